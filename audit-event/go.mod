@@ -8,4 +8,5 @@ require (
 	github.com/openfaas/openfaas-cloud v0.0.0-20200303103051-6c3e056a6ac4
 	github.com/sirupsen/logrus v1.7.0
 	golang.org/x/sys v0.0.0-20201109165425-215b40eba54c
+	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
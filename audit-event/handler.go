@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 
 	"github.com/openfaas/openfaas-cloud/sdk"
@@ -35,7 +34,7 @@ func Handle(req []byte) string {
 			log.Panic(encapsulateErr)
 		}
 
-		res, err := http.Post(slackURL, "application/json", reader)
+		res, err := sdk.HTTPClient().Post(slackURL, "application/json", reader)
 
 		if err != nil {
 			log.Println(err)
@@ -55,6 +54,11 @@ func encapsulateSlackReq(event sdk.AuditEvent) (io.Reader, error) {
 			event.Repo,
 			event.Message)}
 
+	// The correlation ID finds the other events of the same pipeline
+	if len(event.CorrelationID) > 0 {
+		msg.Text = fmt.Sprintf("%s (sha: %s, correlation: %s)", msg.Text, sdk.FormatShortSHA(event.SHA), event.CorrelationID)
+	}
+
 	bytesOut, marshalErr := json.Marshal(msg)
 	if marshalErr != nil {
 		return nil, marshalErr
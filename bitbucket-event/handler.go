@@ -0,0 +1,279 @@
+package function
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+const (
+	// Source name for this function when auditing
+	Source = "bitbucket-event"
+	// SCM is set on each sdk.PushEvent so that git-tar and buildshiprun
+	// clone and report statuses through Bitbucket
+	SCM = "bitbucket"
+	// PushEvent is the X-Event-Key of a push to a repository
+	PushEvent = "repo:push"
+)
+
+var audit sdk.Audit
+
+// Handle accepts repo:push webhooks from Bitbucket Cloud and sends an
+// sdk.PushEvent to git-tar for each branch which was updated, the same
+// pipeline which builds pushes to GitHub and GitLab. The build statuses
+// are reported on the commit by bitbucket-status.
+// The response is an sdk.Response as JSON.
+func Handle(req []byte) string {
+	return handle(req).JSON()
+}
+
+func handle(req []byte) sdk.Response {
+	if audit == nil {
+		audit = sdk.AuditLogger{}
+	}
+
+	eventKey := os.Getenv("Http_X_Event_Key")
+	if eventKey != PushEvent {
+		return sdk.Skipped(fmt.Sprintf("%s cannot handle event: %s", Source, eventKey))
+	}
+
+	if sdk.HmacEnabled() {
+		webhookSecret, secretErr := sdk.ReadSecret("bitbucket-webhook-secret")
+		if secretErr != nil {
+			return sdk.Failed(http.StatusInternalServerError, secretErr.Error())
+		}
+
+		header := http.Header{}
+		header.Set(sdk.GitHubSignatureHeader, os.Getenv("Http_X_Hub_Signature"))
+		scm, _ := sdk.GetSCM(sdk.SCMBitbucket)
+		if err := scm.ValidateWebhook(req, header, webhookSecret); err != nil {
+			msg := fmt.Sprintf("%s: unable to validate webhook: %s", Source, err.Error())
+			audit.Post(sdk.AuditEvent{Message: msg, Source: Source})
+			return sdk.Rejected(http.StatusUnauthorized, msg)
+		}
+	}
+
+	event := sdk.BitbucketPushEvent{}
+	if err := json.Unmarshal(req, &event); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("error while un-marshaling event: %s", err.Error()))
+	}
+
+	owner := ownerOf(event.Repository)
+	if len(owner) == 0 {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("%s: no workspace in event", Source))
+	}
+
+	customers := sdk.NewCustomers(os.Getenv("customers_path"), os.Getenv("customers_url"))
+	customers.Fetch()
+
+	customer, found := customers.Lookup(owner)
+	if sdk.ValidateCustomers() && !found {
+		audit.Post(sdk.AuditEvent{Message: "Customer not found", Owner: owner, Source: Source})
+		return sdk.Rejected(http.StatusForbidden, fmt.Sprintf("Customer: %q not found in customers ACL", owner))
+	}
+
+	messages := []string{}
+	forwarded := 0
+	for _, change := range event.Push.Changes {
+		pushEvent, ok := pushEventFromChange(event, change)
+		if !ok {
+			continue
+		}
+		pushEvent.Customer = customer
+
+		msg, invoked, err := forward(pushEvent)
+		if err != nil {
+			return sdk.Failed(http.StatusBadGateway, err.Error())
+		}
+		if invoked {
+			forwarded++
+		}
+		messages = append(messages, msg)
+	}
+
+	if len(messages) == 0 {
+		return sdk.Skipped(fmt.Sprintf("%s: no branches updated by push to %s", Source, event.Repository.FullName))
+	}
+	if forwarded == 0 {
+		return sdk.Skipped(strings.Join(messages, ", "))
+	}
+
+	return sdk.Accepted(strings.Join(messages, ", "))
+}
+
+// ownerOf gives the workspace of a repository, which the owner's
+// functions are named after
+func ownerOf(repository sdk.BitbucketRepository) string {
+	if len(repository.Workspace.Slug) > 0 {
+		return strings.ToLower(repository.Workspace.Slug)
+	}
+	if index := strings.Index(repository.FullName, "/"); index > 0 {
+		return strings.ToLower(repository.FullName[:index])
+	}
+	return ""
+}
+
+// pushEventFromChange gives the push of one branch, a deleted branch or
+// a tag has nothing to build. The repository's slug from FullName is
+// used for its name, since the display name may contain spaces.
+func pushEventFromChange(event sdk.BitbucketPushEvent, change sdk.BitbucketChange) (sdk.PushEvent, bool) {
+	if change.New == nil || change.New.Type != "branch" || len(change.New.Target.Hash) == 0 {
+		return sdk.PushEvent{}, false
+	}
+
+	repository := event.Repository
+	slug := repository.FullName[strings.Index(repository.FullName, "/")+1:]
+
+	before := ""
+	if change.Old != nil {
+		before = change.Old.Target.Hash
+	}
+
+	return sdk.PushEvent{
+		SCM: SCM,
+		Ref: "refs/heads/" + change.New.Name,
+		Repository: sdk.PushEventRepository{
+			Name:          strings.ToLower(slug),
+			FullName:      repository.FullName,
+			CloneURL:      fmt.Sprintf("https://bitbucket.org/%s.git", repository.FullName),
+			Private:       repository.IsPrivate,
+			RepositoryURL: repository.Links.HTML.Href,
+			Owner: sdk.Owner{
+				Login: ownerOf(repository),
+			},
+		},
+		AfterCommitID:  change.New.Target.Hash,
+		BeforeCommitID: before,
+		Forced:         change.Forced,
+		HeadCommit: &sdk.PushEventCommit{
+			ID:      change.New.Target.Hash,
+			Message: change.New.Target.Message,
+		},
+		Sender: sdk.Sender{
+			Login: event.Actor.Nickname,
+		},
+	}, true
+}
+
+// forward checks the branch of a push is built then sends it to git-tar,
+// it is true when git-tar was invoked
+func forward(pushEvent sdk.PushEvent) (string, bool, error) {
+	status := sdk.BuildStatus(sdk.BuildEventFromPushEvent(pushEvent), sdk.EmptyAuthToken)
+
+	if branchErr := checkBranch(pushEvent.Ref); branchErr != nil {
+		audit.Post(sdk.AuditEvent{
+			Message: branchErr.Error(),
+			Owner:   pushEvent.Repository.Owner.Login,
+			Repo:    pushEvent.Repository.Name,
+			Source:  Source,
+		})
+		return branchErr.Error(), false, nil
+	}
+
+	if sdk.SkipCI(pushEvent.HeadCommit) {
+		msg := fmt.Sprintf("skipping build for: %s, [skip ci] in commit message", sdk.FormatShortSHA(pushEvent.AfterCommitID))
+		status.AddStatus(sdk.StatusSuccess, msg, sdk.StackContext)
+		reportStatus(status)
+		return msg, false, nil
+	}
+
+	serviceValue := fmt.Sprintf("%s-%s", pushEvent.Repository.Owner.Login, pushEvent.Repository.Name)
+	status.AddStatus(sdk.StatusPending, fmt.Sprintf("%s stack deploy is in progress", serviceValue), sdk.StackContext)
+	reportStatus(status)
+
+	statusCode, err := postEvent(pushEvent)
+	if err != nil {
+		status.AddStatus(sdk.StatusFailure, err.Error(), sdk.StackContext)
+		reportStatus(status)
+		return "", false, fmt.Errorf("error while posting event to git-tar: %s", err.Error())
+	}
+
+	audit.Post(sdk.AuditEvent{
+		Message: "Git-tar invoked",
+		Owner:   pushEvent.Repository.Owner.Login,
+		Repo:    pushEvent.Repository.Name,
+		Source:  Source,
+	})
+
+	return fmt.Sprintf("%s at %s, git-tar status: %d", serviceValue, sdk.FormatShortSHA(pushEvent.AfterCommitID), statusCode), true, nil
+}
+
+// checkBranch allows the build branches and the staging branch, any
+// branch is passed on when repo_branches is set so that git-tar can
+// check it against the repository's .ofc.yml
+func checkBranch(ref string) error {
+	branch := sdk.BranchFromRef(ref)
+
+	stagingBranch := strings.TrimSpace(os.Getenv("staging_branch"))
+	if sdk.RepoBranchesEnabled() ||
+		sdk.MatchBranch(branch, sdk.BuildBranches()) ||
+		(len(stagingBranch) > 0 && stagingBranch == branch) {
+		return nil
+	}
+
+	buildBranches := sdk.BuildBranches()
+	if len(buildBranches) == 1 {
+		return fmt.Errorf("skipping build for: %s branch, the build branch is: %s", branch, buildBranches[0])
+	}
+	return fmt.Errorf("skipping build for: %s branch, the build branches are: %s", branch, strings.Join(buildBranches, ", "))
+}
+
+func postEvent(pushEvent sdk.PushEvent) (int, error) {
+	body, err := json.Marshal(pushEvent)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("error while marshalling event: %s", err.Error())
+	}
+
+	return invokeWithHMAC("async-function/git-tar", body)
+}
+
+// reportStatus sends the status to bitbucket-status, failures are only
+// logged so that they do not stop the build
+func reportStatus(status *sdk.Status) {
+	body, err := json.Marshal(status)
+	if err != nil {
+		log.Printf("error while marshalling status: %s", err.Error())
+		return
+	}
+
+	if _, err := invokeWithHMAC("function/bitbucket-status", body); err != nil {
+		log.Printf("error while reporting status to bitbucket-status: %s", err.Error())
+	}
+}
+
+func invokeWithHMAC(path string, body []byte) (int, error) {
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	gatewayURL := sdk.CreateServiceURL(os.Getenv("gateway_url"), os.Getenv("dns_suffix"))
+
+	req, err := http.NewRequest(http.MethodPost, gatewayURL+path, bytes.NewReader(body))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	digest := hmac.Sign(body, []byte(payloadSecret))
+	req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+		return res.StatusCode, fmt.Errorf("unexpected status code from %s: %d", path, res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
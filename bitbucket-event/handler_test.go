@@ -0,0 +1,181 @@
+package function
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
+)
+
+func setupGateway(t *testing.T) (*sdktest.FakeGateway, func()) {
+	gateway := sdktest.NewFakeGateway()
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{
+		"payload-secret":           "secret",
+		"bitbucket-webhook-secret": "webhook-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audit = &sdktest.FakeAudit{}
+
+	os.Setenv("gateway_url", gateway.URL)
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Event_Key", "repo:push")
+	os.Setenv("validate_customers", "false")
+
+	return gateway, func() {
+		gateway.Close()
+		os.RemoveAll(secrets)
+		os.Unsetenv("gateway_url")
+		os.Unsetenv("secret_mount_path")
+		os.Unsetenv("Http_X_Event_Key")
+		os.Unsetenv("Http_X_Hub_Signature")
+		os.Unsetenv("validate_customers")
+	}
+}
+
+func signSHA256(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Test_Handle_Push_InvokesGitTar(t *testing.T) {
+	gateway, teardown := setupGateway(t)
+	defer teardown()
+
+	body := sdktest.Payload(sdktest.BitbucketPush("alexellis", "fn1", "master", "af6db"))
+	os.Setenv("Http_X_Hub_Signature", signSHA256(body, "webhook-secret"))
+
+	res := handle(body)
+
+	if res.Status != sdk.ResponseAccepted || !strings.Contains(res.Reason, "git-tar status: 202") {
+		t.Errorf("want git-tar invoked, got: %+v", res)
+	}
+
+	invocations := gateway.Invocations("git-tar")
+	if len(invocations) != 1 || !invocations[0].Async {
+		t.Fatalf("want one async call to git-tar, got %d", len(invocations))
+	}
+	if got := invocations[0].Header.Get(sdk.CloudSignatureHeader); got != sdktest.Sign(invocations[0].Body, "secret") {
+		t.Errorf("want the event signed with the payload-secret, got %q", got)
+	}
+
+	pushEvent := sdk.PushEvent{}
+	json.Unmarshal(invocations[0].Body, &pushEvent)
+	if pushEvent.SCM != "bitbucket" || pushEvent.Ref != "refs/heads/master" || pushEvent.AfterCommitID != "af6db" {
+		t.Errorf("want the push to master, got %+v", pushEvent)
+	}
+	if pushEvent.Repository.Owner.Login != "alexellis" || pushEvent.Repository.Name != "fn1" || pushEvent.Repository.CloneURL != "https://bitbucket.org/alexellis/fn1.git" {
+		t.Errorf("want the repository of the push, got %+v", pushEvent.Repository)
+	}
+
+	if len(gateway.Invocations("bitbucket-status")) != 1 {
+		t.Errorf("want the pending status reported to bitbucket-status")
+	}
+}
+
+func Test_Handle_InvalidSignature(t *testing.T) {
+	gateway, teardown := setupGateway(t)
+	defer teardown()
+
+	body := sdktest.Payload(sdktest.BitbucketPush("alexellis", "fn1", "master", "af6db"))
+	os.Setenv("Http_X_Hub_Signature", signSHA256(body, "wrong-secret"))
+
+	res := handle(body)
+
+	if res.Status != sdk.ResponseRejected || res.Code != http.StatusUnauthorized {
+		t.Errorf("want the push rejected, got %+v", res)
+	}
+	if len(gateway.Invocations("git-tar")) != 0 {
+		t.Errorf("want git-tar not to be invoked")
+	}
+}
+
+func Test_Handle_OtherEvent(t *testing.T) {
+	_, teardown := setupGateway(t)
+	defer teardown()
+
+	os.Setenv("Http_X_Event_Key", "pullrequest:created")
+
+	if res := handle([]byte(`{}`)); res.Status != sdk.ResponseSkipped {
+		t.Errorf("want the event skipped, got %+v", res)
+	}
+}
+
+func Test_Handle_SkipsOtherBranches(t *testing.T) {
+	gateway, teardown := setupGateway(t)
+	defer teardown()
+
+	body := sdktest.Payload(sdktest.BitbucketPush("alexellis", "fn1", "feature", "af6db"))
+	os.Setenv("Http_X_Hub_Signature", signSHA256(body, "webhook-secret"))
+
+	res := handle(body)
+
+	if res.Status != sdk.ResponseSkipped || !strings.Contains(res.Reason, "skipping build for: feature branch") {
+		t.Errorf("want the push skipped, got %+v", res)
+	}
+	if len(gateway.Invocations("git-tar")) != 0 {
+		t.Errorf("want git-tar not to be invoked")
+	}
+}
+
+func Test_pushEventFromChange(t *testing.T) {
+	event := sdktest.BitbucketPush("OpenFaaS", "fn1", "master", "af6db")
+	event.Repository.Name = "Function One"
+	event.Repository.FullName = "openfaas/function-one"
+	event.Repository.IsPrivate = true
+
+	pushEvent, ok := pushEventFromChange(event, event.Push.Changes[0])
+	if !ok {
+		t.Fatalf("want a push for the branch")
+	}
+	if pushEvent.Repository.Name != "function-one" || pushEvent.Repository.Owner.Login != "openfaas" || !pushEvent.Repository.Private {
+		t.Errorf("want the slug and workspace of the repository, got %+v", pushEvent.Repository)
+	}
+	if pushEvent.HeadCommit == nil || pushEvent.HeadCommit.Message != "Update stack.yml" {
+		t.Errorf("want the head commit, got %+v", pushEvent.HeadCommit)
+	}
+	if len(pushEvent.BeforeCommitID) > 0 {
+		t.Errorf("want no previous commit for a new branch, got %s", pushEvent.BeforeCommitID)
+	}
+
+	updated := event.Push.Changes[0]
+	updated.Old = &sdk.BitbucketRef{Type: "branch", Name: "master"}
+	updated.Old.Target.Hash = "9e6d8"
+	if pushEvent, _ := pushEventFromChange(event, updated); pushEvent.BeforeCommitID != "9e6d8" {
+		t.Errorf("want the previous head of the branch, got %q", pushEvent.BeforeCommitID)
+	}
+
+	deleted := sdk.BitbucketChange{}
+	if _, ok := pushEventFromChange(event, deleted); ok {
+		t.Errorf("want no push for a deleted branch")
+	}
+
+	tag := sdk.BitbucketChange{New: &sdk.BitbucketRef{Type: "tag", Name: "0.1.0"}}
+	tag.New.Target.Hash = "af6db"
+	if _, ok := pushEventFromChange(event, tag); ok {
+		t.Errorf("want no push for a tag")
+	}
+}
+
+func Test_checkBranch(t *testing.T) {
+	os.Setenv("build_branch", "main")
+	defer os.Unsetenv("build_branch")
+
+	if err := checkBranch("refs/heads/main"); err != nil {
+		t.Errorf("want main built, got %s", err)
+	}
+	if err := checkBranch("refs/heads/feature"); err == nil || err.Error() != "skipping build for: feature branch, the build branch is: main" {
+		t.Errorf("want feature skipped, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// maxKeyLength is the longest key Bitbucket accepts for a build status
+const maxKeyLength = 40
+
+// buildStatus is a build status of a commit in the Bitbucket 2.0 API,
+// the key identifies the status so that it is updated in place
+type buildStatus struct {
+	State       string `json:"state"`
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Description string `json:"description"`
+}
+
+// Handle reports the building process of the function and the function
+// stack to Bitbucket by sending build statuses on pending, success and
+// failure. The API is called with an access token for the platform's
+// OAuth consumer.
+// The response is an sdk.Response as JSON.
+func Handle(req []byte) string {
+	return handle(req).JSON()
+}
+
+func handle(req []byte) sdk.Response {
+	if err := sdk.ValidHMAC(&req, "payload-secret", os.Getenv("Http_X_Cloud_Signature")); err != nil {
+		return sdk.Rejected(http.StatusUnauthorized, fmt.Sprintf("invalid HMAC digest for status: %s", err.Error()))
+	}
+
+	status, err := sdk.UnmarshalStatus(req)
+	if err != nil {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("error while un-marshaling status from request: %s", err.Error()))
+	}
+
+	// Published for the dashboard as github-status does
+	sdk.PublishPipelineEvents(status)
+
+	token, err := sdk.BitbucketAccessToken()
+	if err != nil {
+		return sdk.Failed(http.StatusBadGateway, err.Error())
+	}
+
+	event := status.EventInfo
+	for _, commitStatus := range status.CommitStatuses {
+		report := buildStatus{
+			State:       bitbucketState(commitStatus.Status),
+			Key:         statusKey(commitStatus.Context),
+			Name:        sdk.ContextName(commitStatus.Context),
+			URL:         statusURL(&event),
+			Description: commitStatus.Description,
+		}
+
+		if err := sendReport(event.Owner, event.Repository, event.SHA, token, report); err != nil {
+			log.Printf("failed to report status %s for %s/%s: %s", report.Key, event.Owner, event.Repository, err.Error())
+			return sdk.Failed(http.StatusBadGateway, err.Error())
+		}
+	}
+
+	return sdk.OK(fmt.Sprintf("%d statuses reported for %s/%s at %s", len(status.CommitStatuses), event.Owner, event.Repository, sdk.FormatShortSHA(event.SHA)))
+}
+
+// bitbucketState maps the states of an sdk.CommitStatus to those of a
+// Bitbucket build status
+func bitbucketState(state string) string {
+	switch state {
+	case sdk.StatusSuccess:
+		return "SUCCESSFUL"
+	case sdk.StatusPending:
+		return "INPROGRESS"
+	default:
+		return "FAILED"
+	}
+}
+
+// statusKey is the context of a status, shortened to the length allowed
+// by Bitbucket
+func statusKey(context string) string {
+	if len(context) > maxKeyLength {
+		return context[:maxKeyLength]
+	}
+	return context
+}
+
+// statusURL links a build status to the dashboard, or to the repository
+// when gateway_public_url is not set since Bitbucket requires a URL
+func statusURL(event *sdk.Event) string {
+	if publicURL := os.Getenv("gateway_public_url"); len(publicURL) > 0 {
+		return strings.TrimSuffix(publicURL, "/") + "/function/system-dashboard"
+	}
+	return strings.TrimSuffix(event.URL, ".git")
+}
+
+func bitbucketAPIURL() string {
+	if val, ok := os.LookupEnv("bitbucket_api_url"); ok && len(val) > 0 {
+		return strings.TrimSuffix(val, "/") + "/"
+	}
+	return "https://api.bitbucket.org/"
+}
+
+func sendReport(owner, repository, sha, token string, report buildStatus) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s2.0/repositories/%s/%s/commit/%s/statuses/build", bitbucketAPIURL(), owner, repository, sha)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error while creating request to Bitbucket API: %s", err.Error())
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error while sending request to Bitbucket API: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status code from Bitbucket API: %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package function
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
+)
+
+func Test_Handle_ReportsBuildStatuses(t *testing.T) {
+	scm := sdktest.NewFakeSCM()
+	defer scm.Close()
+
+	oauth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token": "bitbucket-access-token"}`))
+	}))
+	defer oauth.Close()
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{
+		"payload-secret":            "secret",
+		"bitbucket-consumer-key":    "consumer-key",
+		"bitbucket-consumer-secret": "consumer-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("bitbucket_api_url", scm.URL)
+	os.Setenv("bitbucket_oauth_url", oauth.URL)
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("bitbucket_api_url")
+	defer os.Unsetenv("bitbucket_oauth_url")
+
+	status := sdk.BuildStatus(&sdk.Event{
+		Owner:      "alexellis",
+		Repository: "fn1",
+		SHA:        "af6db",
+		URL:        "https://bitbucket.org/alexellis/fn1.git",
+	}, sdk.EmptyAuthToken)
+	status.AddStatus(sdk.StatusPending, "alexellis-fn1 stack deploy is in progress", sdk.StackContext)
+
+	body := sdktest.Payload(status)
+	os.Setenv("Http_X_Cloud_Signature", sdktest.Sign(body, "secret"))
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	res := handle(body)
+	if res.Status != sdk.ResponseOK {
+		t.Fatalf("want the status reported, got %+v", res)
+	}
+
+	statuses := scm.Statuses()
+	if len(statuses) != 1 {
+		t.Fatalf("want 1 status, got %v", statuses)
+	}
+	want := sdktest.CommitStatus{
+		Repo:        "alexellis/fn1",
+		SHA:         "af6db",
+		State:       "INPROGRESS",
+		Context:     sdk.StackContext,
+		Description: "alexellis-fn1 stack deploy is in progress",
+		TargetURL:   "https://bitbucket.org/alexellis/fn1",
+	}
+	if statuses[0] != want {
+		t.Errorf("want %+v, got %+v", want, statuses[0])
+	}
+}
+
+func Test_Handle_InvalidSignature(t *testing.T) {
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Cloud_Signature", "sha1=invalid")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	if res := handle([]byte(`{}`)); res.Status != sdk.ResponseRejected || res.Code != http.StatusUnauthorized {
+		t.Errorf("want the status rejected, got %+v", res)
+	}
+}
+
+func Test_bitbucketState(t *testing.T) {
+	tests := map[string]string{
+		sdk.StatusPending: "INPROGRESS",
+		sdk.StatusSuccess: "SUCCESSFUL",
+		sdk.StatusFailure: "FAILED",
+	}
+	for state, want := range tests {
+		if got := bitbucketState(state); got != want {
+			t.Errorf("want %s for %s, got %s", want, state, got)
+		}
+	}
+}
+
+func Test_statusKey(t *testing.T) {
+	context := strings.Repeat("a", 50)
+	if got := statusKey(context); len(got) != maxKeyLength {
+		t.Errorf("want the key shortened to %d characters, got %d", maxKeyLength, len(got))
+	}
+	if got := statusKey("fn1"); got != "fn1" {
+		t.Errorf("want fn1, got %s", got)
+	}
+}
+
+func Test_statusURL(t *testing.T) {
+	event := &sdk.Event{URL: "https://bitbucket.org/alexellis/fn1.git"}
+	if got := statusURL(event); got != "https://bitbucket.org/alexellis/fn1" {
+		t.Errorf("want the repository, got %s", got)
+	}
+
+	os.Setenv("gateway_public_url", "https://system.o6s.io/")
+	defer os.Unsetenv("gateway_public_url")
+
+	if got := statusURL(event); got != "https://system.o6s.io/function/system-dashboard" {
+		t.Errorf("want the dashboard, got %s", got)
+	}
+}
@@ -0,0 +1,77 @@
+package function
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// apmConfig is the env-vars the operator injects into every function
+// so that it reports to the platform's APM, i.e. an OTLP endpoint
+type apmConfig struct {
+	// Env are the env-vars by name, the values are templates
+	Env map[string]string
+	// OptOut are the owners whose functions are not given the env-vars
+	OptOut []string
+}
+
+// getAPMConfig reads apm_env, pairs of name=template such as
+// OTEL_EXPORTER_OTLP_ENDPOINT=http://otel-collector:4317,OTEL_SERVICE_NAME={owner}-{function},
+// and apm_opt_out, a list of owners
+func getAPMConfig() apmConfig {
+	cfg := apmConfig{
+		Env:    map[string]string{},
+		OptOut: splitList(os.Getenv("apm_opt_out")),
+	}
+
+	for _, pair := range splitList(os.Getenv("apm_env")) {
+		index := strings.Index(pair, "=")
+		if index < 1 {
+			log.Printf("Ignoring invalid apm_env entry: %s", pair)
+			continue
+		}
+
+		name := strings.TrimSpace(pair[:index])
+		if !envNameValidator.MatchString(name) {
+			log.Printf("Ignoring invalid apm_env name: %s", name)
+			continue
+		}
+		cfg.Env[name] = strings.TrimSpace(pair[index+1:])
+	}
+	return cfg
+}
+
+// injectAPMEnv adds the APM env-vars to the function, filling in
+// {owner}, {repo}, {function}, {service} and {sha}, where {service} is
+// the function's name on the gateway. An
+// env-var already set in stack.yml is kept, so that a user can point
+// their function elsewhere.
+func injectAPMEnv(event *sdk.Event, serviceValue string, cfg apmConfig) int {
+	if len(cfg.Env) == 0 || contains(cfg.OptOut, event.Owner) {
+		return 0
+	}
+
+	if event.Environment == nil {
+		event.Environment = map[string]string{}
+	}
+
+	replacer := strings.NewReplacer(
+		"{owner}", strings.ToLower(event.Owner),
+		"{repo}", event.Repository,
+		"{function}", event.Service,
+		"{service}", serviceValue,
+		"{sha}", event.SHA,
+	)
+
+	injected := 0
+	for name, value := range cfg.Env {
+		if _, exists := event.Environment[name]; exists {
+			continue
+		}
+		event.Environment[name] = replacer.Replace(value)
+		injected++
+	}
+	return injected
+}
@@ -0,0 +1,73 @@
+package function
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_getAPMConfig(t *testing.T) {
+	os.Setenv("apm_env", "OTEL_EXPORTER_OTLP_ENDPOINT=http://otel-collector:4317, OTEL_SERVICE_NAME={owner}-{function},invalid,1BAD=x")
+	os.Setenv("apm_opt_out", "openfaas")
+	defer os.Unsetenv("apm_env")
+	defer os.Unsetenv("apm_opt_out")
+
+	cfg := getAPMConfig()
+
+	want := map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://otel-collector:4317",
+		"OTEL_SERVICE_NAME":           "{owner}-{function}",
+	}
+	if !reflect.DeepEqual(cfg.Env, want) {
+		t.Errorf("want %v, got %v", want, cfg.Env)
+	}
+	if !reflect.DeepEqual(cfg.OptOut, []string{"openfaas"}) {
+		t.Errorf("want openfaas opted out, got %v", cfg.OptOut)
+	}
+}
+
+func Test_injectAPMEnv(t *testing.T) {
+	cfg := apmConfig{
+		Env: map[string]string{
+			"OTEL_EXPORTER_OTLP_ENDPOINT": "http://otel-collector:4317",
+			"OTEL_SERVICE_NAME":           "{owner}-{function}",
+			"OTEL_RESOURCE_ATTRIBUTES":    "service.version={sha}",
+			"DD_SERVICE":                  "{service}",
+		},
+	}
+
+	event := &sdk.Event{
+		Owner:       "AlexEllis",
+		Service:     "fn1",
+		SHA:         "af6db",
+		Environment: map[string]string{"DD_SERVICE": "custom"},
+	}
+
+	if injected := injectAPMEnv(event, "alexellis-fn1", cfg); injected != 3 {
+		t.Errorf("want 3 env-vars injected, got %d", injected)
+	}
+
+	want := map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://otel-collector:4317",
+		"OTEL_SERVICE_NAME":           "alexellis-fn1",
+		"OTEL_RESOURCE_ATTRIBUTES":    "service.version=af6db",
+		"DD_SERVICE":                  "custom",
+	}
+	if !reflect.DeepEqual(event.Environment, want) {
+		t.Errorf("want %v, got %v", want, event.Environment)
+	}
+}
+
+func Test_injectAPMEnv_OptOut(t *testing.T) {
+	cfg := apmConfig{
+		Env:    map[string]string{"OTEL_SERVICE_NAME": "{owner}-{function}"},
+		OptOut: []string{"alexellis"},
+	}
+
+	event := &sdk.Event{Owner: "alexellis", Service: "fn1"}
+	if injected := injectAPMEnv(event, "alexellis-fn1", cfg); injected != 0 || len(event.Environment) != 0 {
+		t.Errorf("want no env-vars for an owner who opted out, got %v", event.Environment)
+	}
+}
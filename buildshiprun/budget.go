@@ -0,0 +1,189 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// failureBudgetKey is the commitSHA the record of a repository is kept
+// under in pipeline-log
+const failureBudgetKey = "failure-budget"
+
+// resumeCommand is commented on a commit or pull request to resume a
+// paused pipeline, see github-event
+const resumeCommand = "/ofc resume"
+
+// failureRecord counts the consecutive failed builds of a repository,
+// each commit is counted once however many of its functions failed
+type failureRecord struct {
+	Failures int       `json:"failures"`
+	LastSHA  string    `json:"lastSHA,omitempty"`
+	Paused   bool      `json:"paused"`
+	PausedAt time.Time `json:"pausedAt,omitempty"`
+}
+
+// getFailureBudget reads failure_budget, the consecutive failed builds
+// after which a repository's pipeline is paused. It is disabled unless
+// set.
+func getFailureBudget() int {
+	if val, err := strconv.Atoi(os.Getenv("failure_budget")); err == nil && val > 0 {
+		return val
+	}
+	return 0
+}
+
+// failed counts a failed build of sha, ok is true when it used up the
+// budget and the pipeline was paused
+func (r failureRecord) failed(sha string, budget int, now time.Time) (failureRecord, bool) {
+	if r.Paused || sha == r.LastSHA {
+		return r, false
+	}
+
+	r.Failures++
+	r.LastSHA = sha
+	if r.Failures >= budget {
+		r.Paused = true
+		r.PausedAt = now
+		return r, true
+	}
+	return r, false
+}
+
+// succeeded resets the count, unless another function of the last
+// failed commit is the one which was built
+func (r failureRecord) succeeded(sha string) failureRecord {
+	if r.Paused || sha == r.LastSHA {
+		return r
+	}
+	return failureRecord{}
+}
+
+func readFailureRecord(gatewayURL string, repoPath string) (*failureRecord, error) {
+	body, err := sdk.ReadPipelineLog(gatewayURL, sdk.PipelineLog{
+		RepoPath:  repoPath,
+		CommitSHA: failureBudgetKey,
+		Function:  "buildshiprun",
+		Source:    sdk.FailureBudgetSource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	record := failureRecord{}
+	json.Unmarshal(body, &record)
+	return &record, nil
+}
+
+func writeFailureRecord(record failureRecord, gatewayURL string, repoPath string, payloadSecret string) error {
+	recordBytes, _ := json.Marshal(record)
+
+	return sdk.WritePipelineLog(gatewayURL, payloadSecret, sdk.PipelineLog{
+		RepoPath:  repoPath,
+		CommitSHA: failureBudgetKey,
+		Function:  "buildshiprun",
+		Source:    sdk.FailureBudgetSource,
+		Data:      string(recordBytes),
+	})
+}
+
+// pipelinePaused gives the record of a repository when its pipeline is
+// paused. Builds go ahead when the record can't be read.
+func pipelinePaused(event *sdk.Event, gatewayURL string) (*failureRecord, bool) {
+	record, err := readFailureRecord(gatewayURL, event.Owner+"/"+event.Repository)
+	if err != nil {
+		log.Printf("failure-budget: unable to read %s/%s: %s", event.Owner, event.Repository, err.Error())
+		return nil, false
+	}
+	return record, record.Paused
+}
+
+// pausedDescription explains the status of a push to a paused pipeline
+func pausedDescription(record *failureRecord) string {
+	return fmt.Sprintf("pipeline paused after %d failed builds, comment \"%s\" to resume", record.Failures, resumeCommand)
+}
+
+// recordBuild counts the outcome of a build against the repository's
+// failure budget, pausing its pipeline once the budget is used up
+func recordBuild(event *sdk.Event, succeeded bool, budget int, gatewayURL string, payloadSecret string) {
+	repoPath := event.Owner + "/" + event.Repository
+
+	record, err := readFailureRecord(gatewayURL, repoPath)
+	if err != nil {
+		log.Printf("failure-budget: unable to read %s: %s", repoPath, err.Error())
+		return
+	}
+
+	updated, paused := *record, false
+	if succeeded {
+		updated = record.succeeded(event.SHA)
+	} else {
+		updated, paused = record.failed(event.SHA, budget, clock.Now())
+	}
+
+	if updated == *record {
+		return
+	}
+
+	if err := writeFailureRecord(updated, gatewayURL, repoPath, payloadSecret); err != nil {
+		log.Printf("failure-budget: unable to record %s: %s", repoPath, err.Error())
+		return
+	}
+
+	if paused {
+		sdk.PostAudit(sdk.AuditEvent{
+			Message: fmt.Sprintf("buildshiprun paused the pipeline of %s after %d consecutive failed builds", repoPath, updated.Failures),
+			Owner:   event.Owner,
+			Repo:    event.Repository,
+			Source:  "buildshiprun",
+		}.Trace(event.SHA, event.Delivery))
+	}
+}
+
+// resumePipeline handles ?action=resume-pipeline, the body is a
+// sdk.ResumePipelineRequest signed with the payload-secret, i.e. by
+// github-event for a resume comment
+func resumePipeline(req []byte) sdk.Response {
+	resumeReq := sdk.ResumePipelineRequest{}
+	if err := json.Unmarshal(req, &resumeReq); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("resume-pipeline: unable to parse request: %s", err.Error()))
+	}
+
+	if len(resumeReq.RepoPath) == 0 {
+		return sdk.Rejected(http.StatusBadRequest, "resume-pipeline: repoPath is required")
+	}
+
+	payloadSecret, keyErr := sdk.ReadSecret("payload-secret")
+	if keyErr != nil {
+		return sdk.Failed(http.StatusInternalServerError, fmt.Sprintf("resume-pipeline: failed to load hmac key, error %s", keyErr.Error()))
+	}
+
+	gatewayURL := os.Getenv("gateway_url")
+
+	if err := writeFailureRecord(failureRecord{}, gatewayURL, resumeReq.RepoPath, payloadSecret); err != nil {
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("resume-pipeline: %s", err.Error()))
+	}
+
+	msg := fmt.Sprintf("buildshiprun resumed the pipeline of %s", resumeReq.RepoPath)
+	if len(resumeReq.User) > 0 {
+		msg = fmt.Sprintf("%s for %s", msg, resumeReq.User)
+	}
+
+	auditEvent := sdk.AuditEvent{
+		Message: msg,
+		Source:  "buildshiprun",
+	}
+	if parts := strings.SplitN(resumeReq.RepoPath, "/", 2); len(parts) == 2 {
+		auditEvent.Owner, auditEvent.Repo = parts[0], parts[1]
+	}
+	sdk.PostAudit(auditEvent)
+
+	return sdk.OK(msg)
+}
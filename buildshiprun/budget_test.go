@@ -0,0 +1,119 @@
+package function
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_failureRecord(t *testing.T) {
+	now := time.Now()
+	record := failureRecord{}
+
+	record, paused := record.failed("af6db", 2, now)
+	if paused || record.Failures != 1 {
+		t.Errorf("want one failure, got %+v", record)
+	}
+
+	if record, _ = record.failed("af6db", 2, now); record.Failures != 1 {
+		t.Errorf("want another function of the same commit counted once, got %+v", record)
+	}
+
+	if got := record.succeeded("af6db"); got.Failures != 1 {
+		t.Errorf("want a function of the failed commit to leave the count, got %+v", got)
+	}
+
+	if got := record.succeeded("b3cc1"); got.Failures != 0 {
+		t.Errorf("want a successful commit to reset the count, got %+v", got)
+	}
+
+	if record, paused = record.failed("b3cc1", 2, now); !paused || !record.Paused || !record.PausedAt.Equal(now) {
+		t.Errorf("want the pipeline paused once the budget is used up, got %+v", record)
+	}
+
+	if got := record.succeeded("c4dd2"); !got.Paused {
+		t.Errorf("want the pipeline to stay paused until it is resumed")
+	}
+}
+
+// budgetServer keeps the failure records posted to pipeline-log in
+// memory
+func budgetServer(t *testing.T, records map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/function/pipeline-log" {
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+			p := sdk.PipelineLog{}
+			json.Unmarshal(body, &p)
+			if p.Source != sdk.FailureBudgetSource || p.CommitSHA != failureBudgetKey {
+				t.Errorf("unexpected pipeline log %+v", p)
+			}
+			records[p.RepoPath] = p.Data
+			return
+		}
+
+		w.Write([]byte(records[r.URL.Query().Get("repoPath")]))
+	}))
+}
+
+func Test_recordBuild_PausesPipeline(t *testing.T) {
+	records := map[string]string{}
+	s := budgetServer(t, records)
+	defer s.Close()
+
+	for _, sha := range []string{"af6db", "b3cc1"} {
+		event := &sdk.Event{Owner: "alexellis", Repository: "kubecon-tester", SHA: sha}
+		recordBuild(event, false, 2, s.URL+"/", "secret")
+	}
+
+	record, paused := pipelinePaused(&sdk.Event{Owner: "alexellis", Repository: "kubecon-tester"}, s.URL+"/")
+	if !paused {
+		t.Fatalf("want the pipeline paused, got %v", records)
+	}
+
+	want := "pipeline paused after 2 failed builds, comment \"/ofc resume\" to resume"
+	if got := pausedDescription(record); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func Test_resumePipeline(t *testing.T) {
+	records := map[string]string{
+		"alexellis/kubecon-tester": `{"failures":3,"paused":true}`,
+	}
+	s := budgetServer(t, records)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "payload-secret"), []byte("secret"), 0600)
+
+	os.Setenv("secret_mount_path", dir)
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("gateway_url")
+
+	got := resumePipeline([]byte(`{"repoPath":"alexellis/kubecon-tester","user":"alexellis"}`))
+	if got.Status != sdk.ResponseOK || !strings.HasPrefix(got.Reason, "buildshiprun resumed the pipeline of alexellis/kubecon-tester") {
+		t.Errorf("want the pipeline resumed, got %+v", got)
+	}
+
+	if _, paused := pipelinePaused(&sdk.Event{Owner: "alexellis", Repository: "kubecon-tester"}, s.URL+"/"); paused {
+		t.Errorf("want the pipeline no longer paused, got %v", records)
+	}
+}
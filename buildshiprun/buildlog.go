@@ -0,0 +1,71 @@
+package function
+
+import (
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// maxDescriptionLength is the longest description GitHub accepts for a
+// commit status
+const maxDescriptionLength = 140
+
+// auditLogLines is the number of lines of the build log sent with the
+// audit event for a failed build
+const auditLogLines = 10
+
+// buildLogLines flattens the of-builder's log, where an entry such as
+// "l: 2019-01-01T00:00:00Z npm ERR! ..." may hold several lines of output.
+// Secrets are redacted, since the lines are shown in commit statuses.
+func buildLogLines(result sdk.BuildResult) []string {
+	lines := []string{}
+	for _, entry := range result.Log {
+		if !strings.HasPrefix(entry, "l: ") {
+			continue
+		}
+
+		// Drop the prefix and timestamp
+		parts := strings.SplitN(entry, " ", 3)
+		if len(parts) < 3 {
+			continue
+		}
+
+		for _, line := range strings.Split(parts[2], "\n") {
+			if line = strings.TrimSpace(line); len(line) > 0 {
+				lines = append(lines, sdk.Redact(line))
+			}
+		}
+	}
+	return lines
+}
+
+// buildFailureDescription gives the last line of output from the
+// build, or the builder's status when there was none, so that users
+// can see why a build failed from the commit status.
+func buildFailureDescription(result sdk.BuildResult) string {
+	const prefix = "build failed: "
+
+	reason := result.Status
+	if lines := buildLogLines(result); len(lines) > 0 {
+		reason = lines[len(lines)-1]
+	}
+
+	if len(reason) == 0 {
+		return "Unable to build image, check builder logs"
+	}
+
+	if max := maxDescriptionLength - len(prefix); len(reason) > max {
+		reason = "..." + reason[len(reason)-max+3:]
+	}
+
+	return prefix + reason
+}
+
+// buildLogTail gives the last lines of output from the build
+func buildLogTail(result sdk.BuildResult, n int) string {
+	lines := buildLogLines(result)
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,57 @@
+package function
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_buildFailureDescription_LastLogLine(t *testing.T) {
+	result := sdk.BuildResult{
+		Status: "failure: exit code 1",
+		Log: []string{
+			"v: 2019-01-01T00:00:00Z [3/4] RUN npm i 1.00s",
+			"l: 2019-01-01T00:00:01Z npm ERR! code E404\nnpm ERR! 404 Not Found: left-pad@99\n",
+			"s: 2019-01-01T00:00:01Z sha256:abc 0",
+		},
+	}
+
+	want := "build failed: npm ERR! 404 Not Found: left-pad@99"
+	if got := buildFailureDescription(result); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func Test_buildFailureDescription_NoLog(t *testing.T) {
+	result := sdk.BuildResult{Status: "unexpected failure: no target reference to push"}
+
+	want := "build failed: unexpected failure: no target reference to push"
+	if got := buildFailureDescription(result); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func Test_buildFailureDescription_Truncated(t *testing.T) {
+	result := sdk.BuildResult{
+		Log: []string{"l: 2019-01-01T00:00:01Z " + strings.Repeat("x", 200) + " the end"},
+	}
+
+	got := buildFailureDescription(result)
+	if len(got) != maxDescriptionLength {
+		t.Errorf("want length %d, got %d", maxDescriptionLength, len(got))
+	}
+	if !strings.HasSuffix(got, "the end") {
+		t.Errorf("want the tail of the line, got %q", got)
+	}
+}
+
+func Test_buildLogTail(t *testing.T) {
+	result := sdk.BuildResult{
+		Log: []string{"l: 2019-01-01T00:00:01Z one\ntwo\nthree"},
+	}
+
+	if got := buildLogTail(result, 2); got != "two\nthree" {
+		t.Errorf("want last 2 lines, got %q", got)
+	}
+}
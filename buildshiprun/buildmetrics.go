@@ -0,0 +1,126 @@
+package function
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// buildMetrics are the timings and size of a build, as reported by the
+// of-builder along with the time buildshiprun waited for it
+type buildMetrics struct {
+	Owner    string
+	Repo     string
+	Function string
+
+	// WaitSeconds is the time taken by the call to the of-builder
+	WaitSeconds  float64
+	BuildSeconds float64
+	PushSeconds  float64
+	ImageSize    int64
+	// PushMBps and RegistryWaitSeconds tell a slow registry apart from
+	// a large image
+	PushMBps            float64
+	RegistryWaitSeconds float64
+}
+
+func newBuildMetrics(event *sdk.Event, result sdk.BuildResult, waitSeconds float64) buildMetrics {
+	return buildMetrics{
+		Owner:        event.Owner,
+		Repo:         event.Repository,
+		Function:     event.Service,
+		WaitSeconds:  waitSeconds,
+		BuildSeconds: result.BuildSeconds,
+		PushSeconds:  result.PushSeconds,
+		ImageSize:    result.ImageSize,
+
+		PushMBps:            result.PushMBps,
+		RegistryWaitSeconds: result.RegistryWaitSeconds,
+	}
+}
+
+// String formats the metrics for the audit event, older builders do
+// not report the build and push times or the size so these are left out
+func (m buildMetrics) String() string {
+	parts := []string{fmt.Sprintf("build: %.2fs", m.WaitSeconds)}
+	if m.PushSeconds > 0 {
+		parts = append(parts, fmt.Sprintf("push: %.2fs", m.PushSeconds))
+	}
+	if m.ImageSize > 0 {
+		parts = append(parts, fmt.Sprintf("image size: %.1fMB", float64(m.ImageSize)/(1024*1024)))
+	}
+	if m.PushMBps > 0 {
+		parts = append(parts, fmt.Sprintf("push throughput: %.1fMB/s, registry wait: %.2fs", m.PushMBps, m.RegistryWaitSeconds))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// exposition renders the metrics in the Prometheus text format
+func (m buildMetrics) exposition(now time.Time) string {
+	var b strings.Builder
+
+	write := func(name, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+	}
+
+	write("of_build_duration_seconds", "Time taken for the of-builder to build and push the image.", m.WaitSeconds)
+	if m.BuildSeconds > 0 {
+		write("of_build_solve_seconds", "Time taken by buildkit to solve the build, including the push.", m.BuildSeconds)
+	}
+	if m.PushSeconds > 0 {
+		write("of_build_push_seconds", "Time taken to push the image to the registry.", m.PushSeconds)
+	}
+	if m.ImageSize > 0 {
+		write("of_build_image_bytes", "Size of the layers pushed for the image.", float64(m.ImageSize))
+	}
+	if m.PushMBps > 0 {
+		write("of_build_push_throughput_mbps", "Upload throughput to the registry in MB/s.", m.PushMBps)
+		write("of_build_registry_wait_seconds", "Time in the push spent waiting on the registry rather than uploading.", m.RegistryWaitSeconds)
+	}
+	write("of_build_last_success_timestamp_seconds", "Time of the last successful build.", float64(now.Unix()))
+
+	return b.String()
+}
+
+// pushBuildMetrics replaces the metrics for the function in the
+// Prometheus Pushgateway at pushgateway_url, grouped by owner, repo and
+// function. It is a no-op when pushgateway_url is not set.
+func pushBuildMetrics(m buildMetrics, now time.Time) error {
+	groupingKey := fmt.Sprintf("/metrics/job/buildshiprun/owner/%s/repo/%s/function/%s",
+		url.PathEscape(m.Owner), url.PathEscape(m.Repo), url.PathEscape(m.Function))
+
+	return pushMetrics(groupingKey, m.exposition(now))
+}
+
+// pushMetrics replaces the metrics of the group in the Pushgateway at
+// pushgateway_url, it is a no-op when pushgateway_url is not set
+func pushMetrics(groupingKey string, exposition string) error {
+	pushgatewayURL := strings.TrimSuffix(os.Getenv("pushgateway_url"), "/")
+	if len(pushgatewayURL) == 0 {
+		return nil
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, pushgatewayURL+groupingKey, bytes.NewBufferString(exposition))
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("unexpected status code from pushgateway: %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package function
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_buildMetrics_String(t *testing.T) {
+	m := buildMetrics{WaitSeconds: 42.5, PushSeconds: 3.25, ImageSize: 15 * 1024 * 1024}
+
+	want := "build: 42.50s, push: 3.25s, image size: 15.0MB"
+	if got := m.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func Test_buildMetrics_String_Throughput(t *testing.T) {
+	m := buildMetrics{WaitSeconds: 42.5, PushSeconds: 3.25, ImageSize: 15 * 1024 * 1024, PushMBps: 7.5, RegistryWaitSeconds: 1.25}
+
+	want := "build: 42.50s, push: 3.25s, image size: 15.0MB, push throughput: 7.5MB/s, registry wait: 1.25s"
+	if got := m.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func Test_buildMetrics_String_OlderBuilder(t *testing.T) {
+	m := newBuildMetrics(&sdk.Event{}, sdk.BuildResult{}, 10)
+
+	want := "build: 10.00s"
+	if got := m.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func Test_pushBuildMetrics_NotConfigured(t *testing.T) {
+	os.Unsetenv("pushgateway_url")
+
+	if err := pushBuildMetrics(buildMetrics{}, time.Now()); err != nil {
+		t.Errorf("want no error, got %s", err.Error())
+	}
+}
+
+func Test_pushBuildMetrics_GroupsByFunction(t *testing.T) {
+	var path, method, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, method = r.URL.Path, r.Method
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	os.Setenv("pushgateway_url", server.URL+"/")
+	defer os.Unsetenv("pushgateway_url")
+
+	event := &sdk.Event{Owner: "alexellis", Repository: "kubecon-tester", Service: "kubecon-tester-fn1"}
+	result := sdk.BuildResult{BuildSeconds: 40, PushSeconds: 5, ImageSize: 2048, PushMBps: 12.5, RegistryWaitSeconds: 1.5}
+	m := newBuildMetrics(event, result, 42)
+
+	if err := pushBuildMetrics(m, time.Unix(1570000000, 0)); err != nil {
+		t.Fatalf("want no error, got %s", err.Error())
+	}
+
+	if method != http.MethodPut {
+		t.Errorf("want PUT to replace the group, got %s", method)
+	}
+
+	wantPath := "/metrics/job/buildshiprun/owner/alexellis/repo/kubecon-tester/function/kubecon-tester-fn1"
+	if path != wantPath {
+		t.Errorf("want path %s, got %s", wantPath, path)
+	}
+
+	for _, want := range []string{
+		"of_build_duration_seconds 42\n",
+		"of_build_solve_seconds 40\n",
+		"of_build_push_seconds 5\n",
+		"of_build_image_bytes 2048\n",
+		"of_build_push_throughput_mbps 12.5\n",
+		"of_build_registry_wait_seconds 1.5\n",
+		"of_build_last_success_timestamp_seconds 1.57e+09\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in body:\n%s", want, body)
+		}
+	}
+}
+
+func Test_pushBuildMetrics_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	os.Setenv("pushgateway_url", server.URL)
+	defer os.Unsetenv("pushgateway_url")
+
+	if err := pushBuildMetrics(buildMetrics{Owner: "alexellis"}, time.Now()); err == nil {
+		t.Errorf("want an error for a bad request")
+	}
+}
@@ -0,0 +1,396 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// canaryLabel lets a user opt a function in or out of canary
+// deployments, overriding the platform default
+const canaryLabel = "com.openfaas.canary"
+
+// canaryWeightLabel is read by the edge-router to send a share of the
+// stable function's traffic to the canary
+const canaryWeightLabel = sdk.FunctionLabelPrefix + "canary-weight"
+
+const canarySuffix = "-canary"
+
+// canaryConfig configures a canary deployment, where the new version
+// is deployed alongside the stable one and receives Weight percent of
+// its traffic for Window before being promoted or aborted
+type canaryConfig struct {
+	Enabled      bool
+	Weight       int
+	Window       time.Duration
+	MaxErrorRate float64
+	// PrometheusURL is queried for the canary's error rate
+	PrometheusURL string
+}
+
+// getCanaryConfig reads the canary, canary_weight, canary_window and
+// canary_max_error_rate env-vars, the canary label on the function
+// takes precedence over the canary env-var.
+func getCanaryConfig(labels map[string]string) canaryConfig {
+	cfg := canaryConfig{
+		Weight:       10,
+		Window:       2 * time.Minute,
+		MaxErrorRate: 0.05,
+		PrometheusURL: fmt.Sprintf("http://%s:%s",
+			getConfig("prometheus_host", "prometheus.openfaas"),
+			getConfig("prometheus_port", "9090")),
+	}
+
+	enabled := os.Getenv("canary")
+	if val, ok := labels[canaryLabel]; ok && len(val) > 0 {
+		enabled = val
+	}
+	cfg.Enabled = enabled == "true" || enabled == "1"
+
+	if val, err := strconv.Atoi(os.Getenv("canary_weight")); err == nil && val > 0 && val <= 100 {
+		cfg.Weight = val
+	}
+
+	if val, err := strconv.ParseFloat(os.Getenv("canary_max_error_rate"), 64); err == nil && val >= 0 {
+		cfg.MaxErrorRate = val
+	}
+
+	cfg.Window = getDuration("canary_window", cfg.Window)
+
+	return cfg
+}
+
+// canarySpec copies the stable spec, renaming the function and adding
+// the weight label
+func canarySpec(stable *faasSDK.DeployFunctionSpec, weight int) *faasSDK.DeployFunctionSpec {
+	spec := *stable
+	spec.FunctionName = stable.FunctionName + canarySuffix
+
+	spec.Labels = map[string]string{}
+	for k, v := range stable.Labels {
+		spec.Labels[k] = v
+	}
+	spec.Labels["faas_function"] = spec.FunctionName
+	spec.Labels["app"] = spec.FunctionName
+	spec.Labels[canaryWeightLabel] = strconv.Itoa(weight)
+
+	return &spec
+}
+
+// canaryRepoPath keeps a canary job in pipeline-log for each stable
+// function, under the function's name
+const canaryRepoPath = "system/canaries"
+
+// canaryJob is recorded when a canary starts to receive traffic, the
+// scheduled check promotes or aborts it once Due has passed
+type canaryJob struct {
+	Event sdk.Event `json:"event"`
+	// Manifest holds the signed spec of the stable function, which is
+	// deployed when the canary is promoted
+	Manifest     sdk.DeploymentManifest `json:"manifest"`
+	Canary       string                 `json:"canary"`
+	Namespace    string                 `json:"namespace"`
+	Window       time.Duration          `json:"window"`
+	MaxErrorRate float64                `json:"maxErrorRate"`
+	Due          time.Time              `json:"due"`
+	Done         bool                   `json:"done,omitempty"`
+}
+
+// startCanary deploys the canary alongside the stable function and
+// verifies it. The canary is removed when it can't be verified, the
+// stable function is left serving.
+func startCanary(ctx context.Context, client *faasSDK.Client, stable *faasSDK.DeployFunctionSpec, gatewayURL string, cfg canaryConfig, check healthCheck) error {
+	spec := canarySpec(stable, cfg.Weight)
+
+	_, _, err := deployFunction(ctx, client, spec, gatewayURL)
+	if err != nil {
+		err = fmt.Errorf("canary deploy failed: %s", err.Error())
+	} else if verifyErr := verifyDeployment(ctx, client, spec.FunctionName, spec.Namespace, spec.Image, gatewayURL, check); verifyErr != nil {
+		err = fmt.Errorf("canary aborted: %s", verifyErr.Error())
+	}
+
+	if err != nil {
+		removeCanary(ctx, client, spec.FunctionName, spec.Namespace)
+		return err
+	}
+
+	log.Printf("Canary %s receiving %d%% of traffic for %s", spec.FunctionName, cfg.Weight, cfg.Window)
+	return nil
+}
+
+func removeCanary(ctx context.Context, client *faasSDK.Client, functionName string, namespace string) {
+	if err := client.DeleteFunction(ctx, functionName, namespace); err != nil {
+		log.Printf("error removing canary %s: %s", functionName, err.Error())
+	}
+}
+
+// canaryKey names the job of the stable function, a function deployed
+// to a deploy target may have the same name as one on gateway_url
+func canaryKey(event *sdk.Event, functionName string) string {
+	if target, ok := getDeployTarget(deployTargetName(event)); ok {
+		return functionName + "@" + target.Name
+	}
+	return functionName
+}
+
+// recordCanary stores the job which promotes or aborts the canary of
+// the stable spec once the window has passed
+func recordCanary(stable *faasSDK.DeployFunctionSpec, event *sdk.Event, cfg canaryConfig, gatewayURL string, payloadSecret string) (*canaryJob, error) {
+	manifest, err := buildManifest(stable, event, payloadSecret, clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	job := &canaryJob{
+		Event:        *event,
+		Manifest:     *manifest,
+		Canary:       stable.FunctionName + canarySuffix,
+		Namespace:    stable.Namespace,
+		Window:       cfg.Window,
+		MaxErrorRate: cfg.MaxErrorRate,
+		Due:          clock.Now().Add(cfg.Window).UTC(),
+	}
+
+	return job, writeCanary(job, gatewayURL, payloadSecret)
+}
+
+func writeCanary(job *canaryJob, gatewayURL string, payloadSecret string) error {
+	jobBytes, _ := json.Marshal(job)
+
+	return sdk.WritePipelineLog(gatewayURL, payloadSecret, sdk.PipelineLog{
+		RepoPath:  canaryRepoPath,
+		CommitSHA: canaryKey(&job.Event, job.Manifest.Function),
+		Function:  "buildshiprun",
+		Source:    sdk.CanarySource,
+		Data:      string(jobBytes),
+	})
+}
+
+func readCanary(gatewayURL string, key string) (*canaryJob, error) {
+	body, err := sdk.ReadPipelineLog(gatewayURL, sdk.PipelineLog{
+		RepoPath:  canaryRepoPath,
+		CommitSHA: key,
+		Function:  "buildshiprun",
+		Source:    sdk.CanarySource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job := canaryJob{}
+	if err := json.Unmarshal(body, &job); err != nil {
+		return nil, fmt.Errorf("unable to read canary %s: %s", key, err.Error())
+	}
+	return &job, nil
+}
+
+// checkCanaries is run by the cron-connector and finishes each canary
+// whose window has passed. A job is marked done before it is finished,
+// so that an overlapping run does not promote it twice.
+func checkCanaries(gatewayURL string, payloadSecret string) sdk.Response {
+	keys, err := sdk.ListPipelineLog(gatewayURL, sdk.PipelineLog{
+		RepoPath: canaryRepoPath,
+		Function: "buildshiprun",
+		Source:   sdk.CanarySource,
+	})
+	if err != nil {
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("canary: unable to list canaries: %s", err.Error()))
+	}
+
+	finished := 0
+	for _, key := range keys {
+		job, err := readCanary(gatewayURL, key)
+		if err != nil {
+			log.Printf("canary: %s", err.Error())
+			continue
+		}
+
+		if job.Done || clock.Now().Before(job.Due) {
+			continue
+		}
+
+		job.Done = true
+		if err := writeCanary(job, gatewayURL, payloadSecret); err != nil {
+			log.Printf("canary: unable to update %s: %s", key, err.Error())
+			continue
+		}
+
+		finishCanary(job, gatewayURL, payloadSecret)
+		finished++
+	}
+
+	return sdk.OK(fmt.Sprintf("canary: %d finished", finished))
+}
+
+// finishCanary compares the canary's error rate over the window to the
+// threshold, then deploys the stable spec from the job's manifest or
+// leaves the previous version serving. The canary is always removed and
+// the outcome is reported as the commit status of the push.
+func finishCanary(job *canaryJob, gatewayURL string, payloadSecret string) {
+	event := &job.Event
+	status := sdk.BuildStatus(event, sdk.EmptyAuthToken)
+	auditEvent := sdk.AuditEvent{
+		Owner:  event.Owner,
+		Repo:   event.Repository,
+		Source: "buildshiprun",
+	}.Trace(event.SHA, event.Delivery)
+
+	gatewayTimeout := getGatewayTimeout()
+	clientAuth, deployGatewayURL := deployGateway(event, gatewayURL)
+	client := faasSDK.NewClient(clientAuth, deployGatewayURL, nil, &gatewayTimeout)
+	ctx := context.Background()
+
+	defer removeCanary(ctx, client, job.Canary, job.Namespace)
+
+	spec, err := promoteCanary(ctx, client, job, deployGatewayURL, gatewayURL, payloadSecret)
+	if err != nil {
+		msg := err.Error()
+		reportFailure(status, auditEvent, http.StatusBadGateway, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
+		return
+	}
+
+	if signature, manifestErr := recordManifest(spec, event, gatewayURL, payloadSecret); manifestErr != nil {
+		log.Printf("deploy-manifest: error: %s", manifestErr.Error())
+	} else {
+		log.Printf("deploy-manifest: %s", signature)
+	}
+
+	auditEvent.Message = fmt.Sprintf("buildshiprun succeeded: promoted canary %s to %s, image: %s", job.Canary, spec.FunctionName, spec.Image)
+	sdk.PostAudit(auditEvent)
+	sdk.PublishDeploymentEvent(deploymentEvent(sdk.FunctionDeployedEvent, *event, spec.Image, auditEvent.Message))
+
+	status.AddStatus(sdk.StatusSuccess, fmt.Sprintf("deployed: %s", spec.FunctionName), functionContext(event))
+	if statusErr := reportStatus(status, event.SCM); statusErr != nil {
+		log.Printf(statusErr.Error())
+	}
+}
+
+// promoteCanary deploys the stable spec once the canary's error rate is
+// within the threshold, a stable deployment which fails verification is
+// rolled back to the previous version.
+func promoteCanary(ctx context.Context, client *faasSDK.Client, job *canaryJob, deployGatewayURL string, gatewayURL string, payloadSecret string) (*faasSDK.DeployFunctionSpec, error) {
+	functionName := job.Canary
+	if len(job.Namespace) > 0 {
+		functionName = functionName + "." + job.Namespace
+	}
+
+	rate, err := errorRate(getCanaryConfig(job.Event.Labels).PrometheusURL, functionName, job.Window)
+	if err != nil {
+		return nil, fmt.Errorf("canary aborted: unable to read error rate: %s", err.Error())
+	}
+
+	if rate > job.MaxErrorRate {
+		return nil, fmt.Errorf("canary aborted: error rate %.2f%% exceeds %.2f%%", rate*100, job.MaxErrorRate*100)
+	}
+
+	if err := job.Manifest.Verify(payloadSecret); err != nil {
+		return nil, fmt.Errorf("canary aborted: %s", err.Error())
+	}
+
+	spec := faasSDK.DeployFunctionSpec{}
+	if err := json.Unmarshal(job.Manifest.Spec, &spec); err != nil {
+		return nil, fmt.Errorf("canary aborted: unable to read manifest for %s: %s", job.Manifest.Function, err.Error())
+	}
+	spec.RegistryAuth = getRegistryAuth(job.Event.Owner)
+
+	log.Printf("Canary %s promoted with error rate %.2f%%", job.Canary, rate*100)
+
+	repoPath := job.Event.Owner + "/" + job.Event.Repository
+	previous := previousDeployment(gatewayURL, repoPath, spec.FunctionName, lastDeployment(ctx, client, spec.FunctionName, spec.Namespace), spec.Image)
+
+	_, attempts, err := deployFunction(ctx, client, &spec, deployGatewayURL)
+	if err == nil {
+		err = verifyDeployment(ctx, client, spec.FunctionName, spec.Namespace, spec.Image, deployGatewayURL, getHealthCheck())
+	}
+
+	if err != nil {
+		msg := fmt.Sprintf("%s, %s", err.Error(), formatAttempts(attempts))
+		if previous != nil {
+			if rollbackErr := rollback(ctx, client, &spec, previous, deployGatewayURL); rollbackErr != nil {
+				log.Printf(rollbackErr.Error())
+			} else {
+				msg = fmt.Sprintf("%s, rolled back to %s", msg, previous.Image)
+			}
+		}
+		return nil, fmt.Errorf("promotion of canary %s failed: %s", job.Canary, msg)
+	}
+
+	deployed := deployRecord{Image: spec.Image, SHA: job.Event.SHA, Branch: job.Event.Branch, Deployed: clock.Now()}
+	if historyErr := recordDeployment(gatewayURL, payloadSecret, repoPath, spec.FunctionName, deployed); historyErr != nil {
+		log.Printf("deploy-history: error: %s", historyErr.Error())
+	}
+
+	return &spec, nil
+}
+
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Result []struct {
+			Value []interface{} `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// errorRate queries Prometheus for the share of invocations of the
+// function which returned a 5xx status over the window. A function
+// which received no traffic has an error rate of zero.
+func errorRate(prometheusURL string, functionName string, window time.Duration) (float64, error) {
+	selector := fmt.Sprintf(`function_name="%s"`, functionName)
+	rangeVal := fmt.Sprintf("%ds", int(window.Seconds()))
+
+	query := fmt.Sprintf(`sum(rate(gateway_function_invocation_total{%s,code=~"5.."}[%s])) / sum(rate(gateway_function_invocation_total{%s}[%s]))`,
+		selector, rangeVal, selector, rangeVal)
+
+	req, _ := http.NewRequest(http.MethodGet, prometheusURL+"/api/v1/query?query="+url.QueryEscape(query), nil)
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code from prometheus: %d", res.StatusCode)
+	}
+
+	result := prometheusResponse{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return 0, err
+	}
+
+	if len(result.Data.Result) == 0 || len(result.Data.Result[0].Value) < 2 {
+		return 0, nil
+	}
+
+	val, ok := result.Data.Result[0].Value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected value from prometheus: %v", result.Data.Result[0].Value[1])
+	}
+
+	rate, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	// NaN is returned when there were no invocations in the window
+	if rate != rate {
+		return 0, nil
+	}
+
+	return rate, nil
+}
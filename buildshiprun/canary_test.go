@@ -0,0 +1,284 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_getCanaryConfig_LabelOverridesDefault(t *testing.T) {
+	os.Setenv("canary", "true")
+	defer os.Unsetenv("canary")
+
+	if !getCanaryConfig(map[string]string{}).Enabled {
+		t.Errorf("want canary enabled by platform default")
+	}
+
+	if getCanaryConfig(map[string]string{canaryLabel: "false"}).Enabled {
+		t.Errorf("want canary disabled by label")
+	}
+}
+
+func Test_getCanaryConfig_Defaults(t *testing.T) {
+	os.Unsetenv("canary")
+	os.Unsetenv("canary_weight")
+
+	cfg := getCanaryConfig(map[string]string{})
+	if cfg.Enabled {
+		t.Errorf("want canary disabled by default")
+	}
+	if cfg.Weight != 10 {
+		t.Errorf("want weight 10, got %d", cfg.Weight)
+	}
+	if cfg.MaxErrorRate != 0.05 {
+		t.Errorf("want max error rate 0.05, got %f", cfg.MaxErrorRate)
+	}
+}
+
+func Test_canarySpec(t *testing.T) {
+	stable := &faasSDK.DeployFunctionSpec{
+		FunctionName: "alexellis-fn1",
+		Labels:       map[string]string{"faas_function": "alexellis-fn1"},
+	}
+
+	spec := canarySpec(stable, 25)
+
+	if spec.FunctionName != "alexellis-fn1-canary" {
+		t.Errorf("want alexellis-fn1-canary, got %s", spec.FunctionName)
+	}
+	if spec.Labels[canaryWeightLabel] != "25" {
+		t.Errorf("want weight label 25, got %s", spec.Labels[canaryWeightLabel])
+	}
+	if _, ok := stable.Labels[canaryWeightLabel]; ok {
+		t.Errorf("want stable labels unchanged")
+	}
+}
+
+func prometheusServer(value string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(value) == 0 {
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1560000000,"%s"]}]}}`, value)
+	}))
+}
+
+func Test_errorRate(t *testing.T) {
+	tests := []struct {
+		Scenario string
+		Value    string
+		Want     float64
+	}{
+		{"errors", "0.25", 0.25},
+		{"no traffic", "NaN", 0},
+		{"no series", "", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			s := prometheusServer(test.Value)
+			defer s.Close()
+
+			rate, err := errorRate(s.URL, "alexellis-fn1-canary", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rate != test.Want {
+				t.Errorf("want %f, got %f", test.Want, rate)
+			}
+		})
+	}
+}
+
+func Test_startCanary_RemovesUnverifiedCanary(t *testing.T) {
+	os.Setenv("deploy_retries", "1")
+	defer os.Unsetenv("deploy_retries")
+
+	deleted := ""
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			body := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&body)
+			deleted = body["functionName"]
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	cfg := canaryConfig{Enabled: true, Weight: 10, Window: time.Minute}
+	stable := &faasSDK.DeployFunctionSpec{FunctionName: "alexellis-fn1"}
+
+	err := startCanary(context.Background(), client, stable, s.URL+"/", cfg, healthCheck{Enabled: false})
+	if err == nil || !strings.Contains(err.Error(), "canary deploy failed") {
+		t.Fatalf("want canary deploy failed, got %v", err)
+	}
+	if deleted != "alexellis-fn1-canary" {
+		t.Errorf("want canary removed, got %q", deleted)
+	}
+}
+
+// canaryServer is a gateway with pipeline-log, which keeps the canary
+// jobs written to it, and Prometheus
+func canaryServer(t *testing.T, errorRate string, jobs map[string]string, deployed *map[string]interface{}, deleted *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/function/pipeline-log" && r.Method == http.MethodPost:
+			p := sdk.PipelineLog{}
+			json.NewDecoder(r.Body).Decode(&p)
+			if p.Source == sdk.CanarySource {
+				jobs[p.CommitSHA] = p.Data
+			}
+		case r.URL.Path == "/function/pipeline-log" && r.URL.Query().Get("list") == "true":
+			keys := []string{}
+			for key := range jobs {
+				keys = append(keys, key)
+			}
+			json.NewEncoder(w).Encode(keys)
+		case r.URL.Path == "/function/pipeline-log":
+			if r.URL.Query().Get("source") == sdk.CanarySource {
+				w.Write([]byte(jobs[r.URL.Query().Get("commitSHA")]))
+			}
+		case r.URL.Path == "/api/v1/query":
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1560000000,"%s"]}]}}`, errorRate)
+		case r.URL.Path == "/system/function/alexellis-fn1":
+			json.NewEncoder(w).Encode(types.FunctionStatus{Name: "alexellis-fn1", AvailableReplicas: 1})
+		case r.URL.Path == "/system/functions" && r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode([]types.FunctionStatus{{Name: "alexellis-fn1"}})
+		case r.URL.Path == "/system/functions" && r.Method == http.MethodDelete:
+			body := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&body)
+			*deleted = body["functionName"]
+		case r.URL.Path == "/system/functions":
+			json.NewDecoder(r.Body).Decode(deployed)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+}
+
+func canaryJobFor(t *testing.T, image string, due time.Time) *canaryJob {
+	spec := &faasSDK.DeployFunctionSpec{
+		FunctionName: "alexellis-fn1",
+		Image:        image,
+		Labels:       map[string]string{"faas_function": "alexellis-fn1"},
+	}
+	event := &sdk.Event{Owner: "alexellis", Repository: "kubecon-tester", SHA: "af6db", Service: "fn1"}
+
+	manifest, err := buildManifest(spec, event, "secret", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &canaryJob{
+		Event:        *event,
+		Manifest:     *manifest,
+		Canary:       "alexellis-fn1-canary",
+		Window:       time.Minute,
+		MaxErrorRate: 0.05,
+		Due:          due,
+	}
+}
+
+func Test_checkCanaries(t *testing.T) {
+	tests := []struct {
+		title       string
+		errorRate   string
+		due         time.Time
+		wantDeploy  bool
+		wantDeleted bool
+	}{
+		{title: "promoted", errorRate: "0.01", due: time.Unix(1570000000, 0), wantDeploy: true, wantDeleted: true},
+		{title: "aborted", errorRate: "0.5", due: time.Unix(1570000000, 0), wantDeleted: true},
+		{title: "within the window", errorRate: "0.01", due: time.Unix(1570000200, 0)},
+	}
+
+	clock = fixedClock(time.Unix(1570000100, 0))
+	defer func() { clock = sdk.SystemClock{} }()
+
+	os.Setenv("health_check", "false")
+	defer os.Unsetenv("health_check")
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			image := "registry:5000/alexellis/fn1:af6db"
+			jobs := map[string]string{}
+			deployed := map[string]interface{}{}
+			deleted := ""
+
+			s := canaryServer(t, test.errorRate, jobs, &deployed, &deleted)
+			defer s.Close()
+
+			host := strings.Split(strings.TrimPrefix(s.URL, "http://"), ":")
+			os.Setenv("prometheus_host", host[0])
+			os.Setenv("prometheus_port", host[1])
+			defer os.Unsetenv("prometheus_host")
+			defer os.Unsetenv("prometheus_port")
+
+			if err := writeCanary(canaryJobFor(t, image, test.due), s.URL+"/", "secret"); err != nil {
+				t.Fatal(err)
+			}
+
+			checkCanaries(s.URL+"/", "secret")
+
+			if got := deployed["image"] == image; got != test.wantDeploy {
+				t.Errorf("want stable deployed: %v, got %v", test.wantDeploy, deployed)
+			}
+			if got := deleted == "alexellis-fn1-canary"; got != test.wantDeleted {
+				t.Errorf("want canary removed: %v, got %q", test.wantDeleted, deleted)
+			}
+
+			job, err := readCanary(s.URL+"/", "alexellis-fn1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.Done != test.wantDeleted {
+				t.Errorf("want job done: %v, got %v", test.wantDeleted, job.Done)
+			}
+		})
+	}
+}
+
+func Test_checkCanaries_TamperedManifest(t *testing.T) {
+	clock = fixedClock(time.Unix(1570000100, 0))
+	defer func() { clock = sdk.SystemClock{} }()
+
+	jobs := map[string]string{}
+	deployed := map[string]interface{}{}
+	deleted := ""
+
+	s := canaryServer(t, "0", jobs, &deployed, &deleted)
+	defer s.Close()
+
+	host := strings.Split(strings.TrimPrefix(s.URL, "http://"), ":")
+	os.Setenv("prometheus_host", host[0])
+	os.Setenv("prometheus_port", host[1])
+	defer os.Unsetenv("prometheus_host")
+	defer os.Unsetenv("prometheus_port")
+
+	job := canaryJobFor(t, "registry:5000/alexellis/fn1:af6db", time.Unix(1570000000, 0))
+	job.Manifest.Spec = json.RawMessage(`{"FunctionName":"alexellis-fn1","Image":"evil/image"}`)
+	if err := writeCanary(job, s.URL+"/", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkCanaries(s.URL+"/", "secret")
+
+	if len(deployed) > 0 {
+		t.Errorf("want nothing deployed, got %v", deployed)
+	}
+	if deleted != "alexellis-fn1-canary" {
+		t.Errorf("want canary removed, got %q", deleted)
+	}
+}
@@ -0,0 +1,73 @@
+package function
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// defaultCheckLogLines is the number of lines of the build log shown in
+// a function's check run
+const defaultCheckLogLines = 30
+
+// checkLogLines reads check_log_lines
+func checkLogLines() int {
+	if val, err := strconv.Atoi(os.Getenv("check_log_lines")); err == nil && val >= 0 {
+		return val
+	}
+	return defaultCheckLogLines
+}
+
+// buildLogExcerpt gives the last n lines of output from the build, with
+// secrets redacted
+func buildLogExcerpt(result sdk.BuildResult, n int) []string {
+	lines := buildLogLines(result)
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// newCheckRunDetail gives the excerpt of the build log and the timings
+// of the build for the function's check run. The of-builder's own build
+// and push times are used when it reports them, otherwise the time
+// buildshiprun waited for it.
+func newCheckRunDetail(result sdk.BuildResult, started time.Time, waitSeconds float64, prebuilt bool) *sdk.CheckRunDetail {
+	detail := &sdk.CheckRunDetail{
+		LogExcerpt: buildLogExcerpt(result, checkLogLines()),
+		Timings:    []sdk.CheckTiming{},
+		Started:    started,
+	}
+
+	if prebuilt {
+		return detail
+	}
+
+	buildSeconds := result.BuildSeconds
+	if buildSeconds <= 0 {
+		buildSeconds = waitSeconds
+	}
+	detail.Timings = append(detail.Timings, sdk.CheckTiming{Phase: "build", Seconds: buildSeconds})
+
+	if result.PushSeconds > 0 {
+		detail.Timings = append(detail.Timings, sdk.CheckTiming{Phase: "push", Seconds: result.PushSeconds})
+	}
+
+	return detail
+}
+
+// addDeployTiming adds the time taken by the attempts to deploy the
+// function to its check run
+func addDeployTiming(detail *sdk.CheckRunDetail, attempts []deployAttempt) {
+	if detail == nil || len(attempts) == 0 {
+		return
+	}
+
+	var total time.Duration
+	for _, attempt := range attempts {
+		total += attempt.Duration
+	}
+	detail.Timings = append(detail.Timings, sdk.CheckTiming{Phase: "deploy", Seconds: total.Seconds()})
+}
@@ -0,0 +1,62 @@
+package function
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_newCheckRunDetail(t *testing.T) {
+	os.Setenv("check_log_lines", "1")
+	defer os.Unsetenv("check_log_lines")
+
+	result := sdk.BuildResult{
+		Log: []string{
+			"l: 2019-01-01T00:00:01Z Step 1/2\nStep 2/2\n",
+		},
+		BuildSeconds: 12.5,
+		PushSeconds:  2,
+	}
+
+	detail := newCheckRunDetail(result, time.Now(), 20, false)
+
+	if len(detail.LogExcerpt) != 1 || detail.LogExcerpt[0] != "Step 2/2" {
+		t.Errorf("want the last line of the log, got %v", detail.LogExcerpt)
+	}
+
+	want := []sdk.CheckTiming{{Phase: "build", Seconds: 12.5}, {Phase: "push", Seconds: 2}}
+	if len(detail.Timings) != 2 || detail.Timings[0] != want[0] || detail.Timings[1] != want[1] {
+		t.Errorf("want %v, got %v", want, detail.Timings)
+	}
+}
+
+func Test_newCheckRunDetail_OlderBuilder(t *testing.T) {
+	detail := newCheckRunDetail(sdk.BuildResult{}, time.Now(), 20, false)
+
+	if len(detail.Timings) != 1 || detail.Timings[0].Seconds != 20 {
+		t.Errorf("want the time waited for the build, got %v", detail.Timings)
+	}
+}
+
+func Test_newCheckRunDetail_Prebuilt(t *testing.T) {
+	detail := newCheckRunDetail(sdk.BuildResult{}, time.Now(), 0, true)
+
+	if len(detail.Timings) != 0 {
+		t.Errorf("want no build timings for a pre-built image, got %v", detail.Timings)
+	}
+}
+
+func Test_addDeployTiming(t *testing.T) {
+	detail := &sdk.CheckRunDetail{}
+	attempts := []deployAttempt{{Status: 500, Duration: time.Second}, {Status: 200, Duration: 2 * time.Second}}
+
+	addDeployTiming(detail, attempts)
+
+	if len(detail.Timings) != 1 || detail.Timings[0].Phase != "deploy" || detail.Timings[0].Seconds != 3 {
+		t.Errorf("want 3s to deploy, got %v", detail.Timings)
+	}
+
+	addDeployTiming(nil, attempts)
+}
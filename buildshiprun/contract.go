@@ -0,0 +1,118 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Limits on the env-vars and secrets sent by git-tar for a function
+const (
+	maxEnvVars        = 100
+	maxEnvNameLength  = 128
+	maxEnvValueLength = 4096
+	maxSecrets        = 32
+	maxSecretLength   = 200
+)
+
+var (
+	envNameValidator = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// secretValidator matches a DNS-1123 subdomain, as required for a
+	// secret name by Kubernetes
+	secretValidator = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// validateEnv checks the Http_Env header is a JSON object of string
+// values with valid names. An empty value or null means no env-vars.
+func validateEnv(httpEnv string) error {
+	if len(httpEnv) == 0 {
+		return nil
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(httpEnv), &values); err != nil {
+		return fmt.Errorf("invalid environment: must be a map of names to strings")
+	}
+
+	if len(values) > maxEnvVars {
+		return fmt.Errorf("invalid environment: %d env-vars given, the limit is %d", len(values), maxEnvVars)
+	}
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if len(name) > maxEnvNameLength {
+			return fmt.Errorf("invalid env-var name %.32s...: longer than %d characters", name, maxEnvNameLength)
+		}
+
+		if !envNameValidator.MatchString(name) {
+			return fmt.Errorf("invalid env-var name %q: use letters, digits and underscores, not starting with a digit", name)
+		}
+
+		value, ok := values[name].(string)
+		if !ok {
+			return fmt.Errorf("invalid env-var %s: value must be a string, got %s", name, jsonType(values[name]))
+		}
+
+		if len(value) > maxEnvValueLength {
+			return fmt.Errorf("invalid env-var %s: value longer than %d bytes", name, maxEnvValueLength)
+		}
+	}
+
+	return nil
+}
+
+// validateSecretNames checks the Http_Secrets header is a JSON array
+// of valid secret names. An empty value or null means no secrets.
+func validateSecretNames(httpSecrets string) error {
+	if len(httpSecrets) == 0 {
+		return nil
+	}
+
+	values := []interface{}{}
+	if err := json.Unmarshal([]byte(httpSecrets), &values); err != nil {
+		return fmt.Errorf("invalid secrets: must be a list of names")
+	}
+
+	if len(values) > maxSecrets {
+		return fmt.Errorf("invalid secrets: %d secrets given, the limit is %d", len(values), maxSecrets)
+	}
+
+	for _, value := range values {
+		name, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid secret: name must be a string, got %s", jsonType(value))
+		}
+
+		if len(name) > maxSecretLength {
+			return fmt.Errorf("invalid secret name %.32s...: longer than %d characters", name, maxSecretLength)
+		}
+
+		if !secretValidator.MatchString(name) {
+			return fmt.Errorf("invalid secret name %q: use lower-case letters, digits, '-' and '.'", name)
+		}
+	}
+
+	return nil
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return "string"
+}
@@ -0,0 +1,71 @@
+package function
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_validateEnv(t *testing.T) {
+	tests := []struct {
+		title   string
+		env     string
+		wantErr string
+	}{
+		{"empty", "", ""},
+		{"null", "null", ""},
+		{"valid", `{"write_debug":"true","DB_HOST":"db.local"}`, ""},
+		{"not a map", `["write_debug"]`, "must be a map"},
+		{"malformed", `{"write_debug":`, "must be a map"},
+		{"number value", `{"port":8080}`, "value must be a string, got number"},
+		{"invalid name", `{"1port":"8080"}`, `invalid env-var name "1port"`},
+		{"name with dash", `{"db-host":"db"}`, `invalid env-var name "db-host"`},
+		{"long value", fmt.Sprintf(`{"key":"%s"}`, strings.Repeat("a", maxEnvValueLength+1)), "value longer than"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			err := validateEnv(test.env)
+			if len(test.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("want no error, got %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("want error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validateSecretNames(t *testing.T) {
+	tests := []struct {
+		title   string
+		secrets string
+		wantErr string
+	}{
+		{"empty", "", ""},
+		{"null", "null", ""},
+		{"valid", `["api-key","db.password"]`, ""},
+		{"not a list", `{"api-key":"x"}`, "must be a list"},
+		{"number", `[1]`, "name must be a string, got number"},
+		{"upper case", `["API_KEY"]`, `invalid secret name "API_KEY"`},
+		{"too many", "[" + strings.TrimSuffix(strings.Repeat(`"s",`, maxSecrets+1), ",") + "]", "the limit is"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			err := validateSecretNames(test.secrets)
+			if len(test.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("want no error, got %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("want error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
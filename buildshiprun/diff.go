@@ -0,0 +1,203 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-cli/stack"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// ignoredDiffLabels change on every deployment
+var ignoredDiffLabels = map[string]bool{
+	sdk.FunctionLabelPrefix + "git-deploytime": true,
+}
+
+// deployedSpec is the part of the gateway's system/function response
+// which is compared with the new deployment. envVars, secrets, limits
+// and requests are only returned by newer providers.
+type deployedSpec struct {
+	Image    string                   `json:"image"`
+	EnvVars  map[string]string        `json:"envVars"`
+	Labels   map[string]string        `json:"labels"`
+	Secrets  []string                 `json:"secrets"`
+	Limits   *stack.FunctionResources `json:"limits"`
+	Requests *stack.FunctionResources `json:"requests"`
+}
+
+// getDeployedSpec fetches the function's current spec from the gateway
+func getDeployedSpec(auth faasSDK.ClientAuth, gatewayURL, functionName, namespace string) (*deployedSpec, error) {
+	query := url.Values{}
+	if len(namespace) > 0 {
+		query.Set("namespace", namespace)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, strings.TrimSuffix(gatewayURL, "/")+"/system/function/"+functionName+"?"+query.Encode(), nil)
+	if err := auth.Set(req); err != nil {
+		return nil, err
+	}
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from gateway: %d", res.StatusCode)
+	}
+
+	spec := &deployedSpec{}
+	if err := json.Unmarshal(body, spec); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// diffDeployment compares the deployed function with the spec which
+// replaces it. Env-vars and secrets are only compared when the gateway
+// returned them.
+func diffDeployment(current *deployedSpec, next *faasSDK.DeployFunctionSpec) sdk.DeployDiff {
+	diff := sdk.DeployDiff{Changes: []sdk.DeployChange{}}
+
+	if current.Image != next.Image {
+		diff.Changes = append(diff.Changes, sdk.DeployChange{
+			Kind:   sdk.ImageChange,
+			Action: sdk.ChangeUpdated,
+			Old:    current.Image,
+			New:    next.Image,
+		})
+	}
+
+	if current.EnvVars != nil {
+		for _, change := range diffMaps(current.EnvVars, next.EnvVars, nil) {
+			change.Kind = sdk.EnvChange
+			change.Old, change.New = "", ""
+			diff.Changes = append(diff.Changes, change)
+		}
+	}
+
+	for _, change := range diffMaps(current.Labels, next.Labels, ignoredDiffLabels) {
+		change.Kind = sdk.LabelChange
+		diff.Changes = append(diff.Changes, change)
+	}
+
+	if current.Secrets != nil {
+		for _, change := range diffMaps(toSet(current.Secrets), toSet(next.Secrets), nil) {
+			change.Kind = sdk.SecretChange
+			change.Old, change.New = "", ""
+			diff.Changes = append(diff.Changes, change)
+		}
+	}
+
+	if current.Limits != nil {
+		for _, change := range diffMaps(resourceMap(current.Limits), resourceMap(next.FunctionResourceRequest.Limits), nil) {
+			change.Kind = sdk.LimitChange
+			diff.Changes = append(diff.Changes, change)
+		}
+	}
+
+	if current.Requests != nil {
+		for _, change := range diffMaps(resourceMap(current.Requests), resourceMap(next.FunctionResourceRequest.Requests), nil) {
+			change.Kind = sdk.RequestChange
+			diff.Changes = append(diff.Changes, change)
+		}
+	}
+
+	return diff
+}
+
+// resourceMap gives the memory and cpu which are set
+func resourceMap(resources *stack.FunctionResources) map[string]string {
+	values := map[string]string{}
+	if resources == nil {
+		return values
+	}
+	if len(resources.Memory) > 0 {
+		values["memory"] = resources.Memory
+	}
+	if len(resources.CPU) > 0 {
+		values["cpu"] = resources.CPU
+	}
+	return values
+}
+
+func toSet(items []string) map[string]string {
+	set := map[string]string{}
+	for _, item := range items {
+		set[item] = item
+	}
+	return set
+}
+
+// diffMaps returns the keys added, removed or updated, sorted by name
+func diffMaps(current, next map[string]string, ignored map[string]bool) []sdk.DeployChange {
+	changes := []sdk.DeployChange{}
+
+	for name, old := range current {
+		if ignored[name] {
+			continue
+		}
+		if val, ok := next[name]; !ok {
+			changes = append(changes, sdk.DeployChange{Name: name, Action: sdk.ChangeRemoved, Old: old})
+		} else if val != old {
+			changes = append(changes, sdk.DeployChange{Name: name, Action: sdk.ChangeUpdated, Old: old, New: val})
+		}
+	}
+
+	for name, val := range next {
+		if _, ok := current[name]; !ok && !ignored[name] {
+			changes = append(changes, sdk.DeployChange{Name: name, Action: sdk.ChangeAdded, New: val})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+// formatDiff summarises the diff for the audit message, such as
+// "image updated, env +API_URL ~DEBUG, secret -api-key"
+func formatDiff(diff sdk.DeployDiff) string {
+	if len(diff.Changes) == 0 {
+		return "no changes"
+	}
+
+	symbols := map[string]string{
+		sdk.ChangeAdded:   "+",
+		sdk.ChangeRemoved: "-",
+		sdk.ChangeUpdated: "~",
+	}
+
+	kinds := []string{}
+	names := map[string][]string{}
+	for _, change := range diff.Changes {
+		if _, ok := names[change.Kind]; !ok {
+			kinds = append(kinds, change.Kind)
+		}
+		if len(change.Name) > 0 {
+			names[change.Kind] = append(names[change.Kind], symbols[change.Action]+change.Name)
+		} else {
+			names[change.Kind] = append(names[change.Kind], change.Action)
+		}
+	}
+
+	parts := []string{}
+	for _, kind := range kinds {
+		parts = append(parts, kind+" "+strings.Join(names[kind], " "))
+	}
+	return strings.Join(parts, ", ")
+}
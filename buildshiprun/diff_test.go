@@ -0,0 +1,85 @@
+package function
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_diffDeployment(t *testing.T) {
+	current := &deployedSpec{
+		Image:   "registry:5000/alexellis-fn1:latest-6df8c47",
+		EnvVars: map[string]string{"DEBUG": "false", "OLD": "1"},
+		Labels: map[string]string{
+			sdk.FunctionLabelPrefix + "git-sha":        "6df8c47",
+			sdk.FunctionLabelPrefix + "git-deploytime": "1570000000",
+		},
+		Secrets: []string{"alexellis-api-key"},
+	}
+	next := &faasSDK.DeployFunctionSpec{
+		Image:   "registry:5000/alexellis-fn1:latest-a1b2c3d",
+		EnvVars: map[string]string{"DEBUG": "true", "API_URL": "https://example.com"},
+		Labels: map[string]string{
+			sdk.FunctionLabelPrefix + "git-sha":        "a1b2c3d",
+			sdk.FunctionLabelPrefix + "git-deploytime": "1570000100",
+		},
+		Secrets: []string{"alexellis-api-key", "alexellis-db"},
+	}
+
+	diff := diffDeployment(current, next)
+
+	want := "image updated, env +API_URL ~DEBUG -OLD, label ~com.openfaas.cloud.git-sha, secret +alexellis-db"
+	if got := formatDiff(diff); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	for _, change := range diff.Changes {
+		if change.Kind == sdk.EnvChange && (len(change.Old) > 0 || len(change.New) > 0) {
+			t.Errorf("want env-var values left out, got %+v", change)
+		}
+		if change.Kind == sdk.ImageChange && change.New != next.Image {
+			t.Errorf("want new image %s, got %s", next.Image, change.New)
+		}
+	}
+}
+
+func Test_diffDeployment_OlderProvider(t *testing.T) {
+	current := &deployedSpec{Image: "fn1:1"}
+	next := &faasSDK.DeployFunctionSpec{
+		Image:   "fn1:1",
+		EnvVars: map[string]string{"DEBUG": "true"},
+		Secrets: []string{"alexellis-db"},
+	}
+
+	if got := formatDiff(diffDeployment(current, next)); got != "no changes" {
+		t.Errorf("want env-vars and secrets skipped when not returned, got %q", got)
+	}
+}
+
+func Test_getDeployedSpec(t *testing.T) {
+	var path, namespace string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, namespace = r.URL.Path, r.URL.Query().Get("namespace")
+		w.Write([]byte(`{"name": "alexellis-fn1", "image": "fn1:1", "envVars": {"DEBUG": "true"}, "secrets": ["alexellis-db"]}`))
+	}))
+	defer server.Close()
+
+	spec, err := getDeployedSpec(&nilAuth{}, server.URL+"/", "alexellis-fn1", "openfaas-fn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/system/function/alexellis-fn1" || namespace != "openfaas-fn" {
+		t.Errorf("want the function in openfaas-fn, got %s %s", path, namespace)
+	}
+	if spec.Image != "fn1:1" || spec.EnvVars["DEBUG"] != "true" || len(spec.Secrets) != 1 {
+		t.Errorf("want spec read from the gateway, got %+v", spec)
+	}
+}
+
+type nilAuth struct{}
+
+func (nilAuth) Set(req *http.Request) error { return nil }
@@ -0,0 +1,22 @@
+package function
+
+import (
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// deploymentEvent describes the outcome of a pipeline run for NATS
+// subscribers, Function is the name from stack.yml and Branch tells a
+// staging deployment apart from production.
+func deploymentEvent(eventType string, event sdk.Event, image string, message string) sdk.DeploymentEvent {
+	return sdk.DeploymentEvent{
+		Type:      eventType,
+		Owner:     event.Owner,
+		Repo:      event.Repository,
+		Function:  event.Service,
+		SHA:       event.SHA,
+		Branch:    deployBranch(&event),
+		Image:     image,
+		Namespace: sdk.OwnerNamespace(event.Owner),
+		Message:   message,
+	}
+}
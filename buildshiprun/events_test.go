@@ -0,0 +1,36 @@
+package function
+
+import (
+	"os"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_deploymentEvent(t *testing.T) {
+	os.Setenv("owner_namespaces", "true")
+	defer os.Unsetenv("owner_namespaces")
+
+	event := sdk.Event{
+		Owner:      "alexellis",
+		Repository: "kubecon-tester",
+		Service:    "fn1",
+		SHA:        "af6db",
+		Branch:     "staging",
+	}
+
+	got := deploymentEvent(sdk.FunctionDeployedEvent, event, "registry:5000/alexellis/fn1:staging-af6db", "deployed")
+
+	if got.Type != sdk.FunctionDeployedEvent {
+		t.Errorf("want type %s, got %s", sdk.FunctionDeployedEvent, got.Type)
+	}
+	if got.Owner != "alexellis" || got.Repo != "kubecon-tester" || got.Function != "fn1" || got.SHA != "af6db" {
+		t.Errorf("want event details copied, got %+v", got)
+	}
+	if got.Branch != "staging" {
+		t.Errorf("want branch staging, got %s", got.Branch)
+	}
+	if got.Namespace != "openfaas-fn-alexellis" {
+		t.Errorf("want namespace openfaas-fn-alexellis, got %s", got.Namespace)
+	}
+}
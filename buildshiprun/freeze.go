@@ -0,0 +1,34 @@
+package function
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// deployFreeze gives the reason deployments are frozen platform-wide,
+// they are not frozen when it is empty. deploy_freeze_path is read on
+// each call so that an operator can freeze deployments during an
+// incident by updating a mounted ConfigMap or secret, without
+// redeploying buildshiprun. Otherwise deploy_freeze gives the reason.
+func deployFreeze() string {
+	if path := os.Getenv("deploy_freeze_path"); len(path) > 0 {
+		reason, err := ioutil.ReadFile(path)
+		if err == nil {
+			return strings.TrimSpace(string(reason))
+		}
+		if !os.IsNotExist(err) {
+			log.Printf("unable to read deploy freeze from %s: %s", path, err.Error())
+		}
+	}
+
+	return strings.TrimSpace(os.Getenv("deploy_freeze"))
+}
+
+// freezeDescription explains to the owner why their function was built
+// but not deployed
+func freezeDescription(reason string) string {
+	return fmt.Sprintf("deploy frozen by operators: %s, push again once it is lifted", reason)
+}
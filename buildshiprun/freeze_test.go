@@ -0,0 +1,61 @@
+package function
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_deployFreeze(t *testing.T) {
+	os.Unsetenv("deploy_freeze_path")
+	os.Setenv("deploy_freeze", "")
+	defer os.Unsetenv("deploy_freeze")
+
+	if reason := deployFreeze(); reason != "" {
+		t.Errorf("want deployments not frozen, got %q", reason)
+	}
+
+	os.Setenv("deploy_freeze", " incident #42 ")
+	if reason := deployFreeze(); reason != "incident #42" {
+		t.Errorf("want the reason from deploy_freeze, got %q", reason)
+	}
+}
+
+func Test_deployFreeze_Path(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freeze")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	freezePath := path.Join(dir, "deploy-freeze")
+	os.Setenv("deploy_freeze_path", freezePath)
+	defer os.Unsetenv("deploy_freeze_path")
+
+	if reason := deployFreeze(); reason != "" {
+		t.Errorf("want deployments not frozen while the file is missing, got %q", reason)
+	}
+
+	ioutil.WriteFile(freezePath, []byte("database migration\n"), 0600)
+	if reason := deployFreeze(); reason != "database migration" {
+		t.Errorf("want the reason from the file, got %q", reason)
+	}
+
+	ioutil.WriteFile(freezePath, []byte(""), 0600)
+	if reason := deployFreeze(); reason != "" {
+		t.Errorf("want deployments unfrozen once the file is emptied, got %q", reason)
+	}
+}
+
+func Test_promote_Frozen(t *testing.T) {
+	os.Setenv("deploy_freeze", "incident #42")
+	defer os.Unsetenv("deploy_freeze")
+
+	res := promote([]byte(`{"owner":"alexellis","repo":"fns","function":"fn1"}`))
+
+	if res.Code != http.StatusLocked || res.Reason != "promote: deploys are frozen: incident #42" {
+		t.Errorf("want the promotion refused while frozen, got %+v", res)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -26,6 +27,8 @@ const (
 	GitHub = "github"
 	// GitLab SCM
 	GitLab = "gitlab"
+	// Bitbucket SCM
+	Bitbucket = "bitbucket"
 )
 
 const scaleToZeroDefault = true
@@ -45,24 +48,54 @@ type CPULimits struct {
 type FaaSAuth struct {
 }
 
-//Set add basic authentication to the request
+//Set add basic or token authentication to the request, see gateway_auth
 func (auth *FaaSAuth) Set(req *http.Request) error {
-	return sdk.AddBasicAuth(req)
+	return sdk.AddGatewayAuth(req)
 }
 
 var (
-	timeout   = 3 * time.Second
-	namespace = ""
+	timeout = 3 * time.Second
 )
 
+// clock stamps deploy times, pipeline runs and metrics, tests replace it
+// to get deterministic labels
+var clock sdk.Clock = sdk.SystemClock{}
+
 // Handle submits the tar to the of-builder then configures an OpenFaaS
 // deployment based upon stack.yml found in the Git repo. Finally starts
 // a rolling deployment of the function.
+// The response is an sdk.Response as JSON, with secrets redacted.
 func Handle(req []byte) string {
+	return handle(req).JSON()
+}
+
+func handle(req []byte) sdk.Response {
+
+	// The cron-connector's invocation has no body and can't be signed,
+	// it only finishes the canaries which were recorded by a signed build
+	if len(req) == 0 && os.Getenv("Http_X_Connector") == "cron-connector" {
+		payloadSecret, keyErr := sdk.ReadSecret("payload-secret")
+		if keyErr != nil {
+			return sdk.Failed(http.StatusInternalServerError, fmt.Sprintf("canary: failed to load hmac key, error %s", keyErr.Error()))
+		}
+		return checkCanaries(os.Getenv("gateway_url"), payloadSecret)
+	}
 
 	hmacErr := validateRequest(&req)
 	if hmacErr != nil {
-		return fmt.Sprintf("invalid HMAC digest for tar: %s", hmacErr.Error())
+		return sdk.Rejected(http.StatusUnauthorized, fmt.Sprintf("invalid HMAC digest for tar: %s", hmacErr.Error()))
+	}
+
+	query, _ := url.ParseQuery(os.Getenv("Http_Query"))
+	switch query.Get("action") {
+	case "promote":
+		return promote(req)
+	case "pause":
+		return pause(req, true)
+	case "resume":
+		return pause(req, false)
+	case "resume-pipeline":
+		return resumePipeline(req)
 	}
 
 	builderURL := os.Getenv("builder_url")
@@ -72,140 +105,234 @@ func Handle(req []byte) string {
 	if keyErr != nil {
 		err := fmt.Errorf("failed to load hmac key, error %s", keyErr.Error())
 		log.Printf(err.Error())
-		return err.Error()
+		return sdk.Failed(http.StatusInternalServerError, err.Error())
 	}
 
 	event, eventErr := getEventFromEnv()
 	if eventErr != nil {
-		log.Panic(eventErr)
+		msg := fmt.Sprintf("buildshiprun failure reading event: %s", eventErr.Error())
+		log.Printf(msg)
+		return sdk.Rejected(http.StatusBadRequest, msg)
 	}
 
 	auditEvent := sdk.AuditEvent{
 		Owner:  event.Owner,
 		Repo:   event.Repository,
 		Source: "buildshiprun",
-	}
+	}.Trace(event.SHA, event.Delivery)
+
+	// A preview's configuration is compared with the production function
+	productionValue := sdk.FunctionName(event.Owner, event.Service)
 
-	serviceValue := sdk.FormatServiceName(event.Owner, event.Service)
+	applyPreview(event)
+
+	serviceValue := sdk.FunctionName(event.Owner, event.Service)
+	if isStaging(event) {
+		serviceValue = serviceValue + sdk.StagingSuffix
+	}
+	functionNamespace := sdk.OwnerNamespace(event.Owner)
 	log.Printf("%d env-vars for %s", len(event.Environment), serviceValue)
 
 	status := sdk.BuildStatus(event, sdk.EmptyAuthToken)
 
-	reader := bytes.NewBuffer(req)
+	if err := validateEnv(os.Getenv("Http_Env")); err != nil {
+		msg := err.Error()
+		return reportFailure(status, auditEvent, http.StatusUnprocessableEntity, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
+	}
 
-	xCloudSignature := os.Getenv("Http_X_Cloud_Signature")
+	if err := validateSecretNames(os.Getenv("Http_Secrets")); err != nil {
+		msg := err.Error()
+		return reportFailure(status, auditEvent, http.StatusUnprocessableEntity, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
+	}
 
-	r, _ := http.NewRequest(http.MethodPost, builderURL+"build", reader)
+	scheduling := getSchedulingConfig()
+	if err := validateScheduling(event, scheduling); err != nil {
+		msg := err.Error()
+		return reportFailure(status, auditEvent, http.StatusUnprocessableEntity, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
+	}
 
-	r.Header.Set(sdk.CloudSignatureHeader, xCloudSignature)
-	r.Header.Set("Content-Type", "application/octet-stream")
+	resources, err := resolveResources(event, getResourcePolicy())
+	if err != nil {
+		msg := err.Error()
+		return reportFailure(status, auditEvent, http.StatusUnprocessableEntity, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
+	}
 
-	res, err := http.DefaultClient.Do(r)
+	if injected := injectAPMEnv(event, serviceValue, getAPMConfig()); injected > 0 {
+		log.Printf("Injected %d APM env-vars into %s", injected, serviceValue)
+	}
 
-	if err != nil {
-		log.Printf("of-builder error: %s\n", err)
+	if event.SkipBuild {
+		if err := validatePrebuiltImage(event.Image, event.Owner, getPrebuiltRegistries()); err != nil {
+			msg := err.Error()
+			return reportFailure(status, auditEvent, http.StatusUnprocessableEntity, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
+		}
+	}
 
-		auditEvent.Message = fmt.Sprintf("buildshiprun failure: %s", err.Error())
-		sdk.PostAudit(auditEvent)
+	// Initializing the client and context
+	gatewayTimeout := getGatewayTimeout()
+
+	// Functions pushed to a branch with a deploy target are deployed to
+	// the target's gateway, the pipeline continues to use gateway_url
+	clientAuth, deployGatewayURL := deployGateway(event, gatewayURL)
+	if deployGatewayURL != gatewayURL {
+		log.Printf("Deploying %s to target %s: %s", serviceValue, deployTargetName(event), deployGatewayURL)
+	}
 
-		status.AddStatus(sdk.StatusFailure, err.Error(), sdk.BuildFunctionContext(event.Service))
-		statusErr := reportStatus(status, event.SCM)
-		if statusErr != nil {
-			log.Printf(statusErr.Error())
+	client := faasSDK.NewClient(clientAuth, deployGatewayURL, nil, &gatewayTimeout)
+	ctx := context.Background()
+
+	// The owner's namespace is only prepared on the cluster which
+	// buildshiprun runs in, a deploy target manages its own
+	if namespaces := getOwnerNamespace(); namespaces != nil && deployGatewayURL == gatewayURL {
+		if err := namespaces.Prepare(functionNamespace, event.Owner, event.Secrets); err != nil {
+			msg := fmt.Sprintf("unable to prepare namespace %s: %s", functionNamespace, err.Error())
+			return reportFailure(status, auditEvent, http.StatusInternalServerError, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
 		}
+	}
 
-		return auditEvent.Message
+	if validateSecrets() {
+		missing, err := missingSecrets(ctx, client, event.Secrets, functionNamespace)
+		if err != nil {
+			log.Printf("unable to list secrets, skipping validation: %s", err.Error())
+		} else if len(missing) > 0 {
+			msg := fmt.Sprintf("missing secrets: %s", strings.Join(missing, ", "))
+			return reportFailure(status, auditEvent, http.StatusUnprocessableEntity, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
+		}
 	}
 
-	log.Printf("Image build status: %d\n", res.StatusCode)
+	if err := checkFunctionQuota(ctx, client, event.Owner, serviceValue, functionNamespace, getFunctionQuota(event.Owner)); err != nil {
+		msg := err.Error()
+		return reportFailure(status, auditEvent, http.StatusForbidden, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
+	}
 
-	defer res.Body.Close()
+	// A repository whose builds keep failing is not built again until
+	// its owner resumes the pipeline
+	failureBudget := getFailureBudget()
+	if failureBudget > 0 && !event.SkipBuild {
+		if record, paused := pipelinePaused(event, gatewayURL); paused {
+			msg := pausedDescription(record)
+			return reportFailure(status, auditEvent, http.StatusLocked, msg, fmt.Sprintf("buildshiprun skipped %s: %s", serviceValue, msg))
+		}
+	}
 
-	buildBytes, _ := ioutil.ReadAll(res.Body)
+	recordPipelineStage(event, sdk.StageBuilding, gatewayURL, payloadSecret)
+	defer recordPipelineStage(event, sdk.StageCompleted, gatewayURL, payloadSecret)
+
+	buildStart := time.Now()
 
 	result := sdk.BuildResult{}
-	unmarshalErr := json.Unmarshal(buildBytes, &result)
+	buildStatusCode, buildStatus := http.StatusOK, "pre-built"
+
+	if event.SkipBuild {
+		log.Printf("Skipping build of %s, pre-built image: %s", serviceValue, event.Image)
+		result = prebuiltResult(event)
+	} else {
+		var buildBytes []byte
+		buildStatusCode, buildStatus, buildBytes, err = requestBuild(req, event, builderURL, payloadSecret)
+		if err != nil {
+			log.Printf("of-builder error: %s\n", err)
+
+			return reportFailure(status, auditEvent, http.StatusBadGateway, err.Error(),
+				fmt.Sprintf("buildshiprun failure: %s", err.Error()))
+		}
 
-	if unmarshalErr != nil {
-		log.Printf("BuildResult unmarshalErr %s\n", unmarshalErr)
+		unmarshalErr := json.Unmarshal(buildBytes, &result)
 
-		auditEvent.Message = fmt.Sprintf("buildshiprun failure reading response: %s, response: %s", unmarshalErr.Error(), string(buildBytes))
-		sdk.PostAudit(auditEvent)
+		if unmarshalErr != nil {
+			log.Printf("BuildResult unmarshalErr %s\n", unmarshalErr)
 
-		status.AddStatus(sdk.StatusFailure, unmarshalErr.Error(), sdk.BuildFunctionContext(event.Service))
-		statusErr := reportStatus(status, event.SCM)
-		if statusErr != nil {
-			log.Printf(statusErr.Error())
+			return reportFailure(status, auditEvent, http.StatusBadGateway, unmarshalErr.Error(),
+				fmt.Sprintf("buildshiprun failure reading response: %s, response: %s", unmarshalErr.Error(), string(buildBytes)))
 		}
-		return auditEvent.Message
 	}
 
-	imageName := strings.ToLower(result.ImageName)
+	// A pre-built image's tag may be mixed case
+	imageName := result.ImageName
+	if !event.SkipBuild {
+		imageName = strings.ToLower(imageName)
+	}
 
 	repositoryURL := os.Getenv("repository_url")
 	pushRepositoryURL := os.Getenv("push_repository_url")
 
 	if len(repositoryURL) == 0 {
 		msg := "repository_url env-var not set"
-		fmt.Fprintf(os.Stderr, msg)
-		status.AddStatus(sdk.StatusFailure, msg, sdk.BuildFunctionContext(event.Service))
-		statusErr := reportStatus(status, event.SCM)
-		if statusErr != nil {
-			log.Printf(statusErr.Error())
-		}
-		os.Exit(1)
+		return reportFailure(status, auditEvent, http.StatusInternalServerError, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
 	}
 
 	if len(pushRepositoryURL) == 0 {
-		fmt.Fprintf(os.Stderr, "push_repository_url env-var not set")
-		os.Exit(1)
+		msg := "push_repository_url env-var not set"
+		return reportFailure(status, auditEvent, http.StatusInternalServerError, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
 	}
 
 	log.Printf("buildshiprun: image '%s'\n", imageName)
 
-	logStatus, logErr := createPipelineLog(result, event, gatewayURL, payloadSecret)
-	if logErr != nil {
-		log.Printf("pipeline-log: error: %s", err.Error())
-	} else {
-		log.Printf("pipeline-log: status: %d", logStatus)
+	if logErr := createPipelineLog(result, event, gatewayURL, payloadSecret); logErr != nil {
+		log.Printf("pipeline-log: error: %s", logErr.Error())
 	}
 
-	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
-		msg := "Unable to build image, check builder logs"
-		status.AddStatus(sdk.StatusFailure, msg, sdk.BuildFunctionContext(event.Service))
-		statusErr := reportStatus(status, event.SCM)
-		if statusErr != nil {
-			log.Printf(statusErr.Error())
+	buildSeconds := time.Since(buildStart).Seconds()
+	status.Check = newCheckRunDetail(result, buildStart, buildSeconds, event.SkipBuild)
+
+	if buildStatusCode != http.StatusOK && buildStatusCode != http.StatusAccepted {
+		msg := buildFailureDescription(result)
+
+		log.Printf("of-builder result: %s, logs: %s\n", result.Status, sdk.Redact(strings.Join(result.Log, "\n")))
+
+		if failureBudget > 0 && !event.SkipBuild {
+			recordBuild(event, false, failureBudget, gatewayURL, payloadSecret)
 		}
 
-		auditEvent.Message = fmt.Sprintf("Error with buildshiprun: %s", msg)
-		sdk.PostAudit(auditEvent)
+		return reportFailure(status, auditEvent, http.StatusUnprocessableEntity, msg,
+			fmt.Sprintf("Error with buildshiprun: %s\n%s", msg, buildLogTail(result, auditLogLines)))
+	}
 
-		log.Printf("of-builder result: %s, logs: %s\n", result.Status, strings.Join(result.Log, "\n"))
+	if failureBudget > 0 && !event.SkipBuild {
+		recordBuild(event, true, failureBudget, gatewayURL, payloadSecret)
+	}
 
-		log.Fatal(msg)
-		return msg
+	if result.Scan != nil {
+		if scanErr := recordScan(result.Scan, event, gatewayURL, payloadSecret); scanErr != nil {
+			log.Printf("scan: error: %s", scanErr.Error())
+		} else {
+			log.Printf("scan: %s", result.Scan.FormatVulnerabilities())
+		}
 	}
-	// Initializing the client and context
-	client := faasSDK.NewClient(&FaaSAuth{}, gatewayURL, nil, &timeout)
-	ctx := context.Background()
+
+	if err := checkScanPolicy(result.Scan, getScanPolicy()); err != nil {
+		msg := err.Error()
+		return reportFailure(status, auditEvent, http.StatusUnprocessableEntity, msg, fmt.Sprintf("buildshiprun failure: %s", msg))
+	}
+
+	// The image has been built and pushed, but is not deployed while
+	// operators have frozen deployments
+	if reason := deployFreeze(); len(reason) > 0 {
+		msg := freezeDescription(reason)
+		return reportFailure(status, auditEvent, http.StatusLocked, msg, fmt.Sprintf("buildshiprun skipped deploy of %s %s: %s", serviceValue, imageName, msg))
+	}
+
+	var drift *sdk.DeployDiff
 
 	if len(imageName) > 0 {
-		// Replace image name for "localhost" for deployment
-		imageName = getImageName(repositoryURL, pushRepositoryURL, imageName)
+		// Replace image name for "localhost" for deployment, a pre-built
+		// image is pulled from where it was pushed
+		if !event.SkipBuild {
+			imageName = getImageName(repositoryURL, pushRepositoryURL, event.Owner, imageName)
+		}
 
 		log.Printf("Deploying %s as %s", imageName, serviceValue)
 
-		defaultMemoryLimit := getMemoryLimit()
+		memoryLimit := memoryQuantity(strconv.Itoa(resources.MemoryLimitMB))
 
 		scalingMinLimit := getConfig("scaling_min_limit", "1")
 		scalingMaxLimit := getConfig("scaling_max_limit", "4")
 
 		scalingFactor := getConfig("scaling_factor", "20")
 
-		readOnlyRootFS := getReadOnlyRootFS()
+		// A static site only serves the files in its image
+		readOnlyRootFS := getReadOnlyRootFS() || isStaticSite(event)
 
-		registryAuth := getRegistryAuthSecret()
+		registryAuth := getRegistryAuth(event.Owner)
 
 		private := 0
 		if event.Private {
@@ -228,15 +355,21 @@ func Handle(req []byte) string {
 			"schedule",
 			"com.openfaas.health.http.path",
 			"com.openfaas.health.http.initialDelay",
+			"openapi",
+			"com.openfaas.cloud.maintenance",
+			"com.openfaas.cloud.routes",
 		}
 
 		userAnnotations := buildAnnotations(annotationWhitelist, event.Annotations)
 		userAnnotations[sdk.FunctionLabelPrefix+"git-repo-url"] = event.RepoURL
+		repoAnnotations(userAnnotations, event)
+		scanAnnotations(userAnnotations, result.Scan)
 
 		deploy := &faasSDK.DeployFunctionSpec{
 			FunctionName: serviceValue,
+			Namespace:    functionNamespace,
 			Image:        imageName,
-			Network:      "func_functions",
+			Network:      getConfig("function_network", "func_functions"),
 			Labels: map[string]string{
 				"faas_function":             serviceValue,
 				"app":                       serviceValue,
@@ -249,11 +382,13 @@ func Handle(req []byte) string {
 				sdk.FunctionLabelPrefix + "git-owner":      event.Owner,
 				sdk.FunctionLabelPrefix + "git-owner-id":   fmt.Sprintf("%d", event.OwnerID),
 				sdk.FunctionLabelPrefix + "git-repo":       event.Repository,
-				sdk.FunctionLabelPrefix + "git-deploytime": strconv.FormatInt(time.Now().Unix(), 10), //Unix Epoch string
+				sdk.FunctionLabelPrefix + "git-deploytime": strconv.FormatInt(clock.Now().Unix(), 10), //Unix Epoch string
 				sdk.FunctionLabelPrefix + "git-sha":        event.SHA,
 				sdk.FunctionLabelPrefix + "git-private":    fmt.Sprintf("%d", private),
 				sdk.FunctionLabelPrefix + "git-scm":        event.SCM,
-				sdk.FunctionLabelPrefix + "git-branch":     buildBranch(),
+				sdk.FunctionLabelPrefix + "git-branch":     deployBranch(event),
+				metricsOwnerLabel:                          metricsOwner(event.Owner, gatewayURL, payloadSecret),
+				sdk.MemoryLimitLabel:                       strconv.Itoa(resources.MemoryLimitMB),
 			},
 			Annotations: userAnnotations,
 			FunctionResourceRequest: faasSDK.FunctionResourceRequest{
@@ -265,7 +400,14 @@ func Handle(req []byte) string {
 			ReadOnlyRootFilesystem: readOnlyRootFS,
 		}
 
-		deploy.FunctionResourceRequest.Limits.Memory = defaultMemoryLimit
+		deploy.FunctionResourceRequest.Limits.Memory = memoryLimit
+		if resources.MemoryRequestMB > 0 {
+			deploy.FunctionResourceRequest.Requests.Memory = memoryQuantity(strconv.Itoa(resources.MemoryRequestMB))
+		}
+		applyScheduling(deploy, event, scheduling)
+		previewLabels(deploy.Labels, event)
+		tagLabels(deploy.Labels, event)
+		staticSiteLabels(deploy.Labels, event)
 
 		cpuLimit := getCPULimit()
 		if cpuLimit.Available {
@@ -279,38 +421,182 @@ func Handle(req []byte) string {
 			}
 		}
 
+		// Defaults for the template and values from stack.yml
+		if _, kubernetes := os.LookupEnv("KUBERNETES_SERVICE_PORT"); kubernetes {
+			if resources.CPULimitMilli > 0 {
+				deploy.FunctionResourceRequest.Limits.CPU = fmt.Sprintf("%dm", resources.CPULimitMilli)
+			}
+			if resources.CPURequestMilli > 0 {
+				deploy.FunctionResourceRequest.Requests.CPU = fmt.Sprintf("%dm", resources.CPURequestMilli)
+			}
+		}
+
 		gatewayURL := os.Getenv("gateway_url")
 
 		if len(registryAuth) > 0 {
 			deploy.RegistryAuth = registryAuth
 		}
 
-		deployResult, err := deployFunction(ctx, client, deploy, gatewayURL)
-		log.Println(deployResult)
+		recordPipelineStage(event, sdk.StageDeploying, gatewayURL, payloadSecret)
 
-		if err != nil {
-			status.AddStatus(sdk.StatusFailure, err.Error(), sdk.BuildFunctionContext(event.Service))
-			statusErr := reportStatus(status, event.SCM)
-			if statusErr != nil {
+		previous := lastDeployment(ctx, client, serviceValue, functionNamespace)
+		repoPath := event.Owner + "/" + event.Repository
+		target := previousDeployment(gatewayURL, repoPath, serviceValue, previous, deploy.Image)
+
+		// Record what this deployment changes for the audit event
+		var diff *sdk.DeployDiff
+		if previous != nil {
+			if current, err := getDeployedSpec(clientAuth, deployGatewayURL, serviceValue, functionNamespace); err != nil {
+				log.Printf("unable to read the deployed spec of %s: %s", serviceValue, err.Error())
+			} else {
+				changes := diffDeployment(current, deploy)
+				diff = &changes
+			}
+		}
+
+		if isPreview(event) {
+			if production, err := getDeployedSpec(clientAuth, deployGatewayURL, productionValue, functionNamespace); err != nil {
+				log.Printf("unable to read the production spec of %s: %s", productionValue, err.Error())
+			} else {
+				changes := diffPreview(production, deploy)
+				drift = &changes
+			}
+		}
+
+		canary := getCanaryConfig(event.Labels)
+		if canary.Enabled && previous != nil {
+			if err := startCanary(ctx, client, deploy, deployGatewayURL, canary, getHealthCheck()); err != nil {
+				msg := err.Error()
+				return reportFailure(status, auditEvent, http.StatusBadGateway, msg,
+					fmt.Sprintf("buildshiprun failure: %s, %s still serving", msg, previous.Image))
+			}
+
+			// The canary is promoted or aborted by the scheduled check
+			// once its window has passed
+			job, err := recordCanary(deploy, event, canary, gatewayURL, payloadSecret)
+			if err != nil {
+				removeCanary(ctx, client, deploy.FunctionName+canarySuffix, deploy.Namespace)
+				msg := fmt.Sprintf("canary aborted: unable to record canary: %s", err.Error())
+				return reportFailure(status, auditEvent, http.StatusBadGateway, msg,
+					fmt.Sprintf("buildshiprun failure: %s, %s still serving", msg, previous.Image))
+			}
+
+			msg := fmt.Sprintf("canary receiving %d%% of traffic until %s", canary.Weight, job.Due.Format(time.RFC3339))
+			status.AddStatus(sdk.StatusPending, msg, functionContext(event))
+			if statusErr := reportStatus(status, event.SCM); statusErr != nil {
 				log.Printf(statusErr.Error())
 			}
-			log.Fatal(err.Error())
-			auditEvent.Message = fmt.Sprintf("buildshiprun failure: %s", err.Error())
+
+			auditEvent.Message = fmt.Sprintf("buildshiprun: %s, %s", job.Canary, msg)
 			sdk.PostAudit(auditEvent)
-			log.Fatalf("buildshiprun failure: %s", err.Error())
+			return sdk.Accepted(fmt.Sprintf("buildStatus %s %s, %s", imageName, buildStatus, msg))
+		}
+
+		deployResult, attempts, err := deployFunction(ctx, client, deploy, deployGatewayURL)
+		log.Println(deployResult)
+		addDeployTiming(status.Check, attempts)
+
+		var warmup warmupResult
+
+		if err == nil {
+			manifestEvent := event
+			if isStaging(event) {
+				stagingEvent := *event
+				stagingEvent.Service = event.Service + sdk.StagingSuffix
+				manifestEvent = &stagingEvent
+			}
+
+			signature, manifestErr := recordManifest(deploy, manifestEvent, gatewayURL, payloadSecret)
+			if manifestErr != nil {
+				log.Printf("deploy-manifest: error: %s", manifestErr.Error())
+			} else {
+				log.Printf("deploy-manifest: %s", signature)
+			}
+
+			check := getHealthCheck()
+			err = verifyDeployment(ctx, client, serviceValue, functionNamespace, deploy.Image, deployGatewayURL, check)
+			if check.Enabled && check.Status {
+				addVerifyStatus(status, event, err)
+			}
+
+			if err == nil {
+				deployed := deployRecord{Image: deploy.Image, SHA: event.SHA, Branch: event.Branch, Deployed: clock.Now()}
+				if historyErr := recordDeployment(gatewayURL, payloadSecret, repoPath, serviceValue, deployed); historyErr != nil {
+					log.Printf("deploy-history: error: %s", historyErr.Error())
+				}
+
+				warmup = warmUp(deployGatewayURL, serviceValue, functionNamespace, getWarmupConfig(event.Labels))
+				if warmup.Requests > 0 {
+					log.Printf("%s %s", serviceValue, warmup)
+				}
+			}
+		}
+
+		if err != nil {
+			msg := err.Error()
+			if target != nil {
+				if rollbackErr := rollback(ctx, client, deploy, target, deployGatewayURL); rollbackErr != nil {
+					log.Printf(rollbackErr.Error())
+				} else {
+					msg = fmt.Sprintf("%s, rolled back to %s", msg, target.Image)
+				}
+			}
+
+			return reportFailure(status, auditEvent, http.StatusBadGateway, msg,
+				fmt.Sprintf("buildshiprun failure: %s, %s", msg, formatAttempts(attempts)))
 		} else {
-			auditEvent.Message = fmt.Sprintf("buildshiprun succeeded: deployed %s", imageName)
+			metrics := newBuildMetrics(event, result, buildSeconds)
+			auditEvent.Message = fmt.Sprintf("buildshiprun succeeded: deployed %s, %s, %s", imageName, formatAttempts(attempts), metrics)
+			if diff != nil {
+				auditEvent.Message = fmt.Sprintf("%s, changes: %s", auditEvent.Message, formatDiff(*diff))
+				auditEvent.Diff = diff
+			}
+			if warmup.Requests > 0 {
+				auditEvent.Message = fmt.Sprintf("%s, %s", auditEvent.Message, warmup)
+			}
+			if result.Scan != nil {
+				auditEvent.Message = fmt.Sprintf("%s, vulnerabilities: %s", auditEvent.Message, result.Scan.FormatVulnerabilities())
+			}
 			sdk.PostAudit(auditEvent)
+			if err := pushBuildMetrics(metrics, clock.Now()); err != nil {
+				log.Printf("pushgateway: error: %s", err.Error())
+			}
+			sdk.PublishDeploymentEvent(deploymentEvent(sdk.FunctionDeployedEvent, *event, imageName, auditEvent.Message))
 		}
 
 	}
 
-	status.AddStatus(sdk.StatusSuccess, fmt.Sprintf("deployed: %s", serviceValue), sdk.BuildFunctionContext(event.Service))
+	status.AddStatus(sdk.StatusSuccess, fmt.Sprintf("deployed: %s", serviceValue), functionContext(event))
+	status.Deploy = &sdk.DeployInfo{
+		Image:        imageName,
+		BuildSeconds: buildSeconds,
+		Drift:        drift,
+	}
 	statusErr := reportStatus(status, event.SCM)
 	if statusErr != nil {
 		log.Printf(statusErr.Error())
 	}
-	return fmt.Sprintf("buildStatus %s %s", imageName, res.Status)
+	return sdk.OK(fmt.Sprintf("buildStatus %s %s", imageName, buildStatus))
+}
+
+// reportFailure posts the audit event, a failure commit status and a
+// deploy-failed event for the function, then returns the audit message
+// as the failed response of the handler with code so that the process
+// is never exited mid-request.
+func reportFailure(status *sdk.Status, auditEvent sdk.AuditEvent, code int, description string, message string) sdk.Response {
+	log.Printf(message)
+
+	auditEvent.Message = message
+	sdk.PostAudit(auditEvent)
+	sdk.PublishDeploymentEvent(deploymentEvent(sdk.DeployFailedEvent, status.EventInfo, "", message))
+
+	status.AddStatus(sdk.StatusFailure, description, functionContext(&status.EventInfo))
+	statusErr := reportStatus(status, status.EventInfo.SCM)
+	if statusErr != nil {
+		log.Printf(statusErr.Error())
+	}
+
+	return sdk.Failed(code, message)
 }
 
 func buildAnnotations(whitelist []string, userValues map[string]string) map[string]string {
@@ -326,6 +612,43 @@ func buildAnnotations(whitelist []string, userValues map[string]string) map[stri
 	return annotations
 }
 
+// requestBuild sends the build context to of-builder and returns the
+// status and body of its response. The build headers are signed with
+// the payload-secret since git-tar's signature only covers the body.
+func requestBuild(req []byte, event *sdk.Event, builderURL string, payloadSecret string) (int, string, []byte, error) {
+	reader := bytes.NewBuffer(req)
+
+	xCloudSignature := os.Getenv("Http_X_Cloud_Signature")
+
+	r, _ := http.NewRequest(http.MethodPost, builderURL+"build", reader)
+
+	r.Header.Set(sdk.CloudSignatureHeader, xCloudSignature)
+	r.Header.Set("Content-Type", "application/octet-stream")
+	r.Header.Set(sdk.BuildOwnerHeader, event.Owner)
+	r.Header.Set(sdk.BuildRepoHeader, event.Repository)
+	r.Header.Set(sdk.BuildSHAHeader, event.SHA)
+	r.Header.Set(sdk.BuildFunctionHeader, event.Service)
+	r.Header.Set(sdk.BuildSignatureHeader, sdk.SignBuildMetadata(event.Owner, event.Repository, event.SHA, event.Service, req, payloadSecret))
+
+	// Join the builder's spans to the caller's trace
+	if traceParent := os.Getenv("Http_Traceparent"); len(traceParent) > 0 {
+		r.Header.Set("traceparent", traceParent)
+	}
+
+	res, err := builderClient().Do(r)
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	log.Printf("Image build status: %d\n", res.StatusCode)
+
+	defer res.Body.Close()
+
+	buildBytes, _ := ioutil.ReadAll(res.Body)
+
+	return res.StatusCode, res.Status, buildBytes, nil
+}
+
 func validateRequest(req *[]byte) (err error) {
 	payloadSecret, err := sdk.ReadSecret("payload-secret")
 
@@ -355,35 +678,45 @@ func getConfig(key string, defaultValue string) string {
 
 // createPipelineLog sends a log to pipeline-log and will
 // fail silently if unavailable.
-func createPipelineLog(result sdk.BuildResult, event *sdk.Event, gatewayURL string, payloadSecret string) (int, error) {
+func createPipelineLog(result sdk.BuildResult, event *sdk.Event, gatewayURL string, payloadSecret string) error {
 
 	p := sdk.PipelineLog{
 		CommitSHA: event.SHA,
 		Function:  event.Service,
 		RepoPath:  event.Owner + "/" + event.Repository,
-		Data:      strings.Join(result.Log, "\n"),
+		Data:      sdk.Redact(strings.Join(result.Log, "\n")),
 	}
 
-	bytesOut, _ := json.Marshal(&p)
+	return sdk.WritePipelineLog(gatewayURL, payloadSecret, p)
+}
+
+// recordPipelineStage tells the pipeline-watchdog which stage the run
+// has reached and will fail silently if unavailable.
+func recordPipelineStage(event *sdk.Event, stage string, gatewayURL string, payloadSecret string) {
+	run := sdk.PipelineRun{
+		Event:   *event,
+		Stage:   stage,
+		Started: clock.Now(),
+	}
 
-	reader := bytes.NewReader(bytesOut)
+	bytesOut, _ := json.Marshal(&run)
 
-	req, _ := http.NewRequest(http.MethodPost, gatewayURL+"function/pipeline-log", reader)
+	req, _ := http.NewRequest(http.MethodPost, gatewayURL+"function/pipeline-watchdog", bytes.NewReader(bytesOut))
 
 	digest := hmac.Sign(bytesOut, []byte(payloadSecret))
 	req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := sdk.HTTPClient().Do(req)
 	if err != nil {
-		return http.StatusInternalServerError, err
-
+		log.Printf("pipeline-watchdog: error: %s", err.Error())
+		return
 	}
 
-	if req.Body != nil {
-		defer req.Body.Close()
+	if res.Body != nil {
+		defer res.Body.Close()
 	}
 
-	return res.StatusCode, nil
+	log.Printf("pipeline-watchdog: stage: %s, status: %d", stage, res.StatusCode)
 }
 
 // readOnlyRootFS defaults to true, override with env-var of readonly_root_filesystem=false
@@ -414,6 +747,12 @@ func getEventFromEnv() (*sdk.Event, error) {
 	info.SCM = os.Getenv("Http_Scm")
 	info.Private, _ = strconv.ParseBool(os.Getenv("Http_Private"))
 	info.RepoURL = os.Getenv("Http_Repo_Url")
+	info.Branch = os.Getenv("Http_Branch")
+	info.SkipBuild, _ = strconv.ParseBool(os.Getenv("Http_Skip_Build"))
+	info.PullRequest, _ = strconv.Atoi(os.Getenv("Http_Pull_Request"))
+	info.Tag = os.Getenv("Http_Tag")
+	info.License = os.Getenv("Http_Repo_License")
+	info.Languages = os.Getenv("Http_Repo_Languages")
 
 	if len(os.Getenv("Http_Owner_Id")) > 0 {
 		info.OwnerID, _ = strconv.Atoi(os.Getenv("Http_Owner_Id"))
@@ -476,6 +815,45 @@ func getEventFromEnv() (*sdk.Event, error) {
 
 	info.Secrets = secretVars
 
+	info.Language = os.Getenv("Http_Language")
+	info.Tier = os.Getenv("Http_Tier")
+
+	if entitlements := os.Getenv("Http_Entitlements"); len(entitlements) > 0 {
+		if entitlementsErr := json.Unmarshal([]byte(entitlements), &info.Entitlements); entitlementsErr != nil {
+			log.Printf("Error un-marshaling entitlements for function %s, %s", info.Service, entitlementsErr)
+		}
+	}
+
+	if delivery := os.Getenv("Http_Delivery"); len(delivery) > 0 {
+		info.Delivery = &sdk.Delivery{}
+		if deliveryErr := json.Unmarshal([]byte(delivery), info.Delivery); deliveryErr != nil {
+			log.Printf("Error un-marshaling delivery for function %s, %s", info.Service, deliveryErr)
+			info.Delivery = nil
+		}
+	}
+
+	if limits := os.Getenv("Http_Limits"); len(limits) > 0 {
+		info.Limits = &sdk.Resources{}
+		if limitsErr := json.Unmarshal([]byte(limits), info.Limits); limitsErr != nil {
+			log.Printf("Error un-marshaling limits for function %s, %s", info.Service, limitsErr)
+			info.Limits = nil
+		}
+	}
+
+	if requests := os.Getenv("Http_Requests"); len(requests) > 0 {
+		info.Requests = &sdk.Resources{}
+		if requestsErr := json.Unmarshal([]byte(requests), info.Requests); requestsErr != nil {
+			log.Printf("Error un-marshaling requests for function %s, %s", info.Service, requestsErr)
+			info.Requests = nil
+		}
+	}
+
+	if constraints := os.Getenv("Http_Constraints"); len(constraints) > 0 {
+		if constraintsErr := json.Unmarshal([]byte(constraints), &info.Constraints); constraintsErr != nil {
+			log.Printf("Error un-marshaling constraints for function %s, %s", info.Service, constraintsErr)
+		}
+	}
+
 	owner := strings.ToLower(info.Owner)
 	for i := 0; i < len(info.Secrets); i++ {
 		info.Secrets[i] = owner + "-" + info.Secrets[i]
@@ -486,7 +864,7 @@ func getEventFromEnv() (*sdk.Event, error) {
 	return &info, err
 }
 
-func functionExists(ctx context.Context, client *faasSDK.Client, functionName string, gatewayURL string) (bool, error) {
+func functionExists(ctx context.Context, client *faasSDK.Client, functionName string, namespace string, gatewayURL string) (bool, error) {
 	// client := faasSDK.NewClient(&FaaSAuth{}, gatewayURL, nil, &timeout)
 	functions, err := client.ListFunctions(ctx, namespace)
 	if err != nil {
@@ -502,62 +880,79 @@ func functionExists(ctx context.Context, client *faasSDK.Client, functionName st
 	return false, err
 }
 
-func deployFunction(ctx context.Context, client *faasSDK.Client, deploySpec *faasSDK.DeployFunctionSpec, gatewayURL string) (string, error) {
+func deployFunction(ctx context.Context, client *faasSDK.Client, deploySpec *faasSDK.DeployFunctionSpec, gatewayURL string) (string, []deployAttempt, error) {
 	var (
 		err error
 	)
-	exists, err := functionExists(ctx, client, deploySpec.FunctionName, gatewayURL)
+	exists, err := functionExists(ctx, client, deploySpec.FunctionName, deploySpec.Namespace, gatewayURL)
 	log.Println("Deploying: " + deploySpec.Image + " as " + deploySpec.FunctionName)
 	if exists {
 		deploySpec.Update = true
 	}
 
-	resStatus := client.DeployFunction(ctx, deploySpec)
-	log.Printf("Deploy status - %d", resStatus)
-	if resStatus < 200 || resStatus > 299 {
-		return "", fmt.Errorf("http status code %d", resStatus)
+	policy := getRetryPolicy()
+	attempts := []deployAttempt{}
+
+	for i := 0; i < policy.Attempts; i++ {
+		if i > 0 {
+			wait := policy.delay(i - 1)
+			log.Printf("Retrying deployment of %s in %s", deploySpec.FunctionName, wait)
+			time.Sleep(wait)
+		}
+
+		start := time.Now()
+		resStatus := client.DeployFunction(ctx, deploySpec)
+		attempts = append(attempts, deployAttempt{Status: resStatus, Duration: time.Since(start)})
+
+		log.Printf("Deploy status - %d", resStatus)
+		if resStatus >= 200 && resStatus <= 299 {
+			return fmt.Sprintf("%s deployed successfully", deploySpec.FunctionName), attempts, err
+		}
+
+		if !retryable(resStatus) {
+			break
+		}
 	}
-	return fmt.Sprintf("%s deployed successfully", deploySpec.FunctionName), err
+
+	return "", attempts, fmt.Errorf("http status code %d", attempts[len(attempts)-1].Status)
 }
 
 func enableStatusReporting() bool {
 	return os.Getenv("report_status") == "true"
 }
 
+// reportStatus sends the status to the event's SCM through its status
+// function, statuses for GitHub are only sent when report_status is set
 func reportStatus(status *sdk.Status, SCM string) error {
-	if SCM == GitHub {
-		reportGitHubStatus(status)
-	} else if SCM == GitLab {
-		reportGitLabStatus(status)
-	} else {
-		return fmt.Errorf("non-supported SCM: %s", SCM)
+	scm, err := sdk.GetSCM(SCM)
+	if err != nil {
+		return err
 	}
-	return nil
-}
-
-func reportGitHubStatus(status *sdk.Status) {
 
-	if !enableStatusReporting() {
-		return
+	if scm.Name() == GitHub && !enableStatusReporting() {
+		return nil
 	}
 
-	gatewayURL := os.Getenv("gateway_url")
-
 	payloadSecret, keyErr := sdk.ReadSecret("payload-secret")
 	if keyErr != nil {
 		log.Printf("failed to load hmac key for status, error " + keyErr.Error())
-		return
+		return nil
 	}
 
-	_, reportErr := status.Report(gatewayURL, payloadSecret)
-	if reportErr != nil {
+	gatewayURL := sdk.CreateServiceURL(os.Getenv("gateway_url"), os.Getenv("dns_suffix"))
+
+	if reportErr := scm.ReportStatus(status, gatewayURL, payloadSecret); reportErr != nil {
 		log.Printf("failed to report status, error: %s", reportErr.Error())
 	}
+	return nil
 }
 
-func getImageName(repositoryURL, pushRepositoryURL, imageName string) string {
+// getImageName replaces the push registry with the registry used to
+// pull images, including the owner's organisation when one is set. An
+// owner's own registry is used for both, so their images are unchanged.
+func getImageName(repositoryURL, pushRepositoryURL, owner, imageName string) string {
 
-	return strings.Replace(imageName, pushRepositoryURL, repositoryURL, 1)
+	return strings.Replace(imageName, sdk.OwnerRegistry(pushRepositoryURL, owner), sdk.OwnerRegistry(repositoryURL, owner), 1)
 
 	// return repositoryURL + imageName[strings.Index(imageName, "/"):]
 }
@@ -616,69 +1011,33 @@ func getCPULimit() CPULimits {
 }
 
 func getMemoryLimit() string {
+	return memoryQuantity(getMemoryLimitMB())
+}
+
+// memoryQuantity adds the unit used by the orchestrator to a value in MB
+func memoryQuantity(mb string) string {
 	const swarmSuffix = "m"
 	const kubernetesSuffix = "Mi"
 
 	suffix := swarmSuffix
 
 	kubernetesPort := "KUBERNETES_SERVICE_PORT"
-	memoryLimit := os.Getenv("function_memory_limit_mb")
 
 	if _, exists := os.LookupEnv(kubernetesPort); exists {
 		suffix = kubernetesSuffix
 	}
 
-	const defaultMemoryLimit = "128"
-
-	unit := defaultMemoryLimit
-	if len(memoryLimit) > 0 {
-		unit = memoryLimit
-	}
-
-	return fmt.Sprintf("%s%s", unit, suffix)
+	return fmt.Sprintf("%s%s", mb, suffix)
 }
 
-func reportGitLabStatus(status *sdk.Status) {
-
-	payloadSecret, secretErr := sdk.ReadSecret("payload-secret")
-	if secretErr != nil {
-		log.Printf("unexpected error while reading secret: %s", secretErr)
-	}
-
-	suffix := os.Getenv("dns_suffix")
-	gatewayURL := os.Getenv("gateway_url")
-	gatewayURL = sdk.CreateServiceURL(gatewayURL, suffix)
-
-	statusBytes, marshalErr := json.Marshal(status)
-	if marshalErr != nil {
-		log.Printf("error while marshalling request: %s", marshalErr.Error())
-	}
-
-	statusReader := bytes.NewReader(statusBytes)
-	req, reqErr := http.NewRequest(http.MethodPost, gatewayURL+"function/gitlab-status", statusReader)
-	if reqErr != nil {
-		log.Printf("error while making request to gitlab-status: `%s`", reqErr.Error())
-	}
-
-	digest := hmac.Sign(statusBytes, []byte(payloadSecret))
-	req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
-
-	res, resErr := http.DefaultClient.Do(req)
-	if resErr != nil {
-		log.Printf("unexpected error while retrieving response: %s", resErr.Error())
-	}
-	if res.Body != nil {
-		defer res.Body.Close()
-	}
-	if res.StatusCode != http.StatusOK {
-		log.Printf("unexpected status code: %d", res.StatusCode)
-	}
+// getMemoryLimitMB reads function_memory_limit_mb without a unit suffix
+func getMemoryLimitMB() string {
+	const defaultMemoryLimit = "128"
 
-	_, bodyErr := ioutil.ReadAll(res.Body)
-	if bodyErr != nil {
-		log.Printf("unexpected error while reading response body: %s", bodyErr.Error())
+	if memoryLimit := os.Getenv("function_memory_limit_mb"); len(memoryLimit) > 0 {
+		return memoryLimit
 	}
-	status.CommitStatuses = make(map[string]sdk.CommitStatus)
+	return defaultMemoryLimit
 }
 
 func buildBranch() string {
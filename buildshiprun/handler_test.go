@@ -2,8 +2,11 @@ package function
 
 import (
 	"encoding/json"
+	"net/http"
 	"os"
 	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
 )
 
 func TestGetEvent_ReadLabels(t *testing.T) {
@@ -142,7 +145,7 @@ func Test_GetImageName(t *testing.T) {
 
 	for _, testcase := range imageNameTestcases {
 		t.Run(testcase.Name, func(t *testing.T) {
-			output := getImageName(testcase.RepositoryURL, testcase.PushRepositoryURL, testcase.ImageName)
+			output := getImageName(testcase.RepositoryURL, testcase.PushRepositoryURL, "username", testcase.ImageName)
 			if output != testcase.Output {
 				t.Errorf("%s failed!. got: %s, want: %s", testcase.Name, output, testcase.Output)
 			}
@@ -150,6 +153,30 @@ func Test_GetImageName(t *testing.T) {
 	}
 }
 
+func Test_GetImageName_OwnerOrg(t *testing.T) {
+	os.Setenv("registry_orgs", "alexellis=customer-x")
+	defer os.Unsetenv("registry_orgs")
+
+	output := getImageName("127.0.0.1:5000", "registry:5000", "alexellis", "registry:5000/customer-x/kubecon-tester-fn1:latest-master-af6db")
+
+	want := "127.0.0.1:5000/customer-x/kubecon-tester-fn1:latest-master-af6db"
+	if output != want {
+		t.Errorf("got: %s, want: %s", output, want)
+	}
+}
+
+func Test_GetImageName_OwnerRegistry(t *testing.T) {
+	os.Setenv("owner_registries", "alexellis=ghcr.io/alexellis")
+	defer os.Unsetenv("owner_registries")
+
+	output := getImageName("127.0.0.1:5000", "registry:5000", "alexellis", "ghcr.io/alexellis/kubecon-tester-fn1:latest-master-af6db")
+
+	want := "ghcr.io/alexellis/kubecon-tester-fn1:latest-master-af6db"
+	if output != want {
+		t.Errorf("got: %s, want: %s", output, want)
+	}
+}
+
 func Test_ValidImage(t *testing.T) {
 	imageNames := map[string]bool{
 
@@ -466,3 +493,48 @@ func Test_buildAnnotations_AllowsWhitelisted(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_reportFailure_ReturnsAuditMessage(t *testing.T) {
+	os.Unsetenv("audit_url")
+
+	event := &sdk.Event{Service: "fn1", SCM: GitHub}
+	status := sdk.BuildStatus(event, sdk.EmptyAuthToken)
+	auditEvent := sdk.AuditEvent{Source: "buildshiprun"}
+
+	got := reportFailure(status, auditEvent, http.StatusInternalServerError, "repository_url env-var not set", "buildshiprun failure: repository_url env-var not set")
+
+	want := "buildshiprun failure: repository_url env-var not set"
+	if got.Reason != want || got.Status != sdk.ResponseFailed || got.Code != http.StatusInternalServerError {
+		t.Errorf("want: %s, got: %+v", want, got)
+	}
+}
+
+func Test_getEventFromEnv_Tier(t *testing.T) {
+	os.Setenv("Http_Tier", "pro")
+	os.Setenv("Http_Entitlements", `{"functions":"50"}`)
+	defer os.Unsetenv("Http_Tier")
+	defer os.Unsetenv("Http_Entitlements")
+
+	event, err := getEventFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Tier != "pro" || event.Entitlements["functions"] != "50" {
+		t.Errorf("want tier and entitlements read from headers, got %q %v", event.Tier, event.Entitlements)
+	}
+}
+
+func Test_getEventFromEnv_Delivery(t *testing.T) {
+	os.Setenv("Http_Delivery", `{"id":"72d3162e","event":"push","received":"2020-05-01T12:00:00Z","correlationId":"9f2c81d04ab3e6f7"}`)
+	defer os.Unsetenv("Http_Delivery")
+
+	event, err := getEventFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Delivery == nil || event.Delivery.ID != "72d3162e" || event.Delivery.Event != "push" || event.Delivery.CorrelationID != "9f2c81d04ab3e6f7" {
+		t.Errorf("want the delivery read from the header, got %+v", event.Delivery)
+	}
+}
@@ -0,0 +1,161 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// healthCheck configures how a deployment is verified before
+// success is reported
+type healthCheck struct {
+	Enabled  bool
+	Timeout  time.Duration
+	Interval time.Duration
+	// Path is an optional probe which is invoked on the function once
+	// replicas are available, i.e. /healthz
+	Path string
+	// Status reports the verification as its own commit status, so that
+	// it can be required separately by branch protection
+	Status bool
+	// Rollout reads the Deployment's rollout from Kubernetes in place of
+	// the replicas reported by the gateway, so that the cause of a failed
+	// rollout is given
+	Rollout *kubeRollout
+}
+
+// getHealthCheck reads the health_check, health_check_timeout,
+// health_check_interval, health_check_path, verify_status and
+// rollout_status env-vars.
+func getHealthCheck() healthCheck {
+	check := healthCheck{
+		Enabled:  true,
+		Timeout:  2 * time.Minute,
+		Interval: 2 * time.Second,
+		Path:     strings.TrimSpace(os.Getenv("health_check_path")),
+		Status:   os.Getenv("verify_status") == "true",
+		Rollout:  getRollout(),
+	}
+
+	if val, exists := os.LookupEnv("health_check"); exists {
+		if val == "0" || val == "false" {
+			check.Enabled = false
+		}
+	}
+
+	check.Timeout = getDuration("health_check_timeout", check.Timeout)
+	check.Interval = getDuration("health_check_interval", check.Interval)
+
+	return check
+}
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if val, exists := os.LookupEnv(key); exists && len(val) > 0 {
+		duration, err := time.ParseDuration(val)
+		if err != nil {
+			log.Printf("unable to parse %s: %s", key, err.Error())
+			return defaultValue
+		}
+		return duration
+	}
+	return defaultValue
+}
+
+// verifyDeployment waits until every replica of the function runs the
+// image, then invokes the probe path if one is configured. A failed
+// rollout is returned straight away with its cause.
+func verifyDeployment(ctx context.Context, client *faasSDK.Client, functionName string, namespace string, image string, gatewayURL string, check healthCheck) error {
+	if !check.Enabled {
+		return nil
+	}
+
+	deadline := time.Now().Add(check.Timeout)
+
+	for {
+		ready, err := functionReady(ctx, client, functionName, namespace, image, check)
+		if err == nil && ready {
+			break
+		}
+		if _, failed := err.(rolloutFailure); failed {
+			return err
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("function not ready after %s: %s", check.Timeout, err.Error())
+			}
+			return fmt.Errorf("function not ready after %s: rollout of %s is not complete", check.Timeout, image)
+		}
+
+		time.Sleep(check.Interval)
+	}
+
+	if len(check.Path) == 0 {
+		return nil
+	}
+
+	probeURL := functionURL(gatewayURL, functionName, namespace, check.Path)
+
+	// Each probe ends by the deadline, so that a function which doesn't
+	// respond can't hold up the build past health_check_timeout
+	probeCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	var probeErr error
+	for {
+		req, _ := http.NewRequest(http.MethodGet, probeURL, nil)
+		res, err := sdk.HTTPClient().Do(req.WithContext(probeCtx))
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode >= 200 && res.StatusCode <= 299 {
+				return nil
+			}
+			err = fmt.Errorf("unexpected status code %d", res.StatusCode)
+		}
+
+		// A probe cut off by the deadline gives the previous probe's
+		// result, which has the reason the function is failing
+		if probeCtx.Err() == nil || probeErr == nil {
+			probeErr = err
+		}
+
+		if time.Now().After(deadline) || probeCtx.Err() != nil {
+			return fmt.Errorf("health probe %s failed after %s: %s", check.Path, check.Timeout, probeErr.Error())
+		}
+
+		time.Sleep(check.Interval)
+	}
+}
+
+// functionReady is true once the rollout of the image is complete.
+// Without a rollout the gateway only reports the available replicas,
+// which are compared with the desired replicas once it reports the image.
+func functionReady(ctx context.Context, client *faasSDK.Client, functionName string, namespace string, image string, check healthCheck) (bool, error) {
+	if check.Rollout != nil {
+		return check.Rollout.Ready(functionName, namespace, image)
+	}
+
+	fn, err := client.GetFunctionInfo(ctx, functionName, namespace)
+	if err != nil {
+		return false, err
+	}
+	return fn.Image == image && fn.Replicas > 0 && fn.AvailableReplicas >= fn.Replicas, nil
+}
+
+// addVerifyStatus adds the result of verifyDeployment as the function's
+// verification status
+func addVerifyStatus(status *sdk.Status, event *sdk.Event, err error) {
+	verifyContext := sdk.BuildVerifyContext(functionContext(event))
+	if err != nil {
+		status.AddStatus(sdk.StatusFailure, err.Error(), verifyContext)
+		return
+	}
+	status.AddStatus(sdk.StatusSuccess, "deployment verified", verifyContext)
+}
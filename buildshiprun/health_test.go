@@ -0,0 +1,129 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_getHealthCheck_Defaults(t *testing.T) {
+	os.Unsetenv("health_check")
+	os.Unsetenv("health_check_timeout")
+
+	check := getHealthCheck()
+	if !check.Enabled {
+		t.Errorf("want health check enabled by default")
+	}
+	if check.Timeout != 2*time.Minute {
+		t.Errorf("want default timeout 2m, got %s", check.Timeout)
+	}
+}
+
+func Test_getHealthCheck_Disabled(t *testing.T) {
+	os.Setenv("health_check", "false")
+	defer os.Unsetenv("health_check")
+
+	if getHealthCheck().Enabled {
+		t.Errorf("want health check disabled")
+	}
+}
+
+func Test_getHealthCheck_VerifyStatus(t *testing.T) {
+	os.Setenv("verify_status", "true")
+	defer os.Unsetenv("verify_status")
+
+	if !getHealthCheck().Status {
+		t.Errorf("want the verification reported as a status")
+	}
+}
+
+func Test_addVerifyStatus(t *testing.T) {
+	event := &sdk.Event{Service: "fn1"}
+	status := sdk.BuildStatus(event, sdk.EmptyAuthToken)
+
+	addVerifyStatus(status, event, nil)
+	got := status.CommitStatuses[sdk.BuildVerifyContext("fn1")]
+	if got.Status != sdk.StatusSuccess {
+		t.Errorf("want a success verify status, got %q", got.Status)
+	}
+
+	addVerifyStatus(status, event, fmt.Errorf("probe returned 500"))
+	got = status.CommitStatuses[sdk.BuildVerifyContext("fn1")]
+	if got.Status != sdk.StatusFailure || got.Description != "probe returned 500" {
+		t.Errorf("want a failed verify status, got %q: %s", got.Status, got.Description)
+	}
+}
+
+func Test_verifyDeployment_WaitsForReplicas(t *testing.T) {
+	calls := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/function/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		calls++
+		available := uint64(0)
+		if calls > 1 {
+			available = 1
+		}
+		json.NewEncoder(w).Encode(types.FunctionStatus{Name: "alexellis-fn1", Image: "alexellis-fn1:af6db", Replicas: 1, AvailableReplicas: available})
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	check := healthCheck{Enabled: true, Timeout: time.Second, Interval: time.Millisecond, Path: "/healthz"}
+
+	if err := verifyDeployment(context.Background(), client, "alexellis-fn1", "", "alexellis-fn1:af6db", s.URL+"/", check); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if calls != 2 {
+		t.Errorf("want 2 calls to the gateway, got %d", calls)
+	}
+}
+
+func Test_verifyDeployment_ProbeFails(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/function/") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(types.FunctionStatus{Name: "alexellis-fn1", Image: "alexellis-fn1:af6db", Replicas: 1, AvailableReplicas: 1})
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	check := healthCheck{Enabled: true, Timeout: 10 * time.Millisecond, Interval: time.Millisecond, Path: "healthz"}
+
+	err := verifyDeployment(context.Background(), client, "alexellis-fn1", "", "alexellis-fn1:af6db", s.URL+"/", check)
+	if err == nil {
+		t.Fatalf("want error from failing probe")
+	}
+	if !strings.Contains(err.Error(), "500") {
+		t.Errorf("want reason to include status code, got %s", err)
+	}
+}
+
+func Test_verifyDeployment_WaitsForNewImage(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(types.FunctionStatus{Name: "alexellis-fn1", Image: "alexellis-fn1:4cd1e2f", Replicas: 2, AvailableReplicas: 2})
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	check := healthCheck{Enabled: true, Timeout: 10 * time.Millisecond, Interval: time.Millisecond}
+
+	err := verifyDeployment(context.Background(), client, "alexellis-fn1", "", "alexellis-fn1:af6db", s.URL+"/", check)
+	if err == nil || !strings.Contains(err.Error(), "rollout of alexellis-fn1:af6db is not complete") {
+		t.Errorf("want the previous image not ready, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// deployHistoryKey is the key of a function's deploy history in
+// pipeline-log, in place of a commit SHA
+const deployHistoryKey = "deploy-history"
+
+// deployHistoryLength is the number of deployments kept per function
+const deployHistoryLength = 10
+
+// deployRecord is an entry in the deploy history of a function, the
+// Branch is the one the function is deployed for, which git-tar compares
+// a push with to build only the functions which changed
+type deployRecord struct {
+	Image    string    `json:"image"`
+	SHA      string    `json:"sha"`
+	Branch   string    `json:"branch,omitempty"`
+	Deployed time.Time `json:"deployed,omitempty"`
+}
+
+// deployHistory holds the deployments of a function which passed
+// verification, oldest first. A deployment which fails is never added,
+// so a rollback always goes back to a version which was known to work,
+// even after an earlier rollback or a failed deployment.
+type deployHistory struct {
+	Deployments []deployRecord `json:"deployments"`
+}
+
+// lastDeployment returns the image and SHA of the version currently
+// deployed, or nil when the function has never been deployed.
+func lastDeployment(ctx context.Context, client *faasSDK.Client, functionName string, namespace string) *deployRecord {
+	fn, err := client.GetFunctionInfo(ctx, functionName, namespace)
+	if err != nil || len(fn.Image) == 0 {
+		return nil
+	}
+
+	record := &deployRecord{Image: fn.Image}
+	if fn.Labels != nil {
+		record.SHA = (*fn.Labels)[sdk.FunctionLabelPrefix+"git-sha"]
+	}
+
+	return record
+}
+
+// rollbackTarget gives the most recent verified deployment of an image
+// other than the one being deployed. The version the gateway is running
+// is only used when the function has no history, i.e. it was deployed
+// before the history was kept or pipeline-log can't be read.
+func rollbackTarget(history *deployHistory, current *deployRecord, image string) *deployRecord {
+	if history == nil || len(history.Deployments) == 0 {
+		if current != nil && current.Image != image {
+			return current
+		}
+		return nil
+	}
+
+	for i := len(history.Deployments) - 1; i >= 0; i-- {
+		if history.Deployments[i].Image != image {
+			record := history.Deployments[i]
+			return &record
+		}
+	}
+	return nil
+}
+
+// add appends the deployment, keeping the most recent
+// deployHistoryLength. Deploying the same image again moves it to the end.
+func (h deployHistory) add(record deployRecord) deployHistory {
+	deployments := []deployRecord{}
+	for _, d := range h.Deployments {
+		if d.Image != record.Image {
+			deployments = append(deployments, d)
+		}
+	}
+	deployments = append(deployments, record)
+
+	if len(deployments) > deployHistoryLength {
+		deployments = deployments[len(deployments)-deployHistoryLength:]
+	}
+	return deployHistory{Deployments: deployments}
+}
+
+func readDeployHistory(gatewayURL string, repoPath string, functionName string) (*deployHistory, error) {
+	body, err := sdk.ReadPipelineLog(gatewayURL, sdk.PipelineLog{
+		RepoPath:  repoPath,
+		CommitSHA: deployHistoryKey,
+		Function:  functionName,
+		Source:    sdk.DeployHistorySource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	history := deployHistory{}
+	json.Unmarshal(body, &history)
+	return &history, nil
+}
+
+// recordDeployment adds a deployment which passed verification to the
+// function's history
+func recordDeployment(gatewayURL string, payloadSecret string, repoPath string, functionName string, record deployRecord) error {
+	history, err := readDeployHistory(gatewayURL, repoPath, functionName)
+	if err != nil {
+		return err
+	}
+
+	historyBytes, _ := json.Marshal(history.add(record))
+
+	return sdk.WritePipelineLog(gatewayURL, payloadSecret, sdk.PipelineLog{
+		RepoPath:  repoPath,
+		CommitSHA: deployHistoryKey,
+		Function:  functionName,
+		Source:    sdk.DeployHistorySource,
+		Data:      string(historyBytes),
+	})
+}
+
+// previousDeployment reads the function's history to find the version
+// to roll back to should the deployment of image fail
+func previousDeployment(gatewayURL string, repoPath string, functionName string, current *deployRecord, image string) *deployRecord {
+	history, err := readDeployHistory(gatewayURL, repoPath, functionName)
+	if err != nil {
+		log.Printf("deploy-history: unable to read %s: %s", functionName, err.Error())
+	}
+
+	return rollbackTarget(history, current, image)
+}
+
+// rollback redeploys the previously recorded image using the same
+// configuration as the failed deployment.
+func rollback(ctx context.Context, client *faasSDK.Client, failed *faasSDK.DeployFunctionSpec, previous *deployRecord, gatewayURL string) error {
+	spec := *failed
+	spec.Image = previous.Image
+
+	spec.Labels = map[string]string{}
+	for k, v := range failed.Labels {
+		spec.Labels[k] = v
+	}
+	spec.Labels[sdk.FunctionLabelPrefix+"git-sha"] = previous.SHA
+
+	log.Printf("Rolling back %s to %s", spec.FunctionName, spec.Image)
+
+	if _, _, err := deployFunction(ctx, client, &spec, gatewayURL); err != nil {
+		return fmt.Errorf("rollback of %s to %s failed: %s", spec.FunctionName, spec.Image, err.Error())
+	}
+
+	return nil
+}
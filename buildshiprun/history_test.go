@@ -0,0 +1,163 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_lastDeployment_ReadsImageAndSHA(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		labels := map[string]string{sdk.FunctionLabelPrefix + "git-sha": "af6db"}
+		json.NewEncoder(w).Encode(types.FunctionStatus{
+			Name:   "alexellis-fn1",
+			Image:  "registry:5000/alexellis/fn1:af6db",
+			Labels: &labels,
+		})
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	record := lastDeployment(context.Background(), client, "alexellis-fn1", "")
+
+	if record == nil {
+		t.Fatalf("want a deploy record, got nil")
+	}
+	if record.Image != "registry:5000/alexellis/fn1:af6db" {
+		t.Errorf("want image registry:5000/alexellis/fn1:af6db, got %s", record.Image)
+	}
+	if record.SHA != "af6db" {
+		t.Errorf("want SHA af6db, got %s", record.SHA)
+	}
+}
+
+func Test_lastDeployment_NotFound(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	if record := lastDeployment(context.Background(), client, "alexellis-fn1", ""); record != nil {
+		t.Errorf("want nil deploy record, got %v", record)
+	}
+}
+
+func Test_rollback_DeploysPreviousImage(t *testing.T) {
+	var deployed faasSDK.DeployFunctionSpec
+	var got map[string]interface{}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode([]types.FunctionStatus{{Name: "alexellis-fn1"}})
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	deployed = faasSDK.DeployFunctionSpec{
+		FunctionName: "alexellis-fn1",
+		Image:        "registry:5000/alexellis/fn1:bad",
+		Labels:       map[string]string{sdk.FunctionLabelPrefix + "git-sha": "bad"},
+	}
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	previous := &deployRecord{Image: "registry:5000/alexellis/fn1:af6db", SHA: "af6db"}
+
+	if err := rollback(context.Background(), client, &deployed, previous, s.URL); err != nil {
+		t.Fatalf("rollback error: %s", err)
+	}
+
+	if got["image"] != previous.Image {
+		t.Errorf("want image %s, got %v", previous.Image, got["image"])
+	}
+	if deployed.Labels[sdk.FunctionLabelPrefix+"git-sha"] != "bad" {
+		t.Errorf("want the failed spec's labels to be left unchanged")
+	}
+}
+
+func Test_rollbackTarget(t *testing.T) {
+	current := &deployRecord{Image: "fn1:bad", SHA: "bad"}
+	history := &deployHistory{Deployments: []deployRecord{
+		{Image: "fn1:a", SHA: "a"},
+		{Image: "fn1:b", SHA: "b"},
+	}}
+
+	if got := rollbackTarget(history, current, "fn1:c"); got == nil || got.Image != "fn1:b" {
+		t.Errorf("want the last verified deployment, not what the gateway runs, got %v", got)
+	}
+	if got := rollbackTarget(history, current, "fn1:b"); got == nil || got.Image != "fn1:a" {
+		t.Errorf("want the deployment before the image being deployed, got %v", got)
+	}
+	if got := rollbackTarget(nil, current, "fn1:c"); got != current {
+		t.Errorf("want the gateway's version without history, got %v", got)
+	}
+	if got := rollbackTarget(&deployHistory{}, current, "fn1:bad"); got != nil {
+		t.Errorf("want no rollback to the image being deployed, got %v", got)
+	}
+}
+
+func Test_deployHistory_add(t *testing.T) {
+	history := deployHistory{}
+	for i := 0; i < deployHistoryLength+2; i++ {
+		history = history.add(deployRecord{Image: fmt.Sprintf("fn1:%d", i)})
+	}
+	history = history.add(deployRecord{Image: "fn1:5"})
+
+	if len(history.Deployments) != deployHistoryLength {
+		t.Errorf("want %d deployments, got %d", deployHistoryLength, len(history.Deployments))
+	}
+	if last := history.Deployments[len(history.Deployments)-1]; last.Image != "fn1:5" {
+		t.Errorf("want a redeployed image moved to the end, got %s", last.Image)
+	}
+	if first := history.Deployments[0]; first.Image != "fn1:2" {
+		t.Errorf("want the oldest deployments dropped, got %s", first.Image)
+	}
+}
+
+// Test_previousDeployment_AfterFailures rolls back twice in a row, the
+// gateway runs the failed image after the first failure when its
+// rollback fails, but the verified deployment is still the target
+func Test_previousDeployment_AfterFailures(t *testing.T) {
+	var record string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+			p := sdk.PipelineLog{}
+			json.Unmarshal(body, &p)
+			if p.Source != sdk.DeployHistorySource || p.Function != "alexellis-fn1" || p.RepoPath != "alexellis/fn1" {
+				t.Errorf("unexpected pipeline log %+v", p)
+			}
+			record = p.Data
+			return
+		}
+		w.Write([]byte(record))
+	}))
+	defer s.Close()
+
+	gatewayURL := s.URL + "/"
+	deployed := deployRecord{Image: "fn1:good", SHA: "good", Deployed: time.Now()}
+	if err := recordDeployment(gatewayURL, "secret", "alexellis/fn1", "alexellis-fn1", deployed); err != nil {
+		t.Fatal(err)
+	}
+
+	current := &deployRecord{Image: "fn1:bad1", SHA: "bad1"}
+	for _, image := range []string{"fn1:bad1", "fn1:bad2"} {
+		target := previousDeployment(gatewayURL, "alexellis/fn1", "alexellis-fn1", current, image)
+		if target == nil || target.Image != "fn1:good" {
+			t.Errorf("%s: want a rollback to fn1:good, got %v", image, target)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package function
+
+import (
+	"encoding/json"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// buildManifest produces a signed record of the spec applied to the
+// gateway. The registry auth is removed so that no credentials end
+// up in the pipeline store.
+func buildManifest(spec *faasSDK.DeployFunctionSpec, event *sdk.Event, payloadSecret string, deployed time.Time) (*sdk.DeploymentManifest, error) {
+	applied := *spec
+	applied.RegistryAuth = ""
+
+	specBytes, err := json.Marshal(applied)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &sdk.DeploymentManifest{
+		Owner:    event.Owner,
+		Repo:     event.Repository,
+		SHA:      event.SHA,
+		Function: spec.FunctionName,
+		Image:    spec.Image,
+		Spec:     specBytes,
+		Deployed: deployed.UTC(),
+	}
+
+	if err := manifest.Sign(payloadSecret); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// recordManifest stores the signed manifest next to the build log
+// in pipeline-log and returns its signature.
+func recordManifest(spec *faasSDK.DeployFunctionSpec, event *sdk.Event, gatewayURL string, payloadSecret string) (string, error) {
+	manifest, err := buildManifest(spec, event, payloadSecret, clock.Now())
+	if err != nil {
+		return "", err
+	}
+
+	manifestBytes, _ := json.Marshal(manifest)
+
+	p := sdk.PipelineLog{
+		CommitSHA: event.SHA,
+		Function:  event.Service,
+		RepoPath:  event.Owner + "/" + event.Repository,
+		Source:    sdk.ManifestSource,
+		Data:      string(manifestBytes),
+	}
+
+	if err := sdk.WritePipelineLog(gatewayURL, payloadSecret, p); err != nil {
+		return "", err
+	}
+
+	return manifest.Signature, nil
+}
@@ -0,0 +1,34 @@
+package function
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_buildManifest_SignedWithoutRegistryAuth(t *testing.T) {
+	spec := &faasSDK.DeployFunctionSpec{
+		FunctionName: "alexellis-fn1",
+		Image:        "registry:5000/alexellis/fn1:af6db",
+		RegistryAuth: "c2VjcmV0",
+	}
+	event := &sdk.Event{Owner: "alexellis", Repository: "super-pancake", SHA: "af6db"}
+
+	manifest, err := buildManifest(spec, event, "secret", time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if strings.Contains(string(manifest.Spec), "c2VjcmV0") {
+		t.Errorf("want registry auth removed from manifest spec")
+	}
+	if spec.RegistryAuth != "c2VjcmV0" {
+		t.Errorf("want the deployed spec to keep its registry auth")
+	}
+	if err := manifest.Verify("secret"); err != nil {
+		t.Errorf("want valid signature, got %s", err)
+	}
+}
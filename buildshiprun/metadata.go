@@ -0,0 +1,16 @@
+package function
+
+import (
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// repoAnnotations records the license and languages of the repository
+// read by github-push, for the dashboard and for license policies
+func repoAnnotations(annotations map[string]string, event *sdk.Event) {
+	if len(event.License) > 0 {
+		annotations[sdk.LicenseAnnotation] = event.License
+	}
+	if len(event.Languages) > 0 {
+		annotations[sdk.LanguagesAnnotation] = event.Languages
+	}
+}
@@ -0,0 +1,28 @@
+package function
+
+import (
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_repoAnnotations(t *testing.T) {
+	annotations := map[string]string{sdk.LicenseAnnotation: "from-stack"}
+	repoAnnotations(annotations, &sdk.Event{License: "MIT", Languages: "Go=90.0,Dockerfile=10.0"})
+
+	if annotations[sdk.LicenseAnnotation] != "MIT" {
+		t.Errorf("want the license from GitHub, got %q", annotations[sdk.LicenseAnnotation])
+	}
+	if annotations[sdk.LanguagesAnnotation] != "Go=90.0,Dockerfile=10.0" {
+		t.Errorf("want the languages from GitHub, got %q", annotations[sdk.LanguagesAnnotation])
+	}
+}
+
+func Test_repoAnnotations_NoMetadata(t *testing.T) {
+	annotations := map[string]string{}
+	repoAnnotations(annotations, &sdk.Event{})
+
+	if len(annotations) != 0 {
+		t.Errorf("want no annotations without metadata, got %v", annotations)
+	}
+}
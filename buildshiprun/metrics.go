@@ -0,0 +1,197 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// metricsOwnerLabel is the owner label which Prometheus copies from the
+// function's pods onto its series. Unlike git-owner its values are
+// bounded so that installs with thousands of owners do not create a
+// series per owner for every metric.
+const metricsOwnerLabel = sdk.FunctionLabelPrefix + "metrics-owner"
+
+// metricsOwnerRepoPath is where the owners given a label are kept in
+// pipeline-log
+const metricsOwnerRepoPath = "system/metrics-owners"
+
+const metricsOwnerKey = "owners"
+
+// ownerLabelPolicy bounds the number of distinct values of the
+// metrics owner label
+type ownerLabelPolicy struct {
+	// Limit is the number of owners reported by name, including the top
+	// owners, 0 is unlimited
+	Limit int
+	// Buckets are shared by the owners beyond the limit
+	Buckets int
+	// Top owners are always reported by name, i.e. the largest tenants
+	Top map[string]bool
+}
+
+// metricsOwners records which owners were reported by name, in the
+// order they were first deployed, and which were hashed into a bucket
+type metricsOwners struct {
+	Named    []string `json:"named"`
+	Bucketed []string `json:"bucketed"`
+}
+
+// getOwnerLabelPolicy reads metrics_owner_limit, metrics_owner_buckets
+// (default 10) and metrics_owner_top, a comma-separated list of owners.
+func getOwnerLabelPolicy() ownerLabelPolicy {
+	policy := ownerLabelPolicy{
+		Buckets: 10,
+		Top:     map[string]bool{},
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("metrics_owner_limit")); err == nil && val > 0 {
+		policy.Limit = val
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("metrics_owner_buckets")); err == nil && val > 0 {
+		policy.Buckets = val
+	}
+
+	for _, owner := range strings.Split(os.Getenv("metrics_owner_top"), ",") {
+		owner = strings.ToLower(strings.TrimSpace(owner))
+		if len(owner) > 0 {
+			policy.Top[owner] = true
+		}
+	}
+
+	return policy
+}
+
+// Label returns the owner's name while fewer than Limit owners have been
+// named, so that an owner keeps its name once it has one. Owners beyond
+// the limit are hashed into one of the buckets, such as bucket-07. The
+// record is updated with a new owner, and true returned.
+func (p ownerLabelPolicy) Label(owner string, owners *metricsOwners) (string, bool) {
+	owner = strings.ToLower(owner)
+
+	if p.Limit == 0 || p.Top[owner] || contains(owners.Named, owner) {
+		return owner, false
+	}
+
+	if contains(owners.Bucketed, owner) {
+		return p.bucket(owner), false
+	}
+
+	named := len(p.Top)
+	for _, name := range owners.Named {
+		if !p.Top[name] {
+			named++
+		}
+	}
+
+	if named < p.Limit {
+		owners.Named = append(owners.Named, owner)
+		return owner, true
+	}
+
+	owners.Bucketed = append(owners.Bucketed, owner)
+	return p.bucket(owner), true
+}
+
+func (p ownerLabelPolicy) bucket(owner string) string {
+	buckets := p.Buckets
+	if buckets < 1 {
+		buckets = 1
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(owner))
+
+	return fmt.Sprintf("bucket-%02d", h.Sum32()%uint32(buckets))
+}
+
+// metricsOwner gives the label for the owner, recording a new owner in
+// pipeline-log. The owner is hashed into a bucket when the record can't
+// be read, so that the limit holds, and the record is left as it is.
+//
+// The record is read, changed and written back without a lock, so two
+// replicas recording new owners at the same time may each drop the
+// other's owner. A dropped owner is recorded again on its next
+// deployment, when it may be given a bucket in place of its name if the
+// limit has been reached since.
+func metricsOwner(owner string, gatewayURL string, payloadSecret string) string {
+	policy := getOwnerLabelPolicy()
+	if policy.Limit == 0 {
+		return strings.ToLower(owner)
+	}
+
+	owners, err := readMetricsOwners(gatewayURL)
+	if err != nil {
+		log.Printf("metrics-owner: unable to read owners: %s", err.Error())
+		if policy.Top[strings.ToLower(owner)] {
+			return strings.ToLower(owner)
+		}
+		return policy.bucket(strings.ToLower(owner))
+	}
+
+	label, changed := policy.Label(owner, owners)
+	if !changed {
+		return label
+	}
+
+	if err := writeMetricsOwners(owners, gatewayURL, payloadSecret); err != nil {
+		log.Printf("metrics-owner: unable to record %s: %s", owner, err.Error())
+	}
+
+	if err := pushMetrics("/metrics/job/buildshiprun/instance/metrics-owners", owners.exposition()); err != nil {
+		log.Printf("metrics-owner: unable to push metrics: %s", err.Error())
+	}
+
+	return label
+}
+
+// exposition renders the number of owners reported by name and under a
+// bucket in the Prometheus text format
+func (o metricsOwners) exposition() string {
+	return fmt.Sprintf(`# HELP ofc_metrics_owners_named Owners reported by name in the metrics-owner label.
+# TYPE ofc_metrics_owners_named gauge
+ofc_metrics_owners_named %d
+# HELP ofc_metrics_owners_bucketed_total Owners beyond metrics_owner_limit which were hashed into a bucket.
+# TYPE ofc_metrics_owners_bucketed_total counter
+ofc_metrics_owners_bucketed_total %d
+`, len(o.Named), len(o.Bucketed))
+}
+
+func readMetricsOwners(gatewayURL string) (*metricsOwners, error) {
+	body, err := sdk.ReadPipelineLog(gatewayURL, sdk.PipelineLog{
+		RepoPath:  metricsOwnerRepoPath,
+		CommitSHA: metricsOwnerKey,
+		Function:  "buildshiprun",
+		Source:    sdk.MetricsOwnerSource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	owners := metricsOwners{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &owners); err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %s", metricsOwnerKey, err.Error())
+		}
+	}
+	return &owners, nil
+}
+
+func writeMetricsOwners(owners *metricsOwners, gatewayURL string, payloadSecret string) error {
+	ownersBytes, _ := json.Marshal(owners)
+
+	return sdk.WritePipelineLog(gatewayURL, payloadSecret, sdk.PipelineLog{
+		RepoPath:  metricsOwnerRepoPath,
+		CommitSHA: metricsOwnerKey,
+		Function:  "buildshiprun",
+		Source:    sdk.MetricsOwnerSource,
+		Data:      string(ownersBytes),
+	})
+}
@@ -0,0 +1,140 @@
+package function
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_ownerLabelPolicy_Unlimited(t *testing.T) {
+	os.Unsetenv("metrics_owner_limit")
+	os.Unsetenv("metrics_owner_top")
+
+	if got, _ := getOwnerLabelPolicy().Label("AlexEllis", &metricsOwners{}); got != "alexellis" {
+		t.Errorf("want alexellis, got %s", got)
+	}
+}
+
+func Test_ownerLabelPolicy_BucketsBeyondLimit(t *testing.T) {
+	os.Setenv("metrics_owner_limit", "4")
+	os.Setenv("metrics_owner_buckets", "2")
+	os.Setenv("metrics_owner_top", "alexellis, openfaas")
+	defer os.Unsetenv("metrics_owner_limit")
+	defer os.Unsetenv("metrics_owner_buckets")
+	defer os.Unsetenv("metrics_owner_top")
+
+	policy := getOwnerLabelPolicy()
+	owners := &metricsOwners{}
+
+	if got, changed := policy.Label("openfaas", owners); got != "openfaas" || changed {
+		t.Errorf("want openfaas without a change to the record, got %s, %v", got, changed)
+	}
+
+	// The top owners count against the limit, leaving two names
+	for _, owner := range []string{"a", "b"} {
+		if got, changed := policy.Label(owner, owners); got != owner || !changed {
+			t.Errorf("want %s named, got %s, %v", owner, got, changed)
+		}
+	}
+
+	values := map[string]bool{}
+	for _, owner := range []string{"c", "d", "e", "f", "g", "h"} {
+		got, _ := policy.Label(owner, owners)
+		if !strings.HasPrefix(got, "bucket-") {
+			t.Errorf("want %s beyond the limit bucketed, got %s", owner, got)
+		}
+		values[got] = true
+	}
+
+	if len(values) > policy.Buckets {
+		t.Errorf("want at most %d buckets, got %d: %v", policy.Buckets, len(values), values)
+	}
+	if len(owners.Bucketed) != 6 {
+		t.Errorf("want 6 bucketed owners, got %v", owners.Bucketed)
+	}
+
+	if got, changed := policy.Label("A", owners); got != "a" || changed {
+		t.Errorf("want a named owner to keep its name, got %s, %v", got, changed)
+	}
+}
+
+func Test_ownerLabelPolicy_Stable(t *testing.T) {
+	policy := ownerLabelPolicy{Limit: 1, Buckets: 10, Top: map[string]bool{}}
+	owners := &metricsOwners{Named: []string{"alexellis"}}
+
+	first, _ := policy.Label("someone", owners)
+	second, changed := policy.Label("SomeOne", owners)
+	if first != second || changed {
+		t.Errorf("want the same bucket for the same owner, got %s, %s", first, second)
+	}
+}
+
+func Test_metricsOwner_RecordsOwners(t *testing.T) {
+	os.Setenv("metrics_owner_limit", "1")
+	defer os.Unsetenv("metrics_owner_limit")
+
+	var record string
+	var pushed string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/function/pipeline-log" && r.Method == http.MethodPost:
+			p := sdk.PipelineLog{}
+			json.Unmarshal(body, &p)
+			if p.Source != sdk.MetricsOwnerSource || p.RepoPath != metricsOwnerRepoPath {
+				t.Errorf("unexpected pipeline log %+v", p)
+			}
+			record = p.Data
+		case r.URL.Path == "/function/pipeline-log":
+			w.Write([]byte(record))
+		case r.Method == http.MethodPut:
+			pushed = string(body)
+		}
+	}))
+	defer s.Close()
+
+	os.Setenv("pushgateway_url", s.URL)
+	defer os.Unsetenv("pushgateway_url")
+
+	if got := metricsOwner("alexellis", s.URL+"/", "secret"); got != "alexellis" {
+		t.Errorf("want the first owner named, got %s", got)
+	}
+	if got := metricsOwner("someone", s.URL+"/", "secret"); !strings.HasPrefix(got, "bucket-") {
+		t.Errorf("want the owner beyond the limit bucketed, got %s", got)
+	}
+	if got := metricsOwner("alexellis", s.URL+"/", "secret"); got != "alexellis" {
+		t.Errorf("want the named owner kept, got %s", got)
+	}
+
+	if !strings.Contains(pushed, "ofc_metrics_owners_bucketed_total 1\n") {
+		t.Errorf("want the bucketed owner counted, got %q", pushed)
+	}
+}
+
+func Test_metricsOwner_UnreadableRecordIsKept(t *testing.T) {
+	os.Setenv("metrics_owner_limit", "1")
+	defer os.Unsetenv("metrics_owner_limit")
+
+	written := false
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			written = true
+			return
+		}
+		w.Write([]byte(`{"named": ["alexellis"`))
+	}))
+	defer s.Close()
+
+	if got := metricsOwner("someone", s.URL+"/", "secret"); !strings.HasPrefix(got, "bucket-") {
+		t.Errorf("want the owner bucketed when the record can't be parsed, got %s", got)
+	}
+	if written {
+		t.Errorf("want the record left as it is when it can't be parsed")
+	}
+}
@@ -0,0 +1,199 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// ownerNamespace creates the owner's namespace and copies the function's
+// secrets into it before a deployment. import-secrets writes the secrets
+// to function_namespace, and the gateway only deploys to a namespace
+// with the openfaas=1 annotation.
+type ownerNamespace struct {
+	BaseURL string
+	Token   string
+	// SecretsNamespace is where import-secrets writes the owner's secrets
+	SecretsNamespace string
+	Client           *http.Client
+}
+
+// getOwnerNamespace uses buildshiprun's service account, which needs to
+// get, create and patch namespaces and to get, create and update secrets
+// when owner_namespaces=true.
+func getOwnerNamespace() *ownerNamespace {
+	if !sdk.OwnerNamespaces() {
+		return nil
+	}
+
+	baseURL, token, client := inClusterKube()
+	return &ownerNamespace{
+		BaseURL:          baseURL,
+		Token:            token,
+		SecretsNamespace: getConfig("function_namespace", "openfaas-fn"),
+		Client:           client,
+	}
+}
+
+type kubeObject struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   kubeMetadata      `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+type kubeMetadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Prepare creates and annotates the namespace, then copies each of the
+// secrets into it. A secret which has not been imported is left for
+// missingSecrets to report.
+func (o *ownerNamespace) Prepare(namespace, owner string, secrets []string) error {
+	if err := o.ensureNamespace(namespace, owner); err != nil {
+		return err
+	}
+
+	for _, secret := range secrets {
+		if err := o.copySecret(secret, namespace); err != nil {
+			return fmt.Errorf("unable to copy secret %s: %s", secret, err.Error())
+		}
+	}
+	return nil
+}
+
+func (o *ownerNamespace) ensureNamespace(namespace, owner string) error {
+	existing := kubeObject{}
+	status, err := o.do(http.MethodGet, "/api/v1/namespaces/"+namespace, "", nil, &existing)
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusOK {
+		if existing.Metadata.Annotations["openfaas"] == "1" {
+			return nil
+		}
+
+		patch := map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": map[string]string{"openfaas": "1"}},
+		}
+		status, err = o.do(http.MethodPatch, "/api/v1/namespaces/"+namespace, "application/merge-patch+json", patch, nil)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("unable to annotate namespace %s: unexpected status code %d", namespace, status)
+		}
+		return nil
+	}
+
+	if status != http.StatusNotFound {
+		return fmt.Errorf("unable to get namespace %s: unexpected status code %d", namespace, status)
+	}
+
+	created := kubeObject{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Metadata: kubeMetadata{
+			Name:        namespace,
+			Labels:      map[string]string{"openfaas-cloud": "1", sdk.FunctionLabelPrefix + "git-owner": strings.ToLower(owner)},
+			Annotations: map[string]string{"openfaas": "1"},
+		},
+	}
+
+	status, err = o.do(http.MethodPost, "/api/v1/namespaces", "application/json", created, nil)
+	if err != nil {
+		return err
+	}
+	// Another build for the owner may have created it first
+	if status != http.StatusCreated && status != http.StatusConflict {
+		return fmt.Errorf("unable to create namespace %s: unexpected status code %d", namespace, status)
+	}
+	return nil
+}
+
+func (o *ownerNamespace) copySecret(name, namespace string) error {
+	source := kubeObject{}
+	status, err := o.do(http.MethodGet, fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", o.SecretsNamespace, name), "", nil, &source)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return nil
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from %s", status, o.SecretsNamespace)
+	}
+
+	secret := kubeObject{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: kubeMetadata{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{sdk.FunctionLabelPrefix + "copied-from": o.SecretsNamespace},
+		},
+		Type: source.Type,
+		Data: source.Data,
+	}
+
+	secretPath := fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", namespace, name)
+	status, err = o.do(http.MethodPut, secretPath, "application/json", secret, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		status, err = o.do(http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/secrets", namespace), "application/json", secret, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if status != http.StatusOK && status != http.StatusCreated {
+		return fmt.Errorf("unexpected status code %d from %s", status, namespace)
+	}
+	return nil
+}
+
+// do gives the status code of the request, so that the caller can
+// handle a missing or existing object, and decodes a 200 into result
+func (o *ownerNamespace) do(method, path, contentType string, value interface{}, result interface{}) (int, error) {
+	var body *bytes.Reader
+	if value != nil {
+		valueBytes, _ := json.Marshal(value)
+		body = bytes.NewReader(valueBytes)
+	} else {
+		body = bytes.NewReader([]byte{})
+	}
+
+	req, _ := http.NewRequest(method, o.BaseURL+path, body)
+	req.Header.Set("Accept", "application/json")
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if len(o.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+o.Token)
+	}
+
+	res, err := o.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	resBytes, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode == http.StatusOK && result != nil {
+		if err := json.Unmarshal(resBytes, result); err != nil {
+			return res.StatusCode, err
+		}
+	}
+	return res.StatusCode, nil
+}
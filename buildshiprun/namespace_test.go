@@ -0,0 +1,94 @@
+package function
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeKube stores the namespaces and secrets written by ownerNamespace
+type fakeKube struct {
+	objects  map[string]kubeObject
+	requests []string
+}
+
+func (k *fakeKube) handler(w http.ResponseWriter, r *http.Request) {
+	k.requests = append(k.requests, r.Method+" "+r.URL.Path)
+
+	switch r.Method {
+	case http.MethodGet:
+		object, ok := k.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(object)
+	case http.MethodPatch:
+		object := k.objects[r.URL.Path]
+		object.Metadata.Annotations = map[string]string{"openfaas": "1"}
+		k.objects[r.URL.Path] = object
+	case http.MethodPut:
+		if _, ok := k.objects[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		object := kubeObject{}
+		json.NewDecoder(r.Body).Decode(&object)
+		k.objects[r.URL.Path] = object
+	case http.MethodPost:
+		object := kubeObject{}
+		json.NewDecoder(r.Body).Decode(&object)
+		k.objects[r.URL.Path+"/"+object.Metadata.Name] = object
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func Test_ownerNamespace_Prepare(t *testing.T) {
+	kube := &fakeKube{objects: map[string]kubeObject{
+		"/api/v1/namespaces/openfaas-fn/secrets/alexellis-token": {
+			Metadata: kubeMetadata{Name: "alexellis-token", Namespace: "openfaas-fn"},
+			Type:     "Opaque",
+			Data:     map[string]string{"token": "c2VjcmV0"},
+		},
+	}}
+	s := httptest.NewServer(http.HandlerFunc(kube.handler))
+	defer s.Close()
+
+	o := &ownerNamespace{BaseURL: s.URL, SecretsNamespace: "openfaas-fn", Client: http.DefaultClient}
+	if err := o.Prepare("openfaas-fn-alexellis", "alexellis", []string{"alexellis-token", "alexellis-missing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	namespace, ok := kube.objects["/api/v1/namespaces/openfaas-fn-alexellis"]
+	if !ok || namespace.Metadata.Annotations["openfaas"] != "1" {
+		t.Errorf("want the namespace created with the openfaas annotation, got %v", namespace)
+	}
+
+	secret, ok := kube.objects["/api/v1/namespaces/openfaas-fn-alexellis/secrets/alexellis-token"]
+	if !ok || secret.Data["token"] != "c2VjcmV0" || secret.Metadata.Namespace != "openfaas-fn-alexellis" {
+		t.Errorf("want the secret copied into the owner's namespace, got %v", secret)
+	}
+	if _, ok := kube.objects["/api/v1/namespaces/openfaas-fn-alexellis/secrets/alexellis-missing"]; ok {
+		t.Errorf("want a secret which was not imported left for missingSecrets")
+	}
+
+	// A second build annotates an existing namespace and updates the copy
+	kube.objects["/api/v1/namespaces/openfaas-fn-alexellis"] = kubeObject{Metadata: kubeMetadata{Name: "openfaas-fn-alexellis"}}
+	kube.requests = nil
+
+	if err := o.Prepare("openfaas-fn-alexellis", "alexellis", []string{"alexellis-token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GET /api/v1/namespaces/openfaas-fn-alexellis",
+		"PATCH /api/v1/namespaces/openfaas-fn-alexellis",
+		"GET /api/v1/namespaces/openfaas-fn/secrets/alexellis-token",
+		"PUT /api/v1/namespaces/openfaas-fn-alexellis/secrets/alexellis-token",
+	}
+	if !reflect.DeepEqual(kube.requests, want) {
+		t.Errorf("want requests %v, got %v", want, kube.requests)
+	}
+}
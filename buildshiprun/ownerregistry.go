@@ -0,0 +1,82 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// dockerConfig is the subset of a Docker config.json holding the
+// credentials for each registry
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+}
+
+// getRegistryAuth gives the pull credentials for an owner's functions,
+// the platform's credentials are not sent to an owner's own registry
+func getRegistryAuth(owner string) string {
+	if len(sdk.OwnerRegistryURL(owner)) == 0 {
+		return getRegistryAuthSecret()
+	}
+
+	auth, err := getOwnerRegistryAuth(owner)
+	if err != nil {
+		log.Printf("Owner registry credentials for %s: %s", owner, err)
+	}
+	return auth
+}
+
+// getOwnerRegistryAuth gives the pull credentials for an owner who
+// brings their own registry, read from the owner's <owner>-registry-auth
+// secret. An empty string is returned when the owner has no secret, as
+// their registry may be public.
+func getOwnerRegistryAuth(owner string) (string, error) {
+	registry := sdk.OwnerRegistryURL(owner)
+	if len(registry) == 0 {
+		return "", nil
+	}
+
+	secretName := sdk.OwnerRegistryAuthSecret(owner)
+	val, err := sdk.ReadSecret(secretName)
+	if err != nil {
+		return "", nil
+	}
+
+	auth, err := registryAuthFromConfig([]byte(val), registry)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", secretName, err)
+	}
+	return auth, nil
+}
+
+// registryAuthFromConfig finds the base64 encoded username:password for
+// the host of the registry in a Docker config.json, which is the form
+// expected by the gateway for RegistryAuth
+func registryAuthFromConfig(data []byte, registry string) (string, error) {
+	cfg := dockerConfig{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", fmt.Errorf("invalid Docker config: %s", err)
+	}
+
+	host := registry
+	if index := strings.Index(host, "/"); index > -1 {
+		host = host[:index]
+	}
+
+	for server, entry := range cfg.Auths {
+		server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+		if index := strings.Index(server, "/"); index > -1 {
+			server = server[:index]
+		}
+		if server == host && len(entry.Auth) > 0 {
+			return entry.Auth, nil
+		}
+	}
+
+	return "", fmt.Errorf("no credentials for %s", host)
+}
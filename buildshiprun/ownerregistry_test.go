@@ -0,0 +1,59 @@
+package function
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_registryAuthFromConfig(t *testing.T) {
+	config := []byte(`{"auths": {"https://ghcr.io/v2/": {"auth": "dXNlcjpwYXNz"}, "quay.io": {"auth": "b3RoZXI6cGFzcw=="}}}`)
+
+	cases := []struct {
+		title    string
+		registry string
+		want     string
+		wantErr  bool
+	}{
+		{"server with scheme and path", "ghcr.io/alexellis", "dXNlcjpwYXNz", false},
+		{"plain host", "quay.io/ofc", "b3RoZXI6cGFzcw==", false},
+		{"missing host", "docker.io/alexellis", "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			got, err := registryAuthFromConfig(config, c.registry)
+			if (err != nil) != c.wantErr || got != c.want {
+				t.Errorf("want %q (error: %t), got %q (%v)", c.want, c.wantErr, got, err)
+			}
+		})
+	}
+}
+
+func Test_getOwnerRegistryAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "alexellis-registry-auth"), []byte(`{"auths": {"ghcr.io": {"auth": "dXNlcjpwYXNz"}}}`), 0600)
+
+	os.Setenv("secret_mount_path", dir)
+	os.Setenv("owner_registries", "alexellis=ghcr.io/alexellis,openfaas=quay.io/ofc")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("owner_registries")
+
+	if got, err := getOwnerRegistryAuth("alexellis"); err != nil || got != "dXNlcjpwYXNz" {
+		t.Errorf("want the owner's credentials, got %q (%v)", got, err)
+	}
+
+	if got, err := getOwnerRegistryAuth("openfaas"); err != nil || got != "" {
+		t.Errorf("want no credentials for a public registry, got %q (%v)", got, err)
+	}
+
+	if got, err := getOwnerRegistryAuth("stefanprodan"); err != nil || got != "" {
+		t.Errorf("want the platform's registry, got %q (%v)", got, err)
+	}
+}
@@ -0,0 +1,240 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// manifestFunction gives the name a function's manifest is stored under,
+// its name in stack.yml with any staging or preview suffix, i.e. fn1 for
+// alexellis-fn1
+func manifestFunction(owner, functionName string) string {
+	if sdk.OwnerNamespaces() {
+		return functionName
+	}
+	return strings.TrimPrefix(functionName, strings.ToLower(owner)+"-")
+}
+
+// ownerFunctions gives the functions labelled with the owner
+func ownerFunctions(ctx context.Context, client *faasSDK.Client, owner string) ([]types.FunctionStatus, error) {
+	functions, err := client.ListFunctions(ctx, sdk.OwnerNamespace(owner))
+	if err != nil {
+		return nil, err
+	}
+
+	owned := []types.FunctionStatus{}
+	for _, fn := range functions {
+		if fn.Labels != nil && strings.EqualFold((*fn.Labels)[sdk.FunctionLabelPrefix+"git-owner"], owner) {
+			owned = append(owned, fn)
+		}
+	}
+	return owned, nil
+}
+
+func isPaused(fn types.FunctionStatus) bool {
+	if fn.Annotations == nil {
+		return false
+	}
+	_, ok := (*fn.Annotations)[sdk.PausedAnnotation]
+	return ok
+}
+
+// pausedSpec copies the spec with the paused annotation, which the
+// router answers with a 403 for, and allows the function to scale to
+// zero
+func pausedSpec(spec *faasSDK.DeployFunctionSpec) *faasSDK.DeployFunctionSpec {
+	paused := *spec
+
+	paused.Labels = map[string]string{}
+	for k, v := range spec.Labels {
+		paused.Labels[k] = v
+	}
+	paused.Labels[zeroScaleLabel] = "true"
+
+	paused.Annotations = map[string]string{}
+	for k, v := range spec.Annotations {
+		paused.Annotations[k] = v
+	}
+	paused.Annotations[sdk.PausedAnnotation] = "true"
+
+	return &paused
+}
+
+// minReplicas reads com.openfaas.scale.min from the labels, a function
+// is resumed with this many replicas
+func minReplicas(labels map[string]string) int {
+	if val, err := strconv.Atoi(labels["com.openfaas.scale.min"]); err == nil && val > 0 {
+		return val
+	}
+	return 1
+}
+
+// setPaused redeploys a function from its signed manifest, adding the
+// paused annotation and scaling it to zero, or restoring the manifest's
+// spec and replicas to resume it. The manifest must match the image
+// which is deployed, as with promote.
+func setPaused(ctx context.Context, client *faasSDK.Client, fn types.FunctionStatus, gatewayURL string, payloadSecret string, paused bool) error {
+	labels := map[string]string{}
+	if fn.Labels != nil {
+		labels = *fn.Labels
+	}
+
+	owner := labels[sdk.FunctionLabelPrefix+"git-owner"]
+	repo := labels[sdk.FunctionLabelPrefix+"git-repo"]
+	sha := labels[sdk.FunctionLabelPrefix+"git-sha"]
+
+	manifest, err := readManifest(gatewayURL, owner+"/"+repo, sha, manifestFunction(owner, fn.Name))
+	if err != nil {
+		return err
+	}
+
+	if err := manifest.Verify(payloadSecret); err != nil {
+		return err
+	}
+
+	if manifest.Image != fn.Image {
+		return fmt.Errorf("%s is running %s, but its manifest is for %s", fn.Name, fn.Image, manifest.Image)
+	}
+
+	spec := &faasSDK.DeployFunctionSpec{}
+	if err := json.Unmarshal(manifest.Spec, spec); err != nil {
+		return fmt.Errorf("unable to read manifest for %s: %s", fn.Name, err.Error())
+	}
+	spec.RegistryAuth = getRegistryAuth(owner)
+
+	replicas := minReplicas(spec.Labels)
+	if paused {
+		spec = pausedSpec(spec)
+		replicas = 0
+	}
+
+	if _, _, err := deployFunction(ctx, client, spec, gatewayURL); err != nil {
+		return err
+	}
+
+	return scaleFunction(gatewayURL, spec.FunctionName, spec.Namespace, replicas)
+}
+
+// scaleFunction sets the replicas of a function through the gateway
+func scaleFunction(gatewayURL string, functionName string, namespace string, replicas int) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"serviceName": functionName,
+		"namespace":   namespace,
+		"replicas":    replicas,
+	})
+
+	scaleURL := gatewayURL + "system/scale-function/" + functionName
+	if len(namespace) > 0 {
+		scaleURL = scaleURL + "?namespace=" + url.QueryEscape(namespace)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, scaleURL, bytes.NewReader(body))
+	if err := sdk.AddGatewayAuth(req); err != nil {
+		return err
+	}
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+		resBody, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("unable to scale %s to %d: %d, %s", functionName, replicas, res.StatusCode, strings.TrimSpace(string(resBody)))
+	}
+
+	return nil
+}
+
+// pause handles ?action=pause and ?action=resume, the body is a
+// sdk.PauseRequest signed with the payload-secret, i.e. by github-event
+// when the owner's installation is suspended or unsuspended. Only the
+// functions on gateway_url are paused, not those on deploy_targets.
+func pause(req []byte, paused bool) sdk.Response {
+	pauseReq := sdk.PauseRequest{}
+	if err := json.Unmarshal(req, &pauseReq); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("pause: unable to parse request: %s", err.Error()))
+	}
+
+	if len(pauseReq.Owner) == 0 {
+		return sdk.Rejected(http.StatusBadRequest, "pause: owner is required")
+	}
+
+	payloadSecret, keyErr := sdk.ReadSecret("payload-secret")
+	if keyErr != nil {
+		return sdk.Failed(http.StatusInternalServerError, fmt.Sprintf("pause: failed to load hmac key, error %s", keyErr.Error()))
+	}
+
+	gatewayURL := os.Getenv("gateway_url")
+	gatewayTimeout := getGatewayTimeout()
+	client := faasSDK.NewClient(&FaaSAuth{}, gatewayURL, nil, &gatewayTimeout)
+	ctx := context.Background()
+
+	verb := "resumed"
+	if paused {
+		verb = "paused"
+	}
+
+	auditEvent := sdk.AuditEvent{
+		Owner:  pauseReq.Owner,
+		Source: "buildshiprun",
+	}
+
+	functions, err := ownerFunctions(ctx, client, pauseReq.Owner)
+	if err != nil {
+		auditEvent.Message = fmt.Sprintf("buildshiprun failure: unable to list functions for %s: %s", pauseReq.Owner, err.Error())
+		sdk.PostAudit(auditEvent)
+		return sdk.Failed(http.StatusBadGateway, auditEvent.Message)
+	}
+
+	done := []string{}
+	failed := []string{}
+	for _, fn := range functions {
+		if isPaused(fn) == paused {
+			continue
+		}
+
+		if err := setPaused(ctx, client, fn, gatewayURL, payloadSecret, paused); err != nil {
+			log.Printf("Unable to set %s %s: %s", fn.Name, verb, err.Error())
+			failed = append(failed, fmt.Sprintf("%s: %s", fn.Name, err.Error()))
+			continue
+		}
+		done = append(done, fn.Name)
+	}
+
+	msg := fmt.Sprintf("buildshiprun %s %d functions for %s", verb, len(done), pauseReq.Owner)
+	if len(pauseReq.Reason) > 0 {
+		msg = fmt.Sprintf("%s (%s)", msg, pauseReq.Reason)
+	}
+	if len(done) > 0 {
+		msg = fmt.Sprintf("%s: %s", msg, strings.Join(done, ", "))
+	}
+	if len(failed) > 0 {
+		msg = fmt.Sprintf("%s, failed: %s", msg, strings.Join(failed, "; "))
+	}
+
+	auditEvent.Message = msg
+	sdk.PostAudit(auditEvent)
+
+	if len(failed) > 0 {
+		return sdk.Failed(http.StatusBadGateway, msg)
+	}
+	return sdk.OK(msg)
+}
@@ -0,0 +1,154 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_manifestFunction(t *testing.T) {
+	if got := manifestFunction("AlexEllis", "alexellis-fn1-staging"); got != "fn1-staging" {
+		t.Errorf("want fn1-staging, got %s", got)
+	}
+}
+
+func Test_pausedSpec(t *testing.T) {
+	spec := &faasSDK.DeployFunctionSpec{
+		FunctionName: "alexellis-fn1",
+		Labels:       map[string]string{zeroScaleLabel: "false"},
+		Annotations:  map[string]string{"topic": "payments"},
+	}
+
+	paused := pausedSpec(spec)
+	if paused.Annotations[sdk.PausedAnnotation] != "true" || paused.Annotations["topic"] != "payments" {
+		t.Errorf("want the paused annotation added, got %v", paused.Annotations)
+	}
+	if paused.Labels[zeroScaleLabel] != "true" {
+		t.Errorf("want scale to zero allowed, got %v", paused.Labels)
+	}
+	if _, ok := spec.Annotations[sdk.PausedAnnotation]; ok || spec.Labels[zeroScaleLabel] != "false" {
+		t.Errorf("want the manifest's spec unchanged")
+	}
+}
+
+// pauseServer serves alexellis-fn1 running image, with its manifest, and
+// records the spec deployed and the replicas it is scaled to
+func pauseServer(t *testing.T, image string, annotations map[string]string, deployed *map[string]interface{}, scaled *map[string]interface{}) *httptest.Server {
+	manifestSpec := &faasSDK.DeployFunctionSpec{
+		FunctionName: "alexellis-fn1",
+		Image:        "registry:5000/alexellis/fn1:af6db",
+		Labels:       map[string]string{"faas_function": "alexellis-fn1", "com.openfaas.scale.min": "2"},
+		EnvVars:      map[string]string{"write_debug": "true"},
+	}
+	event := &sdk.Event{Owner: "alexellis", Repository: "kubecon-tester", SHA: "af6db", Service: "fn1"}
+	manifest, err := buildManifest(manifestSpec, event, "secret", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{
+		sdk.FunctionLabelPrefix + "git-owner": "alexellis",
+		sdk.FunctionLabelPrefix + "git-repo":  "kubecon-tester",
+		sdk.FunctionLabelPrefix + "git-sha":   "af6db",
+	}
+	fn := types.FunctionStatus{Name: "alexellis-fn1", Image: image, Labels: &labels, Annotations: &annotations}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/function/pipeline-log":
+			if r.URL.Query().Get("function") != "fn1" || r.URL.Query().Get("repoPath") != "alexellis/kubecon-tester" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(manifest)
+		case r.URL.Path == "/system/functions" && r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode([]types.FunctionStatus{fn})
+		case r.URL.Path == "/system/function/alexellis-fn1":
+			json.NewEncoder(w).Encode(fn)
+		case r.URL.Path == "/system/functions":
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, deployed)
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/system/scale-function/alexellis-fn1":
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, scaled)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func Test_setPaused(t *testing.T) {
+	image := "registry:5000/alexellis/fn1:af6db"
+
+	tests := []struct {
+		title        string
+		paused       bool
+		annotations  map[string]string
+		wantReplicas float64
+	}{
+		{title: "pause", paused: true, annotations: map[string]string{}, wantReplicas: 0},
+		{title: "resume", paused: false, annotations: map[string]string{sdk.PausedAnnotation: "true"}, wantReplicas: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			deployed := map[string]interface{}{}
+			scaled := map[string]interface{}{}
+
+			s := pauseServer(t, image, test.annotations, &deployed, &scaled)
+			defer s.Close()
+
+			client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+			functions, err := ownerFunctions(context.Background(), client, "alexellis")
+			if err != nil || len(functions) != 1 {
+				t.Fatalf("want alexellis-fn1 listed, got %v %v", functions, err)
+			}
+
+			if err := setPaused(context.Background(), client, functions[0], s.URL+"/", "secret", test.paused); err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+
+			annotations, _ := deployed["annotations"].(map[string]interface{})
+			if _, ok := annotations[sdk.PausedAnnotation]; ok != test.paused {
+				t.Errorf("want paused annotation %t, got %v", test.paused, annotations)
+			}
+			if envVars, _ := deployed["envVars"].(map[string]interface{}); envVars["write_debug"] != "true" {
+				t.Errorf("want the manifest's env-vars deployed, got %v", deployed["envVars"])
+			}
+			if scaled["replicas"] != test.wantReplicas {
+				t.Errorf("want %v replicas, got %v", test.wantReplicas, scaled["replicas"])
+			}
+		})
+	}
+}
+
+func Test_setPaused_ImageMismatch(t *testing.T) {
+	deployed := map[string]interface{}{}
+	scaled := map[string]interface{}{}
+
+	s := pauseServer(t, "registry:5000/alexellis/fn1:manual", map[string]string{}, &deployed, &scaled)
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	functions, _ := ownerFunctions(context.Background(), client, "alexellis")
+
+	err := setPaused(context.Background(), client, functions[0], s.URL+"/", "secret", true)
+	if err == nil || !strings.Contains(err.Error(), "but its manifest is for") {
+		t.Fatalf("want image mismatch error, got %v", err)
+	}
+	if len(deployed) > 0 || len(scaled) > 0 {
+		t.Errorf("want nothing changed, got %v %v", deployed, scaled)
+	}
+}
@@ -0,0 +1,64 @@
+package function
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// prebuiltImage matches an image reference with an optional tag or
+// digest, i.e. ghcr.io/alexellis/fn1:0.1.0
+var prebuiltImage = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._/:@-]*$`)
+
+// getPrebuiltRegistries reads prebuilt_registries, the registries or
+// repository prefixes from which functions with skip_build: true may
+// be deployed, i.e. "ghcr.io,registry.example.com/ofc". When empty
+// pre-built images are not allowed.
+func getPrebuiltRegistries() []string {
+	return splitList(os.Getenv("prebuilt_registries"))
+}
+
+// validatePrebuiltImage checks that a pre-built image is well formed and
+// is under the owner's namespace of an allowed registry, i.e.
+// "ghcr.io/alexellis/fn1" for alexellis with "ghcr.io". Pre-built images
+// are pulled with the platform's credentials, so an owner must not be
+// able to deploy another owner's private image. An owner who brings
+// their own registry may also deploy any image from it.
+func validatePrebuiltImage(image, owner string, registries []string) error {
+	if len(registries) == 0 {
+		return fmt.Errorf("pre-built images are not enabled, remove skip_build from stack.yml")
+	}
+
+	if !prebuiltImage.MatchString(image) || strings.Contains(image, "..") {
+		return fmt.Errorf("invalid pre-built image: %q", image)
+	}
+
+	if ownerRegistry := sdk.OwnerRegistryURL(owner); len(ownerRegistry) > 0 && strings.HasPrefix(image, ownerRegistry+"/") {
+		return nil
+	}
+
+	namespace := strings.ToLower(owner) + "/"
+	allowed := []string{}
+	for _, registry := range registries {
+		prefix := strings.TrimSuffix(registry, "/") + "/" + namespace
+		if strings.HasPrefix(image, prefix) {
+			return nil
+		}
+		allowed = append(allowed, prefix)
+	}
+
+	return fmt.Errorf("pre-built image %s is not from an allowed registry: %s", image, strings.Join(allowed, ", "))
+}
+
+// prebuiltResult stands in for the builder's result when the image was
+// built elsewhere, such as in the user's CI
+func prebuiltResult(event *sdk.Event) sdk.BuildResult {
+	return sdk.BuildResult{
+		ImageName: event.Image,
+		Status:    "skipped build, pre-built image",
+		Log:       []string{fmt.Sprintf("skip_build: deploying pre-built image %s", event.Image)},
+	}
+}
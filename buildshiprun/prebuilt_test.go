@@ -0,0 +1,121 @@
+package function
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_validatePrebuiltImage(t *testing.T) {
+	registries := []string{"ghcr.io", "registry.example.com/ofc/"}
+
+	cases := []struct {
+		title      string
+		image      string
+		owner      string
+		registries []string
+		wantErr    string
+	}{
+		{
+			title:      "owner's namespace",
+			image:      "ghcr.io/alexellis/fn1:0.1.0",
+			owner:      "alexellis",
+			registries: registries,
+		},
+		{
+			title:      "prefix with a trailing slash",
+			image:      "registry.example.com/ofc/alexellis/figlet:latest",
+			owner:      "AlexEllis",
+			registries: registries,
+		},
+		{
+			title:      "another owner's image",
+			image:      "ghcr.io/openfaas/fn1:0.1.0",
+			owner:      "alexellis",
+			registries: registries,
+			wantErr:    "not from an allowed registry: ghcr.io/alexellis/, registry.example.com/ofc/alexellis/",
+		},
+		{
+			title:      "namespace matches at a path boundary",
+			image:      "ghcr.io/alexellis-fork/fn1:0.1.0",
+			owner:      "alexellis",
+			registries: registries,
+			wantErr:    "not from an allowed registry",
+		},
+		{
+			title:      "other registry",
+			image:      "quay.io/alexellis/fn1:0.1.0",
+			owner:      "alexellis",
+			registries: registries,
+			wantErr:    "not from an allowed registry",
+		},
+		{
+			title:      "parent path",
+			image:      "ghcr.io/alexellis/../openfaas/fn1:0.1.0",
+			owner:      "alexellis",
+			registries: registries,
+			wantErr:    "invalid pre-built image",
+		},
+		{
+			title:   "disabled",
+			image:   "ghcr.io/alexellis/fn1:0.1.0",
+			owner:   "alexellis",
+			wantErr: "pre-built images are not enabled",
+		},
+		{
+			title:      "invalid image",
+			image:      "ghcr.io/alexellis/fn1:0.1.0 --privileged",
+			owner:      "alexellis",
+			registries: registries,
+			wantErr:    "invalid pre-built image",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			err := validatePrebuiltImage(c.image, c.owner, c.registries)
+			if len(c.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("want no error, got %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("want error %q, got %v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validatePrebuiltImage_OwnerRegistry(t *testing.T) {
+	os.Setenv("owner_registries", "alexellis=quay.io/alex")
+	defer os.Unsetenv("owner_registries")
+
+	if err := validatePrebuiltImage("quay.io/alex/fn1:0.1.0", "alexellis", []string{"ghcr.io"}); err != nil {
+		t.Errorf("want an image from the owner's own registry allowed, got %s", err)
+	}
+	if err := validatePrebuiltImage("quay.io/alex/fn1:0.1.0", "openfaas", []string{"ghcr.io"}); err == nil {
+		t.Errorf("want another owner's registry refused")
+	}
+}
+
+func Test_getEventFromEnv_SkipBuild(t *testing.T) {
+	os.Setenv("Http_Skip_Build", "true")
+	os.Setenv("Http_Image", "ghcr.io/alexellis/fn1:0.1.0")
+	defer os.Unsetenv("Http_Skip_Build")
+	defer os.Unsetenv("Http_Image")
+
+	event, err := getEventFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !event.SkipBuild {
+		t.Errorf("want skip build read from header")
+	}
+
+	result := prebuiltResult(event)
+	if result.ImageName != "ghcr.io/alexellis/fn1:0.1.0" {
+		t.Errorf("want the pre-built image deployed as-is, got %s", result.ImageName)
+	}
+}
@@ -0,0 +1,61 @@
+package function
+
+import (
+	"strconv"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// isPreview is true when the event is the head of a pull request, which
+// is deployed alongside the production function until it is closed
+func isPreview(event *sdk.Event) bool {
+	return event.PullRequest > 0
+}
+
+// applyPreview renames the function of a preview, i.e. fn1-pr-12, so
+// that it is served on its own URL and its commit status, comment and
+// manifest are kept apart from the production function's
+func applyPreview(event *sdk.Event) {
+	if !isPreview(event) {
+		return
+	}
+
+	event.Service = event.Service + sdk.PreviewSuffix(event.PullRequest)
+}
+
+// previewLabels records the pull request of a preview for
+// garbage-collect to remove the function when the pull request closes
+func previewLabels(labels map[string]string, event *sdk.Event) {
+	if isPreview(event) {
+		labels[sdk.PullRequestLabel] = strconv.Itoa(event.PullRequest)
+	}
+}
+
+// previewIgnoredLabels always differ between a preview and the
+// production function
+var previewIgnoredLabels = map[string]bool{
+	"faas_function":                            true,
+	"app":                                      true,
+	sdk.PullRequestLabel:                       true,
+	sdk.FunctionLabelPrefix + "git-sha":        true,
+	sdk.FunctionLabelPrefix + "git-branch":     true,
+	sdk.FunctionLabelPrefix + "git-deploytime": true,
+}
+
+// diffPreview compares a preview with the production function, so that
+// the pull request comment shows the labels, limits, env-vars and
+// secrets the change brings along with its code. The image always
+// differs so is left out.
+func diffPreview(production *deployedSpec, preview *faasSDK.DeployFunctionSpec) sdk.DeployDiff {
+	drift := sdk.DeployDiff{Changes: []sdk.DeployChange{}}
+
+	for _, change := range diffDeployment(production, preview).Changes {
+		if change.Kind == sdk.ImageChange || (change.Kind == sdk.LabelChange && previewIgnoredLabels[change.Name]) {
+			continue
+		}
+		drift.Changes = append(drift.Changes, change)
+	}
+
+	return drift
+}
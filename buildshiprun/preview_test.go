@@ -0,0 +1,76 @@
+package function
+
+import (
+	"os"
+	"testing"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-cli/stack"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_applyPreview(t *testing.T) {
+	event := &sdk.Event{Service: "fn1", Branch: "staging", PullRequest: 12}
+	labels := map[string]string{}
+
+	applyPreview(event)
+	previewLabels(labels, event)
+
+	if event.Service != "fn1-pr-12" {
+		t.Errorf("want fn1-pr-12, got %s", event.Service)
+	}
+	if labels[sdk.PullRequestLabel] != "12" {
+		t.Errorf("want the pull request label, got %v", labels)
+	}
+
+	os.Setenv("staging_branch", "staging")
+	defer os.Unsetenv("staging_branch")
+	if isStaging(event) {
+		t.Errorf("want a pull request from the staging branch deployed as a preview")
+	}
+}
+
+func Test_applyPreview_Push(t *testing.T) {
+	event := &sdk.Event{Service: "fn1", Branch: "master"}
+	labels := map[string]string{}
+
+	applyPreview(event)
+	previewLabels(labels, event)
+
+	if event.Service != "fn1" || len(labels) != 0 {
+		t.Errorf("want a push left unchanged, got %s %v", event.Service, labels)
+	}
+}
+
+func Test_diffPreview(t *testing.T) {
+	production := &deployedSpec{
+		Image: "registry:5000/alexellis-fn1:latest-6df8c47",
+		Labels: map[string]string{
+			"faas_function":                     "alexellis-fn1",
+			"com.openfaas.scale.max":            "4",
+			sdk.FunctionLabelPrefix + "git-sha": "6df8c47",
+		},
+		Secrets: []string{"alexellis-api-key"},
+		Limits:  &stack.FunctionResources{Memory: "128Mi"},
+	}
+	preview := &faasSDK.DeployFunctionSpec{
+		Image: "registry:5000/alexellis-fn1:latest-a1b2c3d",
+		Labels: map[string]string{
+			"faas_function":                     "alexellis-fn1-pr-12",
+			"com.openfaas.scale.max":            "10",
+			sdk.FunctionLabelPrefix + "git-sha": "a1b2c3d",
+			sdk.PullRequestLabel:                "12",
+		},
+		Secrets: []string{"alexellis-api-key", "alexellis-db"},
+		FunctionResourceRequest: faasSDK.FunctionResourceRequest{
+			Limits: &stack.FunctionResources{Memory: "256Mi", CPU: "500m"},
+		},
+	}
+
+	drift := diffPreview(production, preview)
+
+	want := "label ~com.openfaas.scale.max, secret +alexellis-db, limit +cpu ~memory"
+	if got := formatDiff(drift); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,215 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// promotedFromLabel records the staging function a production
+// deployment was promoted from
+const promotedFromLabel = sdk.FunctionLabelPrefix + "promoted-from"
+
+// stagingBranch is deployed with a -staging suffix so that its image
+// can be promoted to production without a second build, set with
+// staging_branch. Staging is disabled when it is empty.
+func stagingBranch() string {
+	return os.Getenv("staging_branch")
+}
+
+// isStaging is true when the event was pushed to the staging branch. A
+// staging branch with a deploy target keeps the function's own name, as
+// it runs on a separate gateway. A pull request from the staging branch
+// is a preview.
+func isStaging(event *sdk.Event) bool {
+	if isPreview(event) {
+		return false
+	}
+
+	branch := stagingBranch()
+	if _, ok := getDeployTarget(branch); ok {
+		return false
+	}
+	return len(branch) > 0 && event.Branch == branch && branch != buildBranch()
+}
+
+// deployBranch is the branch recorded on the function's labels
+func deployBranch(event *sdk.Event) string {
+	if len(event.Branch) > 0 {
+		return event.Branch
+	}
+	return buildBranch()
+}
+
+// readManifest fetches the signed manifest recorded for a function at
+// a given SHA from pipeline-log
+func readManifest(gatewayURL string, repoPath string, sha string, function string) (*sdk.DeploymentManifest, error) {
+	body, err := sdk.ReadPipelineLog(gatewayURL, sdk.PipelineLog{
+		RepoPath:  repoPath,
+		CommitSHA: sha,
+		Function:  function,
+		Source:    sdk.ManifestSource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := sdk.DeploymentManifest{}
+	if err := json.Unmarshal(body, &manifest); err != nil || len(manifest.Spec) == 0 {
+		return nil, fmt.Errorf("no manifest found for %s %s@%s", function, repoPath, sha)
+	}
+
+	return &manifest, nil
+}
+
+// promotedSpec copies the staging spec, renaming the function to its
+// production name. The image is left untouched so that production
+// runs exactly the artifact which was tested in staging.
+func promotedSpec(staging *faasSDK.DeployFunctionSpec, functionName string) *faasSDK.DeployFunctionSpec {
+	spec := *staging
+	spec.FunctionName = functionName
+	spec.Update = false
+	spec.RegistryAuth = getRegistryAuth(staging.Labels[sdk.FunctionLabelPrefix+"git-owner"])
+
+	spec.Labels = map[string]string{}
+	for k, v := range staging.Labels {
+		spec.Labels[k] = v
+	}
+	spec.Labels["faas_function"] = functionName
+	spec.Labels["app"] = functionName
+	spec.Labels[sdk.FunctionLabelPrefix+"git-deploytime"] = strconv.FormatInt(clock.Now().Unix(), 10)
+	spec.Labels[promotedFromLabel] = staging.FunctionName
+
+	return &spec
+}
+
+// promoteFunction redeploys the image currently serving in staging to
+// production using the spec from its signed manifest. The manifest must
+// match the staging image, so that a staging function changed outside
+// of the pipeline cannot be promoted.
+func promoteFunction(ctx context.Context, client *faasSDK.Client, promoteReq sdk.PromoteRequest, gatewayURL string, payloadSecret string, check healthCheck) (*faasSDK.DeployFunctionSpec, *sdk.DeploymentManifest, error) {
+	serviceValue := sdk.FunctionName(promoteReq.Owner, promoteReq.Function)
+	namespace := sdk.OwnerNamespace(promoteReq.Owner)
+	stagingName := serviceValue + sdk.StagingSuffix
+
+	staging := lastDeployment(ctx, client, stagingName, namespace)
+	if staging == nil {
+		return nil, nil, fmt.Errorf("%s has not been deployed", stagingName)
+	}
+
+	manifest, err := readManifest(gatewayURL, promoteReq.Owner+"/"+promoteReq.Repo, staging.SHA, promoteReq.Function+sdk.StagingSuffix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := manifest.Verify(payloadSecret); err != nil {
+		return nil, nil, err
+	}
+
+	if manifest.Image != staging.Image {
+		return nil, nil, fmt.Errorf("%s is running %s, but its manifest is for %s", stagingName, staging.Image, manifest.Image)
+	}
+
+	stagingSpec := faasSDK.DeployFunctionSpec{}
+	if err := json.Unmarshal(manifest.Spec, &stagingSpec); err != nil {
+		return nil, nil, fmt.Errorf("unable to read manifest for %s: %s", stagingName, err.Error())
+	}
+
+	spec := promotedSpec(&stagingSpec, serviceValue)
+	repoPath := promoteReq.Owner + "/" + promoteReq.Repo
+	previous := previousDeployment(gatewayURL, repoPath, serviceValue, lastDeployment(ctx, client, serviceValue, namespace), spec.Image)
+
+	_, attempts, err := deployFunction(ctx, client, spec, gatewayURL)
+	if err == nil {
+		err = verifyDeployment(ctx, client, serviceValue, namespace, spec.Image, gatewayURL, check)
+	}
+
+	if err == nil {
+		deployed := deployRecord{Image: spec.Image, SHA: staging.SHA, Branch: buildBranch(), Deployed: clock.Now()}
+		if historyErr := recordDeployment(gatewayURL, payloadSecret, repoPath, serviceValue, deployed); historyErr != nil {
+			log.Printf("deploy-history: error: %s", historyErr.Error())
+		}
+	}
+
+	if err != nil {
+		msg := fmt.Sprintf("%s, %s", err.Error(), formatAttempts(attempts))
+		if previous != nil {
+			if rollbackErr := rollback(ctx, client, spec, previous, gatewayURL); rollbackErr != nil {
+				log.Printf(rollbackErr.Error())
+			} else {
+				msg = fmt.Sprintf("%s, rolled back to %s", msg, previous.Image)
+			}
+		}
+		return nil, nil, fmt.Errorf("promotion of %s failed: %s", stagingName, msg)
+	}
+
+	return spec, manifest, nil
+}
+
+// promote handles ?action=promote, the body is a sdk.PromoteRequest
+// signed with the payload-secret, i.e. by the dashboard or an operator
+func promote(req []byte) sdk.Response {
+	promoteReq := sdk.PromoteRequest{}
+	if err := json.Unmarshal(req, &promoteReq); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("promote: unable to parse request: %s", err.Error()))
+	}
+
+	if len(promoteReq.Owner) == 0 || len(promoteReq.Repo) == 0 || len(promoteReq.Function) == 0 {
+		return sdk.Rejected(http.StatusBadRequest, "promote: owner, repo and function are required")
+	}
+
+	if reason := deployFreeze(); len(reason) > 0 {
+		return sdk.Rejected(http.StatusLocked, fmt.Sprintf("promote: deploys are frozen: %s", reason))
+	}
+
+	payloadSecret, keyErr := sdk.ReadSecret("payload-secret")
+	if keyErr != nil {
+		return sdk.Failed(http.StatusInternalServerError, fmt.Sprintf("promote: failed to load hmac key, error %s", keyErr.Error()))
+	}
+
+	gatewayURL := os.Getenv("gateway_url")
+	gatewayTimeout := getGatewayTimeout()
+	client := faasSDK.NewClient(&FaaSAuth{}, gatewayURL, nil, &gatewayTimeout)
+
+	auditEvent := sdk.AuditEvent{
+		Owner:  promoteReq.Owner,
+		Repo:   promoteReq.Repo,
+		Source: "buildshiprun",
+	}
+
+	spec, manifest, err := promoteFunction(context.Background(), client, promoteReq, gatewayURL, payloadSecret, getHealthCheck())
+	if err != nil {
+		auditEvent.Message = fmt.Sprintf("buildshiprun failure: %s", err.Error())
+		sdk.PostAudit(auditEvent)
+
+		failed := sdk.Event{Owner: promoteReq.Owner, Repository: promoteReq.Repo, Service: promoteReq.Function}
+		sdk.PublishDeploymentEvent(deploymentEvent(sdk.DeployFailedEvent, failed, "", auditEvent.Message))
+		return sdk.Failed(http.StatusBadGateway, auditEvent.Message)
+	}
+
+	event := &sdk.Event{
+		Owner:      promoteReq.Owner,
+		Repository: promoteReq.Repo,
+		SHA:        manifest.SHA,
+		Service:    promoteReq.Function,
+	}
+
+	if signature, manifestErr := recordManifest(spec, event, gatewayURL, payloadSecret); manifestErr != nil {
+		log.Printf("deploy-manifest: error: %s", manifestErr.Error())
+	} else {
+		log.Printf("deploy-manifest: %s", signature)
+	}
+
+	auditEvent.Message = fmt.Sprintf("buildshiprun succeeded: promoted %s to %s, image: %s", spec.Labels[promotedFromLabel], spec.FunctionName, spec.Image)
+	sdk.PostAudit(auditEvent)
+	sdk.PublishDeploymentEvent(deploymentEvent(sdk.FunctionDeployedEvent, *event, spec.Image, auditEvent.Message))
+
+	return sdk.OK(auditEvent.Message)
+}
@@ -0,0 +1,196 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_isStaging(t *testing.T) {
+	os.Setenv("staging_branch", "staging")
+	defer os.Unsetenv("staging_branch")
+
+	if !isStaging(&sdk.Event{Branch: "staging"}) {
+		t.Errorf("want a push to staging to deploy to staging")
+	}
+	if isStaging(&sdk.Event{Branch: "master"}) {
+		t.Errorf("want a push to master not to deploy to staging")
+	}
+}
+
+func Test_isStaging_Disabled(t *testing.T) {
+	os.Unsetenv("staging_branch")
+
+	if isStaging(&sdk.Event{Branch: ""}) {
+		t.Errorf("want staging disabled when staging_branch is unset")
+	}
+}
+
+// fixedClock is an sdk.Clock stopped at a point in time
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+func Test_promotedSpec(t *testing.T) {
+	clock = fixedClock(time.Unix(1570000100, 0))
+	defer func() { clock = sdk.SystemClock{} }()
+
+	staging := &faasSDK.DeployFunctionSpec{
+		FunctionName: "alexellis-fn1-staging",
+		Image:        "registry:5000/alexellis/fn1:staging-af6db",
+		Labels: map[string]string{
+			"faas_function":                     "alexellis-fn1-staging",
+			"app":                               "alexellis-fn1-staging",
+			sdk.FunctionLabelPrefix + "git-sha": "af6db",
+		},
+		Update: true,
+	}
+
+	spec := promotedSpec(staging, "alexellis-fn1")
+
+	if spec.FunctionName != "alexellis-fn1" {
+		t.Errorf("want alexellis-fn1, got %s", spec.FunctionName)
+	}
+	if spec.Image != staging.Image {
+		t.Errorf("want image %s, got %s", staging.Image, spec.Image)
+	}
+	if spec.Labels["faas_function"] != "alexellis-fn1" || spec.Labels["app"] != "alexellis-fn1" {
+		t.Errorf("want faas_function and app labels renamed, got %v", spec.Labels)
+	}
+	if spec.Labels[promotedFromLabel] != "alexellis-fn1-staging" {
+		t.Errorf("want promoted-from label, got %q", spec.Labels[promotedFromLabel])
+	}
+	if got := spec.Labels[sdk.FunctionLabelPrefix+"git-deploytime"]; got != "1570000100" {
+		t.Errorf("want the deploy time from the clock, got %q", got)
+	}
+	if staging.Labels["faas_function"] != "alexellis-fn1-staging" {
+		t.Errorf("want staging labels unchanged")
+	}
+}
+
+func promoteServer(t *testing.T, stagingImage string, manifest *sdk.DeploymentManifest, got *map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/function/pipeline-log":
+			if r.URL.Query().Get("function") != "fn1-staging" || r.URL.Query().Get("source") != sdk.ManifestSource {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(manifest)
+		case r.URL.Path == "/system/function/alexellis-fn1-staging":
+			labels := map[string]string{sdk.FunctionLabelPrefix + "git-sha": "af6db"}
+			json.NewEncoder(w).Encode(types.FunctionStatus{
+				Name:              "alexellis-fn1-staging",
+				Image:             stagingImage,
+				Labels:            &labels,
+				AvailableReplicas: 1,
+			})
+		case r.URL.Path == "/system/function/alexellis-fn1":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/system/functions" && r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode([]types.FunctionStatus{})
+		case r.URL.Path == "/system/functions":
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, got)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func stagingManifest(t *testing.T, image string) *sdk.DeploymentManifest {
+	spec := &faasSDK.DeployFunctionSpec{
+		FunctionName: "alexellis-fn1-staging",
+		Image:        image,
+		Labels:       map[string]string{"faas_function": "alexellis-fn1-staging"},
+		EnvVars:      map[string]string{"write_debug": "true"},
+	}
+	event := &sdk.Event{Owner: "alexellis", Repository: "kubecon-tester", SHA: "af6db", Service: "fn1"}
+
+	manifest, err := buildManifest(spec, event, "secret", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func Test_promoteFunction_DeploysStagingImage(t *testing.T) {
+	image := "registry:5000/alexellis/fn1:staging-af6db"
+	got := map[string]interface{}{}
+
+	s := promoteServer(t, image, stagingManifest(t, image), &got)
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	req := sdk.PromoteRequest{Owner: "alexellis", Repo: "kubecon-tester", Function: "fn1"}
+
+	spec, manifest, err := promoteFunction(context.Background(), client, req, s.URL+"/", "secret", healthCheck{Enabled: false})
+	if err != nil {
+		t.Fatalf("promote error: %s", err)
+	}
+
+	if spec.FunctionName != "alexellis-fn1" {
+		t.Errorf("want alexellis-fn1, got %s", spec.FunctionName)
+	}
+	if manifest.SHA != "af6db" {
+		t.Errorf("want SHA af6db, got %s", manifest.SHA)
+	}
+	if got["image"] != image {
+		t.Errorf("want image %s deployed, got %v", image, got["image"])
+	}
+	if got["service"] != "alexellis-fn1" {
+		t.Errorf("want service alexellis-fn1, got %v", got["service"])
+	}
+}
+
+func Test_promoteFunction_ImageMismatch(t *testing.T) {
+	got := map[string]interface{}{}
+
+	s := promoteServer(t, "registry:5000/alexellis/fn1:manual", stagingManifest(t, "registry:5000/alexellis/fn1:staging-af6db"), &got)
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	req := sdk.PromoteRequest{Owner: "alexellis", Repo: "kubecon-tester", Function: "fn1"}
+
+	_, _, err := promoteFunction(context.Background(), client, req, s.URL+"/", "secret", healthCheck{Enabled: false})
+	if err == nil || !strings.Contains(err.Error(), "but its manifest is for") {
+		t.Fatalf("want image mismatch error, got %v", err)
+	}
+	if len(got) > 0 {
+		t.Errorf("want nothing deployed, got %v", got)
+	}
+}
+
+func Test_promoteFunction_TamperedManifest(t *testing.T) {
+	image := "registry:5000/alexellis/fn1:staging-af6db"
+	got := map[string]interface{}{}
+
+	manifest := stagingManifest(t, image)
+	manifest.Spec = json.RawMessage(`{"FunctionName":"alexellis-fn1-staging","Image":"evil/image"}`)
+
+	s := promoteServer(t, image, manifest, &got)
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	req := sdk.PromoteRequest{Owner: "alexellis", Repo: "kubecon-tester", Function: "fn1"}
+
+	_, _, err := promoteFunction(context.Background(), client, req, s.URL+"/", "secret", healthCheck{Enabled: false})
+	if err == nil || !strings.Contains(err.Error(), "tampered") {
+		t.Fatalf("want tampered manifest error, got %v", err)
+	}
+	if len(got) > 0 {
+		t.Errorf("want nothing deployed, got %v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// getFunctionQuota returns the maximum number of functions an owner
+// may deploy, from function_quotas i.e. "alexellis=20,openfaas=100",
+// then function_quota for every other owner. Zero means unlimited.
+func getFunctionQuota(owner string) int {
+	for _, pair := range strings.Split(os.Getenv("function_quotas"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), owner) {
+			if quota, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil && quota >= 0 {
+				return quota
+			}
+		}
+	}
+
+	if quota, err := strconv.Atoi(os.Getenv("function_quota")); err == nil && quota >= 0 {
+		return quota
+	}
+
+	return 0
+}
+
+// checkFunctionQuota counts the functions labelled with the owner and
+// returns an error when deploying a new function would exceed the
+// quota. Updates to an existing function are always allowed.
+func checkFunctionQuota(ctx context.Context, client *faasSDK.Client, owner string, functionName string, namespace string, quota int) error {
+	if quota <= 0 {
+		return nil
+	}
+
+	functions, err := client.ListFunctions(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, fn := range functions {
+		if fn.Name == functionName {
+			return nil
+		}
+
+		if fn.Labels != nil && strings.EqualFold((*fn.Labels)[sdk.FunctionLabelPrefix+"git-owner"], owner) {
+			count++
+		}
+	}
+
+	if count >= quota {
+		return fmt.Errorf("function quota exceeded: %d of %d functions deployed for %s", count, quota, owner)
+	}
+
+	return nil
+}
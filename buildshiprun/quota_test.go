@@ -0,0 +1,83 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_getFunctionQuota(t *testing.T) {
+	os.Setenv("function_quota", "5")
+	os.Setenv("function_quotas", "alexellis=20, openfaas=0")
+	defer os.Unsetenv("function_quota")
+	defer os.Unsetenv("function_quotas")
+
+	tests := []struct {
+		owner string
+		want  int
+	}{
+		{"alexellis", 20},
+		{"AlexEllis", 20},
+		{"openfaas", 0},
+		{"rgee0", 5},
+	}
+
+	for _, test := range tests {
+		if got := getFunctionQuota(test.owner); got != test.want {
+			t.Errorf("%s: want %d, got %d", test.owner, test.want, got)
+		}
+	}
+}
+
+func quotaServer() *httptest.Server {
+	owned := map[string]string{sdk.FunctionLabelPrefix + "git-owner": "alexellis"}
+	other := map[string]string{sdk.FunctionLabelPrefix + "git-owner": "openfaas"}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]types.FunctionStatus{
+			{Name: "alexellis-fn1", Labels: &owned},
+			{Name: "alexellis-fn2", Labels: &owned},
+			{Name: "openfaas-fn1", Labels: &other},
+		})
+	}))
+}
+
+func Test_checkFunctionQuota(t *testing.T) {
+	s := quotaServer()
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	ctx := context.Background()
+
+	tests := []struct {
+		title    string
+		function string
+		quota    int
+		wantErr  bool
+	}{
+		{"unlimited", "alexellis-fn3", 0, false},
+		{"under quota", "alexellis-fn3", 3, false},
+		{"new function at quota", "alexellis-fn3", 2, true},
+		{"update at quota", "alexellis-fn1", 2, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			err := checkFunctionQuota(ctx, client, "alexellis", test.function, "", test.quota)
+			if test.wantErr != (err != nil) {
+				t.Fatalf("want error: %t, got %v", test.wantErr, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "2 of 2 functions deployed for alexellis") {
+				t.Errorf("want a message with the count and quota, got %s", err.Error())
+			}
+		})
+	}
+}
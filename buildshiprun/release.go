@@ -0,0 +1,32 @@
+package function
+
+import (
+	"os"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// tagDeployTarget is the deploy target for functions built from a git
+// tag, i.e. production, set with tag_deploy_target. Tags are deployed to
+// gateway_url when it is unset.
+func tagDeployTarget() string {
+	return os.Getenv("tag_deploy_target")
+}
+
+// deployTargetName selects the entry of deploy_targets for the event,
+// the tag_deploy_target for a tag or the branch otherwise
+func deployTargetName(event *sdk.Event) string {
+	if len(event.Tag) > 0 {
+		if target := tagDeployTarget(); len(target) > 0 {
+			return target
+		}
+	}
+	return deployBranch(event)
+}
+
+// tagLabels records the git tag a function was deployed from
+func tagLabels(labels map[string]string, event *sdk.Event) {
+	if len(event.Tag) > 0 {
+		labels[sdk.TagLabel] = event.Tag
+	}
+}
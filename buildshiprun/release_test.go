@@ -0,0 +1,55 @@
+package function
+
+import (
+	"os"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_deployTargetName(t *testing.T) {
+	os.Setenv("tag_deploy_target", "production")
+	defer os.Unsetenv("tag_deploy_target")
+
+	if got := deployTargetName(&sdk.Event{Branch: "master", Tag: "v1.2.0"}); got != "production" {
+		t.Errorf("want a tag deployed to production, got %q", got)
+	}
+
+	if got := deployTargetName(&sdk.Event{Branch: "master"}); got != "master" {
+		t.Errorf("want a branch deployed to its own target, got %q", got)
+	}
+}
+
+func Test_deployTargetName_Unset(t *testing.T) {
+	os.Unsetenv("tag_deploy_target")
+
+	if got := deployTargetName(&sdk.Event{Branch: "master", Tag: "v1.2.0"}); got != "master" {
+		t.Errorf("want the branch's target without a tag_deploy_target, got %q", got)
+	}
+}
+
+func Test_functionContext_Tag(t *testing.T) {
+	os.Setenv("deploy_targets", "production=https://gateway.example.com/")
+	os.Setenv("tag_deploy_target", "production")
+	defer os.Unsetenv("deploy_targets")
+	defer os.Unsetenv("tag_deploy_target")
+
+	event := &sdk.Event{Service: "fn1", Branch: "master", Tag: "v1.2.0"}
+	if got := functionContext(event); got != sdk.BuildFunctionContext("fn1")+" (production)" {
+		t.Errorf("want context for the production target, got %q", got)
+	}
+}
+
+func Test_tagLabels(t *testing.T) {
+	labels := map[string]string{}
+	tagLabels(labels, &sdk.Event{Tag: "v1.2.0"})
+	if labels[sdk.TagLabel] != "v1.2.0" {
+		t.Errorf("want the tag labelled, got %v", labels)
+	}
+
+	labels = map[string]string{}
+	tagLabels(labels, &sdk.Event{Branch: "master"})
+	if len(labels) != 0 {
+		t.Errorf("want no label for a branch, got %v", labels)
+	}
+}
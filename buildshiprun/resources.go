@@ -0,0 +1,207 @@
+package function
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// resourcePolicy holds the default memory and CPU for each language
+// template and the most a user may ask for in stack.yml
+type resourcePolicy struct {
+	// MemoryMB is the memory limit for a template without its own default
+	MemoryMB int
+	// LanguageMemoryMB overrides MemoryMB by template, i.e. java11=256
+	LanguageMemoryMB map[string]int
+	// MaxMemoryMB caps the memory limit and request from stack.yml
+	MaxMemoryMB int
+
+	// CPUMilli is the CPU limit, 0 leaves it unset
+	CPUMilli int
+	// LanguageCPUMilli overrides CPUMilli by template
+	LanguageCPUMilli map[string]int
+	// MaxCPUMilli caps the CPU limit and request from stack.yml
+	MaxCPUMilli int
+}
+
+// functionResources are the limits and requests for a function once
+// the policy has been applied, zero values are left unset
+type functionResources struct {
+	MemoryLimitMB   int
+	MemoryRequestMB int
+	CPULimitMilli   int
+	CPURequestMilli int
+}
+
+// getResourcePolicy reads language_memory_limit_mb and
+// language_cpu_limit_milli, as "language=value" pairs, along with
+// function_memory_max_mb and function_cpu_max_milli. The caps default
+// to the largest default so that users can only lower their limits.
+func getResourcePolicy() resourcePolicy {
+	memoryMB, _ := strconv.Atoi(getMemoryLimitMB())
+	cpuMilli, _ := strconv.Atoi(os.Getenv("function_cpu_limit_milli"))
+
+	policy := resourcePolicy{
+		MemoryMB:         memoryMB,
+		LanguageMemoryMB: parseLanguageValues(os.Getenv("language_memory_limit_mb")),
+		CPUMilli:         cpuMilli,
+		LanguageCPUMilli: parseLanguageValues(os.Getenv("language_cpu_limit_milli")),
+	}
+
+	policy.MaxMemoryMB = maxValue(os.Getenv("function_memory_max_mb"), policy.MemoryMB, policy.LanguageMemoryMB)
+	policy.MaxCPUMilli = maxValue(os.Getenv("function_cpu_max_milli"), policy.CPUMilli, policy.LanguageCPUMilli)
+
+	return policy
+}
+
+// parseLanguageValues reads pairs such as "java11=256,go=64"
+func parseLanguageValues(value string) map[string]int {
+	values := map[string]int{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if val, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil && val > 0 {
+			values[strings.ToLower(strings.TrimSpace(parts[0]))] = val
+		}
+	}
+	return values
+}
+
+func maxValue(configured string, defaultValue int, languages map[string]int) int {
+	if val, err := strconv.Atoi(configured); err == nil && val > 0 {
+		return val
+	}
+
+	max := defaultValue
+	for _, val := range languages {
+		if val > max {
+			max = val
+		}
+	}
+	return max
+}
+
+// resolveResources gives the function the defaults for its template,
+// then applies the limits and requests from stack.yml. Values above
+// the caps, or requests above the limit, are rejected so that the
+// build fails with a reason rather than deploying something else.
+func resolveResources(event *sdk.Event, policy resourcePolicy) (functionResources, error) {
+	language := strings.ToLower(event.Language)
+
+	res := functionResources{
+		MemoryLimitMB: policy.MemoryMB,
+		CPULimitMilli: policy.CPUMilli,
+	}
+	if val, ok := policy.LanguageMemoryMB[language]; ok {
+		res.MemoryLimitMB = val
+	}
+	if val, ok := policy.LanguageCPUMilli[language]; ok {
+		res.CPULimitMilli = val
+	}
+
+	var err error
+	if event.Limits != nil {
+		if res.MemoryLimitMB, err = userMemoryMB("memory limit", event.Limits.Memory, res.MemoryLimitMB, policy.MaxMemoryMB); err != nil {
+			return res, err
+		}
+		if res.CPULimitMilli, err = userCPUMilli("CPU limit", event.Limits.CPU, res.CPULimitMilli, policy.MaxCPUMilli); err != nil {
+			return res, err
+		}
+	}
+
+	if event.Requests != nil {
+		if res.MemoryRequestMB, err = userMemoryMB("memory request", event.Requests.Memory, 0, res.MemoryLimitMB); err != nil {
+			return res, err
+		}
+		cpuMax := policy.MaxCPUMilli
+		if res.CPULimitMilli > 0 {
+			cpuMax = res.CPULimitMilli
+		}
+		if res.CPURequestMilli, err = userCPUMilli("CPU request", event.Requests.CPU, 0, cpuMax); err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+func userMemoryMB(name, value string, defaultValue, max int) (int, error) {
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+
+	mb, err := parseMemoryMB(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	if max > 0 && mb > max {
+		return 0, fmt.Errorf("%s of %dMB exceeds the maximum of %dMB", name, mb, max)
+	}
+	return mb, nil
+}
+
+func userCPUMilli(name, value string, defaultValue, max int) (int, error) {
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+
+	milli, err := parseCPUMilli(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	if max > 0 && milli > max {
+		return 0, fmt.Errorf("%s of %dm exceeds the maximum of %dm", name, milli, max)
+	}
+	return milli, nil
+}
+
+// parseMemoryMB reads a quantity in the Kubernetes or Swarm format,
+// such as 256Mi, 256m, 256M or 1Gi
+func parseMemoryMB(value string) (int, error) {
+	value = strings.TrimSpace(value)
+
+	multiplier := 1
+	for _, suffix := range []struct {
+		unit       string
+		multiplier int
+	}{
+		{"Gi", 1024}, {"G", 1024}, {"g", 1024},
+		{"Mi", 1}, {"M", 1}, {"m", 1},
+	} {
+		if strings.HasSuffix(value, suffix.unit) {
+			value = strings.TrimSuffix(value, suffix.unit)
+			multiplier = suffix.multiplier
+			break
+		}
+	}
+
+	val, err := strconv.Atoi(value)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid memory: %s", value)
+	}
+	return val * multiplier, nil
+}
+
+// parseCPUMilli reads CPU as millicores, i.e. 500m, or cores, i.e. 0.5
+func parseCPUMilli(value string) (int, error) {
+	value = strings.TrimSpace(value)
+
+	if strings.HasSuffix(value, "m") {
+		val, err := strconv.Atoi(strings.TrimSuffix(value, "m"))
+		if err != nil || val <= 0 {
+			return 0, fmt.Errorf("invalid CPU: %s", value)
+		}
+		return val, nil
+	}
+
+	cores, err := strconv.ParseFloat(value, 64)
+	if err != nil || cores <= 0 {
+		return 0, fmt.Errorf("invalid CPU: %s", value)
+	}
+	return int(cores * 1000), nil
+}
@@ -0,0 +1,141 @@
+package function
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_getResourcePolicy(t *testing.T) {
+	os.Setenv("function_memory_limit_mb", "128")
+	os.Setenv("language_memory_limit_mb", "java11=384, go=64,invalid")
+	defer os.Unsetenv("function_memory_limit_mb")
+	defer os.Unsetenv("language_memory_limit_mb")
+
+	policy := getResourcePolicy()
+
+	if policy.MemoryMB != 128 {
+		t.Errorf("want default memory 128, got %d", policy.MemoryMB)
+	}
+	if policy.LanguageMemoryMB["java11"] != 384 || policy.LanguageMemoryMB["go"] != 64 || len(policy.LanguageMemoryMB) != 2 {
+		t.Errorf("want java11 and go defaults, got %v", policy.LanguageMemoryMB)
+	}
+	if policy.MaxMemoryMB != 384 {
+		t.Errorf("want the cap to default to the largest default, got %d", policy.MaxMemoryMB)
+	}
+}
+
+func Test_resolveResources(t *testing.T) {
+	policy := resourcePolicy{
+		MemoryMB:         128,
+		LanguageMemoryMB: map[string]int{"java11": 384, "go": 64},
+		MaxMemoryMB:      512,
+		CPUMilli:         500,
+		LanguageCPUMilli: map[string]int{"java11": 1000},
+		MaxCPUMilli:      1000,
+	}
+
+	cases := []struct {
+		title   string
+		event   sdk.Event
+		want    functionResources
+		wantErr string
+	}{
+		{
+			title: "default for an unknown template",
+			event: sdk.Event{Language: "node12"},
+			want:  functionResources{MemoryLimitMB: 128, CPULimitMilli: 500},
+		},
+		{
+			title: "JVM template gets more",
+			event: sdk.Event{Language: "Java11"},
+			want:  functionResources{MemoryLimitMB: 384, CPULimitMilli: 1000},
+		},
+		{
+			title: "Go template gets less memory",
+			event: sdk.Event{Language: "go"},
+			want:  functionResources{MemoryLimitMB: 64, CPULimitMilli: 500},
+		},
+		{
+			title: "stack.yml within the caps",
+			event: sdk.Event{
+				Language: "go",
+				Limits:   &sdk.Resources{Memory: "256Mi", CPU: "0.25"},
+				Requests: &sdk.Resources{Memory: "128m", CPU: "100m"},
+			},
+			want: functionResources{MemoryLimitMB: 256, MemoryRequestMB: 128, CPULimitMilli: 250, CPURequestMilli: 100},
+		},
+		{
+			title:   "memory limit over the cap",
+			event:   sdk.Event{Limits: &sdk.Resources{Memory: "1Gi"}},
+			wantErr: "memory limit of 1024MB exceeds the maximum of 512MB",
+		},
+		{
+			title:   "request over the limit",
+			event:   sdk.Event{Requests: &sdk.Resources{CPU: "750m"}},
+			wantErr: "CPU request of 750m exceeds the maximum of 500m",
+		},
+		{
+			title:   "invalid memory",
+			event:   sdk.Event{Limits: &sdk.Resources{Memory: "lots"}},
+			wantErr: "invalid memory limit",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			got, err := resolveResources(&c.event, policy)
+			if len(c.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Errorf("want error %q, got %v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			if got != c.want {
+				t.Errorf("want %+v, got %+v", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_parseMemoryMB(t *testing.T) {
+	cases := map[string]int{"256Mi": 256, "256m": 256, "256M": 256, "1Gi": 1024, "2g": 2048, "64": 64}
+
+	for value, want := range cases {
+		got, err := parseMemoryMB(value)
+		if err != nil || got != want {
+			t.Errorf("parseMemoryMB(%q): want %d, got %d %v", value, want, got, err)
+		}
+	}
+
+	if _, err := parseMemoryMB("-1Mi"); err == nil {
+		t.Errorf("want an error for a negative value")
+	}
+}
+
+func Test_getEventFromEnv_Resources(t *testing.T) {
+	os.Setenv("Http_Language", "java11")
+	os.Setenv("Http_Limits", `{"memory":"256Mi","cpu":"500m"}`)
+	defer os.Unsetenv("Http_Language")
+	defer os.Unsetenv("Http_Limits")
+
+	event, err := getEventFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Language != "java11" {
+		t.Errorf("want language java11, got %q", event.Language)
+	}
+	if event.Limits == nil || event.Limits.Memory != "256Mi" || event.Limits.CPU != "500m" {
+		t.Errorf("want limits read from header, got %+v", event.Limits)
+	}
+	if event.Requests != nil {
+		t.Errorf("want no requests, got %+v", event.Requests)
+	}
+}
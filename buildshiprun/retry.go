@@ -0,0 +1,76 @@
+package function
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryPolicy controls how many times a call to the gateway is
+// attempted and how long to wait between attempts
+type retryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// deployAttempt records the outcome of a single call to the gateway
+type deployAttempt struct {
+	Status   int
+	Duration time.Duration
+}
+
+// getRetryPolicy reads deploy_retries, deploy_retry_backoff and
+// deploy_retry_max_backoff, defaulting to 3 attempts starting at 1s.
+func getRetryPolicy() retryPolicy {
+	policy := retryPolicy{
+		Attempts:   3,
+		Backoff:    getDuration("deploy_retry_backoff", time.Second),
+		MaxBackoff: getDuration("deploy_retry_max_backoff", 30*time.Second),
+	}
+
+	if val, ok := os.LookupEnv("deploy_retries"); ok && len(val) > 0 {
+		if attempts, err := strconv.Atoi(val); err == nil && attempts > 0 {
+			policy.Attempts = attempts
+		}
+	}
+
+	return policy
+}
+
+// retryable is true for statuses which may succeed if tried again,
+// the faas-cli client reports a transport error as a 500
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// delay is the exponential backoff for the given attempt (0-based)
+// with up to 50% jitter added.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	backoff := p.Backoff
+	for i := 0; i < attempt; i++ {
+		backoff = backoff * 2
+		if backoff >= p.MaxBackoff {
+			backoff = p.MaxBackoff
+			break
+		}
+	}
+
+	if backoff <= 0 {
+		return 0
+	}
+
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+}
+
+func formatAttempts(attempts []deployAttempt) string {
+	history := []string{}
+	for _, attempt := range attempts {
+		history = append(history, fmt.Sprintf("%d (%.2fs)", attempt.Status, attempt.Duration.Seconds()))
+	}
+	return fmt.Sprintf("attempts: %s", strings.Join(history, ", "))
+}
@@ -0,0 +1,103 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+)
+
+func Test_deployFunction_RetriesServiceUnavailable(t *testing.T) {
+	os.Setenv("deploy_retry_backoff", "1ms")
+	defer os.Unsetenv("deploy_retry_backoff")
+
+	deployCalls := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode([]types.FunctionStatus{})
+			return
+		}
+		deployCalls++
+		if deployCalls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	spec := &faasSDK.DeployFunctionSpec{FunctionName: "alexellis-fn1", Image: "fn1:latest"}
+
+	_, attempts, err := deployFunction(context.Background(), client, spec, s.URL)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("want 3 attempts, got %d", len(attempts))
+	}
+	if attempts[0].Status != http.StatusServiceUnavailable || attempts[2].Status != http.StatusAccepted {
+		t.Errorf("unexpected attempt history: %s", formatAttempts(attempts))
+	}
+}
+
+func Test_deployFunction_DoesNotRetryBadRequest(t *testing.T) {
+	os.Setenv("deploy_retry_backoff", "1ms")
+	defer os.Unsetenv("deploy_retry_backoff")
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode([]types.FunctionStatus{})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+	spec := &faasSDK.DeployFunctionSpec{FunctionName: "alexellis-fn1", Image: "fn1:latest"}
+
+	_, attempts, err := deployFunction(context.Background(), client, spec, s.URL)
+	if err == nil {
+		t.Fatalf("want error for 400 response")
+	}
+	if len(attempts) != 1 {
+		t.Errorf("want 1 attempt, got %d", len(attempts))
+	}
+}
+
+func Test_retryPolicy_delay(t *testing.T) {
+	policy := retryPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: 3 * time.Second}
+
+	cases := []struct {
+		attempt int
+		min     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 3 * time.Second},
+		{3, 3 * time.Second},
+	}
+
+	for _, c := range cases {
+		got := policy.delay(c.attempt)
+		if got < c.min || got > c.min+c.min/2 {
+			t.Errorf("attempt %d: want delay between %s and %s, got %s", c.attempt, c.min, c.min+c.min/2, got)
+		}
+	}
+}
+
+func Test_getRetryPolicy_Override(t *testing.T) {
+	os.Setenv("deploy_retries", "5")
+	defer os.Unsetenv("deploy_retries")
+
+	if got := getRetryPolicy().Attempts; got != 5 {
+		t.Errorf("want 5 attempts, got %d", got)
+	}
+}
@@ -0,0 +1,251 @@
+package function
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccountPath holds the token and CA of buildshiprun's service
+// account when it runs on Kubernetes
+const serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// failedWaitingReasons are the reasons a container is waiting which will
+// not resolve without a new deployment, so the rollout is failed straight
+// away rather than at the end of health_check_timeout
+var failedWaitingReasons = []string{
+	"ImagePullBackOff",
+	"ErrImagePull",
+	"InvalidImageName",
+	"CrashLoopBackOff",
+	"CreateContainerConfigError",
+	"CreateContainerError",
+}
+
+// rolloutFailure is the cause of a failed rollout as given by Kubernetes,
+// i.e. ImagePullBackOff or OOMKilled
+type rolloutFailure struct {
+	Reason  string
+	Message string
+}
+
+func (f rolloutFailure) Error() string {
+	if len(f.Message) == 0 {
+		return fmt.Sprintf("rollout failed: %s", f.Reason)
+	}
+	return fmt.Sprintf("rollout failed: %s: %s", f.Reason, f.Message)
+}
+
+// kubeRollout reads the rollout status of a function's Deployment and
+// its Pods from the Kubernetes API
+type kubeRollout struct {
+	BaseURL string
+	Token   string
+	// Namespace is used for functions deployed to the provider's
+	// default namespace
+	Namespace string
+	Client    *http.Client
+}
+
+// getRollout is enabled with rollout_status=true on Kubernetes and uses
+// buildshiprun's service account, which needs to get deployments and
+// list pods in the functions' namespaces.
+func getRollout() *kubeRollout {
+	if os.Getenv("rollout_status") != "true" {
+		return nil
+	}
+
+	baseURL, token, client := inClusterKube()
+	return &kubeRollout{
+		BaseURL:   baseURL,
+		Token:     token,
+		Namespace: getConfig("function_namespace", "openfaas-fn"),
+		Client:    client,
+	}
+}
+
+// inClusterKube gives the address of the Kubernetes API with the token
+// and CA of buildshiprun's service account
+func inClusterKube() (string, string, *http.Client) {
+	baseURL := "https://" + os.Getenv("KUBERNETES_SERVICE_HOST") + ":" + os.Getenv("KUBERNETES_SERVICE_PORT")
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	token := ""
+	if val, err := ioutil.ReadFile(serviceAccountPath + "token"); err == nil {
+		token = strings.TrimSpace(string(val))
+	}
+
+	if ca, err := ioutil.ReadFile(serviceAccountPath + "ca.crt"); err == nil {
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(ca) {
+			client.Transport = &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			}
+		}
+	}
+
+	return baseURL, token, client
+}
+
+type deploymentStatus struct {
+	Metadata struct {
+		Generation int64 `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas *int32 `json:"replicas"`
+		Template struct {
+			Spec struct {
+				Containers []struct {
+					Image string `json:"image"`
+				} `json:"containers"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration int64 `json:"observedGeneration"`
+		Replicas           int32 `json:"replicas"`
+		UpdatedReplicas    int32 `json:"updatedReplicas"`
+		AvailableReplicas  int32 `json:"availableReplicas"`
+		Conditions         []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+type containerState struct {
+	Waiting *struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	} `json:"waiting"`
+	Terminated *struct {
+		Reason   string `json:"reason"`
+		Message  string `json:"message"`
+		ExitCode int32  `json:"exitCode"`
+	} `json:"terminated"`
+}
+
+type podList struct {
+	Items []struct {
+		Status struct {
+			ContainerStatuses []struct {
+				Name      string         `json:"name"`
+				State     containerState `json:"state"`
+				LastState containerState `json:"lastState"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// Ready is true once the Deployment runs the image and every replica has
+// been updated and is available. A rolloutFailure is returned when the
+// progress deadline is exceeded or a container can't start.
+func (k *kubeRollout) Ready(functionName, namespace, image string) (bool, error) {
+	if len(namespace) == 0 {
+		namespace = k.Namespace
+	}
+
+	deployment := deploymentStatus{}
+	if err := k.get(fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments/%s", namespace, functionName), &deployment); err != nil {
+		return false, err
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == "Progressing" && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, rolloutFailure{Reason: condition.Reason, Message: condition.Message}
+		}
+	}
+
+	if err := k.podFailure(functionName, namespace); err != nil {
+		return false, err
+	}
+
+	containers := deployment.Spec.Template.Spec.Containers
+	if len(containers) == 0 || containers[0].Image != image {
+		return false, nil
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+
+	ready := deployment.Status.ObservedGeneration >= deployment.Metadata.Generation &&
+		deployment.Status.UpdatedReplicas >= replicas &&
+		deployment.Status.Replicas == deployment.Status.UpdatedReplicas &&
+		deployment.Status.AvailableReplicas >= replicas &&
+		replicas > 0
+
+	return ready, nil
+}
+
+// podFailure gives the first container of the function which is waiting
+// for a reason in failedWaitingReasons. Its last termination is given
+// instead when it was OOMKilled, since that is the real cause of the
+// CrashLoopBackOff.
+func (k *kubeRollout) podFailure(functionName, namespace string) error {
+	pods := podList{}
+	selector := url.QueryEscape("faas_function=" + functionName)
+	if err := k.get(fmt.Sprintf("/api/v1/namespaces/%s/pods?labelSelector=%s", namespace, selector), &pods); err != nil {
+		return err
+	}
+
+	for _, pod := range pods.Items {
+		for _, container := range pod.Status.ContainerStatuses {
+			waiting := container.State.Waiting
+			if waiting == nil || !failedWaiting(waiting.Reason) {
+				continue
+			}
+
+			if terminated := container.LastState.Terminated; terminated != nil && terminated.Reason == "OOMKilled" {
+				return rolloutFailure{
+					Reason:  terminated.Reason,
+					Message: fmt.Sprintf("container %s exited with code %d", container.Name, terminated.ExitCode),
+				}
+			}
+
+			return rolloutFailure{Reason: waiting.Reason, Message: waiting.Message}
+		}
+	}
+
+	return nil
+}
+
+func failedWaiting(reason string) bool {
+	for _, failed := range failedWaitingReasons {
+		if reason == failed {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *kubeRollout) get(path string, value interface{}) error {
+	req, _ := http.NewRequest(http.MethodGet, k.BaseURL+path, nil)
+	req.Header.Set("Accept", "application/json")
+	if len(k.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+k.Token)
+	}
+
+	res, err := k.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to read rollout status: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to read rollout status: unexpected status code %d", res.StatusCode)
+	}
+
+	return json.Unmarshal(body, value)
+}
@@ -0,0 +1,144 @@
+package function
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const availableDeployment = `{
+  "metadata": {"generation": 2},
+  "spec": {"replicas": 1, "template": {"spec": {"containers": [{"image": "registry/alexellis-fn1:af6db"}]}}},
+  "status": {"observedGeneration": 2, "replicas": 1, "updatedReplicas": 1, "availableReplicas": 1}
+}`
+
+const progressingDeployment = `{
+  "metadata": {"generation": 2},
+  "spec": {"replicas": 1, "template": {"spec": {"containers": [{"image": "registry/alexellis-fn1:af6db"}]}}},
+  "status": {"observedGeneration": 2, "replicas": 2, "updatedReplicas": 1, "availableReplicas": 1}
+}`
+
+const deadlineDeployment = `{
+  "metadata": {"generation": 2},
+  "spec": {"replicas": 1, "template": {"spec": {"containers": [{"image": "registry/alexellis-fn1:af6db"}]}}},
+  "status": {
+    "observedGeneration": 2, "replicas": 2, "updatedReplicas": 1, "availableReplicas": 1,
+    "conditions": [{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded", "message": "ReplicaSet \"alexellis-fn1-7d9\" has timed out progressing."}]
+  }
+}`
+
+const imagePullPods = `{"items": [{"status": {"containerStatuses": [{
+  "name": "alexellis-fn1",
+  "state": {"waiting": {"reason": "ImagePullBackOff", "message": "Back-off pulling image \"registry/alexellis-fn1:af6db\""}}
+}]}}]}`
+
+const oomKilledPods = `{"items": [{"status": {"containerStatuses": [{
+  "name": "alexellis-fn1",
+  "state": {"waiting": {"reason": "CrashLoopBackOff", "message": "back-off 10s restarting failed container"}},
+  "lastState": {"terminated": {"reason": "OOMKilled", "exitCode": 137}}
+}]}}]}`
+
+func rolloutServer(t *testing.T, deployment, pods string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apis/apps/v1/namespaces/openfaas-fn/deployments/alexellis-fn1":
+			w.Write([]byte(deployment))
+		case "/api/v1/namespaces/openfaas-fn/pods":
+			if got := r.URL.Query().Get("labelSelector"); got != "faas_function=alexellis-fn1" {
+				t.Errorf("want pods of the function, got selector %q", got)
+			}
+			w.Write([]byte(pods))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func Test_kubeRollout_Ready(t *testing.T) {
+	tests := []struct {
+		name       string
+		deployment string
+		pods       string
+		ready      bool
+		err        string
+	}{
+		{name: "available", deployment: availableDeployment, pods: `{"items": []}`, ready: true},
+		{name: "progressing", deployment: progressingDeployment, pods: `{"items": []}`, ready: false},
+		{name: "previous image", deployment: strings.Replace(availableDeployment, "af6db", "4cd1e2f", 1), pods: `{"items": []}`, ready: false},
+		{
+			name:       "progress deadline",
+			deployment: deadlineDeployment,
+			pods:       `{"items": []}`,
+			err:        `rollout failed: ProgressDeadlineExceeded: ReplicaSet "alexellis-fn1-7d9" has timed out progressing.`,
+		},
+		{
+			name:       "image pull",
+			deployment: progressingDeployment,
+			pods:       imagePullPods,
+			err:        `rollout failed: ImagePullBackOff: Back-off pulling image "registry/alexellis-fn1:af6db"`,
+		},
+		{
+			name:       "oom killed",
+			deployment: progressingDeployment,
+			pods:       oomKilledPods,
+			err:        "rollout failed: OOMKilled: container alexellis-fn1 exited with code 137",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := rolloutServer(t, test.deployment, test.pods)
+			defer s.Close()
+
+			rollout := &kubeRollout{BaseURL: s.URL, Namespace: "openfaas-fn", Client: http.DefaultClient}
+			ready, err := rollout.Ready("alexellis-fn1", "", "registry/alexellis-fn1:af6db")
+			if len(test.err) > 0 {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("want error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			if ready != test.ready {
+				t.Errorf("want ready %t, got %t", test.ready, ready)
+			}
+		})
+	}
+}
+
+func Test_verifyDeployment_RolloutFailsFast(t *testing.T) {
+	s := rolloutServer(t, progressingDeployment, imagePullPods)
+	defer s.Close()
+
+	check := healthCheck{
+		Enabled:  true,
+		Timeout:  time.Minute,
+		Interval: time.Millisecond,
+		Rollout:  &kubeRollout{BaseURL: s.URL, Namespace: "openfaas-fn", Client: http.DefaultClient},
+	}
+
+	err := verifyDeployment(context.Background(), nil, "alexellis-fn1", "", "registry/alexellis-fn1:af6db", s.URL+"/", check)
+	if _, failed := err.(rolloutFailure); !failed {
+		t.Fatalf("want the rollout failure, got %v", err)
+	}
+}
+
+func Test_getRollout(t *testing.T) {
+	os.Unsetenv("rollout_status")
+	if getRollout() != nil {
+		t.Errorf("want the rollout status disabled by default")
+	}
+
+	os.Setenv("rollout_status", "true")
+	defer os.Unsetenv("rollout_status")
+
+	if rollout := getRollout(); rollout == nil || rollout.Namespace != "openfaas-fn" {
+		t.Errorf("want the rollout status read from openfaas-fn, got %+v", rollout)
+	}
+}
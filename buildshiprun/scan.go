@@ -0,0 +1,84 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// scanPolicy decides whether an image may be deployed from the
+// of-builder's scan of it
+type scanPolicy struct {
+	// BlockSeverities fail the deployment when any vulnerability of
+	// these severities is found, i.e. critical,high
+	BlockSeverities []string
+	// Required fails the deployment of an image which was not scanned
+	Required bool
+}
+
+// getScanPolicy reads scan_block_severities and scan_required, images
+// are deployed whatever the scan finds when neither is set
+func getScanPolicy() scanPolicy {
+	required, _ := strconv.ParseBool(os.Getenv("scan_required"))
+
+	return scanPolicy{
+		BlockSeverities: splitList(os.Getenv("scan_block_severities")),
+		Required:        required,
+	}
+}
+
+// checkScanPolicy gives the reason the image may not be deployed
+func checkScanPolicy(report *sdk.ScanReport, policy scanPolicy) error {
+	if report == nil {
+		if policy.Required {
+			return fmt.Errorf("image was not scanned and scan_required is set")
+		}
+		return nil
+	}
+
+	found := []string{}
+	for _, severity := range policy.BlockSeverities {
+		if count := report.Count(severity); count > 0 {
+			found = append(found, fmt.Sprintf("%d %s", count, strings.ToLower(severity)))
+		}
+	}
+
+	if len(found) > 0 {
+		return fmt.Errorf("image has %s vulnerabilities, blocked by scan policy", strings.Join(found, ", "))
+	}
+	return nil
+}
+
+// scanAnnotations records the summary of the scan on the function for
+// the dashboard
+func scanAnnotations(annotations map[string]string, report *sdk.ScanReport) {
+	if report == nil {
+		return
+	}
+
+	annotations[sdk.SBOMPackagesAnnotation] = strconv.Itoa(report.SBOMPackages)
+	annotations[sdk.VulnerabilitiesAnnotation] = report.FormatVulnerabilities()
+	if len(report.Scanner) > 0 {
+		annotations[sdk.ScannerAnnotation] = report.Scanner
+	}
+}
+
+// recordScan stores the scan report in pipeline-log next to the build
+// log and will fail silently if unavailable
+func recordScan(report *sdk.ScanReport, event *sdk.Event, gatewayURL string, payloadSecret string) error {
+	reportBytes, _ := json.Marshal(report)
+
+	p := sdk.PipelineLog{
+		CommitSHA: event.SHA,
+		Function:  event.Service,
+		RepoPath:  event.Owner + "/" + event.Repository,
+		Source:    sdk.ScanSource,
+		Data:      string(reportBytes),
+	}
+
+	return sdk.WritePipelineLog(gatewayURL, payloadSecret, p)
+}
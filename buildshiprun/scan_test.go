@@ -0,0 +1,77 @@
+package function
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_getScanPolicy(t *testing.T) {
+	os.Setenv("scan_block_severities", "critical, high")
+	os.Setenv("scan_required", "true")
+	defer os.Unsetenv("scan_block_severities")
+	defer os.Unsetenv("scan_required")
+
+	policy := getScanPolicy()
+
+	if !reflect.DeepEqual(policy.BlockSeverities, []string{"critical", "high"}) {
+		t.Errorf("want critical and high blocked, got %v", policy.BlockSeverities)
+	}
+	if !policy.Required {
+		t.Errorf("want scan required")
+	}
+}
+
+func Test_checkScanPolicy(t *testing.T) {
+	report := &sdk.ScanReport{
+		Vulnerabilities: map[string]int{"CRITICAL": 0, "High": 2, "medium": 5},
+	}
+
+	cases := []struct {
+		title   string
+		report  *sdk.ScanReport
+		policy  scanPolicy
+		wantErr bool
+	}{
+		{"no policy", report, scanPolicy{}, false},
+		{"blocked severity found", report, scanPolicy{BlockSeverities: []string{"critical", "high"}}, true},
+		{"blocked severity not found", report, scanPolicy{BlockSeverities: []string{"critical"}}, false},
+		{"not scanned", nil, scanPolicy{BlockSeverities: []string{"critical"}}, false},
+		{"not scanned but required", nil, scanPolicy{Required: true}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			err := checkScanPolicy(c.report, c.policy)
+			if c.wantErr != (err != nil) {
+				t.Errorf("want error: %v, got: %v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_scanAnnotations(t *testing.T) {
+	annotations := map[string]string{}
+	scanAnnotations(annotations, &sdk.ScanReport{
+		Scanner:         "grype 0.74.0",
+		SBOMPackages:    42,
+		Vulnerabilities: map[string]int{"high": 2},
+	})
+
+	want := map[string]string{
+		sdk.SBOMPackagesAnnotation:    "42",
+		sdk.VulnerabilitiesAnnotation: "critical=0,high=2,medium=0,low=0,unknown=0",
+		sdk.ScannerAnnotation:         "grype 0.74.0",
+	}
+	if !reflect.DeepEqual(annotations, want) {
+		t.Errorf("want %v, got %v", want, annotations)
+	}
+
+	unscanned := map[string]string{}
+	scanAnnotations(unscanned, nil)
+	if len(unscanned) != 0 {
+		t.Errorf("want no annotations without a scan, got %v", unscanned)
+	}
+}
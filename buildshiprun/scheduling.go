@@ -0,0 +1,173 @@
+package function
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// gpuLabel requests a GPU node for the function from stack.yml
+const gpuLabel = "com.openfaas.gpu"
+
+// gpuScheduledLabel records that the function was scheduled on a GPU
+const gpuScheduledLabel = sdk.FunctionLabelPrefix + "gpu"
+
+// profileAnnotation selects OpenFaaS Profiles, used to add the GPU
+// tolerations, runtimeClass or resources on Kubernetes. Several
+// profiles are given as a comma-separated list.
+const profileAnnotation = "com.openfaas.profile"
+
+// profileName matches the name of a Profile, which is a Kubernetes
+// object name
+var profileName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// schedulingConfig is the operator's policy for where users may place
+// their functions
+type schedulingConfig struct {
+	// AllowedConstraints are the node label keys a user may constrain on
+	AllowedConstraints []string
+	// GPUEnabled allows functions to request a GPU
+	GPUEnabled bool
+	// GPUOwners limits GPUs to these owners, all owners when empty
+	GPUOwners []string
+	// GPUConstraint places the function on a GPU node
+	GPUConstraint string
+	// GPUProfile is set as the com.openfaas.profile annotation
+	GPUProfile string
+	// AllowedProfiles are the profiles a user may select in stack.yml
+	AllowedProfiles []string
+}
+
+// getSchedulingConfig reads allowed_constraints, allowed_profiles,
+// gpu_enabled, gpu_owners, gpu_constraint and gpu_profile
+func getSchedulingConfig() schedulingConfig {
+	return schedulingConfig{
+		AllowedConstraints: splitList(os.Getenv("allowed_constraints")),
+		AllowedProfiles:    splitList(os.Getenv("allowed_profiles")),
+		GPUEnabled:         os.Getenv("gpu_enabled") == "true",
+		GPUOwners:          splitList(os.Getenv("gpu_owners")),
+		GPUConstraint:      os.Getenv("gpu_constraint"),
+		GPUProfile:         os.Getenv("gpu_profile"),
+	}
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// constraintKey returns the node label of a constraint, which is given
+// as "key == value" on Swarm or "key=value" on Kubernetes
+func constraintKey(constraint string) (string, bool) {
+	for _, op := range []string{"==", "!=", "="} {
+		if index := strings.Index(constraint, op); index > 0 {
+			key := strings.TrimSpace(constraint[:index])
+			value := strings.TrimSpace(constraint[index+len(op):])
+			if len(key) == 0 || len(value) == 0 {
+				return "", false
+			}
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func contains(items []string, value string) bool {
+	for _, item := range items {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsGPU reads the gpu label from stack.yml
+func wantsGPU(labels map[string]string) bool {
+	val := labels[gpuLabel]
+	return val == "true" || val == "1"
+}
+
+// userProfiles reads the profiles selected with the com.openfaas.profile
+// annotation in stack.yml
+func userProfiles(annotations map[string]string) []string {
+	return splitList(annotations[profileAnnotation])
+}
+
+// validateScheduling checks the function's constraints, profiles and
+// GPU request against the operator's policy before the build starts.
+// Constraints on node labels which have not been allowed are rejected,
+// so that functions cannot be placed on reserved nodes, as are profiles
+// not listed in allowed_profiles.
+func validateScheduling(event *sdk.Event, cfg schedulingConfig) error {
+	for _, constraint := range event.Constraints {
+		key, ok := constraintKey(constraint)
+		if !ok {
+			return fmt.Errorf("invalid constraint %q, use key=value", constraint)
+		}
+		if !contains(cfg.AllowedConstraints, key) {
+			return fmt.Errorf("constraint on %s is not allowed", key)
+		}
+	}
+
+	for _, profile := range userProfiles(event.Annotations) {
+		if !profileName.MatchString(profile) {
+			return fmt.Errorf("invalid profile %q", profile)
+		}
+		if !contains(cfg.AllowedProfiles, profile) {
+			return fmt.Errorf("profile %s is not allowed", profile)
+		}
+	}
+
+	if wantsGPU(event.Labels) {
+		if !cfg.GPUEnabled {
+			return fmt.Errorf("GPUs are not available on this installation")
+		}
+		if len(cfg.GPUOwners) > 0 && !contains(cfg.GPUOwners, event.Owner) {
+			return fmt.Errorf("GPUs are not enabled for %s", event.Owner)
+		}
+	}
+
+	return nil
+}
+
+// applyScheduling adds the user's constraints and profiles and the GPU
+// placement to the deployment, once validated by validateScheduling
+func applyScheduling(deploy *faasSDK.DeployFunctionSpec, event *sdk.Event, cfg schedulingConfig) {
+	constraints := append([]string{}, event.Constraints...)
+	profiles := userProfiles(event.Annotations)
+
+	if wantsGPU(event.Labels) {
+		if len(cfg.GPUConstraint) > 0 {
+			constraints = append(constraints, cfg.GPUConstraint)
+		}
+
+		if len(cfg.GPUProfile) > 0 && !contains(profiles, cfg.GPUProfile) {
+			profiles = append(profiles, cfg.GPUProfile)
+		}
+
+		if deploy.Labels == nil {
+			deploy.Labels = map[string]string{}
+		}
+		deploy.Labels[gpuScheduledLabel] = "true"
+	}
+
+	if len(constraints) > 0 {
+		deploy.Constraints = constraints
+	}
+
+	if len(profiles) > 0 {
+		if deploy.Annotations == nil {
+			deploy.Annotations = map[string]string{}
+		}
+		deploy.Annotations[profileAnnotation] = strings.Join(profiles, ",")
+	}
+}
@@ -0,0 +1,182 @@
+package function
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_constraintKey(t *testing.T) {
+	cases := []struct {
+		constraint string
+		want       string
+		ok         bool
+	}{
+		{"node.labels.zone == eu-west-1", "node.labels.zone", true},
+		{"node.labels.zone != eu-west-1", "node.labels.zone", true},
+		{"topology.kubernetes.io/zone=eu-west-1a", "topology.kubernetes.io/zone", true},
+		{"node-role.kubernetes.io/master", "", false},
+		{"=value", "", false},
+		{"key=", "", false},
+	}
+
+	for _, c := range cases {
+		key, ok := constraintKey(c.constraint)
+		if key != c.want || ok != c.ok {
+			t.Errorf("constraintKey(%q): want %q %t, got %q %t", c.constraint, c.want, c.ok, key, ok)
+		}
+	}
+}
+
+func Test_validateScheduling(t *testing.T) {
+	cfg := schedulingConfig{
+		AllowedConstraints: []string{"topology.kubernetes.io/zone"},
+		AllowedProfiles:    []string{"withsysctl", "spot"},
+		GPUEnabled:         true,
+		GPUOwners:          []string{"alexellis"},
+	}
+
+	cases := []struct {
+		title   string
+		event   sdk.Event
+		wantErr string
+	}{
+		{
+			title: "no constraints",
+			event: sdk.Event{Owner: "alexellis"},
+		},
+		{
+			title: "allowed constraint",
+			event: sdk.Event{Owner: "alexellis", Constraints: []string{"topology.kubernetes.io/zone=eu-west-1a"}},
+		},
+		{
+			title:   "constraint on a reserved node label",
+			event:   sdk.Event{Owner: "alexellis", Constraints: []string{"node-role.kubernetes.io/master=true"}},
+			wantErr: "constraint on node-role.kubernetes.io/master is not allowed",
+		},
+		{
+			title:   "invalid constraint",
+			event:   sdk.Event{Owner: "alexellis", Constraints: []string{"gpu"}},
+			wantErr: "invalid constraint",
+		},
+		{
+			title: "allowed profiles",
+			event: sdk.Event{Owner: "alexellis", Annotations: map[string]string{profileAnnotation: "withsysctl, spot"}},
+		},
+		{
+			title:   "profile which is not allowed",
+			event:   sdk.Event{Owner: "alexellis", Annotations: map[string]string{profileAnnotation: "spot,privileged"}},
+			wantErr: "profile privileged is not allowed",
+		},
+		{
+			title:   "invalid profile name",
+			event:   sdk.Event{Owner: "alexellis", Annotations: map[string]string{profileAnnotation: "Spot/Nodes"}},
+			wantErr: "invalid profile",
+		},
+		{
+			title: "gpu for an allowed owner",
+			event: sdk.Event{Owner: "AlexEllis", Labels: map[string]string{gpuLabel: "true"}},
+		},
+		{
+			title:   "gpu for another owner",
+			event:   sdk.Event{Owner: "openfaas", Labels: map[string]string{gpuLabel: "true"}},
+			wantErr: "GPUs are not enabled for openfaas",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			err := validateScheduling(&c.event, cfg)
+			if len(c.wantErr) == 0 && err != nil {
+				t.Errorf("want no error, got %s", err)
+			}
+			if len(c.wantErr) > 0 && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+				t.Errorf("want error %q, got %v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validateScheduling_GPUDisabled(t *testing.T) {
+	event := sdk.Event{Owner: "alexellis", Labels: map[string]string{gpuLabel: "1"}}
+
+	err := validateScheduling(&event, schedulingConfig{})
+	if err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("want GPUs unavailable, got %v", err)
+	}
+}
+
+func Test_applyScheduling_GPU(t *testing.T) {
+	cfg := schedulingConfig{
+		GPUEnabled:    true,
+		GPUConstraint: "nvidia.com/gpu.present=true",
+		GPUProfile:    "gpu",
+	}
+	event := sdk.Event{
+		Owner:       "alexellis",
+		Labels:      map[string]string{gpuLabel: "true"},
+		Constraints: []string{"topology.kubernetes.io/zone=eu-west-1a"},
+	}
+	deploy := &faasSDK.DeployFunctionSpec{}
+
+	applyScheduling(deploy, &event, cfg)
+
+	if strings.Join(deploy.Constraints, ",") != "topology.kubernetes.io/zone=eu-west-1a,nvidia.com/gpu.present=true" {
+		t.Errorf("want user and GPU constraints, got %v", deploy.Constraints)
+	}
+	if deploy.Annotations[profileAnnotation] != "gpu" {
+		t.Errorf("want profile annotation gpu, got %q", deploy.Annotations[profileAnnotation])
+	}
+	if deploy.Labels[gpuScheduledLabel] != "true" {
+		t.Errorf("want %s label, got %v", gpuScheduledLabel, deploy.Labels)
+	}
+	if len(event.Constraints) != 1 {
+		t.Errorf("want event constraints unchanged, got %v", event.Constraints)
+	}
+}
+
+func Test_applyScheduling_ProfilesWithGPU(t *testing.T) {
+	cfg := schedulingConfig{
+		GPUEnabled: true,
+		GPUProfile: "gpu",
+	}
+	event := sdk.Event{
+		Owner:       "alexellis",
+		Labels:      map[string]string{gpuLabel: "true"},
+		Annotations: map[string]string{profileAnnotation: "withsysctl"},
+	}
+	deploy := &faasSDK.DeployFunctionSpec{}
+
+	applyScheduling(deploy, &event, cfg)
+
+	if deploy.Annotations[profileAnnotation] != "withsysctl,gpu" {
+		t.Errorf("want user and GPU profiles, got %q", deploy.Annotations[profileAnnotation])
+	}
+}
+
+func Test_applyScheduling_NoConstraints(t *testing.T) {
+	deploy := &faasSDK.DeployFunctionSpec{}
+
+	applyScheduling(deploy, &sdk.Event{Owner: "alexellis"}, schedulingConfig{})
+
+	if deploy.Constraints != nil || deploy.Labels != nil {
+		t.Errorf("want spec unchanged, got %+v", deploy)
+	}
+}
+
+func Test_getEventFromEnv_Constraints(t *testing.T) {
+	os.Setenv("Http_Constraints", `["topology.kubernetes.io/zone=eu-west-1a"]`)
+	defer os.Unsetenv("Http_Constraints")
+
+	event, err := getEventFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(event.Constraints) != 1 || event.Constraints[0] != "topology.kubernetes.io/zone=eu-west-1a" {
+		t.Errorf("want constraint read from header, got %v", event.Constraints)
+	}
+}
@@ -0,0 +1,43 @@
+package function
+
+import (
+	"context"
+	"os"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+)
+
+// validateSecrets is disabled with validate_secrets=false, i.e. for a
+// provider which does not implement the secrets API
+func validateSecrets() bool {
+	val := os.Getenv("validate_secrets")
+	return val != "false" && val != "0"
+}
+
+// missingSecrets returns the owner-prefixed secrets referenced by the
+// function which do not exist in the namespace, so that a deployment
+// fails before the build instead of when the function is scheduled.
+func missingSecrets(ctx context.Context, client *faasSDK.Client, secrets []string, namespace string) ([]string, error) {
+	missing := []string{}
+	if len(secrets) == 0 {
+		return missing, nil
+	}
+
+	existing, err := client.GetSecretList(ctx, namespace)
+	if err != nil {
+		return missing, err
+	}
+
+	names := map[string]bool{}
+	for _, secret := range existing {
+		names[secret.Name] = true
+	}
+
+	for _, secret := range secrets {
+		if !names[secret] {
+			missing = append(missing, secret)
+		}
+	}
+
+	return missing, nil
+}
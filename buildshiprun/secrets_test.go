@@ -0,0 +1,82 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+)
+
+func Test_missingSecrets(t *testing.T) {
+	namespace := ""
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace = r.URL.Query().Get("namespace")
+		json.NewEncoder(w).Encode([]types.Secret{{Name: "alexellis-api-key"}, {Name: "openfaas-api-key"}})
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+
+	missing, err := missingSecrets(context.Background(), client, []string{"alexellis-api-key", "alexellis-db-password"}, "openfaas-fn-alexellis")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"alexellis-db-password"}
+	if !reflect.DeepEqual(missing, want) {
+		t.Errorf("want %v, got %v", want, missing)
+	}
+	if namespace != "openfaas-fn-alexellis" {
+		t.Errorf("want secrets listed in openfaas-fn-alexellis, got %q", namespace)
+	}
+}
+
+func Test_missingSecrets_NoSecretsSkipsGateway(t *testing.T) {
+	called := false
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+
+	missing, err := missingSecrets(context.Background(), client, []string{}, "")
+	if err != nil || len(missing) > 0 {
+		t.Errorf("want no missing secrets, got %v, %v", missing, err)
+	}
+	if called {
+		t.Errorf("want the gateway not to be called")
+	}
+}
+
+func Test_missingSecrets_ListError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotImplemented)
+	}))
+	defer s.Close()
+
+	client := faasSDK.NewClient(&FaaSAuth{}, s.URL, nil, &timeout)
+
+	if _, err := missingSecrets(context.Background(), client, []string{"alexellis-api-key"}, ""); err == nil {
+		t.Errorf("want an error when secrets cannot be listed")
+	}
+}
+
+func Test_validateSecrets(t *testing.T) {
+	os.Unsetenv("validate_secrets")
+	if !validateSecrets() {
+		t.Errorf("want secrets validated by default")
+	}
+
+	os.Setenv("validate_secrets", "false")
+	defer os.Unsetenv("validate_secrets")
+	if validateSecrets() {
+		t.Errorf("want validation disabled")
+	}
+}
@@ -0,0 +1,26 @@
+package function
+
+import (
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// staticLanguage is the lang of a static site, built by git-tar with its
+// own template which serves the site's files with the of-watchdog
+const staticLanguage = "static"
+
+// staticSiteLabel marks a function which serves a static site
+const staticSiteLabel = sdk.FunctionLabelPrefix + "static-site"
+
+func isStaticSite(event *sdk.Event) bool {
+	return strings.ToLower(event.Language) == staticLanguage
+}
+
+// staticSiteLabels records that the function is a static site, so it can
+// be told apart from a handler on the dashboard
+func staticSiteLabels(labels map[string]string, event *sdk.Event) {
+	if isStaticSite(event) {
+		labels[staticSiteLabel] = "true"
+	}
+}
@@ -0,0 +1,28 @@
+package function
+
+import (
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_staticSiteLabels(t *testing.T) {
+	tests := []struct {
+		title    string
+		language string
+		want     string
+	}{
+		{title: "static site", language: "static", want: "true"},
+		{title: "function", language: "go", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			labels := map[string]string{}
+			staticSiteLabels(labels, &sdk.Event{Language: test.language})
+			if got := labels[staticSiteLabel]; got != test.want {
+				t.Errorf("want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
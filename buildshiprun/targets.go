@@ -0,0 +1,91 @@
+package function
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// deployTarget is a gateway, such as one in a separate staging cluster,
+// which the functions pushed to a branch are deployed to
+type deployTarget struct {
+	// Name is the branch, and prefixes the target's credentials
+	Name       string
+	GatewayURL string
+}
+
+// getDeployTarget reads deploy_targets, branch=gateway pairs such as
+// staging=https://gateway.staging.example.com/. Branches without a target
+// are deployed to gateway_url.
+func getDeployTarget(branch string) (deployTarget, bool) {
+	if len(branch) == 0 {
+		return deployTarget{}, false
+	}
+
+	for _, pair := range strings.Split(os.Getenv("deploy_targets"), ",") {
+		index := strings.Index(pair, "=")
+		if index < 1 {
+			continue
+		}
+
+		name := strings.TrimSpace(pair[:index])
+		gateway := strings.TrimSpace(pair[index+1:])
+		if name != branch || len(gateway) == 0 {
+			continue
+		}
+
+		if !strings.HasSuffix(gateway, "/") {
+			gateway = gateway + "/"
+		}
+
+		return deployTarget{Name: name, GatewayURL: gateway}, true
+	}
+
+	return deployTarget{}, false
+}
+
+// targetAuth adds the basic auth credentials for a deploy target, read
+// from the <branch>-basic-auth-user and <branch>-basic-auth-password
+// secrets
+type targetAuth struct {
+	target string
+}
+
+// Set adds basic authentication to the request
+func (auth *targetAuth) Set(req *http.Request) error {
+	user, err := sdk.ReadSecret(auth.target + "-basic-auth-user")
+	if err != nil {
+		return fmt.Errorf("unable to read credentials for deploy target %s: %s", auth.target, err.Error())
+	}
+
+	password, err := sdk.ReadSecret(auth.target + "-basic-auth-password")
+	if err != nil {
+		return fmt.Errorf("unable to read credentials for deploy target %s: %s", auth.target, err.Error())
+	}
+
+	req.SetBasicAuth(user, password)
+	return nil
+}
+
+// functionContext is the commit status context for a function, a
+// function deployed to a target has its own status, i.e. "fn1 (staging)"
+func functionContext(event *sdk.Event) string {
+	context := sdk.BuildFunctionContext(event.Service)
+	if target, ok := getDeployTarget(deployTargetName(event)); ok {
+		context = fmt.Sprintf("%s (%s)", context, target.Name)
+	}
+	return context
+}
+
+// deployGateway gives the auth and URL of the gateway the event's
+// functions are deployed to, a deploy target's or gateway_url's
+func deployGateway(event *sdk.Event, gatewayURL string) (faasSDK.ClientAuth, string) {
+	if target, ok := getDeployTarget(deployTargetName(event)); ok {
+		return &targetAuth{target: target.Name}, target.GatewayURL
+	}
+	return &FaaSAuth{}, gatewayURL
+}
@@ -0,0 +1,65 @@
+package function
+
+import (
+	"os"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_getDeployTarget(t *testing.T) {
+	os.Setenv("deploy_targets", "staging=https://gateway.staging.example.com, qa = http://qa:8080/")
+	defer os.Unsetenv("deploy_targets")
+
+	cases := []struct {
+		branch string
+		want   string
+		ok     bool
+	}{
+		{"staging", "https://gateway.staging.example.com/", true},
+		{"qa", "http://qa:8080/", true},
+		{"master", "", false},
+		{"", "", false},
+	}
+
+	for _, c := range cases {
+		target, ok := getDeployTarget(c.branch)
+		if ok != c.ok || target.GatewayURL != c.want {
+			t.Errorf("getDeployTarget(%q): want %q %t, got %q %t", c.branch, c.want, c.ok, target.GatewayURL, ok)
+		}
+	}
+}
+
+func Test_getDeployTarget_Unset(t *testing.T) {
+	os.Unsetenv("deploy_targets")
+
+	if _, ok := getDeployTarget("staging"); ok {
+		t.Errorf("want no target when deploy_targets is unset")
+	}
+}
+
+func Test_functionContext(t *testing.T) {
+	os.Setenv("deploy_targets", "staging=https://gateway.staging.example.com/")
+	defer os.Unsetenv("deploy_targets")
+
+	event := &sdk.Event{Service: "fn1", Branch: "staging"}
+	if got := functionContext(event); got != sdk.BuildFunctionContext("fn1")+" (staging)" {
+		t.Errorf("want context for the staging target, got %q", got)
+	}
+
+	event.Branch = "master"
+	if got := functionContext(event); got != sdk.BuildFunctionContext("fn1") {
+		t.Errorf("want the default context, got %q", got)
+	}
+}
+
+func Test_isStaging_WithDeployTarget(t *testing.T) {
+	os.Setenv("staging_branch", "staging")
+	os.Setenv("deploy_targets", "staging=https://gateway.staging.example.com/")
+	defer os.Unsetenv("staging_branch")
+	defer os.Unsetenv("deploy_targets")
+
+	if isStaging(&sdk.Event{Branch: "staging"}) {
+		t.Errorf("want a staging branch with a deploy target to keep the function's name")
+	}
+}
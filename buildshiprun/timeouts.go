@@ -0,0 +1,48 @@
+package function
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// defaultBuildTimeout matches the function's write_timeout in stack.yml
+const defaultBuildTimeout = 5 * time.Minute
+
+// getBuildTimeout reads build_timeout, the longest a call to the
+// of-builder may take before the build is reported as failed
+func getBuildTimeout() time.Duration {
+	return parseIntOrDurationValue(os.Getenv("build_timeout"), defaultBuildTimeout)
+}
+
+// getGatewayTimeout reads gateway_timeout which is applied to each call
+// to the gateway's API
+func getGatewayTimeout() time.Duration {
+	return parseIntOrDurationValue(os.Getenv("gateway_timeout"), timeout)
+}
+
+// builderClient is used to submit builds, it shares the proxy and CA
+// settings of the sdk's client with a timeout of build_timeout
+func builderClient() *http.Client {
+	return sdk.NewHTTPClientWithTimeout(getBuildTimeout())
+}
+
+// parseIntOrDurationValue accepts a number of seconds or a Go duration
+// such as 90s or 5m
+func parseIntOrDurationValue(val string, fallback time.Duration) time.Duration {
+	if len(val) > 0 {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal >= 0 {
+			return time.Duration(parsedVal) * time.Second
+		}
+	}
+
+	duration, durationErr := time.ParseDuration(val)
+	if durationErr != nil {
+		return fallback
+	}
+	return duration
+}
@@ -0,0 +1,51 @@
+package function
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_parseIntOrDurationValue(t *testing.T) {
+	tests := []struct {
+		val  string
+		want time.Duration
+	}{
+		{"", time.Minute},
+		{"90", 90 * time.Second},
+		{"10m", 10 * time.Minute},
+		{"invalid", time.Minute},
+	}
+
+	for _, test := range tests {
+		if got := parseIntOrDurationValue(test.val, time.Minute); got != test.want {
+			t.Errorf("%q: want %s, got %s", test.val, test.want, got)
+		}
+	}
+}
+
+func Test_getBuildTimeout(t *testing.T) {
+	os.Unsetenv("build_timeout")
+	if got := getBuildTimeout(); got != defaultBuildTimeout {
+		t.Errorf("want %s, got %s", defaultBuildTimeout, got)
+	}
+
+	os.Setenv("build_timeout", "120")
+	defer os.Unsetenv("build_timeout")
+	if got := builderClient().Timeout; got != 2*time.Minute {
+		t.Errorf("want builder client timeout 2m, got %s", got)
+	}
+}
+
+func Test_getGatewayTimeout(t *testing.T) {
+	os.Unsetenv("gateway_timeout")
+	if got := getGatewayTimeout(); got != timeout {
+		t.Errorf("want %s, got %s", timeout, got)
+	}
+
+	os.Setenv("gateway_timeout", "30s")
+	defer os.Unsetenv("gateway_timeout")
+	if got := getGatewayTimeout(); got != 30*time.Second {
+		t.Errorf("want 30s, got %s", got)
+	}
+}
@@ -0,0 +1,128 @@
+package function
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// warmupLabel sets the number of warm-up requests for a function from
+// stack.yml, overriding warmup_requests up to warmup_max_requests
+const warmupLabel = "com.openfaas.warmup"
+
+// warmupPathLabel is a custom path to invoke for the warm-up, i.e.
+// /warmup, the function's root is invoked when not set
+const warmupPathLabel = "com.openfaas.warmup.path"
+
+// warmupHeader is sent with each warm-up request so that the function
+// can tell it apart from user traffic
+const warmupHeader = "X-Cloud-Warmup"
+
+// warmupConfig configures the requests sent to a function once it is
+// ready so that the first user request does not hit a cold start
+type warmupConfig struct {
+	Requests int
+	Path     string
+	Timeout  time.Duration
+}
+
+// warmupResult is recorded in the audit trail
+type warmupResult struct {
+	Requests  int
+	Succeeded int
+	Duration  time.Duration
+	LastError string
+}
+
+func (r warmupResult) String() string {
+	msg := fmt.Sprintf("warm-up: %d/%d ok in %s", r.Succeeded, r.Requests, r.Duration.Round(time.Millisecond))
+	if len(r.LastError) > 0 {
+		msg = fmt.Sprintf("%s, last error: %s", msg, r.LastError)
+	}
+	return msg
+}
+
+// getWarmupConfig reads warmup_requests, warmup_max_requests,
+// warmup_path and warmup_timeout, the warm-up labels on the function
+// take precedence. Warm-up is disabled when there are no requests.
+func getWarmupConfig(labels map[string]string) warmupConfig {
+	cfg := warmupConfig{
+		Path:    os.Getenv("warmup_path"),
+		Timeout: getDuration("warmup_timeout", 10*time.Second),
+	}
+
+	cfg.Requests, _ = strconv.Atoi(os.Getenv("warmup_requests"))
+
+	max := 10
+	if val, err := strconv.Atoi(os.Getenv("warmup_max_requests")); err == nil && val >= 0 {
+		max = val
+	}
+
+	if val, err := strconv.Atoi(labels[warmupLabel]); err == nil {
+		cfg.Requests = val
+	}
+	if cfg.Requests > max {
+		cfg.Requests = max
+	}
+	if cfg.Requests < 0 {
+		cfg.Requests = 0
+	}
+
+	if val := strings.TrimSpace(labels[warmupPathLabel]); len(val) > 0 {
+		cfg.Path = val
+	}
+
+	return cfg
+}
+
+// warmUp invokes the function cfg.Requests times one after another, a
+// failed request is recorded but does not fail the deployment
+func warmUp(gatewayURL, functionName, namespace string, cfg warmupConfig) warmupResult {
+	result := warmupResult{Requests: cfg.Requests}
+	if cfg.Requests <= 0 {
+		return result
+	}
+
+	client := &http.Client{Timeout: cfg.Timeout}
+	uri := functionURL(gatewayURL, functionName, namespace, cfg.Path)
+
+	start := time.Now()
+	for i := 0; i < cfg.Requests; i++ {
+		req, _ := http.NewRequest(http.MethodGet, uri, nil)
+		req.Header.Set(warmupHeader, "true")
+
+		res, err := client.Do(req)
+		if err != nil {
+			result.LastError = err.Error()
+			continue
+		}
+
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+
+		if res.StatusCode >= 200 && res.StatusCode <= 299 {
+			result.Succeeded++
+		} else {
+			result.LastError = fmt.Sprintf("unexpected status code %d", res.StatusCode)
+		}
+	}
+	result.Duration = time.Since(start)
+
+	return result
+}
+
+// functionURL is the function's URL on the gateway, with its namespace
+// when one is set
+func functionURL(gatewayURL, functionName, namespace, path string) string {
+	functionPath := functionName
+	if len(namespace) > 0 {
+		functionPath = functionName + "." + namespace
+	}
+
+	return gatewayURL + "function/" + functionPath + "/" + strings.TrimPrefix(path, "/")
+}
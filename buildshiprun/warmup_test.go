@@ -0,0 +1,69 @@
+package function
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_getWarmupConfig(t *testing.T) {
+	os.Setenv("warmup_requests", "2")
+	os.Setenv("warmup_max_requests", "5")
+	defer os.Unsetenv("warmup_requests")
+	defer os.Unsetenv("warmup_max_requests")
+
+	cases := []struct {
+		title    string
+		labels   map[string]string
+		requests int
+		path     string
+	}{
+		{"platform default", nil, 2, ""},
+		{"label overrides", map[string]string{warmupLabel: "4", warmupPathLabel: "/warmup"}, 4, "/warmup"},
+		{"label capped", map[string]string{warmupLabel: "100"}, 5, ""},
+		{"label disables", map[string]string{warmupLabel: "0"}, 0, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			cfg := getWarmupConfig(c.labels)
+			if cfg.Requests != c.requests || cfg.Path != c.path {
+				t.Errorf("want %d requests to %q, got %d to %q", c.requests, c.path, cfg.Requests, cfg.Path)
+			}
+		})
+	}
+}
+
+func Test_warmUp(t *testing.T) {
+	var calls int32
+	var path, header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, header = r.URL.Path, r.Header.Get(warmupHeader)
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	result := warmUp(server.URL+"/", "fn1", "openfaas-fn", warmupConfig{Requests: 3, Path: "/warmup"})
+
+	if result.Requests != 3 || result.Succeeded != 2 {
+		t.Errorf("want 2/3 requests ok, got %d/%d", result.Succeeded, result.Requests)
+	}
+	if path != "/function/fn1.openfaas-fn/warmup" || header != "true" {
+		t.Errorf("want the warm-up path invoked with the header, got %s %q", path, header)
+	}
+	if !strings.Contains(result.String(), "warm-up: 2/3 ok") || !strings.Contains(result.String(), "502") {
+		t.Errorf("want the result and last error in the audit message, got %q", result.String())
+	}
+}
+
+func Test_warmUp_Disabled(t *testing.T) {
+	result := warmUp("http://127.0.0.1:1/", "fn1", "", warmupConfig{})
+	if result.Requests != 0 || result.Succeeded != 0 {
+		t.Errorf("want no requests, got %+v", result)
+	}
+}
@@ -0,0 +1,15 @@
+module github.com/openfaas/openfaas-cloud/cost-estimate
+
+go 1.13
+
+require (
+	github.com/alexellis/hmac v0.0.0-20180624210714-d5d71edd7bc7
+	github.com/drone/envsubst v1.0.2
+	github.com/mitchellh/go-homedir v1.1.0
+	github.com/openfaas/faas v0.0.0-20200305154109-326cc7a9b923
+	github.com/openfaas/faas-cli v0.0.0-20200226083118-b0a70a3f4f20
+	github.com/openfaas/faas-provider v0.0.0-20191005090653-478f741b64cb
+	github.com/openfaas/openfaas-cloud v0.0.0-20200305165942-85f5f4991594
+	github.com/ryanuber/go-glob v1.0.0
+	gopkg.in/yaml.v2 v2.2.8
+)
@@ -0,0 +1,277 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// month is used to scale usage over the metrics window to a monthly estimate
+const month = 30 * 24 * time.Hour
+
+// Prices are set by the operator as unit prices for invocations and
+// for memory reserved while executing
+type Prices struct {
+	Currency              string  `json:"currency"`
+	PerMillionInvocations float64 `json:"perMillionInvocations"`
+	PerGBSecond           float64 `json:"perGBSecond"`
+}
+
+// FunctionCost is the usage of a function over the window and the
+// cost of that usage projected over a month
+type FunctionCost struct {
+	Name             string  `json:"name"`
+	Invocations      float64 `json:"invocations"`
+	ExecutionSeconds float64 `json:"executionSeconds"`
+	MemoryLimitMB    float64 `json:"memoryLimitMB"`
+	MonthlyCost      float64 `json:"monthlyCost"`
+}
+
+// Estimate is the response for an owner
+type Estimate struct {
+	Owner       string         `json:"owner"`
+	Window      string         `json:"window"`
+	Prices      Prices         `json:"prices"`
+	Functions   []FunctionCost `json:"functions"`
+	MonthlyCost float64        `json:"monthlyCost"`
+}
+
+type gatewayFunction struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels"`
+}
+
+type vectorResponse struct {
+	Data struct {
+		Result []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// Handle estimates the monthly cost of an owner's functions, given as
+// ?user=alexellis, from their invocations and execution time over
+// metrics_window and the memory limit recorded by buildshiprun.
+// Add &function=alexellis-fn1 to estimate a single function.
+func Handle(req []byte) string {
+	query, _ := url.ParseQuery(os.Getenv("Http_Query"))
+
+	owner := query.Get("user")
+	if len(owner) == 0 {
+		return "User is required as a querystring i.e. ?user=alexellis."
+	}
+
+	window := query.Get("metrics_window")
+	if len(window) == 0 {
+		window = getConfig("metrics_window", "60m")
+	}
+
+	windowDuration, err := time.ParseDuration(window)
+	if err != nil || windowDuration <= 0 {
+		return fmt.Sprintf("invalid metrics_window: %s", window)
+	}
+
+	functions, err := listFunctions(os.Getenv("gateway_url"), owner)
+	if err != nil {
+		log.Printf("error listing functions: %s", err.Error())
+		return fmt.Sprintf("unable to list functions for %s", owner)
+	}
+
+	if name := query.Get("function"); len(name) > 0 {
+		filtered := []gatewayFunction{}
+		for _, fn := range functions {
+			if fn.Name == name || functionName(fn) == name {
+				filtered = append(filtered, fn)
+			}
+		}
+		functions = filtered
+	}
+
+	prometheusURL := fmt.Sprintf("http://%s:%s",
+		getConfig("prometheus_host", "prometheus.openfaas"),
+		getConfig("prometheus_port", "9090"))
+
+	invocations, err := queryByFunction(prometheusURL,
+		fmt.Sprintf("sum(increase(gateway_function_invocation_total[%s])) by (function_name)", window))
+	if err != nil {
+		log.Printf("error querying invocations: %s", err.Error())
+		return "unable to query metrics"
+	}
+
+	seconds, err := queryByFunction(prometheusURL,
+		fmt.Sprintf("sum(increase(gateway_functions_seconds_sum[%s])) by (function_name)", window))
+	if err != nil {
+		log.Printf("error querying execution time: %s", err.Error())
+		return "unable to query metrics"
+	}
+
+	estimate := estimateCost(owner, window, windowDuration, getPrices(), functions, invocations, seconds)
+
+	bytesOut, _ := json.Marshal(estimate)
+	return string(bytesOut)
+}
+
+// estimateCost projects the usage of each function over the window
+// to a month and applies the unit prices
+func estimateCost(owner string, window string, windowDuration time.Duration, prices Prices, functions []gatewayFunction, invocations map[string]float64, seconds map[string]float64) Estimate {
+	scale := float64(month) / float64(windowDuration)
+
+	estimate := Estimate{
+		Owner:     owner,
+		Window:    window,
+		Prices:    prices,
+		Functions: []FunctionCost{},
+	}
+
+	for _, fn := range functions {
+		name := functionName(fn)
+
+		cost := FunctionCost{
+			Name:             name,
+			Invocations:      invocations[name],
+			ExecutionSeconds: seconds[name],
+			MemoryLimitMB:    memoryLimitMB(fn),
+		}
+
+		gbSeconds := cost.ExecutionSeconds * cost.MemoryLimitMB / 1024
+		cost.MonthlyCost = scale * (cost.Invocations/1000000*prices.PerMillionInvocations + gbSeconds*prices.PerGBSecond)
+
+		estimate.Functions = append(estimate.Functions, cost)
+		estimate.MonthlyCost += cost.MonthlyCost
+	}
+
+	sort.Slice(estimate.Functions, func(i, j int) bool {
+		return estimate.Functions[i].MonthlyCost > estimate.Functions[j].MonthlyCost
+	})
+
+	return estimate
+}
+
+// functionName matches the function_name label used by the gateway's
+// metrics, i.e. alexellis-fn1.openfaas-fn
+func functionName(fn gatewayFunction) string {
+	namespace := fn.Namespace
+	if len(namespace) == 0 {
+		namespace = getConfig("function_namespace", "openfaas-fn")
+	}
+	return fn.Name + "." + namespace
+}
+
+// memoryLimitMB reads the limit recorded by buildshiprun, falling back
+// to the platform's function_memory_limit_mb
+func memoryLimitMB(fn gatewayFunction) float64 {
+	for _, val := range []string{fn.Labels[sdk.MemoryLimitLabel], os.Getenv("function_memory_limit_mb"), "128"} {
+		if limit, err := strconv.ParseFloat(val, 64); err == nil && limit > 0 {
+			return limit
+		}
+	}
+	return 0
+}
+
+// getPrices reads cost_currency, cost_per_million_invocations and
+// cost_per_gb_second
+func getPrices() Prices {
+	prices := Prices{
+		Currency: getConfig("cost_currency", "USD"),
+	}
+
+	if val, err := strconv.ParseFloat(os.Getenv("cost_per_million_invocations"), 64); err == nil {
+		prices.PerMillionInvocations = val
+	}
+
+	if val, err := strconv.ParseFloat(os.Getenv("cost_per_gb_second"), 64); err == nil {
+		prices.PerGBSecond = val
+	}
+
+	return prices
+}
+
+func listFunctions(gatewayURL string, owner string) ([]gatewayFunction, error) {
+	functionsURL := gatewayURL + "system/functions"
+	if os.Getenv("owner_namespaces") == "true" {
+		functionsURL = functionsURL + "?namespace=" + getConfig("namespace_prefix", "openfaas-fn-") + strings.ToLower(owner)
+	}
+
+	functions := []gatewayFunction{}
+	if err := getJSON(functionsURL, true, &functions); err != nil {
+		return nil, err
+	}
+
+	filtered := []gatewayFunction{}
+	for _, fn := range functions {
+		if strings.EqualFold(fn.Labels[sdk.FunctionLabelPrefix+"git-owner"], owner) {
+			filtered = append(filtered, fn)
+		}
+	}
+
+	return filtered, nil
+}
+
+func queryByFunction(prometheusURL string, query string) (map[string]float64, error) {
+	res := vectorResponse{}
+	if err := getJSON(prometheusURL+"/api/v1/query?query="+url.QueryEscape(query), false, &res); err != nil {
+		return nil, err
+	}
+
+	values := map[string]float64{}
+	for _, result := range res.Data.Result {
+		if len(result.Value) < 2 {
+			continue
+		}
+		val, ok := result.Value[1].(string)
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil || f != f {
+			continue
+		}
+		values[result.Metric["function_name"]] = f
+	}
+
+	return values, nil
+}
+
+func getJSON(uri string, auth bool, target interface{}) error {
+	req, _ := http.NewRequest(http.MethodGet, uri, nil)
+	if auth {
+		if err := sdk.AddBasicAuth(req); err != nil {
+			return err
+		}
+	}
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code from %s: %d", uri, res.StatusCode)
+	}
+
+	return json.Unmarshal(body, target)
+}
+
+func getConfig(key string, defaultValue string) string {
+	if val, exists := os.LookupEnv(key); exists && len(val) > 0 {
+		return val
+	}
+	return defaultValue
+}
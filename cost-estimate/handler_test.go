@@ -0,0 +1,84 @@
+package function
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_estimateCost(t *testing.T) {
+	functions := []gatewayFunction{
+		{Name: "alexellis-fn1", Labels: map[string]string{sdk.MemoryLimitLabel: "1024"}},
+		{Name: "alexellis-fn2", Labels: map[string]string{sdk.MemoryLimitLabel: "512"}},
+	}
+	invocations := map[string]float64{
+		"alexellis-fn1.openfaas-fn": 1000,
+		"alexellis-fn2.openfaas-fn": 10,
+	}
+	seconds := map[string]float64{
+		"alexellis-fn1.openfaas-fn": 100,
+	}
+	prices := Prices{Currency: "USD", PerMillionInvocations: 1000, PerGBSecond: 0.01}
+
+	// A window of 1/30th of a month is scaled by 30
+	estimate := estimateCost("alexellis", "24h", 24*time.Hour, prices, functions, invocations, seconds)
+
+	if len(estimate.Functions) != 2 {
+		t.Fatalf("want 2 functions, got %d", len(estimate.Functions))
+	}
+
+	fn1 := estimate.Functions[0]
+	if fn1.Name != "alexellis-fn1.openfaas-fn" {
+		t.Errorf("want most expensive function first, got %s", fn1.Name)
+	}
+
+	// 30 * (1000/1m * 1000 + 100s * 1GB * 0.01)
+	if want := 30 * (1.0 + 1.0); math.Abs(fn1.MonthlyCost-want) > 0.0001 {
+		t.Errorf("want %f, got %f", want, fn1.MonthlyCost)
+	}
+
+	if want := 30 * (1.0 + 1.0 + 0.01); math.Abs(estimate.MonthlyCost-want) > 0.0001 {
+		t.Errorf("want total %f, got %f", want, estimate.MonthlyCost)
+	}
+}
+
+func Test_memoryLimitMB_FallsBackToPlatformLimit(t *testing.T) {
+	os.Setenv("function_memory_limit_mb", "256")
+	defer os.Unsetenv("function_memory_limit_mb")
+
+	if got := memoryLimitMB(gatewayFunction{}); got != 256 {
+		t.Errorf("want 256, got %f", got)
+	}
+}
+
+func Test_functionName_OwnerNamespace(t *testing.T) {
+	fn := gatewayFunction{Name: "fn1", Namespace: "openfaas-fn-alexellis"}
+
+	if got := functionName(fn); got != "fn1.openfaas-fn-alexellis" {
+		t.Errorf("want fn1.openfaas-fn-alexellis, got %s", got)
+	}
+}
+
+func Test_queryByFunction(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"function_name":"alexellis-fn1.openfaas-fn"},"value":[1560000000,"42"]},
+			{"metric":{"function_name":"alexellis-fn2.openfaas-fn"},"value":[1560000000,"NaN"]}]}}`)
+	}))
+	defer s.Close()
+
+	values, err := queryByFunction(s.URL, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(values) != 1 || values["alexellis-fn1.openfaas-fn"] != 42 {
+		t.Errorf("want 42 for alexellis-fn1 only, got %v", values)
+	}
+}
@@ -2,6 +2,24 @@ import axios from 'axios';
 import moment from 'moment';
 
 const getRepoURL = annotations => annotations['com.openfaas.cloud.git-repo-url'] || '';
+const getLicense = annotations => annotations['com.openfaas.cloud.git-license'] || '';
+// Languages are annotated largest first, i.e. Go=82.5,Shell=17.5
+const getLanguages = annotations =>
+  (annotations['com.openfaas.cloud.git-languages'] || '')
+    .split(',')
+    .filter(pair => pair)
+    .map(pair => pair.split('=')[0]);
+// Vulnerabilities are annotated most severe first, i.e. critical=0,high=2
+const getVulnerabilities = annotations =>
+  (annotations['com.openfaas.cloud.vulnerabilities'] || '')
+    .split(',')
+    .filter(pair => pair)
+    .map(pair => {
+      const [severity, count] = pair.split('=');
+      return { severity, count: parseInt(count, 10) || 0 };
+    });
+const getSBOMPackages = annotations =>
+  annotations['com.openfaas.cloud.sbom-packages'] || '';
 
 class FunctionsApi {
   constructor() {
@@ -89,6 +107,11 @@ class FunctionsApi {
         gitSha: item.labels['com.openfaas.cloud.git-sha'],
         gitBranch: item.labels['com.openfaas.cloud.git-branch'],
         gitRepoURL: getRepoURL(item.annotations || {}),
+        gitLicense: getLicense(item.annotations || {}),
+        gitLanguages: getLanguages(item.annotations || {}),
+        sbomPackages: getSBOMPackages(item.annotations || {}),
+        scanner: (item.annotations || {})['com.openfaas.cloud.scanner'] || '',
+        vulnerabilities: getVulnerabilities(item.annotations || {}),
         minReplicas: item.labels['com.openfaas.scale.min'],
         maxReplicas: item.labels['com.openfaas.scale.max'],
       };
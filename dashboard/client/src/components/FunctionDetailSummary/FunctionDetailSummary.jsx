@@ -91,6 +91,23 @@ const FunctionDetailSummary = ({
     }
   ];
 
+  if (fn.sbomPackages) {
+    deployMeta.push({
+      label: 'SBOM:',
+      value: `${fn.sbomPackages} packages${fn.scanner ? ` (${fn.scanner})` : ''}`
+    });
+  }
+
+  if (fn.vulnerabilities && fn.vulnerabilities.length > 0) {
+    deployMeta.push({
+      label: 'Vulnerabilities:',
+      value: fn.vulnerabilities
+        .filter(v => v.count > 0)
+        .map(v => `${v.count} ${v.severity}`)
+        .join(', ') || 'none found'
+    });
+  }
+
   const gitMeta = [
     {
       label: 'Repository:',
@@ -7,4 +7,5 @@ require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/openfaas/faas-provider v0.0.0-20191011092439-98c25c3919da
 	github.com/openfaas/openfaas-cloud v0.0.0-20200225145041-6a94566a9f09
+	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
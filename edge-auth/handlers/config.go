@@ -16,5 +16,6 @@ type Config struct {
 	SecureCookie           bool
 	PublicKeyPath          string
 	PrivateKeyPath         string
-	Debug                  bool // Debug enables verbose logging of claims / cookies
+	DashboardOrigin        string // DashboardOrigin is the only origin which may open a websocket to the events
+	Debug                  bool   // Debug enables verbose logging of claims / cookies
 }
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alexellis/hmac"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// deployRepoName is the "repo" an upload is deployed as, which prefixes
+// the names of its functions
+var deployRepoName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// deployContentTypes are accepted for an upload, none of which can be
+// posted by a form on another site without a preflight request
+var deployContentTypes = []string{
+	"application/x-tar",
+	"application/gzip",
+	"application/x-gzip",
+	"application/octet-stream",
+}
+
+// deployTokenAudience marks a token which may only be used to deploy,
+// it is never accepted in place of the session cookie
+const deployTokenAudience = "openfaas-cloud-deploy"
+
+// MakeDeployHandler lets a signed-in user deploy a tar, or gzipped tar,
+// of stack.yml and their functions' source with a POST, i.e. from their
+// laptop without pushing to a repository first. A GET gives a user
+// signed in with a browser a deploy token which expires after
+// tokenExpiry, it is sent back as a Bearer token, or the POST is sent
+// with the cookie. The session's JWT is never given out, as it holds
+// the user's OAuth access token. The "repo" query names the upload and
+// "user" selects the account it is deployed to, the user's own by
+// default. The archive is queued for git-tar as an sdk.PushEvent signed
+// with the payload-secret.
+func MakeDeployHandler(config *Config, gatewayURL string, payloadSecret string, maxArchiveSize int64, tokenExpiry time.Duration) func(http.ResponseWriter, *http.Request) {
+	keydata, err := ioutil.ReadFile(config.PublicKeyPath)
+	if err != nil {
+		log.Fatalf("unable to read path: %s, error: %s", config.PublicKeyPath, err.Error())
+	}
+
+	publicKey, keyErr := jwt.ParseECPublicKeyFromPEM(keydata)
+	if keyErr != nil {
+		log.Fatalf("unable to parse public key: %s", keyErr.Error())
+	}
+
+	privateKeydata, err := ioutil.ReadFile(config.PrivateKeyPath)
+	if err != nil {
+		log.Fatalf("private key, unable to read path: %s, error: %s", config.PrivateKeyPath, err.Error())
+	}
+
+	privateKey, keyErr := jwt.ParseECPrivateKeyFromPEM(privateKeydata)
+	if keyErr != nil {
+		log.Fatalf("unable to parse private key: %s", keyErr.Error())
+	}
+
+	customers := sdk.NewCustomers(os.Getenv("customers_path"), os.Getenv("customers_url"))
+
+	gatewayURL = strings.TrimSuffix(gatewayURL, "/") + "/"
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			claims, err := cookieClaims(r, publicKey)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			token, expires, err := createDeployToken(claims, privateKey, tokenExpiry)
+			if err != nil {
+				log.Printf("Deploy: unable to create a token for %s: %s", claims.Subject, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			json.NewEncoder(w).Encode(map[string]string{
+				"token":   token,
+				"expires": expires.Format(time.RFC3339),
+			})
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := tokenClaims(r, publicKey)
+		if err != nil {
+			log.Printf("Deploy: %s", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if !deployContentType(r.Header.Get("Content-Type")) {
+			http.Error(w, fmt.Sprintf("Content-Type must be one of: %s", strings.Join(deployContentTypes, ", ")), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		user := r.URL.Query().Get("user")
+		if len(user) == 0 {
+			user = claims.Subject
+		}
+
+		if _, ok := eventOwners(claims, user); !ok {
+			log.Printf("Deploy: %s is not entitled to deploy to %s", claims.Subject, user)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		owner := strings.ToLower(user)
+
+		repo := strings.ToLower(r.URL.Query().Get("repo"))
+		if !deployRepoName.MatchString(repo) {
+			http.Error(w, "repo is required, and may only have letters, numbers and dashes", http.StatusBadRequest)
+			return
+		}
+
+		customer, found := customers.Lookup(owner)
+		if sdk.ValidateCustomers() && !found {
+			log.Printf("Deploy: %s is not a customer", owner)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		archive, err := ioutil.ReadAll(io.LimitReader(r.Body, maxArchiveSize+1))
+		if err != nil {
+			http.Error(w, "unable to read archive", http.StatusBadRequest)
+			return
+		}
+		if int64(len(archive)) > maxArchiveSize {
+			http.Error(w, fmt.Sprintf("archive is larger than %d bytes", maxArchiveSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err := checkArchive(archive); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		commit := sdk.ArchiveCommit(archive)
+		pushEvent := sdk.PushEvent{
+			SCM: sdk.SCMCLI,
+			Repository: sdk.PushEventRepository{
+				Name:     repo,
+				FullName: owner + "/" + repo,
+				Owner: sdk.Owner{
+					Login: owner,
+				},
+			},
+			AfterCommitID: commit,
+			HeadCommit: &sdk.PushEventCommit{
+				ID:      commit,
+				Message: fmt.Sprintf("Uploaded by %s", claims.Subject),
+			},
+			Customer: customer,
+			Sender: sdk.Sender{
+				Login: claims.Subject,
+			},
+			Archive: archive,
+		}
+
+		log.Printf("Deploy: %s uploaded %s/%s at %s, %d bytes", claims.Subject, owner, repo, sdk.FormatShortSHA(commit), len(archive))
+
+		body, _ := json.Marshal(pushEvent)
+		req, _ := http.NewRequest(http.MethodPost, gatewayURL+"async-function/git-tar", bytes.NewReader(body))
+		digest := hmac.Sign(body, []byte(payloadSecret))
+		req.Header.Set(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+		res, err := sdk.HTTPClient().Do(req)
+		if err != nil {
+			log.Printf("Deploy: %s", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+			log.Printf("Deploy: unexpected status code from git-tar: %d", res.StatusCode)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(sdk.Accepted(fmt.Sprintf("%s-%s at %s, git-tar status: %d", owner, repo, sdk.FormatShortSHA(commit), res.StatusCode)).JSON()))
+	}
+}
+
+// createDeployToken signs a token for the user of the session with
+// only the claims needed to deploy, and the deploy audience
+func createDeployToken(session *OpenFaaSCloudClaims, privateKey crypto.PrivateKey, expiry time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(expiry)
+
+	claims := OpenFaaSCloudClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        session.Id,
+			Issuer:    session.Issuer,
+			ExpiresAt: expires.Unix(),
+			IssuedAt:  now.Unix(),
+			Subject:   session.Subject,
+			Audience:  deployTokenAudience,
+		},
+		Organizations: session.Organizations,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(privateKey)
+	return token, expires, err
+}
+
+// tokenClaims gives the claims of the Bearer token, which must be a
+// deploy token, or of the cookie for a request without an Authorization
+// header
+func tokenClaims(r *http.Request, publicKey crypto.PublicKey) (*OpenFaaSCloudClaims, error) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) == 0 {
+		return cookieClaims(r, publicKey)
+	}
+
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return nil, fmt.Errorf("authorization must be a Bearer token")
+	}
+
+	claims := OpenFaaSCloudClaims{}
+	parsed, err := jwt.ParseWithClaims(strings.TrimPrefix(authorization, "Bearer "), &claims, func(token *jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !parsed.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if claims.Audience != deployTokenAudience {
+		return nil, fmt.Errorf("not a deploy token")
+	}
+	return &claims, nil
+}
+
+func deployContentType(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	for _, allowed := range deployContentTypes {
+		if strings.EqualFold(mediaType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkArchive reads the whole archive so that an upload which git-tar
+// can't unpack, or without a stack.yml, is refused straight away
+func checkArchive(archive []byte) error {
+	hasStack := false
+
+	err := sdk.WalkArchive(archive, func(name string, header *tar.Header, r io.Reader) error {
+		if name == "stack.yml" && header.Typeflag != tar.TypeDir {
+			hasStack = true
+		}
+		if name == "template" || strings.HasPrefix(name, "template/") {
+			return fmt.Errorf(`unsupported custom "templates" folder`)
+		}
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !hasStack {
+		return fmt.Errorf("unable to find stack.yml")
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexellis/hmac"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func deployArchive(files ...string) []byte {
+	buf := bytes.Buffer{}
+	tarWriter := tar.NewWriter(&buf)
+	for _, name := range files {
+		tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+		tarWriter.Write([]byte("a"))
+	}
+	tarWriter.Close()
+	return buf.Bytes()
+}
+
+func writeDeployCustomers(t *testing.T) func() {
+	file, err := ioutil.TempFile("", "customers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("alexellis\nopenfaas\n")
+	file.Close()
+
+	os.Setenv("customers_path", file.Name())
+	return func() {
+		os.Unsetenv("customers_path")
+		os.Remove(file.Name())
+	}
+}
+
+// writeDeployKey writes the private key which signs deploy tokens
+func writeDeployKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	private, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := ioutil.TempFile("", "edge-auth-private-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	pem.Encode(file, &pem.Block{Type: "EC PRIVATE KEY", Bytes: private})
+	return file.Name()
+}
+
+func Test_MakeDeployHandler(t *testing.T) {
+	key, keyPath := writeEventsKey(t)
+	defer os.Remove(keyPath)
+	defer writeDeployCustomers(t)()
+
+	var got sdk.PushEvent
+	var gotPath string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		digest := hmac.Sign(body, []byte("secret"))
+		if r.Header.Get(sdk.CloudSignatureHeader) != "sha1="+hex.EncodeToString(digest) {
+			t.Errorf("want the request signed with the payload-secret")
+		}
+		json.Unmarshal(body, &got)
+		gotPath = r.URL.Path
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+
+	privateKeyPath := writeDeployKey(t, key)
+	defer os.Remove(privateKeyPath)
+
+	handler := MakeDeployHandler(&Config{PublicKeyPath: keyPath, PrivateKeyPath: privateKeyPath}, gateway.URL, "secret", 1024*1024, time.Hour)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, OpenFaaSCloudClaims{
+		Organizations:  "openfaas",
+		StandardClaims: jwt.StandardClaims{Subject: "alexellis", Audience: deployTokenAudience},
+	})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := deployArchive("stack.yml", "fn1/handler.go")
+	req := httptest.NewRequest(http.MethodPost, "/deploy/?repo=fn1&user=OpenFaaS", bytes.NewReader(archive))
+	req.Header.Set("Authorization", "Bearer "+signed)
+	req.Header.Set("Content-Type", "application/x-tar")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want the archive queued, got %d %s", rr.Code, rr.Body.String())
+	}
+	if gotPath != "/async-function/git-tar" {
+		t.Errorf("want git-tar invoked asynchronously, got %s", gotPath)
+	}
+	if got.SCM != sdk.SCMCLI || got.Repository.Owner.Login != "openfaas" || got.Repository.Name != "fn1" || got.Sender.Login != "alexellis" {
+		t.Errorf("want the upload deployed to the organization, got %+v", got)
+	}
+	if !bytes.Equal(got.Archive, archive) || got.AfterCommitID != sdk.ArchiveCommit(archive) {
+		t.Errorf("want the archive and its ID sent to git-tar")
+	}
+}
+
+func Test_MakeDeployHandler_Refused(t *testing.T) {
+	key, keyPath := writeEventsKey(t)
+	defer os.Remove(keyPath)
+	defer writeDeployCustomers(t)()
+
+	privateKeyPath := writeDeployKey(t, key)
+	defer os.Remove(privateKeyPath)
+
+	handler := MakeDeployHandler(&Config{PublicKeyPath: keyPath, PrivateKeyPath: privateKeyPath}, "http://127.0.0.1:1/", "secret", 4096, time.Hour)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, OpenFaaSCloudClaims{
+		Organizations:  "someorg",
+		StandardClaims: jwt.StandardClaims{Subject: "alexellis", Audience: deployTokenAudience},
+	})
+	signed, _ := token.SignedString(key)
+
+	session := jwt.NewWithClaims(jwt.SigningMethodES256, OpenFaaSCloudClaims{
+		StandardClaims: jwt.StandardClaims{Subject: "alexellis"},
+	})
+	sessionSigned, _ := session.SignedString(key)
+
+	tests := []struct {
+		Scenario    string
+		Query       string
+		ContentType string
+		Token       string
+		Archive     []byte
+		Want        int
+	}{
+		{"no token", "?repo=fn1", "application/x-tar", "", deployArchive("stack.yml"), http.StatusUnauthorized},
+		{"invalid token", "?repo=fn1", "application/x-tar", "Bearer " + signed + "x", deployArchive("stack.yml"), http.StatusUnauthorized},
+		{"session token", "?repo=fn1", "application/x-tar", "Bearer " + sessionSigned, deployArchive("stack.yml"), http.StatusUnauthorized},
+		{"form post", "?repo=fn1", "application/x-www-form-urlencoded", "Bearer " + signed, deployArchive("stack.yml"), http.StatusUnsupportedMediaType},
+		{"another account", "?repo=fn1&user=someone", "application/x-tar", "Bearer " + signed, deployArchive("stack.yml"), http.StatusForbidden},
+		{"not a customer", "?repo=fn1&user=someorg", "application/x-tar", "Bearer " + signed, deployArchive("stack.yml"), http.StatusForbidden},
+		{"no repo", "", "application/x-tar", "Bearer " + signed, deployArchive("stack.yml"), http.StatusBadRequest},
+		{"invalid repo", "?repo=../fn1", "application/x-tar", "Bearer " + signed, deployArchive("stack.yml"), http.StatusBadRequest},
+		{"no stack.yml", "?repo=fn1", "application/x-tar", "Bearer " + signed, deployArchive("fn1/handler.go"), http.StatusBadRequest},
+		{"templates folder", "?repo=fn1", "application/x-tar", "Bearer " + signed, deployArchive("stack.yml", "template/go/Dockerfile"), http.StatusBadRequest},
+		{"escapes the archive", "?repo=fn1", "application/x-tar", "Bearer " + signed, deployArchive("stack.yml", "../fn1/handler.go"), http.StatusBadRequest},
+		{"too large", "?repo=fn1", "application/x-tar", "Bearer " + signed, deployArchive("stack.yml", "a", "b", "c", "d", "e"), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/deploy/"+test.Query, bytes.NewReader(test.Archive))
+			req.Header.Set("Content-Type", test.ContentType)
+			if len(test.Token) > 0 {
+				req.Header.Set("Authorization", test.Token)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			if rr.Code != test.Want {
+				t.Errorf("want %d, got %d %s", test.Want, rr.Code, strings.TrimSpace(rr.Body.String()))
+			}
+		})
+	}
+}
+
+func Test_MakeDeployHandler_GivesToken(t *testing.T) {
+	key, keyPath := writeEventsKey(t)
+	defer os.Remove(keyPath)
+	privateKeyPath := writeDeployKey(t, key)
+	defer os.Remove(privateKeyPath)
+
+	handler := MakeDeployHandler(&Config{PublicKeyPath: keyPath, PrivateKeyPath: privateKeyPath}, "http://127.0.0.1:1/", "secret", 1024, time.Hour)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, OpenFaaSCloudClaims{
+		AccessToken:    "gho_session",
+		Organizations:  "openfaas",
+		StandardClaims: jwt.StandardClaims{Subject: "alexellis"},
+	})
+	signed, _ := token.SignedString(key)
+
+	req := httptest.NewRequest(http.MethodGet, "/deploy/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: signed})
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want a deploy token, got %d %s", rr.Code, rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), signed) {
+		t.Errorf("want the session's token kept back, got %s", rr.Body.String())
+	}
+
+	body := map[string]string{}
+	json.Unmarshal(rr.Body.Bytes(), &body)
+
+	claims := OpenFaaSCloudClaims{}
+	if _, err := jwt.ParseWithClaims(body["token"], &claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "alexellis" || claims.Organizations != "openfaas" || claims.Audience != deployTokenAudience || len(claims.AccessToken) > 0 {
+		t.Errorf("want a deploy token for the user without the access token, got %+v", claims)
+	}
+	if expires, err := time.Parse(time.RFC3339, body["expires"]); err != nil || expires.Unix() != claims.ExpiresAt {
+		t.Errorf("want the token's expiry, got %q", body["expires"])
+	}
+
+	// The deploy token can't stand in for the session
+	req = httptest.NewRequest(http.MethodGet, "/deploy/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: body["token"]})
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("want a deploy token refused as a cookie, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/deploy/", nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("want a token only given for a cookie, got %d", rr.Code)
+	}
+}
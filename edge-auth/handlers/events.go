@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// eventsKeepAlive is how often a comment is sent on an idle stream so
+// that proxies don't close it
+const eventsKeepAlive = 20 * time.Second
+
+// eventsRetryMillis is how long the browser waits before reconnecting
+// once a stream has ended
+const eventsRetryMillis = 3000
+
+// MakeEventsHandler pushes the pipeline-stage and deployment events for
+// the signed-in user and their organizations, so that the dashboard
+// updates without polling. A request which asks to be upgraded is
+// served as a websocket with each event as a text message, any other as
+// server-sent events. A websocket is only accepted from the
+// DashboardOrigin. A stream is held for streamFor, after which the
+// browser reconnects. The "user" query narrows the events to one of the
+// user's accounts.
+func MakeEventsHandler(config *Config, natsURL string, subjects []string, streamFor time.Duration) func(http.ResponseWriter, *http.Request) {
+	keydata, err := ioutil.ReadFile(config.PublicKeyPath)
+	if err != nil {
+		log.Fatalf("unable to read path: %s, error: %s", config.PublicKeyPath, err.Error())
+	}
+
+	publicKey, keyErr := jwt.ParseECPublicKeyFromPEM(keydata)
+	if keyErr != nil {
+		log.Fatalf("unable to parse public key: %s", keyErr.Error())
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := cookieClaims(r, publicKey)
+		if err != nil {
+			log.Printf("Events: %s", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		owners, ok := eventOwners(claims, r.URL.Query().Get("user"))
+		if !ok {
+			log.Printf("Events: %s is not entitled to the events of %s", claims.Subject, r.URL.Query().Get("user"))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if isWebSocket(r) {
+			if !allowedOrigin(r, config.DashboardOrigin) {
+				log.Printf("Events: websocket for %s refused from origin %q", claims.Subject, r.Header.Get("Origin"))
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			conn, err := acceptWebSocket(w, r)
+			if err != nil {
+				log.Printf("Events: websocket for %s failed: %s", claims.Subject, err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer conn.Close()
+
+			streamWebSocket(conn, natsURL, subjects, owners, claims.Subject, streamFor)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		fmt.Fprintf(w, "retry: %d\n\n", eventsRetryMillis)
+		flusher.Flush()
+
+		ctx, cancel := context.WithTimeout(r.Context(), streamFor)
+		defer cancel()
+
+		events, subscribeErr := subscribeEvents(ctx, natsURL, subjects, owners)
+
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-subscribeErr:
+				if err != nil {
+					log.Printf("Events: subscription for %s failed: %s", claims.Subject, err)
+				}
+				return
+			case event := <-events:
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+				flusher.Flush()
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// streamWebSocket sends each event as a text message until streamFor
+// has passed, when the websocket is closed normally, or the client goes
+// away. The client is pinged while there are no events.
+func streamWebSocket(conn *websocketConn, natsURL string, subjects []string, owners map[string]bool, subject string, streamFor time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), streamFor)
+	defer cancel()
+
+	clientGone := make(chan struct{})
+	go func() {
+		conn.ReadMessages()
+		close(clientGone)
+	}()
+
+	events, subscribeErr := subscribeEvents(ctx, natsURL, subjects, owners)
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-clientGone:
+			return
+		case <-ctx.Done():
+			conn.WriteClose(websocketNormalClosure)
+			return
+		case err := <-subscribeErr:
+			if err != nil {
+				log.Printf("Events: subscription for %s failed: %s", subject, err)
+			}
+			conn.WriteClose(websocketInternalError)
+			return
+		case event := <-events:
+			if err := conn.WriteMessage(websocketText, event.Data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteMessage(websocketPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamEvent is an event of one of the owners as published to NATS
+type streamEvent struct {
+	Type string
+	Data []byte
+}
+
+// subscribeEvents passes on the events of the owners until ctx is done.
+// The subscription's error, or nil once it ends, is sent on the second
+// channel.
+func subscribeEvents(ctx context.Context, natsURL string, subjects []string, owners map[string]bool) (<-chan streamEvent, <-chan error) {
+	events := make(chan streamEvent, 16)
+	subscribeErr := make(chan error, 1)
+
+	go func() {
+		subscribeErr <- sdk.SubscribeNATS(ctx, natsURL, subjects, func(subject string, data []byte) {
+			event := sdk.DeploymentEvent{}
+			if err := json.Unmarshal(data, &event); err != nil {
+				return
+			}
+			if !owners[strings.ToLower(event.Owner)] {
+				return
+			}
+
+			// A client which can't keep up misses events rather than
+			// holding up the subscription
+			select {
+			case events <- streamEvent{Type: event.Type, Data: data}:
+			default:
+			}
+		})
+	}()
+
+	return events, subscribeErr
+}
+
+// cookieClaims gives the claims of the signed-in user's cookie, once its
+// signature has been verified
+func cookieClaims(r *http.Request, publicKey crypto.PublicKey) (*OpenFaaSCloudClaims, error) {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil || len(cookie.Value) == 0 {
+		return nil, fmt.Errorf("no cookie")
+	}
+
+	claims := OpenFaaSCloudClaims{}
+	parsed, err := jwt.ParseWithClaims(cookie.Value, &claims, func(token *jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !parsed.Valid || claims.Audience == deployTokenAudience {
+		return nil, fmt.Errorf("invalid cookie")
+	}
+
+	return &claims, nil
+}
+
+// eventOwners gives the accounts whose events the user may see, which
+// are their own and their organizations'. When user is given only it is
+// returned, and ok is false when it is not one of these.
+func eventOwners(claims *OpenFaaSCloudClaims, user string) (map[string]bool, bool) {
+	owners := map[string]bool{
+		strings.ToLower(claims.Subject): true,
+	}
+	for _, organization := range strings.Split(claims.Organizations, ",") {
+		if organization = strings.TrimSpace(organization); len(organization) > 0 {
+			owners[strings.ToLower(organization)] = true
+		}
+	}
+
+	if len(user) == 0 {
+		return owners, true
+	}
+
+	user = strings.ToLower(user)
+	if !owners[user] {
+		return nil, false
+	}
+	return map[string]bool{user: true}, true
+}
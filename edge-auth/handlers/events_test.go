@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func Test_eventOwners(t *testing.T) {
+	claims := &OpenFaaSCloudClaims{
+		Organizations:  "openfaas, OpenFaaS-Incubator",
+		StandardClaims: jwt.StandardClaims{Subject: "alexellis"},
+	}
+
+	owners, ok := eventOwners(claims, "")
+	if !ok || len(owners) != 3 || !owners["alexellis"] || !owners["openfaas-incubator"] {
+		t.Errorf("want the user and their organizations, got %v", owners)
+	}
+
+	owners, ok = eventOwners(claims, "openfaas")
+	if !ok || len(owners) != 1 || !owners["openfaas"] {
+		t.Errorf("want only the organization, got %v", owners)
+	}
+
+	if _, ok = eventOwners(claims, "someone"); ok {
+		t.Errorf("want another account rejected")
+	}
+}
+
+// eventsNATS accepts one subscriber and sends it msgs once it has
+// subscribed, the connection is then held open
+func eventsNATS(t *testing.T, msgs ...string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\n")
+
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.TrimSpace(line) == "PING" {
+				break
+			}
+		}
+
+		fmt.Fprint(conn, "PONG\r\n")
+		for _, msg := range msgs {
+			fmt.Fprintf(conn, "MSG openfaas-cloud.pipeline 1 %d\r\n%s\r\n", len(msg), msg)
+		}
+
+		reader.ReadString('\n')
+	}()
+
+	return "nats://" + listener.Addr().String()
+}
+
+func writeEventsKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := ioutil.TempFile("", "edge-auth-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	pem.Encode(file, &pem.Block{Type: "PUBLIC KEY", Bytes: public})
+	return key, file.Name()
+}
+
+func Test_MakeEventsHandler(t *testing.T) {
+	key, keyPath := writeEventsKey(t)
+	defer os.Remove(keyPath)
+
+	natsURL := eventsNATS(t,
+		`{"type":"pipeline-stage","owner":"someone","function":"someone-fn1"}`,
+		`{"type":"pipeline-stage","owner":"openfaas","function":"openfaas-fn1","status":"pending"}`,
+	)
+
+	handler := MakeEventsHandler(&Config{PublicKeyPath: keyPath}, natsURL, []string{"openfaas-cloud.pipeline"}, 500*time.Millisecond)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, OpenFaaSCloudClaims{
+		Organizations:  "openfaas",
+		StandardClaims: jwt.StandardClaims{Subject: "alexellis"},
+	})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/events/", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: signed})
+	rr := httptest.NewRecorder()
+
+	handler(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("want an event stream, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "event: pipeline-stage\ndata: {\"type\":\"pipeline-stage\",\"owner\":\"openfaas\"") {
+		t.Errorf("want the organization's event, got %q", body)
+	}
+	if strings.Contains(body, "someone-fn1") {
+		t.Errorf("want another account's event left out, got %q", body)
+	}
+}
+
+func Test_MakeEventsHandler_NoCookie(t *testing.T) {
+	_, keyPath := writeEventsKey(t)
+	defer os.Remove(keyPath)
+
+	handler := MakeEventsHandler(&Config{PublicKeyPath: keyPath}, "nats://127.0.0.1:1", nil, time.Second)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/events/", nil))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("want %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+// readServerFrame reads an unmasked frame sent by the server
+func readServerFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(reader, head); err != nil {
+		t.Fatal(err)
+	}
+
+	size := int(head[1] & 0x7f)
+	if size == 126 {
+		extended := make([]byte, 2)
+		io.ReadFull(reader, extended)
+		size = int(binary.BigEndian.Uint16(extended))
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+func Test_MakeEventsHandler_WebSocket(t *testing.T) {
+	key, keyPath := writeEventsKey(t)
+	defer os.Remove(keyPath)
+
+	natsURL := eventsNATS(t,
+		`{"type":"pipeline-stage","owner":"someone","function":"someone-fn1"}`,
+		`{"type":"function-deployed","owner":"alexellis","function":"alexellis-fn1"}`,
+	)
+
+	handler := MakeEventsHandler(&Config{PublicKeyPath: keyPath, DashboardOrigin: "https://system.example.com"}, natsURL, []string{"openfaas-cloud.pipeline"}, 500*time.Millisecond)
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	defer s.Close()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, OpenFaaSCloudClaims{
+		StandardClaims: jwt.StandardClaims{Subject: "alexellis"},
+	})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(s.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET /events/ HTTP/1.1\r\nHost: auth\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Origin: https://system.example.com\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nCookie: %s=%s\r\n\r\n", cookieName, signed)
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("want the websocket accepted, got %d %q", res.StatusCode, res.Header.Get("Sec-WebSocket-Accept"))
+	}
+
+	opcode, payload := readServerFrame(t, reader)
+	if opcode != websocketText || !strings.Contains(string(payload), `"owner":"alexellis"`) {
+		t.Errorf("want the user's event as a text message, got %d %q", opcode, payload)
+	}
+
+	opcode, payload = readServerFrame(t, reader)
+	if opcode != websocketClose || binary.BigEndian.Uint16(payload) != websocketNormalClosure {
+		t.Errorf("want the websocket closed once the stream ends, got %d %v", opcode, payload)
+	}
+}
+
+func Test_MakeEventsHandler_WebSocketOrigin(t *testing.T) {
+	key, keyPath := writeEventsKey(t)
+	defer os.Remove(keyPath)
+
+	handler := MakeEventsHandler(&Config{PublicKeyPath: keyPath, DashboardOrigin: "https://system.example.com"}, "nats://127.0.0.1:1", nil, time.Second)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, OpenFaaSCloudClaims{
+		StandardClaims: jwt.StandardClaims{Subject: "alexellis"},
+	})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, origin := range []string{"", "https://someone.example.com", "https://system.example.com.attacker.com"} {
+		req := httptest.NewRequest(http.MethodGet, "/events/", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if len(origin) > 0 {
+			req.Header.Set("Origin", origin)
+		}
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: signed})
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("want a websocket from %q refused, got %d", origin, rr.Code)
+		}
+	}
+}
+
+func Test_isWebSocket(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/events/", nil)
+	if isWebSocket(req) {
+		t.Errorf("want a plain request served as server-sent events")
+	}
+
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	if !isWebSocket(req) {
+		t.Errorf("want the upgrade to a websocket recognised")
+	}
+}
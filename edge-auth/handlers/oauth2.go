@@ -13,6 +13,7 @@ import (
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/openfaas/openfaas-cloud/edge-auth/provider"
+	"github.com/openfaas/openfaas-cloud/sdk"
 )
 
 const profileFetchTimeout = time.Second * 5
@@ -161,6 +162,7 @@ func MakeOAuth2Handler(config *Config) func(http.ResponseWriter, *http.Request)
 			Path:     "/",
 			Expires:  time.Now().Add(config.CookieExpiresIn),
 			Domain:   config.CookieRootDomain,
+			SameSite: http.SameSiteLaxMode,
 		})
 
 		log.Printf("SetCookie done, redirect to: %s", reqQuery)
@@ -193,7 +195,7 @@ func createSession(token ProviderAccessToken, privateKey crypto.PrivateKey, conf
 	organizationList := ""
 
 	if providerName == "github" {
-		github := provider.NewGitHub(http.DefaultClient)
+		github := provider.NewGitHub(sdk.HTTPClient())
 
 		organizations, organizationsErr := github.GetUserOrganizations(token.AccessToken)
 		if organizationsErr != nil {
@@ -123,7 +123,8 @@ func validCookie(r *http.Request, cookieName string, publicKey crypto.PublicKey,
 			return http.StatusUnauthorized
 		}
 
-		if parsed.Valid {
+		// A deploy token may only be used to deploy, not as a session
+		if parsed.Valid && claims.Audience != deployTokenAudience {
 			if debug {
 				log.Println("Claims", claims)
 				log.Printf("Validated JWT for (%s) %s", claims.Subject, claims.Name)
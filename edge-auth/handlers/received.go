@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/alexellis/hmac"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// MakeReceivedEventsHandler lets a signed-in user list the recent events
+// github-event received for their account or one of their organizations
+// with a GET, and replay one of them with a POST of {"id": "..."}. The
+// "user" query selects the account, the user's own by default. Requests
+// are passed on to github-event signed with the payload-secret.
+func MakeReceivedEventsHandler(config *Config, gatewayURL string, payloadSecret string) func(http.ResponseWriter, *http.Request) {
+	keydata, err := ioutil.ReadFile(config.PublicKeyPath)
+	if err != nil {
+		log.Fatalf("unable to read path: %s, error: %s", config.PublicKeyPath, err.Error())
+	}
+
+	publicKey, keyErr := jwt.ParseECPublicKeyFromPEM(keydata)
+	if keyErr != nil {
+		log.Fatalf("unable to parse public key: %s", keyErr.Error())
+	}
+
+	gatewayURL = strings.TrimSuffix(gatewayURL, "/") + "/"
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := cookieClaims(r, publicKey)
+		if err != nil {
+			log.Printf("Received events: %s", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		user := r.URL.Query().Get("user")
+		if len(user) == 0 {
+			user = claims.Subject
+		}
+
+		if _, ok := eventOwners(claims, user); !ok {
+			log.Printf("Received events: %s is not entitled to the events of %s", claims.Subject, user)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		receivedReq := sdk.ReceivedEventRequest{Owner: strings.ToLower(user)}
+		action := "received"
+
+		if r.Method == http.MethodPost {
+			// A form can't be posted as JSON from another site without
+			// a preflight request
+			if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+
+			replayReq := sdk.ReceivedEventRequest{}
+			if err := json.NewDecoder(r.Body).Decode(&replayReq); err != nil || len(replayReq.ID) == 0 {
+				http.Error(w, "id is required", http.StatusBadRequest)
+				return
+			}
+
+			receivedReq.ID = replayReq.ID
+			action = "replay-received"
+			log.Printf("Received events: %s replaying %s for %s", claims.Subject, replayReq.ID, receivedReq.Owner)
+		}
+
+		body, _ := json.Marshal(receivedReq)
+		req, _ := http.NewRequest(http.MethodPost, gatewayURL+"function/github-event?action="+action, bytes.NewReader(body))
+		digest := hmac.Sign(body, []byte(payloadSecret))
+		req.Header.Set(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+		res, err := sdk.HTTPClient().Do(req)
+		if err != nil {
+			log.Printf("Received events: %s", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+
+		resBody, _ := ioutil.ReadAll(res.Body)
+		if res.StatusCode != http.StatusOK {
+			log.Printf("Received events: unexpected status code from github-event: %d", res.StatusCode)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(receivedStatus(resBody))
+		w.Write(resBody)
+	}
+}
+
+// receivedStatus gives the status of github-event's response, which is
+// a list of events or an sdk.Response whose code is used
+func receivedStatus(body []byte) int {
+	res := sdk.Response{}
+	if err := json.Unmarshal(body, &res); err != nil || res.Code == 0 {
+		return http.StatusOK
+	}
+	return res.Code
+}
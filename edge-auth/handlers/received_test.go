@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alexellis/hmac"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_MakeReceivedEventsHandler(t *testing.T) {
+	key, keyPath := writeEventsKey(t)
+	defer os.Remove(keyPath)
+
+	var got sdk.ReceivedEventRequest
+	var gotAction string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		digest := hmac.Sign(body, []byte("secret"))
+		if r.Header.Get(sdk.CloudSignatureHeader) != "sha1="+hex.EncodeToString(digest) {
+			t.Errorf("want the request signed with the payload-secret")
+		}
+		json.Unmarshal(body, &got)
+		gotAction = r.URL.Query().Get("action")
+
+		if gotAction == "received" {
+			w.Write([]byte(`[{"id":"guid-1","event":"push","repo":"fn1"}]`))
+			return
+		}
+		w.Write([]byte(`{"status":"accepted","code":202,"reason":"[github-push]: git-tar invoked"}`))
+	}))
+	defer gateway.Close()
+
+	handler := MakeReceivedEventsHandler(&Config{PublicKeyPath: keyPath}, gateway.URL, "secret")
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, OpenFaaSCloudClaims{
+		Organizations:  "openfaas",
+		StandardClaims: jwt.StandardClaims{Subject: "alexellis"},
+	})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/received/?user=OpenFaaS", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: signed})
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "guid-1") {
+		t.Fatalf("want the events listed, got %d %s", rr.Code, rr.Body.String())
+	}
+	if gotAction != "received" || got.Owner != "openfaas" {
+		t.Errorf("want the organization's events listed, got %s %+v", gotAction, got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/received/", strings.NewReader(`{"id":"guid-1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: signed})
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want the event replayed, got %d %s", rr.Code, rr.Body.String())
+	}
+	if gotAction != "replay-received" || got.Owner != "alexellis" || got.ID != "guid-1" {
+		t.Errorf("want the user's event replayed, got %s %+v", gotAction, got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/received/?user=someone", nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: signed})
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("want another account's events forbidden, got %d", rr.Code)
+	}
+}
+
+func Test_MakeReceivedEventsHandler_ReplayNeedsJSON(t *testing.T) {
+	key, keyPath := writeEventsKey(t)
+	defer os.Remove(keyPath)
+
+	handler := MakeReceivedEventsHandler(&Config{PublicKeyPath: keyPath}, "http://127.0.0.1:1/", "secret")
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, OpenFaaSCloudClaims{
+		StandardClaims: jwt.StandardClaims{Subject: "alexellis"},
+	})
+	signed, _ := token.SignedString(key)
+
+	req := httptest.NewRequest(http.MethodPost, "/received/", strings.NewReader("id=guid-1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: signed})
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("want a form post refused, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client's key to accept the
+// handshake, see RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes of the websocket frames
+const (
+	websocketText  = 0x1
+	websocketClose = 0x8
+	websocketPing  = 0x9
+	websocketPong  = 0xA
+)
+
+// Status codes sent in a close frame
+const (
+	websocketNormalClosure = 1000
+	websocketInternalError = 1011
+)
+
+// websocketMaxFrame limits the frames read from the client, which only
+// sends control frames to the events endpoint
+const websocketMaxFrame = 4096
+
+// websocketWriteTimeout is how long a frame may take to write before
+// the client is treated as gone
+const websocketWriteTimeout = 10 * time.Second
+
+// isWebSocket is true when the request asks to be upgraded to a
+// websocket
+func isWebSocket(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+
+	for _, value := range r.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowedOrigin is true when the websocket was opened by a page of the
+// origin. The cookie is sent with a websocket from any page, including
+// a function's sub-domain, so the browser's Origin is checked instead.
+func allowedOrigin(r *http.Request, origin string) bool {
+	return len(origin) > 0 && strings.EqualFold(r.Header.Get("Origin"), strings.TrimSuffix(origin, "/"))
+}
+
+// websocketConn is the server's end of a websocket, its frames are
+// written by one goroutine at a time
+type websocketConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex
+}
+
+// acceptWebSocket completes the handshake and takes the connection over
+// from the server, the caller closes it
+func acceptWebSocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if len(key) == 0 || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("unsupported websocket handshake")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("websockets are not supported")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// The server's read and write timeouts are still set on the
+	// connection, it is held open until the stream ends
+	conn.SetDeadline(time.Time{})
+
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(digest[:]))
+
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &websocketConn{conn: conn, rw: rw}, nil
+}
+
+// WriteMessage writes the payload as a single frame, which is not
+// masked as it is sent by the server
+func (c *websocketConn) WriteMessage(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch size := len(payload); {
+	case size < 126:
+		header = append(header, byte(size))
+	case size <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(size))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(size))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// WriteClose sends a close frame with the status code
+func (c *websocketConn) WriteClose(code uint16) error {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	return c.WriteMessage(websocketClose, payload)
+}
+
+// ReadMessages reads the client's frames, answering its pings, until it
+// closes the websocket or the connection fails. Any messages are
+// dropped since the events endpoint only sends.
+func (c *websocketConn) ReadMessages() error {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case websocketClose:
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			c.WriteMessage(websocketClose, payload)
+			return nil
+		case websocketPing:
+			if err := c.WriteMessage(websocketPong, payload); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readFrame reads a frame from the client, which must be masked
+func (c *websocketConn) readFrame() (byte, []byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.rw, head); err != nil {
+		return 0, nil, err
+	}
+
+	opcode := head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	size := uint64(head[1] & 0x7f)
+
+	switch size {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(c.rw, extended); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(c.rw, extended); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(extended)
+	}
+
+	if !masked {
+		return 0, nil, fmt.Errorf("websocket frame from the client is not masked")
+	}
+	if size > websocketMaxFrame {
+		return 0, nil, fmt.Errorf("websocket frame of %d bytes is too large", size)
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.rw, mask); err != nil {
+		return 0, nil, err
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return opcode, payload, nil
+}
+
+// Close closes the connection without a close frame
+func (c *websocketConn) Close() error {
+	return c.conn.Close()
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/openfaas/openfaas-cloud/edge-auth/handlers"
 	"github.com/openfaas/openfaas-cloud/edge-auth/provider"
+	"github.com/openfaas/openfaas-cloud/sdk"
 )
 
 const cookieExpiry = time.Hour * 48
@@ -82,6 +85,11 @@ func main() {
 		writeDebug = true
 	}
 
+	dashboardOrigin := defaultDashboardOrigin(externalRedirectDomain)
+	if val, exists := os.LookupEnv("dashboard_origin"); exists && len(val) > 0 {
+		dashboardOrigin = val
+	}
+
 	config := &handlers.Config{
 		OAuthProvider:          strings.ToLower(oauthProvider),
 		OAuthProviderBaseURL:   oauthProviderBaseURL,
@@ -95,6 +103,7 @@ func main() {
 		PrivateKeyPath:         privateKeyPath,
 		OAuthClientSecretPath:  oauthClientSecretPath,
 		Debug:                  writeDebug,
+		DashboardOrigin:        dashboardOrigin,
 	}
 
 	protected := []string{
@@ -113,10 +122,14 @@ func main() {
 		"/function/import-secrets",
 		"/function/pipeline-log",
 		"/function/list-functions",
+		"/function/cost-estimate",
 		"/function/audit-event",
 		"/function/echo",
 		"/function/metrics",
 		"/function/function-logs",
+		"/function/event-consumer",
+		"/function/platform-upgrade",
+		"/function/pipeline-watchdog",
 
 		//AWS
 		"/function/register-image",
@@ -124,6 +137,9 @@ func main() {
 		// GitLab
 		"/function/gitlab-status",
 		"/function/gitlab-push",
+
+		// Bitbucket
+		"/function/bitbucket-status",
 	}
 
 	fs := http.FileServer(http.Dir("static"))
@@ -142,6 +158,46 @@ func main() {
 	})
 
 	timeout := time.Second * 10
+	writeTimeout := timeout
+
+	// The dashboard's live events are served when NATS is set up for the
+	// pipeline's events, each stream has to end before the edge-router's
+	// own timeout
+	if natsURL := os.Getenv("nats_url"); len(natsURL) > 0 {
+		streamFor := time.Second * 50
+		if val, err := time.ParseDuration(os.Getenv("events_timeout")); err == nil && val > 0 {
+			streamFor = val
+		}
+
+		subjects := []string{sdk.PipelineSubject(), sdk.DeploymentSubject()}
+		router.HandleFunc("/events/", handlers.MakeEventsHandler(config, natsURL, subjects, streamFor))
+		writeTimeout = streamFor + timeout
+	}
+
+	// Owners list and replay the events github-event received for them
+	// when it keeps them, see received_events
+	if payloadSecretPath := os.Getenv("payload_secret_path"); len(payloadSecretPath) > 0 {
+		payloadSecret, err := ioutil.ReadFile(payloadSecretPath)
+		if err != nil {
+			log.Fatalf("unable to read path: %s, error: %s", payloadSecretPath, err.Error())
+		}
+
+		gatewayURL := os.Getenv("gateway_url")
+		router.HandleFunc("/received/", handlers.MakeReceivedEventsHandler(config, gatewayURL, strings.TrimSpace(string(payloadSecret))))
+
+		// Owners deploy an archive of their stack with the CLI, it is
+		// queued for git-tar so has to fit in a NATS message
+		maxArchiveSize := int64(512 * 1024)
+		if val, err := strconv.ParseInt(os.Getenv("max_archive_size"), 10, 64); err == nil && val > 0 {
+			maxArchiveSize = val
+		}
+		deployTokenExpiry := time.Hour * 12
+		if val, err := time.ParseDuration(os.Getenv("deploy_token_expiry")); err == nil && val > 0 {
+			deployTokenExpiry = val
+		}
+		router.HandleFunc("/deploy/", handlers.MakeDeployHandler(config, gatewayURL, strings.TrimSpace(string(payloadSecret)), maxArchiveSize, deployTokenExpiry))
+	}
+
 	port := 8080
 	if v, exists := os.LookupEnv("port"); exists {
 		val, _ := strconv.Atoi(v)
@@ -154,9 +210,20 @@ func main() {
 		Addr:           fmt.Sprintf(":%d", port),
 		Handler:        router,
 		ReadTimeout:    timeout,
-		WriteTimeout:   timeout,
+		WriteTimeout:   writeTimeout,
 		MaxHeaderBytes: 1 << 20,
 	}
 
 	log.Fatal(s.ListenAndServe())
 }
+
+// defaultDashboardOrigin is the system sub-domain which edge-auth is
+// reached under, i.e. http://system.example.com for an
+// external_redirect_domain of http://auth.system.example.com
+func defaultDashboardOrigin(externalRedirectDomain string) string {
+	u, err := url.Parse(externalRedirectDomain)
+	if err != nil || len(u.Host) == 0 {
+		return ""
+	}
+	return u.Scheme + "://" + strings.TrimPrefix(u.Host, "auth.")
+}
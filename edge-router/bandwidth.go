@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OwnerBandwidth is the traffic proxied for an owner's functions since
+// the router started
+type OwnerBandwidth struct {
+	Owner    string `json:"owner"`
+	BytesIn  int64  `json:"bytesIn"`
+	BytesOut int64  `json:"bytesOut"`
+}
+
+// BandwidthMeter counts the bytes received from and sent to clients
+// for each owner, the owner being resolved from the sub-domain
+type BandwidthMeter struct {
+	owners map[string]*OwnerBandwidth
+	mutex  sync.RWMutex
+}
+
+// NewBandwidthMeter creates an empty BandwidthMeter
+func NewBandwidthMeter() *BandwidthMeter {
+	return &BandwidthMeter{
+		owners: map[string]*OwnerBandwidth{},
+	}
+}
+
+// Add records the request and response bytes of a call
+func (m *BandwidthMeter) Add(owner string, bytesIn, bytesOut int64) {
+	if m == nil {
+		return
+	}
+
+	owner = strings.ToLower(owner)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	usage, ok := m.owners[owner]
+	if !ok {
+		usage = &OwnerBandwidth{Owner: owner}
+		m.owners[owner] = usage
+	}
+
+	usage.BytesIn += bytesIn
+	usage.BytesOut += bytesOut
+}
+
+// Usage returns a copy of the counters sorted by owner
+func (m *BandwidthMeter) Usage() []OwnerBandwidth {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	usage := []OwnerBandwidth{}
+	for _, v := range m.owners {
+		usage = append(usage, *v)
+	}
+
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Owner < usage[j].Owner
+	})
+
+	return usage
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// makeMetricsHandler exposes the counters in the Prometheus text format
+func makeMetricsHandler(m *BandwidthMeter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		usage := m.Usage()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		fmt.Fprintln(w, "# HELP edge_router_owner_bytes_in_total Bytes received from clients for an owner's functions.")
+		fmt.Fprintln(w, "# TYPE edge_router_owner_bytes_in_total counter")
+		for _, u := range usage {
+			fmt.Fprintf(w, "edge_router_owner_bytes_in_total{owner=%q} %d\n", u.Owner, u.BytesIn)
+		}
+
+		fmt.Fprintln(w, "# HELP edge_router_owner_bytes_out_total Bytes sent to clients from an owner's functions.")
+		fmt.Fprintln(w, "# TYPE edge_router_owner_bytes_out_total counter")
+		for _, u := range usage {
+			fmt.Fprintf(w, "edge_router_owner_bytes_out_total{owner=%q} %d\n", u.Owner, u.BytesOut)
+		}
+	}
+}
+
+// makeMeteringHandler returns the counters as JSON, filtered to a
+// single owner with ?owner=alexellis
+func makeMeteringHandler(m *BandwidthMeter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		usage := m.Usage()
+
+		if owner := strings.ToLower(r.URL.Query().Get("owner")); len(owner) > 0 {
+			filtered := []OwnerBandwidth{}
+			for _, u := range usage {
+				if u.Owner == owner {
+					filtered = append(filtered, u)
+				}
+			}
+			usage = filtered
+		}
+
+		bytesOut, _ := json.Marshal(usage)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(bytesOut)
+	}
+}
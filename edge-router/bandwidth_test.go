@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_makeHandler_CountsBandwidthPerOwner(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte("echo " + string(body)))
+	}))
+	defer gateway.Close()
+
+	meter := NewBandwidthMeter()
+	router := httptest.NewServer(passHandler{
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: gateway.URL, Meter: meter}),
+	})
+	defer router.Close()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodPost, router.URL+"/fn1", strings.NewReader("hello"))
+		req.Host = "AlexEllis.example.xyz"
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+
+	usage := meter.Usage()
+	if len(usage) != 1 {
+		t.Fatalf("want usage for 1 owner, got %v", usage)
+	}
+
+	want := OwnerBandwidth{Owner: "alexellis", BytesIn: 10, BytesOut: 20}
+	if usage[0] != want {
+		t.Errorf("want %v, got %v", want, usage[0])
+	}
+}
+
+func Test_BandwidthMeter_NilIsNoop(t *testing.T) {
+	var meter *BandwidthMeter
+	meter.Add("alexellis", 1, 1)
+}
+
+func Test_makeMetricsHandler(t *testing.T) {
+	meter := NewBandwidthMeter()
+	meter.Add("alexellis", 10, 20)
+
+	rr := httptest.NewRecorder()
+	makeMetricsHandler(meter)(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		`edge_router_owner_bytes_in_total{owner="alexellis"} 10`,
+		`edge_router_owner_bytes_out_total{owner="alexellis"} 20`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %s in:\n%s", want, body)
+		}
+	}
+}
+
+func Test_makeMeteringHandler_FiltersByOwner(t *testing.T) {
+	meter := NewBandwidthMeter()
+	meter.Add("alexellis", 10, 20)
+	meter.Add("openfaas", 1, 2)
+
+	rr := httptest.NewRecorder()
+	makeMeteringHandler(meter)(rr, httptest.NewRequest(http.MethodGet, "/metering?owner=openfaas", nil))
+
+	usage := []OwnerBandwidth{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &usage); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(usage) != 1 || usage[0].Owner != "openfaas" || usage[0].BytesOut != 2 {
+		t.Errorf("want only openfaas usage, got %v", usage)
+	}
+}
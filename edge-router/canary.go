@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// canaryWeightLabel is set by buildshiprun on a canary with the
+// percentage of the stable function's traffic it should receive
+const canaryWeightLabel = "com.openfaas.cloud.canary-weight"
+
+const canarySuffix = "-canary"
+
+// CanaryTable holds the percentage of traffic sent to the canary of
+// a function, keyed by the function's name on the gateway such as
+// alexellis-fn1 or fn1.openfaas-fn-alexellis
+type CanaryTable struct {
+	weights map[string]int
+	mutex   sync.RWMutex
+}
+
+// NewCanaryTable creates an empty CanaryTable
+func NewCanaryTable() *CanaryTable {
+	return &CanaryTable{
+		weights: map[string]int{},
+	}
+}
+
+// Set replaces the weights in the table
+func (t *CanaryTable) Set(weights map[string]int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.weights = weights
+}
+
+// Route returns the function path to proxy to, which is the canary's
+// for the configured share of requests
+func (t *CanaryTable) Route(functionPath string) string {
+	if t == nil {
+		return functionPath
+	}
+
+	key := functionPath
+	rest := ""
+	if index := strings.IndexAny(functionPath, "/?"); index > -1 {
+		key = functionPath[:index]
+		rest = functionPath[index:]
+	}
+
+	t.mutex.RLock()
+	weight := t.weights[key]
+	t.mutex.RUnlock()
+
+	if weight <= 0 || rand.Intn(100) >= weight {
+		return functionPath
+	}
+
+	return canaryName(key) + rest
+}
+
+// canaryName inserts the canary suffix before any namespace
+func canaryName(key string) string {
+	if index := strings.Index(key, "."); index > -1 {
+		return key[:index] + canarySuffix + key[index:]
+	}
+	return key + canarySuffix
+}
+
+// gatewayFunction is the subset of the gateway's function status
+// which is needed to find canaries and OpenAPI specs
+type gatewayFunction struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// key is the function's name on the gateway, with its namespace when
+// it was listed from an owner's namespace
+func (fn gatewayFunction) key() string {
+	if len(fn.Namespace) > 0 {
+		return fn.Name + "." + fn.Namespace
+	}
+	return fn.Name
+}
+
+// listFunctions reads the functions from the gateway. When
+// namespacePrefix is set the functions in each owner's namespace
+// are listed.
+func listFunctions(c *http.Client, upstreamURL string, namespacePrefix string) ([]gatewayFunction, error) {
+	namespaces := []string{""}
+
+	if len(namespacePrefix) > 0 {
+		all := []string{}
+		if err := getGatewayJSON(c, upstreamURL+"system/namespaces", &all); err != nil {
+			return nil, err
+		}
+
+		namespaces = []string{}
+		for _, ns := range all {
+			if strings.HasPrefix(ns, namespacePrefix) {
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+
+	all := []gatewayFunction{}
+	for _, ns := range namespaces {
+		functionsURL := upstreamURL + "system/functions"
+		if len(ns) > 0 {
+			functionsURL = functionsURL + "?namespace=" + ns
+		}
+
+		functions := []gatewayFunction{}
+		if err := getGatewayJSON(c, functionsURL, &functions); err != nil {
+			return nil, err
+		}
+
+		for _, fn := range functions {
+			fn.Namespace = ns
+			all = append(all, fn)
+		}
+	}
+
+	return all, nil
+}
+
+// Refresh reads canaries and their weights from the gateway
+func (t *CanaryTable) Refresh(c *http.Client, upstreamURL string, namespacePrefix string) error {
+	functions, err := listFunctions(c, upstreamURL, namespacePrefix)
+	if err != nil {
+		return err
+	}
+
+	weights := map[string]int{}
+	for _, fn := range functions {
+		if !strings.HasSuffix(fn.Name, canarySuffix) {
+			continue
+		}
+
+		weight, err := strconv.Atoi(fn.Labels[canaryWeightLabel])
+		if err != nil || weight <= 0 {
+			continue
+		}
+		if weight > 100 {
+			weight = 100
+		}
+
+		stable := fn
+		stable.Name = strings.TrimSuffix(fn.Name, canarySuffix)
+		weights[stable.key()] = weight
+	}
+
+	t.Set(weights)
+	return nil
+}
+
+// Watch refreshes the table on an interval until the process exits
+func (t *CanaryTable) Watch(c *http.Client, upstreamURL string, namespacePrefix string, interval time.Duration) {
+	for {
+		if err := t.Refresh(c, upstreamURL, namespacePrefix); err != nil {
+			log.Printf("Canary refresh error: %s\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func getGatewayJSON(c *http.Client, uri string, target interface{}) error {
+	req, _ := http.NewRequest(http.MethodGet, uri, nil)
+
+	if user, password, ok := readBasicAuth(); ok {
+		req.SetBasicAuth(user, password)
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from %s: %d", uri, res.StatusCode)
+	}
+
+	return json.Unmarshal(body, target)
+}
+
+// readBasicAuth reads the gateway's credentials from the
+// basic-auth-user and basic-auth-password secrets if mounted
+func readBasicAuth() (string, string, bool) {
+	basePath := "/var/openfaas/secrets/"
+	if val, ok := os.LookupEnv("secret_mount_path"); ok && len(val) > 0 {
+		basePath = val
+	}
+
+	user, userErr := ioutil.ReadFile(path.Join(basePath, "basic-auth-user"))
+	password, passwordErr := ioutil.ReadFile(path.Join(basePath, "basic-auth-password"))
+	if userErr != nil || passwordErr != nil {
+		return "", "", false
+	}
+
+	return strings.TrimSpace(string(user)), strings.TrimSpace(string(password)), true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_CanaryTable_Route(t *testing.T) {
+	table := NewCanaryTable()
+	table.Set(map[string]int{
+		"alexellis-fn1":             100,
+		"fn2.openfaas-fn-alexellis": 100,
+		"alexellis-fn3":             0,
+	})
+
+	tests := []struct {
+		Scenario string
+		Path     string
+		Want     string
+	}{
+		{"canary with sub-path", "alexellis-fn1/users?id=1", "alexellis-fn1-canary/users?id=1"},
+		{"canary in owner namespace", "fn2.openfaas-fn-alexellis", "fn2-canary.openfaas-fn-alexellis"},
+		{"zero weight", "alexellis-fn3", "alexellis-fn3"},
+		{"no canary", "alexellis-fn4", "alexellis-fn4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			if got := table.Route(test.Path); got != test.Want {
+				t.Errorf("want %s, got %s", test.Want, got)
+			}
+		})
+	}
+}
+
+func Test_CanaryTable_RouteNilTable(t *testing.T) {
+	var table *CanaryTable
+	if got := table.Route("alexellis-fn1"); got != "alexellis-fn1" {
+		t.Errorf("want alexellis-fn1, got %s", got)
+	}
+}
+
+func Test_CanaryTable_Refresh(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]gatewayFunction{
+			{Name: "alexellis-fn1"},
+			{Name: "alexellis-fn1-canary", Labels: map[string]string{canaryWeightLabel: "25"}},
+			{Name: "alexellis-fn2-canary", Labels: map[string]string{canaryWeightLabel: "invalid"}},
+		})
+	}))
+	defer gateway.Close()
+
+	table := NewCanaryTable()
+	if err := table.Refresh(http.DefaultClient, gateway.URL+"/", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table.weights) != 1 || table.weights["alexellis-fn1"] != 25 {
+		t.Errorf("want weight of 25 for alexellis-fn1 only, got %v", table.weights)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRetryAfter is the delay between retries when the gateway's
+// 503 does not give a Retry-After header
+const defaultRetryAfter = time.Second
+
+// retryAfter reads the Retry-After header, given either as a number of
+// seconds or as a HTTP date
+func retryAfter(val string, now time.Time) time.Duration {
+	if len(val) == 0 {
+		return defaultRetryAfter
+	}
+
+	if seconds, err := strconv.Atoi(val); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(val); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay
+		}
+		return 0
+	}
+
+	return defaultRetryAfter
+}
+
+// doWithColdStart sends the request and, while the function is scaled
+// from zero and the gateway answers 503, holds the request and retries
+// it after the Retry-After delay for up to wait. The last 503 is
+// returned once the next retry would go past wait, so that a function
+// which does not become ready still fails quickly. A wait of zero sends
+// the request once.
+func doWithColdStart(ctx context.Context, c *http.Client, method string, url string, header http.Header, body []byte, wait time.Duration) (*http.Response, error) {
+	deadline := time.Now().Add(wait)
+
+	for attempt := 1; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequest(method, url, reader)
+		if err != nil {
+			return nil, err
+		}
+		copyHeaders(req.Header, &header)
+
+		res, err := c.Do(req.WithContext(ctx))
+		if err != nil || wait == 0 || res.StatusCode != http.StatusServiceUnavailable {
+			return res, err
+		}
+
+		delay := retryAfter(res.Header.Get("Retry-After"), time.Now())
+		if time.Now().Add(delay).After(deadline) {
+			return res, nil
+		}
+
+		if res.Body != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		log.Printf("Cold start %s: attempt %d, retrying in %s\n", url, attempt, delay)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
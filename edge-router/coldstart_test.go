@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		Scenario string
+		Value    string
+		Want     time.Duration
+	}{
+		{"not given", "", defaultRetryAfter},
+		{"seconds", "2", 2 * time.Second},
+		{"http date", now.Add(3 * time.Second).Format(http.TimeFormat), 3 * time.Second},
+		{"date in the past", now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"invalid", "soon", defaultRetryAfter},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			if got := retryAfter(test.Value, now); got != test.Want {
+				t.Errorf("want %s, got %s", test.Want, got)
+			}
+		})
+	}
+}
+
+func Test_makeHandler_RetriesColdStart(t *testing.T) {
+	var calls int32
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ready " + string(body)))
+	}))
+	defer gateway.Close()
+
+	router := httptest.NewServer(passHandler{
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: gateway.URL, ColdStartWait: time.Second * 5}),
+	})
+	defer router.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, router.URL+"/fn1", strings.NewReader("hello"))
+	req.Host = "alexellis.example.xyz"
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || string(body) != "ready hello" {
+		t.Errorf("want the response once scaled, got %d: %s", res.StatusCode, string(body))
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("want 3 calls to the gateway, got %d", got)
+	}
+}
+
+func Test_makeHandler_ColdStartGivesUpAfterWait(t *testing.T) {
+	var calls int32
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer gateway.Close()
+
+	router := httptest.NewServer(passHandler{
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: gateway.URL, ColdStartWait: time.Second * 2}),
+	})
+	defer router.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, router.URL+"/fn1", nil)
+	req.Host = "alexellis.example.xyz"
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("want %d, got %d", http.StatusServiceUnavailable, res.StatusCode)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("want no retry when Retry-After is past the wait, got %d calls", got)
+	}
+}
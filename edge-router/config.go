@@ -13,6 +13,57 @@ type RouterConfig struct {
 	UpstreamURL string
 	AuthURL     string
 	Timeout     time.Duration
+
+	// ShadowRoutes send a copy of live traffic to another function
+	ShadowRoutes ShadowRoutes
+
+	// NamespacePrefix is set when each owner's functions are deployed
+	// into their own namespace i.e. openfaas-fn-alexellis
+	NamespacePrefix string
+
+	// CanaryRefresh is how often canaries are read from the gateway,
+	// 30s by default, traffic splitting is disabled when zero
+	CanaryRefresh time.Duration
+
+	// MetricsPort serves the per-owner bandwidth counters, they are
+	// not served when empty
+	MetricsPort string
+
+	// ColdStartWait is how long a request is held and retried while its
+	// function scales from zero, requests fail straight away when zero
+	ColdStartWait time.Duration
+
+	// OpenAPIRefresh is how often the OpenAPI specs of annotated
+	// functions are read, requests are not validated when zero
+	OpenAPIRefresh time.Duration
+
+	// RoutesRefresh is how often the path prefixes owners route to
+	// their functions are read, they are ignored when zero
+	RoutesRefresh time.Duration
+
+	// MaintenanceRefresh is how often functions in maintenance mode are
+	// read, the annotation is ignored when zero
+	MaintenanceRefresh time.Duration
+
+	// MaintenancePage is the path of an HTML page served for functions
+	// in maintenance mode, the plain message is served when empty
+	MaintenancePage string
+
+	// MaintenanceRetryAfter is sent as the Retry-After header of the
+	// maintenance response when set
+	MaintenanceRetryAfter time.Duration
+
+	// HTTP2 serves HTTP/2 without TLS (h2c) alongside HTTP/1.1, for
+	// when TLS is terminated in front of the router
+	HTTP2 bool
+
+	// UpstreamHTTP2 calls the gateway and auth service with HTTP/2
+	// without TLS, both must support h2c
+	UpstreamHTTP2 bool
+
+	// EarlyHintsFile is the path of a JSON file with the Link values
+	// sent as 103 Early Hints for each host, none are sent when empty
+	EarlyHintsFile string
 }
 
 // NewRouterConfig create a new RouterConfig by loading
@@ -44,6 +95,36 @@ func NewRouterConfig() RouterConfig {
 
 	cfg.Timeout = parseIntOrDurationValue(os.Getenv("timeout"), time.Second*60)
 
+	cfg.ShadowRoutes = parseShadowRoutes(os.Getenv("shadow_routes"))
+
+	cfg.CanaryRefresh = parseIntOrDurationValue(os.Getenv("canary_refresh"), time.Second*30)
+
+	cfg.ColdStartWait = parseIntOrDurationValue(os.Getenv("cold_start_wait"), 0)
+
+	cfg.OpenAPIRefresh = parseIntOrDurationValue(os.Getenv("openapi_refresh"), 0)
+
+	cfg.RoutesRefresh = parseIntOrDurationValue(os.Getenv("routes_refresh"), 0)
+
+	cfg.MaintenanceRefresh = parseIntOrDurationValue(os.Getenv("maintenance_refresh"), 0)
+	cfg.MaintenancePage = os.Getenv("maintenance_page")
+	cfg.MaintenanceRetryAfter = parseIntOrDurationValue(os.Getenv("maintenance_retry_after"), 0)
+
+	cfg.HTTP2 = os.Getenv("http2") == "true"
+	cfg.UpstreamHTTP2 = os.Getenv("upstream_http2") == "true"
+	cfg.EarlyHintsFile = os.Getenv("early_hints_file")
+
+	cfg.MetricsPort = "8081"
+	if val, exists := os.LookupEnv("metrics_port"); exists {
+		cfg.MetricsPort = val
+	}
+
+	if os.Getenv("owner_namespaces") == "true" {
+		cfg.NamespacePrefix = "openfaas-fn-"
+		if val, exists := os.LookupEnv("namespace_prefix"); exists && len(val) > 0 {
+			cfg.NamespacePrefix = val
+		}
+	}
+
 	return cfg
 }
 
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestReadConfig_PortOverride(t *testing.T) {
@@ -43,3 +44,34 @@ func TestReadConfig_UpstreamURLGiven(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestReadConfig_HTTP2(t *testing.T) {
+	os.Setenv("http2", "true")
+	os.Setenv("early_hints_file", "/var/openfaas/early-hints.json")
+	defer os.Unsetenv("http2")
+	defer os.Unsetenv("early_hints_file")
+
+	cfg := NewRouterConfig()
+
+	if !cfg.HTTP2 || cfg.UpstreamHTTP2 {
+		t.Errorf("want HTTP/2 served but not used upstream, got %t %t", cfg.HTTP2, cfg.UpstreamHTTP2)
+	}
+	if cfg.EarlyHintsFile != "/var/openfaas/early-hints.json" {
+		t.Errorf("want the early hints file, got %q", cfg.EarlyHintsFile)
+	}
+}
+
+func TestReadConfig_CanaryRefresh(t *testing.T) {
+	os.Unsetenv("canary_refresh")
+
+	if got := NewRouterConfig().CanaryRefresh; got != 30*time.Second {
+		t.Errorf("want canary refresh 30s by default, got %s", got)
+	}
+
+	os.Setenv("canary_refresh", "0")
+	defer os.Unsetenv("canary_refresh")
+
+	if got := NewRouterConfig().CanaryRefresh; got != 0 {
+		t.Errorf("want canary refresh disabled, got %s", got)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// EarlyHints holds the Link values sent in a 103 Early Hints response,
+// keyed by the host of the request i.e. alexellis.o6s.io
+type EarlyHints map[string][]string
+
+// readEarlyHints reads the early hints from a JSON file such as:
+// {"alexellis.o6s.io": ["</static/app.css>; rel=preload; as=style"]}
+// No hints are sent when path is empty or the file can't be read.
+func readEarlyHints(path string) EarlyHints {
+	hints := EarlyHints{}
+	if len(path) == 0 {
+		return hints
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Unable to read early hints %s: %s\n", path, err)
+		return hints
+	}
+
+	parsed := EarlyHints{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		log.Printf("Unable to parse early hints %s: %s\n", path, err)
+		return hints
+	}
+
+	for host, links := range parsed {
+		hints[strings.ToLower(host)] = links
+	}
+
+	return hints
+}
+
+// Links gives the Link values for the Host header of a request,
+// ignoring any port
+func (h EarlyHints) Links(host string) []string {
+	if len(h) == 0 {
+		return nil
+	}
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return h[strings.ToLower(host)]
+}
+
+// wantsEarlyHints is true for page loads from a browser, other clients
+// and HTTP/1.0 may not expect an informational response
+func wantsEarlyHints(r *http.Request) bool {
+	if r.Method != http.MethodGet || !r.ProtoAtLeast(1, 1) {
+		return false
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// makeEarlyHintsHandler sends a 103 Early Hints response with the Link
+// values of the request's host before next is called, so that a browser
+// can start to fetch a page's assets while the function is running.
+func makeEarlyHintsHandler(hints EarlyHints, next http.HandlerFunc) http.HandlerFunc {
+	if len(hints) == 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if links := hints.Links(r.Host); len(links) > 0 && wantsEarlyHints(r) {
+			for _, link := range links {
+				w.Header().Add("Link", link)
+			}
+			w.WriteHeader(http.StatusEarlyHints)
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_readEarlyHints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "early-hints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "hints.json")
+	ioutil.WriteFile(file, []byte(`{"AlexEllis.o6s.io": ["</app.css>; rel=preload; as=style"]}`), 0600)
+
+	hints := readEarlyHints(file)
+	if links := hints.Links("alexellis.o6s.io:443"); len(links) != 1 || links[0] != "</app.css>; rel=preload; as=style" {
+		t.Errorf("want the links of alexellis.o6s.io, got %v", links)
+	}
+
+	if len(readEarlyHints(path.Join(dir, "missing.json"))) != 0 {
+		t.Errorf("want no hints when the file is missing")
+	}
+}
+
+func Test_makeEarlyHintsHandler(t *testing.T) {
+	hints := EarlyHints{"alexellis.o6s.io": {"</app.css>; rel=preload; as=style", "</app.js>; rel=preload; as=script"}}
+
+	router := httptest.NewServer(makeEarlyHintsHandler(hints, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html></html>"))
+	}))
+	defer router.Close()
+
+	tests := []struct {
+		Scenario string
+		Host     string
+		Accept   string
+		Want     int
+	}{
+		{"page load", "alexellis.o6s.io", "text/html,application/xhtml+xml", 2},
+		{"API call", "alexellis.o6s.io", "application/json", 0},
+		{"host without hints", "openfaas.o6s.io", "text/html", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			links := 0
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					if code == http.StatusEarlyHints {
+						links = len(header["Link"])
+					}
+					return nil
+				},
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, router.URL, nil)
+			req.Host = test.Host
+			req.Header.Set("Accept", test.Accept)
+
+			res, err := http.DefaultClient.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("want the function's response, got %d", res.StatusCode)
+			}
+			if links != test.Want {
+				t.Errorf("want %d links in early hints, got %d", test.Want, links)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dashboardEventsPath is the dashboard's live event stream, it is served
+// by edge-auth which checks the cookie itself
+const dashboardEventsPath = "dashboard/api/events"
+
+func isDashboardEvents(host, requestURI string) bool {
+	if host != "system" {
+		return false
+	}
+	path := strings.SplitN(requestURI, "?", 2)[0]
+	return path == dashboardEventsPath || path == dashboardEventsPath+"/"
+}
+
+// proxyEvents streams edge-auth's server-sent events to the client,
+// flushing each read rather than buffering the response as other
+// requests are. The stream ends when edge-auth closes it or the client
+// goes away. A request to upgrade to a websocket is passed on as one.
+func proxyEvents(w http.ResponseWriter, r *http.Request, c *http.Client, authURL string) {
+	upstreamURL := authURL + "events/"
+	if len(r.URL.RawQuery) > 0 {
+		upstreamURL = upstreamURL + "?" + r.URL.RawQuery
+	}
+
+	if isUpgrade(r, "websocket") {
+		proxyWebSocket(w, r, c, upstreamURL)
+		return
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, upstreamURL, nil)
+	copyHeaders(req.Header, &r.Header)
+
+	res, err := c.Do(req.WithContext(r.Context()))
+	if err != nil {
+		log.Printf("Events: %s", err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("bad gateway reaching auth server"))
+		return
+	}
+	defer res.Body.Close()
+
+	copyHeaders(w.Header(), &res.Header)
+	w.WriteHeader(res.StatusCode)
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 4096)
+	for {
+		n, readErr := res.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr != nil {
+			return
+		}
+	}
+}
+
+// isUpgrade is true when the request asks to upgrade to the protocol
+func isUpgrade(r *http.Request, protocol string) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), protocol) {
+		return false
+	}
+
+	for _, value := range r.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// proxyWebSocket upgrades the connection to edge-auth, then takes over
+// the client's connection and copies the frames both ways until either
+// end closes. A response other than the upgrade is passed back as-is.
+func proxyWebSocket(w http.ResponseWriter, r *http.Request, c *http.Client, upstreamURL string) {
+	req, _ := http.NewRequest(http.MethodGet, upstreamURL, nil)
+	copyHeaders(req.Header, &r.Header)
+
+	res, err := c.Do(req.WithContext(r.Context()))
+	if err != nil {
+		log.Printf("Events: %s", err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("bad gateway reaching auth server"))
+		return
+	}
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		defer res.Body.Close()
+
+		copyHeaders(w.Header(), &res.Header)
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+		return
+	}
+
+	upstream, ok := res.Body.(io.ReadWriteCloser)
+	if !ok {
+		res.Body.Close()
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets are not supported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("Events: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	// The websocket is held open past the server's timeouts, edge-auth
+	// closes it once the stream ends
+	conn.SetDeadline(time.Time{})
+
+	fmt.Fprint(rw, "HTTP/1.1 101 Switching Protocols\r\n")
+	res.Header.Write(rw)
+	fmt.Fprint(rw, "\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, rw.Reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+
+	<-done
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_isDashboardEvents(t *testing.T) {
+	tests := []struct {
+		host       string
+		requestURI string
+		want       bool
+	}{
+		{host: "system", requestURI: "dashboard/api/events", want: true},
+		{host: "system", requestURI: "dashboard/api/events?user=openfaas", want: true},
+		{host: "system", requestURI: "dashboard/api/list-functions", want: false},
+		{host: "alexellis", requestURI: "dashboard/api/events", want: false},
+	}
+
+	for _, test := range tests {
+		if got := isDashboardEvents(test.host, test.requestURI); got != test.want {
+			t.Errorf("%s %s: want %t, got %t", test.host, test.requestURI, test.want, got)
+		}
+	}
+}
+
+func Test_makeHandler_DashboardEvents(t *testing.T) {
+	var authRequest string
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authRequest = r.URL.String()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("retry: 3000\n\nevent: pipeline-stage\ndata: {}\n\n"))
+	}))
+	defer authServer.Close()
+
+	auth := &authProxy{URL: authServer.URL + "/", Client: http.DefaultClient}
+	router := httptest.NewServer(passHandler{
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: "http://127.0.0.1:1/", Auth: auth}),
+	})
+	defer router.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, router.URL+"/dashboard/api/events?user=openfaas", nil)
+	req.Host = "system.example.xyz"
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.Header.Get("Content-Type") != "text/event-stream" || string(body) != "retry: 3000\n\nevent: pipeline-stage\ndata: {}\n\n" {
+		t.Errorf("want the event stream, got %q %q", res.Header.Get("Content-Type"), body)
+	}
+	if authRequest != "/events/?user=openfaas" {
+		t.Errorf("want the stream from the auth service, got %q", authRequest)
+	}
+}
+
+func Test_makeHandler_DashboardEventsWebSocket(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events/" || r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\nevent\n")
+		rw.Flush()
+
+		// Echo a line from the client to show that frames pass both ways
+		line, _ := rw.ReadString('\n')
+		fmt.Fprint(rw, "echo "+line)
+		rw.Flush()
+	}))
+	defer authServer.Close()
+
+	auth := &authProxy{URL: authServer.URL + "/", Client: http.DefaultClient}
+	router := httptest.NewServer(passHandler{
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: "http://127.0.0.1:1/", Auth: auth}),
+	})
+	defer router.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(router.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, "GET /dashboard/api/events HTTP/1.1\r\nHost: system.example.xyz\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("want the upgrade passed on, got %d", res.StatusCode)
+	}
+
+	if line, _ := reader.ReadString('\n'); line != "event\n" {
+		t.Errorf("want the auth service's frames, got %q", line)
+	}
+
+	fmt.Fprint(conn, "ping\n")
+	if line, _ := reader.ReadString('\n'); line != "echo ping\n" {
+		t.Errorf("want the client's frames passed to the auth service, got %q", line)
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/openfaas/openfaas-cloud/edge-router
 
-go 1.13
+go 1.24
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
@@ -28,7 +30,7 @@ func main() {
 	maxIdleConns := 1024
 	maxIdleConnsPerHost := 1024
 
-	proxyClient := makeProxy(cfg.Timeout, maxIdleConns, maxIdleConnsPerHost)
+	proxyClient := makeProxy(cfg.Timeout, maxIdleConns, maxIdleConnsPerHost, cfg.UpstreamHTTP2)
 
 	log.Printf("Timeout set to: %s\n", cfg.Timeout)
 	log.Printf("Upstream URL: %s\n", cfg.UpstreamURL)
@@ -38,8 +40,60 @@ func main() {
 		Client: proxyClient,
 	}
 
+	canaries := NewCanaryTable()
+	if cfg.CanaryRefresh > 0 {
+		log.Printf("Canary refresh: %s\n", cfg.CanaryRefresh)
+		go canaries.Watch(proxyClient, cfg.UpstreamURL, cfg.NamespacePrefix, cfg.CanaryRefresh)
+	}
+
+	apis := NewOpenAPITable()
+	if cfg.OpenAPIRefresh > 0 {
+		log.Printf("OpenAPI refresh: %s\n", cfg.OpenAPIRefresh)
+		go apis.Watch(proxyClient, cfg.UpstreamURL, cfg.NamespacePrefix, cfg.OpenAPIRefresh)
+	}
+
+	routes := NewRouteTable()
+	if cfg.RoutesRefresh > 0 {
+		log.Printf("Routes refresh: %s\n", cfg.RoutesRefresh)
+		go routes.Watch(proxyClient, cfg.UpstreamURL, cfg.NamespacePrefix, cfg.RoutesRefresh)
+	}
+
+	maintenance := NewMaintenanceTable()
+	maintenance.Page = readMaintenancePage(cfg.MaintenancePage)
+	maintenance.RetryAfter = cfg.MaintenanceRetryAfter
+	if cfg.MaintenanceRefresh > 0 {
+		log.Printf("Maintenance refresh: %s\n", cfg.MaintenanceRefresh)
+		go maintenance.Watch(proxyClient, cfg.UpstreamURL, cfg.NamespacePrefix, cfg.MaintenanceRefresh)
+	}
+
+	meter := NewBandwidthMeter()
+	if len(cfg.MetricsPort) > 0 {
+		log.Printf("Metrics port: %s\n", cfg.MetricsPort)
+		go serveMetrics(cfg.MetricsPort, meter, routes)
+	}
+
+	earlyHints := readEarlyHints(cfg.EarlyHintsFile)
+	if len(earlyHints) > 0 {
+		log.Printf("Early hints for %d host(s)\n", len(earlyHints))
+	}
+
+	handler := makeHandler(handlerConfig{
+		Client:          proxyClient,
+		Timeout:         cfg.Timeout,
+		UpstreamURL:     cfg.UpstreamURL,
+		Auth:            &authProxy1,
+		ShadowRoutes:    cfg.ShadowRoutes,
+		NamespacePrefix: cfg.NamespacePrefix,
+		Canaries:        canaries,
+		Routes:          routes,
+		APIs:            apis,
+		Maintenance:     maintenance,
+		Meter:           meter,
+		ColdStartWait:   cfg.ColdStartWait,
+	})
+
 	router := http.NewServeMux()
-	router.HandleFunc("/", makeHandler(proxyClient, cfg.Timeout, cfg.UpstreamURL, &authProxy1))
+	router.HandleFunc("/", makeEarlyHintsHandler(earlyHints, handler))
 	router.HandleFunc("/healthz", makeHealthzHandler())
 
 	log.Printf("Using port %s\n", cfg.Port)
@@ -52,18 +106,68 @@ func main() {
 		MaxHeaderBytes: 1 << 20,
 	}
 
+	if cfg.HTTP2 {
+		log.Printf("Serving HTTP/2 without TLS (h2c)\n")
+
+		s.Protocols = new(http.Protocols)
+		s.Protocols.SetHTTP1(true)
+		s.Protocols.SetUnencryptedHTTP2(true)
+	}
+
 	log.Fatal(s.ListenAndServe())
 }
 
+// serveMetrics listens on a separate port so that the counters are
+// not reachable through the public sub-domains, nor are the routes
+func serveMetrics(port string, meter *BandwidthMeter, routes *RouteTable) {
+	router := http.NewServeMux()
+	router.HandleFunc("/metrics", makeMetricsHandler(meter))
+	router.HandleFunc("/metering", makeMeteringHandler(meter))
+	router.HandleFunc("/routes", makeRoutesHandler(routes))
+
+	log.Fatal(http.ListenAndServe(":"+port, router))
+}
+
+// handlerConfig is what makeHandler routes and proxies requests with.
+// Each of the tables may be nil, which turns off what it is used for.
+type handlerConfig struct {
+	Client      *http.Client
+	Timeout     time.Duration
+	UpstreamURL string
+	// Auth validates requests and serves the auth sub-domain, requests
+	// are not validated when it is nil
+	Auth            *authProxy
+	ShadowRoutes    ShadowRoutes
+	NamespacePrefix string
+	Canaries        *CanaryTable
+	Routes          *RouteTable
+	APIs            *OpenAPITable
+	Maintenance     *MaintenanceTable
+	Meter           *BandwidthMeter
+	ColdStartWait   time.Duration
+}
+
 // makeHandler builds a router to convert sub-domains into OpenFaaS gateway URLs with
 // a username prefix and suffix of the destination function.
 // i.e. system.o6s.io/dashboard
 //      becomes: gateway:8080/function/system-dashboard, where gateway:8080
-//      is specified in upstreamURL
-func makeHandler(c *http.Client, timeout time.Duration, upstreamURL string, auth *authProxy) func(w http.ResponseWriter, r *http.Request) {
-
-	if strings.HasSuffix(upstreamURL, "/") == false {
-		upstreamURL = upstreamURL + "/"
+//      is specified in UpstreamURL
+// Requests for routes with a shadow are also sent to the shadow function.
+// When NamespacePrefix is set the username selects the namespace instead:
+//      gateway:8080/function/dashboard.openfaas-fn-system
+// A share of the requests for a function with a canary go to the canary.
+// Path prefixes an owner has routed to a function go to that function.
+// Requests to a function with an OpenAPI spec are validated against it.
+// A function in maintenance mode is answered with a 503 without being
+// called, and a paused function with a 403.
+// The bytes in and out of each function call are counted for its owner.
+// When ColdStartWait is set, a request to a function which is scaling
+// from zero is held and retried instead of failing with a 503.
+// The dashboard's live events are streamed from the auth service.
+func makeHandler(cfg handlerConfig) func(w http.ResponseWriter, r *http.Request) {
+
+	if strings.HasSuffix(cfg.UpstreamURL, "/") == false {
+		cfg.UpstreamURL = cfg.UpstreamURL + "/"
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -87,7 +191,9 @@ func makeHandler(c *http.Client, timeout time.Duration, upstreamURL string, auth
 		requestURI := r.RequestURI
 		requestURI = strings.TrimLeft(requestURI, "/")
 
-		if len(requestURI) == 0 {
+		routedPath, routed := cfg.Routes.Match(host, requestURI)
+
+		if len(requestURI) == 0 && !routed {
 			if host == "system" {
 				scheme := "http"
 				if r.TLS != nil {
@@ -109,23 +215,33 @@ func makeHandler(c *http.Client, timeout time.Duration, upstreamURL string, auth
 			return
 		}
 
+		if cfg.Auth != nil && isDashboardEvents(host, requestURI) {
+			proxyEvents(w, r, cfg.Client, cfg.Auth.URL)
+			return
+		}
+
+		fnPath := functionPath(host, requestURI, cfg.NamespacePrefix)
+		if routed {
+			fnPath = routedPath
+		}
+
 		var upstreamFullURL *url.URL
 
 		isAuthHost := strings.HasPrefix(r.Host, authHost)
 		if isAuthHost {
 			var err error
-			upstreamFullURL, err = url.Parse(fmt.Sprintf("%s%s", auth.URL, requestURI))
+			upstreamFullURL, err = url.Parse(fmt.Sprintf("%s%s", cfg.Auth.URL, requestURI))
 			if err != nil {
 				log.Printf("Auth URL transparent error: %s\n", err)
 			} else {
 				log.Printf("Auth URL transparent %s\n", upstreamFullURL.String())
 			}
 		} else {
-			upstreamFullURL, _ = url.Parse(fmt.Sprintf("%sfunction/%s-%s", upstreamURL, host, requestURI))
+			upstreamFullURL, _ = url.Parse(fmt.Sprintf("%sfunction/%s", cfg.UpstreamURL, cfg.Canaries.Route(fnPath)))
 		}
 
-		if auth != nil && !isAuthHost {
-			authStatus, location := auth.Validate(upstreamFullURL.Path, r)
+		if cfg.Auth != nil && !isAuthHost {
+			authStatus, location := cfg.Auth.Validate(upstreamFullURL.Path, r)
 			fmt.Println(authStatus, location)
 
 			responseWritten := false
@@ -177,16 +293,71 @@ func makeHandler(c *http.Client, timeout time.Duration, upstreamURL string, auth
 			}
 		}
 
-		req, _ := http.NewRequest(r.Method, upstreamFullURL.String(), r.Body)
+		if !isAuthHost {
+			if cfg.Maintenance.Paused(fnPath) {
+				log.Printf("Paused: %s %s\n", r.Method, upstreamFullURL.Path)
+
+				cfg.Maintenance.WritePaused(w)
+				return
+			}
 
-		timeoutContext, cancel := context.WithTimeout(context.Background(), timeout)
+			if message, ok := cfg.Maintenance.Check(fnPath); ok {
+				log.Printf("Maintenance: %s %s\n", r.Method, upstreamFullURL.Path)
+
+				cfg.Maintenance.Write(w, message)
+				return
+			}
+
+			if status, reason := cfg.APIs.Validate(fnPath, r); status != 0 {
+				log.Printf("OpenAPI validation: %s %s: %s\n", r.Method, upstreamFullURL.Path, reason)
+
+				w.WriteHeader(status)
+				w.Write([]byte(reason))
+				return
+			}
+		}
+
+		var body io.Reader = r.Body
+		counter := &countingReader{}
+		if r.Body != nil {
+			counter.r = r.Body
+			body = counter
+		}
+
+		// The body is buffered when it has to be sent more than once
+		var bodyBytes []byte
+		retryColdStart := cfg.ColdStartWait > 0 && !isAuthHost
+
+		shadowURI, shadowed := cfg.ShadowRoutes.shadowURI(host, requestURI)
+		shadowed = shadowed && !isAuthHost
+
+		if shadowed || retryColdStart {
+			bodyBytes = []byte{}
+			if r.Body != nil {
+				bodyBytes, _ = ioutil.ReadAll(counter)
+			}
+			body = bytes.NewReader(bodyBytes)
+		}
+
+		if shadowed {
+			mirror(cfg.Client, cfg.Timeout, r.Method, fmt.Sprintf("%sfunction/%s", cfg.UpstreamURL, functionPath(host, shadowURI, cfg.NamespacePrefix)), r.Header.Clone(), bodyBytes)
+		}
+
+		timeoutContext, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
 		defer cancel()
 
-		copyHeaders(req.Header, &r.Header)
+		log.Printf("Serving: %s\n", upstreamFullURL.String())
 
-		log.Printf("Serving: %s\n", req.URL.String())
+		var res *http.Response
+		var resErr error
+		if retryColdStart {
+			res, resErr = doWithColdStart(timeoutContext, cfg.Client, r.Method, upstreamFullURL.String(), r.Header, bodyBytes, cfg.ColdStartWait)
+		} else {
+			req, _ := http.NewRequest(r.Method, upstreamFullURL.String(), body)
+			copyHeaders(req.Header, &r.Header)
 
-		res, resErr := c.Do(req.WithContext(timeoutContext))
+			res, resErr = cfg.Client.Do(req.WithContext(timeoutContext))
+		}
 		if resErr != nil {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			w.Write([]byte(resErr.Error()))
@@ -205,10 +376,31 @@ func makeHandler(c *http.Client, timeout time.Duration, upstreamURL string, auth
 
 			bytesOut, _ := ioutil.ReadAll(res.Body)
 			w.Write(bytesOut)
+
+			if !isAuthHost {
+				cfg.Meter.Add(host, counter.n, int64(len(bytesOut)))
+			}
 		}
 	}
 }
 
+// functionPath gives the path of the function on the gateway for a
+// username and request URI such as "kubecon-tester/path?q=1"
+func functionPath(host, requestURI, namespacePrefix string) string {
+	if len(namespacePrefix) == 0 {
+		return host + "-" + requestURI
+	}
+
+	function := requestURI
+	rest := ""
+	if index := strings.IndexAny(requestURI, "/?"); index > -1 {
+		function = requestURI[:index]
+		rest = requestURI[index:]
+	}
+
+	return function + "." + namespacePrefix + host + rest
+}
+
 func copyHeaders(destination http.Header, source *http.Header) {
 	for k, v := range *source {
 		vClone := make([]string, len(v))
@@ -217,7 +409,10 @@ func copyHeaders(destination http.Header, source *http.Header) {
 	}
 }
 
-func makeProxy(timeout time.Duration, maxIdleConns, maxIdleConnsPerHost int) *http.Client {
+// makeProxy builds the client for the gateway and auth service, HTTP/2
+// is negotiated for https:// URLs and used without TLS for http:// URLs
+// when upstreamHTTP2 is set.
+func makeProxy(timeout time.Duration, maxIdleConns, maxIdleConnsPerHost int, upstreamHTTP2 bool) *http.Client {
 
 	http.DefaultClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
 		return http.ErrUseLastResponse
@@ -235,6 +430,14 @@ func makeProxy(timeout time.Duration, maxIdleConns, maxIdleConnsPerHost int) *ht
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+
+	if upstreamHTTP2 {
+		transport := http.DefaultClient.Transport.(*http.Transport)
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
 	}
 
 	return http.DefaultClient
@@ -67,7 +67,7 @@ func Test_makeHandler(t *testing.T) {
 	}
 
 	router := httptest.NewServer(passHandler{
-		Next: makeHandler(http.DefaultClient, time.Second*10, gateway.URL, nil),
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: gateway.URL}),
 	})
 
 	defer router.Close()
@@ -102,3 +102,25 @@ func Test_makeHandler(t *testing.T) {
 		})
 	}
 }
+
+func Test_functionPath(t *testing.T) {
+	tests := []struct {
+		Scenario        string
+		RequestURI      string
+		NamespacePrefix string
+		Want            string
+	}{
+		{"prefix in shared namespace", "dashboard/index.html", "", "system-dashboard/index.html"},
+		{"owner namespace", "dashboard", "openfaas-fn-", "dashboard.openfaas-fn-system"},
+		{"owner namespace with sub-path", "dashboard/index.html?q=1", "openfaas-fn-", "dashboard.openfaas-fn-system/index.html?q=1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			got := functionPath("system", test.RequestURI, test.NamespacePrefix)
+			if got != test.Want {
+				t.Errorf("want %s, got %s", test.Want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"html"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maintenanceAnnotation is set in stack.yml to take a function offline,
+// its value is "true" or a message to show in place of the default
+const maintenanceAnnotation = "com.openfaas.cloud.maintenance"
+
+const defaultMaintenanceMessage = "This function is down for maintenance, please try again later."
+
+// pausedAnnotation is set by buildshiprun on the functions of an owner
+// whose GitHub App installation is suspended
+const pausedAnnotation = "com.openfaas.cloud.paused"
+
+const pausedMessage = "This function is paused because its owner's installation is suspended."
+
+// MaintenanceTable holds the functions in maintenance mode with their
+// message, keyed by the function's name on the gateway like the
+// CanaryTable
+type MaintenanceTable struct {
+	messages map[string]string
+	paused   map[string]bool
+	mutex    sync.RWMutex
+
+	// Page is an HTML page served in place of the message, where
+	// {message} is replaced with it
+	Page string
+
+	// RetryAfter is sent as the Retry-After header when set
+	RetryAfter time.Duration
+}
+
+// NewMaintenanceTable creates an empty MaintenanceTable
+func NewMaintenanceTable() *MaintenanceTable {
+	return &MaintenanceTable{
+		messages: map[string]string{},
+		paused:   map[string]bool{},
+	}
+}
+
+// Set replaces the functions in the table
+func (t *MaintenanceTable) Set(messages map[string]string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.messages = messages
+}
+
+// Check gives the message for a function path, such as
+// "alexellis-fn1/users", when the function is in maintenance mode
+func (t *MaintenanceTable) Check(functionPath string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+
+	key := functionPath
+	if index := strings.IndexAny(functionPath, "/?"); index > -1 {
+		key = functionPath[:index]
+	}
+
+	t.mutex.RLock()
+	message, ok := t.messages[key]
+	t.mutex.RUnlock()
+
+	return message, ok
+}
+
+// SetPaused replaces the paused functions in the table
+func (t *MaintenanceTable) SetPaused(paused map[string]bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.paused = paused
+}
+
+// Paused is true when the function of a path is paused
+func (t *MaintenanceTable) Paused(functionPath string) bool {
+	if t == nil {
+		return false
+	}
+
+	key := functionPath
+	if index := strings.IndexAny(functionPath, "/?"); index > -1 {
+		key = functionPath[:index]
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.paused[key]
+}
+
+// WritePaused responds with a 403, there is no Retry-After as the
+// function stays paused until the installation is unsuspended
+func (t *MaintenanceTable) WritePaused(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(pausedMessage))
+}
+
+// Write responds with a 503 and the message, within the page when one
+// is configured, without calling the function
+func (t *MaintenanceTable) Write(w http.ResponseWriter, message string) {
+	if t.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(t.RetryAfter.Seconds())))
+	}
+
+	if len(t.Page) > 0 {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(strings.Replace(t.Page, "{message}", html.EscapeString(message), -1)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(message))
+}
+
+// maintenanceMessage reads the annotation, "false" or an empty value
+// leave the function online
+func maintenanceMessage(annotations map[string]string) (string, bool) {
+	val := strings.TrimSpace(annotations[maintenanceAnnotation])
+	if len(val) == 0 {
+		return "", false
+	}
+
+	if enabled, err := strconv.ParseBool(val); err == nil {
+		return defaultMaintenanceMessage, enabled
+	}
+	return val, true
+}
+
+// Refresh reads the functions with the maintenance or paused annotation
+func (t *MaintenanceTable) Refresh(c *http.Client, upstreamURL string, namespacePrefix string) error {
+	functions, err := listFunctions(c, upstreamURL, namespacePrefix)
+	if err != nil {
+		return err
+	}
+
+	messages := map[string]string{}
+	paused := map[string]bool{}
+	for _, fn := range functions {
+		if message, ok := maintenanceMessage(fn.Annotations); ok {
+			messages[fn.key()] = message
+		}
+		if _, ok := fn.Annotations[pausedAnnotation]; ok {
+			paused[fn.key()] = true
+		}
+	}
+
+	t.Set(messages)
+	t.SetPaused(paused)
+	return nil
+}
+
+// Watch refreshes the table on an interval until the process exits
+func (t *MaintenanceTable) Watch(c *http.Client, upstreamURL string, namespacePrefix string, interval time.Duration) {
+	for {
+		if err := t.Refresh(c, upstreamURL, namespacePrefix); err != nil {
+			log.Printf("Maintenance refresh error: %s\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// readMaintenancePage reads the HTML page from maintenance_page, the
+// plain message is served when it is not set or can't be read
+func readMaintenancePage(path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+
+	page, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Unable to read maintenance page %s: %s\n", path, err)
+		return ""
+	}
+	return string(page)
+}
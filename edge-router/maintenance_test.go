@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_maintenanceMessage(t *testing.T) {
+	tests := []struct {
+		Value   string
+		Want    string
+		Enabled bool
+	}{
+		{"", "", false},
+		{"false", defaultMaintenanceMessage, false},
+		{"true", defaultMaintenanceMessage, true},
+		{"Migrating to v2 until 14:00 UTC", "Migrating to v2 until 14:00 UTC", true},
+	}
+
+	for _, test := range tests {
+		got, enabled := maintenanceMessage(map[string]string{maintenanceAnnotation: test.Value})
+		if enabled != test.Enabled || (enabled && got != test.Want) {
+			t.Errorf("%q: want %q %t, got %q %t", test.Value, test.Want, test.Enabled, got, enabled)
+		}
+	}
+}
+
+func Test_MaintenanceTable_Refresh(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]gatewayFunction{
+			{Name: "alexellis-fn1", Annotations: map[string]string{maintenanceAnnotation: "true"}},
+			{Name: "alexellis-fn2", Annotations: map[string]string{maintenanceAnnotation: "false"}},
+			{Name: "alexellis-fn3"},
+		})
+	}))
+	defer gateway.Close()
+
+	table := NewMaintenanceTable()
+	if err := table.Refresh(http.DefaultClient, gateway.URL+"/", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := table.Check("alexellis-fn1/users?id=1"); !ok {
+		t.Errorf("want alexellis-fn1 in maintenance")
+	}
+	if len(table.messages) != 1 {
+		t.Errorf("want only alexellis-fn1 in maintenance, got %v", table.messages)
+	}
+}
+
+func Test_makeHandler_Maintenance(t *testing.T) {
+	var calls int32
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer gateway.Close()
+
+	maintenance := NewMaintenanceTable()
+	maintenance.Page = "<h1>{message}</h1>"
+	maintenance.RetryAfter = time.Minute * 5
+	maintenance.Set(map[string]string{"alexellis-fn1": "Back <soon>"})
+
+	router := httptest.NewServer(passHandler{
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: gateway.URL, Maintenance: maintenance}),
+	})
+	defer router.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, router.URL+"/fn1/users", strings.NewReader(""))
+	req.Host = "alexellis.example.xyz"
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("want %d, got %d", http.StatusServiceUnavailable, res.StatusCode)
+	}
+	if string(body) != "<h1>Back &lt;soon&gt;</h1>" {
+		t.Errorf("want the escaped message in the page, got %q", string(body))
+	}
+	if res.Header.Get("Retry-After") != "300" {
+		t.Errorf("want Retry-After of 300, got %q", res.Header.Get("Retry-After"))
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, router.URL+"/fn2", strings.NewReader(""))
+	req.Host = "alexellis.example.xyz"
+
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("want %d for a function not in maintenance, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("want the function in maintenance not called, got %d calls", got)
+	}
+}
+
+func Test_MaintenanceTable_RefreshPaused(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]gatewayFunction{
+			{Name: "alexellis-fn1", Annotations: map[string]string{pausedAnnotation: "true"}},
+			{Name: "alexellis-fn2"},
+		})
+	}))
+	defer gateway.Close()
+
+	table := NewMaintenanceTable()
+	if err := table.Refresh(http.DefaultClient, gateway.URL+"/", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if !table.Paused("alexellis-fn1/users") {
+		t.Errorf("want alexellis-fn1 paused")
+	}
+	if table.Paused("alexellis-fn2") {
+		t.Errorf("want alexellis-fn2 not paused")
+	}
+	if len(table.messages) != 0 {
+		t.Errorf("want a paused function left out of maintenance, got %v", table.messages)
+	}
+}
+
+func Test_makeHandler_Paused(t *testing.T) {
+	var calls int32
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer gateway.Close()
+
+	maintenance := NewMaintenanceTable()
+	maintenance.SetPaused(map[string]bool{"alexellis-fn1": true})
+
+	router := httptest.NewServer(passHandler{
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: gateway.URL, Maintenance: maintenance}),
+	})
+	defer router.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, router.URL+"/fn1", strings.NewReader(""))
+	req.Host = "alexellis.example.xyz"
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("want %d, got %d", http.StatusForbidden, res.StatusCode)
+	}
+	if string(body) != pausedMessage {
+		t.Errorf("want %q, got %q", pausedMessage, string(body))
+	}
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Errorf("want the paused function not called, got %d calls", got)
+	}
+}
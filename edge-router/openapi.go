@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// openAPIAnnotation is set in stack.yml with the path at which the
+// function serves its OpenAPI spec as JSON, i.e. /openapi.json
+const openAPIAnnotation = "openapi"
+
+// maxSpecSize is the largest spec read from a function
+const maxSpecSize = 1024 * 1024
+
+// openAPIMethods are the operations of an OpenAPI path item
+var openAPIMethods = []string{
+	http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete,
+	http.MethodOptions, http.MethodHead, http.MethodPatch, http.MethodTrace,
+}
+
+// apiRoute is a path from a spec with the content types accepted by
+// each of its methods, a nil list accepts any content type
+type apiRoute struct {
+	segments []string
+	methods  map[string][]string
+}
+
+// apiSpec holds the routes of a function's spec
+type apiSpec struct {
+	routes []apiRoute
+	// path is where the function serves the spec, which is not validated
+	path string
+}
+
+// openAPIDocument is the subset of an OpenAPI 3 document which is used
+// to validate requests
+type openAPIDocument struct {
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+type openAPIOperation struct {
+	RequestBody *struct {
+		Content map[string]json.RawMessage `json:"content"`
+	} `json:"requestBody"`
+}
+
+// parseOpenAPI reads the paths, methods and request content types
+// from an OpenAPI 3 spec in JSON
+func parseOpenAPI(body []byte) (*apiSpec, error) {
+	doc := openAPIDocument{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Paths) == 0 {
+		return nil, fmt.Errorf("no paths in spec")
+	}
+
+	spec := &apiSpec{}
+	for path, item := range doc.Paths {
+		route := apiRoute{
+			segments: splitPath(path),
+			methods:  map[string][]string{},
+		}
+
+		for _, method := range openAPIMethods {
+			raw, ok := item[strings.ToLower(method)]
+			if !ok {
+				continue
+			}
+
+			op := openAPIOperation{}
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, fmt.Errorf("invalid operation %s %s: %s", method, path, err)
+			}
+
+			var contentTypes []string
+			if op.RequestBody != nil && len(op.RequestBody.Content) > 0 {
+				contentTypes = []string{}
+				for contentType := range op.RequestBody.Content {
+					contentTypes = append(contentTypes, strings.ToLower(contentType))
+				}
+			}
+			route.methods[method] = contentTypes
+		}
+
+		spec.routes = append(spec.routes, route)
+	}
+
+	return spec, nil
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// match reports whether the path matches the route, where a segment
+// such as {id} matches any value
+func (r apiRoute) match(segments []string) bool {
+	if len(segments) != len(r.segments) {
+		return false
+	}
+
+	for i, segment := range r.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if len(segments[i]) == 0 {
+				return false
+			}
+			continue
+		}
+		if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// allowedMethods lists the methods of a route for the error message
+func (r apiRoute) allowedMethods() string {
+	methods := []string{}
+	for method := range r.methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// Validate checks a request's path, method and content type against
+// the spec. It returns zero when the request is valid, or the status
+// and reason to return to the client.
+func (s *apiSpec) Validate(path, method, contentType string) (int, string) {
+	segments := splitPath(path)
+	if len(s.path) > 0 && strings.Join(segments, "/") == strings.Trim(s.path, "/") {
+		return 0, ""
+	}
+
+	var route *apiRoute
+	for i := range s.routes {
+		if s.routes[i].match(segments) {
+			route = &s.routes[i]
+			break
+		}
+	}
+
+	if route == nil {
+		return http.StatusBadRequest, fmt.Sprintf("path %s is not in the function's OpenAPI spec", path)
+	}
+
+	contentTypes, ok := route.methods[method]
+	if !ok {
+		// CORS pre-flight requests are left to the function
+		if method == http.MethodOptions {
+			return 0, ""
+		}
+		return http.StatusMethodNotAllowed, fmt.Sprintf("method %s is not allowed for %s, allowed: %s", method, path, route.allowedMethods())
+	}
+
+	if contentTypes == nil || len(contentType) == 0 {
+		return 0, ""
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Sprintf("invalid Content-Type: %s", contentType)
+	}
+
+	for _, accepted := range contentTypes {
+		if mediaTypeMatches(accepted, mediaType) {
+			return 0, ""
+		}
+	}
+
+	return http.StatusUnsupportedMediaType, fmt.Sprintf("Content-Type %s is not accepted for %s %s", mediaType, method, path)
+}
+
+// mediaTypeMatches supports the */* and type/* ranges from the spec
+func mediaTypeMatches(accepted, mediaType string) bool {
+	if accepted == "*/*" || accepted == mediaType {
+		return true
+	}
+	if strings.HasSuffix(accepted, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*"))
+	}
+	return false
+}
+
+// OpenAPITable holds the specs of functions which publish one, keyed
+// by the function's name on the gateway like the CanaryTable
+type OpenAPITable struct {
+	specs map[string]*apiSpec
+	mutex sync.RWMutex
+}
+
+// NewOpenAPITable creates an empty OpenAPITable
+func NewOpenAPITable() *OpenAPITable {
+	return &OpenAPITable{
+		specs: map[string]*apiSpec{},
+	}
+}
+
+// Set replaces the specs in the table
+func (t *OpenAPITable) Set(specs map[string]*apiSpec) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.specs = specs
+}
+
+// Validate checks a request to the function path, such as
+// "alexellis-fn1/users/1?active=true", against the function's spec.
+// Functions without a spec are not validated.
+func (t *OpenAPITable) Validate(functionPath string, r *http.Request) (int, string) {
+	if t == nil {
+		return 0, ""
+	}
+
+	key := functionPath
+	rest := "/"
+	if index := strings.IndexAny(functionPath, "/?"); index > -1 {
+		key = functionPath[:index]
+		rest = functionPath[index:]
+	}
+	if index := strings.Index(rest, "?"); index > -1 {
+		rest = rest[:index]
+	}
+
+	t.mutex.RLock()
+	spec := t.specs[key]
+	t.mutex.RUnlock()
+
+	if spec == nil {
+		return 0, ""
+	}
+
+	return spec.Validate(rest, r.Method, r.Header.Get("Content-Type"))
+}
+
+// Refresh reads the specs of functions with the openapi annotation. A
+// function whose spec cannot be read or parsed is not validated.
+func (t *OpenAPITable) Refresh(c *http.Client, upstreamURL string, namespacePrefix string) error {
+	functions, err := listFunctions(c, upstreamURL, namespacePrefix)
+	if err != nil {
+		return err
+	}
+
+	specs := map[string]*apiSpec{}
+	for _, fn := range functions {
+		specPath := fn.Annotations[openAPIAnnotation]
+		if len(specPath) == 0 {
+			continue
+		}
+
+		key := fn.key()
+		spec, err := getSpec(c, upstreamURL+"function/"+key+"/"+strings.TrimLeft(specPath, "/"))
+		if err != nil {
+			log.Printf("OpenAPI spec for %s: %s\n", key, err)
+			continue
+		}
+		spec.path = specPath
+		specs[key] = spec
+	}
+
+	t.Set(specs)
+	return nil
+}
+
+// Watch refreshes the table on an interval until the process exits
+func (t *OpenAPITable) Watch(c *http.Client, upstreamURL string, namespacePrefix string, interval time.Duration) {
+	for {
+		if err := t.Refresh(c, upstreamURL, namespacePrefix); err != nil {
+			log.Printf("OpenAPI refresh error: %s\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// getSpec invokes the function for its spec, without the gateway's
+// credentials which must not be sent to a function
+func getSpec(c *http.Client, uri string) (*apiSpec, error) {
+	res, err := c.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxSpecSize))
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from %s: %d", uri, res.StatusCode)
+	}
+
+	return parseOpenAPI(body)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSpec = `{
+  "openapi": "3.0.0",
+  "paths": {
+    "/users": {
+      "get": {"responses": {}},
+      "post": {
+        "requestBody": {"content": {"application/json": {}}},
+        "responses": {}
+      }
+    },
+    "/users/{id}": {
+      "parameters": [],
+      "delete": {"responses": {}}
+    },
+    "/upload": {
+      "put": {"requestBody": {"content": {"image/*": {}}}}
+    }
+  }
+}`
+
+func Test_apiSpec_Validate(t *testing.T) {
+	spec, err := parseOpenAPI([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.path = "/openapi.json"
+
+	tests := []struct {
+		Scenario    string
+		Path        string
+		Method      string
+		ContentType string
+		Want        int
+	}{
+		{"valid get", "/users", http.MethodGet, "", 0},
+		{"valid post", "/users", http.MethodPost, "application/json; charset=utf-8", 0},
+		{"path parameter", "/users/1", http.MethodDelete, "", 0},
+		{"media type range", "/upload", http.MethodPut, "image/png", 0},
+		{"spec itself", "/openapi.json", http.MethodGet, "", 0},
+		{"pre-flight", "/users/1", http.MethodOptions, "", 0},
+		{"unknown path", "/admin", http.MethodGet, "", http.StatusBadRequest},
+		{"trailing slash", "/users/", http.MethodGet, "", 0},
+		{"method not in spec", "/users/1", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"content type not in spec", "/users", http.MethodPost, "text/plain", http.StatusUnsupportedMediaType},
+		{"invalid content type", "/users", http.MethodPost, "json;;", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			if got, reason := spec.Validate(test.Path, test.Method, test.ContentType); got != test.Want {
+				t.Errorf("want %d, got %d: %s", test.Want, got, reason)
+			}
+		})
+	}
+}
+
+func Test_OpenAPITable_Refresh(t *testing.T) {
+	var authorized int32
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/system/functions":
+			json.NewEncoder(w).Encode([]gatewayFunction{
+				{Name: "alexellis-fn1", Annotations: map[string]string{openAPIAnnotation: "/openapi.json"}},
+				{Name: "alexellis-fn2", Annotations: map[string]string{openAPIAnnotation: "/missing.json"}},
+				{Name: "alexellis-fn3"},
+			})
+		case "/function/alexellis-fn1/openapi.json":
+			if len(r.Header.Get("Authorization")) > 0 {
+				atomic.AddInt32(&authorized, 1)
+			}
+			w.Write([]byte(testSpec))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer gateway.Close()
+
+	table := NewOpenAPITable()
+	if err := table.Refresh(http.DefaultClient, gateway.URL+"/", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table.specs) != 1 || table.specs["alexellis-fn1"] == nil {
+		t.Errorf("want a spec for alexellis-fn1 only, got %v", table.specs)
+	}
+	if atomic.LoadInt32(&authorized) > 0 {
+		t.Errorf("want the spec read without the gateway's credentials")
+	}
+}
+
+func Test_makeHandler_ValidatesOpenAPI(t *testing.T) {
+	var calls int32
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer gateway.Close()
+
+	spec, err := parseOpenAPI([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apis := NewOpenAPITable()
+	apis.Set(map[string]*apiSpec{"alexellis-fn1": spec})
+
+	router := httptest.NewServer(passHandler{
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: gateway.URL, APIs: apis}),
+	})
+	defer router.Close()
+
+	tests := []struct {
+		Path string
+		Want int
+	}{
+		{"/fn1/users?active=true", http.StatusOK},
+		{"/fn1/admin", http.StatusBadRequest},
+		{"/fn2/admin", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, router.URL+test.Path, strings.NewReader(""))
+		req.Host = "alexellis.example.xyz"
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.Want {
+			t.Errorf("%s: want %d, got %d", test.Path, test.Want, res.StatusCode)
+		}
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("want the invalid request stopped at the router, got %d calls", got)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// routesAnnotation is set in stack.yml with the path prefixes of the
+// owner's sub-domain which are routed to the function, i.e. "/api,/v1"
+// or "/" for the site served at the root
+const routesAnnotation = "com.openfaas.cloud.routes"
+
+// ownerLabel is set by buildshiprun on each function with its owner
+const ownerLabel = "com.openfaas.cloud.git-owner"
+
+// pathRoute sends requests under Prefix to a function, keyed by its
+// name on the gateway like the CanaryTable
+type pathRoute struct {
+	Prefix   string `json:"prefix"`
+	Function string `json:"function"`
+}
+
+// RouteTable holds the path prefixes each owner has routed to their
+// functions, so that several functions are served as one site
+type RouteTable struct {
+	routes map[string][]pathRoute
+	// functions are the names of each owner's functions as they appear
+	// in the first segment of the path, i.e. fn1 for alexellis-fn1
+	functions map[string]map[string]bool
+	mutex     sync.RWMutex
+}
+
+// NewRouteTable creates an empty RouteTable
+func NewRouteTable() *RouteTable {
+	return &RouteTable{
+		routes:    map[string][]pathRoute{},
+		functions: map[string]map[string]bool{},
+	}
+}
+
+// Set replaces the routes and functions in the table, the routes of
+// each owner are sorted with the longest prefix first
+func (t *RouteTable) Set(routes map[string][]pathRoute, functions map[string]map[string]bool) {
+	for _, ownerRoutes := range routes {
+		sort.SliceStable(ownerRoutes, func(i, j int) bool {
+			return len(ownerRoutes[i].Prefix) > len(ownerRoutes[j].Prefix)
+		})
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.routes = routes
+	t.functions = functions
+}
+
+// Routes gives the routes of an owner, or of every owner when empty
+func (t *RouteTable) Routes(owner string) map[string][]pathRoute {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	out := map[string][]pathRoute{}
+	for k, v := range t.routes {
+		if len(owner) == 0 || k == owner {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Match gives the function path to proxy to for a request URI such as
+// "api/users?q=1" on the owner's sub-domain, with the prefix removed.
+// The longest matching prefix wins. A route for "/" is only used when
+// the first segment of the path does not name one of the owner's
+// functions, so that they are still reached by name.
+func (t *RouteTable) Match(owner, requestURI string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+
+	path := "/" + requestURI
+	query := ""
+	if index := strings.Index(path, "?"); index > -1 {
+		query = path[index:]
+		path = path[:index]
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for _, route := range t.routes[owner] {
+		if route.Prefix == "/" {
+			segment := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+			if t.functions[owner][segment] {
+				return "", false
+			}
+			if path == "/" {
+				path = ""
+			}
+			return route.Function + path + query, true
+		}
+
+		if path == route.Prefix || strings.HasPrefix(path, route.Prefix+"/") {
+			return route.Function + strings.TrimPrefix(path, route.Prefix) + query, true
+		}
+	}
+
+	return "", false
+}
+
+// parseRoutePrefixes reads the comma-separated prefixes of the routes
+// annotation, each starts with a slash and has no trailing slash
+func parseRoutePrefixes(value string) []string {
+	prefixes := []string{}
+	for _, prefix := range strings.Split(value, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if len(prefix) == 0 {
+			continue
+		}
+
+		prefix = "/" + strings.Trim(prefix, "/")
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// Refresh reads the routes annotation of each function from the
+// gateway. A function can only route its own owner's paths, when two
+// functions claim a prefix the first by name keeps it.
+func (t *RouteTable) Refresh(c *http.Client, upstreamURL string, namespacePrefix string) error {
+	functions, err := listFunctions(c, upstreamURL, namespacePrefix)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].key() < functions[j].key()
+	})
+
+	routes := map[string][]pathRoute{}
+	names := map[string]map[string]bool{}
+	claimed := map[string]string{}
+
+	for _, fn := range functions {
+		owner := strings.ToLower(fn.Labels[ownerLabel])
+		if len(namespacePrefix) > 0 && len(fn.Namespace) > 0 {
+			owner = strings.TrimPrefix(fn.Namespace, namespacePrefix)
+		}
+		if len(owner) == 0 {
+			continue
+		}
+
+		if names[owner] == nil {
+			names[owner] = map[string]bool{}
+		}
+		names[owner][strings.TrimPrefix(fn.Name, owner+"-")] = true
+
+		for _, prefix := range parseRoutePrefixes(fn.Annotations[routesAnnotation]) {
+			key := owner + prefix
+			if other, ok := claimed[key]; ok {
+				log.Printf("Route %s of %s is already routed to %s\n", prefix, fn.key(), other)
+				continue
+			}
+
+			claimed[key] = fn.key()
+			routes[owner] = append(routes[owner], pathRoute{Prefix: prefix, Function: fn.key()})
+		}
+	}
+
+	t.Set(routes, names)
+	return nil
+}
+
+// Watch refreshes the table on an interval until the process exits
+func (t *RouteTable) Watch(c *http.Client, upstreamURL string, namespacePrefix string, interval time.Duration) {
+	for {
+		if err := t.Refresh(c, upstreamURL, namespacePrefix); err != nil {
+			log.Printf("Routes refresh error: %s\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// makeRoutesHandler lists the routes of each owner, or of ?owner=, as
+// JSON so that an owner's routes can be checked
+func makeRoutesHandler(t *RouteTable) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		bytesOut, _ := json.Marshal(t.Routes(strings.ToLower(r.URL.Query().Get("owner"))))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(bytesOut)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_RouteTable_Match(t *testing.T) {
+	table := NewRouteTable()
+	table.Set(map[string][]pathRoute{
+		"alexellis": {
+			{Prefix: "/", Function: "alexellis-site"},
+			{Prefix: "/api", Function: "alexellis-backend"},
+			{Prefix: "/api/v2", Function: "alexellis-backend-v2"},
+		},
+	}, map[string]map[string]bool{
+		"alexellis": {"site": true, "backend": true, "backend-v2": true, "fn1": true},
+	})
+
+	tests := []struct {
+		Scenario   string
+		Owner      string
+		RequestURI string
+		Want       string
+		Routed     bool
+	}{
+		{"prefix with sub-path", "alexellis", "api/users?id=1", "alexellis-backend/users?id=1", true},
+		{"prefix alone", "alexellis", "api", "alexellis-backend", true},
+		{"longest prefix", "alexellis", "api/v2/users", "alexellis-backend-v2/users", true},
+		{"prefix is a whole segment", "alexellis", "apis", "alexellis-site/apis", true},
+		{"root", "alexellis", "", "alexellis-site", true},
+		{"root with query", "alexellis", "?page=2", "alexellis-site?page=2", true},
+		{"function by name", "alexellis", "fn1/users", "", false},
+		{"other owner", "openfaas", "api", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			got, routed := table.Match(test.Owner, test.RequestURI)
+			if got != test.Want || routed != test.Routed {
+				t.Errorf("want %q (%t), got %q (%t)", test.Want, test.Routed, got, routed)
+			}
+		})
+	}
+}
+
+func Test_RouteTable_MatchNilTable(t *testing.T) {
+	var table *RouteTable
+	if _, routed := table.Match("alexellis", "api"); routed {
+		t.Errorf("want no route")
+	}
+}
+
+func Test_parseRoutePrefixes(t *testing.T) {
+	got := parseRoutePrefixes(" api/, /v1/users ,, /")
+	want := []string{"/api", "/v1/users", "/"}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("want %v, got %v", want, got)
+		}
+	}
+}
+
+func Test_RouteTable_Refresh(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]gatewayFunction{
+			{Name: "alexellis-site", Labels: map[string]string{ownerLabel: "alexellis"}, Annotations: map[string]string{routesAnnotation: "/"}},
+			{Name: "alexellis-backend", Labels: map[string]string{ownerLabel: "alexellis"}, Annotations: map[string]string{routesAnnotation: "/api,/v1"}},
+			{Name: "alexellis-other", Labels: map[string]string{ownerLabel: "alexellis"}, Annotations: map[string]string{routesAnnotation: "/api"}},
+			{Name: "openfaas-fn1", Labels: map[string]string{ownerLabel: "openfaas"}},
+		})
+	}))
+	defer gateway.Close()
+
+	table := NewRouteTable()
+	if err := table.Refresh(http.DefaultClient, gateway.URL+"/", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := table.Routes("alexellis")["alexellis"]
+	if len(routes) != 3 {
+		t.Fatalf("want 3 routes for alexellis, got %v", routes)
+	}
+	if got, _ := table.Match("alexellis", "api"); got != "alexellis-backend" {
+		t.Errorf("want /api kept by the first function to claim it, got %s", got)
+	}
+	if _, routed := table.Match("alexellis", "other"); routed {
+		t.Errorf("want a function reached by name")
+	}
+	if len(table.Routes("openfaas")) != 0 {
+		t.Errorf("want no routes for openfaas")
+	}
+}
+
+func Test_makeHandler_RoutesPathPrefix(t *testing.T) {
+	gatewayHandler := &gateway{}
+	gateway := httptest.NewServer(gatewayHandler)
+	defer gateway.Close()
+
+	routes := NewRouteTable()
+	routes.Set(map[string][]pathRoute{
+		"alexellis": {{Prefix: "/", Function: "alexellis-site"}, {Prefix: "/api", Function: "alexellis-backend"}},
+	}, map[string]map[string]bool{
+		"alexellis": {"site": true, "backend": true},
+	})
+
+	router := httptest.NewServer(passHandler{
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: gateway.URL, Routes: routes}),
+	})
+	defer router.Close()
+
+	tests := map[string]string{
+		"http://alexellis.example.xyz/api/users": "/function/alexellis-backend/users",
+		"http://alexellis.example.xyz/":          "/function/alexellis-site",
+		"http://alexellis.example.xyz/backend":   "/function/alexellis-backend",
+	}
+
+	for requestURL, want := range tests {
+		u, _ := url.Parse(requestURL)
+		req, _ := http.NewRequest(http.MethodGet, router.URL+u.Path, nil)
+		req.Host = u.Host
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK || gatewayHandler.RequestURI != want {
+			t.Errorf("%s: want %s, got %d %s", requestURL, want, res.StatusCode, gatewayHandler.RequestURI)
+		}
+	}
+}
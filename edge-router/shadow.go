@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ShadowRoutes maps a route in the form owner/function to a function
+// of the same owner which receives a copy of the route's traffic. The
+// shadow's responses are discarded.
+type ShadowRoutes map[string]string
+
+// parseShadowRoutes reads a comma-separated list of routes i.e.
+// "alexellis/kubecon-tester=kubecon-tester-v2, alexellis/fn1=fn1-next"
+func parseShadowRoutes(val string) ShadowRoutes {
+	routes := ShadowRoutes{}
+
+	for _, entry := range strings.Split(val, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		route := strings.Trim(strings.TrimSpace(parts[0]), "/")
+		target := strings.Trim(strings.TrimSpace(parts[1]), "/")
+		if len(route) == 0 || len(target) == 0 {
+			continue
+		}
+
+		routes[route] = target
+	}
+
+	return routes
+}
+
+// shadowURI returns the request URI for the shadow of the function
+// being called, retaining any sub-path and query-string.
+func (s ShadowRoutes) shadowURI(host, requestURI string) (string, bool) {
+	if len(s) == 0 {
+		return "", false
+	}
+
+	function := requestURI
+	rest := ""
+	if index := strings.IndexAny(requestURI, "/?"); index > -1 {
+		function = requestURI[:index]
+		rest = requestURI[index:]
+	}
+
+	target, ok := s[host+"/"+function]
+	if !ok {
+		return "", false
+	}
+
+	return target + rest, true
+}
+
+// mirror sends a copy of the request to the shadow function in the
+// background and discards the response.
+func mirror(c *http.Client, timeout time.Duration, method string, shadowURL string, header http.Header, body []byte) {
+	go func() {
+		req, err := http.NewRequest(method, shadowURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Shadow %s error: %s\n", shadowURL, err)
+			return
+		}
+
+		copyHeaders(req.Header, &header)
+
+		timeoutContext, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		res, err := c.Do(req.WithContext(timeoutContext))
+		if err != nil {
+			log.Printf("Shadow %s error: %s\n", shadowURL, err)
+			return
+		}
+
+		if res.Body != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		log.Printf("Shadow %s status: %d\n", shadowURL, res.StatusCode)
+	}()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseShadowRoutes(t *testing.T) {
+	routes := parseShadowRoutes("alexellis/kubecon-tester=kubecon-tester-v2, /alexellis/fn1/ = fn1-next ,invalid, =fn2")
+
+	if len(routes) != 2 {
+		t.Fatalf("want 2 routes, got %d: %v", len(routes), routes)
+	}
+	if routes["alexellis/kubecon-tester"] != "kubecon-tester-v2" {
+		t.Errorf("want kubecon-tester-v2, got %s", routes["alexellis/kubecon-tester"])
+	}
+	if routes["alexellis/fn1"] != "fn1-next" {
+		t.Errorf("want fn1-next, got %s", routes["alexellis/fn1"])
+	}
+}
+
+func Test_shadowURI(t *testing.T) {
+	routes := ShadowRoutes{"alexellis/fn1": "fn1-next"}
+
+	tests := []struct {
+		Scenario   string
+		Host       string
+		RequestURI string
+		Want       string
+		Found      bool
+	}{
+		{"function only", "alexellis", "fn1", "fn1-next", true},
+		{"sub-path and query", "alexellis", "fn1/users?id=1", "fn1-next/users?id=1", true},
+		{"query only", "alexellis", "fn1?id=1", "fn1-next?id=1", true},
+		{"other owner", "stefanprodan", "fn1", "", false},
+		{"prefix of function name", "alexellis", "fn10", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			got, found := routes.shadowURI(test.Host, test.RequestURI)
+			if found != test.Found || got != test.Want {
+				t.Errorf("want %q (%v), got %q (%v)", test.Want, test.Found, got, found)
+			}
+		})
+	}
+}
+
+func Test_makeHandler_MirrorsShadowRoute(t *testing.T) {
+	mirrored := make(chan string, 1)
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.HasSuffix(r.URL.Path, "-next") {
+			mirrored <- r.URL.Path + " " + string(body)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("primary " + string(body)))
+	}))
+	defer gateway.Close()
+
+	router := httptest.NewServer(passHandler{
+		Next: makeHandler(handlerConfig{Client: http.DefaultClient, Timeout: time.Second * 10, UpstreamURL: gateway.URL, ShadowRoutes: ShadowRoutes{"alexellis/fn1": "fn1-next"}}),
+	})
+	defer router.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, router.URL+"/fn1", strings.NewReader("hello"))
+	req.Host = "alexellis.example.xyz"
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || string(body) != "primary hello" {
+		t.Errorf("want primary response, got %d: %s", res.StatusCode, string(body))
+	}
+
+	select {
+	case got := <-mirrored:
+		if got != "/function/alexellis-fn1-next hello" {
+			t.Errorf("want mirrored request to /function/alexellis-fn1-next, got %s", got)
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("shadow function was not called")
+	}
+}
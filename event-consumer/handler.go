@@ -0,0 +1,256 @@
+package function
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+const (
+	// Source used for audit events
+	Source = "event-consumer"
+
+	// durable is the name of the JetStream consumer, which remembers
+	// which events have been handled between invocations
+	durable = "event-consumer"
+)
+
+// clock stamps dead letters, tests replace it
+var clock sdk.Clock = sdk.SystemClock{}
+
+// Handle forwards the events which github-event queued to the
+// sdk.EventStream, one at a time and in the order they were received.
+// It is invoked by the cron-connector and by github-event after each
+// event is queued. An event which can't be forwarded is delivered again
+// after event_ack_wait, up to event_max_deliver times, after which it is
+// written to the dead-letter store so that it can be replayed.
+// The response is an sdk.Response as JSON.
+func Handle(req []byte) string {
+	return handle(req).JSON()
+}
+
+func handle(req []byte) sdk.Response {
+	if err := validateInvocation(req); err != nil {
+		log.Printf("hmac error %s", err.Error())
+		return sdk.Rejected(http.StatusUnauthorized, fmt.Sprintf("%s: invalid HMAC digest: %s", Source, err.Error()))
+	}
+
+	js, err := sdk.ConnectJetStream(os.Getenv("nats_url"))
+	if err != nil {
+		log.Printf("unable to connect to NATS: %s", err.Error())
+		return sdk.Failed(http.StatusServiceUnavailable, fmt.Sprintf("%s: unable to connect to NATS: %s", Source, err.Error()))
+	}
+	defer js.Close()
+
+	maxDeliver := getInt("event_max_deliver", 5)
+
+	subjects := []string{sdk.EventSubjectPrefix + ">"}
+	if err := js.EnsureStream(sdk.EventStream, subjects, getDuration("event_max_age", 24*time.Hour), 2*time.Minute); err != nil {
+		log.Printf("unable to create stream %s: %s", sdk.EventStream, err.Error())
+		return sdk.Failed(http.StatusServiceUnavailable, fmt.Sprintf("%s: unable to create stream %s: %s", Source, sdk.EventStream, err.Error()))
+	}
+
+	if err := js.EnsureConsumer(sdk.EventStream, durable, getDuration("event_ack_wait", time.Minute), maxDeliver); err != nil {
+		log.Printf("unable to create consumer %s: %s", durable, err.Error())
+		return sdk.Failed(http.StatusServiceUnavailable, fmt.Sprintf("%s: unable to create consumer %s: %s", Source, durable, err.Error()))
+	}
+
+	forwarded, failed := consume(js, maxDeliver, time.Now().Add(getDuration("event_consume_timeout", 25*time.Second)))
+
+	return sdk.OK(fmt.Sprintf("%s: %d forwarded, %d failed", Source, forwarded, failed))
+}
+
+// validateInvocation checks the X-Cloud-Signature of the invocation. The
+// cron-connector's invocation has no body and can't be signed, it is
+// trusted since edge-auth does not route event-consumer from outside of
+// the cluster.
+func validateInvocation(req []byte) error {
+	if !sdk.HmacEnabled() {
+		return nil
+	}
+	if len(req) == 0 && os.Getenv("Http_X_Connector") == "cron-connector" {
+		return nil
+	}
+	return sdk.ValidHMAC(&req, "payload-secret", os.Getenv("Http_X_Cloud_Signature"))
+}
+
+// consume fetches events until there are none waiting, one is left to
+// be delivered again or the deadline has passed. The consumer delivers
+// the next event once the last one is acknowledged, so that an event
+// which is left unacknowledged holds up those received after it.
+func consume(js *sdk.JetStream, maxDeliver int, deadline time.Time) (int, int) {
+	forwarded, failed := 0, 0
+
+	for time.Now().Before(deadline) {
+		msgs, err := js.Fetch(sdk.EventStream, durable, 1)
+		if err != nil {
+			log.Printf("unable to fetch events: %s", err.Error())
+			break
+		}
+		if len(msgs) == 0 {
+			break
+		}
+
+		ok, retry := handleMsg(js, msgs[0], maxDeliver)
+		if ok {
+			forwarded++
+			continue
+		}
+
+		failed++
+		if retry {
+			break
+		}
+	}
+
+	return forwarded, failed
+}
+
+// handleMsg forwards an event and acknowledges it. It gives ok when the
+// event was forwarded and retry when it was left unacknowledged, to be
+// delivered again after event_ack_wait.
+func handleMsg(js *sdk.JetStream, msg sdk.JetStreamMsg, maxDeliver int) (ok bool, retry bool) {
+	event := sdk.QueuedEvent{}
+	if err := json.Unmarshal(msg.Data, &event); err != nil || len(event.Function) == 0 {
+		log.Printf("unable to parse event on %s, discarding it", msg.Subject)
+		js.Term(msg)
+		return false, false
+	}
+
+	statusCode, err := forward(event)
+	if err == nil {
+		if ackErr := js.Ack(msg); ackErr != nil {
+			log.Printf("unable to ack event for %s: %s", event.Function, ackErr.Error())
+		}
+		return true, false
+	}
+
+	deliveries := msg.Deliveries()
+	log.Printf("[%s]: %d on delivery %d of %d, %s", event.Function, statusCode, deliveries, maxDeliver, err.Error())
+
+	if retryable(statusCode) && deliveries < maxDeliver {
+		return false, true
+	}
+
+	if deadLetterErr := writeDeadLetter(event, deliveries, err); deadLetterErr != nil {
+		// Leave the event to be delivered again rather than lose it
+		log.Printf("unable to write dead-letter: %s", deadLetterErr.Error())
+		return false, true
+	}
+
+	js.Term(msg)
+	return false, false
+}
+
+// forward posts the event to its function as github-event would have,
+// signed with the payload-secret, a transport error is given as a 500
+func forward(event sdk.QueuedEvent) (int, error) {
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	r, _ := http.NewRequest(http.MethodPost, os.Getenv("gateway_url")+"function/"+event.Function, bytes.NewReader(event.Payload))
+
+	digest := hmac.Sign(event.Payload, []byte(payloadSecret))
+	r.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+	for k, v := range event.Headers {
+		r.Header.Add(k, v)
+	}
+
+	res, err := sdk.HTTPClient().Do(r)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot post to %s: %s", event.Function, err.Error())
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, fmt.Errorf("%s", sdk.Redact(string(body)))
+	}
+
+	return res.StatusCode, nil
+}
+
+// retryable is true for statuses which may succeed if tried again
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// writeDeadLetter stores an event which could not be forwarded via
+// pipeline-log, where github-event's ?action=replay reads it from, and
+// alerts operators
+func writeDeadLetter(event sdk.QueuedEvent, deliveries int, forwardErr error) error {
+	pushEvent := sdk.PushEvent{}
+	json.Unmarshal(event.Payload, &pushEvent)
+
+	deadLetter := sdk.DeadLetter{
+		Function: event.Function,
+		Headers:  event.Headers,
+		Payload:  event.Payload,
+		Attempts: deliveries,
+		Error:    forwardErr.Error(),
+		Failed:   clock.Now(),
+	}
+
+	deadLetterBytes, _ := json.Marshal(deadLetter)
+
+	p := sdk.PipelineLog{
+		RepoPath:  pushEvent.Repository.FullName,
+		CommitSHA: pushEvent.AfterCommitID,
+		Function:  event.Function,
+		Source:    sdk.DeadLetterSource,
+		Data:      string(deadLetterBytes),
+	}
+
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	if err := sdk.WritePipelineLog(os.Getenv("gateway_url"), payloadSecret, p); err != nil {
+		return err
+	}
+
+	sdk.PostAudit(sdk.AuditEvent{
+		Source:  Source,
+		Owner:   pushEvent.Repository.Owner.Login,
+		Repo:    pushEvent.Repository.Name,
+		Message: fmt.Sprintf("dead-letter: %s after %d deliveries (sha: %s): %s", event.Function, deliveries, pushEvent.AfterCommitID, forwardErr.Error()),
+	})
+
+	return nil
+}
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if val, exists := os.LookupEnv(key); exists && len(val) > 0 {
+		duration, err := time.ParseDuration(val)
+		if err != nil {
+			log.Printf("unable to parse %s: %s", key, err.Error())
+			return defaultValue
+		}
+		return duration
+	}
+	return defaultValue
+}
+
+func getInt(key string, defaultValue int) int {
+	if val, err := strconv.Atoi(os.Getenv(key)); err == nil && val > 0 {
+		return val
+	}
+	return defaultValue
+}
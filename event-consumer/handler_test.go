@@ -0,0 +1,239 @@
+package function
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
+)
+
+const testPushEvent = `{"ref":"refs/heads/master","after":"4cd1e2f","repository":{"name":"go-fns","full_name":"alexellis/go-fns","owner":{"login":"alexellis"}}}`
+
+func setupSecrets(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(path.Join(dir, "payload-secret"), []byte("secret"), 0600)
+	os.Setenv("secret_mount_path", dir)
+
+	return func() {
+		os.Unsetenv("secret_mount_path")
+		os.RemoveAll(dir)
+	}
+}
+
+func queueTestEvents(js *sdktest.FakeJetStream, payloads ...string) {
+	for _, payload := range payloads {
+		event, _ := json.Marshal(sdk.QueuedEvent{
+			Function: "github-push",
+			Headers:  map[string]string{"X-Github-Event": "push"},
+			Payload:  []byte(payload),
+		})
+		js.Add(sdk.EventSubjectPrefix+"github-push", event)
+	}
+}
+
+func connect(t *testing.T, js *sdktest.FakeJetStream) *sdk.JetStream {
+	conn, err := sdk.ConnectJetStream(js.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func Test_consume_ForwardsInOrder(t *testing.T) {
+	defer setupSecrets(t)()
+
+	js := sdktest.NewFakeJetStream()
+	defer js.Close()
+	queueTestEvents(js, `{"after":"1"}`, `{"after":"2"}`)
+
+	received := []string{}
+	var validErr error
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r.URL.Path+" "+r.Header.Get("X-Github-Event")+" "+string(body))
+		if err := hmac.Validate(body, r.Header.Get(sdk.CloudSignatureHeader), "secret"); err != nil {
+			validErr = err
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	conn := connect(t, js)
+	defer conn.Close()
+
+	forwarded, failed := consume(conn, 5, time.Now().Add(5*time.Second))
+	if forwarded != 2 || failed != 0 {
+		t.Errorf("want 2 forwarded, got %d forwarded and %d failed", forwarded, failed)
+	}
+
+	want := []string{`/function/github-push push {"after":"1"}`, `/function/github-push push {"after":"2"}`}
+	if len(received) != 2 || received[0] != want[0] || received[1] != want[1] {
+		t.Errorf("want %v, got %v", want, received)
+	}
+	if validErr != nil {
+		t.Errorf("want events signed with the payload-secret, got %s", validErr)
+	}
+
+	for _, msg := range js.Messages() {
+		if msg.Result != "+ACK" {
+			t.Errorf("want each event acknowledged, got %q", msg.Result)
+		}
+	}
+}
+
+func Test_consume_LeavesFailedEventForRedelivery(t *testing.T) {
+	defer setupSecrets(t)()
+
+	js := sdktest.NewFakeJetStream()
+	defer js.Close()
+	queueTestEvents(js, `{"after":"1"}`, `{"after":"2"}`)
+
+	calls := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	conn := connect(t, js)
+	defer conn.Close()
+
+	forwarded, failed := consume(conn, 5, time.Now().Add(5*time.Second))
+	if forwarded != 0 || failed != 1 || calls != 1 {
+		t.Errorf("want one attempt which failed, got %d forwarded, %d failed after %d calls", forwarded, failed, calls)
+	}
+
+	msgs := js.Messages()
+	if msgs[0].Result != "" || msgs[1].Deliveries != 0 {
+		t.Errorf("want the first event left unacknowledged ahead of the second, got %v", msgs)
+	}
+}
+
+func Test_consume_DeadLettersAfterMaxDeliver(t *testing.T) {
+	defer setupSecrets(t)()
+
+	js := sdktest.NewFakeJetStream()
+	defer js.Close()
+	queueTestEvents(js, testPushEvent)
+
+	var deadLetter sdk.PipelineLog
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/function/pipeline-log":
+			json.NewDecoder(r.Body).Decode(&deadLetter)
+			w.WriteHeader(http.StatusOK)
+		case "/function/github-push":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	conn := connect(t, js)
+	defer conn.Close()
+
+	consume(conn, 1, time.Now().Add(5*time.Second))
+
+	if deadLetter.Source != sdk.DeadLetterSource || deadLetter.RepoPath != "alexellis/go-fns" || deadLetter.CommitSHA != "4cd1e2f" {
+		t.Errorf("want a dead-letter for alexellis/go-fns, got %v", deadLetter)
+	}
+
+	if msgs := js.Messages(); msgs[0].Result != "+TERM" {
+		t.Errorf("want the event terminated, got %q", msgs[0].Result)
+	}
+}
+
+func Test_consume_DiscardsInvalidEvent(t *testing.T) {
+	js := sdktest.NewFakeJetStream()
+	defer js.Close()
+	js.Add(sdk.EventSubjectPrefix+"github-push", []byte("not json"))
+
+	conn := connect(t, js)
+	defer conn.Close()
+
+	forwarded, failed := consume(conn, 5, time.Now().Add(5*time.Second))
+	if forwarded != 0 || failed != 1 {
+		t.Errorf("want the event to fail, got %d forwarded and %d failed", forwarded, failed)
+	}
+
+	if msgs := js.Messages(); msgs[0].Result != "+TERM" {
+		t.Errorf("want the event terminated, got %q", msgs[0].Result)
+	}
+}
+
+func Test_validateInvocation(t *testing.T) {
+	defer setupSecrets(t)()
+
+	signature := "sha1=" + hex.EncodeToString(hmac.Sign([]byte{}, []byte("secret")))
+
+	tests := []struct {
+		title     string
+		signature string
+		connector string
+		wantErr   bool
+	}{
+		{title: "signed by github-event", signature: signature},
+		{title: "scheduled by the cron-connector", connector: "cron-connector"},
+		{title: "unsigned", wantErr: true},
+		{title: "wrong signature", signature: "sha1=" + hex.EncodeToString(hmac.Sign([]byte{}, []byte("wrong"))), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			os.Setenv("Http_X_Cloud_Signature", test.signature)
+			os.Setenv("Http_X_Connector", test.connector)
+			defer os.Unsetenv("Http_X_Cloud_Signature")
+			defer os.Unsetenv("Http_X_Connector")
+
+			err := validateInvocation([]byte{})
+			if (err != nil) != test.wantErr {
+				t.Errorf("want error: %v, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_handle_ErrorsAreResponses(t *testing.T) {
+	defer setupSecrets(t)()
+
+	t.Run("unsigned invocation is rejected", func(t *testing.T) {
+		res := handle([]byte(`{}`))
+		if res.Status != sdk.ResponseRejected || res.Code != http.StatusUnauthorized {
+			t.Errorf("want rejected with %d, got %s with %d", http.StatusUnauthorized, res.Status, res.Code)
+		}
+	})
+
+	t.Run("NATS unavailable fails", func(t *testing.T) {
+		os.Setenv("Http_X_Connector", "cron-connector")
+		os.Setenv("nats_url", "nats://127.0.0.1:1")
+		defer os.Unsetenv("Http_X_Connector")
+		defer os.Unsetenv("nats_url")
+
+		res := handle([]byte{})
+		if res.Status != sdk.ResponseFailed || res.Code != http.StatusServiceUnavailable {
+			t.Errorf("want failed with %d, got %s with %d", http.StatusServiceUnavailable, res.Status, res.Code)
+		}
+	})
+}
@@ -7,7 +7,9 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,6 +25,14 @@ const (
 
 var timeout = 3 * time.Second
 
+var audit sdk.Audit
+
+// clock and ids time and identify the reports, tests replace them
+var (
+	clock sdk.Clock       = sdk.SystemClock{}
+	ids   sdk.IDGenerator = sdk.RandomIDs{}
+)
+
 //FaaSAuth Authentication type for OpenFaaS
 type FaaSAuth struct {
 }
@@ -33,8 +43,28 @@ func (auth *FaaSAuth) Set(req *http.Request) error {
 }
 
 // Handle function cleans up functions which were removed or renamed
-// within the repo for the given user.
+// within the repo for the given user. When invoked with an empty body
+// (i.e. by the cron-connector) it scans for orphaned secrets instead.
+// Each request's report is stored in pipeline-log and given back with
+// action=report.
 func Handle(req []byte) string {
+	if audit == nil {
+		audit = sdk.AuditLogger{}
+	}
+
+	query, _ := url.ParseQuery(os.Getenv("Http_Query"))
+	if query.Get("action") == "report" {
+		return fetchReport(req, os.Getenv("gateway_url"))
+	}
+
+	if len(req) == 0 {
+		msg, err := scanSecrets(os.Getenv("gateway_url"), time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return msg
+	}
+
 	validateErr := validateRequestSigning(req)
 
 	if validateErr != nil {
@@ -48,6 +78,8 @@ func Handle(req []byte) string {
 		log.Fatal(err)
 	}
 
+	report := newReport(garbageReq, clock.Now())
+
 	owner := garbageReq.Owner
 	if garbageReq.Repo == "*" {
 		log.Printf("Removing all functions for %s", owner)
@@ -68,31 +100,87 @@ func Handle(req []byte) string {
 	log.Printf("Functions owned by %s:\n %s", owner, strings.Trim(deployedList, ", "))
 
 	client := faasSDK.NewClient(&FaaSAuth{}, gatewayURL, nil, &timeout)
-	deleted := 0
+	deleted := []string{}
 	for _, fn := range deployedFunctions {
-		if garbageReq.Repo == "*" ||
-			(fn.GetRepo() == garbageReq.Repo && !included(&fn, owner, garbageReq.Functions)) {
+		if orphaned(&fn, garbageReq) {
 			log.Printf("Delete: %s\n", fn.Name)
 			err = client.DeleteFunction(context.Background(), fn.Name, namespace)
 			if err != nil {
 				auditEvent := sdk.AuditEvent{
 					Message: fmt.Sprintf("Unable to delete function: `%s`", fn.Name),
+					Owner:   garbageReq.Owner,
+					Repo:    garbageReq.Repo,
 					Source:  Source,
 				}
-				sdk.PostAudit(auditEvent)
-				log.Println(err)
+				audit.Post(auditEvent)
+				report.addError("unable to delete function %s: %s", fn.Name, err.Error())
+				continue
 			}
-			deleted = deleted + 1
+			deleted = append(deleted, fn.Name)
 		}
 	}
+	report.FunctionsRemoved = deleted
+
+	verifyRemoved(report, garbageReq, gatewayURL)
+	if garbageReq.Repo == "*" {
+		uninstallSecrets(report, gatewayURL)
+	}
+
+	report.DurationSeconds = clock.Now().Sub(report.Started).Seconds()
+
+	if err := writeReport(gatewayURL, report); err != nil {
+		log.Printf("unable to record report %s: %s", report.ID, err.Error())
+	}
+	if err := pushReportMetrics(report); err != nil {
+		log.Printf("pushgateway: error: %s", err.Error())
+	}
+
+	msg := fmt.Sprintf("Garbage collection ran for %s/%s - %d functions deleted.", garbageReq.Owner, garbageReq.Repo, len(deleted))
+	if len(deleted) > 0 {
+		msg = fmt.Sprintf("%s Deleted: %s", msg, strings.Join(deleted, ", "))
+	}
+	if len(report.SecretsRemoved) > 0 {
+		msg = fmt.Sprintf("%s %d secrets deleted: %s", msg, len(report.SecretsRemoved), strings.Join(report.SecretsRemoved, ", "))
+	}
+	if !report.Clean() {
+		msg = fmt.Sprintf("%s Incomplete, %d functions and %d secrets remain, %d errors.", msg, len(report.FunctionsRemaining), len(report.SecretsRemaining), len(report.Errors))
+	}
+	msg = fmt.Sprintf("%s Report: %s", msg, report.ID)
 
 	auditEvent := sdk.AuditEvent{
-		Message: fmt.Sprintf("Garbage collection ran for %s/%s - %d functions deleted.", garbageReq.Owner, garbageReq.Repo, deleted),
+		Message: msg,
+		Owner:   garbageReq.Owner,
+		Repo:    garbageReq.Repo,
 		Source:  Source,
 	}
-	sdk.PostAudit(auditEvent)
+	audit.Post(auditEvent)
 
-	return fmt.Sprintf("Garbage collection ran for %s/%s - %d functions deleted.", garbageReq.Owner, garbageReq.Repo, deleted)
+	return msg
+}
+
+// orphaned is true when a function was deployed from the repo but is
+// no longer in its stack.yml. Only functions deployed from the pushed
+// branch are considered, so that a push to the staging branch does not
+// remove production functions and the reverse. Previews are only
+// considered for their own pull request.
+func orphaned(fn *openFaaSFunction, garbageReq GarbageRequest) bool {
+	if garbageReq.Repo == "*" {
+		return true
+	}
+
+	if fn.GetRepo() != garbageReq.Repo {
+		return false
+	}
+
+	if fn.GetPullRequest() != garbageReq.PullRequest {
+		return false
+	}
+
+	if len(garbageReq.Branch) > 0 && len(fn.GetBranch()) > 0 && fn.GetBranch() != garbageReq.Branch {
+		return false
+	}
+
+	return !included(fn, garbageReq.Owner, garbageReq.Functions, garbageReq.PullRequest)
 }
 
 func validateRequestSigning(req []byte) (err error) {
@@ -117,10 +205,17 @@ func formatCloudName(name, owner string) string {
 	return owner + "-" + name
 }
 
-func included(fn *openFaaSFunction, owner string, functionStack []string) bool {
+func included(fn *openFaaSFunction, owner string, functionStack []string, pullRequest int) bool {
 
 	for _, name := range functionStack {
-		if strings.EqualFold(formatCloudName(name, owner), fn.Name) {
+		cloudName := formatCloudName(name, owner)
+		if pullRequest > 0 {
+			if strings.EqualFold(cloudName+sdk.PreviewSuffix(pullRequest), fn.Name) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(cloudName, fn.Name) || strings.EqualFold(cloudName+sdk.StagingSuffix, fn.Name) {
 			return true
 		}
 	}
@@ -134,7 +229,7 @@ func listFunctions(owner, gatewayURL string) ([]openFaaSFunction, error) {
 
 	request, _ := http.NewRequest(http.MethodGet, gatewayURL+"/function/list-functions?user="+owner, nil)
 
-	response, err := http.DefaultClient.Do(request)
+	response, err := sdk.HTTPClient().Do(request)
 
 	if err == nil {
 		if response.Body != nil {
@@ -162,6 +257,12 @@ type GarbageRequest struct {
 	Functions []string `json:"functions"`
 	Repo      string   `json:"repo"`
 	Owner     string   `json:"owner"`
+	// Branch the functions were pushed to, functions deployed from other
+	// branches are kept
+	Branch string `json:"branch,omitempty"`
+	// PullRequest selects the preview functions of a pull request, an
+	// empty list of functions removes the whole preview
+	PullRequest int `json:"pullRequest,omitempty"`
 }
 
 type openFaaSFunction struct {
@@ -177,3 +278,13 @@ func (f *openFaaSFunction) GetOwner() string {
 func (f *openFaaSFunction) GetRepo() string {
 	return f.Labels[sdk.FunctionLabelPrefix+"git-repo"]
 }
+
+func (f *openFaaSFunction) GetBranch() string {
+	return f.Labels[sdk.FunctionLabelPrefix+"git-branch"]
+}
+
+// GetPullRequest is the pull request of a preview function, or zero
+func (f *openFaaSFunction) GetPullRequest() int {
+	number, _ := strconv.Atoi(f.Labels[sdk.PullRequestLabel])
+	return number
+}
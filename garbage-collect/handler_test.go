@@ -0,0 +1,349 @@
+package function
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
+)
+
+func makeFunction(name, repo, branch string) *openFaaSFunction {
+	labels := map[string]string{
+		sdk.FunctionLabelPrefix + "git-owner": "alexellis",
+		sdk.FunctionLabelPrefix + "git-repo":  repo,
+	}
+	if len(branch) > 0 {
+		labels[sdk.FunctionLabelPrefix+"git-branch"] = branch
+	}
+
+	return &openFaaSFunction{Name: name, Labels: labels}
+}
+
+func makePreview(name, repo, branch string, pullRequest int) *openFaaSFunction {
+	fn := makeFunction(name, repo, branch)
+	fn.Labels[sdk.PullRequestLabel] = strconv.Itoa(pullRequest)
+	return fn
+}
+
+func Test_orphaned(t *testing.T) {
+	cases := []struct {
+		title string
+		fn    *openFaaSFunction
+		req   GarbageRequest
+		want  bool
+	}{
+		{
+			title: "function removed from stack.yml",
+			fn:    makeFunction("alexellis-fn2", "alexa-skill", "master"),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Branch: "master", Functions: []string{"fn1"}},
+			want:  true,
+		},
+		{
+			title: "function in stack.yml",
+			fn:    makeFunction("alexellis-fn1", "alexa-skill", "master"),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Branch: "master", Functions: []string{"fn1"}},
+			want:  false,
+		},
+		{
+			title: "function from another repo",
+			fn:    makeFunction("alexellis-fn2", "other-repo", "master"),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Branch: "master", Functions: []string{"fn1"}},
+			want:  false,
+		},
+		{
+			title: "function from another branch",
+			fn:    makeFunction("alexellis-fn2-staging", "alexa-skill", "staging"),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Branch: "master", Functions: []string{"fn1"}},
+			want:  false,
+		},
+		{
+			title: "staging function in stack.yml",
+			fn:    makeFunction("alexellis-fn1-staging", "alexa-skill", "staging"),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Branch: "staging", Functions: []string{"fn1"}},
+			want:  false,
+		},
+		{
+			title: "function without a branch label",
+			fn:    makeFunction("alexellis-fn2", "alexa-skill", ""),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Branch: "master", Functions: []string{"fn1"}},
+			want:  true,
+		},
+		{
+			title: "preview kept by a push",
+			fn:    makePreview("alexellis-fn1-pr-12", "alexa-skill", "master", 12),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Branch: "master", Functions: []string{"fn2"}},
+			want:  false,
+		},
+		{
+			title: "preview function in stack.yml",
+			fn:    makePreview("alexellis-fn1-pr-12", "alexa-skill", "feature", 12),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Branch: "feature", Functions: []string{"fn1"}, PullRequest: 12},
+			want:  false,
+		},
+		{
+			title: "preview removed when the pull request closes",
+			fn:    makePreview("alexellis-fn1-pr-12", "alexa-skill", "feature", 12),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Functions: []string{}, PullRequest: 12},
+			want:  true,
+		},
+		{
+			title: "preview of another pull request",
+			fn:    makePreview("alexellis-fn1-pr-13", "alexa-skill", "feature", 13),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Functions: []string{}, PullRequest: 12},
+			want:  false,
+		},
+		{
+			title: "production function kept when a pull request closes",
+			fn:    makeFunction("alexellis-fn1", "alexa-skill", "master"),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Functions: []string{}, PullRequest: 12},
+			want:  false,
+		},
+		{
+			title: "all repos removed",
+			fn:    makeFunction("alexellis-fn1", "alexa-skill", "master"),
+			req:   GarbageRequest{Owner: "alexellis", Repo: "*"},
+			want:  true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			if got := orphaned(c.fn, c.req); got != c.want {
+				t.Errorf("want %t, got %t", c.want, got)
+			}
+		})
+	}
+}
+
+func setupFakes(t *testing.T) (*sdktest.FakeGateway, *sdktest.FakeAudit, func()) {
+	gateway := sdktest.NewFakeGateway()
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fakeAudit := &sdktest.FakeAudit{}
+	audit = fakeAudit
+
+	os.Setenv("gateway_url", gateway.URL)
+	os.Setenv("secret_mount_path", secrets)
+
+	return gateway, fakeAudit, func() {
+		gateway.Close()
+		os.RemoveAll(secrets)
+		os.Unsetenv("gateway_url")
+		os.Unsetenv("secret_mount_path")
+	}
+}
+
+func Test_Handle_DeletesOrphanedFunctions(t *testing.T) {
+	gateway, fakeAudit, done := setupFakes(t)
+	defer done()
+
+	for _, fn := range []*openFaaSFunction{
+		makeFunction("alexellis-fn1", "alexa-skill", "master"),
+		makeFunction("alexellis-fn2", "alexa-skill", "master"),
+	} {
+		gateway.AddFunction(sdktest.Function{Name: fn.Name, Labels: fn.Labels})
+	}
+
+	listBytes, _ := json.Marshal(gateway.Functions())
+	gateway.SetResponse("list-functions", http.StatusOK, string(listBytes))
+
+	req, _ := json.Marshal(GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Branch: "master", Functions: []string{"fn1"}})
+	os.Setenv("Http_X_Cloud_Signature", sdktest.Sign(req, "secret"))
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	res := Handle(req)
+
+	if !strings.Contains(res, "1 functions deleted") {
+		t.Errorf("want 1 function deleted, got: %q", res)
+	}
+	if _, ok := gateway.Function("alexellis-fn2"); ok {
+		t.Errorf("want alexellis-fn2 removed from the gateway")
+	}
+	if _, ok := gateway.Function("alexellis-fn1"); !ok {
+		t.Errorf("want alexellis-fn1 kept")
+	}
+	if messages := fakeAudit.Messages(); len(messages) != 1 || messages[0] != res {
+		t.Errorf("want the result audited, got %v", messages)
+	}
+}
+
+func Test_Handle_ScanDeletesOrphanedSecrets(t *testing.T) {
+	gateway, fakeAudit, done := setupFakes(t)
+	defer done()
+
+	os.Setenv("secret_scan_policy", secretScanDelete)
+	os.Setenv("secret_grace_period", "1h")
+	defer os.Unsetenv("secret_scan_policy")
+	defer os.Unsetenv("secret_grace_period")
+
+	gateway.AddFunction(sdktest.Function{
+		Name:    "alexellis-fn1",
+		Labels:  map[string]string{sdk.FunctionLabelPrefix + "git-owner": "alexellis"},
+		Secrets: []string{"alexellis-db-password"},
+	})
+	gateway.AddSecret("alexellis-db-password", "")
+	gateway.AddSecret("alexellis-old-token", "")
+	gateway.AddSecret("payload-secret", "")
+
+	state, _ := json.Marshal(secretScanState{Unreferenced: map[string]time.Time{
+		"alexellis-old-token": time.Now().Add(-2 * time.Hour),
+	}})
+	gateway.SetResponse("pipeline-log", http.StatusOK, string(state))
+
+	res := Handle(nil)
+
+	if res != "Secret scan ran - 1 secrets deleted, 0 awaiting the grace period." {
+		t.Errorf("want 1 secret deleted, got: %q", res)
+	}
+
+	secrets := gateway.Secrets()
+	if len(secrets) != 2 || secrets[0] != "alexellis-db-password" || secrets[1] != "payload-secret" {
+		t.Errorf("want alexellis-old-token removed, got %v", secrets)
+	}
+
+	if messages := fakeAudit.Messages(); len(messages) != 1 || !strings.Contains(messages[0], "alexellis-old-token") {
+		t.Errorf("want the deleted secret audited, got %v", messages)
+	}
+
+	writes := 0
+	for _, invocation := range gateway.Invocations("pipeline-log") {
+		if invocation.Method == http.MethodPost {
+			writes++
+		}
+	}
+	if writes != 1 {
+		t.Errorf("want the scan state recorded once, got %d writes", writes)
+	}
+}
+
+func Test_Handle_RecordsReport(t *testing.T) {
+	gateway, _, done := setupFakes(t)
+	defer done()
+
+	ids = &sdktest.SequentialIDs{Prefix: "gc-"}
+	defer func() { ids = sdk.RandomIDs{} }()
+
+	gateway.AddFunction(sdktest.Function{Name: "alexellis-fn2", Labels: makeFunction("alexellis-fn2", "alexa-skill", "master").Labels})
+	listBytes, _ := json.Marshal(gateway.Functions())
+	gateway.SetResponse("list-functions", http.StatusOK, string(listBytes))
+
+	req, _ := json.Marshal(GarbageRequest{Owner: "alexellis", Repo: "alexa-skill", Branch: "master", Functions: []string{"fn1"}})
+	os.Setenv("Http_X_Cloud_Signature", sdktest.Sign(req, "secret"))
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	res := Handle(req)
+
+	if !strings.HasSuffix(res, "Report: gc-1") {
+		t.Errorf("want the report's ID in the result, got: %q", res)
+	}
+
+	writes := []gcReport{}
+	for _, invocation := range gateway.Invocations("pipeline-log") {
+		p := sdk.PipelineLog{}
+		json.Unmarshal(invocation.Body, &p)
+		if p.Source != sdk.GarbageReportSource {
+			continue
+		}
+		report := gcReport{}
+		json.Unmarshal([]byte(p.Data), &report)
+		if p.CommitSHA != report.ID || p.RepoPath != reportRepoPath {
+			t.Errorf("want the report stored by its ID, got %s/%s", p.RepoPath, p.CommitSHA)
+		}
+		writes = append(writes, report)
+	}
+
+	if len(writes) != 1 {
+		t.Fatalf("want one report recorded, got %d", len(writes))
+	}
+	report := writes[0]
+	if len(report.FunctionsRemoved) != 1 || report.FunctionsRemoved[0] != "alexellis-fn2" {
+		t.Errorf("want alexellis-fn2 in the report, got %v", report.FunctionsRemoved)
+	}
+	if !report.Clean() {
+		t.Errorf("want a clean report, got %+v", report)
+	}
+}
+
+func Test_Handle_UninstallReportsSecrets(t *testing.T) {
+	gateway, _, done := setupFakes(t)
+	defer done()
+
+	os.Setenv("secret_scan_policy", secretScanDelete)
+	defer os.Unsetenv("secret_scan_policy")
+
+	for _, fn := range []sdktest.Function{
+		{Name: "alexellis-fn1", Labels: map[string]string{sdk.FunctionLabelPrefix + "git-owner": "alexellis"}, Secrets: []string{"alexellis-db-password"}},
+		{Name: "alexellis-ltd-fn1", Labels: map[string]string{sdk.FunctionLabelPrefix + "git-owner": "alexellis-ltd"}, Secrets: []string{"alexellis-ltd-token"}},
+	} {
+		gateway.AddFunction(fn)
+	}
+	gateway.AddSecret("alexellis-db-password", "")
+	gateway.AddSecret("alexellis-ltd-token", "")
+	gateway.AddSecret("payload-secret", "")
+
+	list := []sdktest.Function{}
+	fn, _ := gateway.Function("alexellis-fn1")
+	list = append(list, fn)
+	listBytes, _ := json.Marshal(list)
+	gateway.SetResponse("list-functions", http.StatusOK, string(listBytes))
+
+	req, _ := json.Marshal(GarbageRequest{Owner: "alexellis", Repo: "*"})
+	os.Setenv("Http_X_Cloud_Signature", sdktest.Sign(req, "secret"))
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	res := Handle(req)
+
+	if !strings.Contains(res, "1 secrets deleted: alexellis-db-password") {
+		t.Errorf("want the owner's secret deleted, got: %q", res)
+	}
+
+	secrets := gateway.Secrets()
+	if len(secrets) != 2 || secrets[0] != "alexellis-ltd-token" || secrets[1] != "payload-secret" {
+		t.Errorf("want only alexellis-db-password removed, got %v", secrets)
+	}
+}
+
+func Test_Handle_FetchesReport(t *testing.T) {
+	gateway, _, done := setupFakes(t)
+	defer done()
+
+	stored, _ := json.Marshal(gcReport{ID: "gc-1", Owner: "alexellis", Repo: "*", FunctionsRemoved: []string{"alexellis-fn1"}})
+	gateway.SetResponse("pipeline-log", http.StatusOK, string(stored))
+
+	os.Setenv("Http_Query", "action=report")
+	defer os.Unsetenv("Http_Query")
+
+	req := []byte(`{"id": "gc-1"}`)
+	os.Setenv("Http_X_Cloud_Signature", sdktest.Sign(req, "secret"))
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	report := gcReport{}
+	if err := json.Unmarshal([]byte(Handle(req)), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.ID != "gc-1" || len(report.FunctionsRemoved) != 1 {
+		t.Errorf("want the stored report, got %+v", report)
+	}
+
+	invocations := gateway.Invocations("pipeline-log")
+	if len(invocations) != 1 || !strings.Contains(invocations[0].Query, "commitSHA=gc-1") {
+		t.Errorf("want the report read by its ID, got %v", invocations)
+	}
+
+	os.Setenv("Http_X_Cloud_Signature", sdktest.Sign(req, "wrong"))
+	rejected := sdk.Response{}
+	json.Unmarshal([]byte(Handle(req)), &rejected)
+	if rejected.Code != http.StatusUnauthorized {
+		t.Errorf("want an unsigned request rejected, got %+v", rejected)
+	}
+}
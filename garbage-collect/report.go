@@ -0,0 +1,281 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// Reports are stored in pipeline-log at system/gc-reports/<id>/garbage-collect
+const reportRepoPath = "system/gc-reports"
+
+// gcReport records what a GarbageRequest removed, and what was left
+// behind, so that an operator can check that an uninstall cleaned up
+type gcReport struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	Repo        string    `json:"repo"`
+	Branch      string    `json:"branch,omitempty"`
+	PullRequest int       `json:"pullRequest,omitempty"`
+	Started     time.Time `json:"started"`
+
+	DurationSeconds  float64  `json:"durationSeconds"`
+	FunctionsRemoved []string `json:"functionsRemoved"`
+	SecretsRemoved   []string `json:"secretsRemoved"`
+	// FunctionsRemaining were still deployed after the request, although
+	// they should have been removed
+	FunctionsRemaining []string `json:"functionsRemaining"`
+	// SecretsRemaining are the owner's unreferenced secrets which an
+	// uninstall left behind, i.e. because secret_scan_policy is not delete
+	SecretsRemaining []string `json:"secretsRemaining"`
+	Errors           []string `json:"errors"`
+}
+
+// reportRequest fetches a report by its ID with action=report
+type reportRequest struct {
+	ID string `json:"id"`
+}
+
+func newReport(garbageReq GarbageRequest, started time.Time) *gcReport {
+	return &gcReport{
+		ID:                 ids.NewID(),
+		Owner:              garbageReq.Owner,
+		Repo:               garbageReq.Repo,
+		Branch:             garbageReq.Branch,
+		PullRequest:        garbageReq.PullRequest,
+		Started:            started,
+		FunctionsRemoved:   []string{},
+		SecretsRemoved:     []string{},
+		FunctionsRemaining: []string{},
+		SecretsRemaining:   []string{},
+		Errors:             []string{},
+	}
+}
+
+// addError records the error in the report as well as logging it
+func (r *gcReport) addError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	log.Println(msg)
+	r.Errors = append(r.Errors, msg)
+}
+
+// Clean is true when everything the request should have removed is gone
+func (r *gcReport) Clean() bool {
+	return len(r.Errors) == 0 && len(r.FunctionsRemaining) == 0 && len(r.SecretsRemaining) == 0
+}
+
+// verifyRemoved reads the functions back from the gateway, rather than
+// list-functions, and records those which the request should have
+// removed but are still deployed
+func verifyRemoved(report *gcReport, garbageReq GarbageRequest, gatewayURL string) {
+	functions, err := listDeployedFunctions(gatewayURL)
+	if err != nil {
+		report.addError("unable to verify removal: %s", err.Error())
+		return
+	}
+
+	for _, deployed := range functions {
+		fn := openFaaSFunction{Name: deployed.Name, Labels: deployed.Labels}
+		if !strings.EqualFold(fn.GetOwner(), garbageReq.Owner) {
+			continue
+		}
+		if orphaned(&fn, garbageReq) {
+			report.FunctionsRemaining = append(report.FunctionsRemaining, fn.Name)
+		}
+	}
+	sort.Strings(report.FunctionsRemaining)
+}
+
+// uninstallSecrets handles the owner's secrets once all of their
+// functions are removed. None of them can be referenced any longer so
+// the grace period of the secret scan does not apply, they are deleted
+// when secret_scan_policy is delete and otherwise reported as remaining.
+// A secret whose name starts with a longer owner's prefix, still in use,
+// is left to that owner.
+func uninstallSecrets(report *gcReport, gatewayURL string) {
+	policy := secretScanPolicy()
+	if policy == secretScanDisabled {
+		return
+	}
+
+	functions, err := listDeployedFunctions(gatewayURL)
+	if err != nil {
+		report.addError("unable to list functions for secrets: %s", err.Error())
+		return
+	}
+
+	client := faasSDK.NewClient(&FaaSAuth{}, gatewayURL, nil, &timeout)
+	secretList, err := client.GetSecretList(context.Background(), namespace)
+	if err != nil {
+		report.addError("unable to list secrets: %s", err.Error())
+		return
+	}
+
+	owners := []string{report.Owner}
+	referenced := map[string]bool{}
+	for _, fn := range functions {
+		if owner := fn.Labels[sdk.FunctionLabelPrefix+"git-owner"]; len(owner) > 0 {
+			owners = append(owners, owner)
+		}
+		for _, secret := range fn.Secrets {
+			referenced[strings.ToLower(secret)] = true
+		}
+	}
+
+	for _, secret := range secretList {
+		if !strings.EqualFold(secretOwner(secret.Name, owners), report.Owner) || referenced[strings.ToLower(secret.Name)] {
+			continue
+		}
+
+		if policy != secretScanDelete {
+			report.SecretsRemaining = append(report.SecretsRemaining, secret.Name)
+			continue
+		}
+
+		log.Printf("Delete secret: %s\n", secret.Name)
+		if err := client.RemoveSecret(context.Background(), types.Secret{Name: secret.Name, Namespace: namespace}); err != nil {
+			report.addError("unable to delete secret %s: %s", secret.Name, err.Error())
+			report.SecretsRemaining = append(report.SecretsRemaining, secret.Name)
+			continue
+		}
+		report.SecretsRemoved = append(report.SecretsRemoved, secret.Name)
+	}
+}
+
+// exposition renders the report's metrics in the Prometheus text format
+func (r *gcReport) exposition() string {
+	var b strings.Builder
+
+	write := func(name, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+	}
+
+	write("of_gc_functions_removed", "Functions removed by the last garbage collection.", float64(len(r.FunctionsRemoved)))
+	write("of_gc_secrets_removed", "Secrets removed by the last garbage collection.", float64(len(r.SecretsRemoved)))
+	write("of_gc_functions_remaining", "Functions which the last garbage collection left deployed.", float64(len(r.FunctionsRemaining)))
+	write("of_gc_errors", "Errors during the last garbage collection.", float64(len(r.Errors)))
+	write("of_gc_duration_seconds", "Time taken by the last garbage collection.", r.DurationSeconds)
+	write("of_gc_last_run_timestamp_seconds", "Time the last garbage collection started.", float64(r.Started.Unix()))
+
+	return b.String()
+}
+
+// pushReportMetrics replaces the metrics for the owner and repo in the
+// Prometheus Pushgateway at pushgateway_url. It is a no-op when
+// pushgateway_url is not set.
+func pushReportMetrics(r *gcReport) error {
+	pushgatewayURL := strings.TrimSuffix(os.Getenv("pushgateway_url"), "/")
+	if len(pushgatewayURL) == 0 {
+		return nil
+	}
+
+	// "*" is an uninstall of the whole account
+	repo := r.Repo
+	if repo == "*" {
+		repo = "all"
+	}
+
+	groupingKey := fmt.Sprintf("/metrics/job/garbage-collect/owner/%s/repo/%s",
+		url.PathEscape(r.Owner), url.PathEscape(repo))
+
+	req, _ := http.NewRequest(http.MethodPut, pushgatewayURL+groupingKey, bytes.NewBufferString(r.exposition()))
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("unexpected status code from pushgateway: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func writeReport(gatewayURL string, report *gcReport) error {
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	reportBytes, _ := json.Marshal(report)
+
+	p := sdk.PipelineLog{
+		RepoPath:  reportRepoPath,
+		CommitSHA: report.ID,
+		Function:  Source,
+		Source:    sdk.GarbageReportSource,
+		Data:      string(reportBytes),
+	}
+
+	return sdk.WritePipelineLog(gatewayURL, payloadSecret, p)
+}
+
+// readReport gives the stored report, or nil when there is none
+func readReport(gatewayURL string, id string) (*gcReport, error) {
+	body, err := sdk.ReadPipelineLog(gatewayURL, sdk.PipelineLog{
+		RepoPath:  reportRepoPath,
+		CommitSHA: id,
+		Function:  Source,
+		Source:    sdk.GarbageReportSource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+
+	report := &gcReport{}
+	if err := json.Unmarshal(body, report); err != nil {
+		return nil, fmt.Errorf("unable to parse report: %s", err.Error())
+	}
+	return report, nil
+}
+
+// fetchReport answers action=report, the body is a reportRequest signed
+// with the payload-secret
+func fetchReport(req []byte, gatewayURL string) string {
+	if err := validateRequestSigning(req); err != nil {
+		return sdk.Rejected(http.StatusUnauthorized, err.Error()).JSON()
+	}
+
+	reportReq := reportRequest{}
+	if err := json.Unmarshal(req, &reportReq); err != nil || len(reportReq.ID) == 0 {
+		return sdk.Rejected(http.StatusBadRequest, "id is required").JSON()
+	}
+
+	// The ID is used in the path of the report
+	if strings.ContainsAny(reportReq.ID, "/.") {
+		return sdk.Rejected(http.StatusBadRequest, "invalid id").JSON()
+	}
+
+	report, err := readReport(gatewayURL, reportReq.ID)
+	if err != nil {
+		return sdk.Failed(http.StatusBadGateway, err.Error()).JSON()
+	}
+	if report == nil {
+		return sdk.Rejected(http.StatusNotFound, fmt.Sprintf("no report found for %s", reportReq.ID)).JSON()
+	}
+
+	reportBytes, _ := json.Marshal(report)
+	return string(reportBytes)
+}
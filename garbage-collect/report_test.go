@@ -0,0 +1,62 @@
+package function
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_pushReportMetrics_GroupsByRepo(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	os.Setenv("pushgateway_url", server.URL+"/")
+	defer os.Unsetenv("pushgateway_url")
+
+	report := &gcReport{
+		Owner:            "alexellis",
+		Repo:             "*",
+		Started:          time.Unix(1500000000, 0),
+		DurationSeconds:  1.5,
+		FunctionsRemoved: []string{"alexellis-fn1", "alexellis-fn2"},
+		SecretsRemoved:   []string{"alexellis-db-password"},
+		Errors:           []string{"unable to delete function alexellis-fn3"},
+	}
+
+	if err := pushReportMetrics(report); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/metrics/job/garbage-collect/owner/alexellis/repo/all" {
+		t.Errorf("want an uninstall grouped as repo all, got %s", path)
+	}
+
+	for _, want := range []string{
+		"of_gc_functions_removed 2\n",
+		"of_gc_secrets_removed 1\n",
+		"of_gc_errors 1\n",
+		"of_gc_duration_seconds 1.5\n",
+		"of_gc_last_run_timestamp_seconds 1.5e+09\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in:\n%s", want, body)
+		}
+	}
+}
+
+func Test_pushReportMetrics_NotConfigured(t *testing.T) {
+	os.Unsetenv("pushgateway_url")
+
+	if err := pushReportMetrics(&gcReport{}); err != nil {
+		t.Errorf("want no error, got %s", err.Error())
+	}
+}
@@ -0,0 +1,293 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// Policies for secrets which are no longer referenced by any function,
+// set with secret_scan_policy
+const (
+	// secretScanReport sends the orphaned secrets to audit-event
+	secretScanReport = "report"
+	// secretScanDelete removes the orphaned secrets from the gateway
+	secretScanDelete = "delete"
+	// secretScanDisabled skips the scan
+	secretScanDisabled = "disabled"
+)
+
+const defaultSecretGracePeriod = 7 * 24 * time.Hour
+
+// The scan state is stored in pipeline-log at system/secrets/garbage-collect
+const (
+	secretScanRepoPath = "system"
+	secretScanSHA      = "secrets"
+)
+
+func secretScanPolicy() string {
+	switch policy := os.Getenv("secret_scan_policy"); policy {
+	case secretScanDelete, secretScanDisabled:
+		return policy
+	case "", secretScanReport:
+		return secretScanReport
+	default:
+		log.Printf("unknown secret_scan_policy: %s, using %s", policy, secretScanReport)
+		return secretScanReport
+	}
+}
+
+// secretGracePeriod is how long a secret must stay unreferenced before
+// it is reported or deleted, so that a secret created ahead of the push
+// which uses it is not removed
+func secretGracePeriod() time.Duration {
+	val := os.Getenv("secret_grace_period")
+	if len(val) == 0 {
+		return defaultSecretGracePeriod
+	}
+
+	grace, err := time.ParseDuration(val)
+	if err != nil {
+		log.Printf("invalid secret_grace_period: %s, using %s", val, defaultSecretGracePeriod)
+		return defaultSecretGracePeriod
+	}
+	return grace
+}
+
+// deployedFunction is a function with the secrets it mounts, as returned
+// by the gateway's system/functions endpoint
+type deployedFunction struct {
+	Name    string            `json:"name"`
+	Labels  map[string]string `json:"labels"`
+	Secrets []string          `json:"secrets"`
+}
+
+// secretScanState records when each unreferenced secret was first found
+type secretScanState struct {
+	Unreferenced map[string]time.Time `json:"unreferenced"`
+}
+
+// orphanedSecret is a secret past its grace period
+type orphanedSecret struct {
+	Name  string
+	Owner string
+}
+
+// secretOwner returns the owner whose prefix the secret has, the longest
+// match wins so that "alex" does not claim "alex-ellis-key" from "alex-ellis"
+func secretOwner(secret string, owners []string) string {
+	match := ""
+	for _, owner := range owners {
+		if strings.HasPrefix(strings.ToLower(secret), strings.ToLower(owner)+"-") && len(owner) > len(match) {
+			match = owner
+		}
+	}
+	return match
+}
+
+// findOrphanedSecrets compares the owner-prefixed secrets with those
+// mounted by the deployed functions. Unreferenced secrets are added to
+// the state when first found and returned once the grace period has
+// passed. Secrets which are referenced again, or have been removed, are
+// dropped from the state.
+func findOrphanedSecrets(secrets []string, functions []deployedFunction, state *secretScanState, now time.Time, grace time.Duration) []orphanedSecret {
+	if state.Unreferenced == nil {
+		state.Unreferenced = map[string]time.Time{}
+	}
+
+	owners := []string{}
+	referenced := map[string]bool{}
+	for _, fn := range functions {
+		if owner := fn.Labels[sdk.FunctionLabelPrefix+"git-owner"]; len(owner) > 0 {
+			owners = append(owners, owner)
+		}
+		for _, secret := range fn.Secrets {
+			referenced[strings.ToLower(secret)] = true
+		}
+	}
+
+	existing := map[string]bool{}
+	orphans := []orphanedSecret{}
+	for _, secret := range secrets {
+		existing[secret] = true
+
+		owner := secretOwner(secret, owners)
+		if len(owner) == 0 {
+			continue
+		}
+
+		if referenced[strings.ToLower(secret)] {
+			delete(state.Unreferenced, secret)
+			continue
+		}
+
+		firstSeen, ok := state.Unreferenced[secret]
+		if !ok {
+			state.Unreferenced[secret] = now
+			continue
+		}
+
+		if now.Sub(firstSeen) >= grace {
+			orphans = append(orphans, orphanedSecret{Name: secret, Owner: owner})
+		}
+	}
+
+	for secret := range state.Unreferenced {
+		if !existing[secret] {
+			delete(state.Unreferenced, secret)
+		}
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Name < orphans[j].Name
+	})
+
+	return orphans
+}
+
+// scanSecrets is run by the cron-connector and reports or deletes the
+// owner-prefixed secrets which no deployed function has referenced for
+// longer than secret_grace_period
+func scanSecrets(gatewayURL string, now time.Time) (string, error) {
+	policy := secretScanPolicy()
+	if policy == secretScanDisabled {
+		return "Secret scan disabled", nil
+	}
+
+	functions, err := listDeployedFunctions(gatewayURL)
+	if err != nil {
+		return "", err
+	}
+
+	client := faasSDK.NewClient(&FaaSAuth{}, gatewayURL, nil, &timeout)
+	secretList, err := client.GetSecretList(context.Background(), namespace)
+	if err != nil {
+		return "", err
+	}
+
+	secrets := []string{}
+	for _, secret := range secretList {
+		secrets = append(secrets, secret.Name)
+	}
+
+	state, err := readScanState(gatewayURL)
+	if err != nil {
+		return "", err
+	}
+
+	orphans := findOrphanedSecrets(secrets, functions, state, now, secretGracePeriod())
+
+	byOwner := map[string][]string{}
+	for _, orphan := range orphans {
+		if policy == secretScanDelete {
+			log.Printf("Delete secret: %s\n", orphan.Name)
+			if err := client.RemoveSecret(context.Background(), types.Secret{Name: orphan.Name, Namespace: namespace}); err != nil {
+				log.Printf("unable to delete secret %s: %s", orphan.Name, err.Error())
+				continue
+			}
+			delete(state.Unreferenced, orphan.Name)
+		}
+		byOwner[orphan.Owner] = append(byOwner[orphan.Owner], orphan.Name)
+	}
+
+	if err := writeScanState(gatewayURL, state); err != nil {
+		log.Printf("unable to record secret scan: %s", err.Error())
+	}
+
+	verb := "unreferenced"
+	if policy == secretScanDelete {
+		verb = "deleted"
+	}
+
+	count := 0
+	for owner, names := range byOwner {
+		count += len(names)
+		audit.Post(sdk.AuditEvent{
+			Message: fmt.Sprintf("Secret scan - %d secrets %s: %s", len(names), verb, strings.Join(names, ", ")),
+			Owner:   owner,
+			Source:  Source,
+		})
+	}
+
+	return fmt.Sprintf("Secret scan ran - %d secrets %s, %d awaiting the grace period.", count, verb, len(state.Unreferenced)), nil
+}
+
+func listDeployedFunctions(gatewayURL string) ([]deployedFunction, error) {
+	req, _ := http.NewRequest(http.MethodGet, gatewayURL+"system/functions", nil)
+	if err := sdk.AddBasicAuth(req); err != nil {
+		return nil, err
+	}
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from gateway: %d", res.StatusCode)
+	}
+
+	functions := []deployedFunction{}
+	if err := json.Unmarshal(body, &functions); err != nil {
+		return nil, err
+	}
+
+	return functions, nil
+}
+
+func readScanState(gatewayURL string) (*secretScanState, error) {
+	body, err := sdk.ReadPipelineLog(gatewayURL, sdk.PipelineLog{
+		RepoPath:  secretScanRepoPath,
+		CommitSHA: secretScanSHA,
+		Function:  Source,
+		Source:    sdk.SecretScanSource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	state := &secretScanState{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, state); err != nil {
+			return nil, fmt.Errorf("unable to parse secret scan state: %s", err.Error())
+		}
+	}
+
+	return state, nil
+}
+
+func writeScanState(gatewayURL string, state *secretScanState) error {
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	stateBytes, _ := json.Marshal(state)
+
+	p := sdk.PipelineLog{
+		RepoPath:  secretScanRepoPath,
+		CommitSHA: secretScanSHA,
+		Function:  Source,
+		Source:    sdk.SecretScanSource,
+		Data:      string(stateBytes),
+	}
+
+	return sdk.WritePipelineLog(gatewayURL, payloadSecret, p)
+}
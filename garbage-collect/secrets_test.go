@@ -0,0 +1,117 @@
+package function
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func makeDeployed(owner string, secrets ...string) deployedFunction {
+	return deployedFunction{
+		Name:    owner + "-fn",
+		Labels:  map[string]string{sdk.FunctionLabelPrefix + "git-owner": owner},
+		Secrets: secrets,
+	}
+}
+
+func Test_secretOwner(t *testing.T) {
+	owners := []string{"alex", "alex-ellis", "openfaas"}
+
+	cases := []struct {
+		secret string
+		want   string
+	}{
+		{"alex-ellis-api-key", "alex-ellis"},
+		{"alex-token", "alex"},
+		{"openfaas-token", "openfaas"},
+		{"payload-secret", ""},
+		{"alexellis-token", ""},
+	}
+
+	for _, c := range cases {
+		if got := secretOwner(c.secret, owners); got != c.want {
+			t.Errorf("secretOwner(%q): want %q, got %q", c.secret, c.want, got)
+		}
+	}
+}
+
+func Test_findOrphanedSecrets(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	grace := 24 * time.Hour
+
+	functions := []deployedFunction{makeDeployed("alexellis", "alexellis-db-password")}
+	secrets := []string{"alexellis-db-password", "alexellis-old-token", "alexellis-new-token", "payload-secret"}
+
+	state := &secretScanState{Unreferenced: map[string]time.Time{
+		"alexellis-old-token":   now.Add(-48 * time.Hour),
+		"alexellis-db-password": now.Add(-48 * time.Hour),
+		"alexellis-deleted":     now.Add(-48 * time.Hour),
+	}}
+
+	orphans := findOrphanedSecrets(secrets, functions, state, now, grace)
+
+	if len(orphans) != 1 || orphans[0].Name != "alexellis-old-token" || orphans[0].Owner != "alexellis" {
+		t.Fatalf("want alexellis-old-token orphaned, got %v", orphans)
+	}
+
+	if _, ok := state.Unreferenced["alexellis-db-password"]; ok {
+		t.Errorf("want a referenced secret dropped from the state")
+	}
+	if _, ok := state.Unreferenced["alexellis-deleted"]; ok {
+		t.Errorf("want a removed secret dropped from the state")
+	}
+	if got := state.Unreferenced["alexellis-new-token"]; !got.Equal(now) {
+		t.Errorf("want alexellis-new-token first seen at %s, got %s", now, got)
+	}
+	if _, ok := state.Unreferenced["payload-secret"]; ok {
+		t.Errorf("want secrets without an owner prefix ignored")
+	}
+}
+
+func Test_findOrphanedSecrets_WithinGracePeriod(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	functions := []deployedFunction{makeDeployed("alexellis")}
+	state := &secretScanState{Unreferenced: map[string]time.Time{
+		"alexellis-token": now.Add(-time.Hour),
+	}}
+
+	orphans := findOrphanedSecrets([]string{"alexellis-token"}, functions, state, now, 24*time.Hour)
+	if len(orphans) != 0 {
+		t.Errorf("want no orphans within the grace period, got %v", orphans)
+	}
+}
+
+func Test_secretGracePeriod(t *testing.T) {
+	os.Setenv("secret_grace_period", "72h")
+	defer os.Unsetenv("secret_grace_period")
+
+	if got := secretGracePeriod(); got != 72*time.Hour {
+		t.Errorf("want 72h, got %s", got)
+	}
+
+	os.Setenv("secret_grace_period", "three days")
+	if got := secretGracePeriod(); got != defaultSecretGracePeriod {
+		t.Errorf("want the default for an invalid value, got %s", got)
+	}
+}
+
+func Test_secretScanPolicy(t *testing.T) {
+	cases := map[string]string{
+		"":         secretScanReport,
+		"report":   secretScanReport,
+		"delete":   secretScanDelete,
+		"disabled": secretScanDisabled,
+		"purge":    secretScanReport,
+	}
+
+	for value, want := range cases {
+		os.Setenv("secret_scan_policy", value)
+		if got := secretScanPolicy(); got != want {
+			t.Errorf("secret_scan_policy=%q: want %s, got %s", value, want, got)
+		}
+	}
+	os.Unsetenv("secret_scan_policy")
+}
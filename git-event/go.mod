@@ -0,0 +1,10 @@
+module github.com/openfaas/openfaas-cloud/git-event
+
+go 1.13
+
+require (
+	github.com/alexellis/hmac v0.0.0-20180624210714-d5d71edd7bc7
+	github.com/openfaas/faas-provider v0.0.0-20180910095832-845bf7aa58cb
+	github.com/openfaas/openfaas-cloud v0.0.0-20200303103051-6c3e056a6ac4
+	gopkg.in/yaml.v2 v2.2.8 // indirect
+)
@@ -0,0 +1,213 @@
+package function
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+const (
+	// Source name for this function when auditing
+	Source = "git-event"
+	// SCM is set on each sdk.PushEvent so that git-tar clones with the
+	// owner's deploy key
+	SCM = "git"
+)
+
+var audit sdk.Audit
+
+// Handle accepts a push to a branch on a plain git server, such as Azure
+// DevOps, SourceHut or a bare repository with a post-receive hook, and
+// sends an sdk.PushEvent to git-tar. The body is an sdk.GitPushEvent
+// signed in X-Hub-Signature-256 with the owner's webhook secret from the
+// git-deploy-keys secret, whose credentials git-tar clones with.
+// The response is an sdk.Response as JSON.
+func Handle(req []byte) string {
+	return handle(req).JSON()
+}
+
+func handle(req []byte) sdk.Response {
+	if audit == nil {
+		audit = sdk.AuditLogger{}
+	}
+
+	event := sdk.GitPushEvent{}
+	if err := json.Unmarshal(req, &event); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("error while un-marshaling event: %s", err.Error()))
+	}
+
+	owner := strings.ToLower(event.Owner)
+	if len(owner) == 0 || len(event.Repo) == 0 {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("%s: owner and repo are required", Source))
+	}
+
+	// The owner is only known from the body, so it is trusted once the
+	// body is signed with their own webhook secret
+	key, keyErr := sdk.ReadGitDeployKey(owner)
+	if keyErr != nil {
+		msg := fmt.Sprintf("%s: unable to validate webhook: %s", Source, keyErr.Error())
+		audit.Post(sdk.AuditEvent{Message: msg, Owner: owner, Source: Source})
+		return sdk.Rejected(http.StatusUnauthorized, msg)
+	}
+
+	scm, _ := sdk.GetSCM(SCM)
+	header := http.Header{}
+	header.Set(sdk.GitHubSignature256Header, os.Getenv("Http_X_Hub_Signature_256"))
+	if err := scm.ValidateWebhook(req, header, key.WebhookSecret); err != nil {
+		msg := fmt.Sprintf("%s: unable to validate webhook: %s", Source, err.Error())
+		audit.Post(sdk.AuditEvent{Message: msg, Owner: owner, Source: Source})
+		return sdk.Rejected(http.StatusUnauthorized, msg)
+	}
+
+	pushEvent, err := pushEventFrom(event)
+	if err != nil {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("%s: %s", Source, err.Error()))
+	}
+
+	customers := sdk.NewCustomers(os.Getenv("customers_path"), os.Getenv("customers_url"))
+	customers.Fetch()
+
+	customer, found := customers.Lookup(owner)
+	if sdk.ValidateCustomers() && !found {
+		audit.Post(sdk.AuditEvent{Message: "Customer not found", Owner: owner, Source: Source})
+		return sdk.Rejected(http.StatusForbidden, fmt.Sprintf("Customer: %q not found in customers ACL", owner))
+	}
+	pushEvent.Customer = customer
+
+	if branchErr := checkBranch(pushEvent.Ref); branchErr != nil {
+		audit.Post(sdk.AuditEvent{
+			Message: branchErr.Error(),
+			Owner:   owner,
+			Repo:    pushEvent.Repository.Name,
+			Source:  Source,
+		})
+		return sdk.Skipped(branchErr.Error())
+	}
+
+	if sdk.SkipCI(pushEvent.HeadCommit) {
+		return sdk.Skipped(fmt.Sprintf("skipping build for: %s, [skip ci] in commit message", sdk.FormatShortSHA(pushEvent.AfterCommitID)))
+	}
+
+	statusCode, postErr := postEvent(pushEvent)
+	if postErr != nil {
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("error while posting event to git-tar: %s", postErr.Error()))
+	}
+
+	audit.Post(sdk.AuditEvent{
+		Message: "Git-tar invoked",
+		Owner:   owner,
+		Repo:    pushEvent.Repository.Name,
+		Source:  Source,
+	})
+
+	return sdk.Accepted(fmt.Sprintf("%s-%s at %s, git-tar status: %d", owner, pushEvent.Repository.Name, sdk.FormatShortSHA(pushEvent.AfterCommitID), statusCode))
+}
+
+// pushEventFrom gives the push of a branch, which is always cloned with
+// the owner's deploy key since a git server has no public raw URL for
+// git-tar to find the stack.yml with
+func pushEventFrom(event sdk.GitPushEvent) (sdk.PushEvent, error) {
+	cloneURL, err := url.Parse(event.CloneURL)
+	if err != nil || cloneURL.Scheme != "https" || len(cloneURL.Host) == 0 {
+		return sdk.PushEvent{}, fmt.Errorf("clone_url must be an https:// URL")
+	}
+	if cloneURL.User != nil {
+		return sdk.PushEvent{}, fmt.Errorf("clone_url must not have credentials, they are read from the deploy key")
+	}
+	if !strings.HasPrefix(event.Ref, "refs/heads/") {
+		return sdk.PushEvent{}, fmt.Errorf("ref must be a branch, i.e. refs/heads/master")
+	}
+	if len(event.After) == 0 {
+		return sdk.PushEvent{}, fmt.Errorf("after must be the commit pushed to the branch")
+	}
+
+	owner := strings.ToLower(event.Owner)
+	repo := strings.ToLower(strings.TrimSuffix(event.Repo, ".git"))
+
+	return sdk.PushEvent{
+		SCM: SCM,
+		Ref: event.Ref,
+		Repository: sdk.PushEventRepository{
+			Name:          repo,
+			FullName:      owner + "/" + repo,
+			CloneURL:      cloneURL.String(),
+			Private:       true,
+			RepositoryURL: strings.TrimSuffix(cloneURL.String(), ".git"),
+			Owner: sdk.Owner{
+				Login: owner,
+			},
+		},
+		AfterCommitID:  event.After,
+		BeforeCommitID: event.Before,
+		Forced:         event.Forced,
+		HeadCommit: &sdk.PushEventCommit{
+			ID:      event.After,
+			Message: event.Message,
+		},
+		Sender: sdk.Sender{
+			Login: event.Sender,
+		},
+	}, nil
+}
+
+// checkBranch allows the build branches and the staging branch, any
+// branch is passed on when repo_branches is set so that git-tar can
+// check it against the repository's .ofc.yml
+func checkBranch(ref string) error {
+	branch := sdk.BranchFromRef(ref)
+
+	stagingBranch := strings.TrimSpace(os.Getenv("staging_branch"))
+	if sdk.RepoBranchesEnabled() ||
+		sdk.MatchBranch(branch, sdk.BuildBranches()) ||
+		(len(stagingBranch) > 0 && stagingBranch == branch) {
+		return nil
+	}
+
+	buildBranches := sdk.BuildBranches()
+	if len(buildBranches) == 1 {
+		return fmt.Errorf("skipping build for: %s branch, the build branch is: %s", branch, buildBranches[0])
+	}
+	return fmt.Errorf("skipping build for: %s branch, the build branches are: %s", branch, strings.Join(buildBranches, ", "))
+}
+
+func postEvent(pushEvent sdk.PushEvent) (int, error) {
+	body, err := json.Marshal(pushEvent)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("error while marshalling event: %s", err.Error())
+	}
+
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	gatewayURL := sdk.CreateServiceURL(os.Getenv("gateway_url"), os.Getenv("dns_suffix"))
+
+	req, err := http.NewRequest(http.MethodPost, gatewayURL+"async-function/git-tar", bytes.NewReader(body))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	digest := hmac.Sign(body, []byte(payloadSecret))
+	req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+		return res.StatusCode, fmt.Errorf("unexpected status code from git-tar: %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
@@ -0,0 +1,71 @@
+package function
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// unpackArchive writes the archive uploaded with the CLI to the same path
+// a repository would be cloned to
+func unpackArchive(pushEvent sdk.PushEvent) (string, error) {
+	if len(pushEvent.Repository.Owner.Login) == 0 {
+		return "", fmt.Errorf("login must be specified")
+	}
+	if len(pushEvent.Repository.Name) == 0 {
+		return "", fmt.Errorf("repo name must be specified")
+	}
+	if len(pushEvent.Archive) == 0 {
+		return "", fmt.Errorf("archive is empty")
+	}
+
+	destPath := path.Join(os.TempDir(), path.Join(pushEvent.Repository.Owner.Login, pushEvent.Repository.Name))
+
+	if _, err := os.Stat(destPath); err == nil {
+		if truncateErr := os.RemoveAll(destPath); truncateErr != nil {
+			return "", truncateErr
+		}
+	}
+
+	if err := os.MkdirAll(destPath, 0777); err != nil {
+		return "", fmt.Errorf("cannot create repo-dir: %s", destPath)
+	}
+
+	err := sdk.WalkArchive(pushEvent.Archive, func(name string, header *tar.Header, r io.Reader) error {
+		target := filepath.Join(destPath, filepath.FromSlash(name))
+
+		if header.Typeflag == tar.TypeDir {
+			return os.MkdirAll(target, 0777)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+
+		// Only the executable bit is kept from the archive
+		mode := os.FileMode(0644)
+		if header.FileInfo().Mode()&0111 != 0 {
+			mode = 0755
+		}
+
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(file, r)
+		return err
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("cannot unpack archive: %s", err.Error())
+	}
+
+	return destPath, nil
+}
@@ -0,0 +1,74 @@
+package function
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_unpackArchive(t *testing.T) {
+	buf := bytes.Buffer{}
+	tarWriter := tar.NewWriter(&buf)
+	files := map[string]string{
+		"stack.yml":      "provider:\n  name: openfaas\n",
+		"fn1/handler.go": "package function\n",
+	}
+	for _, name := range []string{"stack.yml", "fn1/handler.go"} {
+		tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg})
+		tarWriter.Write([]byte(files[name]))
+	}
+	tarWriter.Close()
+
+	pushEvent := sdk.PushEvent{
+		SCM: sdk.SCMCLI,
+		Repository: sdk.PushEventRepository{
+			Name:  "fn1-upload",
+			Owner: sdk.Owner{Login: "alexellis"},
+		},
+		Archive: buf.Bytes(),
+	}
+
+	clonePath, err := unpackArchive(pushEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(clonePath)
+
+	for name, want := range files {
+		got, err := ioutil.ReadFile(path.Join(clonePath, name))
+		if err != nil || string(got) != want {
+			t.Errorf("want %s unpacked, got %q, error: %v", name, string(got), err)
+		}
+	}
+}
+
+func Test_unpackArchive_RejectsEscapes(t *testing.T) {
+	buf := bytes.Buffer{}
+	tarWriter := tar.NewWriter(&buf)
+	tarWriter.WriteHeader(&tar.Header{Name: "../../escaped.txt", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	tarWriter.Write([]byte("a"))
+	tarWriter.Close()
+
+	pushEvent := sdk.PushEvent{
+		SCM: sdk.SCMCLI,
+		Repository: sdk.PushEventRepository{
+			Name:  "fn1-escape",
+			Owner: sdk.Owner{Login: "alexellis"},
+		},
+		Archive: buf.Bytes(),
+	}
+
+	clonePath, err := unpackArchive(pushEvent)
+	if err == nil {
+		os.RemoveAll(clonePath)
+		t.Fatalf("want the archive rejected")
+	}
+	if _, statErr := os.Stat(path.Join(os.TempDir(), "escaped.txt")); statErr == nil {
+		t.Errorf("want no file written outside of the repo-dir")
+	}
+}
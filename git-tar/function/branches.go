@@ -0,0 +1,51 @@
+package function
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// readRepoConfig reads the repository's .ofc.yml, a repository without
+// one gives an empty config
+func readRepoConfig(clonePath string) (sdk.RepoConfig, error) {
+	config := sdk.RepoConfig{}
+
+	data, err := ioutil.ReadFile(path.Join(clonePath, sdk.RepoConfigFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return config, err
+	}
+
+	err = yaml.Unmarshal(data, &config)
+	return config, err
+}
+
+// repoBranches gives the branches which are built for the repository,
+// the branches of its .ofc.yml in place of build_branches
+func repoBranches(config sdk.RepoConfig) []string {
+	branches := []string{}
+	for _, branch := range config.Branches {
+		if branch = strings.TrimSpace(branch); len(branch) > 0 {
+			branches = append(branches, branch)
+		}
+	}
+
+	if len(branches) > 0 {
+		return branches
+	}
+	return sdk.BuildBranches()
+}
+
+// isStagingBranch is true for the staging_branch, which is built
+// whatever the repository's branches are
+func isStagingBranch(branch string) bool {
+	stagingBranch := strings.TrimSpace(os.Getenv("staging_branch"))
+	return len(stagingBranch) > 0 && stagingBranch == branch
+}
@@ -0,0 +1,48 @@
+package function
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_readRepoConfig(t *testing.T) {
+	clonePath, err := ioutil.TempDir("", "ofc-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(clonePath)
+
+	config, err := readRepoConfig(clonePath)
+	if err != nil || len(config.Branches) != 0 {
+		t.Errorf("want an empty config without .ofc.yml, got %v, %v", config, err)
+	}
+
+	ioutil.WriteFile(path.Join(clonePath, ".ofc.yml"), []byte("branches:\n  - main\n  - release/*\n"), 0600)
+
+	config, err = readRepoConfig(clonePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Branches, []string{"main", "release/*"}) {
+		t.Errorf("want main and release/*, got %v", config.Branches)
+	}
+}
+
+func Test_repoBranches(t *testing.T) {
+	os.Setenv("build_branch", "master")
+	defer os.Unsetenv("build_branch")
+
+	if got := repoBranches(sdk.RepoConfig{}); !reflect.DeepEqual(got, []string{"master"}) {
+		t.Errorf("want build_branch without .ofc.yml branches, got %v", got)
+	}
+
+	got := repoBranches(sdk.RepoConfig{Branches: []string{"develop", " "}})
+	if !reflect.DeepEqual(got, []string{"develop"}) {
+		t.Errorf("want the repository's branches, got %v", got)
+	}
+}
@@ -0,0 +1,262 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/faas-cli/stack"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// deployHistoryKey is the key buildshiprun keeps the deploy history of
+// each function under in pipeline-log, in place of a commit SHA
+const deployHistoryKey = "deploy-history"
+
+// deployHistory is the part of buildshiprun's deploy history which gives
+// the commit a function is deployed from, oldest first
+type deployHistory struct {
+	Deployments []struct {
+		SHA    string `json:"sha"`
+		Branch string `json:"branch"`
+	} `json:"deployments"`
+}
+
+// stackPaths are read for every function, so a change to one of them
+// rebuilds the whole stack: the stack file, the templates and the paths
+// which are copied into each function's build context
+func stackPaths(services *stack.Services) []string {
+	paths := []string{stackFileName, "template"}
+	for _, copyPath := range services.StackConfiguration.CopyExtraPaths {
+		paths = append(paths, repoPath(copyPath))
+	}
+	return paths
+}
+
+// repoPath gives a path of stack.yml relative to the root of the repo, in
+// the form git lists changed files
+func repoPath(file string) string {
+	return strings.TrimSuffix(filepath.ToSlash(filepath.Clean(file)), "/")
+}
+
+// changedUnder is true when the file is, or is within, the path. The
+// root of the repository has every file within it.
+func changedUnder(file, dir string) bool {
+	return dir == "." || file == dir || strings.HasPrefix(file, dir+"/")
+}
+
+// buildChangedOnly reads build_changed_only, which builds only the
+// functions whose handler changed since they were deployed
+func buildChangedOnly() bool {
+	ok, _ := strconv.ParseBool(os.Getenv("build_changed_only"))
+	return ok
+}
+
+// compareDeployed is true when the push can be compared with the
+// commits its functions are deployed from, every function is built for
+// a tag, a preview or an upload
+func compareDeployed(pushEvent sdk.PushEvent) bool {
+	if len(sdk.BranchFromRef(pushEvent.Ref)) == 0 {
+		return false
+	}
+
+	return pushEvent.PullRequest == 0 && pushEvent.SCM != sdk.SCMCLI
+}
+
+// deployedNames gives the names a function of the push's branch may be
+// deployed as, the staging branch has a suffix unless it has a deploy
+// target of its own
+func deployedNames(pushEvent sdk.PushEvent, service string) []string {
+	name := sdk.FunctionName(pushEvent.Repository.Owner.Login, service)
+	if isStagingBranch(sdk.BranchFromRef(pushEvent.Ref)) {
+		return []string{name + sdk.StagingSuffix, name}
+	}
+	return []string{name}
+}
+
+// deployedCommit reads the deploy history buildshiprun keeps for the
+// function to find the commit it was last deployed from for the push's
+// branch, or an empty string when it has not been deployed
+func deployedCommit(gatewayURL string, pushEvent sdk.PushEvent, service string) string {
+	branch := sdk.BranchFromRef(pushEvent.Ref)
+	repoPath := pushEvent.Repository.Owner.Login + "/" + pushEvent.Repository.Name
+
+	for _, name := range deployedNames(pushEvent, service) {
+		body, err := sdk.ReadPipelineLog(gatewayURL, sdk.PipelineLog{
+			RepoPath:  repoPath,
+			CommitSHA: deployHistoryKey,
+			Function:  name,
+			Source:    sdk.DeployHistorySource,
+		})
+		if err != nil {
+			log.Printf("unable to read the deploy history of %s: %s", name, err.Error())
+			continue
+		}
+
+		history := deployHistory{}
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &history); err != nil {
+				log.Printf("unable to parse the deploy history of %s: %s", name, err.Error())
+				continue
+			}
+		}
+
+		for i := len(history.Deployments) - 1; i >= 0; i-- {
+			if deployed := history.Deployments[i]; deployed.Branch == branch && len(deployed.SHA) > 0 {
+				return deployed.SHA
+			}
+		}
+	}
+
+	return ""
+}
+
+// deployedCommits gives the commit each function of the stack at
+// clonePath is deployed from, a function which is missing is built
+func deployedCommits(gatewayURL string, pushEvent sdk.PushEvent, clonePath string) map[string]string {
+	deployed := map[string]string{}
+
+	services, err := parseYAML(clonePath)
+	if err != nil {
+		log.Printf("unable to read %s to find the deployed functions: %s", stackFileName, err.Error())
+		return deployed
+	}
+
+	for name := range services.Functions {
+		if sha := deployedCommit(gatewayURL, pushEvent, name); len(sha) > 0 {
+			deployed[name] = sha
+		}
+	}
+	return deployed
+}
+
+// fetchDeployed fetches the commits the functions are deployed from into
+// the clone, a function whose commit can't be fetched is built
+func fetchDeployed(fetcher RepoFetcher, deployed map[string]string, clonePath string) {
+	fetched := map[string]error{}
+	for name, sha := range deployed {
+		err, ok := fetched[sha]
+		if !ok {
+			err = fetcher.Fetch(sha, clonePath)
+			fetched[sha] = err
+			if err != nil {
+				log.Printf("unable to fetch %s to find the changed functions: %s", sdk.FormatShortSHA(sha), err.Error())
+			}
+		}
+		if err != nil {
+			delete(deployed, name)
+		}
+	}
+}
+
+// affectedFunctions gives the functions whose handler folder or
+// environment_file has one of the changed files, all of them when one of
+// the stackPaths changed. A pre-built image is only affected by a change
+// to its environment_file or to the stack.
+func affectedFunctions(changed []string, services *stack.Services) map[string]bool {
+	affected := map[string]bool{}
+
+	for _, file := range changed {
+		for _, stackPath := range stackPaths(services) {
+			if changedUnder(file, stackPath) {
+				for name := range services.Functions {
+					affected[name] = true
+				}
+				return affected
+			}
+		}
+	}
+
+	for name, function := range services.Functions {
+		paths := []string{}
+		for _, envFile := range function.EnvironmentFile {
+			paths = append(paths, repoPath(envFile))
+		}
+		if !function.SkipBuild {
+			paths = append(paths, repoPath(function.Handler))
+		}
+
+		for _, file := range changed {
+			for _, functionPath := range paths {
+				if changedUnder(file, functionPath) {
+					affected[name] = true
+				}
+			}
+		}
+	}
+
+	return affected
+}
+
+// changedFunctions selects the functions to build for the push with
+// build_changed_only, comparing each function with the commit it is
+// deployed from. The others are left as they are deployed and returned
+// with that commit. A function which has not been deployed, or whose
+// changes can't be listed, is built.
+func changedFunctions(fetcher RepoFetcher, clonePath string, services *stack.Services, deployed map[string]string) (*stack.Services, map[string]string) {
+	unchanged := map[string]string{}
+	if !buildChangedOnly() || len(deployed) == 0 {
+		return services, unchanged
+	}
+
+	bySHA := map[string]*stack.Services{}
+	for name, function := range services.Functions {
+		sha, ok := deployed[name]
+		if !ok {
+			continue
+		}
+		if _, ok := bySHA[sha]; !ok {
+			group := *services
+			group.Functions = map[string]stack.Function{}
+			bySHA[sha] = &group
+		}
+		bySHA[sha].Functions[name] = function
+	}
+
+	for sha, group := range bySHA {
+		changed, err := fetcher.ChangedFiles(sha, clonePath)
+		if err != nil {
+			log.Printf("unable to list the files changed since %s, building its functions: %s", sdk.FormatShortSHA(sha), err.Error())
+			continue
+		}
+
+		affected := affectedFunctions(changed, group)
+		for name := range group.Functions {
+			if !affected[name] {
+				unchanged[name] = sha
+			}
+		}
+	}
+
+	selected := *services
+	selected.Functions = map[string]stack.Function{}
+	for name, function := range services.Functions {
+		if _, ok := unchanged[name]; !ok {
+			selected.Functions[name] = function
+		}
+	}
+
+	log.Printf("building %d of %d functions, the others are unchanged since they were deployed", len(selected.Functions), len(services.Functions))
+
+	return &selected, unchanged
+}
+
+// reportUnchanged passes the status of each function which was not
+// rebuilt, so that required status checks are not left pending
+func reportUnchanged(status *sdk.Status, unchanged map[string]string) {
+	names := []string{}
+	for name := range unchanged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		msg := fmt.Sprintf("%s is unchanged since %s was deployed, not rebuilt", name, sdk.FormatShortSHA(unchanged[name]))
+		status.AddStatus(sdk.StatusSuccess, msg, sdk.BuildFunctionContext(name))
+	}
+}
@@ -0,0 +1,213 @@
+package function
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/openfaas/faas-cli/stack"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_affectedFunctions(t *testing.T) {
+	services := &stack.Services{
+		Functions: map[string]stack.Function{
+			"fn1":      {Handler: "./fn1", EnvironmentFile: []string{"env/fn1.yml"}},
+			"fn10":     {Handler: "./fn10"},
+			"api":      {Handler: "services/api/"},
+			"prebuilt": {Image: "alexellis/figlet", SkipBuild: true, EnvironmentFile: []string{"./env/figlet.yml"}},
+		},
+		StackConfiguration: stack.StackConfiguration{CopyExtraPaths: []string{"./common/"}},
+	}
+
+	tests := []struct {
+		Scenario string
+		Changed  []string
+		Want     []string
+	}{
+		{"handler file", []string{"fn1/handler.go"}, []string{"fn1"}},
+		{"prefix of another handler", []string{"fn10/handler.go"}, []string{"fn10"}},
+		{"nested handler", []string{"services/api/index.js", "README.md"}, []string{"api"}},
+		{"outside of any handler", []string{"README.md", "docs/fn1.md"}, []string{}},
+		{"stack file", []string{"stack.yml"}, []string{"api", "fn1", "fn10", "prebuilt"}},
+		{"environment file", []string{"env/fn1.yml"}, []string{"fn1"}},
+		{"environment file of a pre-built image", []string{"env/figlet.yml"}, []string{"prebuilt"}},
+		{"copied path", []string{"common/db.go"}, []string{"api", "fn1", "fn10", "prebuilt"}},
+		{"prefix of a copied path", []string{"common-docs/README.md"}, []string{}},
+		{"template", []string{"template/go/Dockerfile"}, []string{"api", "fn1", "fn10", "prebuilt"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			got := []string{}
+			for name := range affectedFunctions(test.Changed, services) {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, test.Want) {
+				t.Errorf("want %v, got %v", test.Want, got)
+			}
+		})
+	}
+}
+
+func Test_affectedFunctions_RootHandler(t *testing.T) {
+	services := &stack.Services{Functions: map[string]stack.Function{
+		"site": {Handler: "./"},
+		"fn1":  {Handler: "./fn1"},
+	}}
+
+	got := affectedFunctions([]string{"index.html"}, services)
+	if !got["site"] || got["fn1"] {
+		t.Errorf("want only the function at the root rebuilt, got %v", got)
+	}
+}
+
+func Test_compareDeployed(t *testing.T) {
+	push := sdk.PushEvent{Ref: "refs/heads/master", BeforeCommitID: "9e6d8e2", AfterCommitID: "a44c3c4"}
+
+	newBranch := push
+	newBranch.BeforeCommitID = "0000000000000000000000000000000000000000"
+	forced := push
+	forced.Forced = true
+	tag := push
+	tag.Ref = "refs/tags/v1.0.0"
+	preview := push
+	preview.PullRequest = 12
+	upload := push
+	upload.SCM = sdk.SCMCLI
+
+	tests := []struct {
+		Scenario string
+		Push     sdk.PushEvent
+		Want     bool
+	}{
+		{"push to a branch", push, true},
+		{"new branch", newBranch, true},
+		{"forced push", forced, true},
+		{"tag", tag, false},
+		{"preview", preview, false},
+		{"upload", upload, false},
+	}
+
+	for _, test := range tests {
+		if got := compareDeployed(test.Push); got != test.Want {
+			t.Errorf("%s: want %v, got %v", test.Scenario, test.Want, got)
+		}
+	}
+}
+
+func Test_deployedCommit(t *testing.T) {
+	histories := map[string]string{
+		"alexellis-fn1":         `{"deployments":[{"sha":"5d1e7c3","branch":"master"},{"sha":"9e6d8e2","branch":"master"},{"sha":"c0ffee1","branch":"dev"}]}`,
+		"alexellis-fn2-staging": `{"deployments":[{"sha":"b4d2e11","branch":"staging"}]}`,
+		"alexellis-fn3":         `{"deployments":[{"sha":"e3c11a0"}]}`,
+	}
+	var query url.Values
+	pipelineLog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(histories[query.Get("function")]))
+	}))
+	defer pipelineLog.Close()
+
+	os.Setenv("staging_branch", "staging")
+	defer os.Unsetenv("staging_branch")
+
+	push := sdk.PushEvent{
+		Ref:        "refs/heads/master",
+		Repository: sdk.PushEventRepository{Owner: sdk.Owner{Login: "alexellis"}, Name: "fns"},
+	}
+	staging := push
+	staging.Ref = "refs/heads/staging"
+
+	tests := []struct {
+		Scenario string
+		Push     sdk.PushEvent
+		Function string
+		Want     string
+	}{
+		{"latest deployment of the branch", push, "fn1", "9e6d8e2"},
+		{"staging function", staging, "fn2", "b4d2e11"},
+		{"deployed before the branch was recorded", push, "fn3", ""},
+		{"never deployed", push, "fn4", ""},
+	}
+
+	for _, test := range tests {
+		if got := deployedCommit(pipelineLog.URL+"/", test.Push, test.Function); got != test.Want {
+			t.Errorf("%s: want %q, got %q", test.Scenario, test.Want, got)
+		}
+	}
+
+	if query.Get("repoPath") != "alexellis/fns" || query.Get("source") != sdk.DeployHistorySource || query.Get("commitSHA") != deployHistoryKey {
+		t.Errorf("want the deploy history of the repo read, got %v", query)
+	}
+}
+
+// changedFetcher lists the changed files for each commit
+type changedFetcher struct {
+	FakeFetcher
+	changed map[string][]string
+	err     error
+}
+
+func (c changedFetcher) ChangedFiles(from, path string) ([]string, error) {
+	return c.changed[from], c.err
+}
+
+func Test_changedFunctions(t *testing.T) {
+	services := &stack.Services{Functions: map[string]stack.Function{
+		"fn1": {Handler: "./fn1"},
+		"fn2": {Handler: "./fn2"},
+		"fn3": {Handler: "./fn3"},
+		"fn4": {Handler: "./fn4"},
+	}}
+	deployed := map[string]string{"fn1": "9e6d8e2", "fn2": "9e6d8e2", "fn3": "5d1e7c3"}
+	fetcher := changedFetcher{changed: map[string][]string{
+		"9e6d8e2": {"fn2/handler.go"},
+		"5d1e7c3": {"fn2/handler.go", "README.md"},
+	}}
+
+	if got, unchanged := changedFunctions(fetcher, "", services, deployed); len(got.Functions) != 4 || len(unchanged) != 0 {
+		t.Errorf("want every function built by default, got %v", got.Functions)
+	}
+
+	os.Setenv("build_changed_only", "true")
+	defer os.Unsetenv("build_changed_only")
+
+	got, unchanged := changedFunctions(fetcher, "", services, deployed)
+	built := []string{}
+	for name := range got.Functions {
+		built = append(built, name)
+	}
+	sort.Strings(built)
+	if !reflect.DeepEqual(built, []string{"fn2", "fn4"}) {
+		t.Errorf("want fn2 changed and fn4 never deployed built, got %v", built)
+	}
+	if want := map[string]string{"fn1": "9e6d8e2", "fn3": "5d1e7c3"}; !reflect.DeepEqual(unchanged, want) {
+		t.Errorf("want %v unchanged, got %v", want, unchanged)
+	}
+	if len(services.Functions) != 4 {
+		t.Errorf("want the stack left whole for garbage-collect, got %v", services.Functions)
+	}
+
+	failing := changedFetcher{err: fmt.Errorf("bad object")}
+	if got, _ := changedFunctions(failing, "", services, deployed); len(got.Functions) != 4 {
+		t.Errorf("want every function built when the changes can't be listed, got %v", got.Functions)
+	}
+}
+
+func Test_reportUnchanged(t *testing.T) {
+	status := sdk.BuildStatus(&sdk.Event{}, sdk.EmptyAuthToken)
+	reportUnchanged(status, map[string]string{"fn1": "9e6d8e2a8a"})
+
+	got := status.CommitStatuses[sdk.BuildFunctionContext("fn1")]
+	if got.Description != "fn1 is unchanged since 9e6d8e2 was deployed, not rebuilt" {
+		t.Errorf("want the deployed commit cited, got %q", got.Description)
+	}
+}
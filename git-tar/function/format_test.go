@@ -34,7 +34,7 @@ func Test_FormatImageShaTag_PrivateRepo_WithTag_NoStackPrefix(t *testing.T) {
 	sha := "04b8e44988"
 	os.Setenv("build_branch", "master")
 
-	name := formatImageShaTag("registry:5000", function, sha, owner, repo)
+	name := formatImageShaTag("registry:5000", function, sha, owner, repo, buildBranch())
 
 	want := "registry:5000/" + owner + "/" + repo + "-func:0.2-master-04b8e44"
 	if name != want {
@@ -52,7 +52,7 @@ func Test_FormatImageShaTag_PrivateRepo_WithTag(t *testing.T) {
 	sha := "04b8e44988"
 	os.Setenv("build_branch", "master")
 
-	name := formatImageShaTag("registry:5000", function, sha, owner, repo)
+	name := formatImageShaTag("registry:5000", function, sha, owner, repo, buildBranch())
 
 	want := "registry:5000/" + owner + "/" + repo + "-func:0.2-master-04b8e44"
 	if name != want {
@@ -70,7 +70,7 @@ func Test_FormatImageShaTag_PrivateRepo_NoTag(t *testing.T) {
 	sha := "04b8e44988"
 	os.Setenv("build_branch", "master")
 
-	name := formatImageShaTag("registry:5000", function, sha, owner, repo)
+	name := formatImageShaTag("registry:5000", function, sha, owner, repo, buildBranch())
 
 	want := "registry:5000/" + owner + "/" + repo + "-func:latest-master-04b8e44"
 	if name != want {
@@ -87,10 +87,55 @@ func Test_FormatImageShaTag_SharedRepo_NoTag(t *testing.T) {
 	repo := "go-fns-tester"
 	sha := "04b8e44988"
 	os.Setenv("build_branch", "master")
-	name := formatImageShaTag("docker.io/of-community/", function, sha, owner, repo)
+	name := formatImageShaTag("docker.io/of-community/", function, sha, owner, repo, buildBranch())
 
 	want := "docker.io/of-community/" + owner + "-" + repo + "-func:latest-master-04b8e44"
 	if name != want {
 		t.Errorf("Want \"%s\", got \"%s\"", want, name)
 	}
 }
+
+func Test_FormatImageShaTag_OwnerOrg(t *testing.T) {
+	function := &stack.Function{
+		Image: "alexellis2/func:0.2",
+	}
+
+	os.Setenv("registry_orgs", "alexellis=customer-x")
+	defer os.Unsetenv("registry_orgs")
+
+	name := formatImageShaTag("docker.io/of-community/", function, "04b8e44988", "alexellis", "go-fns-tester", "master")
+
+	want := "docker.io/of-community/customer-x/go-fns-tester-func:0.2-master-04b8e44"
+	if name != want {
+		t.Errorf("Want \"%s\", got \"%s\"", want, name)
+	}
+}
+
+func Test_FormatImageShaTag_OwnerRegistry(t *testing.T) {
+	function := &stack.Function{
+		Image: "alexellis2/func:0.2",
+	}
+
+	os.Setenv("owner_registries", "alexellis=ghcr.io/alexellis")
+	defer os.Unsetenv("owner_registries")
+
+	name := formatImageShaTag("docker.io/of-community/", function, "04b8e44988", "alexellis", "go-fns-tester", "master")
+
+	want := "ghcr.io/alexellis/go-fns-tester-func:0.2-master-04b8e44"
+	if name != want {
+		t.Errorf("Want \"%s\", got \"%s\"", want, name)
+	}
+}
+
+func Test_FormatImageGitTag(t *testing.T) {
+	function := &stack.Function{
+		Image: "alexellis2/func:0.2",
+	}
+
+	name := formatImageGitTag("docker.io/of-community/", function, "release/1.2.0", "alexellis", "go-fns-tester")
+
+	want := "docker.io/of-community/alexellis-go-fns-tester-func:release-1.2.0"
+	if name != want {
+		t.Errorf("Want \"%s\", got \"%s\"", want, name)
+	}
+}
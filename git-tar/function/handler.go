@@ -22,9 +22,10 @@ import (
 
 // Source of this event for auditing
 const (
-	Source = "git-tar"
-	GitLab = "gitlab"
-	GitHub = "github"
+	Source    = "git-tar"
+	GitLab    = "gitlab"
+	GitHub    = "github"
+	Bitbucket = "bitbucket"
 )
 
 // Handle clones the git repo and checks out the SHA then uses the
@@ -88,14 +89,21 @@ func Handle(req []byte) []byte {
 			Owner:   pushEvent.Repository.Owner.Login,
 			Repo:    pushEvent.Repository.Name,
 			Source:  Source,
-		}
+		}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery)
 		sdk.PostAudit(auditEvent)
 
 		os.Exit(-1)
 	}
 
-	fetcher := GitRepoFetcher{}
-	clonePath, err := clone(fetcher, pushEvent)
+	fetcher := newGitRepoFetcher()
+
+	var clonePath string
+	var deployed map[string]string
+	if pushEvent.SCM == sdk.SCMCLI {
+		clonePath, err = unpackArchive(pushEvent)
+	} else {
+		clonePath, deployed, err = clone(fetcher, pushEvent)
+	}
 	if err != nil {
 		msg := fmt.Sprintf("error cloning repo: %s ", err.Error())
 		log.Println(msg)
@@ -108,6 +116,55 @@ func Handle(req []byte) []byte {
 		os.Exit(-1)
 	}
 
+	// A release gives its tag but not the tag's commit, which is needed
+	// for the commit status and image
+	if len(pushEvent.AfterCommitID) == 0 {
+		sha, resolveErr := resolveCommit(clonePath)
+		if resolveErr != nil {
+			log.Printf("unable to resolve the commit of %s: %s", pushEvent.Ref, resolveErr.Error())
+			os.Exit(-1)
+		}
+
+		pushEvent.AfterCommitID = sha
+		statusEvent = sdk.BuildEventFromPushEvent(pushEvent)
+		status = sdk.BuildStatus(statusEvent, sdk.EmptyAuthToken)
+	}
+
+	// Pushes to any branch are passed on with repo_branches, so that the
+	// repository's .ofc.yml can pick which are built
+	if branch := sdk.BranchFromRef(pushEvent.Ref); len(branch) > 0 && sdk.RepoBranchesEnabled() {
+		repoConfig, configErr := readRepoConfig(clonePath)
+		if configErr != nil {
+			msg := fmt.Sprintf("cannot parse %s: %s", sdk.RepoConfigFile, configErr.Error())
+			log.Println(msg)
+			status.AddStatus(sdk.StatusFailure, msg, sdk.StackContext)
+			statusErr := reportStatus(status, pushEvent.SCM)
+			if statusErr != nil {
+				log.Printf(statusErr.Error())
+			}
+			os.Exit(-1)
+		}
+
+		if branches := repoBranches(repoConfig); !sdk.MatchBranch(branch, branches) && !isStagingBranch(branch) {
+			msg := fmt.Sprintf("skipping build for: %s branch, the build branches are: %s", branch, strings.Join(branches, ", "))
+			log.Println(msg)
+
+			sdk.PostAudit(sdk.AuditEvent{
+				Message: msg,
+				Owner:   pushEvent.Repository.Owner.Login,
+				Repo:    pushEvent.Repository.Name,
+				Source:  Source,
+			}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery))
+
+			status.AddStatus(sdk.StatusSuccess, msg, sdk.StackContext)
+			statusErr := reportStatus(status, pushEvent.SCM)
+			if statusErr != nil {
+				log.Printf(statusErr.Error())
+			}
+			os.Exit(0)
+		}
+	}
+
 	if _, err := os.Stat(path.Join(clonePath, "template")); err == nil {
 		msg := `unsupported custom "templates" folder`
 		log.Println(msg)
@@ -119,6 +176,14 @@ func Handle(req []byte) []byte {
 		os.Exit(-1)
 	}
 
+	errs, warnings := validateStack(clonePath, pushEvent.Repository.Owner.Login)
+	if len(errs) > 0 {
+		failInvalidStack(status, pushEvent, errs)
+	}
+	for _, warning := range warnings {
+		log.Printf("warning: %s", warning.Error())
+	}
+
 	stack, err := parseYAML(clonePath)
 	if err != nil {
 		log.Println("parseYAML ", err.Error())
@@ -139,7 +204,7 @@ func Handle(req []byte) []byte {
 		os.Exit(1)
 	}
 
-	err = fetchTemplates(clonePath)
+	err = fetchTemplates(fetcher, clonePath)
 	if err != nil {
 		msg := fmt.Sprintf("error fetching templates: %s", err.Error())
 		log.Println(msg)
@@ -152,9 +217,9 @@ func Handle(req []byte) []byte {
 		os.Exit(-1)
 	}
 
-	err = checkCompatibleTemplates(stack, clonePath)
+	errs, err = fetchStackTemplates(fetcher, clonePath, stack)
 	if err != nil {
-		msg := fmt.Sprintf("missing language template: %s", err.Error())
+		msg := fmt.Sprintf("error fetching templates: %s", err.Error())
 		log.Println(msg)
 
 		status.AddStatus(sdk.StatusFailure, msg, sdk.StackContext)
@@ -164,6 +229,27 @@ func Handle(req []byte) []byte {
 		}
 		os.Exit(-1)
 	}
+	if len(errs) > 0 {
+		failInvalidStack(status, pushEvent, errs)
+	}
+
+	if hasStaticFunction(stack.Functions) {
+		if err = writeStaticTemplate(clonePath); err != nil {
+			msg := fmt.Sprintf("error writing static template: %s", err.Error())
+			log.Println(msg)
+
+			status.AddStatus(sdk.StatusFailure, msg, sdk.StackContext)
+			statusErr := reportStatus(status, pushEvent.SCM)
+			if statusErr != nil {
+				log.Printf(statusErr.Error())
+			}
+			os.Exit(-1)
+		}
+	}
+
+	if errs := validateTemplates(clonePath, stack); len(errs) > 0 {
+		failInvalidStack(status, pushEvent, errs)
+	}
 
 	var shrinkWrapPath string
 	shrinkWrapPath, err = shrinkwrap(clonePath)
@@ -179,8 +265,15 @@ func Handle(req []byte) []byte {
 		os.Exit(-1)
 	}
 
+	// With build_changed_only, functions whose handler did not change
+	// since they were deployed keep the image they are deployed with
+	buildStack, unchanged := changedFunctions(fetcher, clonePath, stack, deployed)
+	if len(unchanged) > 0 {
+		reportUnchanged(status, unchanged)
+	}
+
 	var tars []tarEntry
-	tars, err = makeTar(pushEvent, shrinkWrapPath, stack)
+	tars, err = makeTar(pushEvent, shrinkWrapPath, buildStack)
 	if err != nil {
 		msg := fmt.Sprintf("cannot create tar(s): %s", err.Error())
 		log.Println(msg)
@@ -221,15 +314,23 @@ func Handle(req []byte) []byte {
 		os.Exit(-1)
 	}
 
-	status.AddStatus(sdk.StatusSuccess, "stack is successfully deployed", sdk.StackContext)
+	deployedMsg := "stack is successfully deployed"
+	if len(warnings) > 0 {
+		deployedMsg = fmt.Sprintf("%s, warning: %s", deployedMsg, summariseStackErrors(warnings))
+	}
+	status.AddStatus(sdk.StatusSuccess, deployedMsg, sdk.StackContext)
 	statusErr := reportStatus(status, pushEvent.SCM)
 	if statusErr != nil {
 		log.Printf(statusErr.Error())
 	}
 
-	err = garbageCollect(pushEvent, stack)
-	if err != nil {
-		log.Printf("garbage-collect error: %s", err)
+	// A tag deploys a release of the stack, functions removed from it
+	// are collected by the next push to the build branch
+	if len(sdk.TagFromRef(pushEvent.Ref)) == 0 {
+		err = garbageCollect(pushEvent, stack)
+		if err != nil {
+			log.Printf("garbage-collect error: %s", err)
+		}
 	}
 
 	completed := time.Since(start)
@@ -239,6 +340,10 @@ func Handle(req []byte) []byte {
 		tarMsg += fmt.Sprintf("%s @ %s, ", tar.functionName, tar.imageName)
 	}
 
+	if len(tars) == 0 {
+		tarMsg = "no changed functions"
+	}
+
 	deploymentMessage := fmt.Sprintf("Deployed: %s, time taken: %.2fs", strings.TrimRight(tarMsg, ", "), completed.Seconds())
 
 	auditEvent := sdk.AuditEvent{
@@ -246,20 +351,46 @@ func Handle(req []byte) []byte {
 		Owner:   pushEvent.Repository.Owner.Login,
 		Repo:    pushEvent.Repository.Name,
 		Source:  Source,
-	}
+	}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery)
 	sdk.PostAudit(auditEvent)
 
 	return []byte(deploymentMessage + "\n")
 }
 
+// failInvalidStack reports the first of the errors found in stack.yml in
+// the commit status, and all of them in the log and audit trail
+func failInvalidStack(status *sdk.Status, pushEvent sdk.PushEvent, errs []stackError) {
+	for _, stackErr := range errs {
+		log.Println(stackErr.Error())
+
+		auditEvent := sdk.AuditEvent{
+			Message: stackErr.Error(),
+			Owner:   pushEvent.Repository.Owner.Login,
+			Repo:    pushEvent.Repository.Name,
+			Source:  Source,
+		}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery)
+		sdk.PostAudit(auditEvent)
+	}
+
+	status.AddStatus(sdk.StatusFailure, summariseStackErrors(errs), sdk.StackContext)
+	statusErr := reportStatus(status, pushEvent.SCM)
+	if statusErr != nil {
+		log.Printf(statusErr.Error())
+	}
+
+	os.Exit(-1)
+}
+
 func garbageCollect(pushEvent sdk.PushEvent, stack *stack.Services) error {
 	var err error
 
 	gatewayURL := os.Getenv("gateway_url")
 
 	garbageReq := GarbageRequest{
-		Owner: pushEvent.Repository.Owner.Login,
-		Repo:  pushEvent.Repository.Name,
+		Owner:       pushEvent.Repository.Owner.Login,
+		Repo:        pushEvent.Repository.Name,
+		Branch:      pushBranch(pushEvent),
+		PullRequest: pushEvent.PullRequest,
 	}
 
 	for k := range stack.Functions {
@@ -281,7 +412,7 @@ func garbageCollect(pushEvent sdk.PushEvent, stack *stack.Services) error {
 
 	request.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
 
-	response, err := http.DefaultClient.Do(request)
+	response, err := sdk.HTTPClient().Do(request)
 
 	if err == nil {
 		if response.Body != nil {
@@ -298,9 +429,11 @@ func garbageCollect(pushEvent sdk.PushEvent, stack *stack.Services) error {
 }
 
 type GarbageRequest struct {
-	Functions []string `json:"functions"`
-	Repo      string   `json:"repo"`
-	Owner     string   `json:"owner"`
+	Functions   []string `json:"functions"`
+	Repo        string   `json:"repo"`
+	Owner       string   `json:"owner"`
+	Branch      string   `json:"branch,omitempty"`
+	PullRequest int      `json:"pullRequest,omitempty"`
 }
 
 func enableStatusReporting() bool {
@@ -330,7 +463,12 @@ func findStackFile(pushEvent *sdk.PushEvent) (bool, error) {
 		return true, nil
 	}
 
-	addr, err := getRawURL(pushEvent.SCM, pushEvent.Repository.RepositoryURL, pushEvent.Repository.Owner.Login, pushEvent.Repository.Name)
+	// An upload has no raw URL, edge-auth checked it has a stack.yml
+	if pushEvent.SCM == sdk.SCMCLI {
+		return true, nil
+	}
+
+	addr, err := getRawURL(pushEvent.SCM, pushEvent.Repository.RepositoryURL, pushEvent.Repository.Owner.Login, pushEvent.Repository.Name, sourceRef(*pushEvent))
 	if err != nil {
 		return false, err
 	}
@@ -338,7 +476,7 @@ func findStackFile(pushEvent *sdk.PushEvent) (bool, error) {
 	req, _ := http.NewRequest(http.MethodHead, addr, nil)
 	log.Printf("Stack file request: %s", addr)
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := sdk.HTTPClient().Do(req)
 
 	if err != nil {
 		log.Printf("error finding stack %s", err.Error())
@@ -358,18 +496,18 @@ func findStackFile(pushEvent *sdk.PushEvent) (bool, error) {
 	return false, nil
 }
 
-func getRawURL(scm string, repositoryURL string, repositoryOwnerLogin string, repositoryName string) (string, error) {
+func getRawURL(scm string, repositoryURL string, repositoryOwnerLogin string, repositoryName string, branch string) (string, error) {
 
 	rawURL := ""
 	switch scm {
 	case GitHub:
-		rawURL = fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/stack.yml", repositoryOwnerLogin, repositoryName, buildBranch())
-	case GitLab:
-		rawURL = fmt.Sprintf("%s/raw/%s/stack.yml", repositoryURL, buildBranch())
+		rawURL = fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/stack.yml", repositoryOwnerLogin, repositoryName, branch)
+	case GitLab, Bitbucket:
+		rawURL = fmt.Sprintf("%s/raw/%s/stack.yml", repositoryURL, branch)
 	}
 	if rawURL == "" {
 		return "", fmt.Errorf(`failed to find stack.yml file: cannot form proper raw URL.
-			Expected pushEvent.SCM to be "github" or "gitlab", but got %s`, scm)
+			Expected pushEvent.SCM to be "github", "gitlab" or "bitbucket", but got %s`, scm)
 	}
 
 	return rawURL, nil
@@ -382,7 +520,7 @@ func isDockerfileEnabled() (ok bool) {
 
 func hasDockerfileFunction(functions map[string]stack.Function) bool {
 	for _, function := range functions {
-		if strings.ToLower(function.Language) == "dockerfile" {
+		if !function.SkipBuild && strings.ToLower(function.Language) == "dockerfile" {
 			return true
 		}
 	}
@@ -396,3 +534,30 @@ func buildBranch() string {
 	}
 	return branch
 }
+
+// pushBranch is the branch which was pushed to, either the build_branch
+// or the staging_branch, falling back to the build_branch for events
+// without a branch ref
+func pushBranch(pushEvent sdk.PushEvent) string {
+	if strings.HasPrefix(pushEvent.Ref, "refs/heads/") {
+		return strings.TrimPrefix(pushEvent.Ref, "refs/heads/")
+	}
+	return buildBranch()
+}
+
+// sourceRef is the tag or branch which was pushed, to read stack.yml from
+func sourceRef(pushEvent sdk.PushEvent) string {
+	if tag := sdk.TagFromRef(pushEvent.Ref); len(tag) > 0 {
+		return tag
+	}
+	return pushBranch(pushEvent)
+}
+
+// checkoutRef is the commit to build, or the tag of a release whose
+// commit is not known until it is checked out
+func checkoutRef(pushEvent sdk.PushEvent) string {
+	if len(pushEvent.AfterCommitID) == 0 {
+		return sdk.TagFromRef(pushEvent.Ref)
+	}
+	return pushEvent.AfterCommitID
+}
@@ -1,9 +1,11 @@
 package function
 
 import (
+	"os"
 	"testing"
 
 	"github.com/openfaas/faas-cli/stack"
+	"github.com/openfaas/openfaas-cloud/sdk"
 )
 
 func Test_getRawURL(t *testing.T) {
@@ -43,10 +45,17 @@ func Test_getRawURL(t *testing.T) {
 			SCM:                  "gitlab",
 			Expected:             "https://gitlab.url.io/myuser/myrepo/raw/master/stack.yml",
 		},
+		{
+			RepositoryURL:        "https://bitbucket.org/myuser/myrepo",
+			RepositoryOwnerLogin: "myuser",
+			RepositoryName:       "myrepo",
+			SCM:                  "bitbucket",
+			Expected:             "https://bitbucket.org/myuser/myrepo/raw/master/stack.yml",
+		},
 	}
 
 	for _, pushEvent := range pushEvents {
-		addr, _ := getRawURL(pushEvent.SCM, pushEvent.RepositoryURL, pushEvent.RepositoryOwnerLogin, pushEvent.RepositoryName)
+		addr, _ := getRawURL(pushEvent.SCM, pushEvent.RepositoryURL, pushEvent.RepositoryOwnerLogin, pushEvent.RepositoryName, "master")
 		if addr != pushEvent.Expected {
 			t.Errorf("Want \"%s\", got \"%s\"", pushEvent.Expected, addr)
 		}
@@ -78,6 +87,16 @@ func Test_hasDockerfileFunction(t *testing.T) {
 			},
 			expected: true,
 		},
+		{
+			title: "with a pre-built dockerfile function",
+			input: map[string]stack.Function{
+				"test": {
+					Language:  "Dockerfile",
+					SkipBuild: true,
+				},
+			},
+			expected: false,
+		},
 	}
 
 	for _, c := range cases {
@@ -89,3 +108,56 @@ func Test_hasDockerfileFunction(t *testing.T) {
 		})
 	}
 }
+
+func Test_pushBranch(t *testing.T) {
+	os.Setenv("build_branch", "master")
+	defer os.Unsetenv("build_branch")
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "refs/heads/master", want: "master"},
+		{ref: "refs/heads/staging", want: "staging"},
+		{ref: "refs/tags/0.1.0", want: "master"},
+		{ref: "", want: "master"},
+	}
+
+	for _, test := range tests {
+		got := pushBranch(sdk.PushEvent{Ref: test.ref})
+		if got != test.want {
+			t.Errorf("ref %q: want %s, got %s", test.ref, test.want, got)
+		}
+	}
+}
+
+func Test_sourceRef(t *testing.T) {
+	os.Setenv("build_branch", "master")
+	defer os.Unsetenv("build_branch")
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "refs/heads/staging", want: "staging"},
+		{ref: "refs/tags/0.1.0", want: "0.1.0"},
+		{ref: "", want: "master"},
+	}
+
+	for _, test := range tests {
+		got := sourceRef(sdk.PushEvent{Ref: test.ref})
+		if got != test.want {
+			t.Errorf("ref %q: want %s, got %s", test.ref, test.want, got)
+		}
+	}
+}
+
+func Test_checkoutRef(t *testing.T) {
+	if got := checkoutRef(sdk.PushEvent{Ref: "refs/tags/0.1.0", AfterCommitID: "04b8e44988"}); got != "04b8e44988" {
+		t.Errorf("want the pushed commit checked out, got %s", got)
+	}
+
+	if got := checkoutRef(sdk.PushEvent{Ref: "refs/tags/0.1.0"}); got != "0.1.0" {
+		t.Errorf("want the release's tag checked out, got %s", got)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"code.cloudfoundry.org/bytefmt"
 	"github.com/openfaas/faas-cli/schema"
@@ -34,30 +35,29 @@ type tarEntry struct {
 	fileName     string
 	functionName string
 	imageName    string
+	// skipBuild is set for a pre-built image, which has no tar
+	skipBuild bool
 }
 
 func parseYAML(filePath string) (*stack.Services, error) {
 	envVarSubst := false
-	parsed, err := stack.ParseYAMLFile(path.Join(filePath, "stack.yml"), "", "", envVarSubst)
+	parsed, err := stack.ParseYAMLFile(path.Join(filePath, stackFileName), "", "", envVarSubst)
 	return parsed, err
 }
 
-func fetchTemplates(filePath string) error {
+// fetchTemplates pulls the templates of each repository in
+// custom_templates, with those in template_pins at their pinned version
+func fetchTemplates(fetcher GitRepoFetcher, filePath string) error {
 	templateRepos, errors := formatTemplateRepos()
+	pins, pinErrors := templatePins()
 
-	if err := joinErrors(errors); err != nil {
+	if err := joinErrors(append(errors, pinErrors...)); err != nil {
 		return err
 	}
 
 	var errs []error
 	for _, repo := range templateRepos {
-		pullCmd := exec.Command("faas-cli", "template", "pull", repo)
-		pullCmd.Dir = filePath
-		if err := pullCmd.Start(); err != nil {
-			errs = append(errs, fmt.Errorf("%s, %s", repo, err.Error()))
-			continue
-		}
-		if err := pullCmd.Wait(); err != nil {
+		if err := pullTemplates(fetcher, repo, filePath, pins); err != nil {
 			errs = append(errs, fmt.Errorf("%s, %s", repo, err.Error()))
 		}
 	}
@@ -95,6 +95,17 @@ func makeTar(pushEvent sdk.PushEvent, filePath string, services *stack.Services)
 	fmt.Printf("Tar up %s\n", filePath)
 
 	for k, v := range services.Functions {
+		if v.SkipBuild {
+			fmt.Println("Skipping build of pre-built image: ", v.Image, k)
+
+			tars = append(tars,
+				tarEntry{functionName: strings.TrimSpace(k),
+					imageName: v.Image,
+					skipBuild: true,
+				})
+			continue
+		}
+
 		fmt.Println("Creating tar for: ", v.Handler, k)
 
 		tarPath := path.Join(filePath, fmt.Sprintf("%s.tar", k))
@@ -116,7 +127,11 @@ func makeTar(pushEvent sdk.PushEvent, filePath string, services *stack.Services)
 		}
 
 		imageName := formatImageShaTag(pushRepositoryURL, &v, pushEvent.AfterCommitID,
-			pushEvent.Repository.Owner.Login, pushEvent.Repository.Name)
+			pushEvent.Repository.Owner.Login, pushEvent.Repository.Name, pushBranch(pushEvent))
+		if tag := sdk.TagFromRef(pushEvent.Ref); len(tag) > 0 {
+			imageName = formatImageGitTag(pushRepositoryURL, &v, tag,
+				pushEvent.Repository.Owner.Login, pushEvent.Repository.Name)
+		}
 
 		allowedBuildArgs := []string{"GO111MODULE"}
 		buildArgs := makeBuildArgs(v.BuildArgs, allowedBuildArgs)
@@ -124,6 +139,7 @@ func makeTar(pushEvent sdk.PushEvent, filePath string, services *stack.Services)
 		// Write a config file for the Docker build
 		config := buildConfig{
 			Ref:       imageName,
+			Language:  v.Language,
 			BuildArgs: buildArgs,
 		}
 
@@ -187,7 +203,7 @@ func makeTar(pushEvent sdk.PushEvent, filePath string, services *stack.Services)
 	return tars, nil
 }
 
-func formatImageShaTag(registry string, function *stack.Function, sha string, owner string, repo string) string {
+func formatImageShaTag(registry string, function *stack.Function, sha string, owner string, repo string, branch string) string {
 	imageName := function.Image
 
 	repoIndex := strings.LastIndex(imageName, "/")
@@ -197,11 +213,38 @@ func formatImageShaTag(registry string, function *stack.Function, sha string, ow
 
 	sha = sdk.FormatShortSHA(sha)
 
-	imageName = schema.BuildImageName(schema.BranchAndSHAFormat, imageName, sha, buildBranch())
+	imageName = schema.BuildImageName(schema.BranchAndSHAFormat, imageName, sha, branch)
+
+	return formatImageRef(registry, imageName, owner, repo)
+}
+
+// formatImageGitTag tags the image with the git tag in place of the
+// tag from stack.yml, i.e. go-fns-tester-func:v1.2.0
+func formatImageGitTag(registry string, function *stack.Function, tag string, owner string, repo string) string {
+	imageName := function.Image
+
+	repoIndex := strings.LastIndex(imageName, "/")
+	if repoIndex > -1 {
+		imageName = imageName[repoIndex+1:]
+	}
+
+	if tagIndex := strings.Index(imageName, ":"); tagIndex > -1 {
+		imageName = imageName[:tagIndex]
+	}
 
+	return formatImageRef(registry, imageName+":"+sdk.FormatImageTag(tag), owner, repo)
+}
+
+// formatImageRef prefixes the image with the registry, organisation or
+// owner it is pushed to
+func formatImageRef(registry string, imageName string, owner string, repo string) string {
 	var imageRef string
 	sharedRepo := strings.HasSuffix(registry, "/")
-	if sharedRepo {
+	if len(sdk.OwnerRegistryURL(owner)) > 0 || len(sdk.RegistryOrg(owner)) > 0 {
+		// The owner pushes to their own registry, or their own
+		// organisation within the platform's registry
+		imageRef = sdk.OwnerRegistry(registry, owner) + "/" + repo + "-" + imageName
+	} else if sharedRepo {
 		imageRef = registry[:len(registry)-1] + "/" + owner + "-" + repo + "-" + imageName
 	} else {
 		imageRef = registry + "/" + owner + "/" + repo + "-" + imageName
@@ -238,93 +281,86 @@ func (t *githubAuthToken) getToken() (string, error) {
 	return token, nil
 }
 
-func (t *githubAuthToken) getInstallationID() int {
-	return t.installationID
-}
-
-type tokener interface {
-	getToken() (string, error)
-	getInstallationID() int
-}
-
-func getRepositoryURL(e sdk.PushEvent, authToken tokener) (string, error) {
-	cu := e.Repository.CloneURL
-
-	if e.Repository.Private {
-		u, err := url.Parse(cu)
-
-		if err != nil {
-			return "", fmt.Errorf("couldn't parse URL in getRepositoryURL: %t", err)
-		}
-
-		token, err := authToken.getToken()
-
-		if err != nil {
-			return "", fmt.Errorf("cannot get auth token: %t", err)
-		}
-
-		iid := authToken.getInstallationID()
-
-		u.User = url.UserPassword(strconv.Itoa(iid), token)
-
-		return u.String(), nil
+// installationToken creates a token for an installation of the GitHub
+// App to clone its private repositories
+func installationToken(installationID int) (string, error) {
+	at := &githubAuthToken{
+		appID:          os.Getenv("github_app_id"),
+		installationID: installationID,
+		privateKeyPath: sdk.GetPrivateKeyPath(),
 	}
+	return at.getToken()
+}
 
-	return cu, nil
+func init() {
+	sdk.RegisterSCM(&sdk.GitHubSCM{InstallationToken: installationToken})
 }
 
-func clone(fetcher RepoFetcher, pushEvent sdk.PushEvent) (string, error) {
+func clone(fetcher RepoFetcher, pushEvent sdk.PushEvent) (clonePath string, deployed map[string]string, err error) {
 	workDir := os.TempDir()
 	destPath := path.Join(workDir, path.Join(pushEvent.Repository.Owner.Login, pushEvent.Repository.Name))
 
 	if len(pushEvent.Repository.Owner.Login) == 0 {
-		return "", fmt.Errorf("login must be specified")
+		return "", nil, fmt.Errorf("login must be specified")
 	}
 	if len(pushEvent.Repository.Name) == 0 {
-		return "", fmt.Errorf("repo name must be specified")
+		return "", nil, fmt.Errorf("repo name must be specified")
 	}
 
 	if _, err := os.Stat(destPath); err == nil {
 		truncateErr := os.RemoveAll(destPath)
 		if truncateErr != nil {
-			return "", truncateErr
+			return "", nil, truncateErr
 		}
 	}
 
 	userDir := path.Join(workDir, pushEvent.Repository.Owner.Login)
-	err := os.MkdirAll(userDir, 0777)
+	err = os.MkdirAll(userDir, 0777)
 
 	if err != nil {
-		return "", fmt.Errorf("cannot create user-dir: %s", userDir)
+		return "", nil, fmt.Errorf("cannot create user-dir: %s", userDir)
 	}
 
-	var cloneURL string
-	var cloneErr error
-
-	switch pushEvent.SCM {
-	case GitHub:
-
-		cloneURL, cloneErr = GitHubCloneURL(pushEvent)
-		if cloneErr != nil {
-			return "", fmt.Errorf("error while creating GitLab CloneURL: %s", cloneErr.Error())
-		}
+	scm, scmErr := sdk.GetSCM(pushEvent.SCM)
+	if scmErr != nil {
+		return "", nil, scmErr
+	}
 
-	case GitLab:
+	cloneURL, cloneErr := sdk.CloneURL(scm, pushEvent)
+	if cloneErr != nil {
+		return "", nil, fmt.Errorf("error while creating %s CloneURL: %s", scm.Name(), cloneErr.Error())
+	}
 
-		if pushEvent.Repository.Private {
-			cloneURL, cloneErr = GitLabCloneURL(pushEvent)
-			if cloneErr != nil {
-				return "", fmt.Errorf("error while creating GitLab CloneURL: %s", cloneErr.Error())
+	// The installation token would otherwise be left in .git/config and
+	// copied into the build context of a function whose handler is the
+	// root of the repo, so it is removed however the clone ends
+	if cloneURL != pushEvent.Repository.CloneURL {
+		defer func() {
+			if originErr := fetcher.SetOrigin(pushEvent.Repository.CloneURL, destPath); originErr != nil {
+				os.RemoveAll(destPath)
+				if err == nil {
+					clonePath, err = "", originErr
+				}
 			}
-		} else {
-			cloneURL = pushEvent.Repository.CloneURL
-		}
+		}()
+	}
+
+	if err := fetcher.Clone(cloneURL, userDir); err != nil {
+		return "", nil, err
+	}
+	if err := fetcher.Checkout(checkoutRef(pushEvent), destPath); err != nil {
+		return "", nil, err
 	}
 
-	fetcher.Clone(cloneURL, path.Join(workDir, pushEvent.Repository.Owner.Login))
-	fetcher.Checkout(pushEvent.AfterCommitID, destPath)
+	// The commits the functions are deployed from are fetched while
+	// origin still has the credentials of a private repo, a function
+	// whose commit can't be fetched is built
+	if buildChangedOnly() && compareDeployed(pushEvent) {
+		deployed = deployedCommits(os.Getenv("gateway_url"), pushEvent, destPath)
+		fetchDeployed(fetcher, deployed, destPath)
+	}
 
-	return destPath, err
+	return destPath, deployed, nil
 }
 
 func deploy(tars []tarEntry, pushEvent sdk.PushEvent, stack *stack.Services, status *sdk.Status, payloadSecret string) error {
@@ -377,8 +413,12 @@ func deployFunction(tarEntry tarEntry, pushEvent sdk.PushEvent, stack *stack.Ser
 
 	log.Printf("Deploying: %s, image: %s\n", tarEntry.functionName, tarEntry.imageName)
 
-	status.AddStatus(sdk.StatusPending, fmt.Sprintf("%s function build started, image: %s", tarEntry.functionName,
-		tarEntry.imageName),
+	startedMsg := fmt.Sprintf("%s function build started, image: %s", tarEntry.functionName, tarEntry.imageName)
+	if tarEntry.skipBuild {
+		startedMsg = fmt.Sprintf("%s function deploy started, pre-built image: %s", tarEntry.functionName, tarEntry.imageName)
+	}
+
+	status.AddStatus(sdk.StatusPending, startedMsg,
 		sdk.BuildFunctionContext(tarEntry.functionName))
 
 	statusErr := reportStatus(status, pushEvent.SCM)
@@ -386,34 +426,15 @@ func deployFunction(tarEntry tarEntry, pushEvent sdk.PushEvent, stack *stack.Ser
 		log.Printf(statusErr.Error())
 	}
 
-	fileOpen, err := os.Open(tarEntry.fileName)
-
-	if err != nil {
-		return err
-	}
-
-	defer fileOpen.Close()
-
-	fileInfo, statErr := fileOpen.Stat()
-	if statErr == nil {
-		msg := fmt.Sprintf("Building: %s, tar: %s\n",
-			tarEntry.functionName,
-			bytefmt.ByteSize(uint64(fileInfo.Size())))
-
-		log.Printf("%s\n", msg)
-
-		auditEvent := sdk.AuditEvent{
-			Message: msg,
-			Owner:   pushEvent.Repository.Owner.Login,
-			Repo:    pushEvent.Repository.Name,
-			Source:  Source,
+	// A pre-built image is deployed without a build context, the empty
+	// body is still signed so that buildshiprun can verify the request
+	tarFileBytes := []byte{}
+	if !tarEntry.skipBuild {
+		var err error
+		tarFileBytes, err = readTar(tarEntry, pushEvent)
+		if err != nil {
+			return err
 		}
-		sdk.PostAudit(auditEvent)
-	}
-
-	tarFileBytes, tarReadErr := ioutil.ReadAll(fileOpen)
-	if tarReadErr != nil {
-		return tarReadErr
 	}
 
 	digest := hmac.Sign(tarFileBytes, []byte(payloadSecret))
@@ -434,7 +455,19 @@ func deployFunction(tarEntry tarEntry, pushEvent sdk.PushEvent, stack *stack.Ser
 	httpReq.Header.Add("Scm", sourceManagement)
 	httpReq.Header.Add("Private", strconv.FormatBool(privateRepo))
 	httpReq.Header.Add("Repo-URL", repositoryURL)
+	httpReq.Header.Add("Skip-Build", strconv.FormatBool(tarEntry.skipBuild))
 	httpReq.Header.Add("Owner-ID", fmt.Sprintf("%d,", ownerID))
+	httpReq.Header.Add("Branch", pushBranch(pushEvent))
+	if tag := sdk.TagFromRef(pushEvent.Ref); len(tag) > 0 {
+		httpReq.Header.Add("Tag", tag)
+	}
+	if pushEvent.Metadata != nil {
+		httpReq.Header.Add("Repo-License", pushEvent.Metadata.License)
+		httpReq.Header.Add("Repo-Languages", pushEvent.Metadata.FormatLanguages())
+	}
+	if pushEvent.PullRequest > 0 {
+		httpReq.Header.Add("Pull-Request", strconv.Itoa(pushEvent.PullRequest))
+	}
 
 	envJSON, marshalErr := json.Marshal(stack.Functions[tarEntry.functionName].Environment)
 	if marshalErr != nil {
@@ -460,6 +493,16 @@ func deployFunction(tarEntry tarEntry, pushEvent sdk.PushEvent, stack *stack.Ser
 		httpReq.Header.Add("Labels", string(jsonBytes))
 	}
 
+	// Marshal constraints
+	if stack.Functions[tarEntry.functionName].Constraints != nil {
+		jsonBytes, marshalErr := json.Marshal(stack.Functions[tarEntry.functionName].Constraints)
+		if marshalErr != nil {
+			log.Printf("Error marshaling constraints for function: %s, error: %s", tarEntry.functionName, marshalErr)
+		}
+
+		httpReq.Header.Add("Constraints", string(jsonBytes))
+	}
+
 	// Marshal annotations
 	if stack.Functions[tarEntry.functionName].Annotations != nil {
 		jsonBytes, marshalErr := json.Marshal(stack.Functions[tarEntry.functionName].Annotations)
@@ -470,7 +513,36 @@ func deployFunction(tarEntry tarEntry, pushEvent sdk.PushEvent, stack *stack.Ser
 		httpReq.Header.Add("Annotations", string(jsonBytes))
 	}
 
-	res, reqErr := http.DefaultClient.Do(httpReq)
+	// The customer's tier and entitlements as resolved by github-event
+	if pushEvent.Customer != nil {
+		httpReq.Header.Add("Tier", pushEvent.Customer.Tier)
+		if len(pushEvent.Customer.Entitlements) > 0 {
+			jsonBytes, _ := json.Marshal(pushEvent.Customer.Entitlements)
+			httpReq.Header.Add("Entitlements", string(jsonBytes))
+		}
+	}
+
+	// The webhook delivery which started the pipeline
+	if pushEvent.Delivery != nil {
+		jsonBytes, _ := json.Marshal(pushEvent.Delivery)
+		httpReq.Header.Add("Delivery", string(jsonBytes))
+	}
+
+	// The template, so that buildshiprun can apply its default resources
+	httpReq.Header.Add("Language", stack.Functions[tarEntry.functionName].Language)
+
+	// Marshal limits and requests
+	if limits := stack.Functions[tarEntry.functionName].Limits; limits != nil {
+		jsonBytes, _ := json.Marshal(sdk.Resources{Memory: limits.Memory, CPU: limits.CPU})
+		httpReq.Header.Add("Limits", string(jsonBytes))
+	}
+
+	if requests := stack.Functions[tarEntry.functionName].Requests; requests != nil {
+		jsonBytes, _ := json.Marshal(sdk.Resources{Memory: requests.Memory, CPU: requests.CPU})
+		httpReq.Header.Add("Requests", string(jsonBytes))
+	}
+
+	res, reqErr := sdk.NewHTTPClientWithTimeout(deployTimeout()).Do(httpReq)
 
 	if reqErr != nil {
 		return fmt.Errorf("unable to deploy function via buildshiprun: %s", reqErr.Error())
@@ -483,6 +555,37 @@ func deployFunction(tarEntry tarEntry, pushEvent sdk.PushEvent, stack *stack.Ser
 	return nil
 }
 
+// readTar reads the build context for a function and records its size
+// in the audit trail
+func readTar(tarEntry tarEntry, pushEvent sdk.PushEvent) ([]byte, error) {
+	fileOpen, err := os.Open(tarEntry.fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fileOpen.Close()
+
+	fileInfo, statErr := fileOpen.Stat()
+	if statErr == nil {
+		msg := fmt.Sprintf("Building: %s, tar: %s\n",
+			tarEntry.functionName,
+			bytefmt.ByteSize(uint64(fileInfo.Size())))
+
+		log.Printf("%s\n", msg)
+
+		auditEvent := sdk.AuditEvent{
+			Message: msg,
+			Owner:   pushEvent.Repository.Owner.Login,
+			Repo:    pushEvent.Repository.Name,
+			Source:  Source,
+		}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery)
+		sdk.PostAudit(auditEvent)
+	}
+
+	return ioutil.ReadAll(fileOpen)
+}
+
 func importSecrets(pushEvent sdk.PushEvent, stack *stack.Services, clonePath string) error {
 	gatewayURL := os.Getenv("gateway_url")
 
@@ -518,7 +621,7 @@ func importSecrets(pushEvent sdk.PushEvent, stack *stack.Services, clonePath str
 	digest := hmac.Sign(bytesOut, []byte(payloadSecret))
 	httpReq.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
 
-	res, reqErr := http.DefaultClient.Do(httpReq)
+	res, reqErr := sdk.HTTPClient().Do(httpReq)
 
 	if reqErr != nil {
 		fmt.Fprintf(os.Stderr, fmt.Errorf("error reaching import-secrets function: %s", reqErr.Error()).Error())
@@ -546,7 +649,7 @@ func importSecrets(pushEvent sdk.PushEvent, stack *stack.Services, clonePath str
 		Owner:   pushEvent.Repository.Owner.Login,
 		Repo:    pushEvent.Repository.Name,
 		Source:  Source,
-	}
+	}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery)
 
 	sdk.PostAudit(auditEvent)
 
@@ -574,141 +677,30 @@ func formatTemplateRepos() ([]string, []error) {
 	return templateRepos, errors
 }
 
+// reportStatus sends the status to the event's SCM through its status
+// function, statuses for GitHub are only sent when report_status is set
 func reportStatus(status *sdk.Status, SCM string) error {
-	if SCM == GitHub {
-		reportGitHubStatus(status)
-	} else if SCM == GitLab {
-		reportGitLabStatus(status)
-	} else {
-		return fmt.Errorf("non-supported SCM: %s", SCM)
-	}
-	return nil
-}
-
-func reportGitLabStatus(status *sdk.Status) {
-
-	payloadSecret, secretErr := sdk.ReadSecret("payload-secret")
-	if secretErr != nil {
-		log.Printf("unexpected error while reading secret: %s", secretErr)
-	}
-
-	suffix := os.Getenv("dns_suffix")
-	gatewayURL := os.Getenv("gateway_url")
-	gatewayURL = sdk.CreateServiceURL(gatewayURL, suffix)
-
-	statusBytes, marshalErr := json.Marshal(status)
-	if marshalErr != nil {
-		log.Printf("error while marshalling request: %s", marshalErr.Error())
-	}
-
-	statusReader := bytes.NewReader(statusBytes)
-	req, reqErr := http.NewRequest(http.MethodPost, gatewayURL+"function/gitlab-status", statusReader)
-	if reqErr != nil {
-		log.Printf("error while making request to gitlab-status: `%s`", reqErr.Error())
-	}
-
-	digest := hmac.Sign(statusBytes, []byte(payloadSecret))
-	req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
-
-	res, resErr := http.DefaultClient.Do(req)
-	if resErr != nil {
-		log.Printf("unexpected error while retrieving response: %s", resErr.Error())
-	}
-	if res.Body != nil {
-		defer res.Body.Close()
-	}
-	if res.StatusCode != http.StatusAccepted {
-		log.Printf("unexpected status code: %d", res.StatusCode)
-	}
-
-	_, bodyErr := ioutil.ReadAll(res.Body)
-	if bodyErr != nil {
-		log.Printf("unexpected error while reading response body: %s", bodyErr.Error())
+	scm, err := sdk.GetSCM(SCM)
+	if err != nil {
+		return err
 	}
-	status.CommitStatuses = make(map[string]sdk.CommitStatus)
-}
-
-func reportGitHubStatus(status *sdk.Status) {
 
-	if !enableStatusReporting() {
-		return
+	if scm.Name() == GitHub && !enableStatusReporting() {
+		return nil
 	}
 
-	hmacKey, keyErr := getPayloadSecret()
-
+	payloadSecret, keyErr := getPayloadSecret()
 	if keyErr != nil {
 		log.Printf("failed to load hmac key for status, error " + keyErr.Error())
-
-		return
+		return nil
 	}
 
-	gatewayURL := os.Getenv("gateway_url")
+	gatewayURL := sdk.CreateServiceURL(os.Getenv("gateway_url"), os.Getenv("dns_suffix"))
 
-	_, reportErr := status.Report(gatewayURL, hmacKey)
-	if reportErr != nil {
+	if reportErr := scm.ReportStatus(status, gatewayURL, payloadSecret); reportErr != nil {
 		log.Printf("failed to report status, error: %s", reportErr.Error())
 	}
-}
-
-func formatGitLabCloneURL(pushEvent sdk.PushEvent, tokenAPI string) (string, error) {
-	url, urlErr := url.Parse(pushEvent.Repository.CloneURL)
-	if urlErr != nil {
-		return "", fmt.Errorf("error while parsing URL: %s", urlErr.Error())
-	}
-	return fmt.Sprintf("https://%s:%s@%s%s", pushEvent.Repository.Owner.Login, tokenAPI, url.Host, url.Path), nil
-}
-
-// GitLabCloneURL builds a URL from an sdk.PushEvent on how to clone a
-// GitLab repository
-func GitLabCloneURL(pushEvent sdk.PushEvent) (string, error) {
-	tokenAPI, tokenErr := sdk.ReadSecret("gitlab-api-token")
-	if tokenErr != nil {
-		return "", fmt.Errorf("cannot read api token from GitLab in secret `gitlab-api-token`: %s", tokenErr.Error())
-	}
-
-	cloneURL, formatErr := formatGitLabCloneURL(pushEvent, tokenAPI)
-	if formatErr != nil {
-		return "", fmt.Errorf("error while formatting clone URL for GitLab: %s", formatErr.Error())
-	}
-
-	return cloneURL, nil
-}
-
-// GitHubCloneURL builds a URL from an sdk.PushEvent on how to clone a
-// GitHub repository
-func GitHubCloneURL(pushEvent sdk.PushEvent) (string, error) {
-	at := &githubAuthToken{
-		appID:          os.Getenv("github_app_id"),
-		installationID: pushEvent.Installation.ID,
-		privateKeyPath: sdk.GetPrivateKeyPath(),
-	}
-
-	cloneURL, err := getRepositoryURL(pushEvent, at)
-	if err != nil {
-		return "", fmt.Errorf("cannot get repository url to clone: %t", err)
-	}
-
-	return cloneURL, nil
-}
-
-func checkCompatibleTemplates(stack *stack.Services, filePath string) (templatesErr error) {
-	templates, ioErr := existingTemplates(filePath)
-	if templatesErr != nil {
-		templatesErr = ioErr
-	}
-	for functionName, function := range stack.Functions {
-		for templateIndex, template := range templates {
-			if template == function.Language {
-				break
-			} else if templateIndex == len(templates)-1 && template != function.Language {
-				templatesErr = fmt.Errorf("Not supported language: `%s` for function: `%s`",
-					function.Language,
-					functionName,
-				)
-			}
-		}
-	}
-	return templatesErr
+	return nil
 }
 
 func existingTemplates(filePath string) ([]string, error) {
@@ -757,6 +749,15 @@ func registerImage(image, payloadSecret string) error {
 	return err
 }
 
+// deployTimeout bounds the call to buildshiprun, which builds and
+// deploys the function, by git-tar's own write_timeout (default 15m)
+func deployTimeout() time.Duration {
+	if val, err := time.ParseDuration(os.Getenv("write_timeout")); err == nil && val > 0 {
+		return val
+	}
+	return 15 * time.Minute
+}
+
 func invokeWithHMAC(uri string, payload []byte, payloadSecret string, headers map[string]string) (int, []byte, error) {
 
 	statusReader := bytes.NewReader(payload)
@@ -776,7 +777,7 @@ func invokeWithHMAC(uri string, payload []byte, payloadSecret string, headers ma
 		req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
 	}
 
-	res, resErr := http.DefaultClient.Do(req)
+	res, resErr := sdk.HTTPClient().Do(req)
 	if resErr != nil {
 		log.Printf("unexpected error while retrieving response: %s", resErr.Error())
 		return http.StatusServiceUnavailable, nil, resErr
@@ -0,0 +1,146 @@
+package function
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// templateSHA is a commit a template is pinned to, it must be in full
+	// since a server will only fetch a commit by its whole SHA
+	templateSHA = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+	// versionRange is a version a template is pinned to, i.e. 1.2.3, 0.x
+	// or v1.4.x, which is resolved to the highest tag that matches
+	versionRange = regexp.MustCompile(`^v?([0-9]+)(?:\.([0-9]+|x))?(?:\.([0-9]+|x))?$`)
+
+	// versionTag is a release tag of a template repository
+	versionTag = regexp.MustCompile(`^v?([0-9]+)\.([0-9]+)\.([0-9]+)$`)
+)
+
+// templatePins reads template_pins, a comma-separated list of
+// name@ref which pins each template to a version range, tag, branch or
+// commit SHA, i.e. "golang-middleware@0.x, node12@1.2.3"
+func templatePins() (map[string]string, []error) {
+	pins := map[string]string{}
+
+	var errors []error
+	for _, entry := range strings.Split(os.Getenv("template_pins"), ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "@", 2)
+		if len(parts) != 2 || !templateName.MatchString(parts[0]) || len(parts[1]) == 0 || strings.HasPrefix(parts[1], "-") {
+			errors = append(errors, fmt.Errorf("Non-valid template pin is configured in template_pins: %s, want name@version", entry))
+			continue
+		}
+		pins[parts[0]] = parts[1]
+	}
+	return pins, errors
+}
+
+// withTemplateRef gives the source at ref, replacing any ref it had
+func withTemplateRef(source, ref string) string {
+	repo, _ := splitTemplateRef(source)
+	return repo + "#" + ref
+}
+
+// resolveTemplateVersion finds the highest release tag of repo within
+// the version range, pre-releases are not matched
+func resolveTemplateVersion(fetcher GitRepoFetcher, dir, repo, version string) (string, error) {
+	want := versionRange.FindStringSubmatch(version)
+	if want == nil {
+		return "", fmt.Errorf("invalid version range: %s", version)
+	}
+
+	out, err := fetcher.output(dir, "ls-remote", "--tags", "--refs", "--", repo)
+	if err != nil {
+		return "", err
+	}
+
+	best, bestVersion := "", []int{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		tag := strings.TrimPrefix(fields[1], "refs/tags/")
+		match := versionTag.FindStringSubmatch(tag)
+		if match == nil {
+			continue
+		}
+
+		tagVersion := []int{}
+		inRange := true
+		for i := 1; i <= 3; i++ {
+			n, _ := strconv.Atoi(match[i])
+			tagVersion = append(tagVersion, n)
+			if len(want[i]) > 0 && want[i] != "x" && want[i] != match[i] {
+				inRange = false
+			}
+		}
+
+		if inRange && (len(best) == 0 || newerVersion(tagVersion, bestVersion)) {
+			best, bestVersion = tag, tagVersion
+		}
+	}
+
+	if len(best) == 0 {
+		return "", fmt.Errorf("no release of %s matches %s", repo, version)
+	}
+	return best, nil
+}
+
+// newerVersion compares the major, minor and patch of two versions
+func newerVersion(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+	return false
+}
+
+// cloneTemplateRef clones repo at ref into dir/repo. A commit SHA is
+// fetched on its own where the server allows it, a version range is
+// resolved to its tag, and anything else is cloned as a branch or tag.
+func cloneTemplateRef(fetcher GitRepoFetcher, dir, repo, ref string) error {
+	repoPath := path.Join(dir, "repo")
+
+	if templateSHA.MatchString(ref) {
+		if err := fetcher.run(dir, "init", "-q", "repo"); err != nil {
+			return err
+		}
+		if err := fetcher.run(repoPath, "remote", "add", "origin", "--", repo); err != nil {
+			return err
+		}
+
+		pinned := GitRepoFetcher{Depth: 1, Timeout: fetcher.Timeout}
+		return pinned.Checkout(ref, repoPath)
+	}
+
+	if versionRange.MatchString(ref) {
+		tag, err := resolveTemplateVersion(fetcher, dir, repo, ref)
+		if err != nil {
+			return err
+		}
+		log.Printf("Resolved template version %s of %s to %s", ref, repo, tag)
+		ref = tag
+	}
+
+	args := []string{"clone", "--depth", "1"}
+	if len(ref) > 0 {
+		args = append(args, "--branch="+ref)
+	}
+	args = append(args, "--", repo, "repo")
+
+	return fetcher.run(dir, args...)
+}
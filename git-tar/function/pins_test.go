@@ -0,0 +1,218 @@
+package function
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_templatePins(t *testing.T) {
+	os.Setenv("template_pins", " golang-middleware@0.x, node12@1a410efbd13591db07496601ebc7a059dd55cfe9 ,,")
+	defer os.Unsetenv("template_pins")
+
+	pins, errs := templatePins()
+	if len(errs) > 0 {
+		t.Fatalf("want no errors, got: %v", errs)
+	}
+
+	want := map[string]string{
+		"golang-middleware": "0.x",
+		"node12":            "1a410efbd13591db07496601ebc7a059dd55cfe9",
+	}
+	if !reflect.DeepEqual(pins, want) {
+		t.Errorf("want: %v, got: %v", want, pins)
+	}
+}
+
+func Test_templatePins_Invalid(t *testing.T) {
+	os.Setenv("template_pins", "golang-middleware, node12@, ../go@1.0.0, python3@--upload-pack=x")
+	defer os.Unsetenv("template_pins")
+
+	pins, errs := templatePins()
+	if len(pins) != 0 || len(errs) != 4 {
+		t.Errorf("want 4 errors and no pins, got: %v, %v", pins, errs)
+	}
+}
+
+// versionedTemplateRepo makes a template repository with a commit for
+// each tag, whose template.yml has the tag in it
+func versionedTemplateRepo(t *testing.T, tags ...string) (string, []string) {
+	dir, err := ioutil.TempDir("", "git-tar-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) string {
+		git := exec.Command("git", args...)
+		git.Dir = dir
+		git.Env = append(os.Environ(), "GIT_AUTHOR_NAME=ofc", "GIT_AUTHOR_EMAIL=ofc@example.com", "GIT_COMMITTER_NAME=ofc", "GIT_COMMITTER_EMAIL=ofc@example.com")
+		out, err := git.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s", strings.Join(args, " "), out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	run("init", "-q")
+	os.MkdirAll(path.Join(dir, "template", "go"), 0700)
+
+	shas := []string{}
+	for _, tag := range tags {
+		ioutil.WriteFile(path.Join(dir, "template", "go", "template.yml"), []byte("version: "+tag+"\n"), 0644)
+		run("add", ".")
+		run("commit", "-q", "-m", tag)
+		run("tag", tag)
+		shas = append(shas, run("rev-parse", "HEAD"))
+	}
+
+	return dir, shas
+}
+
+func Test_resolveTemplateVersion(t *testing.T) {
+	origin, _ := versionedTemplateRepo(t, "0.1.0", "0.3.2", "v0.10.0", "1.0.0", "1.1.0-rc1", "latest")
+	defer os.RemoveAll(origin)
+
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"0.x", "v0.10.0"},
+		{"0", "v0.10.0"},
+		{"0.3", "0.3.2"},
+		{"v0.1.x", "0.1.0"},
+		{"1.x", "1.0.0"},
+		{"0.10.0", "v0.10.0"},
+	}
+
+	fetcher := GitRepoFetcher{Timeout: time.Minute}
+	for _, test := range tests {
+		got, err := resolveTemplateVersion(fetcher, origin, "file://"+origin, test.version)
+		if err != nil {
+			t.Errorf("%s: %s", test.version, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: want %s, got %s", test.version, test.want, got)
+		}
+	}
+
+	if _, err := resolveTemplateVersion(fetcher, origin, "file://"+origin, "2.x"); err == nil {
+		t.Errorf("want an error for a range without a release")
+	}
+}
+
+func Test_pullTemplates_Pinned(t *testing.T) {
+	origin, shas := versionedTemplateRepo(t, "0.1.0", "0.2.0", "1.0.0")
+	defer os.RemoveAll(origin)
+
+	cacheDir, _ := ioutil.TempDir("", "template-cache")
+	defer os.RemoveAll(cacheDir)
+	defer func(dir string) { templateCacheDir = dir }(templateCacheDir)
+	templateCacheDir = cacheDir
+
+	fetcher := GitRepoFetcher{Timeout: time.Minute}
+
+	tests := []struct {
+		title string
+		pins  map[string]string
+		want  string
+	}{
+		{"unpinned", map[string]string{}, "version: 1.0.0\n"},
+		{"version range", map[string]string{"go": "0.x"}, "version: 0.2.0\n"},
+		{"tag", map[string]string{"go": "0.1.0"}, "version: 0.1.0\n"},
+		{"commit", map[string]string{"go": shas[1]}, "version: 0.2.0\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			clonePath, _ := ioutil.TempDir("", "git-tar-clone")
+			defer os.RemoveAll(clonePath)
+
+			if err := pullTemplates(fetcher, "file://"+origin, clonePath, test.pins); err != nil {
+				t.Fatal(err)
+			}
+
+			got, _ := ioutil.ReadFile(path.Join(clonePath, "template", "go", "template.yml"))
+			if string(got) != test.want {
+				t.Errorf("want: %q, got: %q", test.want, string(got))
+			}
+		})
+	}
+}
+
+func Test_cachedTemplateRepo_CommitIsKept(t *testing.T) {
+	origin, shas := versionedTemplateRepo(t, "0.1.0")
+	defer os.RemoveAll(origin)
+
+	cacheDir, _ := ioutil.TempDir("", "template-cache")
+	defer os.RemoveAll(cacheDir)
+	defer func(dir string) { templateCacheDir = dir }(templateCacheDir)
+	templateCacheDir = cacheDir
+
+	os.Setenv("template_cache_ttl", "0s")
+	defer os.Unsetenv("template_cache_ttl")
+
+	fetcher := GitRepoFetcher{Timeout: time.Minute}
+	source := withTemplateRef("file://"+origin, shas[0])
+
+	repoPath, err := cachedTemplateRepo(fetcher, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marker := path.Join(repoPath, "marker")
+	ioutil.WriteFile(marker, []byte{}, 0644)
+
+	if _, err := cachedTemplateRepo(fetcher, source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("want the repository pinned to a commit kept after template_cache_ttl")
+	}
+}
+
+func Test_cachedTemplateRepo_RefreshKeepsVersionInUse(t *testing.T) {
+	origin, _ := versionedTemplateRepo(t, "0.1.0")
+	defer os.RemoveAll(origin)
+
+	cacheDir, _ := ioutil.TempDir("", "template-cache")
+	defer os.RemoveAll(cacheDir)
+	defer func(dir string) { templateCacheDir = dir }(templateCacheDir)
+	templateCacheDir = cacheDir
+
+	os.Setenv("template_cache_ttl", "0s")
+	defer os.Unsetenv("template_cache_ttl")
+
+	fetcher := GitRepoFetcher{Timeout: time.Minute}
+	source := "file://" + origin
+
+	first, err := cachedTemplateRepo(fetcher, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := cachedTemplateRepo(fetcher, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("want a new version once the cache is stale, got %s twice", first)
+	}
+	if _, err := os.Stat(path.Join(first, "template")); err != nil {
+		t.Errorf("want the version given to another build kept while it may be copying: %s", err)
+	}
+
+	stale := time.Now().Add(-2 * templateVersionGrace)
+	os.Chtimes(path.Dir(first), stale, stale)
+
+	if _, err := cachedTemplateRepo(fetcher, source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("want a version stale for longer than %s removed, got: %v", templateVersionGrace, err)
+	}
+}
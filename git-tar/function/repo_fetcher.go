@@ -1,42 +1,201 @@
 package function
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// defaultCloneTimeout bounds each git command run for a clone
+const defaultCloneTimeout = 2 * time.Minute
+
+// urlCredentials matches the credentials of a clone URL in git's output
+var urlCredentials = regexp.MustCompile(`://[^/@\s]+@`)
+
 type RepoFetcher interface {
 	Clone(url, path string) error
 	Checkout(commitID, path string) error
+	// SetOrigin points the clone at path to url, so that credentials
+	// used for the clone are not left in .git/config
+	SetOrigin(url, path string) error
+	// Fetch fetches a commit other than the one checked out, so that
+	// ChangedFiles can compare with it
+	Fetch(commitID, path string) error
+	// ChangedFiles lists the files changed since the commit from
+	ChangedFiles(from, path string) ([]string, error)
 }
 
+// GitRepoFetcher clones with git, fetching only Depth commits of the
+// history when Depth is above 0
 type GitRepoFetcher struct {
+	Depth   int
+	Timeout time.Duration
 }
 
-func (c GitRepoFetcher) Clone(url, path string) error {
-	git := exec.Command("git", "clone", url)
-	git.Dir = path
-	log.Printf("Cloning %s to %s", url, path)
+// newGitRepoFetcher reads clone_depth, 1 by default and 0 for the whole
+// history, and clone_timeout
+func newGitRepoFetcher() GitRepoFetcher {
+	fetcher := GitRepoFetcher{Depth: 1, Timeout: defaultCloneTimeout}
 
-	err := git.Start()
-	if err != nil {
-		return fmt.Errorf("Cannot start git: %t", err)
+	if val, err := strconv.Atoi(os.Getenv("clone_depth")); err == nil && val >= 0 {
+		fetcher.Depth = val
+	}
+	if val, err := time.ParseDuration(os.Getenv("clone_timeout")); err == nil && val > 0 {
+		fetcher.Timeout = val
 	}
 
-	return git.Wait()
+	return fetcher
 }
 
+// Clone clones url into a directory of path, a shallow clone has no
+// files checked out until Checkout fetches the commit
+func (c GitRepoFetcher) Clone(url, path string) error {
+	args := []string{"clone"}
+	if c.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(c.Depth), "--no-checkout")
+	}
+	args = append(args, url)
+
+	log.Printf("Cloning %s to %s, depth: %d", urlCredentials.ReplaceAllString(url, "://***@"), path, c.Depth)
+	return c.run(path, args...)
+}
+
+// Checkout checks out the commit, or tag, which a shallow clone fetches
+// first since it may not be the head of the default branch. A server
+// which refuses to serve a commit by its SHA is fetched in full.
 func (c GitRepoFetcher) Checkout(commitID, path string) error {
-	git := exec.Command("git", "checkout", commitID)
-	git.Dir = path
 	log.Printf("Checking out SHA: %s to %s", commitID, path)
 
-	err := git.Start()
+	if c.Depth > 0 {
+		err := c.run(path, "fetch", "--depth", strconv.Itoa(c.Depth), "origin", commitID)
+		if err == nil {
+			return c.run(path, "checkout", "FETCH_HEAD")
+		}
+
+		log.Printf("Shallow fetch of %s failed, fetching the whole history: %s", commitID, err)
+		args := []string{"fetch", "--tags", "origin"}
+		if _, statErr := os.Stat(filepath.Join(path, ".git", "shallow")); statErr == nil {
+			args = append(args, "--unshallow")
+		}
+		if err := c.run(path, args...); err != nil {
+			return err
+		}
+	}
+
+	return c.run(path, "checkout", commitID)
+}
+
+// SetOrigin replaces the URL of the origin remote
+func (c GitRepoFetcher) SetOrigin(url, path string) error {
+	return c.run(path, "remote", "set-url", "origin", url)
+}
+
+// Fetch fetches the commit into a shallow clone, which only has the
+// commit checked out, a full clone has it already
+func (c GitRepoFetcher) Fetch(commitID, path string) error {
+	if c.Depth == 0 {
+		return nil
+	}
+	return c.run(path, "fetch", "--depth", "1", "origin", commitID)
+}
+
+// ChangedFiles lists the files which differ between from and the commit
+// checked out at path, a shallow clone must Fetch from first
+func (c GitRepoFetcher) ChangedFiles(from, path string) ([]string, error) {
+	out, err := c.output(path, "diff", "--name-only", "--no-renames", from, "HEAD")
 	if err != nil {
-		return fmt.Errorf("Cannot start git checkout: %t", err)
+		return nil, err
+	}
+
+	files := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			files = append(files, line)
+		}
 	}
+	return files, nil
+}
 
-	err = git.Wait()
+// run runs git in dir and logs its output
+func (c GitRepoFetcher) run(dir string, args ...string) error {
+	out, err := c.output(dir, args...)
+	if out = strings.TrimSpace(out); len(out) > 0 {
+		log.Printf("git %s: %s", args[0], urlCredentials.ReplaceAllString(out, "://***@"))
+	}
 	return err
 }
+
+// output runs git in dir and gives its stdout, only stderr is logged. It
+// is killed along with the helpers it started, such as git-remote-https,
+// after the fetcher's Timeout so that a hung clone does not hold up the
+// queue
+func (c GitRepoFetcher) output(dir string, args ...string) (string, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultCloneTimeout
+	}
+
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+	git := exec.Command("git", args...)
+	git.Dir = dir
+	// Fail rather than wait for credentials on a terminal
+	git.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	git.Stdout = &stdout
+	git.Stderr = &stderr
+	git.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := git.Start(); err != nil {
+		return "", fmt.Errorf("cannot start git %s: %s", args[0], err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- git.Wait()
+	}()
+
+	var err error
+	timedOut := false
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		timedOut = true
+		syscall.Kill(-git.Process.Pid, syscall.SIGKILL)
+		<-done
+	}
+
+	if stderr.Len() > 0 {
+		log.Printf("git %s: %s", args[0], urlCredentials.ReplaceAllString(strings.TrimSpace(stderr.String()), "://***@"))
+	}
+
+	// The output is only logged, since the error is reported in the
+	// commit status
+	if timedOut {
+		return "", fmt.Errorf("git %s timed out after %s", args[0], timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %s", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// resolveCommit gives the SHA of the commit checked out at path
+func resolveCommit(path string) (string, error) {
+	git := exec.Command("git", "rev-parse", "HEAD")
+	git.Dir = path
+
+	out, err := git.Output()
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve HEAD: %s", err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
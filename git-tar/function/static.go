@@ -0,0 +1,66 @@
+package function
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/openfaas/faas-cli/stack"
+)
+
+// staticLanguage is the lang of a static site in stack.yml, its handler
+// is the directory of the site, i.e. ./site or ./public
+const staticLanguage = "static"
+
+// staticTemplateYAML and staticDockerfile make up the built-in template
+// for static sites. The of-watchdog serves the handler's files, so a
+// site is deployed and scaled like any other function.
+const staticTemplateYAML = `language: static
+`
+
+const staticDockerfile = `FROM --platform=${TARGETPLATFORM:-linux/amd64} ghcr.io/openfaas/of-watchdog:0.9.6 as watchdog
+FROM --platform=${TARGETPLATFORM:-linux/amd64} alpine:3.12 as ship
+
+COPY --from=watchdog /fwatchdog /usr/bin/fwatchdog
+RUN chmod +x /usr/bin/fwatchdog
+
+RUN addgroup -S app && adduser -S -g app app
+
+WORKDIR /home/app/public
+COPY --chown=app:app function/ .
+
+USER app
+
+ENV mode="static"
+ENV static_path="/home/app/public"
+
+HEALTHCHECK --interval=3s CMD [ -e /tmp/.lock ] || exit 1
+
+CMD ["fwatchdog"]
+`
+
+func hasStaticFunction(functions map[string]stack.Function) bool {
+	for _, function := range functions {
+		if !function.SkipBuild && strings.ToLower(function.Language) == staticLanguage {
+			return true
+		}
+	}
+	return false
+}
+
+// writeStaticTemplate adds the built-in static template to the templates
+// fetched into the repository, custom template folders are rejected
+// before this so it can't be overridden
+func writeStaticTemplate(filePath string) error {
+	templatePath := path.Join(filePath, "template", staticLanguage)
+	if err := os.MkdirAll(path.Join(templatePath, "function"), 0700); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path.Join(templatePath, "template.yml"), []byte(staticTemplateYAML), 0600); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(templatePath, "Dockerfile"), []byte(staticDockerfile), 0600)
+}
@@ -0,0 +1,67 @@
+package function
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/openfaas/faas-cli/stack"
+)
+
+func Test_hasStaticFunction(t *testing.T) {
+	tests := []struct {
+		title     string
+		functions map[string]stack.Function
+		want      bool
+	}{
+		{
+			title:     "static site",
+			functions: map[string]stack.Function{"docs": {Language: "static"}, "fn1": {Language: "go"}},
+			want:      true,
+		},
+		{
+			title:     "functions only",
+			functions: map[string]stack.Function{"fn1": {Language: "go"}},
+			want:      false,
+		},
+		{
+			title:     "pre-built image",
+			functions: map[string]stack.Function{"docs": {Language: "static", SkipBuild: true}},
+			want:      false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if got := hasStaticFunction(test.functions); got != test.want {
+				t.Errorf("want %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func Test_writeStaticTemplate(t *testing.T) {
+	clonePath, err := ioutil.TempDir("", "ofc-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(clonePath)
+
+	if err := writeStaticTemplate(clonePath); err != nil {
+		t.Fatal(err)
+	}
+
+	services := &stack.Services{Functions: map[string]stack.Function{
+		"docs": {Language: "static"},
+	}}
+	if errs := validateTemplates(clonePath, services); len(errs) > 0 {
+		t.Errorf("want the static template found, got: %s", summariseStackErrors(errs))
+	}
+
+	for _, name := range []string{"template.yml", "Dockerfile", "function"} {
+		if _, err := os.Stat(path.Join(clonePath, "template", "static", name)); err != nil {
+			t.Errorf("want %s in the template: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package function
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/openfaas/faas-cli/stack"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// defaultTemplateStore is the OpenFaaS template store, as used by
+// faas-cli template store pull
+const defaultTemplateStore = "https://raw.githubusercontent.com/openfaas/store/master/templates.json"
+
+// defaultTemplateCacheTTL is how long a template repository is reused
+// before it is cloned again
+const defaultTemplateCacheTTL = 15 * time.Minute
+
+// templateCacheDir has a shallow clone of each template repository, so
+// that the builds of a replica do not clone them every time
+var templateCacheDir = path.Join(os.TempDir(), "template-cache")
+
+// templateName is the name of a template's folder
+var templateName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// storeTemplate is an entry of the template store
+type storeTemplate struct {
+	Template string `json:"template"`
+	Platform string `json:"platform"`
+	Repo     string `json:"repo"`
+}
+
+// templateAllowlist reads template_allowlist, the repositories which
+// stack.yml may fetch templates from. An entry ending with "/" allows
+// every repository under it, i.e. https://github.com/openfaas/
+func templateAllowlist() []string {
+	allowlist := []string{}
+	for _, entry := range strings.Split(os.Getenv("template_allowlist"), ",") {
+		if entry = strings.TrimSpace(entry); len(entry) > 0 {
+			allowlist = append(allowlist, entry)
+		}
+	}
+	return allowlist
+}
+
+func templateStoreURL() string {
+	if val := os.Getenv("template_store_url"); len(val) > 0 {
+		return val
+	}
+	return defaultTemplateStore
+}
+
+func templateCacheTTL() time.Duration {
+	if val, err := time.ParseDuration(os.Getenv("template_cache_ttl")); err == nil && val >= 0 {
+		return val
+	}
+	return defaultTemplateCacheTTL
+}
+
+// splitTemplateRef splits a source into its repository and the branch or
+// tag after "#", as faas-cli template pull does
+func splitTemplateRef(source string) (string, string) {
+	if i := strings.Index(source, "#"); i >= 0 {
+		return source[:i], source[i+1:]
+	}
+	return source, ""
+}
+
+// templateSchemes are the schemes which stack.yml may fetch a template
+// repository with
+var templateSchemes = map[string]bool{"https": true}
+
+// templateRepoURL is a repository parsed for the allowlist, with a
+// lowercase host and the segments of its path without a trailing ".git"
+type templateRepoURL struct {
+	Scheme   string
+	Host     string
+	Segments []string
+}
+
+// parseTemplateRepo parses a repository for the allowlist. A path with a
+// "." or ".." segment is refused rather than cleaned, so that it can't be
+// used to step out of an allowed organisation.
+func parseTemplateRepo(repo string) (templateRepoURL, error) {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return templateRepoURL{}, err
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !templateSchemes[scheme] {
+		return templateRepoURL{}, fmt.Errorf("the scheme must be https")
+	}
+	if len(u.Host) == 0 || len(u.Opaque) > 0 {
+		return templateRepoURL{}, fmt.Errorf("no host is given")
+	}
+	if u.User != nil || len(u.RawQuery) > 0 {
+		return templateRepoURL{}, fmt.Errorf("credentials and queries are not allowed")
+	}
+
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return templateRepoURL{}, fmt.Errorf("the path must not have %q", segment)
+		}
+	}
+
+	segments := []string{}
+	if cleaned := strings.Trim(path.Clean("/"+u.Path), "/"); len(cleaned) > 0 {
+		segments = strings.Split(strings.ToLower(cleaned), "/")
+	}
+	if last := len(segments) - 1; last >= 0 {
+		segments[last] = strings.TrimSuffix(segments[last], ".git")
+	}
+
+	return templateRepoURL{Scheme: scheme, Host: strings.ToLower(u.Host), Segments: segments}, nil
+}
+
+// allowedTemplateSource checks the source's repository against the
+// allowlist by its scheme, host and each segment of its path, the branch
+// or tag is not restricted. An entry ending with "/" allows every
+// repository below it.
+func allowedTemplateSource(source string, allowlist []string) bool {
+	repo, _ := splitTemplateRef(source)
+	want, err := parseTemplateRepo(repo)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range allowlist {
+		allowed, err := parseTemplateRepo(entry)
+		if err != nil || allowed.Scheme != want.Scheme || allowed.Host != want.Host {
+			continue
+		}
+
+		if strings.HasSuffix(entry, "/") {
+			if len(want.Segments) > len(allowed.Segments) && reflect.DeepEqual(want.Segments[:len(allowed.Segments)], allowed.Segments) {
+				return true
+			}
+		} else if reflect.DeepEqual(want.Segments, allowed.Segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// readTemplateStore fetches the templates listed by the template store
+func readTemplateStore(storeURL string) ([]storeTemplate, error) {
+	res, err := sdk.HTTPClient().Get(storeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from %s: %d", storeURL, res.StatusCode)
+	}
+
+	templates := []storeTemplate{}
+	if err := json.NewDecoder(res.Body).Decode(&templates); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", storeURL, err.Error())
+	}
+	return templates, nil
+}
+
+// findStoreTemplate gives the repository of the template, built for
+// x86_64 which is the platform of the builder
+func findStoreTemplate(templates []storeTemplate, name string) (string, bool) {
+	for _, template := range templates {
+		if template.Template != name {
+			continue
+		}
+		if len(template.Platform) == 0 || strings.EqualFold(template.Platform, "x86_64") {
+			return template.Repo, true
+		}
+	}
+	return "", false
+}
+
+// fetchStackTemplates adds the templates listed under configuration in
+// stack.yml to those fetched by fetchTemplates. A template without a
+// source is looked up in the template store, and each repository must be
+// in the template_allowlist. A template which custom_templates already
+// fetched is not replaced. An error is returned for the configuration of
+// the builder, such as template_pins, rather than for stack.yml.
+func fetchStackTemplates(fetcher GitRepoFetcher, clonePath string, services *stack.Services) ([]stackError, error) {
+	templates := services.StackConfiguration.TemplateConfigs
+	if len(templates) == 0 {
+		return nil, nil
+	}
+
+	pins, pinErrors := templatePins()
+	if err := joinErrors(pinErrors); err != nil {
+		return nil, err
+	}
+
+	data, _ := ioutil.ReadFile(path.Join(clonePath, stackFileName))
+	line := readStackLines(data).sections["configuration"]
+	allowlist := templateAllowlist()
+
+	var store []storeTemplate
+	var storeErr error
+
+	errs := []stackError{}
+	for _, template := range templates {
+		if !templateName.MatchString(template.Name) {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("template name %q is invalid", template.Name)})
+			continue
+		}
+
+		source := template.Source
+		if len(source) == 0 {
+			if store == nil && storeErr == nil {
+				store, storeErr = readTemplateStore(templateStoreURL())
+			}
+			if storeErr != nil {
+				errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("unable to read the template store for %q: %s", template.Name, storeErr.Error())})
+				continue
+			}
+
+			repo, ok := findStoreTemplate(store, template.Name)
+			if !ok {
+				errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("template %q is not in the template store, give its source", template.Name)})
+				continue
+			}
+			source = repo
+		}
+
+		// The operator's pin is used over the ref given in stack.yml, so
+		// that upgrades of a template are rolled out deliberately
+		if ref, ok := pins[template.Name]; ok {
+			source = withTemplateRef(source, ref)
+		}
+
+		repo, _ := splitTemplateRef(source)
+		if _, err := parseTemplateRepo(repo); err != nil {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("template %q has an invalid source %q: %s", template.Name, source, err.Error())})
+			continue
+		}
+
+		if !allowedTemplateSource(source, allowlist) {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("template %q from %s is not in the template_allowlist", template.Name, source)})
+			continue
+		}
+
+		repoPath, err := cachedTemplateRepo(fetcher, source)
+		if err != nil {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("unable to fetch template %q from %s: %s", template.Name, source, err.Error())})
+			continue
+		}
+
+		if err := copyTemplate(repoPath, template.Name, clonePath); err != nil {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("template %q from %s: %s", template.Name, source, err.Error())})
+		}
+	}
+
+	return errs, nil
+}
+
+// pullTemplates copies the templates of a repository from
+// custom_templates, as faas-cli template pull would, except that a
+// template in template_pins is copied from the repository at its pin
+func pullTemplates(fetcher GitRepoFetcher, source, clonePath string, pins map[string]string) error {
+	repoPath, err := cachedTemplateRepo(fetcher, source)
+	if err != nil {
+		return err
+	}
+
+	folders, err := ioutil.ReadDir(path.Join(repoPath, "template"))
+	if err != nil {
+		return fmt.Errorf("the repository has no templates")
+	}
+
+	for _, folder := range folders {
+		name := folder.Name()
+		if !folder.IsDir() || !templateName.MatchString(name) {
+			continue
+		}
+
+		templatePath := repoPath
+		if ref, ok := pins[name]; ok {
+			if templatePath, err = cachedTemplateRepo(fetcher, withTemplateRef(source, ref)); err != nil {
+				return fmt.Errorf("template %s pinned to %s: %s", name, ref, err.Error())
+			}
+		}
+
+		if err := copyTemplate(templatePath, name, clonePath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cachedTemplateRepo gives the path of a shallow clone of the source,
+// which is cloned again once it is older than template_cache_ttl unless
+// it is pinned to a commit. Each clone is a new version of the
+// repository and the link named after the source is swapped to it, so
+// that a build copying from the version it was given is never affected
+// by another build refreshing the cache.
+func cachedTemplateRepo(fetcher GitRepoFetcher, source string) (string, error) {
+	sum := sha1.Sum([]byte(source))
+	key := hex.EncodeToString(sum[:])
+	linkPath := path.Join(templateCacheDir, key)
+
+	// A commit can't change, so a repository pinned to one is kept
+	repo, ref := splitTemplateRef(source)
+	if repoPath, err := filepath.EvalSymlinks(linkPath); err == nil {
+		if info, err := os.Stat(repoPath); err == nil && (templateSHA.MatchString(ref) || time.Since(info.ModTime()) < templateCacheTTL()) {
+			log.Printf("Using cached template repository for %s", source)
+			return repoPath, nil
+		}
+	}
+
+	if err := os.MkdirAll(templateCacheDir, 0700); err != nil {
+		return "", err
+	}
+
+	version, err := ioutil.TempDir(templateCacheDir, key+"-")
+	if err != nil {
+		return "", err
+	}
+
+	if err := cloneTemplateRef(fetcher, version, repo, ref); err != nil {
+		os.RemoveAll(version)
+		return "", err
+	}
+
+	repoPath := path.Join(version, "repo")
+	now := time.Now()
+	os.Chtimes(repoPath, now, now)
+
+	// The link is made aside and renamed over the current one, which
+	// replaces it in one step. A cache from before versions were kept
+	// has a folder in its place.
+	if info, err := os.Lstat(linkPath); err == nil && info.IsDir() {
+		os.RemoveAll(linkPath)
+	}
+
+	nextLink := version + ".link"
+	if err := os.Symlink(path.Join(path.Base(version), "repo"), nextLink); err != nil {
+		os.RemoveAll(version)
+		return "", err
+	}
+	if err := os.Rename(nextLink, linkPath); err != nil {
+		os.Remove(nextLink)
+		os.RemoveAll(version)
+		return "", err
+	}
+
+	pruneTemplateVersions(key, version)
+
+	return repoPath, nil
+}
+
+// templateVersionGrace is how long a version of a template repository
+// is kept once it is stale, so that a build which was given it before
+// it was replaced can finish copying from it
+const templateVersionGrace = 10 * time.Minute
+
+// pruneTemplateVersions removes the versions of a repository other than
+// the current one which have been stale for templateVersionGrace, the
+// last build given one of them was given it before it became stale
+func pruneTemplateVersions(key, current string) {
+	versions, _ := filepath.Glob(path.Join(templateCacheDir, key+"-*"))
+	for _, version := range versions {
+		info, err := os.Lstat(version)
+		if err != nil || !info.IsDir() || version == current {
+			continue
+		}
+
+		if time.Since(info.ModTime()) > templateCacheTTL()+templateVersionGrace {
+			os.RemoveAll(version)
+		}
+	}
+}
+
+// copyTemplate copies the template folder called name from a template
+// repository into the clone's template folder
+func copyTemplate(repoPath, name, clonePath string) error {
+	src := path.Join(repoPath, "template", name)
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		return fmt.Errorf("the repository has no template called %s", name)
+	}
+
+	dst := path.Join(clonePath, "template", name)
+	if _, err := os.Stat(dst); err == nil {
+		log.Printf("Template %s is already fetched, not replacing it", name)
+		return nil
+	}
+
+	return copyDir(src, dst)
+}
+
+// copyDir copies the folders and regular files of src, links and other
+// files are left out so that nothing outside of the template is copied
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		in, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, in)
+		return err
+	})
+}
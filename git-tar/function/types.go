@@ -3,5 +3,6 @@ package function
 type buildConfig struct {
 	Ref       string            `json:"ref"`
 	Frontend  string            `json:"frontend,omitempty"`
+	Language  string            `json:"language,omitempty"`
 	BuildArgs map[string]string `json:"buildArgs,omitempty"`
 }
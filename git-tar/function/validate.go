@@ -0,0 +1,327 @@
+package function
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/faas-cli/stack"
+	"github.com/openfaas/openfaas-cloud/sdk"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// stackFileName is the stack file git-tar builds from
+const stackFileName = "stack.yml"
+
+// maxServiceName is the longest name a function can be deployed with,
+// the name of its Kubernetes service is prefixed with the owner
+const maxServiceName = 63
+
+var (
+	// functionName is a DNS-1123 label, as needed by the function's service
+	functionName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+	// imageReference is a Docker image reference with an optional
+	// registry, port and tag
+	imageReference = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?(:[0-9]+)?/)?[a-z0-9]+([._-]+[a-z0-9]+)*(/[a-z0-9]+([._-]+[a-z0-9]+)*)*(:[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?$`)
+
+	// imageName is the last part of an image reference with its tag, the
+	// rest is replaced by the registry the function is pushed to
+	imageName = regexp.MustCompile(`^[a-z0-9]+([._-]+[a-z0-9]+)*(:[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?$`)
+
+	// yamlLine finds the line number in the errors of the yaml parser
+	yamlLine = regexp.MustCompile(`^(?:yaml: )?line ([0-9]+): (.*)$`)
+
+	// duplicateKey finds the key in the parser's error for a duplicate
+	duplicateKey = regexp.MustCompile(`^key "(.*)" already set in map$`)
+)
+
+// stackError is a problem found in stack.yml, Line is 0 when it is not
+// known
+type stackError struct {
+	Line    int
+	Message string
+}
+
+func (e stackError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", stackFileName, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", stackFileName, e.Message)
+}
+
+// stackLines gives the line of each function and of its fields
+type stackLines struct {
+	sections  map[string]int
+	functions map[string]int
+	fields    map[string]map[string]int
+}
+
+// readStackLines reads the keys of stack.yml by their indentation, since
+// the yaml parser gives no positions after unmarshalling
+func readStackLines(data []byte) stackLines {
+	lines := stackLines{
+		sections:  map[string]int{},
+		functions: map[string]int{},
+		fields:    map[string]map[string]int{},
+	}
+
+	section, current := "", ""
+	nameIndent, fieldIndent := -1, -1
+
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") || !strings.Contains(trimmed, ":") {
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		key := strings.Trim(strings.TrimSpace(trimmed[:strings.Index(trimmed, ":")]), `"'`)
+
+		if indent == 0 {
+			section, current = key, ""
+			nameIndent, fieldIndent = -1, -1
+			lines.sections[key] = i + 1
+			continue
+		}
+
+		if section != "functions" {
+			continue
+		}
+
+		if nameIndent == -1 || indent <= nameIndent {
+			nameIndent, fieldIndent = indent, -1
+			current = key
+			if _, ok := lines.functions[key]; !ok {
+				lines.functions[key] = i + 1
+				lines.fields[key] = map[string]int{}
+			}
+			continue
+		}
+
+		if fieldIndent == -1 {
+			fieldIndent = indent
+		}
+		if indent == fieldIndent {
+			if _, ok := lines.fields[current][key]; !ok {
+				lines.fields[current][key] = i + 1
+			}
+		}
+	}
+
+	return lines
+}
+
+// line gives the line of the function's field, or of the function when
+// the field is missing
+func (l stackLines) line(function, field string) int {
+	if line, ok := l.fields[function][field]; ok {
+		return line
+	}
+	return l.functions[function]
+}
+
+// validateStack checks stack.yml before it is parsed and built, so that
+// a mistake is reported with its line rather than as a failed build
+// later in the pipeline. Unknown and repeated fields, which faas-cli
+// ignores, are given as warnings so that a stack which builds today is
+// not failed, except for a repeated function.
+func validateStack(clonePath string, owner string) ([]stackError, []stackError) {
+	data, err := ioutil.ReadFile(path.Join(clonePath, stackFileName))
+	if err != nil {
+		return []stackError{{Message: fmt.Sprintf("cannot read: %s", err.Error())}}, nil
+	}
+
+	services := stack.Services{}
+	if err := yaml.Unmarshal(data, &services); err != nil {
+		return yamlErrors(data, err), nil
+	}
+
+	lines := readStackLines(data)
+	errs := []stackError{}
+	warnings := []stackError{}
+
+	if err := yaml.UnmarshalStrict(data, &stack.Services{}); err != nil {
+		for _, strictErr := range yamlErrors(data, err) {
+			if key := duplicateKey.FindStringSubmatch(strictErr.Message); key != nil && lines.functions[key[1]] > 0 {
+				errs = append(errs, stackError{Line: strictErr.Line, Message: fmt.Sprintf("function %q is defined more than once", key[1])})
+			} else {
+				warnings = append(warnings, strictErr)
+			}
+		}
+	}
+
+	if services.Provider.Name != "openfaas" {
+		errs = append(errs, stackError{
+			Line:    lines.sections["provider"],
+			Message: fmt.Sprintf(`provider.name must be "openfaas", but was %q`, services.Provider.Name),
+		})
+	}
+
+	if len(services.Version) > 0 && !stack.IsValidSchemaVersion(services.Version) {
+		errs = append(errs, stackError{
+			Line:    lines.sections["version"],
+			Message: fmt.Sprintf("version must be one of %s, but was %q", strings.Join(stack.ValidSchemaVersions, ", "), services.Version),
+		})
+	}
+
+	if len(services.Functions) == 0 {
+		errs = append(errs, stackError{Line: lines.sections["functions"], Message: "no functions are defined"})
+	}
+
+	names := []string{}
+	for name := range services.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := map[string]string{}
+	for _, name := range names {
+		function := services.Functions[name]
+		line := lines.line(name, "")
+
+		if !functionName.MatchString(name) {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("function name %q may only have lowercase letters, numbers and dashes", name)})
+		} else if serviceName := sdk.FormatServiceName(owner, name); len(serviceName) > maxServiceName {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("function name %q is too long, %s must be at most %d characters", name, serviceName, maxServiceName)})
+		}
+
+		if other, ok := seen[strings.ToLower(name)]; ok {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("function %q has the same name as %q", name, other)})
+		}
+		seen[strings.ToLower(name)] = name
+
+		if len(function.Image) == 0 {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("function %q has no image", name)})
+		} else if !validImage(function) {
+			errs = append(errs, stackError{Line: lines.line(name, "image"), Message: fmt.Sprintf("function %q has an invalid image: %q", name, function.Image)})
+		}
+
+		if function.SkipBuild {
+			continue
+		}
+
+		if len(function.Language) == 0 {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("function %q has no lang, or skip_build for a pre-built image", name)})
+		}
+
+		if len(function.Handler) == 0 {
+			errs = append(errs, stackError{Line: line, Message: fmt.Sprintf("function %q has no handler", name)})
+			continue
+		}
+
+		handlerLine := lines.line(name, "handler")
+		handler := filepath.Clean(function.Handler)
+		if filepath.IsAbs(handler) || handler == ".." || strings.HasPrefix(handler, ".."+string(filepath.Separator)) {
+			errs = append(errs, stackError{Line: handlerLine, Message: fmt.Sprintf("function %q has a handler outside of the repository: %q", name, function.Handler)})
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(clonePath, handler)); err != nil || !info.IsDir() {
+			errs = append(errs, stackError{Line: handlerLine, Message: fmt.Sprintf("function %q has a handler folder which does not exist: %q", name, function.Handler)})
+		}
+	}
+
+	return errs, warnings
+}
+
+// validImage checks the whole reference of a pre-built image, and only
+// the name and tag of an image which is built
+func validImage(function stack.Function) bool {
+	if function.SkipBuild {
+		return imageReference.MatchString(function.Image)
+	}
+	return imageName.MatchString(function.Image[strings.LastIndex(function.Image, "/")+1:])
+}
+
+// validateTemplates checks the language of each function which is built
+// has a template, once they have been fetched
+func validateTemplates(clonePath string, services *stack.Services) []stackError {
+	templates, err := existingTemplates(clonePath)
+	if err != nil {
+		return []stackError{{Message: err.Error()}}
+	}
+
+	available := map[string]bool{}
+	for _, template := range templates {
+		available[template] = true
+	}
+	sort.Strings(templates)
+
+	data, _ := ioutil.ReadFile(path.Join(clonePath, stackFileName))
+	lines := readStackLines(data)
+
+	names := []string{}
+	for name := range services.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := []stackError{}
+	for _, name := range names {
+		function := services.Functions[name]
+		if function.SkipBuild || available[function.Language] {
+			continue
+		}
+
+		errs = append(errs, stackError{
+			Line:    lines.line(name, "lang"),
+			Message: fmt.Sprintf("unknown language template %q for function %q, available: %s", function.Language, name, strings.Join(templates, ", ")),
+		})
+	}
+
+	return errs
+}
+
+// yamlErrors splits the errors of the yaml parser, which give the line
+// of each field that is unknown or of the wrong type
+func yamlErrors(data []byte, err error) []stackError {
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+
+	errs := []stackError{}
+	for _, message := range messages {
+		match := yamlLine.FindStringSubmatch(strings.TrimSpace(message))
+		if match == nil {
+			errs = append(errs, stackError{Message: strings.TrimPrefix(message, "yaml: ")})
+			continue
+		}
+
+		line, _ := strconv.Atoi(match[1])
+		if key := duplicateKey.FindStringSubmatch(match[2]); key != nil {
+			line = keyLine(data, key[1], line)
+		}
+		errs = append(errs, stackError{Line: line, Message: match[2]})
+	}
+	return errs
+}
+
+// keyLine finds the line of key at or above line, since the parser gives
+// the line of a duplicate key's value, which may be below it
+func keyLine(data []byte, key string, line int) int {
+	lines := strings.Split(string(data), "\n")
+	for i := line - 1; i >= 0 && i < len(lines); i-- {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, key+":") || strings.HasPrefix(trimmed, strconv.Quote(key)+":") {
+			return i + 1
+		}
+	}
+	return line
+}
+
+// summariseStackErrors gives the first error or warning for the commit
+// status, which is limited in length, with a count of the others
+func summariseStackErrors(errs []stackError) string {
+	msg := errs[0].Error()
+	if len(errs) > 1 {
+		msg = fmt.Sprintf("%s (and %d more)", msg, len(errs)-1)
+	}
+	return msg
+}
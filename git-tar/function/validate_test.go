@@ -0,0 +1,259 @@
+package function
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// writeStack writes a clone with the stack file and a folder for each of
+// the handlers
+func writeStack(t *testing.T, stackYAML string, handlers ...string) string {
+	clonePath, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(clonePath, stackFileName), []byte(stackYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, handler := range handlers {
+		if err := os.MkdirAll(path.Join(clonePath, handler), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return clonePath
+}
+
+func errorMessages(errs []stackError) []string {
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return messages
+}
+
+func Test_validateStack_Valid(t *testing.T) {
+	clonePath := writeStack(t, `version: 1.0
+provider:
+  name: openfaas
+  gateway: http://127.0.0.1:8080
+
+functions:
+  fn1:
+    lang: go
+    handler: ./fn1
+    image: ghcr.io/${OWNER:-alexellis}/fn1:latest
+    environment:
+      write_debug: true
+  figlet:
+    image: functions/figlet:0.13.0
+    skip_build: true
+`, "fn1")
+	defer os.RemoveAll(clonePath)
+
+	if errs, warnings := validateStack(clonePath, "alexellis"); len(errs) > 0 || len(warnings) > 0 {
+		t.Errorf("want no errors or warnings, got: %v, %v", errorMessages(errs), errorMessages(warnings))
+	}
+}
+
+func Test_validateStack_Errors(t *testing.T) {
+	tests := []struct {
+		title    string
+		stack    string
+		handlers []string
+		want     []string
+	}{
+		{
+			title: "duplicate function",
+			stack: `provider:
+  name: openfaas
+functions:
+  fn1:
+    lang: go
+    handler: ./fn1
+    image: fn1:latest
+  fn1:
+    lang: node12
+    handler: ./fn1
+    image: fn1:latest
+`,
+			handlers: []string{"fn1"},
+			want:     []string{`stack.yml:8: function "fn1" is defined more than once`},
+		},
+		{
+			title: "provider and version",
+			stack: `version: 2.0
+provider:
+  name: faas
+functions:
+  fn1:
+    lang: go
+    handler: ./fn1
+    image: fn1:latest
+`,
+			handlers: []string{"fn1"},
+			want: []string{
+				`stack.yml:2: provider.name must be "openfaas", but was "faas"`,
+				`stack.yml:1: version must be one of 1.0, but was "2.0"`,
+			},
+		},
+		{
+			title: "invalid names",
+			stack: `provider:
+  name: openfaas
+functions:
+  Fn1:
+    lang: go
+    handler: ./fn1
+    image: fn1:latest
+  fn1:
+    lang: go
+    handler: ./fn1
+    image: fn1:latest
+  a-function-with-a-name-which-is-far-too-long-to-deploy:
+    lang: go
+    handler: ./fn1
+    image: fn1:latest
+`,
+			handlers: []string{"fn1"},
+			want: []string{
+				`stack.yml:4: function name "Fn1" may only have lowercase letters, numbers and dashes`,
+				`stack.yml:12: function name "a-function-with-a-name-which-is-far-too-long-to-deploy" is too long, alexellis-a-function-with-a-name-which-is-far-too-long-to-deploy must be at most 63 characters`,
+				`stack.yml:8: function "fn1" has the same name as "Fn1"`,
+			},
+		},
+		{
+			title: "invalid images",
+			stack: `provider:
+  name: openfaas
+functions:
+  fn1:
+    lang: go
+    handler: ./fn1
+    image: alexellis/Fn1:latest
+  fn2:
+    lang: go
+    handler: ./fn2
+  figlet:
+    skip_build: true
+    image: functions/figlet:0.13.0:latest
+`,
+			handlers: []string{"fn1", "fn2"},
+			want: []string{
+				`stack.yml:13: function "figlet" has an invalid image: "functions/figlet:0.13.0:latest"`,
+				`stack.yml:7: function "fn1" has an invalid image: "alexellis/Fn1:latest"`,
+				`stack.yml:8: function "fn2" has no image`,
+			},
+		},
+		{
+			title: "handlers",
+			stack: `provider:
+  name: openfaas
+functions:
+  fn1:
+    lang: go
+    handler: ./fn1
+    image: fn1:latest
+  fn2:
+    lang: go
+    handler: ../fn2
+    image: fn2:latest
+  fn3:
+    image: fn3:latest
+`,
+			want: []string{
+				`stack.yml:6: function "fn1" has a handler folder which does not exist: "./fn1"`,
+				`stack.yml:10: function "fn2" has a handler outside of the repository: "../fn2"`,
+				`stack.yml:12: function "fn3" has no lang, or skip_build for a pre-built image`,
+				`stack.yml:12: function "fn3" has no handler`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			clonePath := writeStack(t, test.stack, test.handlers...)
+			defer os.RemoveAll(clonePath)
+
+			errs, _ := validateStack(clonePath, "alexellis")
+			got := errorMessages(errs)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("want: %q, got: %q", test.want, got)
+			}
+		})
+	}
+}
+
+func Test_validateStack_Warnings(t *testing.T) {
+	clonePath := writeStack(t, `provider:
+  name: openfaas
+functions:
+  fn1:
+    lang: go
+    handler: ./fn1
+    image: fn1:latest
+    enviroment:
+      write_debug: true
+    environment:
+      write_debug: true
+      write_debug: false
+`, "fn1")
+	defer os.RemoveAll(clonePath)
+
+	errs, warnings := validateStack(clonePath, "alexellis")
+	if len(errs) > 0 {
+		t.Errorf("want no errors for fields faas-cli ignores, got: %q", errorMessages(errs))
+	}
+
+	got := errorMessages(warnings)
+	want := []string{
+		"stack.yml:8: field enviroment not found in type stack.Function",
+		`stack.yml:12: key "write_debug" already set in map`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
+
+func Test_validateTemplates_Line(t *testing.T) {
+	clonePath := writeStack(t, `provider:
+  name: openfaas
+functions:
+  fn1:
+    lang: go
+    handler: ./fn1
+    image: fn1:latest
+  fn2:
+    handler: ./fn2
+    image: fn2:latest
+    lang: smalltalk
+`, "fn1", "fn2", "template/go")
+	defer os.RemoveAll(clonePath)
+
+	services, err := parseYAML(clonePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := errorMessages(validateTemplates(clonePath, services))
+	want := []string{`stack.yml:11: unknown language template "smalltalk" for function "fn2", available: go`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
+
+func Test_summariseStackErrors(t *testing.T) {
+	errs := []stackError{
+		{Line: 4, Message: "first"},
+		{Message: "second"},
+		{Line: 9, Message: "third"},
+	}
+
+	want := "stack.yml:4: first (and 2 more)"
+	if got := summariseStackErrors(errs); got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
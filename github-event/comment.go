@@ -0,0 +1,88 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// resumeCommand is commented on a commit or pull request to resume a
+// repository's pipeline once buildshiprun has paused it for failing
+// too many builds in a row
+const resumeCommand = "/ofc resume"
+
+// CommentEvent is the part of a commit_comment or issue_comment event
+// which is read for commands
+type CommentEvent struct {
+	Action  string `json:"action"`
+	Comment struct {
+		Body              string `json:"body"`
+		AuthorAssociation string `json:"author_association"`
+		User              struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"comment"`
+	Repository sdk.PushEventRepository `json:"repository"`
+}
+
+// isResumeCommand is true when the first line of a comment is the
+// resume command
+func isResumeCommand(body string) bool {
+	firstLine := strings.SplitN(strings.TrimSpace(body), "\n", 2)[0]
+	return strings.EqualFold(strings.TrimSpace(firstLine), resumeCommand)
+}
+
+// canResume is true for the owner of the repository, a member of its
+// organization or a collaborator
+func canResume(authorAssociation string) bool {
+	switch authorAssociation {
+	case "OWNER", "MEMBER", "COLLABORATOR":
+		return true
+	}
+	return false
+}
+
+// handleComment asks buildshiprun to resume the repository's pipeline
+// for a resume command, any other comment is ignored
+func handleComment(req []byte, eventHeader string) sdk.Response {
+	event := CommentEvent{}
+	if err := json.Unmarshal(req, &event); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, err.Error())
+	}
+
+	if event.Action != "created" || !isResumeCommand(event.Comment.Body) {
+		return sdk.Skipped(fmt.Sprintf("Message received with event: %s", eventHeader))
+	}
+
+	auditEvent := sdk.AuditEvent{
+		Owner:  event.Repository.Owner.Login,
+		Repo:   event.Repository.Name,
+		Source: Source,
+	}
+
+	user := event.Comment.User.Login
+	if !canResume(event.Comment.AuthorAssociation) {
+		auditEvent.Message = fmt.Sprintf("%s is not allowed to resume the pipeline of %s", user, event.Repository.FullName)
+		sdk.PostAudit(auditEvent)
+		return sdk.Rejected(http.StatusForbidden, auditEvent.Message)
+	}
+
+	body, _ := json.Marshal(sdk.ResumePipelineRequest{
+		RepoPath: event.Repository.FullName,
+		User:     user,
+	})
+
+	if err := invokeBuildshiprun("resume-pipeline", body); err != nil {
+		auditEvent.Message = fmt.Sprintf("unable to resume the pipeline of %s: %s", event.Repository.FullName, err.Error())
+		sdk.PostAudit(auditEvent)
+		return sdk.Failed(http.StatusBadGateway, auditEvent.Message)
+	}
+
+	auditEvent.Message = fmt.Sprintf("%s asked to resume the pipeline of %s", user, event.Repository.FullName)
+	sdk.PostAudit(auditEvent)
+
+	return sdk.Accepted(auditEvent.Message)
+}
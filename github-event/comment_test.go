@@ -0,0 +1,60 @@
+package function
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_isResumeCommand(t *testing.T) {
+	cases := map[string]bool{
+		"/ofc resume":                      true,
+		"  /OFC Resume\r\nfixed the build": true,
+		"/ofc resume please":               false,
+		"I'll /ofc resume later":           false,
+		"":                                 false,
+	}
+
+	for body, want := range cases {
+		if got := isResumeCommand(body); got != want {
+			t.Errorf("%q: want %t, got %t", body, want, got)
+		}
+	}
+}
+
+func Test_handleComment(t *testing.T) {
+	defer setupSecrets(t)()
+
+	var gotQuery string
+	var gotReq sdk.ResumePipelineRequest
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/async-function/buildshiprun" {
+			gotQuery = r.URL.RawQuery
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &gotReq)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	comment := `{"action":"created","comment":{"body":"/ofc resume","author_association":"%s","user":{"login":"alexellis"}},"repository":{"name":"fn","full_name":"alexellis/fn","owner":{"login":"alexellis"}}}`
+
+	got := handleComment([]byte(strings.Replace(comment, "%s", "NONE", 1)), "commit_comment")
+	if gotQuery != "" || got.Code != http.StatusForbidden {
+		t.Errorf("want a comment from outside the repository rejected, got %+v", got)
+	}
+
+	got = handleComment([]byte(strings.Replace(comment, "%s", "OWNER", 1)), "commit_comment")
+	if got.Status != sdk.ResponseAccepted || gotQuery != "action=resume-pipeline" || gotReq.RepoPath != "alexellis/fn" || gotReq.User != "alexellis" {
+		t.Errorf("want the pipeline resumed, got %q %+v", gotQuery, gotReq)
+	}
+}
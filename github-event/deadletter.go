@@ -0,0 +1,193 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// retryPolicy controls how many times an event is forwarded before it
+// is written to the dead-letter store. GitHub gives up on a webhook
+// after 10 seconds so the defaults are kept short.
+type retryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// getRetryPolicy reads forward_retries, forward_retry_backoff and
+// forward_retry_max_backoff
+func getRetryPolicy() retryPolicy {
+	policy := retryPolicy{
+		Attempts:   3,
+		Backoff:    getDuration("forward_retry_backoff", 500*time.Millisecond),
+		MaxBackoff: getDuration("forward_retry_max_backoff", 2*time.Second),
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("forward_retries")); err == nil && val > 0 {
+		policy.Attempts = val
+	}
+
+	return policy
+}
+
+// delay is the exponential backoff for the given attempt (0-based)
+// with up to 50% jitter added
+func (p retryPolicy) delay(attempt int) time.Duration {
+	backoff := p.Backoff
+	for i := 0; i < attempt; i++ {
+		backoff = backoff * 2
+		if backoff >= p.MaxBackoff {
+			backoff = p.MaxBackoff
+			break
+		}
+	}
+
+	if backoff <= 0 {
+		return 0
+	}
+
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+}
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if val, exists := os.LookupEnv(key); exists && len(val) > 0 {
+		duration, err := time.ParseDuration(val)
+		if err != nil {
+			log.Printf("unable to parse %s: %s", key, err.Error())
+			return defaultValue
+		}
+		return duration
+	}
+	return defaultValue
+}
+
+// retryable is true for statuses which may succeed if tried again,
+// forward reports a transport error as a 500
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// forwardWithRetry forwards the event until it is accepted, a
+// non-retryable status is returned or the attempts are exhausted
+func forwardWithRetry(req []byte, function string, headers map[string]string, policy retryPolicy) (string, int, int, error) {
+	var (
+		body       string
+		statusCode int
+		err        error
+	)
+
+	attempt := 0
+	for attempt < policy.Attempts {
+		if attempt > 0 {
+			wait := policy.delay(attempt - 1)
+			log.Printf("Retrying %s in %s, last status: %d", function, wait, statusCode)
+			time.Sleep(wait)
+		}
+
+		body, statusCode, err = forward(req, function, headers)
+		attempt++
+
+		if err == nil || !retryable(statusCode) {
+			break
+		}
+	}
+
+	return body, statusCode, attempt, err
+}
+
+// writeDeadLetter stores an event which could not be forwarded via
+// pipeline-log so that it can be replayed, and alerts operators
+func writeDeadLetter(event *sdk.PushEvent, function string, headers map[string]string, req []byte, attempts int, forwardErr error) error {
+	deadLetter := sdk.DeadLetter{
+		Function: function,
+		Headers:  headers,
+		Payload:  req,
+		Attempts: attempts,
+		Error:    forwardErr.Error(),
+		Failed:   clock.Now(),
+	}
+
+	deadLetterBytes, _ := json.Marshal(deadLetter)
+
+	p := sdk.PipelineLog{
+		RepoPath:  event.Repository.FullName,
+		CommitSHA: event.AfterCommitID,
+		Function:  function,
+		Source:    sdk.DeadLetterSource,
+		Data:      string(deadLetterBytes),
+	}
+
+	if err := writeRecord(p); err != nil {
+		return err
+	}
+
+	sdk.PostAudit(sdk.AuditEvent{
+		Source:  Source,
+		Owner:   event.Repository.Owner.Login,
+		Repo:    event.Repository.Name,
+		Message: fmt.Sprintf("dead-letter: %s after %d attempts (sha: %s): %s", function, attempts, event.AfterCommitID, forwardErr.Error()),
+	}.Trace(event.AfterCommitID, event.Delivery))
+
+	return nil
+}
+
+// replay forwards a dead letter again, the request must be signed with
+// the payload-secret, i.e. by an operator
+func replay(req []byte) sdk.Response {
+	if err := sdk.ValidHMAC(&req, "payload-secret", os.Getenv("Http_X_Cloud_Signature")); err != nil {
+		return sdk.Rejected(http.StatusUnauthorized, fmt.Sprintf("replay: %s", err.Error()))
+	}
+
+	replayReq := sdk.ReplayRequest{}
+	if err := json.Unmarshal(req, &replayReq); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("replay: unable to parse request: %s", err.Error()))
+	}
+
+	if len(replayReq.Function) == 0 {
+		replayReq.Function = "github-push"
+	}
+
+	deadLetter, err := readDeadLetter(replayReq)
+	if err != nil {
+		return sdk.Failed(http.StatusNotFound, fmt.Sprintf("replay: %s", err.Error()))
+	}
+
+	body, statusCode, attempts, err := forwardWithRetry(deadLetter.Payload, deadLetter.Function, deadLetter.Headers, getRetryPolicy())
+	if err != nil {
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("replay: [%s]: %d after %d attempts, %s", deadLetter.Function, statusCode, attempts, err.Error()))
+	}
+
+	sdk.PostAudit(sdk.AuditEvent{
+		Source:  Source,
+		Message: fmt.Sprintf("replayed dead-letter: %s for %s (sha: %s)", deadLetter.Function, replayReq.RepoPath, replayReq.CommitSHA),
+	})
+
+	return forwarded(deadLetter.Function, statusCode, body)
+}
+
+func readDeadLetter(replayReq sdk.ReplayRequest) (*sdk.DeadLetter, error) {
+	body, err := sdk.ReadPipelineLog(os.Getenv("gateway_url"), sdk.PipelineLog{
+		RepoPath:  replayReq.RepoPath,
+		CommitSHA: replayReq.CommitSHA,
+		Function:  replayReq.Function,
+		Source:    sdk.DeadLetterSource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetter := sdk.DeadLetter{}
+	if err := json.Unmarshal(body, &deadLetter); err != nil || len(deadLetter.Payload) == 0 {
+		return nil, fmt.Errorf("no dead-letter found for %s %s", replayReq.RepoPath, replayReq.CommitSHA)
+	}
+
+	return &deadLetter, nil
+}
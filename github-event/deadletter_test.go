@@ -0,0 +1,127 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func setupSecrets(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(path.Join(dir, "payload-secret"), []byte("secret"), 0600)
+	os.Setenv("secret_mount_path", dir)
+
+	return func() {
+		os.Unsetenv("secret_mount_path")
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_forwardWithRetry_RetriesUntilAccepted(t *testing.T) {
+	defer setupSecrets(t)()
+
+	calls := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	policy := retryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	_, statusCode, attempts, err := forwardWithRetry([]byte("{}"), "github-push", map[string]string{}, policy)
+
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if statusCode != http.StatusOK || attempts != 3 {
+		t.Errorf("want 200 after 3 attempts, got %d after %d", statusCode, attempts)
+	}
+}
+
+func Test_forwardWithRetry_StopsOnClientError(t *testing.T) {
+	defer setupSecrets(t)()
+
+	calls := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	policy := retryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	_, _, attempts, err := forwardWithRetry([]byte("{}"), "github-push", map[string]string{}, policy)
+
+	if err == nil {
+		t.Fatalf("want error")
+	}
+	if attempts != 1 || calls != 1 {
+		t.Errorf("want a single attempt, got %d", attempts)
+	}
+}
+
+func Test_writeDeadLetter(t *testing.T) {
+	defer setupSecrets(t)()
+
+	var stored sdk.PipelineLog
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/function/pipeline-log" {
+			json.NewDecoder(r.Body).Decode(&stored)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	event := &sdk.PushEvent{AfterCommitID: "af6db"}
+	event.Repository.FullName = "alexellis/super-cake"
+
+	err := writeDeadLetter(event, "github-push", map[string]string{"X-GitHub-Event": "push"}, []byte(`{"ref":"master"}`), 3, fmt.Errorf("context deadline exceeded"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Source != sdk.DeadLetterSource || stored.RepoPath != "alexellis/super-cake" || stored.CommitSHA != "af6db" {
+		t.Errorf("unexpected pipeline log: %+v", stored)
+	}
+
+	deadLetter := sdk.DeadLetter{}
+	json.Unmarshal([]byte(stored.Data), &deadLetter)
+	if string(deadLetter.Payload) != `{"ref":"master"}` || deadLetter.Headers["X-GitHub-Event"] != "push" {
+		t.Errorf("want payload and headers stored, got %+v", deadLetter)
+	}
+}
+
+func Test_replay_RequiresSignature(t *testing.T) {
+	defer setupSecrets(t)()
+
+	os.Setenv("Http_X_Cloud_Signature", "sha1=invalid")
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	got := replay([]byte(`{"repoPath":"alexellis/super-cake","commitSHA":"af6db"}`))
+	if got.Reason != "replay: unable to validate HMAC" || got.Code != http.StatusUnauthorized {
+		t.Errorf("want HMAC error, got %+v", got)
+	}
+}
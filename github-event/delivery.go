@@ -0,0 +1,205 @@
+package function
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// deliveryRepoPath is where deliveries are kept in pipeline-log, each
+// under its key
+const deliveryRepoPath = "system/deliveries"
+
+// deliveryRecord is stored for each accepted webhook delivery
+type deliveryRecord struct {
+	Delivery string    `json:"delivery"`
+	Event    string    `json:"event"`
+	Digest   string    `json:"digest"`
+	Received time.Time `json:"received"`
+}
+
+// deliveryTTL reads delivery_ttl, how long a delivery is remembered for
+// (default 24h), de-duplication is on unless dedupe_deliveries is false
+func deliveryTTL() time.Duration {
+	if val, ok := os.LookupEnv("dedupe_deliveries"); ok && (val == "false" || val == "0") {
+		return 0
+	}
+	return getDuration("delivery_ttl", 24*time.Hour)
+}
+
+// pushedAt reads repository.pushed_at of a push event, which is covered
+// by the signature. Only a push gives it as the Unix time of the push,
+// other events give the time of the repository's last push.
+func pushedAt(req []byte) (time.Time, bool) {
+	event := struct {
+		Repository struct {
+			PushedAt json.Number `json:"pushed_at"`
+		} `json:"repository"`
+	}{}
+	if err := json.Unmarshal(req, &event); err != nil {
+		return time.Time{}, false
+	}
+
+	seconds, err := event.Repository.PushedAt.Int64()
+	if err != nil || seconds <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// stalePush is true for a push made longer ago than the ttl, which would
+// no longer be found as a duplicate, so that a captured payload can't be
+// replayed once its record has expired
+func stalePush(eventHeader string, req []byte, ttl time.Duration, now time.Time) bool {
+	if eventHeader != "push" {
+		return false
+	}
+
+	pushed, ok := pushedAt(req)
+	return ok && now.Sub(pushed) >= ttl
+}
+
+// stale audits a push refused for its age and returns the response for
+// the caller
+func stale(eventHeader string, deliveryID string, ttl time.Duration) sdk.Response {
+	msg := fmt.Sprintf("stale %s delivery %s refused, it was pushed more than %s ago", eventHeader, deliveryID, ttl)
+
+	sdk.PostAudit(sdk.AuditEvent{
+		Message:    msg,
+		Source:     Source,
+		DeliveryID: deliveryID,
+	})
+
+	return sdk.Rejected(http.StatusBadRequest, msg)
+}
+
+// deliveryKeys gives the keys a delivery is recorded under, its GUID
+// from X-GitHub-Delivery and the digest of the body. The GUID is not
+// covered by the signature, so a replayed payload is found by its
+// digest even when the GUID has been changed.
+func deliveryKeys(deliveryID string, digest string) []string {
+	keys := []string{}
+	if len(deliveryID) > 0 {
+		keys = append(keys, "id-"+deliveryID)
+	}
+	return append(keys, "sha256-"+digest)
+}
+
+func bodyDigest(req []byte) string {
+	sum := sha256.Sum256(req)
+	return hex.EncodeToString(sum[:])
+}
+
+// seenDelivery returns the earlier record of the delivery when it was
+// accepted within the ttl, otherwise it records the delivery. A store
+// which can't be read or written is logged and the delivery accepted,
+// so that pipeline-log being down does not stop deployments. This is
+// not complete replay protection: a replay is only dropped while the
+// store can be reached.
+//
+// pipeline-log has no conditional write, so the check and the write are
+// not atomic: two copies of a delivery which arrive together may both
+// be accepted. Delivery is at-least-once, the de-duplication drops
+// redeliveries and replays which come later, and a commit which is
+// built twice is deployed to the same image.
+func seenDelivery(deliveryID string, eventHeader string, req []byte, ttl time.Duration, now time.Time) *deliveryRecord {
+	digest := bodyDigest(req)
+	keys := deliveryKeys(deliveryID, digest)
+
+	for _, key := range keys {
+		record, err := readDelivery(key)
+		if err != nil {
+			log.Printf("unable to read delivery %s: %s", key, err.Error())
+			continue
+		}
+		if record != nil && now.Sub(record.Received) < ttl {
+			return record
+		}
+	}
+
+	record := deliveryRecord{
+		Delivery: deliveryID,
+		Event:    eventHeader,
+		Digest:   digest,
+		Received: now,
+	}
+	for _, key := range keys {
+		if err := writeDelivery(key, record); err != nil {
+			log.Printf("unable to record delivery %s: %s", key, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// forgetDelivery expires the record of a delivery which could not be
+// forwarded, so that GitHub's redelivery of it is accepted
+func forgetDelivery(deliveryID string, req []byte) {
+	for _, key := range deliveryKeys(deliveryID, bodyDigest(req)) {
+		if err := writeDelivery(key, deliveryRecord{Delivery: deliveryID}); err != nil {
+			log.Printf("unable to expire delivery %s: %s", key, err.Error())
+		}
+	}
+}
+
+func readDelivery(key string) (*deliveryRecord, error) {
+	body, err := sdk.ReadPipelineLog(os.Getenv("gateway_url"), sdk.PipelineLog{
+		RepoPath:  deliveryRepoPath,
+		CommitSHA: key,
+		Function:  Source,
+		Source:    sdk.DeliverySource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	record := deliveryRecord{}
+	if err := json.Unmarshal(body, &record); err != nil || record.Received.IsZero() {
+		return nil, nil
+	}
+
+	return &record, nil
+}
+
+func writeDelivery(key string, record deliveryRecord) error {
+	recordBytes, _ := json.Marshal(record)
+
+	return writeRecord(sdk.PipelineLog{
+		RepoPath:  deliveryRepoPath,
+		CommitSHA: key,
+		Function:  Source,
+		Source:    sdk.DeliverySource,
+		Data:      string(recordBytes),
+	})
+}
+
+// writeRecord stores a record github-event keeps in pipeline-log
+func writeRecord(p sdk.PipelineLog) error {
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	return sdk.WritePipelineLog(os.Getenv("gateway_url"), payloadSecret, p)
+}
+
+// duplicate audits a dropped delivery and returns the response for the
+// caller
+func duplicate(eventHeader string, deliveryID string, earlier *deliveryRecord) sdk.Response {
+	msg := fmt.Sprintf("duplicate %s delivery %s dropped, first received as %s at %s", eventHeader, deliveryID, earlier.Delivery, earlier.Received.Format(time.RFC3339))
+
+	sdk.PostAudit(sdk.AuditEvent{
+		Message:    msg,
+		Source:     Source,
+		DeliveryID: deliveryID,
+	})
+
+	return sdk.Skipped(msg)
+}
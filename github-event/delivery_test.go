@@ -0,0 +1,157 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// fakePipelineLog keeps the deliveries written to it in memory
+func fakePipelineLog(t *testing.T) (*httptest.Server, map[string]string) {
+	store := map[string]string{}
+	mutex := sync.Mutex{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+			p := sdk.PipelineLog{}
+			json.Unmarshal(body, &p)
+			if p.Source != sdk.DeliverySource || p.RepoPath != deliveryRepoPath {
+				t.Errorf("unexpected pipeline log %+v", p)
+			}
+			store[p.CommitSHA] = p.Data
+			return
+		}
+
+		w.Write([]byte(store[r.URL.Query().Get("commitSHA")]))
+	}))
+
+	return server, store
+}
+
+func Test_seenDelivery(t *testing.T) {
+	defer setupSecrets(t)()
+
+	server, store := fakePipelineLog(t)
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	req := []byte(`{"after": "af6db"}`)
+	now := time.Now()
+
+	if earlier := seenDelivery("guid-1", "push", req, time.Hour, now); earlier != nil {
+		t.Fatalf("want the first delivery accepted, got %+v", earlier)
+	}
+	if len(store) != 2 {
+		t.Errorf("want the delivery recorded by GUID and digest, got %v", store)
+	}
+
+	if earlier := seenDelivery("guid-1", "push", req, time.Hour, now.Add(time.Minute)); earlier == nil || earlier.Delivery != "guid-1" {
+		t.Errorf("want a redelivery dropped, got %+v", earlier)
+	}
+
+	if earlier := seenDelivery("guid-2", "push", req, time.Hour, now.Add(time.Minute)); earlier == nil {
+		t.Errorf("want a replay with another GUID dropped")
+	}
+
+	if earlier := seenDelivery("guid-3", "push", []byte(`{"after": "b3cc1"}`), time.Hour, now.Add(time.Minute)); earlier != nil {
+		t.Errorf("want another event accepted, got %+v", earlier)
+	}
+
+	if earlier := seenDelivery("guid-1", "push", req, time.Hour, now.Add(2*time.Hour)); earlier != nil {
+		t.Errorf("want a delivery accepted once the ttl has passed, got %+v", earlier)
+	}
+}
+
+func Test_forgetDelivery(t *testing.T) {
+	defer setupSecrets(t)()
+
+	server, _ := fakePipelineLog(t)
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	req := []byte(`{"after": "af6db"}`)
+	now := time.Now()
+
+	seenDelivery("guid-1", "push", req, time.Hour, now)
+	forgetDelivery("guid-1", req)
+
+	if earlier := seenDelivery("guid-1", "push", req, time.Hour, now); earlier != nil {
+		t.Errorf("want a forgotten delivery accepted again, got %+v", earlier)
+	}
+}
+
+func Test_seenDelivery_StoreDown(t *testing.T) {
+	defer setupSecrets(t)()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	if earlier := seenDelivery("guid-1", "push", []byte("{}"), time.Hour, time.Now()); earlier != nil {
+		t.Errorf("want the delivery accepted when the store is down")
+	}
+}
+
+func Test_deliveryTTL(t *testing.T) {
+	defer os.Unsetenv("dedupe_deliveries")
+	defer os.Unsetenv("delivery_ttl")
+
+	if ttl := deliveryTTL(); ttl != 24*time.Hour {
+		t.Errorf("want de-duplication on for 24h by default, got %s", ttl)
+	}
+
+	os.Setenv("delivery_ttl", "1h")
+	if ttl := deliveryTTL(); ttl != time.Hour {
+		t.Errorf("want 1h, got %s", ttl)
+	}
+
+	os.Setenv("dedupe_deliveries", "false")
+	if ttl := deliveryTTL(); ttl != 0 {
+		t.Errorf("want de-duplication turned off, got %s", ttl)
+	}
+}
+
+func Test_stalePush(t *testing.T) {
+	now := time.Date(2026, 10, 2, 12, 0, 0, 0, time.UTC)
+	push := func(pushed time.Time) []byte {
+		return []byte(fmt.Sprintf(`{"repository": {"pushed_at": %d}}`, pushed.Unix()))
+	}
+
+	tests := []struct {
+		Scenario string
+		Event    string
+		Req      []byte
+		Want     bool
+	}{
+		{"recent push", "push", push(now.Add(-time.Minute)), false},
+		{"push older than the ttl", "push", push(now.Add(-25 * time.Hour)), true},
+		{"push without pushed_at", "push", []byte(`{"repository": {}}`), false},
+		{"pull request with the time of the last push", "pull_request", []byte(`{"repository": {"pushed_at": "2026-09-01T12:00:00Z"}}`), false},
+	}
+
+	for _, test := range tests {
+		if got := stalePush(test.Event, test.Req, 24*time.Hour, now); got != test.Want {
+			t.Errorf("%s: want %v, got %v", test.Scenario, test.Want, got)
+		}
+	}
+}
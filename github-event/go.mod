@@ -3,7 +3,10 @@ module github.com/openfaas/openfaas-cloud/github-event
 go 1.13
 
 require (
+	github.com/alexellis/derek v0.0.0-20200824120721-b453a7326b67
 	github.com/alexellis/hmac v0.0.0-20180624211220-5c52ab81c0de
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/openfaas/faas-provider v0.0.0-20180910095832-845bf7aa58cb
 	github.com/openfaas/openfaas-cloud v0.0.0-20200303103051-6c3e056a6ac4
+	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
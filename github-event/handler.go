@@ -20,6 +20,13 @@ const Source = "github-event"
 
 var audit sdk.Audit
 
+// clock and ids stamp and identify deliveries, tests replace them to get
+// deterministic timestamps and correlation IDs
+var (
+	clock sdk.Clock       = sdk.SystemClock{}
+	ids   sdk.IDGenerator = sdk.RandomIDs{}
+)
+
 type GarbageRequest struct {
 	Functions []string `json:"functions"`
 	Repo      string   `json:"repo"`
@@ -44,8 +51,20 @@ type Installation struct {
 }
 
 // Handle receives events from the GitHub app and checks the origin via
-// HMAC. Valid events are push or installation events.
+// HMAC, using the sha256 signature when GitHub sends one. Valid events
+// are push, pull_request, release, installation or marketplace_purchase
+// events.
+// The response is an sdk.Response as JSON, with secrets redacted, other
+// than for action=received which lists an owner's recent events.
 func Handle(req []byte) string {
+	if values, err := url.ParseQuery(os.Getenv("Http_Query")); err == nil && values.Get("action") == "received" {
+		return listReceived(req)
+	}
+
+	return handle(req).JSON()
+}
+
+func handle(req []byte) sdk.Response {
 	customersPath := os.Getenv("customers_path")
 	customersURL := os.Getenv("customers_url")
 
@@ -56,7 +75,19 @@ func Handle(req []byte) string {
 	if values, err := url.ParseQuery(queryVal); err == nil {
 		setupAction := values.Get("setup_action")
 		if setupAction == "install" {
-			return "Installation completed, please return to the installation guide."
+			return sdk.OK("Installation completed, please return to the installation guide.")
+		}
+
+		if values.Get("action") == "replay" {
+			return replay(req)
+		}
+
+		if values.Get("action") == "replay-received" {
+			return replayReceived(req)
+		}
+
+		if values.Get("action") == "installation" {
+			return processInstallation(req)
 		}
 	}
 
@@ -66,10 +97,23 @@ func Handle(req []byte) string {
 
 	eventHeader := os.Getenv("Http_X_Github_Event")
 	xHubSignature := os.Getenv("Http_X_Hub_Signature")
+	xHubSignature256 := os.Getenv("Http_X_Hub_Signature_256")
+	webhookHeader := http.Header{}
+	webhookHeader.Set(sdk.GitHubSignatureHeader, xHubSignature)
+	webhookHeader.Set(sdk.GitHubSignature256Header, xHubSignature256)
+	deliveryID := os.Getenv("Http_X_Github_Delivery")
+	received := clock.Now()
+	ttl := deliveryTTL()
 
 	if eventHeader != "push" &&
+		eventHeader != "pull_request" &&
+		eventHeader != "release" &&
+		eventHeader != "check_run" &&
+		eventHeader != "commit_comment" &&
+		eventHeader != "issue_comment" &&
 		eventHeader != "installation_repositories" &&
 		eventHeader != "integration_installation" &&
+		eventHeader != "marketplace_purchase" &&
 		eventHeader != "installation" {
 
 		auditEvent := sdk.AuditEvent{
@@ -79,55 +123,197 @@ func Handle(req []byte) string {
 
 		sdk.PostAudit(auditEvent)
 
-		return fmt.Sprintf("%s cannot handle event: %s", Source, eventHeader)
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("%s cannot handle event: %s", Source, eventHeader))
 	}
 
 	customer := sdk.PushEvent{}
 	unmarshalErr := json.Unmarshal(req, &customer)
 	if unmarshalErr != nil {
-		return fmt.Sprintf("Error while un-marshaling customers: %s, value: %s",
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("Error while un-marshaling customers: %s, value: %s",
 			unmarshalErr.Error(),
-			string(req))
+			string(req)))
 	}
 
-	if eventHeader == "push" {
+	// A pull_request, release or re-run of a check run is checked like a
+	// push, github-push decides whether it is deployed
+	if eventHeader == "push" || eventHeader == "pull_request" || eventHeader == "release" || eventHeader == "check_run" {
+		if sdk.HmacEnabled() {
+			webhookSecretKey, secretErr := sdk.ReadSecret("github-webhook-secret")
+			if secretErr != nil {
+				return sdk.Failed(http.StatusInternalServerError, secretErr.Error())
+			}
+
+			validateErr := validateWebhook(req, webhookHeader, webhookSecretKey)
+			if validateErr != nil {
+				return unauthorized(eventHeader, validateErr)
+			}
+		}
+
+		// The signature is checked first, since the customer's teams are
+		// looked up with an installation token
 		if sdk.ValidateCustomers() {
 			err := validateCustomers(&customer, customers)
 			if err != nil {
-				return err.Error()
+				return sdk.Rejected(http.StatusForbidden, err.Error())
 			}
 		}
 
-		if sdk.HmacEnabled() {
-			webhookSecretKey, secretErr := sdk.ReadSecret("github-webhook-secret")
-			if secretErr != nil {
-				return secretErr.Error()
+		// Check runs are created and completed with each build, only a
+		// re-run requested by a user is forwarded
+		if eventHeader == "check_run" {
+			checkRunEvent := sdk.CheckRunEvent{}
+			if err := json.Unmarshal(req, &checkRunEvent); err != nil {
+				return sdk.Rejected(http.StatusBadRequest, err.Error())
+			}
+			if !checkRunEvent.Rerun() {
+				return sdk.Skipped(fmt.Sprintf("Message received with event: %s, action: %s", eventHeader, checkRunEvent.Action))
 			}
+		}
 
-			validateErr := hmac.Validate(req, xHubSignature, webhookSecretKey)
-			if validateErr != nil {
-				log.Fatal(validateErr)
+		// The correlation ID is carried with the delivery to every
+		// function in the pipeline and added to their audit events
+		delivery := sdk.Delivery{ID: deliveryID, Event: eventHeader, Received: received, CorrelationID: ids.NewID()}
+		customer.Delivery = &delivery
+
+		if ttl > 0 {
+			if stalePush(eventHeader, req, ttl, received) {
+				return stale(eventHeader, deliveryID, ttl)
+			}
+			if earlier := seenDelivery(deliveryID, eventHeader, req, ttl, received); earlier != nil {
+				return duplicate(eventHeader, deliveryID, earlier)
 			}
 		}
 
 		headers := map[string]string{
-			"X-Hub-Signature": xHubSignature,
-			"X-GitHub-Event":  eventHeader,
-			"Content-Type":    "application/json",
+			sdk.GitHubSignatureHeader:    xHubSignature,
+			sdk.GitHubSignature256Header: xHubSignature256,
+			"X-GitHub-Event":             eventHeader,
+			"Content-Type":               "application/json",
+		}
+		if len(deliveryID) > 0 {
+			headers["X-GitHub-Delivery"] = deliveryID
+		}
+
+		if err := addCustomerHeaders(headers, customer.Repository.Owner.Login, customers); err != nil {
+			log.Printf("unable to forward customer tier: %s", err.Error())
+		}
+
+		if err := addDeliveryHeaders(headers, delivery, req); err != nil {
+			log.Printf("unable to forward delivery %s: %s", deliveryID, err.Error())
 		}
 
 		forwardTo := "github-push"
-		body, statusCode, err := forward(req, forwardTo, headers)
+
+		// A webhook loop in one repository must not flood the pipeline
+		// for everyone else. A throttled event is kept as a dead-letter,
+		// so that it can be replayed once the loop is fixed.
+		if limit := getRateLimit(); limit.enabled() {
+			key := rateLimitKey(customer)
+			allowed, wait, limitErr := allowEvent(key, limit, received)
+			if limitErr != nil || !allowed {
+				if ttl > 0 {
+					forgetDelivery(deliveryID, req)
+				}
+
+				reason := fmt.Errorf("%s is over the rate limit", key)
+				if limitErr != nil {
+					reason = fmt.Errorf("unable to check the rate limit of %s: %s", key, limitErr.Error())
+				}
+				if deadLetterErr := writeDeadLetter(&customer, forwardTo, headers, req, 0, reason); deadLetterErr != nil {
+					log.Printf("unable to write dead-letter: %s", deadLetterErr.Error())
+				}
+
+				if limitErr != nil {
+					log.Printf(reason.Error())
+					return sdk.Failed(http.StatusServiceUnavailable, reason.Error())
+				}
+				return throttled(eventHeader, customer, key, wait)
+			}
+		}
+
+		if history := receivedHistory(); history > 0 {
+			if err := recordReceived(&customer, eventHeader, delivery, forwardTo, headers, req, history); err != nil {
+				log.Printf("unable to record %s event for replay: %s", eventHeader, err.Error())
+			}
+		}
+
+		if eventQueue() == jetStreamQueue {
+			ack, queueErr := queueEvent(forwardTo, headers, req, deliveryID)
+			if queueErr == nil {
+				return sdk.Accepted(fmt.Sprintf("[%s]: queued as %d of %s", forwardTo, ack.Sequence, ack.Stream))
+			}
+			log.Printf("unable to queue %s event, forwarding it now: %s", eventHeader, queueErr.Error())
+		}
+
+		body, statusCode, attempts, err := forwardWithRetry(req, forwardTo, headers, getRetryPolicy())
 
 		if statusCode == http.StatusOK {
-			return fmt.Sprintf("[%s]: %d, %s", forwardTo, statusCode, body)
+			return forwarded(forwardTo, statusCode, body)
 		}
 
 		if err != nil {
-			return err.Error()
+			if ttl > 0 {
+				forgetDelivery(deliveryID, req)
+			}
+			if retryable(statusCode) {
+				if deadLetterErr := writeDeadLetter(&customer, forwardTo, headers, req, attempts, err); deadLetterErr != nil {
+					log.Printf("unable to write dead-letter: %s", deadLetterErr.Error())
+				}
+			}
+			return sdk.Failed(http.StatusBadGateway, err.Error())
+		}
+
+		return forwarded(forwardTo, statusCode, body)
+	}
+
+	// Comments are read for commands such as resuming a paused pipeline
+	if eventHeader == "commit_comment" || eventHeader == "issue_comment" {
+		if sdk.HmacEnabled() {
+			webhookSecretKey, secretErr := sdk.ReadSecret("github-webhook-secret")
+			if secretErr != nil {
+				return sdk.Failed(http.StatusInternalServerError, secretErr.Error())
+			}
+
+			if validateErr := validateWebhook(req, webhookHeader, webhookSecretKey); validateErr != nil {
+				return unauthorized(eventHeader, validateErr)
+			}
+		}
+
+		if sdk.ValidateCustomers() {
+			if err := validateCustomers(&customer, customers); err != nil {
+				return sdk.Rejected(http.StatusForbidden, err.Error())
+			}
+		}
+
+		return handleComment(req, eventHeader)
+	}
+
+	// A purchase is made by an account which may not be a customer yet,
+	// so only the signature is checked
+	if eventHeader == "marketplace_purchase" {
+		event := MarketplacePurchaseEvent{}
+		if err := json.Unmarshal(req, &event); err != nil {
+			return sdk.Rejected(http.StatusBadRequest, err.Error())
+		}
+
+		if sdk.HmacEnabled() {
+			webhookSecretKey, secretErr := sdk.ReadSecret("github-webhook-secret")
+			if secretErr != nil {
+				return sdk.Failed(http.StatusInternalServerError, secretErr.Error())
+			}
+
+			if validateErr := validateWebhook(req, webhookHeader, webhookSecretKey); validateErr != nil {
+				return unauthorized(eventHeader, validateErr)
+			}
+		}
+
+		if ttl > 0 {
+			if earlier := seenDelivery(deliveryID, eventHeader, req, ttl, received); earlier != nil {
+				return duplicate(eventHeader, deliveryID, earlier)
+			}
 		}
 
-		return body
+		return handleMarketplacePurchase(event, customers.Store)
 	}
 
 	if eventHeader == "installation" ||
@@ -137,96 +323,89 @@ func Handle(req []byte) string {
 		event := InstallationRepositoriesEvent{}
 		err := json.Unmarshal(req, &event)
 		if err != nil {
-			return err.Error()
+			return sdk.Rejected(http.StatusBadRequest, err.Error())
+		}
+
+		if sdk.HmacEnabled() {
+			webhookSecretKey, secretErr := sdk.ReadSecret("github-webhook-secret")
+			if secretErr != nil {
+				return sdk.Failed(http.StatusInternalServerError, secretErr.Error())
+			}
+
+			validateErr := validateWebhook(req, webhookHeader, webhookSecretKey)
+			if validateErr != nil {
+				return unauthorized(eventHeader, validateErr)
+			}
 		}
 
 		if sdk.ValidateCustomers() {
-			customer := sdk.PushEvent{
+			account := sdk.PushEvent{
 				Repository: sdk.PushEventRepository{
 					Owner: sdk.Owner{
 						Login: event.Installation.Account.Login,
 					},
 				},
+				Sender:       customer.Sender,
+				Installation: customer.Installation,
 			}
 
-			err := validateCustomers(&customer, customers)
+			err := validateCustomers(&account, customers)
 			if err != nil {
-				return err.Error()
+				return sdk.Rejected(http.StatusForbidden, err.Error())
 			}
 		}
 
-		if sdk.HmacEnabled() {
-			webhookSecretKey, secretErr := sdk.ReadSecret("github-webhook-secret")
-			if secretErr != nil {
-				return secretErr.Error()
-			}
-
-			validateErr := hmac.Validate(req, xHubSignature, webhookSecretKey)
-			if validateErr != nil {
-				log.Fatal(validateErr)
+		if ttl > 0 {
+			if earlier := seenDelivery(deliveryID, eventHeader, req, ttl, received); earlier != nil {
+				return duplicate(eventHeader, deliveryID, earlier)
 			}
 		}
 
-		fmt.Printf("event.Action: %s\n", event.Action)
-
-		switch event.Action {
-		case "created", "added":
-
-			addedVal := ""
-			if event.RepositoriesAdded != nil {
-				for _, added := range event.RepositoriesAdded {
-					addedVal += added.FullName + ", "
-				}
-			}
-			if event.Repositories != nil {
-				for _, added := range event.Repositories {
-					addedVal += added.FullName + ", "
-				}
+		if worker := installationWorker(); len(worker) > 0 {
+			queueErr := queueInstallation(req, eventHeader, worker)
+			if queueErr == nil {
+				return sdk.Accepted(fmt.Sprintf("Message queued with event: %s", eventHeader))
 			}
+			log.Printf("unable to queue %s event, handling it now: %s", eventHeader, queueErr.Error())
+		}
 
-			auditEvent := sdk.AuditEvent{
-				Message: event.Installation.Account.Login + " added repositories: " + addedVal,
-				Source:  Source,
-			}
+		handleInstallation(event)
+	}
 
-			sdk.PostAudit(auditEvent)
+	return sdk.OK(fmt.Sprintf("Message received with event: %s", eventHeader))
+}
 
-		case "removed":
-			garbageRequests := []GarbageRequest{}
-			for _, repo := range event.RepositoriesRemoved {
-				fmt.Printf("Need to remove: %s.\n", repo.FullName)
+// forwarded gives the response of the function an event was forwarded
+// to, which is passed on with this delivery's correlation ID
+func forwarded(function string, statusCode int, body string) sdk.Response {
+	res := sdk.Response{}
+	if err := json.Unmarshal([]byte(body), &res); err != nil || len(res.Status) == 0 {
+		return sdk.OK(fmt.Sprintf("[%s]: %d, %s", function, statusCode, body))
+	}
 
-				garbageRequests = append(garbageRequests,
-					GarbageRequest{
-						Owner:     event.Installation.Account.Login,
-						Repo:      repo.Name,
-						Functions: []string{},
-					},
-				)
-			}
-			garbageCollect(garbageRequests)
-			break
-		case "deleted":
-			garbageRequests := []GarbageRequest{}
-			owner := event.Installation.Account.Login
-			fmt.Printf("Need to remove all repos for owner: %s.\n", owner)
-
-			garbageRequests = append(garbageRequests,
-				GarbageRequest{
-					Owner:     owner,
-					Repo:      "*",
-					Functions: []string{},
-				},
-			)
+	res.CorrelationID = ""
+	res.Reason = fmt.Sprintf("[%s]: %s", function, res.Reason)
+	return res
+}
 
-			garbageCollect(garbageRequests)
+// validateWebhook checks the signature of an event through the GitHub SCM
+func validateWebhook(payload []byte, header http.Header, secret string) error {
+	scm, _ := sdk.GetSCM(sdk.SCMGitHub)
+	return scm.ValidateWebhook(payload, header, secret)
+}
 
-			break
-		}
+// unauthorized audits an event whose signature did not match and
+// returns the response for the caller
+func unauthorized(eventHeader string, err error) sdk.Response {
+	msg := fmt.Sprintf("invalid signature for %s event: %s", eventHeader, err.Error())
 
-	}
+	sdk.PostAudit(sdk.AuditEvent{
+		Message: msg,
+		Source:  Source,
+	})
 
-	return fmt.Sprintf("Message received with event: %s", eventHeader)
+	log.Println(msg)
+	return sdk.Rejected(http.StatusUnauthorized, msg)
 }
 
 func validateCustomers(pushEvent *sdk.PushEvent, customers *sdk.Customers) error {
@@ -243,6 +422,17 @@ func validateCustomers(pushEvent *sdk.PushEvent, customers *sdk.Customers) error
 			log.Printf("Error getting customer: %s, %s", owner, err.Error())
 		}
 
+		sender := pushEvent.Sender.Login
+		if teams := getAllowedTeams(); len(teams) > 0 && len(sender) > 0 {
+			token, tokenErr := installationToken(pushEvent.Installation.ID)
+			if tokenErr != nil {
+				log.Printf("Error checking teams of: %s, %s", sender, tokenErr.Error())
+			} else if team := teamMember(teams, owner, sender, token); team != nil {
+				log.Printf("Customer: %s allowed by %s, a member of %s", owner, sender, team)
+				return nil
+			}
+		}
+
 		auditEvent := sdk.AuditEvent{
 			Message: "Customer not found",
 			Owner:   owner,
@@ -256,6 +446,49 @@ func validateCustomers(pushEvent *sdk.PushEvent, customers *sdk.Customers) error
 	return nil
 }
 
+// addCustomerHeaders forwards the owner's tier and entitlements so that
+// the functions further down the pipeline do not each look them up.
+// Nothing is added when the owner is not listed, i.e. customer
+// validation is disabled.
+func addCustomerHeaders(headers map[string]string, owner string, customers *sdk.Customers) error {
+	customer, ok := customers.Lookup(owner)
+	if !ok {
+		return nil
+	}
+
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	value, signature := sdk.SignCustomer(*customer, payloadSecret)
+	headers[sdk.CustomerHeader] = value
+	headers[sdk.CustomerSignatureHeader] = signature
+
+	return nil
+}
+
+// addDeliveryHeaders forwards the delivery the event came from, signed
+// together with the event, so that the pipeline can be traced back to
+// it in GitHub's "Recent Deliveries". Nothing is added for an event
+// without a delivery ID.
+func addDeliveryHeaders(headers map[string]string, delivery sdk.Delivery, req []byte) error {
+	if len(delivery.ID) == 0 {
+		return nil
+	}
+
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	value, signature := sdk.SignDelivery(delivery, req, payloadSecret)
+	headers[sdk.DeliveryHeader] = value
+	headers[sdk.DeliverySignatureHeader] = signature
+
+	return nil
+}
+
 func garbageCollect(garbageRequests []GarbageRequest) error {
 
 	gatewayURL := os.Getenv("gateway_url")
@@ -274,7 +507,7 @@ func garbageCollect(garbageRequests []GarbageRequest) error {
 		digest := hmac.Sign(body, []byte(payloadSecret))
 		req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
 
-		res, err := http.DefaultClient.Do(req)
+		res, err := sdk.HTTPClient().Do(req)
 		if err != nil {
 			return err
 		}
@@ -305,14 +538,9 @@ func forward(req []byte, function string, headers map[string]string) (string, in
 		pushReq.Header.Add(k, v)
 	}
 
-	res, err := http.DefaultClient.Do(pushReq)
+	res, err := sdk.HTTPClient().Do(pushReq)
 	if err != nil {
 		msg := "cannot post to " + function + ": " + err.Error()
-		auditEvent := sdk.AuditEvent{
-			Message: msg,
-			Source:  Source,
-		}
-		sdk.PostAudit(auditEvent)
 		return "", http.StatusInternalServerError, fmt.Errorf(msg)
 	}
 
@@ -1,13 +1,17 @@
 package function
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
 	"testing"
+	"time"
 
+	"github.com/alexellis/hmac"
 	"github.com/openfaas/openfaas-cloud/sdk"
 )
 
@@ -81,7 +85,9 @@ func Test_Handle_ValidateCustomersInvalid(t *testing.T) {
 
 	os.Setenv("Http_X_Github_Event", "push")
 	os.Setenv("validate_customers", "true")
+	os.Setenv("validate_hmac", "false")
 	os.Setenv("customers_url", server.URL)
+	defer os.Unsetenv("validate_hmac")
 
 	owner := "rgee0"
 	customer := sdk.PushEvent{
@@ -93,11 +99,11 @@ func Test_Handle_ValidateCustomersInvalid(t *testing.T) {
 	}
 	body, _ := json.Marshal(customer)
 
-	res := Handle(body)
+	res := handle(body)
 
 	want := `Customer: "rgee0" not found in customers ACL`
-	if res != want {
-		t.Errorf("want error: %q, got: %q", want, res)
+	if res.Reason != want || res.Code != http.StatusForbidden {
+		t.Errorf("want error: %q, got: %q (%d)", want, res.Reason, res.Code)
 		t.Fail()
 	}
 }
@@ -147,7 +153,7 @@ func Test_Handle_Event(t *testing.T) {
 			header:            "push",
 			action:            "",
 			validateCustomers: "true",
-			validateHmac:      "false",
+			validateHmac:      "true",
 			want:              "unable to read secret: /var/openfaas/secrets/github-webhook-secret, error: open /var/openfaas/secrets/github-webhook-secret: no such file or directory",
 			login:             "alexellis",
 		},
@@ -156,15 +162,34 @@ func Test_Handle_Event(t *testing.T) {
 			header:            "push",
 			action:            "",
 			validateCustomers: "false",
-			validateHmac:      "false",
+			validateHmac:      "true",
+			want:              "unable to read secret: /var/openfaas/secrets/github-webhook-secret, error: open /var/openfaas/secrets/github-webhook-secret: no such file or directory",
+		},
+		{
+			scenario:          "Pull request event",
+			header:            "pull_request",
+			action:            "",
+			validateCustomers: "false",
+			validateHmac:      "true",
+			want:              "unable to read secret: /var/openfaas/secrets/github-webhook-secret, error: open /var/openfaas/secrets/github-webhook-secret: no such file or directory",
+		},
+		{
+			scenario:          "Release event",
+			header:            "release",
+			action:            "",
+			validateCustomers: "false",
+			validateHmac:      "true",
 			want:              "unable to read secret: /var/openfaas/secrets/github-webhook-secret, error: open /var/openfaas/secrets/github-webhook-secret: no such file or directory",
 		},
 	}
 
+	defer os.Unsetenv("validate_hmac")
+
 	for _, event := range events {
 		t.Run(event.scenario, func(t *testing.T) {
 			os.Setenv("Http_X_Github_Event", event.header)
 			os.Setenv("validate_customers", event.validateCustomers)
+			os.Setenv("validate_hmac", event.validateHmac)
 
 			req := []byte{}
 			audit = sdk.NilLogger{}
@@ -177,10 +202,10 @@ func Test_Handle_Event(t *testing.T) {
 				os.Setenv("customers_url", server.URL)
 			}
 
-			res := Handle(req)
+			res := handle(req)
 
-			if res != event.want {
-				t.Errorf("want %q, but got %q", event.want, res)
+			if res.Reason != event.want {
+				t.Errorf("want %q, but got %q", event.want, res.Reason)
 			}
 		})
 	}
@@ -188,10 +213,158 @@ func Test_Handle_Event(t *testing.T) {
 
 func Test_RedirectSetupAction(t *testing.T) {
 	os.Setenv("Http_Query", "setup_action=install")
-	got := Handle([]byte(""))
+	got := sdk.Response{}
+	if err := json.Unmarshal([]byte(Handle([]byte(""))), &got); err != nil {
+		t.Fatalf("want a JSON response, got %s", err)
+	}
 	want := "Installation completed, please return to the installation guide."
-	if got != want {
-		t.Errorf("want %s, got %s", want, got)
+	if got.Reason != want || got.Status != sdk.ResponseOK || got.Code != http.StatusOK {
+		t.Errorf("want %s, got %+v", want, got)
+	}
+
+}
+
+func Test_addCustomerHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "github-event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	customersPath := path.Join(dir, "customers")
+	ioutil.WriteFile(customersPath, []byte("alexellis pro functions=50\nopenfaas\n"), 0600)
+	ioutil.WriteFile(path.Join(dir, "payload-secret"), []byte("secret"), 0600)
+
+	os.Setenv("secret_mount_path", dir)
+	defer os.Unsetenv("secret_mount_path")
+
+	customers := sdk.NewCustomers(customersPath, "")
+	customers.Fetch()
+
+	headers := map[string]string{}
+	if err := addCustomerHeaders(headers, "alexellis", customers); err != nil {
+		t.Fatal(err)
+	}
+
+	customer, err := sdk.CustomerFromHeader(headers[sdk.CustomerHeader], headers[sdk.CustomerSignatureHeader], "secret", "alexellis")
+	if err != nil {
+		t.Fatalf("want a signed customer, got %s", err)
+	}
+	if customer.Tier != "pro" || customer.Entitlements["functions"] != "50" {
+		t.Errorf("want pro with entitlements, got %+v", customer)
+	}
+
+	headers = map[string]string{}
+	if err := addCustomerHeaders(headers, "not-a-customer", customers); err != nil || len(headers) > 0 {
+		t.Errorf("want no headers for an unknown owner, got %v %v", headers, err)
+	}
+}
+
+func Test_addDeliveryHeaders(t *testing.T) {
+	defer setupSecrets(t)()
+
+	req := []byte(`{"ref":"refs/heads/master"}`)
+	received := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	headers := map[string]string{}
+	if err := addDeliveryHeaders(headers, sdk.Delivery{ID: "72d3162e", Event: "push", Received: received, CorrelationID: "9f2c81d04ab3e6f7"}, req); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery, err := sdk.DeliveryFromHeader(headers[sdk.DeliveryHeader], headers[sdk.DeliverySignatureHeader], req, "secret")
+	if err != nil {
+		t.Fatalf("want a signed delivery, got %s", err)
+	}
+	if delivery.ID != "72d3162e" || delivery.Event != "push" || !delivery.Received.Equal(received) || delivery.CorrelationID != "9f2c81d04ab3e6f7" {
+		t.Errorf("want the delivery with its correlation ID, got %+v", delivery)
 	}
 
+	headers = map[string]string{}
+	if err := addDeliveryHeaders(headers, sdk.Delivery{Event: "push"}, req); err != nil || len(headers) > 0 {
+		t.Errorf("want no headers without a delivery ID, got %v %v", headers, err)
+	}
+}
+
+func Test_Handle_PushEventInvalidSignature(t *testing.T) {
+	os.Unsetenv("Http_Query")
+
+	secrets, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+	ioutil.WriteFile(path.Join(secrets, "github-webhook-secret"), []byte("webhook-secret"), 0600)
+
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("validate_customers", "false")
+	os.Setenv("Http_X_Hub_Signature_256", "sha256=00")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("Http_X_Hub_Signature_256")
+
+	res := handle([]byte(`{"ref":"refs/heads/master","repository":{"owner":{"login":"alexellis"}}}`))
+
+	want := "invalid signature for push event: invalid message digest or secret in X-Hub-Signature-256"
+	if res.Reason != want || res.Status != sdk.ResponseRejected || res.Code != http.StatusUnauthorized {
+		t.Errorf("want %q rejected with %d, got %+v", want, http.StatusUnauthorized, res)
+	}
+}
+
+func Test_Handle_InvalidSignatureBeforeCustomers(t *testing.T) {
+	os.Unsetenv("Http_Query")
+
+	secrets, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+	ioutil.WriteFile(path.Join(secrets, "github-webhook-secret"), []byte("webhook-secret"), 0600)
+
+	server := httptest.NewServer(&HTTPHandler{})
+	defer server.Close()
+
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("validate_customers", "true")
+	os.Setenv("customers_url", server.URL)
+	os.Setenv("allowed_teams", "openfaas")
+	os.Setenv("Http_X_Hub_Signature_256", "sha256=00")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("allowed_teams")
+	defer os.Unsetenv("Http_X_Hub_Signature_256")
+
+	res := handle([]byte(`{"ref":"refs/heads/master","repository":{"owner":{"login":"rgee0"}},"sender":{"login":"rgee0"},"installation":{"id":1}}`))
+
+	// An unsigned event is refused before the sender's teams are looked
+	// up with an installation token
+	if res.Status != sdk.ResponseRejected || res.Code != http.StatusUnauthorized {
+		t.Errorf("want the signature checked before the customer, got %+v", res)
+	}
+}
+
+func Test_Handle_CheckRunEventNotRerun(t *testing.T) {
+	os.Unsetenv("Http_Query")
+
+	secrets, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+	ioutil.WriteFile(path.Join(secrets, "github-webhook-secret"), []byte("webhook-secret"), 0600)
+
+	req := []byte(`{"action":"completed","check_run":{"head_sha":"af6db3c"},"repository":{"owner":{"login":"alexellis"}}}`)
+
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "check_run")
+	os.Setenv("validate_customers", "false")
+	os.Setenv("Http_X_Hub_Signature", "sha1="+hex.EncodeToString(hmac.Sign(req, []byte("webhook-secret"))))
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("Http_X_Hub_Signature")
+
+	res := handle(req)
+
+	want := "Message received with event: check_run, action: completed"
+	if res.Reason != want || res.Status != sdk.ResponseSkipped {
+		t.Errorf("want %q skipped, got %+v", want, res)
+	}
 }
@@ -0,0 +1,197 @@
+package function
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// installationWorker reads installation_worker, the name of this function
+// on the gateway, i.e. system-github-event. When set, installation events
+// are queued to it and processed by the queue-worker rather than while
+// GitHub waits, so that a burst of them, such as an organisation
+// uninstalling the app, does not hold up push events. Give the worker
+// its own queue with the com.openfaas.queue annotation to keep them
+// apart from builds.
+func installationWorker() string {
+	return strings.TrimSpace(os.Getenv("installation_worker"))
+}
+
+// queueInstallation posts a validated installation event to the worker
+// with action=installation, signed with the payload-secret
+func queueInstallation(req []byte, eventHeader string, worker string) error {
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	r, _ := http.NewRequest(http.MethodPost, os.Getenv("gateway_url")+"async-function/"+worker+"?action=installation", bytes.NewReader(req))
+
+	digest := hmac.Sign(req, []byte(payloadSecret))
+	r.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+	r.Header.Add("X-GitHub-Event", eventHeader)
+	r.Header.Add("Content-Type", "application/json")
+
+	res, err := sdk.HTTPClient().Do(r)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusAccepted {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("unexpected status code from %s: %d, %s", worker, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// processInstallation handles an installation event queued by
+// queueInstallation, the event was validated before it was queued
+func processInstallation(req []byte) sdk.Response {
+	if err := sdk.ValidHMAC(&req, "payload-secret", os.Getenv("Http_X_Cloud_Signature")); err != nil {
+		return sdk.Rejected(http.StatusUnauthorized, fmt.Sprintf("installation: %s", err.Error()))
+	}
+
+	event := InstallationRepositoriesEvent{}
+	if err := json.Unmarshal(req, &event); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("installation: unable to parse event: %s", err.Error()))
+	}
+
+	handleInstallation(event)
+
+	return sdk.OK(fmt.Sprintf("Message processed with event: %s", os.Getenv("Http_X_Github_Event")))
+}
+
+// handleInstallation audits added repositories, garbage-collects the
+// functions of removed repositories or of an uninstalled account and
+// pauses the functions of a suspended account
+func handleInstallation(event InstallationRepositoriesEvent) {
+	fmt.Printf("event.Action: %s\n", event.Action)
+
+	switch event.Action {
+	case "created", "added":
+
+		addedVal := ""
+		if event.RepositoriesAdded != nil {
+			for _, added := range event.RepositoriesAdded {
+				addedVal += added.FullName + ", "
+			}
+		}
+		if event.Repositories != nil {
+			for _, added := range event.Repositories {
+				addedVal += added.FullName + ", "
+			}
+		}
+
+		auditEvent := sdk.AuditEvent{
+			Message: event.Installation.Account.Login + " added repositories: " + addedVal,
+			Source:  Source,
+		}
+
+		sdk.PostAudit(auditEvent)
+
+	case "removed":
+		garbageRequests := []GarbageRequest{}
+		for _, repo := range event.RepositoriesRemoved {
+			fmt.Printf("Need to remove: %s.\n", repo.FullName)
+
+			garbageRequests = append(garbageRequests,
+				GarbageRequest{
+					Owner:     event.Installation.Account.Login,
+					Repo:      repo.Name,
+					Functions: []string{},
+				},
+			)
+		}
+		garbageCollect(garbageRequests)
+	case "deleted":
+		garbageRequests := []GarbageRequest{}
+		owner := event.Installation.Account.Login
+		fmt.Printf("Need to remove all repos for owner: %s.\n", owner)
+
+		garbageRequests = append(garbageRequests,
+			GarbageRequest{
+				Owner:     owner,
+				Repo:      "*",
+				Functions: []string{},
+			},
+		)
+
+		garbageCollect(garbageRequests)
+	case "suspend", "unsuspend":
+		owner := event.Installation.Account.Login
+		paused := event.Action == "suspend"
+
+		if err := pauseFunctions(owner, paused); err != nil {
+			log.Printf("unable to %s functions for %s: %s", event.Action, owner, err.Error())
+		}
+	}
+}
+
+// pauseFunctions asks buildshiprun to pause the functions of an owner
+// whose installation is suspended, or to resume them once it is
+// unsuspended, rather than leaving them running without the app
+func pauseFunctions(owner string, paused bool) error {
+	action := "resume"
+	reason := "installation unsuspended"
+	if paused {
+		action = "pause"
+		reason = "installation suspended"
+	}
+
+	body, _ := json.Marshal(sdk.PauseRequest{Owner: owner, Reason: reason})
+	if err := invokeBuildshiprun(action, body); err != nil {
+		return err
+	}
+
+	sdk.PostAudit(sdk.AuditEvent{
+		Message: fmt.Sprintf("%s: functions of %s will be %sd", reason, owner, action),
+		Owner:   owner,
+		Source:  Source,
+	})
+
+	return nil
+}
+
+// invokeBuildshiprun posts a request for one of buildshiprun's actions
+// asynchronously, signed with the payload-secret
+func invokeBuildshiprun(action string, body []byte) error {
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, os.Getenv("gateway_url")+"async-function/buildshiprun?action="+action, bytes.NewReader(body))
+
+	digest := hmac.Sign(body, []byte(payloadSecret))
+	req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusAccepted {
+		resBody, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("unexpected status code from buildshiprun: %d, %s", res.StatusCode, strings.TrimSpace(string(resBody)))
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package function
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+const testUninstallEvent = `{"action":"deleted","installation":{"account":{"login":"alexellis"}}}`
+
+func Test_queueInstallation(t *testing.T) {
+	defer setupSecrets(t)()
+
+	var gotPath, gotQuery, gotEvent string
+	var gotBody []byte
+	var validErr error
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.RawQuery
+		gotEvent = r.Header.Get("X-GitHub-Event")
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		validErr = hmac.Validate(gotBody, r.Header.Get(sdk.CloudSignatureHeader), "secret")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	if err := queueInstallation([]byte(testUninstallEvent), "installation", "system-github-event"); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	if gotPath != "/async-function/system-github-event" || gotQuery != "action=installation" {
+		t.Errorf("want the event queued to the worker, got %s?%s", gotPath, gotQuery)
+	}
+	if gotEvent != "installation" || string(gotBody) != testUninstallEvent {
+		t.Errorf("want the event forwarded as received, got %s: %s", gotEvent, gotBody)
+	}
+	if validErr != nil {
+		t.Errorf("want the event signed with the payload-secret, got %s", validErr)
+	}
+}
+
+func Test_queueInstallation_Unavailable(t *testing.T) {
+	defer setupSecrets(t)()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	if err := queueInstallation([]byte(testUninstallEvent), "installation", "system-github-event"); err == nil {
+		t.Errorf("want an error when the event is not accepted")
+	}
+}
+
+func Test_processInstallation_RequiresSignature(t *testing.T) {
+	defer setupSecrets(t)()
+
+	os.Setenv("Http_X_Cloud_Signature", "sha1=invalid")
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	got := processInstallation([]byte(testUninstallEvent))
+	if got.Reason != "installation: unable to validate HMAC" || got.Status != sdk.ResponseRejected {
+		t.Errorf("want the event rejected, got %+v", got)
+	}
+}
+
+func Test_processInstallation_GarbageCollects(t *testing.T) {
+	defer setupSecrets(t)()
+
+	garbage := []GarbageRequest{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/async-function/garbage-collect" {
+			req := GarbageRequest{}
+			json.NewDecoder(r.Body).Decode(&req)
+			garbage = append(garbage, req)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	req := []byte(testUninstallEvent)
+	os.Setenv("Http_X_Cloud_Signature", "sha1="+hex.EncodeToString(hmac.Sign(req, []byte("secret"))))
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	processInstallation(req)
+
+	if len(garbage) != 1 || garbage[0].Owner != "alexellis" || garbage[0].Repo != "*" {
+		t.Errorf("want every repo of alexellis garbage-collected, got %v", garbage)
+	}
+}
+
+func Test_handleInstallation_Suspend(t *testing.T) {
+	defer setupSecrets(t)()
+
+	var gotQuery []string
+	var gotReq sdk.PauseRequest
+	var validErr error
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/async-function/buildshiprun" {
+			gotQuery = append(gotQuery, r.URL.RawQuery)
+			body, _ := ioutil.ReadAll(r.Body)
+			validErr = hmac.Validate(body, r.Header.Get(sdk.CloudSignatureHeader), "secret")
+			json.Unmarshal(body, &gotReq)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	for _, action := range []string{"suspend", "unsuspend"} {
+		event := InstallationRepositoriesEvent{Action: action}
+		event.Installation.Account.Login = "alexellis"
+		handleInstallation(event)
+	}
+
+	if len(gotQuery) != 2 || gotQuery[0] != "action=pause" || gotQuery[1] != "action=resume" {
+		t.Errorf("want the functions paused then resumed, got %v", gotQuery)
+	}
+	if gotReq.Owner != "alexellis" || gotReq.Reason != "installation unsuspended" {
+		t.Errorf("want the owner and reason, got %+v", gotReq)
+	}
+	if validErr != nil {
+		t.Errorf("want the request signed with the payload-secret, got %s", validErr)
+	}
+}
@@ -0,0 +1,136 @@
+package function
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// MarketplacePurchaseEvent is sent when an account buys, changes or
+// cancels a plan of the GitHub App on the GitHub Marketplace
+type MarketplacePurchaseEvent struct {
+	Action              string              `json:"action"`
+	EffectiveDate       string              `json:"effective_date"`
+	MarketplacePurchase MarketplacePurchase `json:"marketplace_purchase"`
+}
+
+// MarketplacePurchase is the plan an account is on
+type MarketplacePurchase struct {
+	Account struct {
+		Login string `json:"login"`
+		Type  string `json:"type"`
+	} `json:"account"`
+	Plan struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"plan"`
+	OnFreeTrial bool `json:"on_free_trial"`
+}
+
+// marketplacePlan is the tier and quota of functions given to the
+// customers on a Marketplace plan
+type marketplacePlan struct {
+	Tier         string
+	MaxFunctions int
+}
+
+// getMarketplacePlans reads marketplace_plans, which maps the name of
+// each plan on the Marketplace listing to a tier and an optional quota
+// of functions, i.e. "Pro=pro:50,Team=team:200"
+func getMarketplacePlans() map[string]marketplacePlan {
+	plans := map[string]marketplacePlan{}
+	for _, entry := range strings.Split(os.Getenv("marketplace_plans"), ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		if len(name) == 0 || len(value) == 0 {
+			continue
+		}
+
+		plan := marketplacePlan{Tier: strings.ToLower(value)}
+		if index := strings.Index(value, ":"); index > -1 {
+			plan.Tier = strings.ToLower(value[:index])
+			plan.MaxFunctions, _ = strconv.Atoi(value[index+1:])
+		}
+		plans[name] = plan
+	}
+	return plans
+}
+
+// planFor gives the plan of a purchase, a plan which is not listed in
+// marketplace_plans becomes a tier of the same name without a quota
+func planFor(purchase MarketplacePurchase, plans map[string]marketplacePlan) marketplacePlan {
+	name := strings.ToLower(strings.TrimSpace(purchase.Plan.Name))
+	if plan, ok := plans[name]; ok {
+		return plan
+	}
+	return marketplacePlan{Tier: strings.Join(strings.Fields(name), "-")}
+}
+
+// handleMarketplacePurchase adds a customer on the tier of the plan
+// they bought, moves them to a new tier when their plan changes and
+// removes them when it is cancelled. A pending change only takes
+// effect at the end of the billing cycle, when GitHub sends "changed".
+// Customers can only be written to a store which implements
+// sdk.CustomerWriter, otherwise the change is audited for the CUSTOMERS
+// file to be edited by hand.
+func handleMarketplacePurchase(event MarketplacePurchaseEvent, store sdk.CustomerStore) sdk.Response {
+	purchase := event.MarketplacePurchase
+	login := purchase.Account.Login
+	if len(login) == 0 {
+		return sdk.Rejected(http.StatusBadRequest, "marketplace_purchase: no account in event")
+	}
+
+	plan := planFor(purchase, getMarketplacePlans())
+
+	var message string
+	switch event.Action {
+	case "purchased", "changed":
+		message = fmt.Sprintf("marketplace: %s %s plan %s, tier: %s", login, event.Action, purchase.Plan.Name, plan.Tier)
+	case "cancelled":
+		message = fmt.Sprintf("marketplace: %s cancelled plan %s", login, purchase.Plan.Name)
+	default:
+		message = fmt.Sprintf("marketplace: %s %s for plan %s, effective: %s", login, event.Action, purchase.Plan.Name, event.EffectiveDate)
+
+		sdk.PostAudit(sdk.AuditEvent{Message: message, Owner: login, Source: Source})
+		return sdk.OK(message)
+	}
+
+	writer, ok := store.(sdk.CustomerWriter)
+	if !ok {
+		message = fmt.Sprintf("%s, update %s by hand", message, store)
+
+		sdk.PostAudit(sdk.AuditEvent{Message: message, Owner: login, Source: Source})
+		return sdk.Skipped(message)
+	}
+
+	var err error
+	if event.Action == "cancelled" {
+		err = writer.Delete(login)
+	} else {
+		err = writer.Put(sdk.CustomerSpec{
+			Login:        login,
+			Plan:         plan.Tier,
+			MaxFunctions: plan.MaxFunctions,
+		})
+	}
+
+	if err != nil {
+		log.Printf("unable to update customer %s: %s", login, err.Error())
+
+		sdk.PostAudit(sdk.AuditEvent{Message: fmt.Sprintf("%s, unable to update customer: %s", message, err.Error()), Owner: login, Source: Source})
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("%s: %s", message, err.Error()))
+	}
+
+	sdk.PostAudit(sdk.AuditEvent{Message: message, Owner: login, Source: Source})
+	return sdk.OK(message)
+}
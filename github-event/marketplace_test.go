@@ -0,0 +1,115 @@
+package function
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// fakeCustomerWriter records the customers put and deleted
+type fakeCustomerWriter struct {
+	put     []sdk.CustomerSpec
+	deleted []string
+	err     error
+}
+
+func (w *fakeCustomerWriter) List() ([]sdk.CustomerInfo, error) { return nil, nil }
+
+func (w *fakeCustomerWriter) String() string { return "fake" }
+
+func (w *fakeCustomerWriter) Put(spec sdk.CustomerSpec) error {
+	w.put = append(w.put, spec)
+	return w.err
+}
+
+func (w *fakeCustomerWriter) Delete(login string) error {
+	w.deleted = append(w.deleted, login)
+	return w.err
+}
+
+func marketplaceEvent(action, login, plan string) MarketplacePurchaseEvent {
+	event := MarketplacePurchaseEvent{Action: action}
+	event.MarketplacePurchase.Account.Login = login
+	event.MarketplacePurchase.Plan.Name = plan
+	return event
+}
+
+func Test_getMarketplacePlans(t *testing.T) {
+	os.Setenv("marketplace_plans", "Pro=pro:50, Team Plan=Team,broken")
+	defer os.Unsetenv("marketplace_plans")
+
+	plans := getMarketplacePlans()
+
+	if plans["pro"] != (marketplacePlan{Tier: "pro", MaxFunctions: 50}) {
+		t.Errorf("want pro with 50 functions, got %+v", plans["pro"])
+	}
+	if plans["team plan"] != (marketplacePlan{Tier: "team"}) {
+		t.Errorf("want team without a quota, got %+v", plans["team plan"])
+	}
+	if len(plans) != 2 {
+		t.Errorf("want 2 plans, got %v", plans)
+	}
+}
+
+func Test_handleMarketplacePurchase_Purchased(t *testing.T) {
+	os.Setenv("marketplace_plans", "Pro=pro:50")
+	defer os.Unsetenv("marketplace_plans")
+
+	store := &fakeCustomerWriter{}
+	res := handleMarketplacePurchase(marketplaceEvent("purchased", "alexellis", "Pro"), store)
+
+	if res.Status != sdk.ResponseOK {
+		t.Errorf("want OK, got %+v", res)
+	}
+	want := sdk.CustomerSpec{Login: "alexellis", Plan: "pro", MaxFunctions: 50}
+	if len(store.put) != 1 || fmt.Sprintf("%+v", store.put[0]) != fmt.Sprintf("%+v", want) {
+		t.Errorf("want %+v, got %+v", want, store.put)
+	}
+}
+
+func Test_handleMarketplacePurchase_UnlistedPlan(t *testing.T) {
+	store := &fakeCustomerWriter{}
+	handleMarketplacePurchase(marketplaceEvent("changed", "alexellis", "Open Source"), store)
+
+	if len(store.put) != 1 || store.put[0].Plan != "open-source" || store.put[0].MaxFunctions != 0 {
+		t.Errorf("want the plan's name as the tier, got %+v", store.put)
+	}
+}
+
+func Test_handleMarketplacePurchase_Cancelled(t *testing.T) {
+	store := &fakeCustomerWriter{}
+	handleMarketplacePurchase(marketplaceEvent("cancelled", "alexellis", "Pro"), store)
+
+	if len(store.deleted) != 1 || store.deleted[0] != "alexellis" || len(store.put) != 0 {
+		t.Errorf("want alexellis removed, got %v", store.deleted)
+	}
+}
+
+func Test_handleMarketplacePurchase_PendingChange(t *testing.T) {
+	store := &fakeCustomerWriter{}
+	res := handleMarketplacePurchase(marketplaceEvent("pending_change", "alexellis", "Free"), store)
+
+	if res.Status != sdk.ResponseOK || len(store.put)+len(store.deleted) != 0 {
+		t.Errorf("want the customer left until the change takes effect, got %+v", res)
+	}
+}
+
+func Test_handleMarketplacePurchase_ReadOnlyStore(t *testing.T) {
+	res := handleMarketplacePurchase(marketplaceEvent("purchased", "alexellis", "Pro"), &sdk.FileCustomerStore{Path: "/var/secrets/customers"})
+
+	if res.Status != sdk.ResponseSkipped {
+		t.Errorf("want the purchase skipped for a read-only store, got %+v", res)
+	}
+}
+
+func Test_handleMarketplacePurchase_StoreError(t *testing.T) {
+	store := &fakeCustomerWriter{err: fmt.Errorf("forbidden")}
+	res := handleMarketplacePurchase(marketplaceEvent("purchased", "alexellis", "Pro"), store)
+
+	if res.Status != sdk.ResponseFailed || res.Code != http.StatusBadGateway {
+		t.Errorf("want a failure so that GitHub can redeliver, got %+v", res)
+	}
+}
@@ -0,0 +1,86 @@
+package function
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// jetStreamQueue is the value of event_queue which queues events to NATS
+// JetStream for event-consumer in place of forwarding them
+const jetStreamQueue = "jetstream"
+
+// eventQueue reads event_queue, events are forwarded straight away
+// unless it is jetstream
+func eventQueue() string {
+	return os.Getenv("event_queue")
+}
+
+// queueEvent publishes the event for the function to the EventStream on
+// nats_url. The delivery's GUID is given as the message ID, so that
+// JetStream stores a redelivery once within its duplicate window.
+func queueEvent(function string, headers map[string]string, req []byte, deliveryID string) (sdk.PubAck, error) {
+	js, err := sdk.ConnectJetStream(os.Getenv("nats_url"))
+	if err != nil {
+		return sdk.PubAck{}, err
+	}
+	defer js.Close()
+
+	subjects := []string{sdk.EventSubjectPrefix + ">"}
+	if err := js.EnsureStream(sdk.EventStream, subjects, getDuration("event_max_age", 24*time.Hour), 2*time.Minute); err != nil {
+		return sdk.PubAck{}, err
+	}
+
+	event, _ := json.Marshal(sdk.QueuedEvent{
+		Function: function,
+		Headers:  headers,
+		Payload:  req,
+		Queued:   clock.Now(),
+	})
+
+	ack, err := js.Publish(sdk.EventSubjectPrefix+function, deliveryID, event)
+	if err != nil {
+		return ack, err
+	}
+
+	wakeConsumer()
+	return ack, nil
+}
+
+// wakeConsumer invokes event-consumer asynchronously so that the event
+// is handled without waiting for its schedule, it is only logged when
+// the consumer can't be reached. The empty body is signed with the
+// payload-secret, since event-consumer only accepts signed invocations
+// other than its schedule's.
+func wakeConsumer() {
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		log.Printf("unable to wake event-consumer: %s", err.Error())
+		return
+	}
+
+	r, _ := http.NewRequest(http.MethodPost, os.Getenv("gateway_url")+"async-function/event-consumer", nil)
+
+	digest := hmac.Sign([]byte{}, []byte(payloadSecret))
+	r.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+	res, err := sdk.HTTPClient().Do(r)
+	if err != nil {
+		log.Printf("unable to wake event-consumer: %s", err.Error())
+		return
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusAccepted {
+		log.Printf("unable to wake event-consumer: %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,81 @@
+package function
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
+)
+
+func Test_queueEvent(t *testing.T) {
+	queued := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock = sdktest.NewFixedClock(queued)
+	defer func() { clock = sdk.SystemClock{} }()
+
+	defer setupSecrets(t)()
+
+	js := sdktest.NewFakeJetStream()
+	defer js.Close()
+
+	woken := false
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		woken = r.URL.Path == "/async-function/event-consumer" &&
+			hmac.Validate([]byte{}, r.Header.Get(sdk.CloudSignatureHeader), "secret") == nil
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	os.Setenv("nats_url", js.URL)
+	defer os.Unsetenv("nats_url")
+	os.Setenv("gateway_url", s.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	headers := map[string]string{"X-Github-Event": "push"}
+	ack, err := queueEvent("github-push", headers, []byte(`{"ref":"refs/heads/master"}`), "delivery-1")
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if ack.Sequence != 1 {
+		t.Errorf("want the event stored as 1, got %d", ack.Sequence)
+	}
+
+	ack, err = queueEvent("github-push", headers, []byte(`{"ref":"refs/heads/master"}`), "delivery-1")
+	if err != nil || !ack.Duplicate {
+		t.Errorf("want a redelivery stored once, got %v, %v", ack, err)
+	}
+
+	msgs := js.Messages()
+	if len(msgs) != 1 || msgs[0].Subject != sdk.EventSubjectPrefix+"github-push" {
+		t.Fatalf("want one event for github-push, got %v", msgs)
+	}
+
+	event := sdk.QueuedEvent{}
+	if err := json.Unmarshal(msgs[0].Data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Function != "github-push" || event.Headers["X-Github-Event"] != "push" || string(event.Payload) != `{"ref":"refs/heads/master"}` {
+		t.Errorf("want the event queued as received, got %v", event)
+	}
+	if !event.Queued.Equal(queued) {
+		t.Errorf("want the event stamped by the clock, got %s", event.Queued)
+	}
+
+	if !woken {
+		t.Errorf("want event-consumer invoked with a signature")
+	}
+}
+
+func Test_queueEvent_Unavailable(t *testing.T) {
+	os.Setenv("nats_url", "nats://127.0.0.1:1")
+	defer os.Unsetenv("nats_url")
+
+	if _, err := queueEvent("github-push", map[string]string{}, []byte("{}"), "delivery-1"); err == nil {
+		t.Errorf("want an error when NATS can't be reached, so that the event is forwarded")
+	}
+}
@@ -0,0 +1,149 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// rateLimitRepoPath is where the token buckets are kept in pipeline-log,
+// each under its key
+const rateLimitRepoPath = "system/rate-limits"
+
+// rateLimit allows Events per Interval for each installation, with up
+// to Burst events at once
+type rateLimit struct {
+	Events   int
+	Interval time.Duration
+	Burst    int
+}
+
+// getRateLimit reads rate_limit, the events allowed per
+// rate_limit_interval (default 1m), and rate_limit_burst which defaults
+// to rate_limit. Events are not limited unless rate_limit is set.
+func getRateLimit() rateLimit {
+	limit := rateLimit{
+		Interval: getDuration("rate_limit_interval", time.Minute),
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("rate_limit")); err == nil && val > 0 {
+		limit.Events = val
+	}
+
+	limit.Burst = limit.Events
+	if val, err := strconv.Atoi(os.Getenv("rate_limit_burst")); err == nil && val > 0 {
+		limit.Burst = val
+	}
+
+	return limit
+}
+
+// enabled is false when rate_limit is not set
+func (l rateLimit) enabled() bool {
+	return l.Events > 0 && l.Interval > 0
+}
+
+// rateBucket is stored for each installation, Tokens are refilled at
+// the rate of the limit since Updated
+type rateBucket struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+// take refills the bucket up to the burst and takes a token for an
+// event. When none is left, the wait until the next token is returned.
+func (b rateBucket) take(limit rateLimit, now time.Time) (rateBucket, bool, time.Duration) {
+	perSecond := float64(limit.Events) / limit.Interval.Seconds()
+
+	tokens := float64(limit.Burst)
+	if !b.Updated.IsZero() {
+		elapsed := now.Sub(b.Updated).Seconds()
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		tokens = math.Min(float64(limit.Burst), b.Tokens+elapsed*perSecond)
+	}
+
+	if tokens < 1 {
+		wait := time.Duration((1 - tokens) / perSecond * float64(time.Second))
+		return rateBucket{Tokens: tokens, Updated: now}, false, wait
+	}
+
+	return rateBucket{Tokens: tokens - 1, Updated: now}, true, 0
+}
+
+// rateLimitKey gives the bucket for an event, its installation of the
+// GitHub App or the owner when there is none
+func rateLimitKey(event sdk.PushEvent) string {
+	if event.Installation.ID > 0 {
+		return fmt.Sprintf("installation-%d", event.Installation.ID)
+	}
+	return "owner-" + strings.ToLower(event.Repository.Owner.Login)
+}
+
+// allowEvent takes a token from the bucket of the key, the wait until
+// an event would be allowed is returned when it is throttled. An error
+// is returned when the bucket can't be read or written, so that events
+// are not let through without a limit while pipeline-log is down.
+//
+// pipeline-log can't compare and swap a record, so the bucket is read
+// and written back without a lock. Events of the same key received at
+// the same time may take the same token, which lets through at most one
+// extra event for each of them; a webhook loop is still throttled.
+func allowEvent(key string, limit rateLimit, now time.Time) (bool, time.Duration, error) {
+	bucket := rateBucket{}
+
+	body, err := sdk.ReadPipelineLog(os.Getenv("gateway_url"), sdk.PipelineLog{
+		RepoPath:  rateLimitRepoPath,
+		CommitSHA: key,
+		Function:  Source,
+		Source:    sdk.RateLimitSource,
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &bucket); err != nil {
+			return false, 0, fmt.Errorf("unable to parse the bucket: %s", err.Error())
+		}
+	}
+
+	bucket, allowed, wait := bucket.take(limit, now)
+
+	bucketBytes, _ := json.Marshal(bucket)
+	writeErr := writeRecord(sdk.PipelineLog{
+		RepoPath:  rateLimitRepoPath,
+		CommitSHA: key,
+		Function:  Source,
+		Source:    sdk.RateLimitSource,
+		Data:      string(bucketBytes),
+	})
+	if writeErr != nil {
+		return false, 0, writeErr
+	}
+
+	return allowed, wait, nil
+}
+
+// throttled audits an event which was over the rate limit and returns
+// the response for the caller
+func throttled(eventHeader string, event sdk.PushEvent, key string, wait time.Duration) sdk.Response {
+	msg := fmt.Sprintf("%s event for %s throttled, %s is over the rate limit, try again in %s or replay its dead-letter",
+		eventHeader, event.Repository.FullName, key, time.Duration(math.Ceil(wait.Seconds()))*time.Second)
+
+	sdk.PostAudit(sdk.AuditEvent{
+		Message: msg,
+		Owner:   event.Repository.Owner.Login,
+		Repo:    event.Repository.Name,
+		Source:  Source,
+	}.Trace(event.AfterCommitID, event.Delivery))
+
+	return sdk.Rejected(http.StatusTooManyRequests, msg)
+}
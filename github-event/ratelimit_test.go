@@ -0,0 +1,189 @@
+package function
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_rateBucket_take(t *testing.T) {
+	limit := rateLimit{Events: 6, Interval: time.Minute, Burst: 2}
+	now := time.Now()
+
+	bucket := rateBucket{}
+	for i := 0; i < 2; i++ {
+		var allowed bool
+		if bucket, allowed, _ = bucket.take(limit, now); !allowed {
+			t.Fatalf("want event %d of the burst allowed", i+1)
+		}
+	}
+
+	bucket, allowed, wait := bucket.take(limit, now)
+	if allowed {
+		t.Errorf("want an event over the burst throttled")
+	}
+	if wait != 10*time.Second {
+		t.Errorf("want a wait of 10s for the next token, got %s", wait)
+	}
+
+	if _, allowed, _ = bucket.take(limit, now.Add(10*time.Second)); !allowed {
+		t.Errorf("want an event allowed once a token was refilled")
+	}
+
+	if bucket, _, _ = bucket.take(limit, now.Add(time.Hour)); bucket.Tokens != 1 {
+		t.Errorf("want the bucket refilled up to the burst, got %f tokens", bucket.Tokens)
+	}
+}
+
+func Test_getRateLimit(t *testing.T) {
+	if getRateLimit().enabled() {
+		t.Errorf("want no limit unless rate_limit is set")
+	}
+
+	os.Setenv("rate_limit", "30")
+	defer os.Unsetenv("rate_limit")
+
+	limit := getRateLimit()
+	if !limit.enabled() || limit.Burst != 30 || limit.Interval != time.Minute {
+		t.Errorf("want 30 a minute with a burst of 30, got %+v", limit)
+	}
+
+	os.Setenv("rate_limit_burst", "5")
+	defer os.Unsetenv("rate_limit_burst")
+
+	if limit := getRateLimit(); limit.Burst != 5 {
+		t.Errorf("want a burst of 5, got %d", limit.Burst)
+	}
+}
+
+func Test_rateLimitKey(t *testing.T) {
+	event := sdk.PushEvent{}
+	event.Repository.Owner.Login = "AlexEllis"
+
+	if key := rateLimitKey(event); key != "owner-alexellis" {
+		t.Errorf("want the owner without an installation, got %s", key)
+	}
+
+	event.Installation.ID = 1234
+	if key := rateLimitKey(event); key != "installation-1234" {
+		t.Errorf("want the installation, got %s", key)
+	}
+}
+
+func Test_Handle_Throttled(t *testing.T) {
+	defer setupSecrets(t)()
+
+	buckets := map[string]string{}
+	deadLetters := []sdk.PipelineLog{}
+	forwarded := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/function/pipeline-log":
+			if r.Method == http.MethodPost {
+				body, _ := ioutil.ReadAll(r.Body)
+				p := sdk.PipelineLog{}
+				json.Unmarshal(body, &p)
+				if p.Source == sdk.DeadLetterSource {
+					deadLetters = append(deadLetters, p)
+					return
+				}
+				if p.Source != sdk.RateLimitSource || p.RepoPath != rateLimitRepoPath {
+					t.Errorf("unexpected pipeline log %+v", p)
+				}
+				buckets[p.CommitSHA] = p.Data
+				return
+			}
+			w.Write([]byte(buckets[r.URL.Query().Get("commitSHA")]))
+		case "/function/github-push":
+			forwarded++
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	os.Setenv("validate_hmac", "false")
+	os.Setenv("validate_customers", "false")
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("rate_limit", "1")
+	os.Setenv("rate_limit_interval", "1h")
+	os.Setenv("dedupe_deliveries", "false")
+	defer os.Unsetenv("dedupe_deliveries")
+	defer os.Unsetenv("gateway_url")
+	defer os.Unsetenv("validate_hmac")
+	defer os.Unsetenv("validate_customers")
+	defer os.Unsetenv("Http_X_Github_Event")
+	defer os.Unsetenv("rate_limit")
+	defer os.Unsetenv("rate_limit_interval")
+
+	req := []byte(`{"ref":"refs/heads/master","after":"af6db","installation":{"id":1234},"repository":{"name":"fn","full_name":"alexellis/fn","owner":{"login":"alexellis"}}}`)
+
+	handle(req)
+	got := handle(req)
+
+	if forwarded != 1 {
+		t.Errorf("want only the first event forwarded, got %d", forwarded)
+	}
+	if !strings.HasPrefix(got.Reason, "push event for alexellis/fn throttled") || got.Code != http.StatusTooManyRequests {
+		t.Errorf("want a throttled response, got %+v", got)
+	}
+	if _, ok := buckets["installation-1234"]; !ok {
+		t.Errorf("want the bucket kept for the installation, got %v", buckets)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].RepoPath != "alexellis/fn" || deadLetters[0].CommitSHA != "af6db" || deadLetters[0].Function != "github-push" {
+		t.Errorf("want the throttled event kept as a dead-letter to replay, got %+v", deadLetters)
+	}
+}
+
+func Test_Handle_RateLimitUnreadable(t *testing.T) {
+	defer setupSecrets(t)()
+
+	deadLetters := 0
+	forwarded := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/function/pipeline-log":
+			body, _ := ioutil.ReadAll(r.Body)
+			p := sdk.PipelineLog{}
+			json.Unmarshal(body, &p)
+			if r.Method == http.MethodPost && p.Source == sdk.DeadLetterSource {
+				deadLetters++
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		case "/function/github-push":
+			forwarded++
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	os.Setenv("validate_hmac", "false")
+	os.Setenv("validate_customers", "false")
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("rate_limit", "1")
+	defer os.Unsetenv("gateway_url")
+	defer os.Unsetenv("validate_hmac")
+	defer os.Unsetenv("validate_customers")
+	defer os.Unsetenv("Http_X_Github_Event")
+	defer os.Unsetenv("rate_limit")
+
+	req := []byte(`{"ref":"refs/heads/master","after":"af6db","installation":{"id":1234},"repository":{"name":"fn","full_name":"alexellis/fn","owner":{"login":"alexellis"}}}`)
+
+	got := handle(req)
+	if forwarded != 0 {
+		t.Errorf("want nothing forwarded without a rate limit, got %d", forwarded)
+	}
+	if got.Code != http.StatusServiceUnavailable {
+		t.Errorf("want the event failed when the rate limit can't be read, got %+v", got)
+	}
+	if deadLetters != 1 {
+		t.Errorf("want the event kept as a dead-letter to replay, got %d", deadLetters)
+	}
+}
@@ -0,0 +1,335 @@
+package function
+
+import (
+	cryptohmac "crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// receivedRecord is an event as it was forwarded, so that it can be
+// forwarded again
+type receivedRecord struct {
+	sdk.ReceivedEvent
+	Function string            `json:"function"`
+	Headers  map[string]string `json:"headers"`
+	Payload  []byte            `json:"payload"`
+}
+
+// receivedHistory reads received_events, how many of each owner's
+// recent events are kept so that they can replay them. None are kept
+// by default.
+func receivedHistory() int {
+	if val, err := strconv.Atoi(os.Getenv("received_events")); err == nil && val > 0 {
+		return val
+	}
+	return 0
+}
+
+// signatureHeaders are not kept with a received event, a replay is
+// signed again when it is forwarded
+var signatureHeaders = []string{
+	sdk.GitHubSignatureHeader,
+	sdk.GitHubSignature256Header,
+	sdk.CustomerSignatureHeader,
+	sdk.DeliveryHeader,
+	sdk.DeliverySignatureHeader,
+}
+
+// receivedKeyPattern matches the keys of received events, an event kept
+// before they were keyed by time is not listed
+var receivedKeyPattern = regexp.MustCompile(`^[0-9]{20}-.+$`)
+
+func receivedRepoPath(owner string) string {
+	return "system/received/" + strings.ToLower(owner)
+}
+
+// recordReceived keeps the event for its owner, under the time it was
+// received followed by the delivery's GUID, or its correlation ID when
+// GitHub gave none. Each event is written on its own, so that events
+// received at the same time for an owner don't overwrite each other,
+// and the owner's oldest events beyond the history are removed.
+func recordReceived(event *sdk.PushEvent, eventHeader string, delivery sdk.Delivery, function string, headers map[string]string, req []byte, history int) error {
+	owner := event.Repository.Owner.Login
+	if len(owner) == 0 {
+		return fmt.Errorf("no owner for %s event", eventHeader)
+	}
+
+	id := delivery.ID
+	if len(id) == 0 {
+		id = delivery.CorrelationID
+	}
+
+	record := receivedRecord{
+		ReceivedEvent: sdk.ReceivedEvent{
+			ID:       id,
+			Event:    eventHeader,
+			Repo:     event.Repository.Name,
+			Ref:      event.Ref,
+			SHA:      event.AfterCommitID,
+			Sender:   event.Sender.Login,
+			Received: delivery.Received,
+		},
+		Function: function,
+		Headers:  map[string]string{},
+		Payload:  req,
+	}
+	for k, v := range headers {
+		record.Headers[k] = v
+	}
+	for _, k := range signatureHeaders {
+		delete(record.Headers, k)
+	}
+
+	recordBytes, _ := json.Marshal(record)
+	err := writeRecord(sdk.PipelineLog{
+		RepoPath:  receivedRepoPath(owner),
+		CommitSHA: receivedKey(delivery.Received, id),
+		Function:  Source,
+		Source:    sdk.ReceivedEventSource,
+		Data:      string(recordBytes),
+	})
+	if err != nil {
+		return err
+	}
+
+	return pruneReceived(owner, history)
+}
+
+// pruneReceived removes the owner's events older than the most recent
+// history, so that what is kept for replay doesn't grow without bound
+func pruneReceived(owner string, history int) error {
+	keys, err := sdk.ListPipelineLog(os.Getenv("gateway_url"), sdk.PipelineLog{
+		RepoPath: receivedRepoPath(owner),
+		Function: Source,
+		Source:   sdk.ReceivedEventSource,
+	})
+	if err != nil {
+		return err
+	}
+
+	received := []string{}
+	for _, key := range keys {
+		if receivedKeyPattern.MatchString(key) {
+			received = append(received, key)
+		}
+	}
+	if len(received) <= history {
+		return nil
+	}
+
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range received[:len(received)-history] {
+		err := sdk.DeletePipelineLog(os.Getenv("gateway_url"), payloadSecret, sdk.PipelineLog{
+			RepoPath:  receivedRepoPath(owner),
+			CommitSHA: key,
+			Function:  Source,
+			Source:    sdk.ReceivedEventSource,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to remove event %s: %s", key, err.Error())
+		}
+	}
+	return nil
+}
+
+// receivedKey sorts the owner's events by the time they were received
+func receivedKey(received time.Time, id string) string {
+	return fmt.Sprintf("%020d-%s", received.UnixNano(), id)
+}
+
+// recentReceivedKeys gives the keys of the owner's most recent events,
+// newest first
+func recentReceivedKeys(owner string, history int) ([]string, error) {
+	keys, err := sdk.ListPipelineLog(os.Getenv("gateway_url"), sdk.PipelineLog{
+		RepoPath: receivedRepoPath(owner),
+		Function: Source,
+		Source:   sdk.ReceivedEventSource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	recent := []string{}
+	for i := len(keys) - 1; i >= 0 && len(recent) < history; i-- {
+		if receivedKeyPattern.MatchString(keys[i]) {
+			recent = append(recent, keys[i])
+		}
+	}
+	return recent, nil
+}
+
+// readReceived reads the record kept under the key
+func readReceived(owner string, key string) (*receivedRecord, error) {
+	body, err := sdk.ReadPipelineLog(os.Getenv("gateway_url"), sdk.PipelineLog{
+		RepoPath:  receivedRepoPath(owner),
+		CommitSHA: key,
+		Function:  Source,
+		Source:    sdk.ReceivedEventSource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	record := receivedRecord{}
+	if err := json.Unmarshal(body, &record); err != nil {
+		return nil, fmt.Errorf("unable to parse event %s: %s", key, err.Error())
+	}
+	return &record, nil
+}
+
+// readReceivedEvents gives the owner's most recent events, newest first
+func readReceivedEvents(owner string, history int) ([]sdk.ReceivedEvent, error) {
+	keys, err := recentReceivedKeys(owner, history)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []sdk.ReceivedEvent{}
+	for _, key := range keys {
+		record, err := readReceived(owner, key)
+		if err != nil {
+			log.Printf("skipping received event: %s", err.Error())
+			continue
+		}
+		events = append(events, record.ReceivedEvent)
+	}
+	return events, nil
+}
+
+// parseReceivedRequest checks the request was signed with the
+// payload-secret, i.e. by edge-auth once the user has signed in
+func parseReceivedRequest(req []byte) (sdk.ReceivedEventRequest, error) {
+	receivedReq := sdk.ReceivedEventRequest{}
+
+	if err := sdk.ValidHMAC(&req, "payload-secret", os.Getenv("Http_X_Cloud_Signature")); err != nil {
+		return receivedReq, err
+	}
+
+	if err := json.Unmarshal(req, &receivedReq); err != nil {
+		return receivedReq, fmt.Errorf("unable to parse request: %s", err.Error())
+	}
+
+	if len(receivedReq.Owner) == 0 {
+		return receivedReq, fmt.Errorf("owner is required")
+	}
+
+	return receivedReq, nil
+}
+
+// listReceived gives the owner's recent events as a JSON array, or an
+// sdk.Response when they can't be listed
+func listReceived(req []byte) string {
+	receivedReq, err := parseReceivedRequest(req)
+	if err != nil {
+		return sdk.Rejected(http.StatusUnauthorized, fmt.Sprintf("received events: %s", err.Error())).JSON()
+	}
+
+	events, err := readReceivedEvents(receivedReq.Owner, receivedHistory())
+	if err != nil {
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("received events: %s", err.Error())).JSON()
+	}
+
+	out, _ := json.Marshal(events)
+	return string(out)
+}
+
+// signReplay signs a received event again, as its signatures are not
+// kept: with the github-webhook-secret as GitHub signed it, when HMAC is
+// validated, and with the payload-secret for its customer and delivery
+func signReplay(headers map[string]string, delivery sdk.Delivery, payload []byte) error {
+	if sdk.HmacEnabled() {
+		webhookSecret, err := sdk.ReadSecret("github-webhook-secret")
+		if err != nil {
+			return err
+		}
+
+		mac := cryptohmac.New(sha256.New, []byte(webhookSecret))
+		mac.Write(payload)
+		headers[sdk.GitHubSignature256Header] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		headers[sdk.GitHubSignatureHeader] = "sha1=" + hex.EncodeToString(hmac.Sign(payload, []byte(webhookSecret)))
+	}
+
+	if value, ok := headers[sdk.CustomerHeader]; ok {
+		payloadSecret, err := sdk.ReadSecret("payload-secret")
+		if err != nil {
+			return err
+		}
+		headers[sdk.CustomerSignatureHeader] = "sha1=" + hex.EncodeToString(hmac.Sign([]byte(value), []byte(payloadSecret)))
+	}
+
+	return addDeliveryHeaders(headers, delivery, payload)
+}
+
+// replayReceived forwards one of the owner's recent events again with a
+// new correlation ID. Only the events still in the owner's list can be
+// replayed, and they are not checked against earlier deliveries.
+func replayReceived(req []byte) sdk.Response {
+	receivedReq, err := parseReceivedRequest(req)
+	if err != nil {
+		return sdk.Rejected(http.StatusUnauthorized, fmt.Sprintf("replay event: %s", err.Error()))
+	}
+
+	keys, err := recentReceivedKeys(receivedReq.Owner, receivedHistory())
+	if err != nil {
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("replay event: %s", err.Error()))
+	}
+
+	key := ""
+	for _, recent := range keys {
+		if strings.SplitN(recent, "-", 2)[1] == receivedReq.ID {
+			key = recent
+			break
+		}
+	}
+	if len(key) == 0 || len(receivedReq.ID) == 0 {
+		return sdk.Rejected(http.StatusNotFound, fmt.Sprintf("replay event: %q is not one of the recent events of %s", receivedReq.ID, receivedReq.Owner))
+	}
+
+	record, err := readReceived(receivedReq.Owner, key)
+	if err != nil {
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("replay event: %s", err.Error()))
+	}
+	if len(record.Payload) == 0 {
+		return sdk.Failed(http.StatusNotFound, fmt.Sprintf("replay event: no event found for %s", receivedReq.ID))
+	}
+
+	headers := map[string]string{}
+	for k, v := range record.Headers {
+		headers[k] = v
+	}
+
+	delivery := sdk.Delivery{ID: record.ID, Event: record.Event, Received: record.Received, CorrelationID: ids.NewID()}
+	if err := signReplay(headers, delivery, record.Payload); err != nil {
+		return sdk.Failed(http.StatusInternalServerError, fmt.Sprintf("replay event: %s", err.Error()))
+	}
+
+	resBody, statusCode, attempts, err := forwardWithRetry(record.Payload, record.Function, headers, getRetryPolicy())
+	if err != nil {
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("replay event: [%s]: %d after %d attempts, %s", record.Function, statusCode, attempts, err.Error()))
+	}
+
+	sdk.PostAudit(sdk.AuditEvent{
+		Source:  Source,
+		Owner:   receivedReq.Owner,
+		Repo:    record.Repo,
+		Message: fmt.Sprintf("replayed %s event %s by its owner", record.Event, record.ID),
+	}.Trace(record.SHA, &delivery))
+
+	return forwarded(record.Function, statusCode, resBody)
+}
@@ -0,0 +1,276 @@
+package function
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
+)
+
+// fakeReceivedStore keeps the received events written to pipeline-log
+// and the events forwarded to github-push
+func fakeReceivedStore(t *testing.T) (*httptest.Server, map[string]string, *[]*http.Request) {
+	store := map[string]string{}
+	forwarded := []*http.Request{}
+	mutex := sync.Mutex{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.URL.Path == "/function/github-push" {
+			forwarded = append(forwarded, r)
+			w.Write([]byte(`{"status": "accepted", "code": 202, "reason": "git-tar invoked"}`))
+			return
+		}
+
+		if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+			body, _ := ioutil.ReadAll(r.Body)
+			p := sdk.PipelineLog{}
+			json.Unmarshal(body, &p)
+			if p.Source != sdk.ReceivedEventSource {
+				t.Errorf("unexpected pipeline log %+v", p)
+			}
+			if r.Method == http.MethodDelete {
+				delete(store, p.RepoPath+"/"+p.CommitSHA)
+				return
+			}
+			store[p.RepoPath+"/"+p.CommitSHA] = p.Data
+			return
+		}
+
+		query := r.URL.Query()
+		if query.Get("list") == "true" {
+			keys := []string{}
+			for path := range store {
+				if strings.HasPrefix(path, query.Get("repoPath")+"/") {
+					keys = append(keys, strings.TrimPrefix(path, query.Get("repoPath")+"/"))
+				}
+			}
+			sort.Strings(keys)
+			json.NewEncoder(w).Encode(keys)
+			return
+		}
+		w.Write([]byte(store[query.Get("repoPath")+"/"+query.Get("commitSHA")]))
+	}))
+
+	return server, store, &forwarded
+}
+
+func recordPush(t *testing.T, deliveryID string, sha string, minute int) {
+	recordPushKeeping(t, deliveryID, sha, minute, 20)
+}
+
+func recordPushKeeping(t *testing.T, deliveryID string, sha string, minute int, history int) {
+	event := sdktest.GitHubPush("alexellis", "fn1", "master", sha)
+	delivery := sdk.Delivery{ID: deliveryID, Event: "push", Received: time.Date(2026, 10, 1, 12, minute, 0, 0, time.UTC), CorrelationID: "first"}
+	headers := map[string]string{
+		"X-GitHub-Event":             "push",
+		sdk.GitHubSignatureHeader:    "sha1=original",
+		sdk.GitHubSignature256Header: "sha256=original",
+		sdk.CustomerHeader:           `{"login":"alexellis"}`,
+		sdk.CustomerSignatureHeader:  "sha1=original",
+	}
+
+	if err := recordReceived(&event, "push", delivery, "github-push", headers, sdktest.Payload(event), history); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_recordReceived_KeepsRecentEvents(t *testing.T) {
+	defer setupSecrets(t)()
+
+	server, store, _ := fakeReceivedStore(t)
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	recordPush(t, "guid-3", "c7e02", 3)
+	recordPush(t, "guid-1", "af6db", 1)
+	recordPush(t, "guid-2", "b3cc1", 2)
+
+	events, err := readReceivedEvents("AlexEllis", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].ID != "guid-3" || events[1].ID != "guid-2" {
+		t.Fatalf("want the two most recent events, got %+v", events)
+	}
+	if events[0].Repo != "fn1" || events[0].SHA != "c7e02" || events[0].Ref != "refs/heads/master" {
+		t.Errorf("want the push described, got %+v", events[0])
+	}
+
+	if len(store) != 3 {
+		t.Errorf("want each event kept on its own, got %v", store)
+	}
+	if _, ok := store["system/received/alexellis/"+receivedKey(time.Date(2026, 10, 1, 12, 3, 0, 0, time.UTC), "guid-3")]; !ok {
+		t.Errorf("want the event kept under the time it was received and its delivery, got %v", store)
+	}
+}
+
+func Test_recordReceived_PrunesOldEvents(t *testing.T) {
+	defer setupSecrets(t)()
+
+	server, store, _ := fakeReceivedStore(t)
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	for minute, id := range []string{"guid-1", "guid-2", "guid-3", "guid-4"} {
+		recordPushKeeping(t, id, "af6db", minute, 2)
+	}
+
+	keys := []string{}
+	for key := range store {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || !strings.HasSuffix(keys[0], "-guid-3") || !strings.HasSuffix(keys[1], "-guid-4") {
+		t.Errorf("want only the two most recent events kept, got %v", keys)
+	}
+}
+
+func Test_recordReceived_DropsSignatures(t *testing.T) {
+	defer setupSecrets(t)()
+
+	server, store, _ := fakeReceivedStore(t)
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	defer os.Unsetenv("gateway_url")
+
+	recordPush(t, "guid-1", "af6db", 0)
+
+	for _, data := range store {
+		record := receivedRecord{}
+		json.Unmarshal([]byte(data), &record)
+		for _, header := range signatureHeaders {
+			if _, ok := record.Headers[header]; ok {
+				t.Errorf("want %s not kept, got %v", header, record.Headers)
+			}
+		}
+		if record.Headers[sdk.CustomerHeader] == "" {
+			t.Errorf("want the customer kept to be signed again, got %v", record.Headers)
+		}
+	}
+}
+
+func Test_listReceived(t *testing.T) {
+	defer setupSecrets(t)()
+
+	server, _, _ := fakeReceivedStore(t)
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	defer os.Unsetenv("gateway_url")
+	os.Setenv("received_events", "20")
+	defer os.Unsetenv("received_events")
+
+	recordPush(t, "guid-1", "af6db", 0)
+
+	body := sdktest.Payload(sdk.ReceivedEventRequest{Owner: "alexellis"})
+	os.Setenv("Http_X_Cloud_Signature", sdktest.Sign(body, "secret"))
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	index := []sdk.ReceivedEvent{}
+	if err := json.Unmarshal([]byte(listReceived(body)), &index); err != nil {
+		t.Fatalf("want a list of events, got %s", err)
+	}
+	if len(index) != 1 || index[0].ID != "guid-1" {
+		t.Errorf("want the recorded push, got %+v", index)
+	}
+
+	os.Setenv("Http_X_Cloud_Signature", sdktest.Sign(body, "wrong-secret"))
+	res := sdk.Response{}
+	json.Unmarshal([]byte(listReceived(body)), &res)
+	if res.Status != sdk.ResponseRejected || res.Code != http.StatusUnauthorized {
+		t.Errorf("want an unsigned request rejected, got %+v", res)
+	}
+}
+
+func Test_replayReceived_ForwardsWithNewCorrelationID(t *testing.T) {
+	defer setupSecrets(t)()
+
+	server, _, forwarded := fakeReceivedStore(t)
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	defer os.Unsetenv("gateway_url")
+	os.Setenv("received_events", "20")
+	defer os.Unsetenv("received_events")
+
+	ids = &sdktest.SequentialIDs{Prefix: "replay"}
+	defer func() { ids = sdk.RandomIDs{} }()
+
+	os.Setenv("validate_hmac", "true")
+	defer os.Unsetenv("validate_hmac")
+	ioutil.WriteFile(path.Join(os.Getenv("secret_mount_path"), "github-webhook-secret"), []byte("webhook-secret"), 0600)
+
+	recordPush(t, "guid-1", "af6db", 0)
+
+	body := sdktest.Payload(sdk.ReceivedEventRequest{Owner: "alexellis", ID: "guid-1"})
+	os.Setenv("Http_X_Cloud_Signature", sdktest.Sign(body, "secret"))
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	res := replayReceived(body)
+	if res.Status != sdk.ResponseAccepted {
+		t.Fatalf("want the event replayed, got %+v", res)
+	}
+
+	if len(*forwarded) != 1 {
+		t.Fatalf("want the event forwarded to github-push once, got %d", len(*forwarded))
+	}
+	header := (*forwarded)[0].Header
+	delivery, err := sdk.DeliveryFromHeader(header.Get(sdk.DeliveryHeader), header.Get(sdk.DeliverySignatureHeader), sdktest.Payload(sdktest.GitHubPush("alexellis", "fn1", "master", "af6db")), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.ID != "guid-1" || delivery.CorrelationID != "replay1" {
+		t.Errorf("want the delivery with a new correlation ID, got %+v", delivery)
+	}
+
+	payload := sdktest.Payload(sdktest.GitHubPush("alexellis", "fn1", "master", "af6db"))
+	if err := sdk.ValidGitHubSignature(payload, header.Get(sdk.GitHubSignature256Header), header.Get(sdk.GitHubSignatureHeader), "webhook-secret"); err != nil {
+		t.Errorf("want the replay signed with the github-webhook-secret: %s", err)
+	}
+	if _, err := sdk.CustomerFromHeader(header.Get(sdk.CustomerHeader), header.Get(sdk.CustomerSignatureHeader), "secret", "alexellis"); err != nil {
+		t.Errorf("want the customer signed again: %s", err)
+	}
+}
+
+func Test_replayReceived_UnknownEvent(t *testing.T) {
+	defer setupSecrets(t)()
+
+	server, _, forwarded := fakeReceivedStore(t)
+	defer server.Close()
+
+	os.Setenv("gateway_url", server.URL+"/")
+	defer os.Unsetenv("gateway_url")
+	os.Setenv("received_events", "20")
+	defer os.Unsetenv("received_events")
+
+	recordPush(t, "guid-1", "af6db", 0)
+
+	body := sdktest.Payload(sdk.ReceivedEventRequest{Owner: "openfaas", ID: "guid-1"})
+	os.Setenv("Http_X_Cloud_Signature", sdktest.Sign(body, "secret"))
+	defer os.Unsetenv("Http_X_Cloud_Signature")
+
+	if res := replayReceived(body); res.Status != sdk.ResponseRejected || res.Code != http.StatusNotFound {
+		t.Errorf("want another owner's event not found, got %+v", res)
+	}
+	if len(*forwarded) != 0 {
+		t.Errorf("want nothing forwarded")
+	}
+}
@@ -0,0 +1,140 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/alexellis/derek/auth"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// allowedTeam is an org, or a team within an org, whose members are
+// customers without being listed in the CUSTOMERS file
+type allowedTeam struct {
+	Org string
+	// Team is the team's slug, every member of the org is allowed when
+	// it is empty
+	Team string
+}
+
+func (t allowedTeam) String() string {
+	if len(t.Team) == 0 {
+		return t.Org
+	}
+	return t.Org + "/" + t.Team
+}
+
+// getAllowedTeams reads allowed_teams, a list of orgs or org/team
+// pairs such as openfaas,acme/platform
+func getAllowedTeams() []allowedTeam {
+	teams := []allowedTeam{}
+	for _, entry := range strings.Split(os.Getenv("allowed_teams"), ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		team := allowedTeam{Org: entry}
+		if index := strings.Index(entry, "/"); index > -1 {
+			team = allowedTeam{Org: entry[:index], Team: entry[index+1:]}
+		}
+		teams = append(teams, team)
+	}
+	return teams
+}
+
+// githubAPIURL is the GitHub API with a trailing slash, set with
+// github_api_url for GitHub Enterprise
+func githubAPIURL() string {
+	apiURL := os.Getenv("github_api_url")
+	if len(apiURL) == 0 {
+		return "https://api.github.com/"
+	}
+	if !strings.HasSuffix(apiURL, "/") {
+		apiURL = apiURL + "/"
+	}
+	return apiURL
+}
+
+// teamMember gives the allowed team the sender is a member of. The
+// sender's membership only allows their own repos and those of the
+// team's org, so that a member cannot deploy for an outside owner.
+func teamMember(teams []allowedTeam, owner, sender, token string) *allowedTeam {
+	for _, team := range teams {
+		if !strings.EqualFold(owner, sender) && !strings.EqualFold(owner, team.Org) {
+			continue
+		}
+
+		member, err := isMember(team, sender, token)
+		if err != nil {
+			log.Printf("unable to check membership of %s in %s: %s", sender, team, err.Error())
+			continue
+		}
+		if member {
+			return &team
+		}
+	}
+	return nil
+}
+
+// installationToken is made for the GitHub App's installation, which
+// needs to be able to read the org's members
+func installationToken(installationID int) (string, error) {
+	privateKey, err := ioutil.ReadFile(sdk.GetPrivateKeyPath())
+	if err != nil {
+		return "", fmt.Errorf("unable to read private key: %s", err.Error())
+	}
+
+	return auth.MakeAccessTokenForInstallation(os.Getenv("github_app_id"), installationID, string(privateKey))
+}
+
+// isMember checks the user's membership of an org, or their active
+// membership of a team
+func isMember(team allowedTeam, login, token string) (bool, error) {
+	uri := fmt.Sprintf("%sorgs/%s/members/%s", githubAPIURL(), team.Org, login)
+	if len(team.Team) > 0 {
+		uri = fmt.Sprintf("%sorgs/%s/teams/%s/memberships/%s", githubAPIURL(), team.Org, team.Team, login)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, uri, nil)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "token "+token)
+
+	// A 302 is given for an org whose members the token cannot see
+	client := *sdk.HTTPClient()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	switch res.StatusCode {
+	case http.StatusNoContent:
+		return true, nil
+	case http.StatusOK:
+		membership := struct {
+			State string `json:"state"`
+		}{}
+		body, _ := ioutil.ReadAll(res.Body)
+		if err := json.Unmarshal(body, &membership); err != nil {
+			return false, err
+		}
+		return membership.State == "active", nil
+	case http.StatusNotFound, http.StatusFound:
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unexpected status code from GitHub: %d", res.StatusCode)
+}
@@ -0,0 +1,92 @@
+package function
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// fakeOrgs serves the membership of alexellis in openfaas and in
+// acme/platform, and a pending invite to acme/docs
+func fakeOrgs() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token installation-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/orgs/openfaas/members/alexellis":
+			w.WriteHeader(http.StatusNoContent)
+		case "/orgs/acme/teams/platform/memberships/alexellis":
+			w.Write([]byte(`{"state": "active", "role": "member"}`))
+		case "/orgs/acme/teams/docs/memberships/alexellis":
+			w.Write([]byte(`{"state": "pending", "role": "member"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func Test_getAllowedTeams(t *testing.T) {
+	os.Setenv("allowed_teams", "openfaas, acme/platform,")
+	defer os.Unsetenv("allowed_teams")
+
+	want := []allowedTeam{{Org: "openfaas"}, {Org: "acme", Team: "platform"}}
+	if got := getAllowedTeams(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func Test_isMember(t *testing.T) {
+	server := fakeOrgs()
+	defer server.Close()
+
+	os.Setenv("github_api_url", server.URL)
+	defer os.Unsetenv("github_api_url")
+
+	cases := []struct {
+		team  allowedTeam
+		login string
+		want  bool
+	}{
+		{team: allowedTeam{Org: "openfaas"}, login: "alexellis", want: true},
+		{team: allowedTeam{Org: "openfaas"}, login: "someone", want: false},
+		{team: allowedTeam{Org: "acme", Team: "platform"}, login: "alexellis", want: true},
+		{team: allowedTeam{Org: "acme", Team: "docs"}, login: "alexellis", want: false},
+	}
+
+	for _, c := range cases {
+		got, err := isMember(c.team, c.login, "installation-token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s in %s: want %t, got %t", c.login, c.team, c.want, got)
+		}
+	}
+}
+
+func Test_teamMember(t *testing.T) {
+	server := fakeOrgs()
+	defer server.Close()
+
+	os.Setenv("github_api_url", server.URL)
+	defer os.Unsetenv("github_api_url")
+
+	teams := []allowedTeam{{Org: "acme", Team: "platform"}}
+
+	if team := teamMember(teams, "alexellis", "alexellis", "installation-token"); team == nil {
+		t.Errorf("want a member's own repo allowed")
+	}
+
+	if team := teamMember(teams, "acme", "alexellis", "installation-token"); team == nil {
+		t.Errorf("want the team's org allowed")
+	}
+
+	if team := teamMember(teams, "someone", "alexellis", "installation-token"); team != nil {
+		t.Errorf("want an outside owner refused, got %s", team)
+	}
+}
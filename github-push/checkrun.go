@@ -0,0 +1,56 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// handleCheckRun builds and deploys the commit of a check run again when
+// it is re-run from GitHub, either with its re-run link or the Re-run
+// button added by github-status
+func handleCheckRun(req []byte) sdk.Response {
+	checkRunEvent := sdk.CheckRunEvent{}
+	if err := json.Unmarshal(req, &checkRunEvent); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, err.Error())
+	}
+
+	name := checkRunEvent.CheckRun.Name
+
+	if !checkRunEvent.Rerun() {
+		return sdk.Skipped(fmt.Sprintf("skipping check run %s, action: %s", name, checkRunEvent.Action))
+	}
+
+	branch := checkRunEvent.CheckRun.CheckSuite.HeadBranch
+	if len(branch) == 0 {
+		return sdk.Skipped(fmt.Sprintf("skipping check run %s, it was not for a branch", name))
+	}
+
+	owner := checkRunEvent.Repository.Owner.Login
+	repo := checkRunEvent.Repository.Name
+
+	pushEvent := checkRunEvent.PushEvent()
+	pushEvent.SCM = SCM
+	pushEvent.Customer = customerFromEnv(owner)
+	pushEvent.Delivery = deliveryFromEnv(req)
+
+	if !isBuildRef(pushEvent.Ref) && !isStagingRef(pushEvent.Ref) {
+		return sdk.Skipped(fmt.Sprintf("skipping check run %s for: %s branch, %s", name, branch, describeBuildBranches()))
+	}
+
+	statusCode, postErr := postEvent(pushEvent)
+	if postErr != nil {
+		return sdk.Failed(http.StatusBadGateway, postErr.Error())
+	}
+
+	audit.Post(sdk.AuditEvent{
+		Message: fmt.Sprintf("Git-tar invoked (re-run of %s by %s)", name, checkRunEvent.Sender.Login),
+		Owner:   owner,
+		Repo:    repo,
+		Source:  Source,
+	}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery))
+
+	return invokedGitTar(fmt.Sprintf("Re-run: %s of %s", name, sdk.FormatShortSHA(pushEvent.AfterCommitID)), statusCode)
+}
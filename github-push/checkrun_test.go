@@ -0,0 +1,115 @@
+package function
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
+)
+
+func checkRunPayload(action, identifier, branch string) []byte {
+	event := sdk.CheckRunEvent{
+		Action: action,
+		CheckRun: sdk.CheckRun{
+			Name:       "fn1",
+			HeadSHA:    "a1b2c3d4e5f6",
+			CheckSuite: sdk.CheckSuite{HeadBranch: branch},
+		},
+		Repository: sdk.PushEventRepository{
+			Name:     "fn1",
+			FullName: "alexellis/fn1",
+			Owner:    sdk.Owner{Login: "alexellis"},
+		},
+		Sender: sdk.Sender{Login: "alexellis"},
+	}
+	if len(identifier) > 0 {
+		event.RequestedAction = &sdk.RequestedAction{Identifier: identifier}
+	}
+	body, _ := json.Marshal(event)
+	return body
+}
+
+func Test_Handle_CheckRun_Rerun(t *testing.T) {
+	gateway := sdktest.NewFakeGateway()
+	defer gateway.Close()
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	fakeAudit := &sdktest.FakeAudit{}
+	audit = fakeAudit
+
+	os.Setenv("gateway_url", gateway.URL)
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "check_run")
+	os.Setenv("validate_hmac", "false")
+	defer os.Unsetenv("gateway_url")
+	defer os.Unsetenv("secret_mount_path")
+
+	res := handle(checkRunPayload("requested_action", sdk.RerunAction, "master"))
+	if !strings.Contains(res.Reason, "git-tar: 202") {
+		t.Errorf("want git-tar invoked, got: %q", res.Reason)
+	}
+
+	invocations := gateway.Invocations("git-tar")
+	if len(invocations) != 1 {
+		t.Fatalf("want one call to git-tar, got %d", len(invocations))
+	}
+
+	pushEvent := sdk.PushEvent{}
+	json.Unmarshal(invocations[0].Body, &pushEvent)
+	if pushEvent.Ref != "refs/heads/master" || pushEvent.AfterCommitID != "a1b2c3d4e5f6" || pushEvent.SCM != "github" {
+		t.Errorf("want the check run's commit built, got %+v", pushEvent)
+	}
+
+	events := fakeAudit.Events()
+	if len(events) != 1 || events[0].Message != "Git-tar invoked (re-run of fn1 by alexellis)" {
+		t.Errorf("want the re-run audited, got %+v", events)
+	}
+}
+
+func Test_Handle_CheckRun_Skipped(t *testing.T) {
+	os.Setenv("Http_X_Github_Event", "check_run")
+	os.Setenv("validate_hmac", "false")
+
+	tests := []struct {
+		title   string
+		payload []byte
+		want    string
+	}{
+		{
+			title:   "completed check run",
+			payload: checkRunPayload("completed", "", "master"),
+			want:    "skipping check run fn1, action: completed",
+		},
+		{
+			title:   "other button",
+			payload: checkRunPayload("requested_action", "fix", "master"),
+			want:    "skipping check run fn1, action: requested_action",
+		},
+		{
+			title:   "tag",
+			payload: checkRunPayload("rerequested", "", ""),
+			want:    "skipping check run fn1, it was not for a branch",
+		},
+		{
+			title:   "other branch",
+			payload: checkRunPayload("rerequested", "", "feature"),
+			want:    "skipping check run fn1 for: feature branch, the build branch is: master",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if res := handle(test.payload); res.Reason != test.want {
+				t.Errorf("want %q, got %q", test.want, res.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// Policies for a force-push to the build or staging branch, set with
+// force_push_policy
+const (
+	// forcePushDeploy deploys the new head, marking the audit event
+	forcePushDeploy = "deploy"
+	// forcePushConfirm holds the push until it is confirmed
+	forcePushConfirm = "confirm"
+	// forcePushReject skips the push, a new commit must be pushed
+	forcePushReject = "reject"
+)
+
+// heldPushFunction is the function a held push is forwarded to
+const heldPushFunction = "git-tar"
+
+func forcePushPolicy() string {
+	switch policy := os.Getenv("force_push_policy"); policy {
+	case forcePushConfirm, forcePushReject:
+		return policy
+	case "", forcePushDeploy:
+		return forcePushDeploy
+	default:
+		log.Printf("unknown force_push_policy: %s, using %s", policy, forcePushDeploy)
+		return forcePushDeploy
+	}
+}
+
+// isRevert is true when the head commit was created by git revert or
+// GitHub's revert button. A revert is deployed straight away whatever
+// the force-push policy, so that a bad change can always be rolled back.
+func isRevert(pushEvent sdk.PushEvent) bool {
+	return pushEvent.HeadCommit != nil && strings.HasPrefix(pushEvent.HeadCommit.Message, "Revert \"")
+}
+
+// pushKind describes a push which rewrote or reverted history in the
+// audit trail
+func pushKind(pushEvent sdk.PushEvent) string {
+	switch {
+	case isRevert(pushEvent):
+		return "revert"
+	case pushEvent.Forced:
+		return "force-push"
+	}
+	return ""
+}
+
+// holdPush stores a force-push in pipeline-log until it is confirmed
+func holdPush(pushEvent sdk.PushEvent) error {
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	pushBytes, _ := json.Marshal(pushEvent)
+
+	p := sdk.PipelineLog{
+		RepoPath:  pushEvent.Repository.FullName,
+		CommitSHA: pushEvent.AfterCommitID,
+		Function:  heldPushFunction,
+		Source:    sdk.HeldPushSource,
+		Data:      string(pushBytes),
+	}
+
+	return sdk.WritePipelineLog(os.Getenv("gateway_url"), payloadSecret, p)
+}
+
+func readHeldPush(repoPath string, commitSHA string) (*sdk.PushEvent, error) {
+	body, err := sdk.ReadPipelineLog(os.Getenv("gateway_url"), sdk.PipelineLog{
+		RepoPath:  repoPath,
+		CommitSHA: commitSHA,
+		Function:  heldPushFunction,
+		Source:    sdk.HeldPushSource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pushEvent := sdk.PushEvent{}
+	if err := json.Unmarshal(body, &pushEvent); err != nil || len(pushEvent.AfterCommitID) == 0 {
+		return nil, fmt.Errorf("no held push found for %s %s", repoPath, commitSHA)
+	}
+
+	return &pushEvent, nil
+}
+
+// confirm handles ?action=confirm, the body is a sdk.ReplayRequest for
+// the held force-push signed with the payload-secret
+func confirm(req []byte) sdk.Response {
+	if err := sdk.ValidHMAC(&req, "payload-secret", os.Getenv("Http_X_Cloud_Signature")); err != nil {
+		return sdk.Rejected(http.StatusUnauthorized, fmt.Sprintf("confirm: %s", err.Error()))
+	}
+
+	confirmReq := sdk.ReplayRequest{}
+	if err := json.Unmarshal(req, &confirmReq); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("confirm: unable to parse request: %s", err.Error()))
+	}
+
+	pushEvent, err := readHeldPush(confirmReq.RepoPath, confirmReq.CommitSHA)
+	if err != nil {
+		return sdk.Failed(http.StatusNotFound, fmt.Sprintf("confirm: %s", err.Error()))
+	}
+
+	statusCode, err := postEvent(*pushEvent)
+	if err != nil {
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("confirm: %s", err.Error()))
+	}
+
+	audit.Post(sdk.AuditEvent{
+		Message: fmt.Sprintf("Git-tar invoked (force-push confirmed for %s)", pushEvent.AfterCommitID),
+		Owner:   pushEvent.Repository.Owner.Login,
+		Repo:    pushEvent.Repository.Name,
+		Source:  Source,
+	}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery))
+
+	return invokedGitTar(fmt.Sprintf("Push: %s", formatPushEvent(*pushEvent)), statusCode)
+}
@@ -0,0 +1,68 @@
+package function
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_forcePushPolicy(t *testing.T) {
+	defer os.Unsetenv("force_push_policy")
+
+	cases := []struct {
+		value string
+		want  string
+	}{
+		{"", forcePushDeploy},
+		{"deploy", forcePushDeploy},
+		{"confirm", forcePushConfirm},
+		{"reject", forcePushReject},
+		{"unknown", forcePushDeploy},
+	}
+
+	for _, c := range cases {
+		os.Setenv("force_push_policy", c.value)
+		if got := forcePushPolicy(); got != c.want {
+			t.Errorf("force_push_policy=%q: want %s, got %s", c.value, c.want, got)
+		}
+	}
+}
+
+func Test_pushKind(t *testing.T) {
+	cases := []struct {
+		title string
+		event sdk.PushEvent
+		want  string
+	}{
+		{"push", sdk.PushEvent{HeadCommit: &sdk.PushEventCommit{Message: "Add fn2"}}, ""},
+		{"force-push", sdk.PushEvent{Forced: true, HeadCommit: &sdk.PushEventCommit{Message: "Add fn2"}}, "force-push"},
+		{"revert", sdk.PushEvent{HeadCommit: &sdk.PushEventCommit{Message: "Revert \"Add fn2\"\n\nThis reverts commit af6db."}}, "revert"},
+		{"forced revert", sdk.PushEvent{Forced: true, HeadCommit: &sdk.PushEventCommit{Message: "Revert \"Add fn2\""}}, "revert"},
+		{"no head commit", sdk.PushEvent{Forced: true}, "force-push"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			if got := pushKind(c.event); got != c.want {
+				t.Errorf("want %q, got %q", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_Handle_ForcePushRejected(t *testing.T) {
+	audit = sdk.NilLogger{}
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("validate_hmac", "false")
+	os.Setenv("force_push_policy", "reject")
+	defer os.Unsetenv("force_push_policy")
+
+	res := handle([]byte(`{"ref":"refs/heads/master","forced":true,"head_commit":{"message":"Squash history"}}`))
+
+	want := "force-push to refs/heads/master is not deployed"
+	if !strings.HasPrefix(res.Reason, want) || res.Status != sdk.ResponseRejected {
+		t.Errorf("want %q rejected, got %+v", want, res)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/alexellis/hmac"
 	"github.com/openfaas/openfaas-cloud/sdk"
@@ -21,15 +23,25 @@ const SCM = "github"
 
 var audit sdk.Audit
 
-// Handle processes the push event from the "github-event" function
+// Handle processes the push, pull_request, release or check_run event
+// from the "github-event" function, the response is an sdk.Response as
+// JSON
 func Handle(req []byte) string {
+	return handle(req).JSON()
+}
+
+func handle(req []byte) sdk.Response {
 
 	if audit == nil {
 		audit = sdk.AuditLogger{}
 	}
 
+	if query, _ := url.ParseQuery(os.Getenv("Http_Query")); query.Get("action") == "confirm" {
+		return confirm(req)
+	}
+
 	event := os.Getenv("Http_X_Github_Event")
-	if event != "push" {
+	if event != "push" && event != "pull_request" && event != "release" && event != "check_run" {
 
 		auditEvent := sdk.AuditEvent{
 			Message: "bad event: " + event,
@@ -37,54 +49,132 @@ func Handle(req []byte) string {
 		}
 		audit.Post(auditEvent)
 
-		return fmt.Sprintf("%s cannot handle event: %s", Source, event)
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("%s cannot handle event: %s", Source, event))
 	}
 
 	xHubSignature := os.Getenv("Http_X_Hub_Signature")
+	xHubSignature256 := os.Getenv("Http_X_Hub_Signature_256")
 
 	if sdk.HmacEnabled() {
 		webhookSecretKey, secretErr := sdk.ReadSecret("github-webhook-secret")
 		if secretErr != nil {
-			return secretErr.Error()
+			return sdk.Failed(http.StatusInternalServerError, secretErr.Error())
 		}
 
-		validateErr := hmac.Validate(req, xHubSignature, webhookSecretKey)
+		validateErr := sdk.ValidGitHubSignature(req, xHubSignature256, xHubSignature, webhookSecretKey)
 		if validateErr != nil {
-			log.Fatal(validateErr)
+			msg := fmt.Sprintf("invalid signature for %s event: %s", event, validateErr.Error())
+			log.Println(msg)
+			return sdk.Rejected(http.StatusUnauthorized, msg)
 		}
 	}
 
+	switch event {
+	case "pull_request":
+		return handlePullRequest(req)
+	case "release":
+		return handleRelease(req)
+	case "check_run":
+		return handleCheckRun(req)
+	}
+
 	pushEvent := sdk.PushEvent{}
 	err := json.Unmarshal(req, &pushEvent)
 
 	if err != nil {
-		return err.Error()
+		return sdk.Rejected(http.StatusBadRequest, err.Error())
 	}
 
 	pushEvent.SCM = SCM
+	pushEvent.Customer = customerFromEnv(pushEvent.Repository.Owner.Login)
+	pushEvent.Delivery = deliveryFromEnv(req)
 
 	eventInfo := sdk.BuildEventFromPushEvent(pushEvent)
 	status := sdk.BuildStatus(eventInfo, sdk.EmptyAuthToken)
 
-	if buildBranch := buildBranch(); len(pushEvent.Ref) == 0 ||
-		pushEvent.Ref != fmt.Sprintf("refs/heads/%s", buildBranch) {
-		msg := fmt.Sprintf("skipping build for: %s branch, the build branch is: %s", pushEvent.Ref, buildBranch)
+	if len(pushEvent.Ref) == 0 ||
+		(!isBuildRef(pushEvent.Ref) && !isStagingRef(pushEvent.Ref) && !isTagPush(pushEvent)) {
+		msg := fmt.Sprintf("skipping build for: %s branch, %s", pushEvent.Ref, describeBuildBranches())
 		auditEvent := sdk.AuditEvent{
 			Message: msg,
 			Owner:   pushEvent.Repository.Owner.Login,
 			Repo:    pushEvent.Repository.Name,
 			Source:  Source,
-		}
+		}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery)
 
 		audit.Post(auditEvent)
 
 		status.AddStatus(sdk.StatusSuccess, msg, sdk.StackContext)
 		reportGitHubStatus(status)
-		return msg
+		return sdk.Skipped(msg)
+	}
+
+	if branch := sdk.BranchFromRef(pushEvent.Ref); len(branch) > 0 && !pushEvent.Customer.AllowsBranch(branch) {
+		msg := fmt.Sprintf("skipping build for: %s branch, the branches of %s are: %s", branch, pushEvent.Customer.Login, strings.Join(pushEvent.Customer.Branches, ", "))
+		audit.Post(sdk.AuditEvent{
+			Message: msg,
+			Owner:   pushEvent.Repository.Owner.Login,
+			Repo:    pushEvent.Repository.Name,
+			Source:  Source,
+		}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery))
+
+		status.AddStatus(sdk.StatusSuccess, msg, sdk.StackContext)
+		reportGitHubStatus(status)
+		return sdk.Skipped(msg)
+	}
+
+	if sdk.SkipCI(pushEvent.HeadCommit) && !isTagPush(pushEvent) {
+		msg := fmt.Sprintf("skipping build for: %s, [skip ci] in commit message", sdk.FormatShortSHA(pushEvent.AfterCommitID))
+		audit.Post(sdk.AuditEvent{
+			Message: msg,
+			Owner:   pushEvent.Repository.Owner.Login,
+			Repo:    pushEvent.Repository.Name,
+			Source:  Source,
+		}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery))
+
+		status.AddStatus(sdk.StatusSuccess, msg, sdk.StackContext)
+		reportGitHubStatus(status)
+		return sdk.Skipped(msg)
 	}
 
 	serviceValue := sdk.FormatServiceName(pushEvent.Repository.Owner.Login, pushEvent.Repository.Name)
 
+	if pushEvent.Forced && !isRevert(pushEvent) {
+		switch forcePushPolicy() {
+		case forcePushReject:
+			msg := fmt.Sprintf("force-push to %s is not deployed, push a new commit to deploy", pushEvent.Ref)
+			audit.Post(sdk.AuditEvent{
+				Message: msg,
+				Owner:   pushEvent.Repository.Owner.Login,
+				Repo:    pushEvent.Repository.Name,
+				Source:  Source,
+			}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery))
+
+			status.AddStatus(sdk.StatusFailure, msg, sdk.StackContext)
+			reportGitHubStatus(status)
+			return sdk.Rejected(http.StatusConflict, msg)
+		case forcePushConfirm:
+			if holdErr := holdPush(pushEvent); holdErr != nil {
+				msg := fmt.Sprintf("unable to hold force-push: %s", holdErr.Error())
+				status.AddStatus(sdk.StatusFailure, msg, sdk.StackContext)
+				reportGitHubStatus(status)
+				return sdk.Failed(http.StatusBadGateway, msg)
+			}
+
+			msg := fmt.Sprintf("force-push to %s is awaiting confirmation", pushEvent.Ref)
+			audit.Post(sdk.AuditEvent{
+				Message: msg,
+				Owner:   pushEvent.Repository.Owner.Login,
+				Repo:    pushEvent.Repository.Name,
+				Source:  Source,
+			}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery))
+
+			status.AddStatus(sdk.StatusPending, msg, sdk.StackContext)
+			reportGitHubStatus(status)
+			return sdk.Accepted(msg)
+		}
+	}
+
 	status.AddStatus(sdk.StatusPending, fmt.Sprintf("%s stack deploy is in progress", serviceValue), sdk.StackContext)
 	reportGitHubStatus(status)
 
@@ -92,19 +182,81 @@ func Handle(req []byte) string {
 	if postErr != nil {
 		status.AddStatus(sdk.StatusFailure, postErr.Error(), sdk.StackContext)
 		reportGitHubStatus(status)
-		return postErr.Error()
+		return sdk.Failed(http.StatusBadGateway, postErr.Error())
+	}
+
+	auditMessage := "Git-tar invoked"
+	if kind := pushKind(pushEvent); len(kind) > 0 {
+		auditMessage = fmt.Sprintf("%s (%s to %s)", auditMessage, kind, pushEvent.Ref)
 	}
 
 	auditEvent := sdk.AuditEvent{
-		Message: "Git-tar invoked",
+		Message: auditMessage,
 		Owner:   pushEvent.Repository.Owner.Login,
 		Repo:    pushEvent.Repository.Name,
 		Source:  Source,
+	}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery)
+
+	audit.Post(auditEvent)
+
+	return invokedGitTar(fmt.Sprintf("Push: %s", formatPushEvent(pushEvent)), statusCode)
+}
+
+// invokedGitTar gives the response once an event was posted to git-tar,
+// which accepts it to be built asynchronously
+func invokedGitTar(msg string, statusCode int) sdk.Response {
+	msg = fmt.Sprintf("%s, git-tar: %d", msg, statusCode)
+	if statusCode != http.StatusAccepted && statusCode != http.StatusOK {
+		return sdk.Failed(http.StatusBadGateway, msg)
+	}
+	return sdk.Accepted(msg)
+}
+
+// customerFromEnv reads the tier and entitlements forwarded by
+// github-event, they are dropped unless signed with the payload-secret
+// for the owner of the push
+func customerFromEnv(owner string) *sdk.CustomerInfo {
+	value := os.Getenv("Http_X_Cloud_Customer")
+	if len(value) == 0 {
+		return nil
+	}
+
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		log.Printf("unable to read customer: %s", err.Error())
+		return nil
+	}
+
+	customer, err := sdk.CustomerFromHeader(value, os.Getenv("Http_X_Cloud_Customer_Signature"), payloadSecret, owner)
+	if err != nil {
+		log.Printf("unable to read customer: %s", err.Error())
+		return nil
+	}
+
+	return customer
+}
+
+// deliveryFromEnv reads the webhook delivery forwarded by github-event,
+// it is dropped unless signed with the payload-secret for this event
+func deliveryFromEnv(req []byte) *sdk.Delivery {
+	value := os.Getenv("Http_X_Cloud_Delivery")
+	if len(value) == 0 {
+		return nil
 	}
 
-	sdk.PostAudit(auditEvent)
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		log.Printf("unable to read delivery: %s", err.Error())
+		return nil
+	}
+
+	delivery, err := sdk.DeliveryFromHeader(value, os.Getenv("Http_X_Cloud_Delivery_Signature"), req, payloadSecret)
+	if err != nil {
+		log.Printf("unable to read delivery: %s", err.Error())
+		return nil
+	}
 
-	return fmt.Sprintf("Push: %s\n, git-tar: %d\n", formatPushEvent(pushEvent), statusCode)
+	return delivery
 }
 
 func formatPushEvent(pushEvent sdk.PushEvent) string {
@@ -119,9 +271,19 @@ func postEvent(pushEvent sdk.PushEvent) (int, error) {
 		return http.StatusUnauthorized, err
 	}
 
+	// The metadata is best-effort, the build goes ahead without it
+	if repoMetadataEnabled() {
+		metadata, err := readRepoMetadata(pushEvent)
+		if err != nil {
+			log.Printf("unable to read metadata of %s: %s", pushEvent.Repository.FullName, err.Error())
+		} else {
+			pushEvent.Metadata = metadata
+		}
+	}
+
 	body, _ := json.Marshal(pushEvent)
 
-	c := http.Client{}
+	c := sdk.HTTPClient()
 	bodyReader := bytes.NewBuffer(body)
 	httpReq, _ := http.NewRequest(http.MethodPost, gatewayURL+"async-function/git-tar", bodyReader)
 
@@ -172,10 +334,32 @@ func reportGitHubStatus(status *sdk.Status) {
 	}
 }
 
-func buildBranch() string {
-	branch := os.Getenv("build_branch")
-	if branch == "" {
-		return "master"
+// isBuildRef is true for a push to one of the build_branches. Pushes to
+// any branch are passed on when repo_branches is set, so that git-tar
+// can check them against the repository's .ofc.yml.
+func isBuildRef(ref string) bool {
+	branch := sdk.BranchFromRef(ref)
+	if len(branch) == 0 {
+		return false
+	}
+	return sdk.RepoBranchesEnabled() || sdk.MatchBranch(branch, sdk.BuildBranches())
+}
+
+func describeBuildBranches() string {
+	branches := sdk.BuildBranches()
+	if len(branches) == 1 {
+		return "the build branch is: " + branches[0]
 	}
-	return branch
+	return "the build branches are: " + strings.Join(branches, ", ")
+}
+
+// stagingBranch is deployed alongside the build_branch with a -staging
+// suffix so that it can be promoted, set with staging_branch
+func stagingBranch() string {
+	return os.Getenv("staging_branch")
+}
+
+func isStagingRef(ref string) bool {
+	branch := stagingBranch()
+	return len(branch) > 0 && ref == fmt.Sprintf("refs/heads/%s", branch)
 }
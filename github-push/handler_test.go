@@ -1,6 +1,7 @@
 package function
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
 )
 
 type HTTPHandler struct {
@@ -23,25 +25,46 @@ func Test_Handle_Push_InvalidBranch(t *testing.T) {
 	os.Setenv("validate_hmac", "false")
 	os.Setenv("validate_customers", "false")
 
-	res := Handle([]byte(
+	res := handle([]byte(
 		`{"ref":"refs/heads/staging"}`,
 	))
 
 	want := "skipping build for: refs/heads/staging branch, the build branch is: master"
-	if res != want {
-		t.Errorf("want error: \"%s\", got: \"%s\"", want, res)
+	if res.Reason != want {
+		t.Errorf("want error: \"%s\", got: \"%s\"", want, res.Reason)
 		t.Fail()
 	}
 }
 
+func Test_isStagingRef(t *testing.T) {
+	os.Setenv("staging_branch", "staging")
+	defer os.Unsetenv("staging_branch")
+
+	if !isStagingRef("refs/heads/staging") {
+		t.Errorf("want refs/heads/staging to be the staging branch")
+	}
+
+	if isStagingRef("refs/heads/master") {
+		t.Errorf("want refs/heads/master not to be the staging branch")
+	}
+}
+
+func Test_isStagingRef_Unset(t *testing.T) {
+	os.Unsetenv("staging_branch")
+
+	if isStagingRef("refs/heads/") {
+		t.Errorf("want no staging branch when staging_branch is unset")
+	}
+}
+
 func Test_Handle_EmptyEvent(t *testing.T) {
 	audit = sdk.NilLogger{}
 	os.Setenv("Http_X_Github_Event", "")
 
-	res := Handle([]byte{})
+	res := handle([]byte{})
 	want := "github-push cannot handle event: "
-	if res != want {
-		t.Errorf("want error: \"%s\", got: \"%s\"", want, res)
+	if res.Reason != want || res.Code != http.StatusBadRequest {
+		t.Errorf("want error: \"%s\", got: \"%s\"", want, res.Reason)
 		t.Fail()
 	}
 }
@@ -50,10 +73,10 @@ func Test_Handle_IssueComment(t *testing.T) {
 	audit = sdk.NilLogger{}
 	os.Setenv("Http_X_Github_Event", "IssueComment")
 
-	res := Handle([]byte{})
+	res := handle([]byte{})
 	want := "github-push cannot handle event: IssueComment"
-	if res != want {
-		t.Errorf("want error: \"%s\", got: \"%s\"", want, res)
+	if res.Reason != want {
+		t.Errorf("want error: \"%s\", got: \"%s\"", want, res.Reason)
 		t.Fail()
 	}
 }
@@ -63,13 +86,238 @@ func Test_Handle_ValidateCustomers_Matched(t *testing.T) {
 	os.Setenv("Http_X_Github_Event", "push")
 	os.Setenv("validate_customers", "true")
 	os.Setenv("customers_url", server.URL)
-	res := Handle([]byte(
+	res := handle([]byte(
 		`{"ref":"refs/heads/master","repository":{ "owner": { "login": "alexellis" } }}`,
 	))
 	// This error is as far as we can get right now without subbing more code.
 	secretErr := "unable to read secret"
-	if !strings.Contains(res, secretErr) {
-		t.Errorf("want error: \"%s\", got: \"%s\"", secretErr, res)
+	if !strings.Contains(res.Reason, secretErr) {
+		t.Errorf("want error: \"%s\", got: \"%s\"", secretErr, res.Reason)
 		t.Fail()
 	}
 }
+
+func Test_Handle_Push_InvokesGitTar(t *testing.T) {
+	gateway := sdktest.NewFakeGateway()
+	defer gateway.Close()
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	fakeAudit := &sdktest.FakeAudit{}
+	audit = fakeAudit
+
+	os.Setenv("gateway_url", gateway.URL)
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("validate_hmac", "false")
+	os.Setenv("validate_customers", "false")
+	os.Setenv("report_status", "true")
+	defer os.Unsetenv("gateway_url")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("report_status")
+
+	body := sdktest.Payload(sdktest.GitHubPush("alexellis", "fn1", "master", "af6db"))
+	value, signature := sdk.SignDelivery(sdk.Delivery{ID: "72d3162e", Event: "push", CorrelationID: "9f2c81d04ab3e6f7"}, body, "secret")
+	os.Setenv("Http_X_Cloud_Delivery", value)
+	os.Setenv("Http_X_Cloud_Delivery_Signature", signature)
+	defer os.Unsetenv("Http_X_Cloud_Delivery")
+	defer os.Unsetenv("Http_X_Cloud_Delivery_Signature")
+
+	res := handle(body)
+
+	if res.Status != sdk.ResponseAccepted || !strings.Contains(res.Reason, "git-tar: 202") {
+		t.Errorf("want git-tar invoked, got: %+v", res)
+	}
+
+	invocations := gateway.Invocations("git-tar")
+	if len(invocations) != 1 || !invocations[0].Async {
+		t.Fatalf("want one async call to git-tar, got %d", len(invocations))
+	}
+	if got := invocations[0].Header.Get(sdk.CloudSignatureHeader); got != sdktest.Sign(invocations[0].Body, "secret") {
+		t.Errorf("want the event signed with the payload-secret, got %q", got)
+	}
+
+	if len(gateway.Invocations("github-status")) != 1 {
+		t.Errorf("want the pending status reported to github-status")
+	}
+
+	messages := fakeAudit.Messages()
+	if len(messages) != 1 || messages[0] != "Git-tar invoked" {
+		t.Errorf("want the git-tar invocation audited, got %v", messages)
+	}
+
+	events := fakeAudit.Events()
+	if len(events) != 1 || events[0].SHA != "af6db" || events[0].DeliveryID != "72d3162e" || events[0].CorrelationID != "9f2c81d04ab3e6f7" {
+		t.Errorf("want the audit event traced to the delivery, got %+v", events)
+	}
+}
+
+func Test_Handle_Push_ForwardsCustomerTier(t *testing.T) {
+	gateway := sdktest.NewFakeGateway()
+	defer gateway.Close()
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	audit = &sdktest.FakeAudit{}
+
+	value, signature := sdk.SignCustomer(sdk.CustomerInfo{Login: "alexellis", Tier: "pro"}, "secret")
+
+	os.Setenv("gateway_url", gateway.URL)
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("validate_hmac", "false")
+	os.Setenv("Http_X_Cloud_Customer", value)
+	os.Setenv("Http_X_Cloud_Customer_Signature", signature)
+	defer os.Unsetenv("gateway_url")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("Http_X_Cloud_Customer")
+	defer os.Unsetenv("Http_X_Cloud_Customer_Signature")
+
+	Handle(sdktest.Payload(sdktest.GitHubPush("alexellis", "fn1", "master", "af6db")))
+
+	invocations := gateway.Invocations("git-tar")
+	if len(invocations) != 1 {
+		t.Fatalf("want one call to git-tar, got %d", len(invocations))
+	}
+
+	pushEvent := sdk.PushEvent{}
+	json.Unmarshal(invocations[0].Body, &pushEvent)
+	if pushEvent.Customer == nil || pushEvent.Customer.Tier != "pro" {
+		t.Errorf("want the tier in the signed event, got %+v", pushEvent.Customer)
+	}
+}
+
+func Test_Handle_Push_CustomerBranches(t *testing.T) {
+	gateway := sdktest.NewFakeGateway()
+	defer gateway.Close()
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	audit = &sdktest.FakeAudit{}
+
+	value, signature := sdk.SignCustomer(sdk.CustomerInfo{Login: "alexellis", Tier: "free", Branches: []string{"release/*"}}, "secret")
+
+	os.Setenv("gateway_url", gateway.URL)
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("validate_hmac", "false")
+	os.Setenv("Http_X_Cloud_Customer", value)
+	os.Setenv("Http_X_Cloud_Customer_Signature", signature)
+	defer os.Unsetenv("gateway_url")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("Http_X_Cloud_Customer")
+	defer os.Unsetenv("Http_X_Cloud_Customer_Signature")
+
+	res := handle(sdktest.Payload(sdktest.GitHubPush("alexellis", "fn1", "master", "af6db")))
+
+	want := "skipping build for: master branch, the branches of alexellis are: release/*"
+	if res.Reason != want || res.Status != sdk.ResponseSkipped {
+		t.Errorf("want %q, got %+v", want, res)
+	}
+	if len(gateway.Invocations("git-tar")) != 0 {
+		t.Errorf("want no call to git-tar for a branch the customer can't build")
+	}
+}
+
+func Test_customerFromEnv_WrongOwner(t *testing.T) {
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	value, signature := sdk.SignCustomer(sdk.CustomerInfo{Login: "alexellis", Tier: "pro"}, "secret")
+
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Cloud_Customer", value)
+	os.Setenv("Http_X_Cloud_Customer_Signature", signature)
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("Http_X_Cloud_Customer")
+	defer os.Unsetenv("Http_X_Cloud_Customer_Signature")
+
+	if customer := customerFromEnv("openfaas"); customer != nil {
+		t.Errorf("want the tier of another owner dropped, got %+v", customer)
+	}
+}
+
+func Test_deliveryFromEnv(t *testing.T) {
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	req := []byte(`{"ref":"refs/heads/master"}`)
+	value, signature := sdk.SignDelivery(sdk.Delivery{ID: "72d3162e", Event: "push"}, req, "secret")
+
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Cloud_Delivery", value)
+	os.Setenv("Http_X_Cloud_Delivery_Signature", signature)
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("Http_X_Cloud_Delivery")
+	defer os.Unsetenv("Http_X_Cloud_Delivery_Signature")
+
+	if delivery := deliveryFromEnv(req); delivery == nil || delivery.ID != "72d3162e" {
+		t.Errorf("want the delivery, got %+v", delivery)
+	}
+
+	if delivery := deliveryFromEnv([]byte(`{"ref":"refs/heads/dev"}`)); delivery != nil {
+		t.Errorf("want the delivery of another event dropped, got %+v", delivery)
+	}
+}
+
+func Test_isBuildRef(t *testing.T) {
+	os.Setenv("build_branches", "master,release/*")
+	defer os.Unsetenv("build_branches")
+
+	cases := map[string]bool{
+		"refs/heads/master":      true,
+		"refs/heads/release/1.0": true,
+		"refs/heads/feature":     false,
+		"refs/tags/v1.0.0":       false,
+	}
+
+	for ref, want := range cases {
+		if got := isBuildRef(ref); got != want {
+			t.Errorf("%s: want %v, got %v", ref, want, got)
+		}
+	}
+}
+
+func Test_isBuildRef_RepoBranches(t *testing.T) {
+	os.Setenv("repo_branches", "true")
+	defer os.Unsetenv("repo_branches")
+
+	if !isBuildRef("refs/heads/feature") {
+		t.Errorf("want any branch passed on to git-tar when repo_branches is set")
+	}
+
+	if isBuildRef("refs/tags/v1.0.0") {
+		t.Errorf("want a tag not to be a build ref")
+	}
+}
+
+func Test_Handle_SkipCI(t *testing.T) {
+	audit = sdk.NilLogger{}
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("validate_hmac", "false")
+
+	res := handle([]byte(`{"ref":"refs/heads/master","after":"af6db9c21a3d","head_commit":{"message":"Update README [skip ci]"}}`))
+
+	want := "skipping build for: af6db9c, [skip ci] in commit message"
+	if res.Reason != want {
+		t.Errorf("want %q, got %q", want, res.Reason)
+	}
+}
@@ -0,0 +1,114 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/alexellis/derek/auth"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// repoMetadataEnabled reads the languages and license of the repository
+// from the GitHub API for each build, set with enable_repo_metadata
+func repoMetadataEnabled() bool {
+	return readBool("enable_repo_metadata")
+}
+
+// githubAPIURL is the GitHub API with a trailing slash, set with
+// github_api_url for GitHub Enterprise
+func githubAPIURL() string {
+	apiURL := os.Getenv("github_api_url")
+	if len(apiURL) == 0 {
+		return "https://api.github.com/"
+	}
+	if !strings.HasSuffix(apiURL, "/") {
+		apiURL = apiURL + "/"
+	}
+	return apiURL
+}
+
+// readRepoMetadata gives the languages and license of the repository,
+// the license is empty when the repository has none
+func readRepoMetadata(pushEvent sdk.PushEvent) (*sdk.RepoMetadata, error) {
+	token := installationToken(pushEvent.Installation.ID)
+	repoURL := githubAPIURL() + "repos/" + pushEvent.Repository.Owner.Login + "/" + pushEvent.Repository.Name
+
+	metadata := &sdk.RepoMetadata{Languages: map[string]int{}}
+	if _, err := getGitHub(repoURL+"/languages", token, &metadata.Languages); err != nil {
+		return nil, fmt.Errorf("unable to read languages: %s", err.Error())
+	}
+
+	license := struct {
+		License struct {
+			SPDXID string `json:"spdx_id"`
+		} `json:"license"`
+	}{}
+
+	found, err := getGitHub(repoURL+"/license", token, &license)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read license: %s", err.Error())
+	}
+	if found {
+		metadata.License = license.License.SPDXID
+	}
+
+	return metadata, nil
+}
+
+// installationToken gives a token for the GitHub App's installation, or
+// an empty string when no App is configured, so that only public
+// repositories can be read
+func installationToken(installationID int) string {
+	appID := os.Getenv("github_app_id")
+	if len(appID) == 0 || installationID == 0 {
+		return ""
+	}
+
+	privateKey, err := ioutil.ReadFile(sdk.GetPrivateKeyPath())
+	if err != nil {
+		log.Printf("unable to read private key: %s", err.Error())
+		return ""
+	}
+
+	token, err := auth.MakeAccessTokenForInstallation(appID, installationID, string(privateKey))
+	if err != nil {
+		log.Printf("unable to make installation token: %s", err.Error())
+		return ""
+	}
+	return token
+}
+
+// getGitHub reads a resource from the GitHub API into out, giving false
+// when it is not found
+func getGitHub(uri, token string, out interface{}) (bool, error) {
+	req, _ := http.NewRequest(http.MethodGet, uri, nil)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status code from GitHub: %d", res.StatusCode)
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	return true, json.Unmarshal(body, out)
+}
@@ -0,0 +1,75 @@
+package function
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
+)
+
+func Test_Handle_Push_RepoMetadata(t *testing.T) {
+	gateway := sdktest.NewFakeGateway()
+	defer gateway.Close()
+
+	scm := sdktest.NewFakeSCM()
+	defer scm.Close()
+	scm.SetRepoMetadata("alexellis/fn1", sdk.RepoMetadata{
+		License:   "MIT",
+		Languages: map[string]int{"Go": 900, "Dockerfile": 100},
+	})
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	audit = &sdktest.FakeAudit{}
+
+	os.Setenv("gateway_url", gateway.URL)
+	os.Setenv("github_api_url", scm.URL)
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("validate_hmac", "false")
+	os.Setenv("enable_repo_metadata", "true")
+	defer os.Unsetenv("gateway_url")
+	defer os.Unsetenv("github_api_url")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("enable_repo_metadata")
+
+	Handle(sdktest.Payload(sdktest.GitHubPush("alexellis", "fn1", "master", "af6db")))
+
+	invocations := gateway.Invocations("git-tar")
+	if len(invocations) != 1 {
+		t.Fatalf("want one call to git-tar, got %d", len(invocations))
+	}
+
+	pushEvent := sdk.PushEvent{}
+	json.Unmarshal(invocations[0].Body, &pushEvent)
+
+	if pushEvent.Metadata == nil {
+		t.Fatalf("want the metadata sent to git-tar")
+	}
+	if pushEvent.Metadata.License != "MIT" || pushEvent.Metadata.FormatLanguages() != "Go=90.0,Dockerfile=10.0" {
+		t.Errorf("want the license and languages of the repo, got %+v", pushEvent.Metadata)
+	}
+}
+
+func Test_readRepoMetadata_NoLicense(t *testing.T) {
+	scm := sdktest.NewFakeSCM()
+	defer scm.Close()
+	scm.SetRepoMetadata("alexellis/fn1", sdk.RepoMetadata{Languages: map[string]int{"Go": 100}})
+
+	os.Setenv("github_api_url", scm.URL)
+	defer os.Unsetenv("github_api_url")
+
+	metadata, err := readRepoMetadata(sdktest.GitHubPush("alexellis", "fn1", "master", "af6db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.License != "" || metadata.Languages["Go"] != 100 {
+		t.Errorf("want the languages and no license, got %+v", metadata)
+	}
+}
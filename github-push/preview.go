@@ -0,0 +1,141 @@
+package function
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// GarbageRequest removes the functions deployed for a pull request
+type GarbageRequest struct {
+	Functions   []string `json:"functions"`
+	Repo        string   `json:"repo"`
+	Owner       string   `json:"owner"`
+	PullRequest int      `json:"pullRequest,omitempty"`
+}
+
+// previewsEnabled deploys pull requests as preview environments with a
+// -pr-N suffix, set with enable_pr_previews
+func previewsEnabled() bool {
+	return readBool("enable_pr_previews")
+}
+
+// handlePullRequest deploys the head of an open pull request as a
+// preview, and removes the preview once the pull request is closed or
+// merged. Pull requests from forks are not deployed, as their code has
+// not been reviewed by the owner.
+func handlePullRequest(req []byte) sdk.Response {
+	prEvent := sdk.PullRequestEvent{}
+	if err := json.Unmarshal(req, &prEvent); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, err.Error())
+	}
+
+	owner := prEvent.Repository.Owner.Login
+	repo := prEvent.Repository.Name
+
+	if !previewsEnabled() {
+		return sdk.Skipped(fmt.Sprintf("skipping pull request #%d, previews are not enabled", prEvent.Number))
+	}
+
+	switch prEvent.Action {
+	case "opened", "reopened", "synchronize":
+		if prEvent.FromFork() {
+			msg := fmt.Sprintf("skipping preview for pull request #%d from %s", prEvent.Number, prEvent.PullRequest.Head.Repo.FullName)
+			audit.Post(sdk.AuditEvent{
+				Message: msg,
+				Owner:   owner,
+				Repo:    repo,
+				Source:  Source,
+			})
+			return sdk.Skipped(msg)
+		}
+
+		pushEvent := prEvent.PushEvent()
+		pushEvent.SCM = SCM
+		pushEvent.Customer = customerFromEnv(owner)
+		pushEvent.Delivery = deliveryFromEnv(req)
+
+		status := sdk.BuildStatus(sdk.BuildEventFromPushEvent(pushEvent), sdk.EmptyAuthToken)
+		serviceValue := sdk.FormatServiceName(owner, repo)
+		status.AddStatus(sdk.StatusPending, fmt.Sprintf("%s preview deploy is in progress", serviceValue), sdk.StackContext)
+		reportGitHubStatus(status)
+
+		statusCode, postErr := postEvent(pushEvent)
+		if postErr != nil {
+			status.AddStatus(sdk.StatusFailure, postErr.Error(), sdk.StackContext)
+			reportGitHubStatus(status)
+			return sdk.Failed(http.StatusBadGateway, postErr.Error())
+		}
+
+		audit.Post(sdk.AuditEvent{
+			Message: fmt.Sprintf("Git-tar invoked (preview for pull request #%d)", prEvent.Number),
+			Owner:   owner,
+			Repo:    repo,
+			Source:  Source,
+		}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery))
+
+		return invokedGitTar(fmt.Sprintf("Pull request: #%d", prEvent.Number), statusCode)
+
+	case "closed":
+		if err := removePreview(owner, repo, prEvent.Number); err != nil {
+			return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("unable to remove preview for pull request #%d: %s", prEvent.Number, err.Error()))
+		}
+
+		action := "closed"
+		if prEvent.PullRequest.Merged {
+			action = "merged"
+		}
+
+		msg := fmt.Sprintf("removing preview for pull request #%d, %s", prEvent.Number, action)
+		audit.Post(sdk.AuditEvent{
+			Message: msg,
+			Owner:   owner,
+			Repo:    repo,
+			Source:  Source,
+		})
+		return sdk.OK(msg)
+	}
+
+	return sdk.Skipped(fmt.Sprintf("skipping pull request #%d, action: %s", prEvent.Number, prEvent.Action))
+}
+
+// removePreview asks garbage-collect to delete every function deployed
+// for the pull request
+func removePreview(owner, repo string, number int) error {
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	body, _ := json.Marshal(GarbageRequest{
+		Owner:       owner,
+		Repo:        repo,
+		Functions:   []string{},
+		PullRequest: number,
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, os.Getenv("gateway_url")+"async-function/garbage-collect", bytes.NewReader(body))
+
+	digest := hmac.Sign(body, []byte(payloadSecret))
+	req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("unexpected status code from garbage-collect: %d", res.StatusCode)
+	}
+	return nil
+}
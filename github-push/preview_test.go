@@ -0,0 +1,115 @@
+package function
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
+)
+
+func pullRequestPayload(action string, number int, headRepo string, merged bool) []byte {
+	event := sdk.PullRequestEvent{
+		Action: action,
+		Number: number,
+		PullRequest: sdk.PullRequest{
+			Merged: merged,
+			Head: sdk.PullRequestRef{
+				Ref:  "feature",
+				SHA:  "af6db",
+				Repo: sdk.PushEventRepository{FullName: headRepo},
+			},
+		},
+		Repository: sdk.PushEventRepository{
+			Name:     "fn1",
+			FullName: "alexellis/fn1",
+			Owner:    sdk.Owner{Login: "alexellis"},
+		},
+	}
+	body, _ := json.Marshal(event)
+	return body
+}
+
+func Test_Handle_PullRequest(t *testing.T) {
+	gateway := sdktest.NewFakeGateway()
+	defer gateway.Close()
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	audit = &sdktest.FakeAudit{}
+
+	os.Setenv("gateway_url", gateway.URL)
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "pull_request")
+	os.Setenv("validate_hmac", "false")
+	os.Setenv("enable_pr_previews", "true")
+	defer os.Unsetenv("gateway_url")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("enable_pr_previews")
+
+	res := handle(pullRequestPayload("synchronize", 12, "alexellis/fn1", false))
+	if !strings.Contains(res.Reason, "git-tar: 202") {
+		t.Errorf("want git-tar invoked, got: %q", res.Reason)
+	}
+
+	invocations := gateway.Invocations("git-tar")
+	if len(invocations) != 1 {
+		t.Fatalf("want one call to git-tar, got %d", len(invocations))
+	}
+
+	pushEvent := sdk.PushEvent{}
+	json.Unmarshal(invocations[0].Body, &pushEvent)
+	if pushEvent.PullRequest != 12 || pushEvent.Ref != "refs/heads/feature" || pushEvent.AfterCommitID != "af6db" {
+		t.Errorf("want the head of the pull request built as a preview, got %+v", pushEvent)
+	}
+
+	res = handle(pullRequestPayload("closed", 12, "alexellis/fn1", true))
+	if res.Reason != "removing preview for pull request #12, merged" {
+		t.Errorf("want the preview removed, got: %q", res.Reason)
+	}
+
+	removals := gateway.Invocations("garbage-collect")
+	if len(removals) != 1 || !removals[0].Async {
+		t.Fatalf("want one async call to garbage-collect, got %d", len(removals))
+	}
+
+	garbageReq := GarbageRequest{}
+	json.Unmarshal(removals[0].Body, &garbageReq)
+	if garbageReq.PullRequest != 12 || garbageReq.Repo != "fn1" || len(garbageReq.Functions) != 0 {
+		t.Errorf("want the functions for the pull request removed, got %+v", garbageReq)
+	}
+}
+
+func Test_Handle_PullRequest_Skipped(t *testing.T) {
+	audit = &sdktest.FakeAudit{}
+
+	os.Setenv("Http_X_Github_Event", "pull_request")
+	os.Setenv("validate_hmac", "false")
+	defer os.Unsetenv("enable_pr_previews")
+
+	cases := []struct {
+		title    string
+		previews string
+		payload  []byte
+		want     string
+	}{
+		{"previews disabled", "false", pullRequestPayload("opened", 3, "alexellis/fn1", false), "skipping pull request #3, previews are not enabled"},
+		{"fork", "true", pullRequestPayload("opened", 3, "someone/fn1", false), "skipping preview for pull request #3 from someone/fn1"},
+		{"other action", "true", pullRequestPayload("labeled", 3, "alexellis/fn1", false), "skipping pull request #3, action: labeled"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			os.Setenv("enable_pr_previews", c.previews)
+			if res := handle(c.payload); res.Reason != c.want {
+				t.Errorf("want %q, got %q", c.want, res.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// Triggers for deploying git tags, set with tag_deploys
+const (
+	tagDeployPush    = "push"
+	tagDeployRelease = "release"
+)
+
+// tagDeploys gives what deploys a git tag, either the push of the tag or
+// the publishing of a release. Only one is used so that a release,
+// which also pushes its tag, is deployed once. Tags are not deployed
+// when it is unset.
+func tagDeploys() string {
+	switch trigger := os.Getenv("tag_deploys"); trigger {
+	case tagDeployPush, tagDeployRelease:
+		return trigger
+	}
+	return ""
+}
+
+// isTagPush is true for the push of a new tag when tags are deployed on
+// push, the push which deletes a tag has an all-zero SHA
+func isTagPush(pushEvent sdk.PushEvent) bool {
+	return tagDeploys() == tagDeployPush &&
+		len(sdk.TagFromRef(pushEvent.Ref)) > 0 &&
+		len(strings.Trim(pushEvent.AfterCommitID, "0")) > 0
+}
+
+// handleRelease deploys the tag of a published release. Draft releases
+// do not send a published event.
+func handleRelease(req []byte) sdk.Response {
+	releaseEvent := sdk.ReleaseEvent{}
+	if err := json.Unmarshal(req, &releaseEvent); err != nil {
+		return sdk.Rejected(http.StatusBadRequest, err.Error())
+	}
+
+	tag := releaseEvent.Release.TagName
+
+	if tagDeploys() != tagDeployRelease {
+		return sdk.Skipped(fmt.Sprintf("skipping release %s, releases are not deployed", tag))
+	}
+
+	if releaseEvent.Action != "published" {
+		return sdk.Skipped(fmt.Sprintf("skipping release %s, action: %s", tag, releaseEvent.Action))
+	}
+
+	owner := releaseEvent.Repository.Owner.Login
+	repo := releaseEvent.Repository.Name
+
+	pushEvent := releaseEvent.PushEvent()
+	pushEvent.SCM = SCM
+	pushEvent.Customer = customerFromEnv(owner)
+	pushEvent.Delivery = deliveryFromEnv(req)
+
+	// The commit status is reported by git-tar once the tag's commit is
+	// known
+	statusCode, postErr := postEvent(pushEvent)
+	if postErr != nil {
+		return sdk.Failed(http.StatusBadGateway, postErr.Error())
+	}
+
+	audit.Post(sdk.AuditEvent{
+		Message: fmt.Sprintf("Git-tar invoked (release %s)", tag),
+		Owner:   owner,
+		Repo:    repo,
+		Source:  Source,
+	}.Trace(pushEvent.AfterCommitID, pushEvent.Delivery))
+
+	return invokedGitTar(fmt.Sprintf("Release: %s", tag), statusCode)
+}
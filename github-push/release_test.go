@@ -0,0 +1,138 @@
+package function
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"github.com/openfaas/openfaas-cloud/sdk/sdktest"
+)
+
+func releasePayload(action, tag string) []byte {
+	event := sdk.ReleaseEvent{
+		Action:  action,
+		Release: sdk.Release{TagName: tag},
+		Repository: sdk.PushEventRepository{
+			Name:     "fn1",
+			FullName: "alexellis/fn1",
+			Owner:    sdk.Owner{Login: "alexellis"},
+		},
+	}
+	body, _ := json.Marshal(event)
+	return body
+}
+
+func Test_Handle_Release(t *testing.T) {
+	gateway := sdktest.NewFakeGateway()
+	defer gateway.Close()
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	audit = &sdktest.FakeAudit{}
+
+	os.Setenv("gateway_url", gateway.URL)
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "release")
+	os.Setenv("validate_hmac", "false")
+	os.Setenv("tag_deploys", "release")
+	defer os.Unsetenv("gateway_url")
+	defer os.Unsetenv("secret_mount_path")
+	defer os.Unsetenv("tag_deploys")
+
+	res := handle(releasePayload("published", "v1.2.0"))
+	if !strings.Contains(res.Reason, "git-tar: 202") {
+		t.Errorf("want git-tar invoked, got: %q", res.Reason)
+	}
+
+	invocations := gateway.Invocations("git-tar")
+	if len(invocations) != 1 {
+		t.Fatalf("want one call to git-tar, got %d", len(invocations))
+	}
+
+	pushEvent := sdk.PushEvent{}
+	json.Unmarshal(invocations[0].Body, &pushEvent)
+	if pushEvent.Ref != "refs/tags/v1.2.0" || pushEvent.SCM != "github" {
+		t.Errorf("want the release's tag built, got %+v", pushEvent)
+	}
+
+	res = handle(releasePayload("created", "v1.2.0"))
+	if res.Reason != "skipping release v1.2.0, action: created" {
+		t.Errorf("want created releases skipped, got: %q", res.Reason)
+	}
+}
+
+func Test_Handle_Release_NotEnabled(t *testing.T) {
+	os.Setenv("Http_X_Github_Event", "release")
+	os.Setenv("validate_hmac", "false")
+	os.Setenv("tag_deploys", "push")
+	defer os.Unsetenv("tag_deploys")
+
+	res := handle(releasePayload("published", "v1.2.0"))
+	if res.Reason != "skipping release v1.2.0, releases are not deployed" {
+		t.Errorf("want the release skipped, got: %q", res.Reason)
+	}
+}
+
+func Test_Handle_Push_Tag(t *testing.T) {
+	gateway := sdktest.NewFakeGateway()
+	defer gateway.Close()
+
+	secrets, err := sdktest.WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	audit = &sdktest.FakeAudit{}
+
+	os.Setenv("gateway_url", gateway.URL)
+	os.Setenv("secret_mount_path", secrets)
+	os.Setenv("Http_X_Github_Event", "push")
+	os.Setenv("validate_hmac", "false")
+	defer os.Unsetenv("gateway_url")
+	defer os.Unsetenv("secret_mount_path")
+
+	pushEvent := sdktest.GitHubPush("alexellis", "fn1", "master", "af6db")
+	pushEvent.Ref = "refs/tags/v1.2.0"
+
+	res := handle(sdktest.Payload(pushEvent))
+	if want := "skipping build for: refs/tags/v1.2.0 branch, the build branch is: master"; res.Reason != want {
+		t.Errorf("want tags skipped by default, got: %q", res.Reason)
+	}
+
+	os.Setenv("tag_deploys", "push")
+	defer os.Unsetenv("tag_deploys")
+
+	res = handle(sdktest.Payload(pushEvent))
+	if !strings.Contains(res.Reason, "git-tar: 202") {
+		t.Errorf("want git-tar invoked for the tag, got: %q", res.Reason)
+	}
+}
+
+func Test_isTagPush(t *testing.T) {
+	os.Setenv("tag_deploys", "push")
+	defer os.Unsetenv("tag_deploys")
+
+	cases := []struct {
+		ref  string
+		sha  string
+		want bool
+	}{
+		{ref: "refs/tags/v1.0.0", sha: "af6db", want: true},
+		{ref: "refs/tags/v1.0.0", sha: "0000000000000000000000000000000000000000", want: false},
+		{ref: "refs/heads/master", sha: "af6db", want: false},
+	}
+
+	for _, c := range cases {
+		pushEvent := sdk.PushEvent{Ref: c.ref, AfterCommitID: c.sha}
+		if got := isTagPush(pushEvent); got != c.want {
+			t.Errorf("isTagPush(%s@%s): want %t, got %t", c.ref, c.sha, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package function
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// annotationPath is the file timing annotations are made on, every
+// repository built by OpenFaaS Cloud has one
+const annotationPath = "stack.yml"
+
+// maxCheckMessageLength is the longest text GitHub accepts for the
+// output of a check run
+const maxCheckMessageLength = 65535
+
+// checkRunRequest creates or updates a check run. It is sent with the
+// client's NewRequest as the vendored go-github does not have actions
+// nor the current fields of annotations.
+type checkRunRequest struct {
+	Name        string           `json:"name,omitempty"`
+	HeadSHA     string           `json:"head_sha,omitempty"`
+	DetailsURL  string           `json:"details_url,omitempty"`
+	Status      string           `json:"status,omitempty"`
+	Conclusion  string           `json:"conclusion,omitempty"`
+	StartedAt   *time.Time       `json:"started_at,omitempty"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+	Output      *checkRunOutput  `json:"output,omitempty"`
+	Actions     []checkRunAction `json:"actions,omitempty"`
+}
+
+type checkRunOutput struct {
+	Title       string               `json:"title"`
+	Summary     string               `json:"summary"`
+	Text        string               `json:"text,omitempty"`
+	Annotations []checkRunAnnotation `json:"annotations,omitempty"`
+}
+
+type checkRunAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Title           string `json:"title,omitempty"`
+	Message         string `json:"message"`
+}
+
+// checkRunAction is a button shown on the check run, pressing it sends
+// a check_run event with the requested_action
+type checkRunAction struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Identifier  string `json:"identifier"`
+}
+
+// checkDetail gives buildshiprun's detail for the check run of a
+// function, rather than of the stack or a verification
+func checkDetail(commitStatus *sdk.CommitStatus, detail *sdk.CheckRunDetail) *sdk.CheckRunDetail {
+	if _, verify := sdk.IsVerifyContext(commitStatus.Context); verify || commitStatus.Context == sdk.StackContext {
+		return nil
+	}
+	return detail
+}
+
+// buildCheckRunRequest gives the check run for a status. The text is the
+// build log, and buildshiprun's detail adds the timings as a table and
+// an annotation. A completed check run can be re-run with a button.
+func buildCheckRunRequest(commitStatus *sdk.CommitStatus, event *sdk.Event, detail *sdk.CheckRunDetail, url string, logValue string, now time.Time) checkRunRequest {
+	status := commitStatus.Status
+	checkRunStatus := getCheckRunStatus(&status)
+
+	summary := *getCheckRunDescription(commitStatus, &url)
+	if detail != nil && len(detail.Timings) > 0 {
+		summary = summary + "\n\n" + formatTimings(detail.Timings)
+	}
+
+	check := checkRunRequest{
+		Name:       sdk.ContextName(commitStatus.Context),
+		HeadSHA:    event.SHA,
+		DetailsURL: url,
+		Status:     checkRunStatus,
+		StartedAt:  &now,
+		Output: &checkRunOutput{
+			Title:   *getCheckRunTitle(commitStatus),
+			Summary: summary,
+			Text:    logValue,
+		},
+	}
+
+	if detail != nil && !detail.Started.IsZero() {
+		started := detail.Started
+		check.StartedAt = &started
+	}
+
+	if checkRunStatus == githubCheckCompleted {
+		check.Conclusion = getCheckRunConclusion(&status)
+		check.CompletedAt = &now
+		check.Actions = []checkRunAction{
+			{
+				Label:       "Re-run",
+				Description: "Build and deploy the commit again",
+				Identifier:  sdk.RerunAction,
+			},
+		}
+
+		if detail != nil && len(detail.Timings) > 0 {
+			check.Output.Annotations = []checkRunAnnotation{
+				{
+					Path:            annotationPath,
+					StartLine:       1,
+					EndLine:         1,
+					AnnotationLevel: "notice",
+					Title:           fmt.Sprintf("Timings for %s", event.Service),
+					Message:         formatTimingsLine(detail.Timings),
+				},
+			}
+		}
+	}
+
+	return check
+}
+
+// formatTimings gives a markdown table of the timings and their total
+func formatTimings(timings []sdk.CheckTiming) string {
+	var total float64
+	rows := []string{"| Phase | Time |", "|---|---|"}
+	for _, timing := range timings {
+		rows = append(rows, fmt.Sprintf("| %s | %.2fs |", timing.Phase, timing.Seconds))
+		total += timing.Seconds
+	}
+	rows = append(rows, fmt.Sprintf("| **total** | **%.2fs** |", total))
+	return strings.Join(rows, "\n")
+}
+
+// formatTimingsLine gives the timings on one line, i.e.
+// "build: 12.50s, push: 2.00s, deploy: 3.00s"
+func formatTimingsLine(timings []sdk.CheckTiming) string {
+	parts := []string{}
+	for _, timing := range timings {
+		parts = append(parts, fmt.Sprintf("%s: %.2fs", timing.Phase, timing.Seconds))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,65 @@
+package function
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_buildCheckRunRequest_Completed(t *testing.T) {
+	status := &sdk.CommitStatus{Status: sdk.StatusSuccess, Description: "deployed: alexellis-fn1", Context: "fn1"}
+	event := &sdk.Event{Service: "fn1", SHA: "af6db3c"}
+	started := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	detail := &sdk.CheckRunDetail{
+		Started: started,
+		Timings: []sdk.CheckTiming{{Phase: "build", Seconds: 12.5}, {Phase: "deploy", Seconds: 2}},
+	}
+
+	check := buildCheckRunRequest(status, event, detail, "https://system.o6s.io/dashboard/", "logs", time.Now())
+
+	if check.Name != "fn1" || check.HeadSHA != "af6db3c" || check.Status != "completed" || check.Conclusion != "success" {
+		t.Errorf("want a completed, successful run for fn1, got %+v", check)
+	}
+	if check.StartedAt == nil || !check.StartedAt.Equal(started) {
+		t.Errorf("want the run started with the build, got %v", check.StartedAt)
+	}
+	if !strings.Contains(check.Output.Summary, "| build | 12.50s |") || !strings.Contains(check.Output.Summary, "| **total** | **14.50s** |") {
+		t.Errorf("want the timings in the summary, got %s", check.Output.Summary)
+	}
+	if len(check.Output.Annotations) != 1 || check.Output.Annotations[0].Message != "build: 12.50s, deploy: 2.00s" {
+		t.Errorf("want a timing annotation, got %v", check.Output.Annotations)
+	}
+	if len(check.Actions) != 1 || check.Actions[0].Identifier != sdk.RerunAction {
+		t.Errorf("want a re-run button, got %v", check.Actions)
+	}
+}
+
+func Test_buildCheckRunRequest_Pending(t *testing.T) {
+	status := &sdk.CommitStatus{Status: sdk.StatusPending, Description: "building", Context: "fn1"}
+	event := &sdk.Event{Service: "fn1", SHA: "af6db3c"}
+
+	check := buildCheckRunRequest(status, event, nil, "", "", time.Now())
+
+	if check.Status != "queued" || len(check.Conclusion) > 0 || check.CompletedAt != nil {
+		t.Errorf("want a queued run, got %+v", check)
+	}
+	if len(check.Actions) > 0 || len(check.Output.Annotations) > 0 {
+		t.Errorf("want no re-run button or annotations until the run completes, got %+v", check)
+	}
+}
+
+func Test_checkDetail(t *testing.T) {
+	detail := &sdk.CheckRunDetail{}
+
+	if got := checkDetail(&sdk.CommitStatus{Context: "fn1"}, detail); got != detail {
+		t.Errorf("want the detail for the function's run")
+	}
+	if got := checkDetail(&sdk.CommitStatus{Context: sdk.StackContext}, detail); got != nil {
+		t.Errorf("want no detail for the stack's run")
+	}
+	if got := checkDetail(&sdk.CommitStatus{Context: sdk.BuildVerifyContext("fn1")}, detail); got != nil {
+		t.Errorf("want no detail for the verification's run")
+	}
+}
@@ -0,0 +1,154 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/alexellis/derek/config"
+	"github.com/alexellis/derek/factory"
+	"github.com/google/go-github/github"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+const githubDeploymentSuccess = "success"
+
+// deploymentsEnabled can be turned off with use_deployments=false for
+// installations where the GitHub App lacks the deployments permission
+func deploymentsEnabled() bool {
+	return os.Getenv("use_deployments") != "false"
+}
+
+// deploymentEnvironment is the name of the environment shown in the
+// repository's environments view. There is one for each function and
+// branch, i.e. openfaas-fn/alexellis-fn1 (master), so that a preview or
+// a staging deployment doesn't mark the production one inactive. The
+// namespace is replaced by deployment_environment when it is set.
+func deploymentEnvironment(event *sdk.Event) string {
+	namespace := functionNamespace(event.Owner)
+	if val, ok := os.LookupEnv("deployment_environment"); ok && len(val) > 0 {
+		namespace = val
+	}
+
+	environment := namespace + "/" + sdk.FunctionName(event.Owner, event.Service)
+	if ref := deploymentRef(event); len(ref) > 0 {
+		environment = fmt.Sprintf("%s (%s)", environment, ref)
+	}
+	return environment
+}
+
+// deploymentRef is the branch which was deployed, every tag shares one
+// environment
+func deploymentRef(event *sdk.Event) string {
+	if len(event.Tag) > 0 {
+		return "tags"
+	}
+	return event.Branch
+}
+
+// functionNamespace is the namespace buildshiprun deploys the owner's
+// functions to, the gateway's function_namespace unless
+// owner_namespaces=true
+func functionNamespace(owner string) string {
+	if namespace := sdk.OwnerNamespace(owner); len(namespace) > 0 {
+		return namespace
+	}
+
+	if val := os.Getenv("function_namespace"); len(val) > 0 {
+		return val
+	}
+	return "openfaas-fn"
+}
+
+// productionDeployment is true for the build_branch, or for a tag when
+// tag_deploy_target deploys tags to production and leaves the build
+// branch as staging. Previews and other branches are not production.
+func productionDeployment(event *sdk.Event) bool {
+	if event.PullRequest > 0 {
+		return false
+	}
+
+	tagTarget := len(os.Getenv("tag_deploy_target")) > 0
+	if len(event.Tag) > 0 {
+		return tagTarget
+	}
+
+	buildBranch := os.Getenv("build_branch")
+	if len(buildBranch) == 0 {
+		buildBranch = "master"
+	}
+	return !tagTarget && event.Branch == buildBranch
+}
+
+// isDeployment is true for the success status of a function, rather
+// than of the stack, its verification or of a build in progress
+func isDeployment(commitStatus *sdk.CommitStatus) bool {
+	if _, verify := sdk.IsVerifyContext(commitStatus.Context); verify {
+		return false
+	}
+	return commitStatus.Status == sdk.StatusSuccess && commitStatus.Context != sdk.StackContext
+}
+
+func buildDeploymentRequest(event *sdk.Event, environment string) *github.DeploymentRequest {
+	task := "deploy:" + event.Service
+	autoMerge := false
+	production := productionDeployment(event)
+	// A preview's environment goes away when the pull request closes
+	transient := event.PullRequest > 0
+	description := fmt.Sprintf("Deploy %s", event.Service)
+	// Statuses for the other functions in the stack may still be pending
+	requiredContexts := []string{}
+
+	return &github.DeploymentRequest{
+		Ref:                   &event.SHA,
+		Task:                  &task,
+		AutoMerge:             &autoMerge,
+		RequiredContexts:      &requiredContexts,
+		Environment:           &environment,
+		Description:           &description,
+		ProductionEnvironment: &production,
+		TransientEnvironment:  &transient,
+	}
+}
+
+func buildDeploymentStatusRequest(event *sdk.Event, functionURL string, logURL string) *github.DeploymentStatusRequest {
+	state := githubDeploymentSuccess
+	description := fmt.Sprintf("Deployed %s", event.Service)
+	// Marks the previous deployment of the function as inactive
+	autoInactive := true
+
+	return &github.DeploymentStatusRequest{
+		State:          &state,
+		Description:    &description,
+		EnvironmentURL: &functionURL,
+		LogURL:         &logURL,
+		AutoInactive:   &autoInactive,
+	}
+}
+
+// reportDeployment creates a GitHub Deployment for the commit and
+// marks it as successful with the function's URL, giving the
+// repository a deploy history for each function.
+func reportDeployment(commitStatus *sdk.CommitStatus, event *sdk.Event, cfg config.Config) error {
+	ctx := context.Background()
+	client := factory.MakeClient(ctx, token, cfg)
+
+	environment := deploymentEnvironment(event)
+	deployment, _, err := client.Repositories.CreateDeployment(ctx, event.Owner, event.Repository, buildDeploymentRequest(event, environment))
+	if err != nil {
+		return fmt.Errorf("failed to create deployment for %s, error: %s", event.Service, err.Error())
+	}
+
+	functionURL := buildPublicStatusURL(sdk.StatusSuccess, commitStatus.Context, event)
+	logURL := buildPublicStatusURL(sdk.StatusFailure, commitStatus.Context, event)
+
+	log.Printf("Deployment: %d, Environment: %s, URL: %s, Repo: %s, Owner: %s", deployment.GetID(), environment, functionURL, event.Repository, event.Owner)
+
+	_, _, err = client.Repositories.CreateDeploymentStatus(ctx, event.Owner, event.Repository, deployment.GetID(), buildDeploymentStatusRequest(event, functionURL, logURL))
+	if err != nil {
+		return fmt.Errorf("failed to create deployment status for %s, error: %s", event.Service, err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package function
+
+import (
+	"os"
+	"testing"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_isDeployment(t *testing.T) {
+	tests := []struct {
+		title  string
+		status sdk.CommitStatus
+		want   bool
+	}{
+		{"function deployed", sdk.CommitStatus{Status: sdk.StatusSuccess, Context: "fn1"}, true},
+		{"function pending", sdk.CommitStatus{Status: sdk.StatusPending, Context: "fn1"}, false},
+		{"function failed", sdk.CommitStatus{Status: sdk.StatusFailure, Context: "fn1"}, false},
+		{"stack deployed", sdk.CommitStatus{Status: sdk.StatusSuccess, Context: sdk.StackContext}, false},
+		{"function verified", sdk.CommitStatus{Status: sdk.StatusSuccess, Context: sdk.BuildVerifyContext("fn1")}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if got := isDeployment(&test.status); got != test.want {
+				t.Errorf("want %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func Test_deploymentEnvironment(t *testing.T) {
+	os.Unsetenv("deployment_environment")
+	os.Unsetenv("owner_namespaces")
+
+	tests := []struct {
+		title string
+		event sdk.Event
+		want  string
+	}{
+		{"branch", sdk.Event{Owner: "AlexEllis", Service: "fn1", Branch: "master"}, "openfaas-fn/alexellis-fn1 (master)"},
+		{"other branch", sdk.Event{Owner: "alexellis", Service: "fn1", Branch: "staging"}, "openfaas-fn/alexellis-fn1 (staging)"},
+		{"preview", sdk.Event{Owner: "alexellis", Service: "fn1-pr-12", Branch: "feature", PullRequest: 12}, "openfaas-fn/alexellis-fn1-pr-12 (feature)"},
+		{"tag", sdk.Event{Owner: "alexellis", Service: "fn1", Tag: "v1.2.0"}, "openfaas-fn/alexellis-fn1 (tags)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if got := deploymentEnvironment(&test.event); got != test.want {
+				t.Errorf("want %s, got %s", test.want, got)
+			}
+		})
+	}
+
+	event := &sdk.Event{Owner: "alexellis", Service: "fn1", Branch: "master"}
+
+	os.Setenv("owner_namespaces", "true")
+	defer os.Unsetenv("owner_namespaces")
+	if got := deploymentEnvironment(event); got != "openfaas-fn-alexellis/fn1 (master)" {
+		t.Errorf("want openfaas-fn-alexellis/fn1 (master), got %s", got)
+	}
+
+	os.Setenv("deployment_environment", "production")
+	defer os.Unsetenv("deployment_environment")
+	if got := deploymentEnvironment(event); got != "production/fn1 (master)" {
+		t.Errorf("want production/fn1 (master), got %s", got)
+	}
+}
+
+func Test_productionDeployment(t *testing.T) {
+	os.Unsetenv("build_branch")
+	os.Unsetenv("tag_deploy_target")
+
+	tests := []struct {
+		title     string
+		event     sdk.Event
+		tagTarget string
+		want      bool
+	}{
+		{"build branch", sdk.Event{Branch: "master"}, "", true},
+		{"other branch", sdk.Event{Branch: "staging"}, "", false},
+		{"preview", sdk.Event{Branch: "master", PullRequest: 12}, "", false},
+		{"tag", sdk.Event{Tag: "v1.2.0"}, "", false},
+		{"tag deployed to production", sdk.Event{Tag: "v1.2.0"}, "production", true},
+		{"build branch as staging", sdk.Event{Branch: "master"}, "production", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			os.Setenv("tag_deploy_target", test.tagTarget)
+			defer os.Unsetenv("tag_deploy_target")
+
+			if got := productionDeployment(&test.event); got != test.want {
+				t.Errorf("want %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func Test_buildDeploymentRequest(t *testing.T) {
+	os.Unsetenv("build_branch")
+	event := &sdk.Event{Service: "fn1", SHA: "6df8c47", Branch: "master"}
+
+	req := buildDeploymentRequest(event, "openfaas-fn/alexellis-fn1 (master)")
+
+	if *req.Ref != "6df8c47" {
+		t.Errorf("want ref 6df8c47, got %s", *req.Ref)
+	}
+	if *req.Environment != "openfaas-fn/alexellis-fn1 (master)" {
+		t.Errorf("want environment openfaas-fn/alexellis-fn1 (master), got %s", *req.Environment)
+	}
+	if len(*req.RequiredContexts) != 0 {
+		t.Errorf("want no required contexts, got %v", *req.RequiredContexts)
+	}
+	if !*req.ProductionEnvironment || *req.TransientEnvironment {
+		t.Errorf("want a production deployment for the build branch")
+	}
+
+	preview := buildDeploymentRequest(&sdk.Event{Service: "fn1-pr-12", SHA: "6df8c47", Branch: "feature", PullRequest: 12}, "openfaas-fn/alexellis-fn1-pr-12 (feature)")
+	if *preview.ProductionEnvironment || !*preview.TransientEnvironment {
+		t.Errorf("want a transient deployment for a preview")
+	}
+}
+
+func Test_buildDeploymentStatusRequest(t *testing.T) {
+	event := &sdk.Event{Service: "fn1"}
+
+	req := buildDeploymentStatusRequest(event, "https://alexellis.o6s.io/fn1", "https://system.o6s.io/dashboard/alexellis")
+
+	if *req.State != githubDeploymentSuccess {
+		t.Errorf("want state %s, got %s", githubDeploymentSuccess, *req.State)
+	}
+	if *req.EnvironmentURL != "https://alexellis.o6s.io/fn1" {
+		t.Errorf("want function URL, got %s", *req.EnvironmentURL)
+	}
+}
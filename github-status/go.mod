@@ -14,4 +14,5 @@ require (
 	golang.org/x/net v0.0.0-20180826012351-8a410e7b638d
 	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
 	google.golang.org/appengine v1.1.0
+	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
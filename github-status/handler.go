@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/alexellis/derek/auth"
@@ -64,6 +65,10 @@ func Handle(req []byte) string {
 		log.Fatal("failed commit statuses are empty: ", status.CommitStatuses)
 	}
 
+	// Every status of the pipeline passes through here, so the dashboard
+	// is sent each stage as it happens
+	sdk.PublishPipelineEvents(status)
+
 	// use auth token if provided
 	if status.AuthToken != sdk.EmptyAuthToken && sdk.ValidToken(status.AuthToken) {
 		token = status.AuthToken
@@ -88,7 +93,7 @@ func Handle(req []byte) string {
 	}
 
 	for _, commitStatus := range status.CommitStatuses {
-		err := reportToGithub(&commitStatus, &status.EventInfo)
+		err := reportToGithub(&commitStatus, &status.EventInfo, status.Deploy, status.Check)
 		if err != nil {
 			log.Fatalf("failed to report status %v, error: %s", status, err.Error())
 		}
@@ -102,7 +107,7 @@ func Handle(req []byte) string {
 }
 
 func getLogs(status *sdk.CommitStatus, event *sdk.Event) (string, error) {
-	client := &http.Client{}
+	client := sdk.HTTPClient()
 	var err error
 	gatewayURL := os.Getenv("gateway_url")
 	// TODO: support logs for different commit status contexts
@@ -124,7 +129,7 @@ func getLogs(status *sdk.CommitStatus, event *sdk.Event) (string, error) {
 	return string(responsePayload), nil
 }
 
-func reportToGithub(commitStatus *sdk.CommitStatus, event *sdk.Event) error {
+func reportToGithub(commitStatus *sdk.CommitStatus, event *sdk.Event, deploy *sdk.DeployInfo, detail *sdk.CheckRunDetail) error {
 	secretKey, err := sdk.ReadSecret(defaultPayloadSecretName)
 	if err != nil {
 		log.Printf("reusing provided auth token")
@@ -144,9 +149,28 @@ func reportToGithub(commitStatus *sdk.CommitStatus, event *sdk.Event) error {
 		ApplicationID: appID,
 	}
 	if os.Getenv("use_checks") == "false" {
-		return reportStatus(commitStatus.Status, commitStatus.Description, appID, event, cfg)
+		err = reportStatus(commitStatus.Status, commitStatus.Description, commitStatus.Context, event, cfg)
+	} else {
+		err = reportCheck(commitStatus, event, checkDetail(commitStatus, detail), cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	if deploymentsEnabled() && isDeployment(commitStatus) {
+		// The commit status has been written, so a failure here is not fatal
+		if deploymentErr := reportDeployment(commitStatus, event, cfg); deploymentErr != nil {
+			log.Printf(deploymentErr.Error())
+		}
 	}
-	return reportCheck(commitStatus, event, cfg)
+
+	if prCommentsEnabled() && isDeployment(commitStatus) && deploy != nil {
+		if commentErr := reportPullRequestComment(commitStatus, event, deploy, cfg); commentErr != nil {
+			log.Printf(commentErr.Error())
+		}
+	}
+
+	return nil
 }
 
 func reportStatus(status string, desc string, statusContext string, event *sdk.Event, cfg config.Config) error {
@@ -156,7 +180,7 @@ func reportStatus(status string, desc string, statusContext string, event *sdk.E
 
 	url := buildPublicStatusURL(status, statusContext, event)
 
-	repoStatus := buildStatus(status, desc, statusContext, url)
+	repoStatus := buildStatus(status, desc, sdk.ContextName(statusContext), url)
 
 	log.Printf("Status: %s, Context: %s, GitHub AppID: %s, Repo: %s, Owner: %s", status, statusContext, appID, event.Repository, event.Owner)
 
@@ -170,75 +194,58 @@ func reportStatus(status string, desc string, statusContext string, event *sdk.E
 	return nil
 }
 
-func reportCheck(commitStatus *sdk.CommitStatus, event *sdk.Event, cfg config.Config) error {
+func reportCheck(commitStatus *sdk.CommitStatus, event *sdk.Event, detail *sdk.CheckRunDetail, cfg config.Config) error {
 	ctx := context.Background()
 	appID := os.Getenv("github_app_id")
 	status := commitStatus.Status
 	url := buildPublicStatusURL(commitStatus.Status, commitStatus.Context, event)
+	name := sdk.ContextName(commitStatus.Context)
 
 	log.Printf("Check: %s, Context: %s, GitHub AppID: %s, Repo: %s, Owner: %s", status, commitStatus.Context, appID, event.Repository, event.Owner)
 
 	client := factory.MakeClient(ctx, token, cfg)
 
-	now := github.Timestamp{time.Now()}
+	var logValue string
+	if detail != nil && len(detail.LogExcerpt) > 0 {
+		logValue = formatLog(strings.Join(detail.LogExcerpt, "\n"), maxCheckMessageLength)
+	} else {
+		logs, err := getLogs(commitStatus, event)
+		if err != nil {
+			return err
+		}
 
-	logs, err := getLogs(commitStatus, event)
-	if err != nil {
-		return err
+		if len(logs) > 0 {
+			logValue = formatLog(logs, maxCheckMessageLength)
+		}
 	}
 
-	var logValue string
-
-	if len(logs) > 0 {
-		const maxCheckMessageLength = 65535
-		logValue = formatLog(logs, maxCheckMessageLength)
+	checks, _, listErr := client.Checks.ListCheckRunsForRef(ctx, event.Owner, event.Repository, event.SHA, &github.ListCheckRunsOptions{CheckName: &name})
+	if listErr != nil {
+		return fmt.Errorf("failed to list check runs for %s, error: %s", name, listErr.Error())
 	}
 
-	checks, _, _ := client.Checks.ListCheckRunsForRef(ctx, event.Owner, event.Repository, event.SHA, &github.ListCheckRunsOptions{CheckName: &appID})
-
-	checkRunStatus := getCheckRunStatus(&status)
-	conclusion := getCheckRunConclusion(&status)
-	summary := getCheckRunDescription(commitStatus, &url)
-	log.Printf("Check run status: %s", checkRunStatus)
-
-	var apiErr error
-	if *checks.Total == 0 {
-		check := github.CreateCheckRunOptions{
-			StartedAt: &now,
-			Name:      commitStatus.Context,
-			HeadSHA:   event.SHA,
-			Status:    &checkRunStatus,
-			Output: &github.CheckRunOutput{
-				Text:    &logValue,
-				Title:   getCheckRunTitle(commitStatus),
-				Summary: summary,
-			},
+	check := buildCheckRunRequest(commitStatus, event, detail, url, logValue, time.Now())
+	log.Printf("Check run status: %s", check.Status)
+
+	method, path := http.MethodPost, fmt.Sprintf("repos/%s/%s/check-runs", event.Owner, event.Repository)
+	if checks.Total != nil && *checks.Total > 0 {
+		// The run was created with the pending status, it keeps its
+		// commit and start time
+		method, path = http.MethodPatch, fmt.Sprintf("%s/%d", path, *checks.CheckRuns[0].ID)
+		check.HeadSHA = ""
+		if detail == nil {
+			check.StartedAt = nil
 		}
+	}
 
-		if checkRunStatus == githubCheckCompleted {
-			check.Conclusion = &conclusion
-			check.CompletedAt = &now
-		}
-		log.Printf("Creating check run %s", check.Name)
-		_, _, apiErr = client.Checks.CreateCheckRun(ctx, event.Owner, event.Repository, check)
-	} else {
-		check := github.UpdateCheckRunOptions{
-			Name:       *checks.CheckRuns[0].Name,
-			DetailsURL: &url,
-			Output: &github.CheckRunOutput{
-				Text:    &logValue,
-				Title:   getCheckRunTitle(commitStatus),
-				Summary: summary,
-			},
-		}
-		if checkRunStatus == "completed" {
-			check.Conclusion = &conclusion
-			check.CompletedAt = &now
-		}
-		_, _, apiErr = client.Checks.UpdateCheckRun(ctx, event.Owner, event.Repository, *checks.CheckRuns[0].ID, check)
-		log.Printf("Creating check run %s", check.Name)
+	req, err := client.NewRequest(method, path, check)
+	if err != nil {
+		return err
 	}
-	if apiErr != nil {
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	log.Printf("%s check run %s", method, check.Name)
+	if _, apiErr := client.Do(ctx, req, nil); apiErr != nil {
 		return fmt.Errorf("failed to report status %s, error: %s", status, apiErr.Error())
 	}
 	return nil
@@ -269,9 +276,12 @@ func getCheckRunConclusion(status *string) string {
 // getCheckRunTitle returns a title for the given status to be displayed in Github Checks UI
 func getCheckRunTitle(status *sdk.CommitStatus) *string {
 	title := status.Description
-	switch status.Context {
-	case sdk.StackContext:
+	function, verify := sdk.IsVerifyContext(status.Context)
+	switch {
+	case status.Context == sdk.StackContext:
 		title = "Deploy to OpenFaaS"
+	case verify:
+		title = fmt.Sprintf("Verify %s", function)
 	default: // Assuming status is either a function name (building) or stack deploy
 		title = fmt.Sprintf("Build %s", status.Context)
 	}
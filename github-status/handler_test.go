@@ -302,6 +302,12 @@ func TestGetCheckRunTitle(t *testing.T) {
 	if *title != "Build hello-go" {
 		t.Fatalf("Expected %s but got %s", "Build hello-go", *title)
 	}
+
+	status.Context = sdk.BuildVerifyContext("hello-go")
+	title = getCheckRunTitle(status)
+	if *title != "Verify hello-go" {
+		t.Fatalf("Expected %s but got %s", "Verify hello-go", *title)
+	}
 }
 
 func TestGetCheckRunStatus(t *testing.T) {
@@ -0,0 +1,211 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/alexellis/derek/auth"
+	"github.com/alexellis/derek/config"
+	"github.com/alexellis/derek/factory"
+	"github.com/google/go-github/github"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// prCommentsEnabled can be turned off with pr_comments=false
+func prCommentsEnabled() bool {
+	return os.Getenv("pr_comments") != "false"
+}
+
+// commentMarker identifies the comment for a function so that it is
+// updated by later pushes to the pull request instead of repeated
+func commentMarker(service string) string {
+	return fmt.Sprintf("<!-- openfaas-cloud:%s -->", service)
+}
+
+// buildComment formats the deploy preview comment for a function
+func buildComment(event *sdk.Event, deploy *sdk.DeployInfo, functionURL string) string {
+	// Hide internal-repo details
+	image := deploy.Image[strings.Index(deploy.Image, "/")+1:]
+
+	lines := []string{
+		commentMarker(event.Service),
+		fmt.Sprintf("Deployed `%s` from %s", event.Service, event.SHA),
+		"",
+		"| | |",
+		"|---|---|",
+		fmt.Sprintf("| Endpoint | %s |", functionURL),
+		fmt.Sprintf("| Build time | %.1fs |", deploy.BuildSeconds),
+		fmt.Sprintf("| Image | `%s` |", image),
+	}
+
+	if deploy.Drift != nil {
+		lines = append(lines, "")
+		lines = append(lines, driftLines(deploy.Drift)...)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// driftLines lists how the preview's labels, limits, env-vars and secrets
+// differ from the production function's, for reviewers to spot a change
+// of configuration along with the code. Values of env-vars and secrets
+// are never recorded.
+func driftLines(drift *sdk.DeployDiff) []string {
+	if len(drift.Changes) == 0 {
+		return []string{"Configuration matches production."}
+	}
+
+	lines := []string{
+		"Configuration compared with production:",
+		"",
+		"| Change | Name | Production | Preview |",
+		"|---|---|---|---|",
+	}
+	for _, change := range drift.Changes {
+		lines = append(lines, fmt.Sprintf("| %s %s | %s | %s | %s |",
+			change.Kind, change.Action, driftCell(change.Name), driftCell(change.Old), driftCell(change.New)))
+	}
+	return lines
+}
+
+func driftCell(value string) string {
+	if len(value) == 0 {
+		return ""
+	}
+	return "`" + strings.Replace(value, "|", "\\|", -1) + "`"
+}
+
+// appLogin is the login the GitHub App comments as, looked up once
+var appLogin string
+
+// botLogin gives the login of the GitHub App's bot user, i.e.
+// "openfaas-cloud[bot]". The App is read with a JWT, since an
+// installation's token can't look it up.
+func botLogin(ctx context.Context, cfg config.Config) (string, error) {
+	if len(appLogin) > 0 {
+		return appLogin, nil
+	}
+
+	jwt, err := auth.GetSignedJwtToken(cfg.ApplicationID, cfg.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	app, _, err := factory.MakeClient(ctx, jwt, cfg).Apps.Get(ctx, "")
+	if err != nil {
+		return "", err
+	}
+
+	// The slug is the last part of https://github.com/apps/<slug>
+	slug := path.Base(app.GetHTMLURL())
+	if len(slug) == 0 || slug == "." || slug == "/" {
+		return "", fmt.Errorf("no slug in the App's URL %q", app.GetHTMLURL())
+	}
+
+	appLogin = slug + "[bot]"
+	return appLogin, nil
+}
+
+// findPullRequests returns the numbers of the open pull requests whose
+// head is the pushed commit
+func findPullRequests(ctx context.Context, client *github.Client, event *sdk.Event) ([]int, error) {
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	numbers := []int{}
+	for {
+		pulls, res, err := client.PullRequests.List(ctx, event.Owner, event.Repository, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pull := range pulls {
+			if pull.GetHead().GetSHA() == event.SHA {
+				numbers = append(numbers, pull.GetNumber())
+			}
+		}
+
+		if res.NextPage == 0 {
+			return numbers, nil
+		}
+		opts.Page = res.NextPage
+	}
+}
+
+// findComment gives the comment left on the pull request by the bot for
+// an earlier commit, or nil. A comment by anyone else is not edited, even
+// when it has the marker.
+func findComment(ctx context.Context, client *github.Client, event *sdk.Event, number int, marker string, login string) (*github.IssueComment, error) {
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	for {
+		comments, res, err := client.Issues.ListComments(ctx, event.Owner, event.Repository, number, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, comment := range comments {
+			if comment.GetUser().GetLogin() == login && strings.HasPrefix(comment.GetBody(), marker) {
+				return comment, nil
+			}
+		}
+
+		if res.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = res.NextPage
+	}
+}
+
+// reportPullRequestComment posts the function's endpoint, build time
+// and image to each open pull request for the commit, editing the
+// comment left for an earlier commit when there is one.
+func reportPullRequestComment(commitStatus *sdk.CommitStatus, event *sdk.Event, deploy *sdk.DeployInfo, cfg config.Config) error {
+	ctx := context.Background()
+	client := factory.MakeClient(ctx, token, cfg)
+
+	numbers, err := findPullRequests(ctx, client, event)
+	if err != nil {
+		return fmt.Errorf("failed to list pull requests for %s, error: %s", event.SHA, err.Error())
+	}
+	if len(numbers) == 0 {
+		return nil
+	}
+
+	login, err := botLogin(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to read the GitHub App's login, error: %s", err.Error())
+	}
+
+	functionURL := buildPublicStatusURL(sdk.StatusSuccess, commitStatus.Context, event)
+	body := buildComment(event, deploy, functionURL)
+	marker := commentMarker(event.Service)
+
+	for _, number := range numbers {
+		existing, err := findComment(ctx, client, event, number, marker, login)
+		if err != nil {
+			return fmt.Errorf("failed to list comments on #%d, error: %s", number, err.Error())
+		}
+
+		log.Printf("Pull request comment: #%d, Function: %s, Repo: %s, Owner: %s", number, event.Service, event.Repository, event.Owner)
+
+		if existing != nil {
+			_, _, err = client.Issues.EditComment(ctx, event.Owner, event.Repository, existing.GetID(), &github.IssueComment{Body: &body})
+		} else {
+			_, _, err = client.Issues.CreateComment(ctx, event.Owner, event.Repository, number, &github.IssueComment{Body: &body})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to comment on #%d, error: %s", number, err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_buildComment(t *testing.T) {
+	event := &sdk.Event{Service: "fn1", SHA: "6df8c47"}
+	deploy := &sdk.DeployInfo{Image: "registry:5000/alexellis-fn1:latest-6df8c47", BuildSeconds: 42.25}
+
+	comment := buildComment(event, deploy, "https://alexellis.o6s.io/fn1")
+
+	if !strings.HasPrefix(comment, commentMarker("fn1")) {
+		t.Errorf("want comment to start with the marker, got %s", comment)
+	}
+
+	for _, want := range []string{"https://alexellis.o6s.io/fn1", "42.2s", "`alexellis-fn1:latest-6df8c47`"} {
+		if !strings.Contains(comment, want) {
+			t.Errorf("want comment to contain %s, got %s", want, comment)
+		}
+	}
+
+	if strings.Contains(comment, "registry:5000") {
+		t.Errorf("want registry hidden, got %s", comment)
+	}
+}
+
+func Test_buildComment_Drift(t *testing.T) {
+	event := &sdk.Event{Service: "fn1-pr-12", SHA: "6df8c47"}
+	deploy := &sdk.DeployInfo{
+		Image: "registry:5000/alexellis-fn1:latest-6df8c47",
+		Drift: &sdk.DeployDiff{Changes: []sdk.DeployChange{
+			{Kind: sdk.LabelChange, Name: "com.openfaas.scale.max", Action: sdk.ChangeUpdated, Old: "4", New: "10"},
+			{Kind: sdk.SecretChange, Name: "alexellis-db", Action: sdk.ChangeAdded},
+		}},
+	}
+
+	comment := buildComment(event, deploy, "https://alexellis.o6s.io/fn1-pr-12")
+
+	for _, want := range []string{
+		"| label updated | `com.openfaas.scale.max` | `4` | `10` |",
+		"| secret added | `alexellis-db` |  |  |",
+	} {
+		if !strings.Contains(comment, want) {
+			t.Errorf("want comment to contain %s, got %s", want, comment)
+		}
+	}
+
+	deploy.Drift = &sdk.DeployDiff{}
+	if comment := buildComment(event, deploy, ""); !strings.Contains(comment, "Configuration matches production.") {
+		t.Errorf("want no drift reported, got %s", comment)
+	}
+}
+
+func Test_commentMarker_PerFunction(t *testing.T) {
+	if commentMarker("fn1") == commentMarker("fn2") {
+		t.Errorf("want a marker per function")
+	}
+}
+
+// pagedClient serves each page of a list from pages, with a Link to the
+// next page as the GitHub API does
+func pagedClient(t *testing.T, pages []string) (*github.Client, func()) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		fmt.Sscanf(r.URL.Query().Get("page"), "%d", &page)
+		if page < 1 || page > len(pages) {
+			t.Fatalf("unexpected page %d", page)
+		}
+		if page < len(pages) {
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=%d>; rel="next"`, server.URL, r.URL.Path, page+1))
+		}
+		w.Write([]byte(pages[page-1]))
+	}))
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client, server.Close
+}
+
+func Test_findPullRequests_Paginates(t *testing.T) {
+	client, done := pagedClient(t, []string{
+		`[{"number": 1, "head": {"sha": "a44c3c4"}}]`,
+		`[{"number": 2, "head": {"sha": "6df8c47"}}]`,
+	})
+	defer done()
+
+	numbers, err := findPullRequests(context.Background(), client, &sdk.Event{Owner: "alexellis", Repository: "fns", SHA: "6df8c47"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(numbers) != 1 || numbers[0] != 2 {
+		t.Errorf("want #2 found on the second page, got %v", numbers)
+	}
+}
+
+func Test_findComment_OnlyTheBots(t *testing.T) {
+	marker := commentMarker("fn1")
+	client, done := pagedClient(t, []string{
+		fmt.Sprintf(`[{"id": 10, "body": %q, "user": {"login": "alexellis"}}]`, marker+"\ncopied"),
+		fmt.Sprintf(`[{"id": 11, "body": %q, "user": {"login": "other-app[bot]"}}, {"id": 12, "body": %q, "user": {"login": "openfaas-cloud[bot]"}}]`, marker, marker),
+	})
+	defer done()
+
+	comment, err := findComment(context.Background(), client, &sdk.Event{Owner: "alexellis", Repository: "fns"}, 1, marker, "openfaas-cloud[bot]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment.GetID() != 12 {
+		t.Errorf("want the bot's own comment from the second page, got %d", comment.GetID())
+	}
+}
@@ -58,6 +58,8 @@ func buildPublicStatusURL(status, statusContext string, event *sdk.Event) string
 	} else if status == sdk.StatusFailure {
 		if len(gatewayPrettyURL) > 0 {
 			url = gatewayPrettyURL
+		} else if len(publicURL) > 0 && !isStack {
+			url = buildPublicLogURL(publicURL, event)
 		} else if len(publicURL) > 0 {
 			url = publicURL
 		}
@@ -66,6 +68,12 @@ func buildPublicStatusURL(status, statusContext string, event *sdk.Event) string
 
 }
 
+// buildPublicLogURL links a failed build to its log in the dashboard
+// on the public URL, so that users can see why it failed
+func buildPublicLogURL(dashboardURL string, event *sdk.Event) string {
+	return dashboardURL + "/" + event.Owner + "/" + event.Service + "/build-log?repoPath=" + event.Owner + "/" + event.Repository + "&commitSHA=" + event.SHA
+}
+
 func replaceFunctionSuffix(url, newSuffix string) string {
 	if strings.HasSuffix(url, "function/") {
 		url = strings.TrimSuffix(url, "function/")
@@ -230,6 +230,26 @@ func TestBuildURLFailsWithPublicUrl(t *testing.T) {
 	}
 }
 
+func TestBuildURLFailsWithPublicUrlLinksBuildLog(t *testing.T) {
+	os.Setenv("gateway_public_url", "http://localhost:8080")
+	os.Setenv("gateway_pretty_url", "")
+
+	event := &sdk.Event{
+		Owner:      "alexellis",
+		Service:    "tester",
+		URL:        "http://original-value.local",
+		Repository: "functions",
+		SHA:        "SomeSha",
+	}
+	want := "http://localhost:8080/function/system-dashboard/alexellis/tester/build-log?repoPath=alexellis/functions&commitSHA=SomeSha"
+
+	got := buildPublicStatusURL("failure", sdk.BuildFunctionContext(event.Service), event)
+
+	if got != want {
+		t.Errorf("building PublicURL: want %s, got %s", want, got)
+	}
+}
+
 func TestBuildPrettyURLNoUrl(t *testing.T) {
 	gatewayPrettyUrl := ""
 
@@ -20,6 +20,7 @@ import (
 const (
 	Source              = "gitlab-event"
 	EventSource         = "System Hook"
+	ProjectHookSource   = "Push Hook"
 	PushEvent           = "push"
 	ProjectUpdateEvent  = "project_update"
 	ProjectDestroyEvent = "project_destroy"
@@ -27,6 +28,11 @@ const (
 
 var (
 	supportedEvents = [...]string{PushEvent, ProjectUpdateEvent, ProjectDestroyEvent}
+
+	// supportedHooks are the X-Gitlab-Event values accepted. A System
+	// Hook is added by an admin for the whole instance, a Push Hook is a
+	// project's own webhook for users who can't add a System Hook
+	supportedHooks = [...]string{EventSource, ProjectHookSource}
 )
 
 // Handle is the function which accepts events from
@@ -36,14 +42,14 @@ func Handle(req []byte) string {
 	eventHeader := os.Getenv("Http_X_Gitlab_Event")
 	xGitlabToken := os.Getenv("Http_X_Gitlab_Token")
 
-	if eventHeader != EventSource {
+	if !checkSupportedHook(eventHeader) {
 		auditEvent := sdk.AuditEvent{
-			Message: "required : " + EventSource,
+			Message: "required : " + EventSource + " or " + ProjectHookSource,
 			Source:  Source,
 		}
 		sdk.PostAudit(auditEvent)
 
-		return fmt.Sprintf("%s: %s required cannot handle: %s", Source, EventSource, eventHeader)
+		return fmt.Sprintf("%s: %s or %s required cannot handle: %s", Source, EventSource, ProjectHookSource, eventHeader)
 	}
 
 	eventName := PureEvent{}
@@ -67,7 +73,10 @@ func Handle(req []byte) string {
 		if secretErr != nil {
 			return fmt.Sprintf("unable to load gitlab-webhook-secret: %s", secretErr.Error())
 		}
-		if xGitlabToken != tokenSecretKey {
+		header := http.Header{}
+		header.Set("X-Gitlab-Token", xGitlabToken)
+		scm, _ := sdk.GetSCM(sdk.SCMGitLab)
+		if err := scm.ValidateWebhook(req, header, tokenSecretKey); err != nil {
 			return fmt.Sprintf("value in X-Gitlab-Token does not match gitlab-webhook-secret")
 		}
 	}
@@ -202,7 +211,7 @@ func Handle(req []byte) string {
 }
 
 func garbageCollect(garbageRequests []GarbageRequest) error {
-	client := http.Client{}
+	client := sdk.HTTPClient()
 
 	suffix := os.Getenv("dns_suffix")
 	gatewayURL := os.Getenv("gateway_url")
@@ -268,7 +277,7 @@ func forward(req []byte, function string, headers map[string]string) (string, in
 	gatewayURL := os.Getenv("gateway_url")
 	gatewayURL = sdk.CreateServiceURL(gatewayURL, suffix)
 
-	c := http.Client{}
+	c := sdk.HTTPClient()
 
 	bodyReader := bytes.NewBuffer(req)
 	pushReq, reqErr := http.NewRequest(http.MethodPost, gatewayURL+"function/"+function, bodyReader)
@@ -319,7 +328,7 @@ func appInstalled(id int, instance, apiToken, installationTag string) (bool, err
 	}
 	req.Header.Add("PRIVATE-TOKEN", apiToken)
 
-	client := &http.Client{}
+	client := sdk.HTTPClient()
 	resp, respErr := client.Do(req)
 	if respErr != nil {
 		return false, fmt.Errorf("error while getting response from GitLab: %s", respErr.Error())
@@ -363,7 +372,7 @@ func getCustomers(customerURL string) ([]string, error) {
 		return nil, fmt.Errorf("error while making the request to `%s` : %s", customerURL, reqErr.Error())
 	}
 
-	c := http.Client{}
+	c := sdk.HTTPClient()
 	res, resErr := c.Do(httpReq)
 	if resErr != nil {
 		return nil, fmt.Errorf("error while requesting customers: %s", reqErr.Error())
@@ -411,3 +420,12 @@ func checkSupportedEvents(event string) bool {
 	}
 	return false
 }
+
+func checkSupportedHook(hook string) bool {
+	for _, supportedHook := range supportedHooks {
+		if supportedHook == hook {
+			return true
+		}
+	}
+	return false
+}
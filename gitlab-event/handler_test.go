@@ -47,6 +47,38 @@ func Test_checkSupportedEvents(t *testing.T) {
 	}
 }
 
+func Test_checkSupportedHook(t *testing.T) {
+	tests := []struct {
+		title        string
+		hook         string
+		expectedBool bool
+	}{
+		{
+			title:        "Supported `System Hook` for the whole instance",
+			hook:         "System Hook",
+			expectedBool: true,
+		},
+		{
+			title:        "Supported `Push Hook` from a project's webhook",
+			hook:         "Push Hook",
+			expectedBool: true,
+		},
+		{
+			title:        "Non-supported `Tag Push Hook`",
+			hook:         "Tag Push Hook",
+			expectedBool: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			hookSupported := checkSupportedHook(test.hook)
+			if hookSupported != test.expectedBool {
+				t.Errorf("expected to be: %v got: %v", test.expectedBool, hookSupported)
+			}
+		})
+	}
+}
+
 func Test_getUser(t *testing.T) {
 	tests := []struct {
 		title             string
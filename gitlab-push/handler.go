@@ -21,6 +21,9 @@ const (
 	PublicRepo   = 20
 	Source       = "gitlab-push"
 	SCM          = "gitlab"
+
+	SystemHook  = "System Hook"
+	ProjectHook = "Push Hook"
 )
 
 var audit sdk.Audit
@@ -40,7 +43,7 @@ func Handle(req []byte) string {
 
 	event := os.Getenv("Http_X_Gitlab_Event")
 
-	if event != "System Hook" {
+	if event != SystemHook && event != ProjectHook {
 		auditEvent := sdk.AuditEvent{
 			Message: "bad event: " + event,
 			Source:  Source,
@@ -72,7 +75,9 @@ func Handle(req []byte) string {
 			},
 			RepositoryURL: gitlabPushEvent.GitLabProject.WebURL,
 		},
-		AfterCommitID: gitlabPushEvent.AfterCommitID,
+		AfterCommitID:  gitlabPushEvent.AfterCommitID,
+		BeforeCommitID: gitlabPushEvent.BeforeCommitID,
+		HeadCommit:     gitlabPushEvent.HeadCommit(),
 		Installation: sdk.PushEventInstallation{
 			ID: gitlabPushEvent.GitLabProject.ID,
 		},
@@ -98,6 +103,20 @@ func Handle(req []byte) string {
 		return branchErrorMessage
 	}
 
+	if sdk.SkipCI(pushEvent.HeadCommit) {
+		msg := fmt.Sprintf("skipping build for: %s, [skip ci] in commit message", sdk.FormatShortSHA(pushEvent.AfterCommitID))
+		audit.Post(sdk.AuditEvent{
+			Message: msg,
+			Owner:   pushEvent.Repository.Owner.Login,
+			Repo:    pushEvent.Repository.Name,
+			Source:  Source,
+		})
+
+		status.AddStatus(sdk.StatusSuccess, msg, sdk.StackContext)
+		reportGitLabStatus(status)
+		return msg
+	}
+
 	serviceValue := fmt.Sprintf("%s-%s", pushEvent.Repository.Owner.Login, pushEvent.Repository.Name)
 	status.AddStatus(sdk.StatusPending, fmt.Sprintf("%s stack deploy is in progress", serviceValue), sdk.StackContext)
 	reportGitLabStatus(status)
@@ -144,7 +163,7 @@ func postEvent(pushEvent sdk.PushEvent) (int, error) {
 	digest := hmac.Sign(body, []byte(payloadSecret))
 	httpReq.Header.Add("X-Cloud-Signature", "sha1="+hex.EncodeToString(digest))
 
-	c := http.Client{}
+	c := sdk.HTTPClient()
 	res, reqErr := c.Do(httpReq)
 	if res.Body != nil {
 		defer res.Body.Close()
@@ -184,7 +203,7 @@ func reportGitLabStatus(status *sdk.Status) {
 	digest := hmac.Sign(statusBytes, []byte(payloadSecret))
 	req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
 
-	client := http.Client{}
+	client := sdk.HTTPClient()
 	res, resErr := client.Do(req)
 	if resErr != nil {
 		log.Printf("unexpected error while retrieving response: %s", resErr.Error())
@@ -225,23 +244,31 @@ func validateRequest(req []byte) (err error) {
 }
 
 func checkBranch(branchRef string) (branchErr error) {
-	buildBranch := getBranch()
-	branchFromRef := filterBranchRef(branchRef)
-	if buildBranch != branchFromRef {
-		msg := fmt.Sprintf("skipping build for: %s branch, the build branch is: %s",
-			branchFromRef,
-			buildBranch)
-		branchErr = fmt.Errorf(msg)
-	}
-	return branchErr
-}
+	branchFromRef := sdk.BranchFromRef(branchRef)
+	if len(branchFromRef) == 0 {
+		branchFromRef = filterBranchRef(branchRef)
+	}
 
-func getBranch() string {
-	buildBranch := "master"
-	if environmentBranch, exists := os.LookupEnv("build_branch"); exists {
-		buildBranch = strings.TrimSpace(environmentBranch)
+	// Any branch is passed on when repo_branches is set, so that git-tar
+	// can check it against the repository's .ofc.yml
+	if sdk.RepoBranchesEnabled() ||
+		sdk.MatchBranch(branchFromRef, sdk.BuildBranches()) ||
+		isStagingBranch(branchFromRef) {
+		return nil
 	}
-	return buildBranch
+
+	buildBranches := sdk.BuildBranches()
+	if len(buildBranches) == 1 {
+		return fmt.Errorf("skipping build for: %s branch, the build branch is: %s", branchFromRef, buildBranches[0])
+	}
+	return fmt.Errorf("skipping build for: %s branch, the build branches are: %s", branchFromRef, strings.Join(buildBranches, ", "))
+}
+
+// isStagingBranch is true when the branch is the staging_branch, which
+// is deployed alongside the build branch so that it can be promoted
+func isStagingBranch(branch string) bool {
+	stagingBranch := strings.TrimSpace(os.Getenv("staging_branch"))
+	return len(stagingBranch) > 0 && stagingBranch == branch
 }
 
 func filterBranchRef(branchRef string) string {
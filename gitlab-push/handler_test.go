@@ -70,43 +70,6 @@ func Test_filterBranchRef(t *testing.T) {
 		})
 	}
 }
-func Test_getBranch(t *testing.T) {
-	tests := []struct {
-		title          string
-		branchInEnv    string
-		expectedBranch string
-	}{
-		{
-			title:          "Expected format",
-			branchInEnv:    "master",
-			expectedBranch: "master",
-		},
-		{
-			title:          "Expected format with spaces",
-			branchInEnv:    " master ",
-			expectedBranch: "master",
-		},
-	}
-	for _, test := range tests {
-		t.Run(test.title, func(t *testing.T) {
-			os.Setenv("build_branch", test.branchInEnv)
-			branch := getBranch()
-			if branch != test.expectedBranch {
-				t.Errorf("Value: `%s` not found in expected cases: `%v`", branch, test.expectedBranch)
-			}
-		})
-	}
-}
-
-func Test_getBranch_unsetEnv(t *testing.T) {
-	expectedBranch := "master"
-	branch := getBranch()
-	t.Run("Test when env var build_branch is unset", func(t *testing.T) {
-		if branch != expectedBranch {
-			t.Errorf("Value: `%s` not found in expected cases: `%v`", branch, expectedBranch)
-		}
-	})
-}
 func Test_checkBranch(t *testing.T) {
 	tests := []struct {
 		title         string
@@ -154,3 +117,41 @@ func Test_checkBranch_unsetBranchInEnv(t *testing.T) {
 		}
 	})
 }
+
+func Test_checkBranch_stagingBranch(t *testing.T) {
+	os.Setenv("build_branch", "master")
+	os.Setenv("staging_branch", "staging")
+	defer os.Unsetenv("staging_branch")
+
+	if branchErr := checkBranch("refs/heads/staging"); branchErr != nil {
+		t.Errorf("Expected the staging branch to be built, got: `%s`", branchErr.Error())
+	}
+
+	if branchErr := checkBranch("refs/heads/development"); branchErr == nil {
+		t.Errorf("Expected development branch to be skipped")
+	}
+}
+
+func Test_checkBranch_buildBranches(t *testing.T) {
+	os.Setenv("build_branches", "master, release/*")
+	defer os.Unsetenv("build_branches")
+
+	if branchErr := checkBranch("refs/heads/release/1.0"); branchErr != nil {
+		t.Errorf("Expected release/1.0 to be built, got: `%s`", branchErr.Error())
+	}
+
+	want := "skipping build for: development branch, the build branches are: master, release/*"
+	if branchErr := checkBranch("refs/heads/development"); branchErr == nil || branchErr.Error() != want {
+		t.Errorf("Expected error: `%s`, got: `%v`", want, branchErr)
+	}
+}
+
+func Test_checkBranch_repoBranches(t *testing.T) {
+	os.Setenv("build_branch", "master")
+	os.Setenv("repo_branches", "true")
+	defer os.Unsetenv("repo_branches")
+
+	if branchErr := checkBranch("refs/heads/development"); branchErr != nil {
+		t.Errorf("Expected development to be passed on to git-tar, got: `%s`", branchErr.Error())
+	}
+}
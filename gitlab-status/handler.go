@@ -24,6 +24,9 @@ func Handle(req []byte) string {
 		return fmt.Sprintf("error while un-marshaling status from request: %s", statusErr.Error())
 	}
 
+	// Published for the dashboard as github-status does
+	sdk.PublishPipelineEvents(status)
+
 	token, tokenErr := sdk.ReadSecret("gitlab-api-token")
 	if tokenErr != nil {
 		return fmt.Sprintf("error while reading gitlab-api-token: %s", tokenErr.Error())
@@ -35,7 +38,7 @@ func Handle(req []byte) string {
 	}
 
 	for _, commitStatus := range status.CommitStatuses {
-		reportErr := sendReport(url, token, commitStatus.Status, commitStatus.Description, commitStatus.Context)
+		reportErr := sendReport(url, token, commitStatus.Status, commitStatus.Description, sdk.ContextName(commitStatus.Context))
 		if reportErr != nil {
 			log.Fatalf("failed to report status %v, error: %s", status, reportErr.Error())
 		}
@@ -89,7 +92,7 @@ func sendReport(URL string, token string, state string, desc string, context str
 	}
 	req.Header.Set("PRIVATE-TOKEN", token)
 
-	resp, clientErr := http.DefaultClient.Do(req)
+	resp, clientErr := sdk.HTTPClient().Do(req)
 	if clientErr != nil {
 		return fmt.Errorf("error while sending request to GitLab API: %s", clientErr.Error())
 	}
@@ -4,13 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
 
 	"github.com/openfaas/faas/gateway/metrics"
+	"github.com/openfaas/openfaas-cloud/sdk"
 )
 
 type Metrics struct {
@@ -37,7 +37,7 @@ func Handle(req []byte) string {
 		log.Fatalf("Could not convert env-var prometheus_port to int. Env-var value: %s. Error: %t", envPort, err)
 	}
 
-	metricsQuery := metrics.NewPrometheusQuery(host, port, http.DefaultClient)
+	metricsQuery := metrics.NewPrometheusQuery(host, port, sdk.HTTPClient())
 	metricsWindow := parseMetricsWindow()
 	key := fnName + "." + ns
 	// log.Printf("key: %q", key)
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// ownerBuildEnvPath is where the owners' build environments are
+// mounted, one file per owner named <owner>-build-env
+func ownerBuildEnvPath() string {
+	if val, ok := os.LookupEnv("owner_build_env_path"); ok && len(val) > 0 {
+		return val
+	}
+	return "/var/openfaas/secrets/"
+}
+
+// ownerBuildArgs gives the build args configured by the operators for
+// an owner, such as credentials for a private proxy. The file holds one
+// KEY=value per line, blank lines and lines starting with # are
+// skipped. Values are redacted from build logs and statuses.
+func ownerBuildArgs(owner string) map[string]string {
+	args := map[string]string{}
+	if len(owner) == 0 {
+		return args
+	}
+
+	name := strings.ToLower(owner) + "-build-env"
+	data, err := ioutil.ReadFile(filepath.Join(ownerBuildEnvPath(), name))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read build env %s: %s", name, err)
+		}
+		return args
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || len(key) == 0 {
+			log.Printf("Ignoring invalid line in build env %s", name)
+			continue
+		}
+
+		sdk.RegisterSecret(parts[1])
+		args["build-arg:"+key] = parts[1]
+	}
+
+	log.Printf("Using %d build args for %s", len(args), owner)
+	return args
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// defaultLogMemoryLimit is the number of bytes of log kept in memory
+// for a build before the log is spilled to disk
+const defaultLogMemoryLimit = 1024 * 1024
+
+// logMemoryLimit reads log_memory_limit in bytes
+func logMemoryLimit() int {
+	if val, err := strconv.Atoi(os.Getenv("log_memory_limit")); err == nil && val > 0 {
+		return val
+	}
+	return defaultLogMemoryLimit
+}
+
+// logStorageURL is where complete build logs are uploaded once they
+// have spilled to disk, set with log_storage_url, i.e. a bucket in
+// Minio or S3. When empty only the tail of the log is kept.
+func logStorageURL() string {
+	return strings.TrimSuffix(os.Getenv("log_storage_url"), "/")
+}
+
+// buildLog holds the log of a build in memory up to limit bytes. Past
+// the limit the whole log is written to a temporary file and only the
+// most recent lines are kept in memory to be returned to buildshiprun.
+type buildLog struct {
+	lines []string
+	size  int
+	limit int
+
+	spill   *os.File
+	dropped int
+	// url of the complete log once stored
+	url string
+
+	sync sync.Mutex
+}
+
+func newBuildLog(limit int, lines ...string) *buildLog {
+	b := &buildLog{limit: limit}
+	for _, line := range lines {
+		b.Append(line)
+	}
+	return b
+}
+
+// Append adds a line to the log with secrets redacted, since the log is
+// returned to buildshiprun and shown to users
+func (b *buildLog) Append(msg string) {
+	msg = sdk.Redact(msg)
+
+	b.sync.Lock()
+	defer b.sync.Unlock()
+
+	b.lines = append(b.lines, msg)
+	b.size += len(msg)
+
+	if b.size <= b.limit && b.spill == nil {
+		return
+	}
+
+	if b.spill == nil {
+		spill, err := ioutil.TempFile("", "buildlog")
+		if err != nil {
+			log.Printf("Unable to spill build log to disk: %s", err.Error())
+		} else {
+			b.spill = spill
+			for _, line := range b.lines[:len(b.lines)-1] {
+				fmt.Fprintln(b.spill, line)
+			}
+		}
+	}
+
+	if b.spill != nil {
+		fmt.Fprintln(b.spill, msg)
+	}
+
+	for b.size > b.limit && len(b.lines) > 1 {
+		b.size -= len(b.lines[0])
+		b.lines = b.lines[1:]
+		b.dropped++
+	}
+}
+
+// Lines returns the lines held in memory, preceded by a note of how
+// many earlier lines were left out when the log has spilled and where
+// the complete log was stored
+func (b *buildLog) Lines() []string {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+
+	if b.dropped == 0 {
+		return append([]string{}, b.lines...)
+	}
+
+	note := fmt.Sprintf("log: %d earlier lines omitted", b.dropped)
+	if len(b.url) > 0 {
+		note = fmt.Sprintf("%s, complete log: %s", note, b.url)
+	}
+	return append([]string{note}, b.lines...)
+}
+
+// Store uploads the complete log to log_storage_url as name when it has
+// spilled to disk, returning the URL of the log. Nothing is uploaded
+// for a log which fitted in memory.
+func (b *buildLog) Store(name string) (string, error) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+
+	storageURL := logStorageURL()
+	if b.spill == nil || len(storageURL) == 0 {
+		return "", nil
+	}
+
+	if _, err := b.spill.Seek(0, 0); err != nil {
+		return "", err
+	}
+
+	info, err := b.spill.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	logURL := storageURL + "/" + name
+	req, _ := http.NewRequest(http.MethodPut, logURL, b.spill)
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "text/plain")
+
+	if token, err := sdk.ReadSecret("log-storage-token"); err == nil && len(token) > 0 {
+		sdk.RegisterSecret(token)
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return "", fmt.Errorf("unexpected status code from log storage: %d", res.StatusCode)
+	}
+
+	b.url = logURL
+	return logURL, nil
+}
+
+// Close removes the spilled log
+func (b *buildLog) Close() {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+
+	if b.spill != nil {
+		b.spill.Close()
+		os.Remove(b.spill.Name())
+		b.spill = nil
+	}
+}
+
+// buildLogName is the name of the complete log in log storage, i.e.
+// alexellis/kubecon-tester/<sha>/kubecon-tester-fn1.log
+func buildLogName(record buildRecord) string {
+	return path.Join(url.PathEscape(record.Owner), url.PathEscape(record.Repo),
+		url.PathEscape(record.SHA), url.PathEscape(record.Function)+".log")
+}
+
+// imageLogName names the log of a build which was not sent by
+// buildshiprun after its image
+func imageLogName(image string) string {
+	return path.Join("images", url.PathEscape(strings.NewReplacer("/", "-", ":", "-").Replace(strings.ToLower(image)))+".log")
+}
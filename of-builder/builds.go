@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultRecentBuilds is the number of builds kept for /builds
+const defaultRecentBuilds = 100
+
+const (
+	defaultBuildsPageSize = 20
+	maxBuildsPageSize     = 100
+)
+
+// Status of a recentBuild
+const (
+	buildSucceeded = "success"
+	buildFailed    = "failure"
+)
+
+// recentBuildsLimit reads recent_builds, the number of builds kept in
+// memory for /builds. Set it to 0 to keep none.
+func recentBuildsLimit() int {
+	if val, err := strconv.Atoi(os.Getenv("recent_builds")); err == nil && val >= 0 {
+		return val
+	}
+	return defaultRecentBuilds
+}
+
+// recentBuild is the metadata, timings and log pointer kept of a build,
+// the owner, repo, sha and function are only known for builds sent by
+// buildshiprun
+type recentBuild struct {
+	ID       string `json:"id"`
+	Owner    string `json:"owner,omitempty"`
+	Repo     string `json:"repo,omitempty"`
+	SHA      string `json:"sha,omitempty"`
+	Function string `json:"function,omitempty"`
+	Image    string `json:"image,omitempty"`
+	Status   string `json:"status"`
+	// Message is the reason a build failed
+	Message string `json:"message,omitempty"`
+	Rebuild bool   `json:"rebuild,omitempty"`
+
+	Started        time.Time `json:"started"`
+	Completed      time.Time `json:"completed"`
+	ExtractSeconds float64   `json:"extractSeconds,omitempty"`
+	BuildSeconds   float64   `json:"buildSeconds,omitempty"`
+	PushSeconds    float64   `json:"pushSeconds,omitempty"`
+	ImageSize      int64     `json:"imageSize,omitempty"`
+
+	// LogURL is the complete log when it was uploaded to log storage
+	LogURL string `json:"logURL,omitempty"`
+	// LogLines is the number of lines of log returned to buildshiprun
+	LogLines int `json:"logLines"`
+}
+
+// buildFilter selects builds from /builds, zero values match any build
+type buildFilter struct {
+	Owner  string
+	Status string
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f buildFilter) match(b recentBuild) bool {
+	if len(f.Owner) > 0 && !strings.EqualFold(f.Owner, b.Owner) {
+		return false
+	}
+	if len(f.Status) > 0 && f.Status != b.Status {
+		return false
+	}
+	if !f.Since.IsZero() && b.Started.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && b.Started.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// recentBuilds keeps the most recent builds in memory, the oldest is
+// dropped once limit is reached. It is shared by concurrent builds.
+type recentBuilds struct {
+	builds []recentBuild
+	limit  int
+	mutex  sync.RWMutex
+}
+
+func newRecentBuilds(limit int) *recentBuilds {
+	return &recentBuilds{limit: limit}
+}
+
+// builds is recorded by every build and rebuild
+var builds = newRecentBuilds(recentBuildsLimit())
+
+// Add keeps a build
+func (h *recentBuilds) Add(b recentBuild) {
+	if h.limit == 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.builds = append(h.builds, b)
+	if len(h.builds) > h.limit {
+		h.builds = append([]recentBuild{}, h.builds[len(h.builds)-h.limit:]...)
+	}
+}
+
+// Get finds a build by its ID
+func (h *recentBuilds) Get(id string) (recentBuild, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for _, b := range h.builds {
+		if b.ID == id {
+			return b, true
+		}
+	}
+	return recentBuild{}, false
+}
+
+// List gives the builds matching filter, newest first
+func (h *recentBuilds) List(filter buildFilter) []recentBuild {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	matched := []recentBuild{}
+	for i := len(h.builds) - 1; i >= 0; i-- {
+		if filter.match(h.builds[i]) {
+			matched = append(matched, h.builds[i])
+		}
+	}
+	return matched
+}
+
+// newBuildID gives a random ID for a build
+func newBuildID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(id)
+}
+
+// recordRecentBuild keeps the outcome of a build for /builds from the
+// BuildResult returned by buildTar
+func recordRecentBuild(record buildRecord, rebuild bool, started time.Time, dt []byte, err error) {
+	result := BuildResult{}
+	if dt != nil {
+		json.Unmarshal(dt, &result)
+	}
+
+	b := recentBuild{
+		ID:             newBuildID(),
+		Owner:          record.Owner,
+		Repo:           record.Repo,
+		SHA:            record.SHA,
+		Function:       record.Function,
+		Image:          result.ImageName,
+		Status:         buildSucceeded,
+		Rebuild:        rebuild,
+		Started:        started,
+		Completed:      time.Now(),
+		ExtractSeconds: result.ExtractSeconds,
+		BuildSeconds:   result.BuildSeconds,
+		PushSeconds:    result.PushSeconds,
+		ImageSize:      result.ImageSize,
+		LogURL:         result.LogURL,
+		LogLines:       len(result.Log),
+	}
+
+	if err != nil {
+		b.Status = buildFailed
+		b.Message = result.Status
+		if len(b.Message) == 0 {
+			b.Message = fmt.Sprintf("unexpected failure: %s", err.Error())
+		}
+	}
+
+	builds.Add(b)
+}
+
+// buildsPage is the response of /builds
+type buildsPage struct {
+	Builds []recentBuild `json:"builds"`
+	Total  int           `json:"total"`
+	Offset int           `json:"offset"`
+	Limit  int           `json:"limit"`
+}
+
+// parseBuildsQuery reads the filter and page from the query of /builds,
+// i.e. ?owner=alexellis&status=failure&since=2020-05-01T00:00:00Z&limit=10
+func parseBuildsQuery(r *http.Request) (buildFilter, int, int, error) {
+	query := r.URL.Query()
+
+	filter := buildFilter{
+		Owner:  query.Get("owner"),
+		Status: query.Get("status"),
+	}
+
+	if len(filter.Status) > 0 && filter.Status != buildSucceeded && filter.Status != buildFailed {
+		return filter, 0, 0, fmt.Errorf("status must be %s or %s", buildSucceeded, buildFailed)
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if val := query.Get(param.name); len(val) > 0 {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				return filter, 0, 0, fmt.Errorf("%s must be an RFC3339 time: %s", param.name, err.Error())
+			}
+			*param.value = t
+		}
+	}
+
+	offset := 0
+	if val := query.Get("offset"); len(val) > 0 {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return filter, 0, 0, fmt.Errorf("offset must be a positive number")
+		}
+		offset = n
+	}
+
+	limit := defaultBuildsPageSize
+	if val := query.Get("limit"); len(val) > 0 {
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			return filter, 0, 0, fmt.Errorf("limit must be a positive number")
+		}
+		limit = n
+	}
+	if limit > maxBuildsPageSize {
+		limit = maxBuildsPageSize
+	}
+
+	return filter, offset, limit, nil
+}
+
+// buildsHandler lists the recent builds, newest first, a page at a time
+func buildsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, offset, limit, err := parseBuildsQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	matched := builds.List(filter)
+
+	page := buildsPage{
+		Builds: []recentBuild{},
+		Total:  len(matched),
+		Offset: offset,
+		Limit:  limit,
+	}
+	if offset < len(matched) {
+		end := offset + limit
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page.Builds = matched[offset:end]
+	}
+
+	pageBytes, _ := json.Marshal(page)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(pageBytes)
+}
+
+// recentBuildHandler gives a recent build by its ID
+func recentBuildHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	b, ok := builds.Get(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no recent build: %s", id), http.StatusNotFound)
+		return
+	}
+
+	buildBytes, _ := json.Marshal(b)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buildBytes)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buildStats collects the timings and size reported in the BuildResult
+// from buildkit's progress
+type buildStats struct {
+	solveStart time.Time
+
+	pushStart time.Time
+	pushEnd   time.Time
+	// layers holds the size of each layer blob uploaded, keyed by digest
+	layers map[string]int64
+	// uploads holds when each layer blob was being transferred
+	uploads map[string]interval
+	// manifest and config are the digests the image exporter wrote
+	manifest string
+	config   string
+
+	sync sync.Mutex
+}
+
+func newBuildStats() *buildStats {
+	return &buildStats{
+		solveStart: time.Now(),
+		layers:     map[string]int64{},
+		uploads:    map[string]interval{},
+	}
+}
+
+// The image exporter reports the digests it wrote as the IDs of its
+// statuses, i.e. "exporting manifest sha256:..."
+const (
+	exportingManifest = "exporting manifest "
+	exportingConfig   = "exporting config "
+)
+
+// Exported gives the digests of the manifest and config which were
+// pushed, either is empty when buildkit did not report it
+func (b *buildStats) Exported() exportedImage {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+
+	return exportedImage{Manifest: b.manifest, Config: b.config}
+}
+
+type interval struct {
+	start time.Time
+	end   time.Time
+}
+
+// status records a push status, layer uploads have the blob's digest
+// as their ID and the upload as a whole is reported with the "pushing"
+// prefix
+func (b *buildStats) status(id string, total int64, started, completed *time.Time) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+
+	if strings.HasPrefix(id, exportingManifest) {
+		b.manifest = strings.TrimPrefix(id, exportingManifest)
+	} else if strings.HasPrefix(id, exportingConfig) {
+		b.config = strings.TrimPrefix(id, exportingConfig)
+	}
+
+	if strings.HasPrefix(id, "sha256:") {
+		if total > b.layers[id] {
+			b.layers[id] = total
+		}
+		if started != nil && completed != nil && completed.After(*started) {
+			b.uploads[id] = interval{start: *started, end: *completed}
+		}
+	}
+
+	if !strings.HasPrefix(id, "pushing") || started == nil {
+		return
+	}
+
+	if b.pushStart.IsZero() || started.Before(b.pushStart) {
+		b.pushStart = *started
+	}
+	if completed != nil && completed.After(b.pushEnd) {
+		b.pushEnd = *completed
+	}
+}
+
+// Apply sets the build and push durations and the image size on the
+// result. Layers which the registry already had are not uploaded, so
+// the size is that of the layers pushed by this build. The push
+// throughput is measured over the time layers were being transferred,
+// the rest of the push is spent waiting on the registry for auth,
+// blob checks and the manifest.
+func (b *buildStats) Apply(result *BuildResult) {
+	b.sync.Lock()
+	defer b.sync.Unlock()
+
+	result.BuildSeconds = time.Since(b.solveStart).Seconds()
+
+	if !b.pushStart.IsZero() && b.pushEnd.After(b.pushStart) {
+		result.PushSeconds = b.pushEnd.Sub(b.pushStart).Seconds()
+	}
+
+	for _, size := range b.layers {
+		result.ImageSize += size
+	}
+
+	if result.PushSeconds == 0 {
+		return
+	}
+
+	transferSeconds := transferTime(b.uploads).Seconds()
+	if transferSeconds > result.PushSeconds {
+		transferSeconds = result.PushSeconds
+	}
+	if transferSeconds > 0 {
+		result.PushMBps = float64(result.ImageSize) / (1024 * 1024) / transferSeconds
+	}
+	result.RegistryWaitSeconds = result.PushSeconds - transferSeconds
+}
+
+// transferTime is the time during which at least one layer was being
+// uploaded, layers are pushed in parallel so overlaps count once
+func transferTime(uploads map[string]interval) time.Duration {
+	intervals := []interval{}
+	for _, upload := range uploads {
+		intervals = append(intervals, upload)
+	}
+
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].start.Before(intervals[j].start)
+	})
+
+	var total time.Duration
+	var current *interval
+	for i := range intervals {
+		next := intervals[i]
+		if current != nil && !next.start.After(current.end) {
+			if next.end.After(current.end) {
+				current.end = next.end
+			}
+			continue
+		}
+		if current != nil {
+			total += current.end.Sub(current.start)
+		}
+		current = &next
+	}
+	if current != nil {
+		total += current.end.Sub(current.start)
+	}
+
+	return total
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/session"
+)
+
+// cachePolicy is read from the environment so that buildkit's cache is
+// kept in check without cron jobs outside of the builder. A schedule
+// which is empty disables its task.
+type cachePolicy struct {
+	// PruneSchedule is when the cache is checked for stale records
+	PruneSchedule *cronSchedule
+	// PruneAfter is how long a record is left unused before it is stale
+	PruneAfter time.Duration
+	// WarmSchedule is when WarmImages are pulled into the cache
+	WarmSchedule *cronSchedule
+	// WarmImages are the base images of the templates, pulled after
+	// each prune and on WarmSchedule so that builds don't wait for them
+	WarmImages []string
+}
+
+// getCachePolicy reads cache_prune_schedule, cache_prune_days,
+// cache_warm_schedule and cache_warm_images, a comma-separated list of
+// images. The frontends are warmed along with the images.
+func getCachePolicy(frontends frontendConfig) cachePolicy {
+	policy := cachePolicy{
+		PruneAfter: 7 * 24 * time.Hour,
+	}
+
+	if val := strings.TrimSpace(os.Getenv("cache_prune_schedule")); len(val) > 0 {
+		schedule, err := parseCron(val)
+		if err != nil {
+			log.Printf("cache_prune_schedule: %s, cache will not be pruned", err)
+		}
+		policy.PruneSchedule = schedule
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("cache_prune_days")); err == nil && val > 0 {
+		policy.PruneAfter = time.Duration(val) * 24 * time.Hour
+	}
+
+	if val := strings.TrimSpace(os.Getenv("cache_warm_schedule")); len(val) > 0 {
+		schedule, err := parseCron(val)
+		if err != nil {
+			log.Printf("cache_warm_schedule: %s, cache will not be warmed", err)
+		}
+		policy.WarmSchedule = schedule
+	}
+
+	seen := map[string]bool{}
+	images := strings.Split(os.Getenv("cache_warm_images"), ",")
+	images = append(images, frontends.Default)
+	for _, image := range frontends.Languages {
+		images = append(images, image)
+	}
+	for _, image := range images {
+		image = strings.TrimSpace(image)
+		if len(image) > 0 && !seen[image] {
+			seen[image] = true
+			policy.WarmImages = append(policy.WarmImages, image)
+		}
+	}
+
+	return policy
+}
+
+// staleRecords gives the cache records which are not in use and were
+// last used, or created when they were never used, before cutoff
+func staleRecords(records []*client.UsageInfo, cutoff time.Time) []*client.UsageInfo {
+	stale := []*client.UsageInfo{}
+	for _, record := range records {
+		if record.InUse {
+			continue
+		}
+
+		lastUsed := record.CreatedAt
+		if record.LastUsedAt != nil {
+			lastUsed = *record.LastUsedAt
+		}
+		if lastUsed.Before(cutoff) {
+			stale = append(stale, record)
+		}
+	}
+	return stale
+}
+
+// runCacheScheduler runs the prune and warm tasks on their schedules
+// until ctx is done
+func runCacheScheduler(ctx context.Context, policy cachePolicy) error {
+	if policy.PruneSchedule == nil && policy.WarmSchedule == nil {
+		return nil
+	}
+
+	nextPrune, nextWarm := time.Time{}, time.Time{}
+	now := time.Now()
+	if policy.PruneSchedule != nil {
+		nextPrune = policy.PruneSchedule.Next(now)
+		log.Printf("Cache prune scheduled for %s", nextPrune.Format(time.RFC3339))
+	}
+	if policy.WarmSchedule != nil {
+		nextWarm = policy.WarmSchedule.Next(now)
+		log.Printf("Cache warm scheduled for %s", nextWarm.Format(time.RFC3339))
+	}
+
+	for {
+		next := nextPrune
+		if next.IsZero() || (!nextWarm.IsZero() && nextWarm.Before(next)) {
+			next = nextWarm
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		if !nextPrune.IsZero() && !now.Before(nextPrune) {
+			if pruned, err := pruneCache(ctx, policy.PruneAfter); err != nil {
+				log.Printf("Cache prune failed: %s", err)
+			} else if pruned {
+				// Pull the base images straight back in, since buildkit
+				// removes every record which is not in use
+				warmCache(ctx, policy.WarmImages)
+			}
+			nextPrune = policy.PruneSchedule.Next(now)
+		}
+		if !nextWarm.IsZero() && !now.Before(nextWarm) {
+			warmCache(ctx, policy.WarmImages)
+			nextWarm = policy.WarmSchedule.Next(now)
+		}
+	}
+}
+
+// pruneCache prunes buildkit's cache when any record has been unused
+// for longer than pruneAfter. This version of buildkit can't prune by
+// age, so every record which is not in use is removed and the base
+// images are warmed again afterwards.
+func pruneCache(ctx context.Context, pruneAfter time.Duration) (bool, error) {
+	c, err := client.New(buildkitURL, client.WithBlock())
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	records, err := c.DiskUsage(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	stale := staleRecords(records, time.Now().Add(-pruneAfter))
+	if len(stale) == 0 {
+		log.Printf("Cache prune: no records unused for %s, skipping", pruneAfter)
+		return false, nil
+	}
+
+	var staleSize int64
+	for _, record := range stale {
+		staleSize += record.Size
+	}
+
+	ch := make(chan client.UsageInfo)
+	done := make(chan struct{})
+	var count int
+	var freed int64
+	go func() {
+		for record := range ch {
+			count++
+			freed += record.Size
+		}
+		close(done)
+	}()
+
+	err = c.Prune(ctx, ch)
+	close(ch)
+	<-done
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("Cache prune: %d records (%d bytes) unused for %s, removed %d records (%d bytes)",
+		len(stale), staleSize, pruneAfter, count, freed)
+
+	return true, nil
+}
+
+// warmCache pulls each image into buildkit's cache, an image which
+// can't be pulled is logged and the rest are still pulled
+func warmCache(ctx context.Context, images []string) {
+	if len(images) == 0 {
+		return
+	}
+
+	c, err := client.New(buildkitURL, client.WithBlock())
+	if err != nil {
+		log.Printf("Cache warm failed: %s", err)
+		return
+	}
+	defer c.Close()
+
+	warmed := 0
+	for _, image := range images {
+		def, err := llb.Image(image).Marshal()
+		if err != nil {
+			log.Printf("Cache warm: %s: %s", image, err)
+			continue
+		}
+
+		solveOpt := client.SolveOpt{
+			Session: []session.Attachable{registryAuthProvider("")},
+		}
+
+		start := time.Now()
+		if err := c.Solve(ctx, def, solveOpt, nil); err != nil {
+			log.Printf("Cache warm: %s: %s", image, err)
+			continue
+		}
+
+		warmed++
+		log.Printf("Cache warm: %s pulled in %.2fs", image, time.Since(start).Seconds())
+	}
+
+	log.Printf("Cache warm: %d of %d images pulled", warmed, len(images))
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/pkg/archive"
+	"golang.org/x/sync/errgroup"
+)
+
+// smallFileLimit is the largest file which is buffered in memory and
+// written by the worker pool, larger files are streamed in order
+const smallFileLimit = 1024 * 1024
+
+// extractConfig bounds the IO used to extract a build context
+type extractConfig struct {
+	// Concurrency is the number of small files written in parallel
+	Concurrency int
+	// BytesPerSecond limits the write rate across all workers, 0 is unlimited
+	BytesPerSecond int64
+	Lchown         bool
+}
+
+// getExtractConfig reads extract_concurrency and extract_write_limit_mb
+func getExtractConfig() extractConfig {
+	cfg := extractConfig{
+		Concurrency: 4,
+		Lchown:      lchownEnabled,
+	}
+
+	if val, ok := os.LookupEnv("extract_concurrency"); ok && len(val) > 0 {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.Concurrency = n
+		}
+	}
+
+	if val, ok := os.LookupEnv("extract_write_limit_mb"); ok && len(val) > 0 {
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil && n > 0 {
+			cfg.BytesPerSecond = n * 1024 * 1024
+		}
+	}
+
+	return cfg
+}
+
+// maxLinkHops limits how many symlinks are followed to resolve a link,
+// as the kernel does with ELOOP
+const maxLinkHops = 40
+
+// extractTar unpacks the build context into dest. Small regular files
+// are written by a bounded pool of workers, symlinks and then hard links
+// are created in order once all files have been written, so that no file
+// is written through a link.
+func extractTar(r io.Reader, dest string, cfg extractConfig) error {
+	stream, err := archive.DecompressStream(r)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	limiter := newThrottle(cfg.BytesPerSecond)
+	sem := make(chan struct{}, cfg.Concurrency)
+	eg := errgroup.Group{}
+
+	symlinks := []*tar.Header{}
+	links := []*tar.Header{}
+	tr := tar.NewReader(stream)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			eg.Wait()
+			return err
+		}
+
+		target, err := safeJoin(dest, hdr.Name)
+		if err != nil {
+			eg.Wait()
+			return err
+		}
+
+		// An earlier entry may be a symlink which a later entry would be
+		// written through, i.e. "dir" -> "/etc" followed by "dir/passwd"
+		if err := checkNoSymlinks(dest, target); err != nil {
+			eg.Wait()
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)|0700); err != nil {
+				eg.Wait()
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				eg.Wait()
+				return err
+			}
+
+			if hdr.Size > smallFileLimit {
+				if err := writeFile(target, hdr, tr, limiter, cfg.Lchown); err != nil {
+					eg.Wait()
+					return err
+				}
+				continue
+			}
+
+			buf := make([]byte, hdr.Size)
+			if _, err := io.ReadFull(tr, buf); err != nil {
+				eg.Wait()
+				return err
+			}
+
+			fileHdr := hdr
+			sem <- struct{}{}
+			eg.Go(func() error {
+				defer func() { <-sem }()
+				return writeFile(target, fileHdr, bytes.NewReader(buf), limiter, cfg.Lchown)
+			})
+		case tar.TypeSymlink:
+			symlinks = append(symlinks, hdr)
+		case tar.TypeLink:
+			links = append(links, hdr)
+		}
+	}
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	for _, hdr := range symlinks {
+		target, err := safeJoin(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkNoSymlinks(dest, target); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := checkLinkname(dest, target, hdr.Linkname); err != nil {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+		if cfg.Lchown {
+			os.Lchown(target, hdr.Uid, hdr.Gid)
+		}
+	}
+
+	for _, hdr := range links {
+		target, err := safeJoin(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		source, err := safeJoin(dest, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := checkNoSymlinks(dest, target); err != nil {
+			return err
+		}
+		if err := checkNoSymlinks(dest, source); err != nil {
+			return err
+		}
+		if err := os.Link(source, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFile(target string, hdr *tar.Header, r io.Reader, limiter *throttle, lchown bool) error {
+	// No symlinks exist while files are written, but a file is still
+	// never opened through one, nor over an earlier entry
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, os.FileMode(hdr.Mode))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(&throttledWriter{w: f, t: limiter}, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if lchown {
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+// safeJoin prevents entries such as ../../etc/passwd escaping dest
+func safeJoin(dest, name string) (string, error) {
+	target := filepath.Join(dest, filepath.Clean("/"+name))
+	if target != dest && !strings.HasPrefix(target, dest+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid path in archive: %s", name)
+	}
+	return target, nil
+}
+
+// checkNoSymlinks refuses a target which is, or is under, an existing
+// symlink in dest, since writing to it would follow the link
+func checkNoSymlinks(dest, target string) error {
+	rel, err := filepath.Rel(dest, target)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+
+	current := dest
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		current = filepath.Join(current, part)
+
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("invalid path in archive, %s is written through a symlink", rel)
+		}
+	}
+	return nil
+}
+
+// checkLinkname only allows a symlink to a relative path within dest.
+// The linkname is resolved through the symlinks already created, since
+// "z" -> "." followed by "x" -> "z/../evil" is within dest lexically.
+func checkLinkname(dest, target, linkname string) error {
+	if filepath.IsAbs(linkname) {
+		return fmt.Errorf("invalid symlink in archive, %s points to an absolute path: %s", target, linkname)
+	}
+
+	hops := 0
+	if _, _, err := resolveLink(dest, filepath.Dir(target), linkname, &hops); err != nil {
+		return fmt.Errorf("invalid symlink in archive, %s points outside of the build context: %s, %s", target, linkname, err.Error())
+	}
+	return nil
+}
+
+// resolveLink walks linkname from dir one part at a time, following any
+// symlinks on the way, and fails if the path leaves dest. It also fails
+// for ".." after a part which does not exist yet, since a link created
+// later could make that part resolve to a different depth. The bool is
+// false when the path does not exist.
+func resolveLink(dest, dir, linkname string, hops *int) (string, bool, error) {
+	current := dir
+	exists := true
+
+	for _, part := range strings.Split(linkname, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if !exists {
+				return "", false, fmt.Errorf("%s does not exist", current)
+			}
+			if current == dest {
+				return "", false, fmt.Errorf("%s is above the build context", linkname)
+			}
+			current = filepath.Dir(current)
+			continue
+		}
+
+		current = filepath.Join(current, part)
+		if !exists {
+			continue
+		}
+
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			exists = false
+			continue
+		}
+		if err != nil {
+			return "", false, err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			*hops++
+			if *hops > maxLinkHops {
+				return "", false, fmt.Errorf("too many levels of symlinks")
+			}
+
+			next, err := os.Readlink(current)
+			if err != nil {
+				return "", false, err
+			}
+			if filepath.IsAbs(next) {
+				return "", false, fmt.Errorf("%s points to an absolute path", current)
+			}
+
+			current, exists, err = resolveLink(dest, filepath.Dir(current), next, hops)
+			if err != nil {
+				return "", false, err
+			}
+		}
+	}
+
+	return current, exists, nil
+}
+
+// throttle is shared between writers so that the total write rate
+// stays under bytesPerSecond
+type throttle struct {
+	bytesPerSecond int64
+	start          time.Time
+	written        int64
+	mutex          sync.Mutex
+}
+
+func newThrottle(bytesPerSecond int64) *throttle {
+	return &throttle{
+		bytesPerSecond: bytesPerSecond,
+		start:          time.Now(),
+	}
+}
+
+func (t *throttle) wait(n int) {
+	if t.bytesPerSecond <= 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	t.written += int64(n)
+	due := t.start.Add(time.Duration(float64(t.written) / float64(t.bytesPerSecond) * float64(time.Second)))
+	t.mutex.Unlock()
+
+	if delay := time.Until(due); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+type throttledWriter struct {
+	w io.Writer
+	t *throttle
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	const chunk = 32 * 1024
+
+	written := 0
+	for written < len(p) {
+		end := written + chunk
+		if end > len(p) {
+			end = len(p)
+		}
+
+		tw.t.wait(end - written)
+
+		n, err := tw.w.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
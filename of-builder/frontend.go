@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// frontendConfig maps a template language to the buildkit frontend
+// image used to build it. It is managed by the operator so that the
+// image which runs a build is never chosen by the uploaded context.
+type frontendConfig struct {
+	Default   string
+	Languages map[string]string
+}
+
+// getFrontendConfig reads default_frontend and frontends, a list of
+// language=image pairs i.e.
+// "go=docker.io/docker/dockerfile@sha256:<digest>,node12=...".
+// Images must be pinned by digest, any other entry is ignored.
+func getFrontendConfig() frontendConfig {
+	cfg := frontendConfig{
+		Default:   DefaultFrontEnd,
+		Languages: map[string]string{},
+	}
+
+	if val := strings.TrimSpace(os.Getenv("default_frontend")); len(val) > 0 {
+		if pinnedFrontend(val) {
+			cfg.Default = val
+		} else {
+			log.Printf("default_frontend %s is not pinned by digest, using %s", val, cfg.Default)
+		}
+	}
+
+	for _, pair := range strings.Split(os.Getenv("frontends"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		language := strings.ToLower(strings.TrimSpace(parts[0]))
+		image := strings.TrimSpace(parts[1])
+		if !pinnedFrontend(image) {
+			log.Printf("frontend %s for %s is not pinned by digest, ignoring", image, language)
+			continue
+		}
+
+		cfg.Languages[language] = image
+	}
+
+	return cfg
+}
+
+// pinnedFrontend checks that the image is referenced by its digest so
+// that a tag being moved cannot change the builder
+func pinnedFrontend(image string) bool {
+	return strings.Contains(image, "@sha256:")
+}
+
+// Frontend returns the frontend for the language, or the default
+func (f frontendConfig) Frontend(language string) string {
+	if image, ok := f.Languages[strings.ToLower(language)]; ok {
+		return image
+	}
+	return f.Default
+}
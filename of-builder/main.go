@@ -11,16 +11,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/alexellis/hmac"
-	"github.com/docker/docker/pkg/archive"
 	"github.com/gorilla/mux"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/session"
-	"github.com/moby/buildkit/session/auth/authprovider"
 	"github.com/moby/buildkit/util/appcontext"
 	"github.com/openfaas/openfaas-cloud/sdk"
 	"github.com/pkg/errors"
@@ -37,11 +35,14 @@ var (
 	lchownEnabled bool
 	buildkitURL   string
 	buildArgs     = map[string]string{}
+	frontends     frontendConfig
 )
 
 type buildConfig struct {
-	Ref       string            `json:"ref"`
+	Ref string `json:"ref"`
+	// Frontend is ignored, the frontend is picked by Language
 	Frontend  string            `json:"frontend,omitempty"`
+	Language  string            `json:"language,omitempty"`
 	BuildArgs map[string]string `json:"buildArgs,omitempty"`
 }
 
@@ -60,6 +61,8 @@ func main() {
 		buildkitURL = val
 	}
 
+	frontends = getFrontendConfig()
+
 	if val, ok := os.LookupEnv("http_proxy"); ok {
 		buildArgs["build-arg:http_proxy"] = val
 	}
@@ -74,7 +77,11 @@ func main() {
 
 	router := mux.NewRouter().StrictSlash(true)
 	router.HandleFunc("/build", buildHandler)
+	router.HandleFunc("/rebuild", rebuildHandler)
+	router.HandleFunc("/builds", buildsHandler)
+	router.HandleFunc("/builds/{id}", recentBuildHandler)
 	router.HandleFunc("/healthz", healthzHandler)
+	router.HandleFunc("/metrics", makeMetricsHandler(pushes))
 
 	addr := "0.0.0.0:8080"
 	log.Printf("of-builder serving traffic on: %s\n", addr)
@@ -95,6 +102,10 @@ func main() {
 		return server.ListenAndServe()
 	})
 
+	eg.Go(func() error {
+		return runCacheScheduler(ctx, getCachePolicy(frontends))
+	})
+
 	if err := eg.Wait(); err != nil {
 		panic(err)
 	}
@@ -111,7 +122,7 @@ func buildHandler(w http.ResponseWriter, r *http.Request) {
 			buildResult := BuildResult{
 				ImageName: "",
 				Log:       nil,
-				Status:    fmt.Sprintf("unexpected failure: %s", err.Error()),
+				Status:    sdk.Redact(fmt.Sprintf("unexpected failure: %s", err.Error())),
 			}
 			dt, _ = json.Marshal(buildResult)
 		}
@@ -132,39 +143,80 @@ func build(w http.ResponseWriter, r *http.Request, buildArgs map[string]string)
 
 	defer r.Body.Close()
 
-	tmpdir, err := ioutil.TempDir("", "buildctx")
-	if err != nil {
-		return nil, err
-	}
-
 	tarBytes, bodyErr := ioutil.ReadAll(r.Body)
 	if bodyErr != nil {
 		return nil, bodyErr
 	}
 
-	enforceHMAC := true
-	if val, ok := os.LookupEnv("disable_hmac"); ok && val == "true" {
-		enforceHMAC = false
-	}
-
-	if enforceHMAC {
+	if hmacEnforced() {
 		hmacErr := validateRequest(&tarBytes, r)
 		if hmacErr != nil {
 			return nil, hmacErr
 		}
+
+		if hasBuildMetadata(r.Header) {
+			payloadSecret, _ := sdk.ReadSecret("payload-secret")
+			if metadataErr := validBuildMetadata(r.Header, tarBytes, payloadSecret); metadataErr != nil {
+				return nil, metadataErr
+			}
+		}
 	}
 
-	defer os.RemoveAll(tmpdir)
+	record, recorded := buildRecordFromHeaders(r.Header)
+	logName := ""
+	if recorded {
+		logName = buildLogName(record)
+	}
 
-	opts := archive.TarOptions{
-		NoLchown: !lchownEnabled,
+	started := time.Now()
+	trace := newBuildTrace(r.Header)
+	dt, err := buildTar(tarBytes, buildArgs, r.Header.Get(buildOwnerHeader), logName, trace)
+	go trace.Export()
+
+	recordRecentBuild(record, false, started, dt, err)
+
+	if err == nil {
+		if recorded {
+			if saveErr := saveBuild(record, tarBytes); saveErr != nil {
+				log.Printf("Unable to record build for rebuild: %s", saveErr.Error())
+			}
+		}
 	}
 
-	if err := archive.Untar(bytes.NewReader(tarBytes), tmpdir, &opts); err != nil {
+	return dt, err
+}
+
+// buildTar builds and pushes the image described by a build context,
+// recording a span for each phase of the build in trace. A log which
+// spills to disk is uploaded to log storage as logName. The owner's
+// own registry credentials and build env are used when they have been
+// mounted.
+func buildTar(tarBytes []byte, buildArgs map[string]string, owner string, logName string, trace *buildTrace) (dt []byte, err error) {
+	buildSpan := trace.Start("build", nil)
+	defer func() {
+		buildSpan.End(err)
+	}()
+
+	tmpdir, err := ioutil.TempDir("", "buildctx")
+	if err != nil {
 		return nil, err
 	}
 
-	dt, err := ioutil.ReadFile(filepath.Join(tmpdir, ConfigFileName))
+	defer os.RemoveAll(tmpdir)
+
+	untarSpan := trace.Start("untar", buildSpan)
+	untarSpan.SetAttribute("size", strconv.Itoa(len(tarBytes)))
+
+	extractStart := time.Now()
+	if err := extractTar(bytes.NewReader(tarBytes), tmpdir, getExtractConfig()); err != nil {
+		untarSpan.End(err)
+		return nil, err
+	}
+	untarSpan.End(nil)
+	extractSeconds := time.Since(extractStart).Seconds()
+	log.Printf("Extracted build context in %.2fs", extractSeconds)
+
+	dt, err = ioutil.ReadFile(filepath.Join(tmpdir, ConfigFileName))
 	if err != nil {
 		return nil, err
 	}
@@ -178,8 +230,12 @@ func build(w http.ResponseWriter, r *http.Request, buildArgs map[string]string)
 		return nil, errors.Errorf("no target reference to push")
 	}
 
-	if cfg.Frontend == "" {
-		cfg.Frontend = DefaultFrontEnd
+	buildSpan.SetAttribute("image", cfg.Ref)
+	buildSpan.SetAttribute("language", cfg.Language)
+
+	frontend := frontends.Frontend(cfg.Language)
+	if len(cfg.Frontend) > 0 && cfg.Frontend != frontend {
+		log.Printf("Ignoring frontend %s from build config, using %s", cfg.Frontend, frontend)
 	}
 
 	insecure := "false"
@@ -188,7 +244,7 @@ func build(w http.ResponseWriter, r *http.Request, buildArgs map[string]string)
 	}
 
 	frontendAttrs := map[string]string{
-		"source": cfg.Frontend,
+		"source": frontend,
 	}
 
 	for k, v := range buildArgs {
@@ -199,6 +255,12 @@ func build(w http.ResponseWriter, r *http.Request, buildArgs map[string]string)
 		frontendAttrs[fmt.Sprintf("build-arg:%s", k)] = v
 	}
 
+	// The owner's build env is set by the operators, so it takes
+	// precedence over the args in the build config
+	for k, v := range ownerBuildArgs(owner) {
+		frontendAttrs[k] = v
+	}
+
 	contextDir := filepath.Join(tmpdir, "context")
 	solveOpt := client.SolveOpt{
 		Exporter: "image",
@@ -213,84 +275,86 @@ func build(w http.ResponseWriter, r *http.Request, buildArgs map[string]string)
 		Frontend:      "dockerfile.v0",
 		FrontendAttrs: frontendAttrs,
 		// ~/.docker/config.json could be provided as Kube or Swarm's secret
-		Session: []session.Attachable{authprovider.NewDockerAuthProvider()},
+		Session: []session.Attachable{registryAuthProvider(owner)},
 	}
 
 	if insecure == "true" {
 		solveOpt.ExporterAttrs["registry.insecure"] = insecure
 	}
 
-	c, err := client.New(buildkitURL, client.WithBlock())
-	if err != nil {
-		return nil, err
+	build := newBuildLog(logMemoryLimit(), fmt.Sprintf("extract: %.2fs", extractSeconds))
+	defer build.Close()
+
+	if len(logName) == 0 {
+		logName = imageLogName(cfg.Ref)
 	}
 
-	ch := make(chan *client.SolveStatus)
-	eg, ctx := errgroup.WithContext(context.Background())
-	eg.Go(func() error {
-		return c.Solve(ctx, nil, solveOpt, ch)
-	})
+	stats, solveErr := solveWithRetry(solveOpt, frontend, trace, buildSpan, build, getRetryConfig())
 
-	build := buildLog{
-		Line: []string{},
-		Sync: &sync.Mutex{},
+	logURL, storeErr := build.Store(logName)
+	if storeErr != nil {
+		log.Printf("Unable to store build log %s: %s", logName, storeErr.Error())
 	}
 
-	eg.Go(func() error {
-		for s := range ch {
-			for _, v := range s.Vertexes {
-				var msg string
-				if v.Completed != nil {
-					msg = fmt.Sprintf("v: %s %s %.2fs", v.Started.Format(time.RFC3339), v.Name, v.Completed.Sub(*v.Started).Seconds())
-				} else {
-					var startedTime time.Time
-					if v.Started != nil {
-						startedTime = *(v.Started)
-					} else {
-						startedTime = time.Now()
-					}
-					startedVal := startedTime.Format(time.RFC3339)
-					msg = fmt.Sprintf("v: %s %v", startedVal, v.Name)
-				}
-				build.Append(msg)
-				fmt.Printf("%s\n", msg)
+	if err := solveErr; err != nil {
 
-			}
-			for _, s := range s.Statuses {
-				msg := fmt.Sprintf("s: %s %s %d", s.Timestamp.Format(time.RFC3339), s.ID, s.Current)
-				build.Append(msg)
+		buildResult := BuildResult{
+			ImageName:      cfg.Ref,
+			Log:            build.Lines(),
+			LogURL:         logURL,
+			Status:         sdk.Redact(fmt.Sprintf("failure: %s", err.Error())),
+			ExtractSeconds: extractSeconds,
+		}
+		stats.Apply(&buildResult)
 
-				fmt.Printf("status: %s %s %d\n", s.Vertex, s.ID, s.Current)
-			}
-			for _, l := range s.Logs {
+		bytesOut, _ := json.Marshal(buildResult)
+		return bytesOut, err
+	}
 
-				msg := fmt.Sprintf("l: %s %s", l.Timestamp.Format(time.RFC3339), l.Data)
-				build.Append(msg)
+	buildResult := BuildResult{
+		ImageName:      cfg.Ref,
+		Log:            build.Lines(),
+		LogURL:         logURL,
+		Status:         "success",
+		ExtractSeconds: extractSeconds,
+	}
+	stats.Apply(&buildResult)
 
-				fmt.Printf("log: %s\n%s\n", l.Vertex, l.Data)
-			}
+	if verifyPushEnabled() {
+		verifySpan := trace.Start("verify", buildSpan)
+		digest, verifyErr := verifyPush(strings.ToLower(cfg.Ref), stats.Exported(), owner, insecure == "true")
+		verifySpan.End(verifyErr)
 
-		}
-		return nil
-	})
+		if verifyErr != nil {
+			msg := fmt.Sprintf("verify: %s", verifyErr.Error())
+			build.Append(msg)
+			log.Println(msg)
 
-	if err := eg.Wait(); err != nil {
+			buildResult.Log = build.Lines()
+			buildResult.Status = sdk.Redact(fmt.Sprintf("failure: %s", msg))
 
-		buildResult := BuildResult{
-			ImageName: cfg.Ref,
-			Log:       build.Line,
-			Status:    fmt.Sprintf("failure: %s", err.Error()),
+			bytesOut, _ := json.Marshal(buildResult)
+			return bytesOut, verifyErr
 		}
 
-		bytesOut, _ := json.Marshal(buildResult)
-		return bytesOut, err
+		buildResult.Digest = digest
+		log.Printf("Verified %s at %s", cfg.Ref, digest)
 	}
 
-	buildResult := BuildResult{
-		ImageName: cfg.Ref,
-		Log:       build.Line,
-		Status:    "success",
+	if uri := scannerURL(); len(uri) > 0 {
+		scanSpan := trace.Start("scan", buildSpan)
+		report, scanErr := scanImage(uri, strings.ToLower(cfg.Ref), insecure == "true", scannerTimeout())
+		scanSpan.End(scanErr)
+
+		if scanErr != nil {
+			log.Printf("Unable to scan %s: %s", cfg.Ref, scanErr.Error())
+		} else {
+			buildResult.Scan = report
+			log.Printf("Scanned %s: %d packages, vulnerabilities: %v", cfg.Ref, report.SBOMPackages, report.Vulnerabilities)
+		}
 	}
+	pushes.Add(cfg.Ref, buildResult)
+	log.Printf("Built %s in %.2fs, pushed %d bytes in %.2fs at %.1fMB/s, waited %.2fs on the registry", cfg.Ref, buildResult.BuildSeconds, buildResult.ImageSize, buildResult.PushSeconds, buildResult.PushMBps, buildResult.RegistryWaitSeconds)
 
 	bytesOut, _ := json.Marshal(buildResult)
 
@@ -300,22 +364,31 @@ func build(w http.ResponseWriter, r *http.Request, buildArgs map[string]string)
 // BuildResult represents a successful Docker build and
 // push operation to a remote registry
 type BuildResult struct {
-	Log       []string `json:"log"`
-	ImageName string   `json:"imageName"`
-	Status    string   `json:"status"`
-}
-
-type buildLog struct {
-	Line []string
-	Sync *sync.Mutex
+	Log            []string `json:"log"`
+	ImageName      string   `json:"imageName"`
+	Status         string   `json:"status"`
+	ExtractSeconds float64  `json:"extractSeconds,omitempty"`
+	BuildSeconds   float64  `json:"buildSeconds,omitempty"`
+	PushSeconds    float64  `json:"pushSeconds,omitempty"`
+	ImageSize      int64    `json:"imageSize,omitempty"`
+	// PushMBps is the upload throughput to the registry
+	PushMBps float64 `json:"pushMBps,omitempty"`
+	// RegistryWaitSeconds is the part of the push spent waiting on the
+	// registry rather than uploading layers
+	RegistryWaitSeconds float64 `json:"registryWaitSeconds,omitempty"`
+	// Digest is the manifest read back from the registry after the push
+	Digest string `json:"digest,omitempty"`
+	// LogURL is the complete log when it was too large to be returned
+	LogURL string `json:"logURL,omitempty"`
+	// Scan is the SBOM and vulnerability summary from scanner_url
+	Scan *ScanReport `json:"scan,omitempty"`
 }
 
-func (b *buildLog) Append(msg string) {
-	b.Sync.Lock()
-	defer b.Sync.Unlock()
-
-	b.Line = append(b.Line, msg)
-
+func hmacEnforced() bool {
+	if val, ok := os.LookupEnv("disable_hmac"); ok && val == "true" {
+		return false
+	}
+	return true
 }
 
 func validateRequest(req *[]byte, r *http.Request) (err error) {
@@ -324,6 +397,7 @@ func validateRequest(req *[]byte, r *http.Request) (err error) {
 	if err != nil {
 		return fmt.Errorf("couldn't get payload-secret: %t", err)
 	}
+	sdk.RegisterSecret(payloadSecret)
 
 	xCloudSignature := r.Header.Get(sdk.CloudSignatureHeader)
 
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// registryPushes is the push activity to a registry since the builder
+// started
+type registryPushes struct {
+	Pushes          int64
+	Bytes           int64
+	PushSeconds     float64
+	TransferSeconds float64
+	WaitSeconds     float64
+	// LastMBps is the throughput of the most recent push
+	LastMBps float64
+}
+
+// pushMetrics aggregates push throughput by registry, it is shared by
+// concurrent builds
+type pushMetrics struct {
+	registries map[string]*registryPushes
+	mutex      sync.RWMutex
+}
+
+func newPushMetrics() *pushMetrics {
+	return &pushMetrics{
+		registries: map[string]*registryPushes{},
+	}
+}
+
+// pushes is recorded by every build
+var pushes = newPushMetrics()
+
+// Add records the push of a build, builds which did not push are
+// ignored
+func (m *pushMetrics) Add(image string, result BuildResult) {
+	if result.PushSeconds <= 0 {
+		return
+	}
+
+	registry := registryHost(image)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r, ok := m.registries[registry]
+	if !ok {
+		r = &registryPushes{}
+		m.registries[registry] = r
+	}
+
+	r.Pushes++
+	r.Bytes += result.ImageSize
+	r.PushSeconds += result.PushSeconds
+	r.WaitSeconds += result.RegistryWaitSeconds
+	r.TransferSeconds += result.PushSeconds - result.RegistryWaitSeconds
+	r.LastMBps = result.PushMBps
+}
+
+// snapshot returns a copy of the counters keyed by registry
+func (m *pushMetrics) snapshot() map[string]registryPushes {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	copied := map[string]registryPushes{}
+	for registry, r := range m.registries {
+		copied[registry] = *r
+	}
+	return copied
+}
+
+// registryHost is the registry of an image reference, images without
+// a registry are pulled from the Docker Hub
+func registryHost(image string) string {
+	index := strings.Index(image, "/")
+	if index == -1 {
+		return "docker.io"
+	}
+
+	host := image[:index]
+	if strings.ContainsAny(host, ".:") || host == "localhost" {
+		return host
+	}
+	return "docker.io"
+}
+
+// makeMetricsHandler exposes the push counters in the Prometheus text
+// format. Throughput over a window is given by
+// rate(of_builder_push_bytes_total) / rate(of_builder_push_transfer_seconds_total)
+func makeMetricsHandler(m *pushMetrics) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		registries := m.snapshot()
+		names := []string{}
+		for name := range registries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		write := func(name, kind, help string, value func(registryPushes) string) {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+			for _, registry := range names {
+				fmt.Fprintf(w, "%s{registry=%q} %s\n", name, registry, value(registries[registry]))
+			}
+		}
+
+		write("of_builder_pushes_total", "counter", "Images pushed to the registry.",
+			func(r registryPushes) string { return fmt.Sprintf("%d", r.Pushes) })
+		write("of_builder_push_bytes_total", "counter", "Bytes of layers uploaded to the registry.",
+			func(r registryPushes) string { return fmt.Sprintf("%d", r.Bytes) })
+		write("of_builder_push_seconds_total", "counter", "Time spent pushing images to the registry.",
+			func(r registryPushes) string { return fmt.Sprintf("%g", r.PushSeconds) })
+		write("of_builder_push_transfer_seconds_total", "counter", "Time spent uploading layers to the registry.",
+			func(r registryPushes) string { return fmt.Sprintf("%g", r.TransferSeconds) })
+		write("of_builder_registry_wait_seconds_total", "counter", "Time spent in pushes waiting on the registry rather than uploading.",
+			func(r registryPushes) string { return fmt.Sprintf("%g", r.WaitSeconds) })
+		write("of_builder_push_throughput_mbps", "gauge", "Upload throughput of the most recent push in MB/s.",
+			func(r registryPushes) string { return fmt.Sprintf("%g", r.LastMBps) })
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexellis/hmac"
+)
+
+// Headers sent by buildshiprun to identify a build, these match the
+// constants in the sdk
+const (
+	buildOwnerHeader    = "X-Build-Owner"
+	buildRepoHeader     = "X-Build-Repo"
+	buildSHAHeader      = "X-Build-SHA"
+	buildFunctionHeader = "X-Build-Function"
+	// buildSignatureHeader covers the headers above and the digest of
+	// the build context, see sdk.SignBuildMetadata
+	buildSignatureHeader = "X-Build-Signature"
+)
+
+const (
+	buildContextFile = "context.tar"
+	buildRecordFile  = "build.json"
+)
+
+// buildRecord is the metadata stored alongside the build context of
+// a successful build
+type buildRecord struct {
+	Owner    string    `json:"owner"`
+	Repo     string    `json:"repo"`
+	SHA      string    `json:"sha"`
+	Function string    `json:"function"`
+	Built    time.Time `json:"built"`
+}
+
+// rebuildRequest selects the recorded builds to repeat, when Function
+// is empty every function built for the commit is rebuilt
+type rebuildRequest struct {
+	Owner    string `json:"owner"`
+	Repo     string `json:"repo"`
+	SHA      string `json:"sha"`
+	Function string `json:"function,omitempty"`
+}
+
+// buildHistoryPath is where build contexts are kept for /rebuild, set
+// with build_history_path. Builds are not recorded when it is empty.
+func buildHistoryPath() string {
+	return os.Getenv("build_history_path")
+}
+
+// buildHistoryMaxAge reads build_history_days, how long a build context
+// is kept for (default 14 days), 0 keeps them until they are removed
+func buildHistoryMaxAge() time.Duration {
+	days := 14
+	if val, ok := os.LookupEnv("build_history_days"); ok && len(val) > 0 {
+		if parsed, err := strconv.Atoi(val); err == nil && parsed >= 0 {
+			days = parsed
+		} else {
+			log.Printf("invalid build_history_days %q, using %d", val, days)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// validBuildMetadata checks the signature of the build headers, which
+// is made over the same message as sdk.BuildMetadataMessage. The headers
+// pick where the build context is kept, so they must be signed along
+// with the body, i.e. by buildshiprun.
+func validBuildMetadata(header http.Header, buildContext []byte, payloadSecret string) error {
+	digest := sha256.Sum256(buildContext)
+	message := strings.Join([]string{
+		header.Get(buildOwnerHeader),
+		header.Get(buildRepoHeader),
+		header.Get(buildSHAHeader),
+		header.Get(buildFunctionHeader),
+		hex.EncodeToString(digest[:]),
+	}, "\n")
+
+	if err := hmac.Validate([]byte(message), header.Get(buildSignatureHeader), payloadSecret); err != nil {
+		return fmt.Errorf("unable to validate %s: %s", buildSignatureHeader, err.Error())
+	}
+	return nil
+}
+
+// hasBuildMetadata is true when any of the build headers was sent
+func hasBuildMetadata(header http.Header) bool {
+	for _, name := range []string{buildOwnerHeader, buildRepoHeader, buildSHAHeader, buildFunctionHeader} {
+		if len(header.Get(name)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func buildRecordFromHeaders(header http.Header) (buildRecord, bool) {
+	record := buildRecord{
+		Owner:    header.Get(buildOwnerHeader),
+		Repo:     header.Get(buildRepoHeader),
+		SHA:      header.Get(buildSHAHeader),
+		Function: header.Get(buildFunctionHeader),
+		Built:    time.Now(),
+	}
+
+	if len(record.Owner) == 0 || len(record.Repo) == 0 || len(record.SHA) == 0 || len(record.Function) == 0 {
+		return record, false
+	}
+
+	return record, true
+}
+
+// commitPath gives the directory holding the builds for a commit,
+// i.e. <build_history_path>/alexellis/kubecon-tester/<sha>
+func commitPath(base, owner, repo, sha string) (string, error) {
+	for _, part := range []string{owner, repo, sha} {
+		if len(part) == 0 || strings.ContainsAny(part, `/\`) || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid build path component: %q", part)
+		}
+	}
+
+	return safeJoin(base, filepath.Join(owner, repo, sha))
+}
+
+// saveBuild stores the context and metadata of a successful build
+func saveBuild(record buildRecord, tarBytes []byte) error {
+	base := buildHistoryPath()
+	if len(base) == 0 {
+		return nil
+	}
+
+	dir, err := commitPath(base, record.Owner, record.Repo, record.SHA)
+	if err != nil {
+		return err
+	}
+
+	dir, err = safeJoin(dir, record.Function)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, buildContextFile), tarBytes, 0600); err != nil {
+		return err
+	}
+
+	recordBytes, _ := json.Marshal(record)
+	if err := ioutil.WriteFile(filepath.Join(dir, buildRecordFile), recordBytes, 0600); err != nil {
+		return err
+	}
+
+	if maxAge := buildHistoryMaxAge(); maxAge > 0 {
+		if pruned, err := pruneBuildHistory(base, time.Now().Add(-maxAge)); err != nil {
+			log.Printf("Unable to prune build history: %s", err.Error())
+		} else if pruned > 0 {
+			log.Printf("Pruned %d builds older than %s from build history", pruned, maxAge)
+		}
+	}
+
+	return nil
+}
+
+// pruneBuildHistory removes the builds recorded before the cutoff, then
+// the commit, repo and owner directories which are left empty. The time
+// in build.json is used, or the time the context was written when it
+// can't be read.
+func pruneBuildHistory(base string, cutoff time.Time) (int, error) {
+	builds, err := filepath.Glob(filepath.Join(base, "*", "*", "*", "*", buildContextFile))
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, contextPath := range builds {
+		dir := filepath.Dir(contextPath)
+
+		built := time.Time{}
+		record := buildRecord{}
+		if recordBytes, err := ioutil.ReadFile(filepath.Join(dir, buildRecordFile)); err == nil && json.Unmarshal(recordBytes, &record) == nil {
+			built = record.Built
+		}
+		if built.IsZero() {
+			info, err := os.Stat(contextPath)
+			if err != nil {
+				continue
+			}
+			built = info.ModTime()
+		}
+
+		if !built.Before(cutoff) {
+			continue
+		}
+
+		if err := os.RemoveAll(dir); err != nil {
+			return pruned, err
+		}
+		pruned++
+
+		// Remove the sha, repo and owner directories once they are empty
+		for parent := filepath.Dir(dir); parent != filepath.Clean(base); parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+	}
+
+	return pruned, nil
+}
+
+// findBuilds returns the directories of the recorded builds which
+// match the request
+func findBuilds(base string, req rebuildRequest) ([]string, error) {
+	dir, err := commitPath(base, req.Owner, req.Repo, req.SHA)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Function) > 0 {
+		fnDir, err := safeJoin(dir, req.Function)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(filepath.Join(fnDir, buildContextFile)); err != nil {
+			return nil, err
+		}
+		return []string{fnDir}, nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	builds := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			builds = append(builds, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return builds, nil
+}
+
+// rebuildHandler repeats the builds recorded for an owner/repo/sha
+// using the original build context, so that a build can be re-run
+// without git-tar uploading it again. The response is a JSON array of
+// build results, one per function.
+func rebuildHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	base := buildHistoryPath()
+	if len(base) == 0 {
+		http.Error(w, "rebuild is not enabled, set build_history_path", http.StatusNotImplemented)
+		return
+	}
+
+	if r.Body == nil {
+		http.Error(w, "a body is required to rebuild a function", http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if hmacEnforced() {
+		if hmacErr := validateRequest(&body, r); hmacErr != nil {
+			http.Error(w, hmacErr.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	req := rebuildRequest{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	builds, err := findBuilds(base, req)
+	if err != nil || len(builds) == 0 {
+		http.Error(w, fmt.Sprintf("no recorded build for %s/%s@%s", req.Owner, req.Repo, req.SHA), http.StatusNotFound)
+		return
+	}
+
+	results := []json.RawMessage{}
+	statusCode := http.StatusOK
+
+	for _, dir := range builds {
+		tarBytes, err := ioutil.ReadFile(filepath.Join(dir, buildContextFile))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Rebuilding %s/%s@%s %s", req.Owner, req.Repo, req.SHA, filepath.Base(dir))
+
+		started := time.Now()
+		trace := newBuildTrace(r.Header)
+		record := buildRecord{Owner: req.Owner, Repo: req.Repo, SHA: req.SHA, Function: filepath.Base(dir)}
+		dt, err := buildTar(tarBytes, buildArgs, req.Owner, buildLogName(record), trace)
+		go trace.Export()
+
+		recordRecentBuild(record, true, started, dt, err)
+
+		if err != nil {
+			statusCode = http.StatusInternalServerError
+			if dt == nil {
+				dt, _ = json.Marshal(BuildResult{
+					Status: fmt.Sprintf("unexpected failure: %s", err.Error()),
+				})
+			}
+		}
+
+		results = append(results, dt)
+	}
+
+	resultsBytes, _ := json.Marshal(results)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(resultsBytes)
+}
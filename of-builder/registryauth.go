@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth"
+	"github.com/moby/buildkit/session/auth/authprovider"
+	"github.com/openfaas/openfaas-cloud/sdk"
+	"google.golang.org/grpc"
+)
+
+// ownerRegistryAuthPath is where the owners' registry credentials are
+// mounted, one Docker config.json per owner named <owner>-registry-auth
+func ownerRegistryAuthPath() string {
+	if val, ok := os.LookupEnv("owner_registry_auth_path"); ok && len(val) > 0 {
+		return val
+	}
+	return "/var/openfaas/secrets/"
+}
+
+// registryAuthProvider gives the credentials used to pull base images
+// and push the function's image. An owner who brings their own
+// registry has their config.json used in place of the platform's, so
+// that the platform's credentials are never sent to their registry.
+func registryAuthProvider(owner string) session.Attachable {
+	cfg, ok := ownerRegistryConfig(owner)
+	if !ok {
+		return authprovider.NewDockerAuthProvider()
+	}
+
+	log.Printf("Using registry credentials for %s", owner)
+	return &configAuthProvider{config: cfg}
+}
+
+// registryConfig gives the credentials the image is pushed with, the
+// owner's or the platform's ~/.docker/config.json
+func registryConfig(owner string) *configfile.ConfigFile {
+	if cfg, ok := ownerRegistryConfig(owner); ok {
+		return cfg
+	}
+	return config.LoadDefaultConfigFile(ioutil.Discard)
+}
+
+// ownerRegistryConfig reads the owner's config.json, it is false when
+// the owner has not mounted their own credentials
+func ownerRegistryConfig(owner string) (*configfile.ConfigFile, bool) {
+	if len(owner) == 0 {
+		return nil, false
+	}
+
+	name := strings.ToLower(owner) + "-registry-auth"
+	data, err := ioutil.ReadFile(filepath.Join(ownerRegistryAuthPath(), name))
+	if err != nil {
+		return nil, false
+	}
+
+	cfg, err := config.LoadFromReader(bytes.NewReader(data))
+	if err != nil {
+		// Fail closed, the push is refused by the owner's registry
+		log.Printf("Invalid registry credentials in %s: %s", name, err)
+		cfg = configfile.New(name)
+	}
+
+	// Keep the owner's credentials out of build logs and statuses
+	for _, ac := range cfg.AuthConfigs {
+		sdk.RegisterSecret(ac.Password)
+		sdk.RegisterSecret(ac.Auth)
+		sdk.RegisterSecret(ac.IdentityToken)
+		sdk.RegisterSecret(ac.RegistryToken)
+	}
+
+	// Only the credentials in the file are used, a credential helper
+	// would read the builder's own store
+	cfg.CredentialsStore = ""
+	cfg.CredentialHelpers = nil
+
+	return cfg, true
+}
+
+// configAuthProvider serves credentials from a config.json which was
+// not loaded from ~/.docker, following buildkit's authprovider
+type configAuthProvider struct {
+	config *configfile.ConfigFile
+}
+
+func (ap *configAuthProvider) Register(server *grpc.Server) {
+	auth.RegisterAuthServer(server, ap)
+}
+
+func (ap *configAuthProvider) Credentials(ctx context.Context, req *auth.CredentialsRequest) (*auth.CredentialsResponse, error) {
+	if req.Host == "registry-1.docker.io" {
+		req.Host = "https://index.docker.io/v1/"
+	}
+	ac, err := ap.config.GetAuthConfig(req.Host)
+	if err != nil {
+		return nil, err
+	}
+	res := &auth.CredentialsResponse{}
+	if ac.IdentityToken != "" {
+		res.Secret = ac.IdentityToken
+	} else {
+		res.Username = ac.Username
+		res.Secret = ac.Password
+	}
+	return res, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryConfig bounds how often a build is resubmitted when buildkitd
+// restarts during the solve
+type retryConfig struct {
+	Attempts int
+	Delay    time.Duration
+}
+
+// getRetryConfig reads solve_retries, the number of times a build is
+// resubmitted (default 2, 0 disables) and solve_retry_delay (default 2s)
+func getRetryConfig() retryConfig {
+	cfg := retryConfig{
+		Attempts: 2,
+		Delay:    2 * time.Second,
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("solve_retries")); err == nil && val >= 0 {
+		cfg.Attempts = val
+	}
+	if val, err := time.ParseDuration(os.Getenv("solve_retry_delay")); err == nil && val >= 0 {
+		cfg.Delay = val
+	}
+
+	return cfg
+}
+
+// workerRestartMessages are returned by gRPC when the connection to
+// buildkitd drops, the status code is lost when buildkit wraps them
+var workerRestartMessages = []string{
+	"transport is closing",
+	"connection reset by peer",
+	"connection refused",
+	"error reading from server: EOF",
+	"rpc error: code = Unavailable",
+}
+
+// isWorkerRestart tells a dropped connection to buildkitd apart from a
+// failing build, which is not retried
+func isWorkerRestart(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if s, ok := status.FromError(errors.Cause(err)); ok && s.Code() == codes.Unavailable {
+		return true
+	}
+
+	msg := err.Error()
+	for _, restart := range workerRestartMessages {
+		if strings.Contains(msg, restart) {
+			return true
+		}
+	}
+	return false
+}
+
+// solveWithRetry resubmits the solve when buildkitd restarts, the steps
+// which completed are in its cache, so the retry picks up where the
+// build left off. The stats are of the last attempt.
+func solveWithRetry(solveOpt client.SolveOpt, frontend string, trace *buildTrace, buildSpan *traceSpan, build *buildLog, cfg retryConfig) (*buildStats, error) {
+	for attempt := 0; ; attempt++ {
+		stats, err := solve(solveOpt, frontend, trace, buildSpan, build, attempt)
+		if err == nil || attempt >= cfg.Attempts || !isWorkerRestart(err) {
+			return stats, err
+		}
+
+		msg := fmt.Sprintf("retry: buildkit restarted, resubmitting %d of %d in %s: %s", attempt+1, cfg.Attempts, cfg.Delay, err.Error())
+		build.Append(msg)
+		log.Println(msg)
+
+		time.Sleep(cfg.Delay)
+	}
+}
+
+// solve runs a single attempt of the build, recording the vertexes and
+// statuses to the log, trace and stats
+func solve(solveOpt client.SolveOpt, frontend string, trace *buildTrace, buildSpan *traceSpan, build *buildLog, attempt int) (*buildStats, error) {
+	stats := newBuildStats()
+
+	c, err := client.New(buildkitURL, client.WithBlock())
+	if err != nil {
+		return stats, err
+	}
+	defer c.Close()
+
+	solveSpan := trace.Start("solve", buildSpan)
+	solveSpan.SetAttribute("frontend", frontend)
+	if attempt > 0 {
+		solveSpan.SetAttribute("attempt", strconv.Itoa(attempt+1))
+	}
+	phases := phaseRecorder{trace: trace, parent: solveSpan}
+
+	ch := make(chan *client.SolveStatus)
+	eg, ctx := errgroup.WithContext(context.Background())
+	eg.Go(func() error {
+		solveErr := c.Solve(ctx, nil, solveOpt, ch)
+		solveSpan.End(solveErr)
+		return solveErr
+	})
+
+	eg.Go(func() error {
+		for s := range ch {
+			for _, v := range s.Vertexes {
+				phases.vertex(v.Name, v.Started, v.Completed, v.Error)
+
+				var msg string
+				if v.Completed != nil {
+					msg = fmt.Sprintf("v: %s %s %.2fs", v.Started.Format(time.RFC3339), v.Name, v.Completed.Sub(*v.Started).Seconds())
+				} else {
+					var startedTime time.Time
+					if v.Started != nil {
+						startedTime = *(v.Started)
+					} else {
+						startedTime = time.Now()
+					}
+					startedVal := startedTime.Format(time.RFC3339)
+					msg = fmt.Sprintf("v: %s %v", startedVal, v.Name)
+				}
+				build.Append(msg)
+				fmt.Printf("%s\n", msg)
+
+			}
+			for _, s := range s.Statuses {
+				phases.status(s.ID, s.Started, s.Completed)
+				stats.status(s.ID, s.Total, s.Started, s.Completed)
+
+				msg := fmt.Sprintf("s: %s %s %d", s.Timestamp.Format(time.RFC3339), s.ID, s.Current)
+				build.Append(msg)
+
+				fmt.Printf("status: %s %s %d\n", s.Vertex, s.ID, s.Current)
+			}
+			for _, l := range s.Logs {
+
+				msg := fmt.Sprintf("l: %s %s", l.Timestamp.Format(time.RFC3339), l.Data)
+				build.Append(msg)
+
+				fmt.Printf("log: %s\n%s\n", l.Vertex, l.Data)
+			}
+
+		}
+		return nil
+	})
+
+	return stats, eg.Wait()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// ScanReport summarises the SBOM and vulnerabilities of a pushed image,
+// as returned by the scanner at scanner_url
+type ScanReport struct {
+	Image           string         `json:"image"`
+	Scanner         string         `json:"scanner,omitempty"`
+	SBOMFormat      string         `json:"sbomFormat,omitempty"`
+	SBOMPackages    int            `json:"sbomPackages"`
+	SBOMURL         string         `json:"sbomURL,omitempty"`
+	Vulnerabilities map[string]int `json:"vulnerabilities"`
+}
+
+// scanRequest is sent to the scanner once the image has been pushed
+type scanRequest struct {
+	Image    string `json:"image"`
+	Insecure bool   `json:"insecure,omitempty"`
+}
+
+// scannerURL is an HTTP service which generates the SBOM of an image
+// and scans it, i.e. a wrapper for syft and grype or Trivy. Images are
+// not scanned when it is not set.
+func scannerURL() string {
+	return os.Getenv("scanner_url")
+}
+
+// scannerTimeout reads scanner_timeout, 2m by default
+func scannerTimeout() time.Duration {
+	if val, err := time.ParseDuration(os.Getenv("scanner_timeout")); err == nil && val > 0 {
+		return val
+	}
+	return 2 * time.Minute
+}
+
+// scanImage asks the scanner for the report of a pushed image. The
+// scanner-token secret is sent as a bearer token when present.
+func scanImage(uri string, image string, insecure bool, timeout time.Duration) (*ScanReport, error) {
+	body, _ := json.Marshal(scanRequest{Image: image, Insecure: insecure})
+
+	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if token, err := sdk.ReadSecret("scanner-token"); err == nil && len(token) > 0 {
+		sdk.RegisterSecret(token)
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	resBody, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from scanner: %d, %s", res.StatusCode, strings.TrimSpace(string(resBody)))
+	}
+
+	report := ScanReport{}
+	if err := json.Unmarshal(resBody, &report); err != nil {
+		return nil, fmt.Errorf("unable to parse scan report: %s", err)
+	}
+
+	if len(report.Image) == 0 {
+		report.Image = image
+	}
+
+	return &report, nil
+}
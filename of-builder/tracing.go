@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceParentHeader is the W3C trace context header sent by the caller
+// so that the builder's spans join the pipeline's trace
+const traceParentHeader = "traceparent"
+
+// buildTrace records a span for each phase of a build and exports them
+// to an OpenTelemetry collector once the build completes. A nil
+// *buildTrace records nothing, so that tracing is optional.
+type buildTrace struct {
+	traceID  string
+	parentID string
+	endpoint string
+
+	spans []*traceSpan
+	mutex sync.Mutex
+}
+
+// traceSpan is a single phase of the build, i.e. untar or push
+type traceSpan struct {
+	name       string
+	spanID     string
+	parentID   string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        string
+}
+
+// newBuildTrace starts a trace for a request when otlp_endpoint is set,
+// i.e. http://otel-collector.openfaas:4318. The trace ID and parent span
+// are taken from the traceparent header, or a new trace is started.
+func newBuildTrace(header http.Header) *buildTrace {
+	endpoint := os.Getenv("otlp_endpoint")
+	if len(endpoint) == 0 {
+		return nil
+	}
+
+	t := &buildTrace{
+		endpoint: strings.TrimRight(endpoint, "/"),
+	}
+
+	if traceID, parentID, ok := parseTraceParent(header.Get(traceParentHeader)); ok {
+		t.traceID = traceID
+		t.parentID = parentID
+	} else {
+		t.traceID = randomHex(16)
+	}
+
+	return t
+}
+
+// parseTraceParent reads a version 00 traceparent header, such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceParent(value string) (string, string, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return "", "", false
+	}
+
+	traceID, parentID := strings.ToLower(parts[1]), strings.ToLower(parts[2])
+	if !isHex(traceID, 32) || !isHex(parentID, 16) {
+		return "", "", false
+	}
+
+	if traceID == strings.Repeat("0", 32) || parentID == strings.Repeat("0", 16) {
+		return "", "", false
+	}
+
+	return traceID, parentID, true
+}
+
+func isHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start begins a span, parent is nil for a span directly under the
+// caller's span
+func (t *buildTrace) Start(name string, parent *traceSpan) *traceSpan {
+	if t == nil {
+		return nil
+	}
+
+	return t.StartAt(name, parent, time.Now())
+}
+
+// StartAt begins a span at a time reported by buildkit
+func (t *buildTrace) StartAt(name string, parent *traceSpan, start time.Time) *traceSpan {
+	if t == nil {
+		return nil
+	}
+
+	s := &traceSpan{
+		name:       name,
+		spanID:     randomHex(8),
+		parentID:   t.parentID,
+		start:      start,
+		attributes: map[string]string{},
+	}
+	if parent != nil {
+		s.parentID = parent.spanID
+	}
+
+	t.mutex.Lock()
+	t.spans = append(t.spans, s)
+	t.mutex.Unlock()
+
+	return s
+}
+
+// SetAttribute records a string attribute on the span
+func (s *traceSpan) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.attributes[key] = value
+}
+
+// End completes the span, marking it as failed when err is not nil
+func (s *traceSpan) End(err error) {
+	if s == nil {
+		return
+	}
+	s.EndAt(time.Now(), err)
+}
+
+// EndAt completes the span at a time reported by buildkit
+func (s *traceSpan) EndAt(end time.Time, err error) {
+	if s == nil {
+		return
+	}
+
+	s.end = end
+	if err != nil {
+		s.err = err.Error()
+	}
+}
+
+// Export sends the completed spans to the collector using OTLP/HTTP
+// with the JSON encoding. Errors are only logged so that tracing never
+// fails a build.
+func (t *buildTrace) Export() {
+	if t == nil {
+		return
+	}
+
+	bytesOut, err := json.Marshal(t.otlp())
+	if err != nil {
+		log.Printf("trace export: %s", err.Error())
+		return
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	res, err := client.Post(t.endpoint+"/v1/traces", "application/json", bytes.NewReader(bytesOut))
+	if err != nil {
+		log.Printf("trace export: %s", err.Error())
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		log.Printf("trace export: unexpected status code from %s: %d", t.endpoint, res.StatusCode)
+	}
+}
+
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	out := []otlpAttribute{}
+	for k, v := range attributes {
+		out = append(out, otlpAttribute{Key: k, Value: map[string]string{"stringValue": v}})
+	}
+	return out
+}
+
+// otlp builds the ExportTraceServiceRequest for the completed spans
+func (t *buildTrace) otlp() map[string]interface{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	spans := []map[string]interface{}{}
+	for _, s := range t.spans {
+		if s.end.IsZero() {
+			continue
+		}
+
+		span := map[string]interface{}{
+			"traceId":           t.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attributes),
+		}
+		if len(s.parentID) > 0 {
+			span["parentSpanId"] = s.parentID
+		}
+		if len(s.err) > 0 {
+			span["status"] = map[string]interface{}{"code": 2, "message": s.err}
+		}
+
+		spans = append(spans, span)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": "of-builder"}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "of-builder"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// phaseRecorder turns buildkit's progress into export and push spans.
+// The image exporter is reported as the "exporting to image" vertex and
+// the upload as statuses prefixed with "pushing".
+type phaseRecorder struct {
+	trace  *buildTrace
+	parent *traceSpan
+
+	export *traceSpan
+	push   *traceSpan
+}
+
+func (p *phaseRecorder) vertex(name string, started, completed *time.Time, vertexErr string) {
+	if p.trace == nil || started == nil || !strings.HasPrefix(name, "exporting to image") {
+		return
+	}
+
+	if p.export == nil {
+		p.export = p.trace.StartAt("export", p.parent, *started)
+	}
+
+	if completed != nil {
+		var err error
+		if len(vertexErr) > 0 {
+			err = fmt.Errorf("%s", vertexErr)
+		}
+		p.export.EndAt(*completed, err)
+	}
+}
+
+func (p *phaseRecorder) status(id string, started, completed *time.Time) {
+	if p.trace == nil || started == nil || !strings.HasPrefix(id, "pushing") {
+		return
+	}
+
+	if p.push == nil {
+		parent := p.export
+		if parent == nil {
+			parent = p.parent
+		}
+		p.push = p.trace.StartAt("push", parent, *started)
+	}
+
+	if completed != nil && completed.After(p.push.end) {
+		p.push.EndAt(*completed, nil)
+	}
+}
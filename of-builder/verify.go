@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/docker/cli/cli/config/configfile"
+)
+
+// verifyTimeout bounds the requests made to read the image back
+const verifyTimeout = 30 * time.Second
+
+// manifestMediaTypes are accepted when the manifest is read back, a list
+// or index is only expected from a registry which rewrote the image
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// exportedImage holds the digests buildkit reported when it pushed
+type exportedImage struct {
+	Manifest string
+	Config   string
+}
+
+// verifyPushEnabled reads verify_push, pushed images are read back from
+// the registry unless it is "false"
+func verifyPushEnabled() bool {
+	return os.Getenv("verify_push") != "false"
+}
+
+// buildPlatform is the os/arch images are built for, from
+// build_platform or the builder's own platform
+func buildPlatform() string {
+	if val := os.Getenv("build_platform"); len(val) > 0 {
+		return val
+	}
+	return "linux/" + runtime.GOARCH
+}
+
+// imageLocation is where an image's manifest is read from
+type imageLocation struct {
+	Host       string
+	Repository string
+	Reference  string
+}
+
+// parseImageLocation splits an image such as registry:5000/owner/fn:tag,
+// images without a registry are on the Docker Hub
+func parseImageLocation(image string) imageLocation {
+	loc := imageLocation{Host: registryHost(image), Reference: "latest"}
+
+	name := image
+	if strings.HasPrefix(image, loc.Host+"/") {
+		name = strings.TrimPrefix(image, loc.Host+"/")
+	}
+
+	if index := strings.Index(name, "@"); index > -1 {
+		loc.Reference = name[index+1:]
+		name = name[:index]
+	} else if index := strings.LastIndex(name, ":"); index > strings.LastIndex(name, "/") {
+		loc.Reference = name[index+1:]
+		name = name[:index]
+	}
+
+	if loc.Host == "docker.io" {
+		loc.Host = "registry-1.docker.io"
+		if !strings.Contains(name, "/") {
+			name = "library/" + name
+		}
+	}
+	loc.Repository = name
+
+	return loc
+}
+
+// registryReader reads manifests and blobs with the credentials the
+// image was pushed with
+type registryReader struct {
+	client *http.Client
+	config *configfile.ConfigFile
+	scheme string
+	loc    imageLocation
+	bearer string
+	// basic is set once a Basic challenge has been answered
+	basic bool
+}
+
+// verifyPush reads the pushed image back from the registry and checks
+// the manifest and config are the ones buildkit exported, and that the
+// image is for the build platform. A registry which corrupts blobs, or
+// a mirror which serves another image for the tag, fails the build so
+// that buildshiprun does not deploy it. The manifest's digest is
+// returned.
+func verifyPush(image string, exported exportedImage, owner string, insecure bool) (string, error) {
+	r := &registryReader{
+		client: &http.Client{Timeout: verifyTimeout},
+		config: registryConfig(owner),
+		scheme: "https",
+		loc:    parseImageLocation(image),
+	}
+	if insecure {
+		r.scheme = "http"
+	}
+
+	body, mediaType, err := r.get("manifests/"+r.loc.Reference, manifestMediaTypes)
+	if err != nil {
+		return "", fmt.Errorf("unable to read manifest of %s: %s", image, err.Error())
+	}
+
+	digest := sha256Digest(body)
+	if len(exported.Manifest) > 0 && digest != exported.Manifest {
+		return digest, fmt.Errorf("registry has manifest %s for %s, but %s was pushed", digest, image, exported.Manifest)
+	}
+
+	platform := buildPlatform()
+
+	if strings.HasSuffix(mediaType, "manifest.list.v2+json") || strings.HasSuffix(mediaType, "image.index.v1+json") {
+		index := struct {
+			Manifests []struct {
+				Platform struct {
+					OS           string `json:"os"`
+					Architecture string `json:"architecture"`
+				} `json:"platform"`
+			} `json:"manifests"`
+		}{}
+		if err := json.Unmarshal(body, &index); err != nil {
+			return digest, fmt.Errorf("unable to parse manifest list of %s: %s", image, err.Error())
+		}
+
+		platforms := []string{}
+		for _, m := range index.Manifests {
+			p := m.Platform.OS + "/" + m.Platform.Architecture
+			if p == platform {
+				return digest, nil
+			}
+			platforms = append(platforms, p)
+		}
+		return digest, fmt.Errorf("manifest list of %s has %s, but not %s", image, strings.Join(platforms, ", "), platform)
+	}
+
+	manifest := struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}{}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return digest, fmt.Errorf("unable to parse manifest of %s: %s", image, err.Error())
+	}
+
+	if len(exported.Config) > 0 && manifest.Config.Digest != exported.Config {
+		return digest, fmt.Errorf("registry has config %s for %s, but %s was pushed", manifest.Config.Digest, image, exported.Config)
+	}
+
+	configBody, _, err := r.get("blobs/"+manifest.Config.Digest, nil)
+	if err != nil {
+		return digest, fmt.Errorf("unable to read config of %s: %s", image, err.Error())
+	}
+	if got := sha256Digest(configBody); got != manifest.Config.Digest {
+		return digest, fmt.Errorf("registry served config %s for %s, which is corrupt: %s", manifest.Config.Digest, image, got)
+	}
+
+	imageConfig := struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	}{}
+	if err := json.Unmarshal(configBody, &imageConfig); err != nil {
+		return digest, fmt.Errorf("unable to parse config of %s: %s", image, err.Error())
+	}
+	if got := imageConfig.OS + "/" + imageConfig.Architecture; got != platform {
+		return digest, fmt.Errorf("image %s is for %s, but %s was built", image, got, platform)
+	}
+
+	return digest, nil
+}
+
+// get reads from the repository's v2 API, answering the registry's
+// challenge with a token or basic auth when the request is refused
+func (r *registryReader) get(path string, accept []string) ([]byte, string, error) {
+	reqURL := fmt.Sprintf("%s://%s/v2/%s/%s", r.scheme, r.loc.Host, r.loc.Repository, path)
+
+	res, err := r.do(reqURL, accept)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("WWW-Authenticate")
+		res.Body.Close()
+
+		if err := r.authorize(challenge); err != nil {
+			return nil, "", err
+		}
+
+		res, err = r.do(reqURL, accept)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status code from %s: %d", r.loc.Host, res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The registry's digest must agree with what it served, otherwise
+	// the bytes were changed on the way
+	if digest := res.Header.Get("Docker-Content-Digest"); len(digest) > 0 && digest != sha256Digest(body) {
+		return nil, "", fmt.Errorf("registry sent %s, but the body is %s", digest, sha256Digest(body))
+	}
+
+	return body, res.Header.Get("Content-Type"), nil
+}
+
+func (r *registryReader) do(reqURL string, accept []string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(accept) > 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+
+	if len(r.bearer) > 0 {
+		req.Header.Set("Authorization", "Bearer "+r.bearer)
+	} else if r.basic {
+		username, secret := r.credentials()
+		req.SetBasicAuth(username, secret)
+	}
+
+	return r.client.Do(req)
+}
+
+// authorize answers a Bearer challenge with a pull token for the
+// repository, or uses basic auth for a Basic challenge
+func (r *registryReader) authorize(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if r.basic {
+			return fmt.Errorf("credentials for %s were refused", r.loc.Host)
+		}
+		r.basic = true
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported auth challenge from %s: %q", r.loc.Host, challenge)
+	}
+
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil || len(params["realm"]) == 0 {
+		return fmt.Errorf("invalid realm in challenge from %s", r.loc.Host)
+	}
+
+	query := tokenURL.Query()
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	query.Set("scope", "repository:"+r.loc.Repository+":pull")
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	if username, secret := r.credentials(); len(secret) > 0 {
+		req.SetBasicAuth(username, secret)
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code for a token from %s: %d", tokenURL.Host, res.StatusCode)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return fmt.Errorf("unable to parse token from %s: %s", tokenURL.Host, err.Error())
+	}
+
+	r.bearer = token.Token
+	if len(r.bearer) == 0 {
+		r.bearer = token.AccessToken
+	}
+	if len(r.bearer) == 0 {
+		return fmt.Errorf("no token given by %s", tokenURL.Host)
+	}
+	return nil
+}
+
+// credentials gives the username and password, or identity token, for
+// the registry from the config.json the image was pushed with
+func (r *registryReader) credentials() (string, string) {
+	host := r.loc.Host
+	if host == "registry-1.docker.io" {
+		host = "https://index.docker.io/v1/"
+	}
+
+	ac, err := r.config.GetAuthConfig(host)
+	if err != nil {
+		return "", ""
+	}
+	if len(ac.IdentityToken) > 0 {
+		return "<token>", ac.IdentityToken
+	}
+	return ac.Username, ac.Password
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+
+	for _, param := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return parts[0], params
+}
+
+func sha256Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
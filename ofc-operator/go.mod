@@ -0,0 +1,3 @@
+module github.com/openfaas/openfaas-cloud/ofc-operator
+
+go 1.13
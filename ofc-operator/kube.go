@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccountPath holds the token and CA of the operator's service
+// account when it runs in a Pod
+const serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// cloudResource is the API path of the OpenFaaSCloud custom resource
+const cloudResource = "/apis/ofc.openfaas.com/v1alpha1/namespaces/%s/openfaasclouds/%s"
+
+// kubeClient calls the Kubernetes API with a bearer token, the few
+// requests made by the operator do not warrant client-go
+type kubeClient struct {
+	BaseURL string
+	Token   string
+	Client  *http.Client
+}
+
+// newInClusterClient uses the service account mounted into the Pod
+func newInClusterClient(timeout time.Duration) (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 || len(port) == 0 {
+		return nil, fmt.Errorf("not running in a cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+
+	token, err := ioutil.ReadFile(serviceAccountPath + "token")
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountPath + "ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %sca.crt", serviceAccountPath)
+	}
+
+	return &kubeClient{
+		BaseURL: "https://" + host + ":" + port,
+		Token:   strings.TrimSpace(string(token)),
+		Client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+	}, nil
+}
+
+// statusError is returned for a response outside of the 2xx range
+type statusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, e.Body)
+}
+
+func isNotFound(err error) bool {
+	statusErr, ok := err.(*statusError)
+	return ok && statusErr.StatusCode == http.StatusNotFound
+}
+
+func (k *kubeClient) do(method, path, contentType string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, k.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if len(k.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+k.Token)
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := k.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	resBody, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &statusError{StatusCode: res.StatusCode, Body: strings.TrimSpace(string(resBody))}
+	}
+
+	if out != nil {
+		return json.Unmarshal(resBody, out)
+	}
+	return nil
+}
+
+// GetCloud reads the OpenFaaSCloud resource
+func (k *kubeClient) GetCloud(namespace, name string) (*OpenFaaSCloud, error) {
+	cloud := &OpenFaaSCloud{}
+	err := k.do(http.MethodGet, fmt.Sprintf(cloudResource, namespace, name), "", nil, cloud)
+	return cloud, err
+}
+
+// UpdateCloudStatus writes the status sub-resource
+func (k *kubeClient) UpdateCloudStatus(namespace, name string, status CloudStatus) error {
+	body, _ := json.Marshal(map[string]interface{}{"status": status})
+	return k.do(http.MethodPatch, fmt.Sprintf(cloudResource, namespace, name)+"/status", "application/merge-patch+json", body, nil)
+}
+
+// envVar is a container's env-var, those read from a secret or a
+// field have a ValueFrom and are left alone
+type envVar struct {
+	Name      string          `json:"name"`
+	Value     string          `json:"value"`
+	ValueFrom json.RawMessage `json:"valueFrom,omitempty"`
+}
+
+type container struct {
+	Name string   `json:"name"`
+	Env  []envVar `json:"env,omitempty"`
+}
+
+// deployment is the subset of an apps/v1 Deployment read by the operator
+type deployment struct {
+	Spec struct {
+		Template struct {
+			Spec struct {
+				Containers []container `json:"containers"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// GetDeployment reads a Deployment
+func (k *kubeClient) GetDeployment(namespace, name string) (*deployment, error) {
+	dep := &deployment{}
+	err := k.do(http.MethodGet, fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments/%s", namespace, name), "", nil, dep)
+	return dep, err
+}
+
+// PatchEnv sets env-vars on a container with a strategic merge patch,
+// which merges the env-vars by name and keeps those not given
+func (k *kubeClient) PatchEnv(namespace, name, containerName string, env []envVar) error {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []container{{Name: containerName, Env: env}},
+				},
+			},
+		},
+	}
+
+	body, _ := json.Marshal(patch)
+	return k.do(http.MethodPatch, fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments/%s", namespace, name), "application/strategic-merge-patch+json", body, nil)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	cfg := newOperatorConfig()
+
+	kube, err := newInClusterClient(10 * time.Second)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	log.Printf("Reconciling %s/%s every %s\n", cfg.Namespace, cfg.CloudName, cfg.Interval)
+
+	reconciler := &Reconciler{Kube: kube, Config: cfg}
+	go reconciler.Run()
+
+	router := http.NewServeMux()
+	router.HandleFunc("/healthz", makeHealthzHandler())
+
+	port := "8080"
+	if val, ok := os.LookupEnv("port"); ok && len(val) > 0 {
+		port = val
+	}
+
+	log.Fatal(http.ListenAndServe(":"+port, router))
+}
+
+// newOperatorConfig reads namespace, function_namespace, cloud_name and
+// reconcile_interval
+func newOperatorConfig() operatorConfig {
+	cfg := operatorConfig{
+		Namespace:         "openfaas",
+		FunctionNamespace: "openfaas-fn",
+		CloudName:         "openfaas-cloud",
+		Interval:          time.Minute,
+	}
+
+	if val := os.Getenv("namespace"); len(val) > 0 {
+		cfg.Namespace = val
+	}
+	if val := os.Getenv("function_namespace"); len(val) > 0 {
+		cfg.FunctionNamespace = val
+	}
+	if val := os.Getenv("cloud_name"); len(val) > 0 {
+		cfg.CloudName = val
+	}
+	if val, err := time.ParseDuration(os.Getenv("reconcile_interval")); err == nil && val > 0 {
+		cfg.Interval = val
+	}
+
+	return cfg
+}
+
+func makeHealthzHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
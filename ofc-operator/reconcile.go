@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// operatorConfig is read from env-vars, everything else comes from the
+// OpenFaaSCloud resource
+type operatorConfig struct {
+	// Namespace of the resource, the router and the of-builder
+	Namespace         string
+	FunctionNamespace string
+	CloudName         string
+	Interval          time.Duration
+}
+
+// Reconciler applies the OpenFaaSCloud resource to the Deployments of
+// the installation
+type Reconciler struct {
+	Kube   *kubeClient
+	Config operatorConfig
+}
+
+// Reconcile reads the resource and patches the env-vars which differ
+// on each Deployment. It runs on an interval rather than on a watch, so
+// that a component redeployed with its old env-vars, i.e. with
+// faas-cli deploy, is brought back in line.
+func (r *Reconciler) Reconcile(now time.Time) (*CloudStatus, error) {
+	cloud, err := r.Kube.GetCloud(r.Config.Namespace, r.Config.CloudName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s/%s: %s", r.Config.Namespace, r.Config.CloudName, err)
+	}
+
+	status := CloudStatus{
+		ObservedGeneration: cloud.Metadata.Generation,
+		LastReconciled:     now.UTC().Format(time.RFC3339),
+	}
+
+	for _, t := range targets(cloud.Spec, r.Config.Namespace, r.Config.FunctionNamespace) {
+		updated, err := r.apply(t, render(cloud.Spec, t.Component))
+		switch {
+		case isNotFound(err):
+			status.Missing = append(status.Missing, t.Name)
+		case err != nil:
+			status.Errors = append(status.Errors, fmt.Sprintf("%s: %s", t.Name, err))
+		case updated:
+			status.Updated = append(status.Updated, t.Name)
+		}
+	}
+
+	if err := r.Kube.UpdateCloudStatus(r.Config.Namespace, r.Config.CloudName, status); err != nil {
+		log.Printf("Unable to update status of %s: %s", r.Config.CloudName, err)
+	}
+
+	return &status, nil
+}
+
+// apply patches the Deployment's container of the same name, or its
+// only container, when any of the env-vars differ
+func (r *Reconciler) apply(t target, env map[string]string) (bool, error) {
+	if len(env) == 0 {
+		return false, nil
+	}
+
+	dep, err := r.Kube.GetDeployment(t.Namespace, t.Name)
+	if err != nil {
+		return false, err
+	}
+
+	c := findContainer(dep, t.Name)
+	if c == nil {
+		return false, fmt.Errorf("no container named %s", t.Name)
+	}
+
+	changes := envChanges(c.Env, env)
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	names := []string{}
+	for _, change := range changes {
+		names = append(names, change.Name)
+	}
+	log.Printf("Updating %s/%s: %v", t.Namespace, t.Name, names)
+
+	return true, r.Kube.PatchEnv(t.Namespace, t.Name, c.Name, changes)
+}
+
+func findContainer(dep *deployment, name string) *container {
+	containers := dep.Spec.Template.Spec.Containers
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	if len(containers) == 1 {
+		return &containers[0]
+	}
+	return nil
+}
+
+// envChanges lists the env-vars to set, in name order. An env-var read
+// from a secret or field is not replaced with a plain value.
+func envChanges(current []envVar, desired map[string]string) []envVar {
+	existing := map[string]envVar{}
+	for _, e := range current {
+		existing[e.Name] = e
+	}
+
+	names := []string{}
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changes := []envVar{}
+	for _, name := range names {
+		e, ok := existing[name]
+		if ok && len(e.ValueFrom) > 0 {
+			log.Printf("Skipping %s, it is read from a reference", name)
+			continue
+		}
+		if !ok || e.Value != desired[name] {
+			changes = append(changes, envVar{Name: name, Value: desired[name]})
+		}
+	}
+	return changes
+}
+
+// Run reconciles on the interval until the process exits
+func (r *Reconciler) Run() {
+	for {
+		status, err := r.Reconcile(time.Now())
+		if err != nil {
+			log.Printf("Reconcile error: %s", err)
+		} else if len(status.Updated) > 0 || len(status.Errors) > 0 {
+			log.Printf("Reconciled %s: updated %v, errors %v", r.Config.CloudName, status.Updated, status.Errors)
+		}
+		time.Sleep(r.Config.Interval)
+	}
+}
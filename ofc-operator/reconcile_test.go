@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKube serves the resource and Deployments, and records patches
+type fakeKube struct {
+	cloud       string
+	deployments map[string]string
+	patches     map[string]string
+	status      string
+	mutex       sync.Mutex
+}
+
+func (f *fakeKube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/openfaasclouds/openfaas-cloud/status"):
+		f.status = string(body)
+	case strings.HasSuffix(r.URL.Path, "/openfaasclouds/openfaas-cloud"):
+		w.Write([]byte(f.cloud))
+	case strings.HasPrefix(r.URL.Path, "/apis/apps/v1/namespaces/"):
+		dep, ok := f.deployments[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			if r.Header.Get("Content-Type") != "application/strategic-merge-patch+json" {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			f.patches[r.URL.Path] = string(body)
+			return
+		}
+		w.Write([]byte(dep))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func Test_Reconcile(t *testing.T) {
+	kube := &fakeKube{
+		cloud: `{"metadata": {"name": "openfaas-cloud", "generation": 3},
+			"spec": {"functions": ["buildshiprun", "git-tar"], "features": {"buildBranch": "main"},
+			"registry": {"url": "registry:5000"}, "env": {"edge-router": {"timeout": "60s"}}}}`,
+		deployments: map[string]string{
+			"/apis/apps/v1/namespaces/openfaas/deployments/edge-router": `{"spec": {"template": {"spec": {"containers": [
+				{"name": "edge-router", "env": [{"name": "timeout", "value": "60s"}]}]}}}}`,
+			"/apis/apps/v1/namespaces/openfaas-fn/deployments/buildshiprun": `{"spec": {"template": {"spec": {"containers": [
+				{"name": "buildshiprun", "env": [{"name": "build_branch", "value": "master"},
+				{"name": "repository_url", "valueFrom": {"configMapKeyRef": {"name": "ofc", "key": "registry"}}}]}]}}}}`,
+		},
+		patches: map[string]string{},
+	}
+
+	server := httptest.NewServer(kube)
+	defer server.Close()
+
+	r := &Reconciler{
+		Kube:   &kubeClient{BaseURL: server.URL, Client: http.DefaultClient},
+		Config: operatorConfig{Namespace: "openfaas", FunctionNamespace: "openfaas-fn", CloudName: "openfaas-cloud"},
+	}
+
+	status, err := r.Reconcile(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status.Updated) != 1 || status.Updated[0] != "buildshiprun" {
+		t.Errorf("want buildshiprun updated, got %v", status.Updated)
+	}
+	if len(status.Missing) != 1 || status.Missing[0] != "git-tar" {
+		t.Errorf("want git-tar missing, got %v", status.Missing)
+	}
+	if status.ObservedGeneration != 3 {
+		t.Errorf("want generation 3 observed, got %d", status.ObservedGeneration)
+	}
+
+	patch := kube.patches["/apis/apps/v1/namespaces/openfaas-fn/deployments/buildshiprun"]
+	want := `{"spec":{"template":{"spec":{"containers":[{"name":"buildshiprun","env":[{"name":"build_branch","value":"main"}]}]}}}}`
+	if patch != want {
+		t.Errorf("want the changed env-var patched only\nwant %s\ngot  %s", want, patch)
+	}
+
+	if _, ok := kube.patches["/apis/apps/v1/namespaces/openfaas/deployments/edge-router"]; ok {
+		t.Errorf("want no patch for an unchanged Deployment")
+	}
+
+	written := struct {
+		Status CloudStatus `json:"status"`
+	}{}
+	json.Unmarshal([]byte(kube.status), &written)
+	if written.Status.LastReconciled != "2020-01-01T00:00:00Z" {
+		t.Errorf("want the status written, got %s", kube.status)
+	}
+}
+
+func Test_Reconcile_MissingResource(t *testing.T) {
+	server := httptest.NewServer(&fakeKube{})
+	defer server.Close()
+
+	r := &Reconciler{
+		Kube:   &kubeClient{BaseURL: server.URL, Client: http.DefaultClient},
+		Config: operatorConfig{Namespace: "openfaas", CloudName: "other"},
+	}
+
+	if _, err := r.Reconcile(time.Now()); err == nil || !strings.Contains(err.Error(), "unable to read openfaas/other") {
+		t.Errorf("want an error for a missing resource, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Components configured outside of the pipeline functions, and the key
+// in spec.env which applies to every pipeline function
+const (
+	routerComponent   = "edge-router"
+	builderComponent  = "of-builder"
+	pipelineComponent = "pipeline"
+)
+
+// defaultFunctions are the pipeline functions deployed from stack.yml
+var defaultFunctions = []string{
+	"system-github-event",
+	"github-push",
+	"event-consumer",
+	"git-tar",
+	"buildshiprun",
+	"garbage-collect",
+	"github-status",
+	"import-secrets",
+	"pipeline-log",
+	"pipeline-watchdog",
+	"list-functions",
+	"cost-estimate",
+	"platform-upgrade",
+	"audit-event",
+	"metrics",
+	"function-logs",
+}
+
+// target is a Deployment whose container is given the rendered env-vars
+type target struct {
+	Component string
+	Namespace string
+	Name      string
+}
+
+// targets lists the Deployments to reconcile, the router and builder
+// run in the core namespace and the pipeline functions in the
+// functions namespace
+func targets(spec CloudSpec, coreNamespace, functionNamespace string) []target {
+	list := []target{
+		{Component: routerComponent, Namespace: coreNamespace, Name: routerComponent},
+		{Component: builderComponent, Namespace: coreNamespace, Name: builderComponent},
+	}
+
+	functions := spec.Functions
+	if len(functions) == 0 {
+		functions = defaultFunctions
+	}
+
+	for _, name := range functions {
+		list = append(list, target{Component: name, Namespace: functionNamespace, Name: name})
+	}
+	return list
+}
+
+// render gives the env-vars for a component from the spec. Only the
+// values which are set are rendered, so that the component's defaults
+// apply to the rest.
+func render(spec CloudSpec, component string) map[string]string {
+	env := map[string]string{}
+
+	switch component {
+	case routerComponent:
+		setBool(env, "owner_namespaces", spec.Features.OwnerNamespaces)
+	case builderComponent:
+		set(env, "owner_registry_auth_path", spec.Secrets.OwnerRegistryAuthPath)
+	default:
+		renderPipeline(spec, env)
+		mergeEnv(env, spec.Env[pipelineComponent])
+	}
+
+	mergeEnv(env, spec.Env[component])
+	return env
+}
+
+// renderPipeline sets the env-vars shared by the pipeline functions,
+// the names match gateway_config.yml
+func renderPipeline(spec CloudSpec, env map[string]string) {
+	publicURL, prettyURL := publicURLs(spec.Domain)
+	set(env, "gateway_public_url", publicURL)
+	set(env, "gateway_pretty_url", prettyURL)
+
+	set(env, "repository_url", spec.Registry.URL)
+	set(env, "push_repository_url", spec.Registry.PushURL)
+	set(env, "registry_orgs", formatPairs(spec.Registry.Orgs))
+	set(env, "registry_org_template", spec.Registry.OrgTemplate)
+	set(env, "owner_registries", formatPairs(spec.Registry.OwnerRegistries))
+	set(env, "prebuilt_registries", strings.Join(spec.Registry.PrebuiltRegistries, ","))
+
+	if spec.Quotas.Functions != nil {
+		env["function_quota"] = strconv.Itoa(*spec.Quotas.Functions)
+	}
+	quotas := map[string]string{}
+	for owner, quota := range spec.Quotas.Owners {
+		quotas[owner] = strconv.Itoa(quota)
+	}
+	set(env, "function_quotas", formatPairs(quotas))
+	if spec.Quotas.MemoryMaxMB > 0 {
+		env["function_memory_max_mb"] = strconv.Itoa(spec.Quotas.MemoryMaxMB)
+	}
+	if spec.Quotas.CPUMaxMilli > 0 {
+		env["function_cpu_max_milli"] = strconv.Itoa(spec.Quotas.CPUMaxMilli)
+	}
+
+	setBool(env, "enable_dockerfile_lang", spec.Features.DockerfileLang)
+	setBool(env, "enable_pr_previews", spec.Features.PullRequestPreviews)
+	setBool(env, "owner_namespaces", spec.Features.OwnerNamespaces)
+	setBool(env, "readonly_root_filesystem", spec.Features.ReadOnlyRootFilesystem)
+	set(env, "build_branch", spec.Features.BuildBranch)
+	set(env, "staging_branch", spec.Features.StagingBranch)
+
+	set(env, "secret_mount_path", spec.Secrets.MountPath)
+	set(env, "gateway_token_file", spec.Secrets.GatewayTokenFile)
+	set(env, "ca_bundle_path", spec.Secrets.CABundlePath)
+}
+
+// publicURLs derives the system and pretty URLs from the root domain
+// when they are not given, i.e. https://system.o6s.io/ and
+// https://user.o6s.io/function
+func publicURLs(domain DomainSpec) (string, string) {
+	publicURL, prettyURL := domain.PublicURL, domain.PrettyURL
+	if len(domain.RootDomain) == 0 {
+		return publicURL, prettyURL
+	}
+
+	scheme := "http"
+	if domain.TLS {
+		scheme = "https"
+	}
+
+	if len(publicURL) == 0 {
+		publicURL = fmt.Sprintf("%s://system.%s/", scheme, domain.RootDomain)
+	}
+	if len(prettyURL) == 0 {
+		prettyURL = fmt.Sprintf("%s://user.%s/function", scheme, domain.RootDomain)
+	}
+	return publicURL, prettyURL
+}
+
+func set(env map[string]string, key, value string) {
+	if len(value) > 0 {
+		env[key] = value
+	}
+}
+
+func setBool(env map[string]string, key string, value *bool) {
+	if value != nil {
+		env[key] = strconv.FormatBool(*value)
+	}
+}
+
+func mergeEnv(env map[string]string, overrides map[string]string) {
+	for k, v := range overrides {
+		env[k] = v
+	}
+}
+
+// formatPairs gives the key=value list read by the components, sorted
+// so that the rendered value does not change between reconciliations
+func formatPairs(pairs map[string]string) string {
+	keys := []string{}
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := []string{}
+	for _, k := range keys {
+		values = append(values, k+"="+pairs[k])
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_render_Pipeline(t *testing.T) {
+	quota := 20
+	previews := true
+	spec := CloudSpec{
+		Domain: DomainSpec{RootDomain: "o6s.io", TLS: true},
+		Registry: RegistrySpec{
+			URL:             "docker.io/ofcommunity/",
+			PushURL:         "docker.io/ofcommunity/",
+			OwnerRegistries: map[string]string{"openfaas": "quay.io/ofc", "alexellis": "ghcr.io/alexellis"},
+		},
+		Quotas:   QuotaSpec{Functions: &quota, Owners: map[string]int{"alexellis": 50}},
+		Features: FeatureSpec{PullRequestPreviews: &previews},
+		Env: map[string]map[string]string{
+			"pipeline":     {"build_branch": "main"},
+			"buildshiprun": {"warmup_requests": "2"},
+		},
+	}
+
+	want := map[string]string{
+		"gateway_public_url":  "https://system.o6s.io/",
+		"gateway_pretty_url":  "https://user.o6s.io/function",
+		"repository_url":      "docker.io/ofcommunity/",
+		"push_repository_url": "docker.io/ofcommunity/",
+		"owner_registries":    "alexellis=ghcr.io/alexellis,openfaas=quay.io/ofc",
+		"function_quota":      "20",
+		"function_quotas":     "alexellis=50",
+		"enable_pr_previews":  "true",
+		"build_branch":        "main",
+		"warmup_requests":     "2",
+	}
+
+	if got := render(spec, "buildshiprun"); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v\ngot %v", want, got)
+	}
+
+	if got := render(spec, "git-tar"); got["warmup_requests"] != "" || got["build_branch"] != "main" {
+		t.Errorf("want only the pipeline overrides for git-tar, got %v", got)
+	}
+}
+
+func Test_render_CoreComponents(t *testing.T) {
+	ownerNamespaces := true
+	spec := CloudSpec{
+		Features: FeatureSpec{OwnerNamespaces: &ownerNamespaces},
+		Secrets:  SecretsSpec{OwnerRegistryAuthPath: "/var/openfaas/registries/"},
+		Env: map[string]map[string]string{
+			"pipeline":    {"build_branch": "main"},
+			"edge-router": {"cold_start_wait": "30s"},
+		},
+	}
+
+	want := map[string]string{"owner_namespaces": "true", "cold_start_wait": "30s"}
+	if got := render(spec, routerComponent); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	want = map[string]string{"owner_registry_auth_path": "/var/openfaas/registries/"}
+	if got := render(spec, builderComponent); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func Test_targets(t *testing.T) {
+	list := targets(CloudSpec{Functions: []string{"buildshiprun"}}, "openfaas", "openfaas-fn")
+
+	want := []target{
+		{Component: routerComponent, Namespace: "openfaas", Name: routerComponent},
+		{Component: builderComponent, Namespace: "openfaas", Name: builderComponent},
+		{Component: "buildshiprun", Namespace: "openfaas-fn", Name: "buildshiprun"},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("want %v, got %v", want, list)
+	}
+
+	if got := len(targets(CloudSpec{}, "openfaas", "openfaas-fn")); got != len(defaultFunctions)+2 {
+		t.Errorf("want the default pipeline functions, got %d targets", got)
+	}
+}
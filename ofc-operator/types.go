@@ -0,0 +1,93 @@
+package main
+
+// OpenFaaSCloud is the custom resource holding the configuration of an
+// installation, in place of the env-vars of each component
+type OpenFaaSCloud struct {
+	APIVersion string       `json:"apiVersion"`
+	Kind       string       `json:"kind"`
+	Metadata   ObjectMeta   `json:"metadata"`
+	Spec       CloudSpec    `json:"spec"`
+	Status     *CloudStatus `json:"status,omitempty"`
+}
+
+// ObjectMeta is the subset of Kubernetes' metadata used by the operator
+type ObjectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	Generation      int64  `json:"generation,omitempty"`
+}
+
+// CloudSpec is the desired configuration of the router, of-builder and
+// the pipeline functions
+type CloudSpec struct {
+	Domain   DomainSpec   `json:"domain"`
+	Registry RegistrySpec `json:"registry"`
+	Quotas   QuotaSpec    `json:"quotas"`
+	Features FeatureSpec  `json:"features"`
+	Secrets  SecretsSpec  `json:"secrets"`
+
+	// Functions are the pipeline functions to configure, the core
+	// functions from stack.yml are used when empty
+	Functions []string `json:"functions,omitempty"`
+
+	// Env adds or overrides env-vars by component, where "pipeline"
+	// applies to every pipeline function, i.e. env.buildshiprun.warmup_requests
+	Env map[string]map[string]string `json:"env,omitempty"`
+}
+
+// DomainSpec gives the public URLs of the installation
+type DomainSpec struct {
+	// RootDomain such as o6s.io, the public URLs are derived from it
+	// when they are not set
+	RootDomain string `json:"rootDomain,omitempty"`
+	PublicURL  string `json:"publicURL,omitempty"`
+	PrettyURL  string `json:"prettyURL,omitempty"`
+	TLS        bool   `json:"tls,omitempty"`
+}
+
+// RegistrySpec is where images are pushed to and pulled from
+type RegistrySpec struct {
+	URL                string            `json:"url,omitempty"`
+	PushURL            string            `json:"pushURL,omitempty"`
+	Orgs               map[string]string `json:"orgs,omitempty"`
+	OrgTemplate        string            `json:"orgTemplate,omitempty"`
+	OwnerRegistries    map[string]string `json:"ownerRegistries,omitempty"`
+	PrebuiltRegistries []string          `json:"prebuiltRegistries,omitempty"`
+}
+
+// QuotaSpec limits what each owner may deploy
+type QuotaSpec struct {
+	Functions   *int           `json:"functions,omitempty"`
+	Owners      map[string]int `json:"owners,omitempty"`
+	MemoryMaxMB int            `json:"memoryMaxMB,omitempty"`
+	CPUMaxMilli int            `json:"cpuMaxMilli,omitempty"`
+}
+
+// FeatureSpec turns optional parts of the pipeline on or off, features
+// which are not set keep the component's default
+type FeatureSpec struct {
+	DockerfileLang         *bool  `json:"dockerfileLang,omitempty"`
+	PullRequestPreviews    *bool  `json:"pullRequestPreviews,omitempty"`
+	OwnerNamespaces        *bool  `json:"ownerNamespaces,omitempty"`
+	ReadOnlyRootFilesystem *bool  `json:"readOnlyRootFilesystem,omitempty"`
+	BuildBranch            string `json:"buildBranch,omitempty"`
+	StagingBranch          string `json:"stagingBranch,omitempty"`
+}
+
+// SecretsSpec references where the components find their secrets
+type SecretsSpec struct {
+	MountPath             string `json:"mountPath,omitempty"`
+	GatewayTokenFile      string `json:"gatewayTokenFile,omitempty"`
+	CABundlePath          string `json:"caBundlePath,omitempty"`
+	OwnerRegistryAuthPath string `json:"ownerRegistryAuthPath,omitempty"`
+}
+
+// CloudStatus records the outcome of the last reconciliation
+type CloudStatus struct {
+	ObservedGeneration int64    `json:"observedGeneration"`
+	LastReconciled     string   `json:"lastReconciled"`
+	Updated            []string `json:"updated,omitempty"`
+	Missing            []string `json:"missing,omitempty"`
+	Errors             []string `json:"errors,omitempty"`
+}
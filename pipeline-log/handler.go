@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"strings"
 
 	minio "github.com/minio/minio-go"
 	"github.com/openfaas/openfaas-cloud/sdk"
@@ -19,7 +21,7 @@ import (
 func Handle(req []byte) string {
 	method := os.Getenv("Http_Method")
 
-	if method == http.MethodPost {
+	if method == http.MethodPost || method == http.MethodDelete {
 		hmacErr := sdk.ValidHMAC(&req, "payload-secret", os.Getenv("Http_X_Cloud_Signature"))
 		if hmacErr != nil {
 			log.Printf("hmac error %s\n", hmacErr.Error())
@@ -59,6 +61,18 @@ func Handle(req []byte) string {
 		}
 		return fmt.Sprintf("Wrote %d bytes to %s\n", n, fullPath)
 
+	case http.MethodDelete:
+
+		pipelineLog := sdk.PipelineLog{}
+		json.Unmarshal(req, &pipelineLog)
+
+		fullPath := getPath(bucketName, &pipelineLog)
+		if err := minioClient.RemoveObject(bucketName, fullPath); err != nil {
+			log.Printf("error removing: %s, error: %s", fullPath, err.Error())
+			os.Exit(1)
+		}
+		return fmt.Sprintf("Removed %s\n", fullPath)
+
 	case http.MethodGet:
 		queryRaw := os.Getenv("Http_Query")
 		query, parseErr := url.ParseQuery(queryRaw)
@@ -70,6 +84,13 @@ func Handle(req []byte) string {
 			CommitSHA: query.Get("commitSHA"),
 			Function:  query.Get("function"),
 			RepoPath:  query.Get("repoPath"),
+			Source:    query.Get("source"),
+		}
+
+		if query.Get("list") == "true" {
+			keys := listKeys(minioClient, bucketName, &p)
+			out, _ := json.Marshal(keys)
+			return string(out)
 		}
 
 		fullPath := getPath(bucketName, &p)
@@ -89,6 +110,47 @@ func Handle(req []byte) string {
 	return fmt.Sprintf("pipeline-log, unknown request")
 }
 
+// listKeys gives the keys, in place of the commit SHA, of the records of
+// the function and source under the repo path, oldest first when the keys
+// are timestamps
+func listKeys(minioClient *minio.Client, bucket string, p *sdk.PipelineLog) []string {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	names := []string{}
+	for object := range minioClient.ListObjectsV2(bucket, fmt.Sprintf("%s/%s/", bucket, p.RepoPath), true, doneCh) {
+		if object.Err != nil {
+			log.Printf("error listing: %s, error: %s", p.RepoPath, object.Err.Error())
+			break
+		}
+		names = append(names, object.Key)
+	}
+
+	return keysOf(bucket, p, names)
+}
+
+// keysOf picks the keys from object names such as
+// pipeline/system/received/alexellis/<key>/github-event/build.log
+func keysOf(bucket string, p *sdk.PipelineLog, names []string) []string {
+	keys := []string{}
+	for _, name := range names {
+		key := sdk.PipelineLog{RepoPath: p.RepoPath, Function: p.Function, Source: p.Source}
+		rel := strings.TrimPrefix(name, fmt.Sprintf("%s/%s/", bucket, p.RepoPath))
+
+		parts := strings.Split(rel, "/")
+		if len(parts) != 3 {
+			continue
+		}
+		key.CommitSHA = parts[0]
+		if getPath(bucket, &key) == name {
+			keys = append(keys, parts[0])
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
 func connectToMinio(region string) (*minio.Client, error) {
 
 	endpoint := os.Getenv("s3_url")
@@ -102,8 +164,25 @@ func connectToMinio(region string) (*minio.Client, error) {
 }
 
 // getPath produces a string such as pipeline/alexellis/super-pancake-fn/commit-id/fn1/
+// signed deployment manifests and dead letters are stored next to the build log
 func getPath(bucket string, p *sdk.PipelineLog) string {
 	fileName := "build.log"
+	switch p.Source {
+	case sdk.ManifestSource:
+		fileName = "manifest.json"
+	case sdk.DeadLetterSource:
+		fileName = "dead-letter.json"
+	case sdk.HeldPushSource:
+		fileName = "held-push.json"
+	case sdk.SecretScanSource:
+		fileName = "secret-scan.json"
+	case sdk.DeliverySource:
+		fileName = "delivery.json"
+	case sdk.ScanSource:
+		fileName = "scan.json"
+	case sdk.GarbageReportSource:
+		fileName = "gc-report.json"
+	}
 	return fmt.Sprintf("%s/%s/%s/%s/%s", bucket, p.RepoPath, p.CommitSHA, p.Function, fileName)
 }
 
@@ -2,6 +2,7 @@ package function
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/openfaas/openfaas-cloud/sdk"
@@ -20,6 +21,97 @@ func Test_getPath(t *testing.T) {
 	}
 }
 
+func Test_getPath_Manifest(t *testing.T) {
+	got := getPath("pipeline", &sdk.PipelineLog{
+		RepoPath:  "alexellis/super-cake",
+		CommitSHA: "af6db",
+		Function:  "slack-fn",
+		Source:    sdk.ManifestSource,
+	})
+	want := "pipeline/alexellis/super-cake/af6db/slack-fn/manifest.json"
+	if got != want {
+		t.Errorf("got: %s, but want: %s", got, want)
+	}
+}
+
+func Test_getPath_DeadLetter(t *testing.T) {
+	got := getPath("pipeline", &sdk.PipelineLog{
+		RepoPath:  "alexellis/super-cake",
+		CommitSHA: "af6db",
+		Function:  "github-push",
+		Source:    sdk.DeadLetterSource,
+	})
+	want := "pipeline/alexellis/super-cake/af6db/github-push/dead-letter.json"
+	if got != want {
+		t.Errorf("got: %s, but want: %s", got, want)
+	}
+}
+
+func Test_getPath_HeldPush(t *testing.T) {
+	got := getPath("pipeline", &sdk.PipelineLog{
+		RepoPath:  "alexellis/super-cake",
+		CommitSHA: "af6db",
+		Function:  "git-tar",
+		Source:    sdk.HeldPushSource,
+	})
+	want := "pipeline/alexellis/super-cake/af6db/git-tar/held-push.json"
+	if got != want {
+		t.Errorf("got: %s, but want: %s", got, want)
+	}
+}
+
+func Test_getPath_SecretScan(t *testing.T) {
+	got := getPath("pipeline", &sdk.PipelineLog{
+		RepoPath:  "system",
+		CommitSHA: "secrets",
+		Function:  "garbage-collect",
+		Source:    sdk.SecretScanSource,
+	})
+	want := "pipeline/system/secrets/garbage-collect/secret-scan.json"
+	if got != want {
+		t.Errorf("got: %s, but want: %s", got, want)
+	}
+}
+
+func Test_getPath_Delivery(t *testing.T) {
+	got := getPath("pipeline", &sdk.PipelineLog{
+		RepoPath:  "system/deliveries",
+		CommitSHA: "72d3162e-cc78-11e3-81ab-4c9367dc0958",
+		Function:  "github-event",
+		Source:    sdk.DeliverySource,
+	})
+	want := "pipeline/system/deliveries/72d3162e-cc78-11e3-81ab-4c9367dc0958/github-event/delivery.json"
+	if got != want {
+		t.Errorf("got: %s, but want: %s", got, want)
+	}
+}
+
+func Test_getPath_Scan(t *testing.T) {
+	got := getPath("pipeline", &sdk.PipelineLog{
+		RepoPath:  "alexellis/super-pancake-fn",
+		CommitSHA: "ad9ab29",
+		Function:  "fn1",
+		Source:    sdk.ScanSource,
+	})
+	want := "pipeline/alexellis/super-pancake-fn/ad9ab29/fn1/scan.json"
+	if got != want {
+		t.Errorf("got: %s, but want: %s", got, want)
+	}
+}
+
+func Test_getPath_GarbageReport(t *testing.T) {
+	got := getPath("pipeline", &sdk.PipelineLog{
+		RepoPath:  "system/gc-reports",
+		CommitSHA: "9e1c0f2a7b3d4e5f",
+		Function:  "garbage-collect",
+		Source:    sdk.GarbageReportSource,
+	})
+	want := "pipeline/system/gc-reports/9e1c0f2a7b3d4e5f/garbage-collect/gc-report.json"
+	if got != want {
+		t.Errorf("got: %s, but want: %s", got, want)
+	}
+}
+
 func Test_tlsEnabled(t *testing.T) {
 	connection := []struct {
 		title         string
@@ -102,3 +194,21 @@ func Test_regionName(t *testing.T) {
 	}
 
 }
+
+func Test_keysOf(t *testing.T) {
+	p := &sdk.PipelineLog{RepoPath: "system/received/alexellis", Function: "github-event", Source: sdk.ReceivedEventSource}
+	names := []string{
+		"pipeline/system/received/alexellis/00000000001588334400-b/github-event/build.log",
+		"pipeline/system/received/alexellis/00000000001588334300-a/github-event/build.log",
+		"pipeline/system/received/alexellis/index/github-event/build.log",
+		"pipeline/system/received/alexellis/00000000001588334500-c/github-push/build.log",
+		"pipeline/system/received/alexellis/00000000001588334600-d/github-event/manifest.json",
+		"pipeline/system/received/alexellis/nested/key/github-event/build.log",
+	}
+
+	got := keysOf("pipeline", p, names)
+	want := []string{"00000000001588334300-a", "00000000001588334400-b", "index"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+}
@@ -0,0 +1,264 @@
+package function
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexellis/hmac"
+	minio "github.com/minio/minio-go"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+const (
+	// Source used for audit events
+	Source = "pipeline-watchdog"
+
+	runsPrefix = "runs"
+
+	defaultStageTimeout = 30 * time.Minute
+)
+
+// clock gives the start of a run and the time stuck runs are measured
+// from, tests replace it
+var clock sdk.Clock = sdk.SystemClock{}
+
+// Handle records the stage a pipeline run has reached when passed
+// a signed sdk.PipelineRun, or when invoked with an empty body (i.e.
+// by the cron-connector) scans for runs which have been stuck in one
+// stage for longer than the configured timeout and marks them failed.
+// The response is an sdk.Response as JSON.
+func Handle(req []byte) string {
+	return handle(req).JSON()
+}
+
+func handle(req []byte) sdk.Response {
+	region := regionName()
+	bucketName := bucketName()
+
+	minioClient, connectErr := connectToMinio(region)
+	if connectErr != nil {
+		log.Printf("S3/Minio connection error %s\n", connectErr.Error())
+		return sdk.Failed(http.StatusInternalServerError, fmt.Sprintf("%s: S3/Minio connection error: %s", Source, connectErr.Error()))
+	}
+
+	if len(req) == 0 {
+		stuck, err := scanRuns(minioClient, bucketName, clock.Now())
+		if err != nil {
+			log.Printf("error scanning pipeline runs: %s", err.Error())
+			return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("%s: error scanning pipeline runs: %s", Source, err.Error()))
+		}
+		return sdk.OK(fmt.Sprintf("%s: %d stuck runs marked failed", Source, stuck))
+	}
+
+	hmacErr := sdk.ValidHMAC(&req, "payload-secret", os.Getenv("Http_X_Cloud_Signature"))
+	if hmacErr != nil {
+		log.Printf("hmac error %s\n", hmacErr.Error())
+		return sdk.Rejected(http.StatusUnauthorized, fmt.Sprintf("%s: invalid HMAC digest: %s", Source, hmacErr.Error()))
+	}
+
+	run := sdk.PipelineRun{}
+	if err := json.Unmarshal(req, &run); err != nil {
+		log.Printf("error parsing pipeline run: %s", err.Error())
+		return sdk.Rejected(http.StatusBadRequest, fmt.Sprintf("%s: error parsing pipeline run: %s", Source, err.Error()))
+	}
+
+	fullPath := getPath(&run.Event)
+
+	if run.Stage == sdk.StageCompleted {
+		if err := minioClient.RemoveObject(bucketName, fullPath); err != nil {
+			log.Printf("error removing: %s, error: %s", fullPath, err.Error())
+		}
+		return sdk.OK(fmt.Sprintf("Removed %s", fullPath))
+	}
+
+	minioClient.MakeBucket(bucketName, region)
+
+	if run.Started.IsZero() {
+		run.Started = clock.Now()
+	}
+
+	bytesOut, _ := json.Marshal(&run)
+	reader := bytes.NewReader(bytesOut)
+	n, err := minioClient.PutObject(bucketName,
+		fullPath,
+		reader,
+		int64(reader.Len()),
+		minio.PutObjectOptions{})
+
+	if err != nil {
+		log.Printf("error writing: %s, error: %s", fullPath, err.Error())
+		return sdk.Failed(http.StatusBadGateway, fmt.Sprintf("%s: error writing %s: %s", Source, fullPath, err.Error()))
+	}
+
+	return sdk.OK(fmt.Sprintf("Wrote %d bytes to %s", n, fullPath))
+}
+
+// scanRuns marks any run which has exceeded the timeout for its
+// stage as failed, then removes it so that it is only reported once
+func scanRuns(minioClient *minio.Client, bucketName string, now time.Time) (int, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	stuck := 0
+	for object := range minioClient.ListObjects(bucketName, runsPrefix+"/", true, doneCh) {
+		if object.Err != nil {
+			return stuck, object.Err
+		}
+
+		obj, err := minioClient.GetObject(bucketName, object.Key, minio.GetObjectOptions{})
+		if err != nil {
+			log.Printf("error reading: %s, error: %s", object.Key, err.Error())
+			continue
+		}
+
+		runBytes, _ := ioutil.ReadAll(obj)
+		obj.Close()
+
+		run := sdk.PipelineRun{}
+		if err := json.Unmarshal(runBytes, &run); err != nil {
+			log.Printf("error parsing: %s, error: %s", object.Key, err.Error())
+			continue
+		}
+
+		timeout := stageTimeout(run.Stage)
+		if !isStuck(&run, timeout, now) {
+			continue
+		}
+
+		markFailed(&run, timeout)
+
+		if err := minioClient.RemoveObject(bucketName, object.Key); err != nil {
+			log.Printf("error removing: %s, error: %s", object.Key, err.Error())
+		}
+		stuck++
+	}
+
+	return stuck, nil
+}
+
+func isStuck(run *sdk.PipelineRun, timeout time.Duration, now time.Time) bool {
+	return now.Sub(run.Started) > timeout
+}
+
+// markFailed updates the commit status for a stuck run and alerts
+// operators through the audit-event function
+func markFailed(run *sdk.PipelineRun, timeout time.Duration) {
+	msg := fmt.Sprintf("pipeline stuck in %s for over %s", run.Stage, timeout)
+	log.Printf("%s/%s %s: %s", run.Event.Owner, run.Event.Repository, run.Event.Service, msg)
+
+	status := sdk.BuildStatus(&run.Event, sdk.EmptyAuthToken)
+	status.AddStatus(sdk.StatusFailure, msg, sdk.BuildFunctionContext(run.Event.Service))
+	if err := reportStatus(status); err != nil {
+		log.Printf("failed to report status, error: %s", err.Error())
+	}
+
+	sdk.PostAudit(sdk.AuditEvent{
+		Source:  Source,
+		Owner:   run.Event.Owner,
+		Repo:    run.Event.Repository,
+		Message: fmt.Sprintf("%s: %s (sha: %s)", run.Event.Service, msg, run.Event.SHA),
+	})
+}
+
+func reportStatus(status *sdk.Status) error {
+	if os.Getenv("report_status") != "true" {
+		return nil
+	}
+
+	payloadSecret, err := sdk.ReadSecret("payload-secret")
+	if err != nil {
+		return err
+	}
+
+	gatewayURL := os.Getenv("gateway_url")
+
+	if status.EventInfo.SCM == "gitlab" {
+		statusBytes, _ := json.Marshal(status)
+
+		req, _ := http.NewRequest(http.MethodPost, gatewayURL+"function/gitlab-status", bytes.NewReader(statusBytes))
+		digest := hmac.Sign(statusBytes, []byte(payloadSecret))
+		req.Header.Add(sdk.CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+		res, err := sdk.HTTPClient().Do(req)
+		if err != nil {
+			return err
+		}
+		if res.Body != nil {
+			defer res.Body.Close()
+		}
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status code from gitlab-status: %d", res.StatusCode)
+		}
+		return nil
+	}
+
+	_, err = status.Report(gatewayURL, payloadSecret)
+	return err
+}
+
+// stageTimeout reads the timeout for a stage from an env-var such as
+// stage_timeout_building=30m, falling back to stage_timeout and then
+// to a default of 30 minutes.
+func stageTimeout(stage string) time.Duration {
+	for _, key := range []string{"stage_timeout_" + strings.ToLower(stage), "stage_timeout"} {
+		if val, ok := os.LookupEnv(key); ok && len(val) > 0 {
+			timeout, err := time.ParseDuration(val)
+			if err != nil {
+				log.Printf("unable to parse %s: %s", key, err.Error())
+				continue
+			}
+			return timeout
+		}
+	}
+
+	return defaultStageTimeout
+}
+
+// getPath produces a string such as runs/alexellis/super-pancake-fn/commit-id/fn1.json
+func getPath(event *sdk.Event) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s.json", runsPrefix, event.Owner, event.Repository, event.SHA, event.Service)
+}
+
+func connectToMinio(region string) (*minio.Client, error) {
+
+	endpoint := os.Getenv("s3_url")
+
+	tlsEnabled := tlsEnabled()
+
+	secretKey, _ := sdk.ReadSecret("s3-secret-key")
+	accessKey, _ := sdk.ReadSecret("s3-access-key")
+
+	return minio.New(endpoint, accessKey, secretKey, tlsEnabled)
+}
+
+func tlsEnabled() bool {
+	if connection := os.Getenv("s3_tls"); connection == "true" || connection == "1" {
+		return true
+	}
+	return false
+}
+
+func bucketName() string {
+	bucketName, exist := os.LookupEnv("s3_bucket")
+	if exist == false || len(bucketName) == 0 {
+		bucketName = "pipeline"
+		log.Printf("Bucket name not found, set to default: %v\n", bucketName)
+	}
+	return bucketName
+}
+
+func regionName() string {
+	regionName, exist := os.LookupEnv("s3_region")
+	if exist == false || len(regionName) == 0 {
+		regionName = "us-east-1"
+	}
+	return regionName
+}
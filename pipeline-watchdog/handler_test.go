@@ -0,0 +1,105 @@
+package function
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_getPath(t *testing.T) {
+	got := getPath(&sdk.Event{
+		Owner:      "alexellis",
+		Repository: "super-cake",
+		SHA:        "af6db",
+		Service:    "slack-fn",
+	})
+	want := "runs/alexellis/super-cake/af6db/slack-fn.json"
+	if got != want {
+		t.Errorf("got: %s, but want: %s", got, want)
+	}
+}
+
+func Test_stageTimeout(t *testing.T) {
+	cases := []struct {
+		title   string
+		env     map[string]string
+		stage   string
+		timeout time.Duration
+	}{
+		{
+			title:   "Default when unset",
+			env:     map[string]string{},
+			stage:   sdk.StageBuilding,
+			timeout: defaultStageTimeout,
+		},
+		{
+			title:   "Per-stage override",
+			env:     map[string]string{"stage_timeout_building": "45m"},
+			stage:   sdk.StageBuilding,
+			timeout: 45 * time.Minute,
+		},
+		{
+			title:   "Global override",
+			env:     map[string]string{"stage_timeout": "5m"},
+			stage:   sdk.StageDeploying,
+			timeout: 5 * time.Minute,
+		},
+		{
+			title:   "Invalid value falls back to default",
+			env:     map[string]string{"stage_timeout_deploying": "soon"},
+			stage:   sdk.StageDeploying,
+			timeout: defaultStageTimeout,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			for k, v := range c.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			got := stageTimeout(c.stage)
+			if got != c.timeout {
+				t.Errorf("want: %s, got: %s", c.timeout, got)
+			}
+		})
+	}
+}
+
+func Test_isStuck(t *testing.T) {
+	now := time.Now()
+	run := &sdk.PipelineRun{Stage: sdk.StageBuilding, Started: now.Add(-31 * time.Minute)}
+
+	if !isStuck(run, 30*time.Minute, now) {
+		t.Errorf("want run started 31m ago to be stuck with a 30m timeout")
+	}
+
+	run.Started = now.Add(-10 * time.Minute)
+	if isStuck(run, 30*time.Minute, now) {
+		t.Errorf("want run started 10m ago not to be stuck with a 30m timeout")
+	}
+}
+
+func Test_handle_ErrorsAreResponses(t *testing.T) {
+	os.Setenv("s3_url", "127.0.0.1:9000")
+	defer os.Unsetenv("s3_url")
+
+	t.Run("unsigned run is rejected", func(t *testing.T) {
+		res := handle([]byte(`{"stage":"build"}`))
+		if res.Status != sdk.ResponseRejected || res.Code != http.StatusUnauthorized {
+			t.Errorf("want rejected with %d, got %s with %d", http.StatusUnauthorized, res.Status, res.Code)
+		}
+	})
+
+	t.Run("unreachable store fails the scan", func(t *testing.T) {
+		os.Setenv("s3_url", "127.0.0.1:1")
+		res := handle([]byte{})
+		if res.Status != sdk.ResponseFailed || res.Code != http.StatusBadGateway {
+			t.Errorf("want failed with %d, got %s with %d", http.StatusBadGateway, res.Status, res.Code)
+		}
+	})
+}
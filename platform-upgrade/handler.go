@@ -0,0 +1,544 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+	"github.com/openfaas/faas-cli/stack"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// Source name for audit events
+const Source = "platform-upgrade"
+
+// errNotFound is returned for a function which is not deployed, i.e.
+// the GitLab functions on an installation for GitHub
+var errNotFound = errors.New("function not found")
+
+// defaultOrder upgrades the functions which others call before their
+// callers, so that a new caller never reaches an old dependency. The
+// core Deployments are placed by the same rule: of-builder before
+// buildshiprun, and edge-auth and edge-router after the functions they
+// route to.
+var defaultOrder = []string{
+	"audit-event",
+	"pipeline-log",
+	"pipeline-watchdog",
+	"github-status",
+	"gitlab-status",
+	"bitbucket-status",
+	"import-secrets",
+	"garbage-collect",
+	"list-functions",
+	"cost-estimate",
+	"metrics",
+	"function-logs",
+	"of-builder",
+	"buildshiprun",
+	"git-tar",
+	"github-push",
+	"gitlab-push",
+	"event-consumer",
+	"system-github-event",
+	"system-gitlab-event",
+	"system-bitbucket-event",
+	"system-git-event",
+	"system-dashboard",
+	"edge-auth",
+	"edge-router",
+}
+
+// coreDeployments are not functions, they are upgraded by setting the
+// image of their Deployment in core_namespace
+var coreDeployments = map[string]bool{
+	"edge-router": true,
+	"edge-auth":   true,
+	"of-builder":  true,
+}
+
+//FaaSAuth Authentication type for OpenFaaS
+type FaaSAuth struct {
+}
+
+//Set add basic authentication to the request
+func (auth *FaaSAuth) Set(req *http.Request) error {
+	return sdk.AddBasicAuth(req)
+}
+
+// UpgradeRequest selects the version to upgrade the platform to
+type UpgradeRequest struct {
+	// Tag replaces the tag of each function's current image
+	Tag string `json:"tag"`
+	// Images overrides the image for a function, i.e. to change registry
+	Images map[string]string `json:"images,omitempty"`
+	// Functions limits the upgrade to these functions, in upgrade order
+	Functions []string `json:"functions,omitempty"`
+	// DryRun returns the plan without deploying
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// Step is the upgrade of a single function
+type Step struct {
+	Function      string `json:"function"`
+	PreviousImage string `json:"previousImage"`
+	Image         string `json:"image"`
+	Upgraded      bool   `json:"upgraded"`
+	RolledBack    bool   `json:"rolledBack,omitempty"`
+}
+
+// UpgradeResult is the response of the function
+type UpgradeResult struct {
+	Steps      []Step `json:"steps"`
+	RolledBack bool   `json:"rolledBack"`
+	Error      string `json:"error,omitempty"`
+}
+
+// functionSpec is a function as returned by the provider, the env-vars,
+// secrets and resources are returned by faas-netes 0.12 and newer
+type functionSpec struct {
+	Name                   string             `json:"name"`
+	Image                  string             `json:"image"`
+	Namespace              string             `json:"namespace,omitempty"`
+	EnvProcess             string             `json:"envProcess"`
+	EnvVars                map[string]string  `json:"envVars"`
+	Constraints            []string           `json:"constraints"`
+	Secrets                []string           `json:"secrets"`
+	Labels                 *map[string]string `json:"labels"`
+	Annotations            *map[string]string `json:"annotations"`
+	Limits                 *resources         `json:"limits"`
+	Requests               *resources         `json:"requests"`
+	ReadOnlyRootFilesystem bool               `json:"readOnlyRootFilesystem"`
+	Replicas               uint64             `json:"replicas"`
+	AvailableReplicas      uint64             `json:"availableReplicas"`
+}
+
+type resources struct {
+	Memory string `json:"memory"`
+	CPU    string `json:"cpu"`
+}
+
+// Handle upgrades the OpenFaaS Cloud system functions to a new version
+// one at a time in dependency order. Each function must become ready
+// before the next is upgraded, then the self-check is run. On any
+// failure every upgraded function is rolled back to its previous image.
+// The request must be signed with the payload-secret.
+func Handle(req []byte) string {
+	if err := sdk.ValidHMAC(&req, "payload-secret", os.Getenv("Http_X_Cloud_Signature")); err != nil {
+		return fmt.Sprintf("invalid HMAC digest: %s", err.Error())
+	}
+
+	upgradeReq := UpgradeRequest{}
+	if err := json.Unmarshal(req, &upgradeReq); err != nil {
+		return fmt.Sprintf("unable to parse request: %s", err.Error())
+	}
+
+	if len(upgradeReq.Tag) == 0 && len(upgradeReq.Images) == 0 {
+		return "a tag or images are required i.e. {\"tag\": \"0.14.0\"}"
+	}
+
+	gatewayURL := os.Getenv("gateway_url")
+	timeout := getDuration("gateway_timeout", 10*time.Second)
+	client := faasSDK.NewClient(&FaaSAuth{}, gatewayURL, nil, &timeout)
+
+	u := upgrader{
+		client:       client,
+		gatewayURL:   gatewayURL,
+		namespace:    os.Getenv("system_namespace"),
+		readyTimeout: getDuration("ready_timeout", 2*time.Minute),
+		interval:     2 * time.Second,
+		selfCheck:    os.Getenv("self_check_function"),
+		rollout:      getRollout(),
+	}
+	if u.rollout != nil {
+		u.coreNamespace = getCoreNamespace()
+	}
+
+	result := u.upgrade(context.Background(), upgradeReq, getOrder())
+
+	msg := fmt.Sprintf("platform upgrade to %s succeeded", upgradeReq.Tag)
+	if len(result.Error) > 0 {
+		msg = fmt.Sprintf("platform upgrade to %s failed: %s, rolled back: %t", upgradeReq.Tag, result.Error, result.RolledBack)
+	}
+	if !upgradeReq.DryRun {
+		sdk.PostAudit(sdk.AuditEvent{Source: Source, Message: msg})
+	}
+	log.Println(msg)
+
+	bytesOut, _ := json.Marshal(result)
+	return string(bytesOut)
+}
+
+// getOrder reads upgrade_order, a comma-separated list of functions,
+// falling back to defaultOrder
+func getOrder() []string {
+	order := []string{}
+	for _, name := range strings.Split(os.Getenv("upgrade_order"), ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			order = append(order, name)
+		}
+	}
+
+	if len(order) == 0 {
+		return defaultOrder
+	}
+	return order
+}
+
+// getCoreNamespace reads core_namespace, the namespace of edge-router,
+// edge-auth and of-builder
+func getCoreNamespace() string {
+	if val, exists := os.LookupEnv("core_namespace"); exists && len(val) > 0 {
+		return val
+	}
+	return "openfaas"
+}
+
+type upgrader struct {
+	client        *faasSDK.Client
+	gatewayURL    string
+	namespace     string
+	coreNamespace string
+	readyTimeout  time.Duration
+	interval      time.Duration
+	// selfCheck is invoked once every function is upgraded and must
+	// return a 2xx status
+	selfCheck string
+	// rollout reads the Deployment's rollout from Kubernetes in place of
+	// the replicas reported by the gateway, and upgrades the core
+	// Deployments
+	rollout *kubeRollout
+}
+
+type planned struct {
+	step Step
+	spec *functionSpec
+}
+
+// upgrade resolves the plan before deploying anything, so that a
+// function which cannot be read fails the upgrade without changes.
+// Functions which are not deployed are skipped, as are the core
+// Deployments without rollout_status since they are only reachable
+// through Kubernetes.
+func (u *upgrader) upgrade(ctx context.Context, req UpgradeRequest, order []string) UpgradeResult {
+	result := UpgradeResult{Steps: []Step{}}
+
+	plan := []planned{}
+	for _, name := range selectFunctions(order, req.Functions) {
+		if coreDeployments[name] && u.rollout == nil {
+			log.Printf("Skipping %s, rollout_status=true is required to upgrade it", name)
+			continue
+		}
+
+		spec, err := u.getCurrent(name)
+		if err == errNotFound {
+			log.Printf("Skipping %s, not deployed", name)
+			continue
+		}
+		if err != nil {
+			result.Error = fmt.Sprintf("unable to read %s: %s", name, err.Error())
+			return result
+		}
+
+		image := req.Images[name]
+		if len(image) == 0 {
+			image = retag(spec.Image, req.Tag)
+		}
+
+		plan = append(plan, planned{
+			step: Step{Function: name, PreviousImage: spec.Image, Image: image},
+			spec: spec,
+		})
+	}
+
+	if req.DryRun {
+		for _, p := range plan {
+			result.Steps = append(result.Steps, p.step)
+		}
+		return result
+	}
+
+	done := []planned{}
+	var upgradeErr error
+
+	for i := range plan {
+		p := &plan[i]
+		if p.step.Image == p.step.PreviousImage {
+			continue
+		}
+
+		log.Printf("Upgrading %s from %s to %s", p.step.Function, p.step.PreviousImage, p.step.Image)
+
+		// Record the step before deploying, so that a failed or partial
+		// deployment is rolled back too
+		done = append(done, *p)
+
+		if upgradeErr = u.deploy(ctx, p.spec, p.step.Image); upgradeErr != nil {
+			upgradeErr = fmt.Errorf("%s: %s", p.step.Function, upgradeErr.Error())
+			break
+		}
+
+		if upgradeErr = u.waitReady(p.step.Function, p.step.Image); upgradeErr != nil {
+			upgradeErr = fmt.Errorf("%s: %s", p.step.Function, upgradeErr.Error())
+			break
+		}
+
+		p.step.Upgraded = true
+	}
+
+	if upgradeErr == nil && len(u.selfCheck) > 0 && len(done) > 0 {
+		upgradeErr = u.runSelfCheck()
+	}
+
+	if upgradeErr != nil {
+		result.Error = upgradeErr.Error()
+		result.RolledBack = u.rollback(ctx, plan, done)
+	}
+
+	for _, p := range plan {
+		result.Steps = append(result.Steps, p.step)
+	}
+
+	return result
+}
+
+// rollback redeploys the previous image of each function which was
+// changed, in reverse order
+func (u *upgrader) rollback(ctx context.Context, plan []planned, done []planned) bool {
+	ok := true
+
+	for i := len(done) - 1; i >= 0; i-- {
+		p := done[i]
+		log.Printf("Rolling back %s to %s", p.step.Function, p.step.PreviousImage)
+
+		if err := u.deploy(ctx, p.spec, p.step.PreviousImage); err != nil {
+			log.Printf("rollback of %s failed: %s", p.step.Function, err.Error())
+			ok = false
+			continue
+		}
+
+		for j := range plan {
+			if plan[j].step.Function == p.step.Function {
+				plan[j].step.RolledBack = true
+			}
+		}
+	}
+
+	return ok
+}
+
+// selectFunctions keeps the upgrade order while limiting it to the
+// requested functions
+func selectFunctions(order []string, requested []string) []string {
+	if len(requested) == 0 {
+		return order
+	}
+
+	selected := []string{}
+	for _, name := range order {
+		for _, r := range requested {
+			if r == name {
+				selected = append(selected, name)
+				break
+			}
+		}
+	}
+
+	return selected
+}
+
+// retag replaces the tag of an image, keeping any registry port
+func retag(image string, tag string) string {
+	if len(tag) == 0 {
+		return image
+	}
+
+	name := image
+	if index := strings.Index(name, "@"); index > -1 {
+		name = name[:index]
+	}
+	if index := strings.LastIndex(name, ":"); index > strings.LastIndex(name, "/") {
+		name = name[:index]
+	}
+
+	return name + ":" + tag
+}
+
+// getCurrent reads a function from the gateway, or the image of a core
+// Deployment from Kubernetes
+func (u *upgrader) getCurrent(name string) (*functionSpec, error) {
+	if !coreDeployments[name] {
+		return u.getFunction(name)
+	}
+
+	image, err := u.rollout.Image(name, u.coreNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return &functionSpec{Name: name, Image: image}, nil
+}
+
+func (u *upgrader) getFunction(name string) (*functionSpec, error) {
+	uri := u.gatewayURL + "system/function/" + name
+	if len(u.namespace) > 0 {
+		uri = uri + "?namespace=" + u.namespace
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, uri, nil)
+	if err := sdk.AddBasicAuth(req); err != nil {
+		return nil, err
+	}
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	spec := functionSpec{}
+	if err := json.Unmarshal(body, &spec); err != nil {
+		return nil, err
+	}
+
+	return &spec, nil
+}
+
+// deploy applies the function's current configuration with a new image,
+// a core Deployment only has its image replaced
+func (u *upgrader) deploy(ctx context.Context, spec *functionSpec, image string) error {
+	if coreDeployments[spec.Name] {
+		return u.rollout.SetImage(spec.Name, u.coreNamespace, image)
+	}
+
+	deploy := &faasSDK.DeployFunctionSpec{
+		FunctionName:           spec.Name,
+		Namespace:              u.namespace,
+		Image:                  image,
+		FProcess:               spec.EnvProcess,
+		EnvVars:                spec.EnvVars,
+		Secrets:                spec.Secrets,
+		Constraints:            spec.Constraints,
+		ReadOnlyRootFilesystem: spec.ReadOnlyRootFilesystem,
+		Update:                 true,
+		FunctionResourceRequest: faasSDK.FunctionResourceRequest{
+			Limits:   &stack.FunctionResources{},
+			Requests: &stack.FunctionResources{},
+		},
+	}
+
+	if spec.Labels != nil {
+		deploy.Labels = *spec.Labels
+	}
+	if spec.Annotations != nil {
+		deploy.Annotations = *spec.Annotations
+	}
+	if spec.Limits != nil {
+		deploy.FunctionResourceRequest.Limits.Memory = spec.Limits.Memory
+		deploy.FunctionResourceRequest.Limits.CPU = spec.Limits.CPU
+	}
+	if spec.Requests != nil {
+		deploy.FunctionResourceRequest.Requests.Memory = spec.Requests.Memory
+		deploy.FunctionResourceRequest.Requests.CPU = spec.Requests.CPU
+	}
+
+	status := u.client.DeployFunction(ctx, deploy)
+	if status < 200 || status > 299 {
+		return fmt.Errorf("deploy of %s returned status %d", image, status)
+	}
+
+	return nil
+}
+
+// waitReady waits until the rollout of the image is complete, an error
+// reading the rollout is retried until readyTimeout
+func (u *upgrader) waitReady(name string, image string) error {
+	deadline := time.Now().Add(u.readyTimeout)
+
+	for {
+		ready, err := u.ready(name, image)
+		if err == nil && ready {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("not ready after %s: %s", u.readyTimeout, err.Error())
+			}
+			return fmt.Errorf("not ready after %s: rollout of %s is not complete", u.readyTimeout, image)
+		}
+
+		time.Sleep(u.interval)
+	}
+}
+
+// ready is true once every replica runs the image. Without a rollout
+// the gateway only reports the available replicas, which are compared
+// with the desired replicas once it reports the new image.
+func (u *upgrader) ready(name string, image string) (bool, error) {
+	if coreDeployments[name] {
+		return u.rollout.Ready(name, u.coreNamespace, image)
+	}
+	if u.rollout != nil {
+		return u.rollout.Ready(name, u.namespace, image)
+	}
+
+	spec, err := u.getFunction(name)
+	if err != nil {
+		return false, err
+	}
+	return spec.Image == image && spec.Replicas > 0 && spec.AvailableReplicas >= spec.Replicas, nil
+}
+
+// runSelfCheck invokes the self-check function, which verifies the
+// platform end-to-end
+func (u *upgrader) runSelfCheck() error {
+	req, _ := http.NewRequest(http.MethodPost, u.gatewayURL+"function/"+u.selfCheck, bytes.NewReader([]byte{}))
+
+	res, err := sdk.HTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("self-check %s: %s", u.selfCheck, err.Error())
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("self-check %s: status %d: %s", u.selfCheck, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if val, exists := os.LookupEnv(key); exists && len(val) > 0 {
+		duration, err := time.ParseDuration(val)
+		if err != nil {
+			log.Printf("unable to parse %s: %s", key, err.Error())
+			return defaultValue
+		}
+		return duration
+	}
+	return defaultValue
+}
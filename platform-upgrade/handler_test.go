@@ -0,0 +1,316 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	faasSDK "github.com/openfaas/faas-cli/proxy"
+)
+
+func Test_retag(t *testing.T) {
+	cases := []struct {
+		image string
+		tag   string
+		want  string
+	}{
+		{"openfaas/of-buildshiprun:0.13.0", "0.14.0", "openfaas/of-buildshiprun:0.14.0"},
+		{"openfaas/of-buildshiprun", "0.14.0", "openfaas/of-buildshiprun:0.14.0"},
+		{"registry:5000/openfaas/git-tar:0.13.0", "0.14.0", "registry:5000/openfaas/git-tar:0.14.0"},
+		{"registry:5000/openfaas/git-tar", "0.14.0", "registry:5000/openfaas/git-tar:0.14.0"},
+		{"openfaas/git-tar@sha256:abc", "0.14.0", "openfaas/git-tar:0.14.0"},
+		{"openfaas/git-tar:0.13.0", "", "openfaas/git-tar:0.13.0"},
+	}
+
+	for _, c := range cases {
+		if got := retag(c.image, c.tag); got != c.want {
+			t.Errorf("retag(%q, %q): want %q, got %q", c.image, c.tag, c.want, got)
+		}
+	}
+}
+
+func Test_selectFunctions_KeepsOrder(t *testing.T) {
+	got := selectFunctions([]string{"audit-event", "buildshiprun", "git-tar"}, []string{"git-tar", "audit-event"})
+
+	if strings.Join(got, ",") != "audit-event,git-tar" {
+		t.Errorf("want audit-event,git-tar, got %v", got)
+	}
+}
+
+// fakeGateway holds the image of each function and fails deployments
+// of the images in failImages
+type fakeGateway struct {
+	images     map[string]string
+	failImages map[string]bool
+	deployed   []string
+	mutex      sync.Mutex
+}
+
+func (g *fakeGateway) handler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/system/function/"):
+			name := strings.TrimPrefix(r.URL.Path, "/system/function/")
+			image, ok := g.images[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(functionSpec{
+				Name:              name,
+				Image:             image,
+				EnvVars:           map[string]string{"write_debug": "true"},
+				Secrets:           []string{"payload-secret"},
+				Replicas:          1,
+				AvailableReplicas: 1,
+			})
+		case r.URL.Path == "/system/functions" && r.Method == http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			spec := map[string]interface{}{}
+			json.Unmarshal(body, &spec)
+
+			name := spec["service"].(string)
+			image := spec["image"].(string)
+			g.deployed = append(g.deployed, name+"="+image)
+
+			if g.failImages[image] {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			g.images[name] = image
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/function/self-check":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/function/missing-check":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func newUpgrader(url string) upgrader {
+	timeout := 5 * time.Second
+	return upgrader{
+		client:       faasSDK.NewClient(&FaaSAuth{}, url, nil, &timeout),
+		gatewayURL:   url + "/",
+		readyTimeout: time.Second,
+		interval:     10 * time.Millisecond,
+	}
+}
+
+func Test_upgrade_DeploysInOrder(t *testing.T) {
+	g := &fakeGateway{
+		images: map[string]string{
+			"audit-event":  "openfaas/audit-event:0.1.0",
+			"buildshiprun": "openfaas/of-buildshiprun:0.1.0",
+		},
+	}
+	s := httptest.NewServer(g.handler(t))
+	defer s.Close()
+
+	u := newUpgrader(s.URL)
+	u.selfCheck = "self-check"
+
+	result := u.upgrade(context.Background(), UpgradeRequest{Tag: "0.2.0"}, []string{"audit-event", "buildshiprun"})
+
+	if len(result.Error) > 0 {
+		t.Fatalf("want no error, got %s", result.Error)
+	}
+
+	want := "audit-event=openfaas/audit-event:0.2.0,buildshiprun=openfaas/of-buildshiprun:0.2.0"
+	if got := strings.Join(g.deployed, ","); got != want {
+		t.Errorf("want deployments %s, got %s", want, got)
+	}
+
+	for _, step := range result.Steps {
+		if !step.Upgraded {
+			t.Errorf("want %s upgraded", step.Function)
+		}
+	}
+}
+
+func Test_upgrade_RollsBackOnFailure(t *testing.T) {
+	g := &fakeGateway{
+		images: map[string]string{
+			"audit-event":  "openfaas/audit-event:0.1.0",
+			"buildshiprun": "openfaas/of-buildshiprun:0.1.0",
+			"git-tar":      "openfaas/of-git-tar:0.1.0",
+		},
+		failImages: map[string]bool{"openfaas/of-buildshiprun:0.2.0": true},
+	}
+	s := httptest.NewServer(g.handler(t))
+	defer s.Close()
+
+	u := newUpgrader(s.URL)
+
+	result := u.upgrade(context.Background(), UpgradeRequest{Tag: "0.2.0"}, []string{"audit-event", "buildshiprun", "git-tar"})
+
+	if !strings.Contains(result.Error, "buildshiprun") {
+		t.Errorf("want buildshiprun error, got %q", result.Error)
+	}
+	if !result.RolledBack {
+		t.Errorf("want rolled back")
+	}
+
+	want := "audit-event=openfaas/audit-event:0.2.0," +
+		"buildshiprun=openfaas/of-buildshiprun:0.2.0," +
+		"buildshiprun=openfaas/of-buildshiprun:0.1.0," +
+		"audit-event=openfaas/audit-event:0.1.0"
+	if got := strings.Join(g.deployed, ","); got != want {
+		t.Errorf("want deployments %s, got %s", want, got)
+	}
+
+	if g.images["audit-event"] != "openfaas/audit-event:0.1.0" {
+		t.Errorf("want audit-event restored, got %s", g.images["audit-event"])
+	}
+}
+
+func Test_upgrade_SkipsMissingFunction(t *testing.T) {
+	g := &fakeGateway{
+		images: map[string]string{"audit-event": "openfaas/audit-event:0.1.0"},
+	}
+	s := httptest.NewServer(g.handler(t))
+	defer s.Close()
+
+	u := newUpgrader(s.URL)
+
+	result := u.upgrade(context.Background(), UpgradeRequest{Tag: "0.2.0"}, []string{"audit-event", "system-gitlab-event"})
+
+	if len(result.Error) > 0 {
+		t.Errorf("want no error, got %q", result.Error)
+	}
+	if len(result.Steps) != 1 || result.Steps[0].Function != "audit-event" {
+		t.Errorf("want only audit-event planned, got %v", result.Steps)
+	}
+}
+
+func Test_upgrade_SelfCheckFailureRollsBack(t *testing.T) {
+	g := &fakeGateway{
+		images: map[string]string{"audit-event": "openfaas/audit-event:0.1.0"},
+	}
+	s := httptest.NewServer(g.handler(t))
+	defer s.Close()
+
+	u := newUpgrader(s.URL)
+	u.selfCheck = "missing-check"
+
+	result := u.upgrade(context.Background(), UpgradeRequest{Tag: "0.2.0"}, []string{"audit-event"})
+
+	if !strings.Contains(result.Error, "self-check missing-check") {
+		t.Errorf("want self-check error, got %q", result.Error)
+	}
+	if g.images["audit-event"] != "openfaas/audit-event:0.1.0" {
+		t.Errorf("want audit-event restored, got %s", g.images["audit-event"])
+	}
+	if !result.Steps[0].RolledBack {
+		t.Errorf("want audit-event marked as rolled back")
+	}
+}
+
+func Test_upgrade_DryRun(t *testing.T) {
+	g := &fakeGateway{
+		images: map[string]string{"audit-event": "openfaas/audit-event:0.1.0"},
+	}
+	s := httptest.NewServer(g.handler(t))
+	defer s.Close()
+
+	u := newUpgrader(s.URL)
+
+	result := u.upgrade(context.Background(), UpgradeRequest{Tag: "0.2.0", DryRun: true}, []string{"audit-event"})
+
+	if len(g.deployed) > 0 {
+		t.Errorf("want nothing deployed, got %v", g.deployed)
+	}
+	if len(result.Steps) != 1 || result.Steps[0].Image != "openfaas/audit-event:0.2.0" {
+		t.Errorf("want planned image openfaas/audit-event:0.2.0, got %v", result.Steps)
+	}
+}
+
+func Test_waitReady_WaitsForNewImage(t *testing.T) {
+	g := &fakeGateway{images: map[string]string{"audit-event": "openfaas/audit-event:0.1.0"}}
+	s := httptest.NewServer(g.handler(t))
+	defer s.Close()
+
+	u := newUpgrader(s.URL)
+
+	if err := u.waitReady("audit-event", "openfaas/audit-event:0.2.0"); err == nil {
+		t.Errorf("want an error while the gateway reports the previous image")
+	}
+	if err := u.waitReady("audit-event", "openfaas/audit-event:0.1.0"); err != nil {
+		t.Errorf("want ready, got %s", err)
+	}
+}
+
+func Test_upgrade_CoreDeployments(t *testing.T) {
+	g := &fakeGateway{images: map[string]string{}}
+	s := httptest.NewServer(g.handler(t))
+	defer s.Close()
+
+	var mutex sync.Mutex
+	image := "openfaas/of-builder:0.1.0"
+	patched := ""
+	kube := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.URL.Path != "/apis/apps/v1/namespaces/openfaas/deployments/of-builder" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			body, _ := ioutil.ReadAll(r.Body)
+			patch := []map[string]string{}
+			json.Unmarshal(body, &patch)
+			image = patch[0]["value"]
+			patched = image
+		}
+		w.Write([]byte(deployment(image, 2, 2, 2)))
+	}))
+	defer kube.Close()
+
+	u := newUpgrader(s.URL)
+	u.rollout = &kubeRollout{BaseURL: kube.URL, Client: http.DefaultClient}
+	u.coreNamespace = "openfaas"
+
+	result := u.upgrade(context.Background(), UpgradeRequest{Tag: "0.2.0"}, []string{"of-builder", "edge-router"})
+
+	if len(result.Error) > 0 {
+		t.Fatalf("want no error, got %s", result.Error)
+	}
+	if patched != "openfaas/of-builder:0.2.0" {
+		t.Errorf("want of-builder patched to openfaas/of-builder:0.2.0, got %q", patched)
+	}
+	if len(result.Steps) != 1 || result.Steps[0].Function != "of-builder" || !result.Steps[0].Upgraded {
+		t.Errorf("want of-builder upgraded and the missing edge-router skipped, got %v", result.Steps)
+	}
+}
+
+func Test_upgrade_CoreDeploymentsNeedRollout(t *testing.T) {
+	g := &fakeGateway{
+		images: map[string]string{"audit-event": "openfaas/audit-event:0.1.0"},
+	}
+	s := httptest.NewServer(g.handler(t))
+	defer s.Close()
+
+	u := newUpgrader(s.URL)
+
+	result := u.upgrade(context.Background(), UpgradeRequest{Tag: "0.2.0"}, []string{"audit-event", "edge-router"})
+
+	if len(result.Error) > 0 {
+		t.Errorf("want no error, got %q", result.Error)
+	}
+	if len(result.Steps) != 1 || result.Steps[0].Function != "audit-event" {
+		t.Errorf("want only audit-event planned, got %v", result.Steps)
+	}
+}
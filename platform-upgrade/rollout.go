@@ -0,0 +1,191 @@
+package function
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccountPath holds the token and CA of platform-upgrade's
+// service account when it runs on Kubernetes
+const serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// kubeRollout reads the rollout status of a function's Deployment from
+// the Kubernetes API
+type kubeRollout struct {
+	BaseURL string
+	Token   string
+	// Namespace is used when system_namespace is not set
+	Namespace string
+	Client    *http.Client
+}
+
+// getRollout is enabled with rollout_status=true on Kubernetes and uses
+// platform-upgrade's service account, which needs to get deployments in
+// the system functions' namespace, and to get and patch the core
+// Deployments in core_namespace.
+func getRollout() *kubeRollout {
+	if os.Getenv("rollout_status") != "true" {
+		return nil
+	}
+
+	rollout := &kubeRollout{
+		BaseURL:   "https://" + os.Getenv("KUBERNETES_SERVICE_HOST") + ":" + os.Getenv("KUBERNETES_SERVICE_PORT"),
+		Namespace: "openfaas-fn",
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if token, err := ioutil.ReadFile(serviceAccountPath + "token"); err == nil {
+		rollout.Token = strings.TrimSpace(string(token))
+	}
+
+	if ca, err := ioutil.ReadFile(serviceAccountPath + "ca.crt"); err == nil {
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(ca) {
+			rollout.Client.Transport = &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			}
+		}
+	}
+
+	return rollout
+}
+
+type deploymentStatus struct {
+	Metadata struct {
+		Generation int64 `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas *int32 `json:"replicas"`
+		Template struct {
+			Spec struct {
+				Containers []struct {
+					Image string `json:"image"`
+				} `json:"containers"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration int64 `json:"observedGeneration"`
+		Replicas           int32 `json:"replicas"`
+		UpdatedReplicas    int32 `json:"updatedReplicas"`
+		AvailableReplicas  int32 `json:"availableReplicas"`
+		Conditions         []struct {
+			Type    string `json:"type"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// Ready is true once the Deployment runs the image and every replica has
+// been updated and is available, so that no replica of the previous
+// image is left. An error is returned when the progress deadline is
+// exceeded.
+func (k *kubeRollout) Ready(functionName, namespace, image string) (bool, error) {
+	if len(namespace) == 0 {
+		namespace = k.Namespace
+	}
+
+	deployment := deploymentStatus{}
+	if err := k.get(fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments/%s", namespace, functionName), &deployment); err != nil {
+		return false, err
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == "Progressing" && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, fmt.Errorf("rollout failed: %s: %s", condition.Reason, condition.Message)
+		}
+	}
+
+	containers := deployment.Spec.Template.Spec.Containers
+	if len(containers) == 0 || containers[0].Image != image {
+		return false, nil
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+
+	ready := deployment.Status.ObservedGeneration >= deployment.Metadata.Generation &&
+		deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.Replicas == deployment.Status.UpdatedReplicas &&
+		deployment.Status.AvailableReplicas == replicas &&
+		replicas > 0
+
+	return ready, nil
+}
+
+// Image gives the image of a Deployment's first container, errNotFound
+// is returned when there is no such Deployment
+func (k *kubeRollout) Image(name, namespace string) (string, error) {
+	deployment := deploymentStatus{}
+	if err := k.get(fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments/%s", namespace, name), &deployment); err != nil {
+		return "", err
+	}
+
+	containers := deployment.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return "", fmt.Errorf("deployment %s has no containers", name)
+	}
+	return containers[0].Image, nil
+}
+
+// SetImage replaces the image of a Deployment's first container, which
+// starts its rollout
+func (k *kubeRollout) SetImage(name, namespace, image string) error {
+	patch, _ := json.Marshal([]map[string]string{
+		{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": image},
+	})
+
+	req, _ := http.NewRequest(http.MethodPatch, k.BaseURL+fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments/%s", namespace, name), bytes.NewReader(patch))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	req.Header.Set("Accept", "application/json")
+	if len(k.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+k.Token)
+	}
+
+	res, err := k.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to set image: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("unable to set image: status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (k *kubeRollout) get(path string, value interface{}) error {
+	req, _ := http.NewRequest(http.MethodGet, k.BaseURL+path, nil)
+	req.Header.Set("Accept", "application/json")
+	if len(k.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+k.Token)
+	}
+
+	res, err := k.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to read rollout status: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to read rollout status: unexpected status code %d", res.StatusCode)
+	}
+
+	return json.Unmarshal(body, value)
+}
@@ -0,0 +1,52 @@
+package function
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func deployment(image string, replicas, updated, available int) string {
+	return fmt.Sprintf(`{
+  "metadata": {"generation": 2},
+  "spec": {"replicas": 2, "template": {"spec": {"containers": [{"image": %q}]}}},
+  "status": {"observedGeneration": 2, "replicas": %d, "updatedReplicas": %d, "availableReplicas": %d}
+}`, image, replicas, updated, available)
+}
+
+func Test_kubeRollout_Ready(t *testing.T) {
+	tests := []struct {
+		name       string
+		deployment string
+		ready      bool
+	}{
+		{name: "complete", deployment: deployment("openfaas/audit-event:0.2.0", 2, 2, 2), ready: true},
+		{name: "previous image", deployment: deployment("openfaas/audit-event:0.1.0", 2, 2, 2), ready: false},
+		{name: "old replicas left", deployment: deployment("openfaas/audit-event:0.2.0", 3, 2, 2), ready: false},
+		{name: "one updated", deployment: deployment("openfaas/audit-event:0.2.0", 3, 1, 2), ready: false},
+		{name: "not available", deployment: deployment("openfaas/audit-event:0.2.0", 2, 2, 1), ready: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/apis/apps/v1/namespaces/openfaas/deployments/audit-event" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Write([]byte(test.deployment))
+			}))
+			defer s.Close()
+
+			rollout := &kubeRollout{BaseURL: s.URL, Namespace: "openfaas-fn", Client: http.DefaultClient}
+			ready, err := rollout.Ready("audit-event", "openfaas", "openfaas/audit-event:0.2.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ready != test.ready {
+				t.Errorf("want ready: %v, got: %v", test.ready, ready)
+			}
+		})
+	}
+}
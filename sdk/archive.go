@@ -0,0 +1,109 @@
+package sdk
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// MaxArchiveContents bounds the size of the files in an uploaded archive
+// once it is uncompressed
+const MaxArchiveContents = 50 * 1024 * 1024
+
+// ArchiveCommit gives the ID of an uploaded archive, which stands in for
+// the commit SHA in the image tag and commit statuses
+func ArchiveCommit(archive []byte) string {
+	sum := sha1.Sum(archive)
+	return hex.EncodeToString(sum[:])
+}
+
+// WalkArchive calls fn with each directory and regular file of a tar, or
+// of a gzipped tar. Names are cleaned and relative to the archive's root,
+// an archive with absolute names, names outside of its root, links or
+// more than MaxArchiveContents of files is rejected.
+func WalkArchive(archive []byte, fn func(name string, header *tar.Header, r io.Reader) error) error {
+	var reader io.Reader = bytes.NewReader(archive)
+
+	// A gzip stream starts with 0x1f 0x8b
+	if len(archive) > 1 && archive[0] == 0x1f && archive[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("unable to read gzip: %s", err.Error())
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	tarReader := tar.NewReader(reader)
+	var total int64
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read tar: %s", err.Error())
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("%s is outside of the archive", header.Name)
+		}
+		if name == "." {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeReg, tar.TypeRegA:
+			total += header.Size
+			if total > MaxArchiveContents {
+				return fmt.Errorf("archive has more than %d bytes of files", MaxArchiveContents)
+			}
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			return fmt.Errorf("%s is not a file or directory", header.Name)
+		}
+
+		if err := fn(name, header, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+// CLISCM is an archive uploaded through edge-auth, rather than a
+// repository which is pushed to
+type CLISCM struct {
+}
+
+// Name is "cli"
+func (c *CLISCM) Name() string {
+	return SCMCLI
+}
+
+// ValidateWebhook always fails, since uploads are authorized by edge-auth
+func (c *CLISCM) ValidateWebhook(payload []byte, header http.Header, secret string) error {
+	return fmt.Errorf("%s has no webhooks", SCMCLI)
+}
+
+// CloneCredentials fails since an upload is unpacked from its archive
+func (c *CLISCM) CloneCredentials(event PushEvent) (*url.Userinfo, error) {
+	return nil, fmt.Errorf("%s is not cloned, its archive is unpacked", event.Repository.FullName)
+}
+
+// ReportStatus only clears the statuses, since an upload has no commit
+// to report statuses to
+func (c *CLISCM) ReportStatus(status *Status, gatewayURL string, payloadSecret string) error {
+	status.CommitStatuses = make(map[string]CommitStatus)
+	return nil
+}
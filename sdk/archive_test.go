@@ -0,0 +1,116 @@
+package sdk
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func makeArchive(t *testing.T, compress bool, headers ...*tar.Header) []byte {
+	buf := bytes.Buffer{}
+	var w io.Writer = &buf
+
+	var gzipWriter *gzip.Writer
+	if compress {
+		gzipWriter = gzip.NewWriter(&buf)
+		w = gzipWriter
+	}
+
+	tarWriter := tar.NewWriter(w)
+	for _, header := range headers {
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			tarWriter.Write(bytes.Repeat([]byte("a"), int(header.Size)))
+		}
+	}
+	tarWriter.Close()
+
+	if compress {
+		gzipWriter.Close()
+	}
+	return buf.Bytes()
+}
+
+func Test_WalkArchive(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		archive := makeArchive(t, compress,
+			&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+			&tar.Header{Name: "./stack.yml", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+			&tar.Header{Name: "fn1/", Typeflag: tar.TypeDir, Mode: 0755},
+			&tar.Header{Name: "fn1/handler.go", Typeflag: tar.TypeReg, Mode: 0644, Size: 5},
+		)
+
+		names := []string{}
+		err := WalkArchive(archive, func(name string, header *tar.Header, r io.Reader) error {
+			names = append(names, name)
+			if header.Typeflag == tar.TypeReg {
+				body, _ := ioutil.ReadAll(r)
+				if int64(len(body)) != header.Size {
+					t.Errorf("want %d bytes for %s, got %d", header.Size, name, len(body))
+				}
+			}
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("gzip: %t, want no error, got %s", compress, err)
+		}
+		if got := strings.Join(names, ","); got != "stack.yml,fn1,fn1/handler.go" {
+			t.Errorf("gzip: %t, want names relative to the root, got %s", compress, got)
+		}
+	}
+}
+
+func Test_WalkArchive_Rejects(t *testing.T) {
+	tests := []struct {
+		Scenario string
+		Header   *tar.Header
+	}{
+		{"parent directory", &tar.Header{Name: "../stack.yml", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}},
+		{"nested parent directory", &tar.Header{Name: "fn1/../../stack.yml", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}},
+		{"absolute name", &tar.Header{Name: "/etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}},
+		{"symlink", &tar.Header{Name: "fn1/handler.go", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		{"hard link", &tar.Header{Name: "fn1/handler.go", Typeflag: tar.TypeLink, Linkname: "stack.yml"}},
+		{"too large", &tar.Header{Name: "stack.yml", Typeflag: tar.TypeReg, Mode: 0644, Size: MaxArchiveContents + 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Scenario, func(t *testing.T) {
+			archive := makeArchive(t, true, test.Header)
+
+			called := false
+			err := WalkArchive(archive, func(name string, header *tar.Header, r io.Reader) error {
+				called = true
+				return nil
+			})
+
+			if err == nil || called {
+				t.Errorf("want %s rejected, got error: %v, called: %t", test.Header.Name, err, called)
+			}
+		})
+	}
+}
+
+func Test_ArchiveCommit(t *testing.T) {
+	commit := ArchiveCommit([]byte("archive"))
+	if len(commit) != 40 || commit == ArchiveCommit([]byte("another archive")) {
+		t.Errorf("want a SHA-1 of the archive, got %s", commit)
+	}
+}
+
+func Test_CLISCM_CloneCredentials(t *testing.T) {
+	scm, err := GetSCM(SCMCLI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CloneURL(scm, PushEvent{SCM: SCMCLI}); err == nil {
+		t.Errorf("want an upload never cloned")
+	}
+}
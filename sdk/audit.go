@@ -8,8 +8,11 @@ import (
 	"os"
 )
 
+// PostAudit sends the event to the audit_url, with secrets redacted from
+// its message
 func PostAudit(auditEvent AuditEvent) {
-	c := http.Client{}
+	c := HTTPClient()
+	auditEvent.Message = Redact(auditEvent.Message)
 	bytesOut, _ := json.Marshal(&auditEvent)
 	reader := bytes.NewBuffer(bytesOut)
 	auditURL := os.Getenv("audit_url")
@@ -36,4 +39,58 @@ type AuditEvent struct {
 	Message string
 	Owner   string
 	Repo    string
+	// SHA is the commit being built
+	SHA string `json:",omitempty"`
+	// DeliveryID and CorrelationID trace the event back to the webhook
+	// delivery and the pipeline which it started, see Delivery
+	DeliveryID    string `json:",omitempty"`
+	CorrelationID string `json:",omitempty"`
+	// Diff is what a redeployment changed
+	Diff *DeployDiff `json:",omitempty"`
+}
+
+// Trace adds the commit and the delivery which started the pipeline to
+// the event, so that the audit trail of one push can be followed from
+// github-event to buildshiprun. delivery may be nil.
+func (a AuditEvent) Trace(sha string, delivery *Delivery) AuditEvent {
+	a.SHA = sha
+	if delivery != nil {
+		a.DeliveryID = delivery.ID
+		a.CorrelationID = delivery.CorrelationID
+	}
+	return a
+}
+
+// Kinds of change recorded in a DeployDiff
+const (
+	ImageChange  = "image"
+	EnvChange    = "env"
+	LabelChange  = "label"
+	SecretChange = "secret"
+	// LimitChange and RequestChange are named "memory" or "cpu"
+	LimitChange   = "limit"
+	RequestChange = "request"
+)
+
+// Actions for a DeployChange
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeUpdated = "updated"
+)
+
+// DeployDiff lists the changes between the deployed function and the
+// spec which replaced it
+type DeployDiff struct {
+	Changes []DeployChange
+}
+
+// DeployChange is a change to the image, or to one env-var, label,
+// secret, limit or request. The values of env-vars are not recorded.
+type DeployChange struct {
+	Kind   string
+	Name   string `json:",omitempty"`
+	Action string
+	Old    string `json:",omitempty"`
+	New    string `json:",omitempty"`
 }
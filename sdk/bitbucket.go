@@ -0,0 +1,113 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// BitbucketPushEvent as received from a Bitbucket Cloud repo:push webhook
+type BitbucketPushEvent struct {
+	Actor      BitbucketActor      `json:"actor"`
+	Repository BitbucketRepository `json:"repository"`
+	Push       struct {
+		Changes []BitbucketChange `json:"changes"`
+	} `json:"push"`
+}
+
+// BitbucketActor is the user who pushed
+type BitbucketActor struct {
+	Nickname string `json:"nickname"`
+}
+
+// BitbucketRepository is the repository pushed to, FullName is
+// workspace/repo_slug
+type BitbucketRepository struct {
+	Name      string `json:"name"`
+	FullName  string `json:"full_name"`
+	IsPrivate bool   `json:"is_private"`
+	Links     struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+	Workspace struct {
+		Slug string `json:"slug"`
+	} `json:"workspace"`
+}
+
+// BitbucketChange is one of the branches or tags updated by a push, New
+// is nil when it was deleted and Old when it was created
+type BitbucketChange struct {
+	New    *BitbucketRef `json:"new"`
+	Old    *BitbucketRef `json:"old"`
+	Forced bool          `json:"forced"`
+}
+
+// BitbucketRef is a branch or tag and the commit at its head
+type BitbucketRef struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Target struct {
+		Hash    string `json:"hash"`
+		Message string `json:"message"`
+	} `json:"target"`
+}
+
+// BitbucketAccessToken exchanges the platform's OAuth consumer, read
+// from the bitbucket-consumer-key and bitbucket-consumer-secret secrets,
+// for an access token with the client credentials grant. The token acts
+// as the consumer's workspace and lasts for two hours.
+func BitbucketAccessToken() (string, error) {
+	key, err := ReadSecret("bitbucket-consumer-key")
+	if err != nil {
+		return "", err
+	}
+	secret, err := ReadSecret("bitbucket-consumer-secret")
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+
+	req, err := http.NewRequest(http.MethodPost, bitbucketOAuthURL(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(key, secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := HTTPClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to request a Bitbucket access token: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code requesting a Bitbucket access token: %d", res.StatusCode)
+	}
+
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("unable to parse Bitbucket access token: %s", err.Error())
+	}
+	if len(token.AccessToken) == 0 {
+		return "", fmt.Errorf("no access token given by Bitbucket")
+	}
+
+	RegisterSecret(token.AccessToken)
+	return token.AccessToken, nil
+}
+
+func bitbucketOAuthURL() string {
+	if val, ok := os.LookupEnv("bitbucket_oauth_url"); ok && len(val) > 0 {
+		return val
+	}
+	return "https://bitbucket.org/site/oauth2/access_token"
+}
@@ -0,0 +1,71 @@
+package sdk
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_BitbucketAccessToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "bitbucket-consumer-key"), []byte("consumer-key"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "bitbucket-consumer-secret"), []byte("consumer-secret"), 0600)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if key != "consumer-key" || secret != "consumer-secret" || r.Form.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"access_token": "bitbucket-access-token", "token_type": "bearer", "expires_in": 7200}`))
+	}))
+	defer s.Close()
+
+	os.Setenv("secret_mount_path", dir)
+	defer os.Unsetenv("secret_mount_path")
+	os.Setenv("bitbucket_oauth_url", s.URL)
+	defer os.Unsetenv("bitbucket_oauth_url")
+
+	token, err := BitbucketAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "bitbucket-access-token" {
+		t.Errorf("want the access token, got %q", token)
+	}
+	if got := Redact("clone with " + token); got != "clone with [REDACTED]" {
+		t.Errorf("want the token redacted, got %q", got)
+	}
+}
+
+func Test_BitbucketAccessToken_Rejected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "bitbucket-consumer-key"), []byte("consumer-key"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "bitbucket-consumer-secret"), []byte("wrong-secret"), 0600)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer s.Close()
+
+	os.Setenv("secret_mount_path", dir)
+	defer os.Unsetenv("secret_mount_path")
+	os.Setenv("bitbucket_oauth_url", s.URL)
+	defer os.Unsetenv("bitbucket_oauth_url")
+
+	if _, err := BitbucketAccessToken(); err == nil {
+		t.Errorf("want an error when the consumer is rejected")
+	}
+}
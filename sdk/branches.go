@@ -0,0 +1,75 @@
+package sdk
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// branchRefPrefix prefixes the ref of a branch in a push event
+const branchRefPrefix = "refs/heads/"
+
+// RepoConfigFile is read from the root of a repository for its own
+// settings, such as the branches which are built
+const RepoConfigFile = ".ofc.yml"
+
+// RepoConfig is the content of a repository's .ofc.yml
+type RepoConfig struct {
+	// Branches are built when pushed to in place of build_branches
+	Branches []string `yaml:"branches"`
+}
+
+// BranchFromRef gives the branch of a ref such as refs/heads/master, or
+// an empty string for a tag
+func BranchFromRef(ref string) string {
+	if strings.HasPrefix(ref, branchRefPrefix) {
+		return strings.TrimPrefix(ref, branchRefPrefix)
+	}
+	return ""
+}
+
+// BuildBranches gives the branches pushes to which are built, from the
+// comma-separated build_branches, i.e. "master,release/*". It falls
+// back to the single build_branch, and then to master.
+func BuildBranches() []string {
+	branches := []string{}
+	for _, branch := range strings.Split(os.Getenv("build_branches"), ",") {
+		if branch = strings.TrimSpace(branch); len(branch) > 0 {
+			branches = append(branches, branch)
+		}
+	}
+
+	if len(branches) > 0 {
+		return branches
+	}
+
+	if branch := strings.TrimSpace(os.Getenv("build_branch")); len(branch) > 0 {
+		return []string{branch}
+	}
+	return []string{"master"}
+}
+
+// MatchBranch is true when the branch is one of the patterns, which may
+// contain wildcards such as release/*
+func MatchBranch(branch string, patterns []string) bool {
+	if len(branch) == 0 {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		if pattern == branch {
+			return true
+		}
+		if matched, err := path.Match(pattern, branch); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// RepoBranchesEnabled is true when repositories may choose the branches
+// which are built in their .ofc.yml with repo_branches
+func RepoBranchesEnabled() bool {
+	val := os.Getenv("repo_branches")
+	return val == "true" || val == "1"
+}
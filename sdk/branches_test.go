@@ -0,0 +1,66 @@
+package sdk
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_BranchFromRef(t *testing.T) {
+	cases := map[string]string{
+		"refs/heads/master":      "master",
+		"refs/heads/release/1.0": "release/1.0",
+		"refs/tags/v1.0.0":       "",
+		"":                       "",
+	}
+
+	for ref, want := range cases {
+		if got := BranchFromRef(ref); got != want {
+			t.Errorf("%s: want %q, got %q", ref, want, got)
+		}
+	}
+}
+
+func Test_BuildBranches(t *testing.T) {
+	cases := []struct {
+		title          string
+		buildBranches  string
+		buildBranch    string
+		expectedResult []string
+	}{
+		{"defaults to master", "", "", []string{"master"}},
+		{"falls back to build_branch", "", "main", []string{"main"}},
+		{"list overrides build_branch", "main, release/* ,", "main", []string{"main", "release/*"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			os.Setenv("build_branches", c.buildBranches)
+			os.Setenv("build_branch", c.buildBranch)
+			defer os.Unsetenv("build_branches")
+			defer os.Unsetenv("build_branch")
+
+			if got := BuildBranches(); !reflect.DeepEqual(got, c.expectedResult) {
+				t.Errorf("want %v, got %v", c.expectedResult, got)
+			}
+		})
+	}
+}
+
+func Test_MatchBranch(t *testing.T) {
+	patterns := []string{"master", "release/*"}
+
+	cases := map[string]bool{
+		"master":          true,
+		"release/1.0":     true,
+		"release/1.0/fix": false,
+		"feature/login":   false,
+		"":                false,
+	}
+
+	for branch, want := range cases {
+		if got := MatchBranch(branch, patterns); got != want {
+			t.Errorf("%q: want %v, got %v", branch, want, got)
+		}
+	}
+}
@@ -1,9 +1,73 @@
 package sdk
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/alexellis/hmac"
+)
+
 // BuildResult represents a successful Docker build and
 // push operation to a remote registry
 type BuildResult struct {
-	Log       []string `json:"log"`
-	ImageName string   `json:"imageName"`
-	Status    string   `json:"status"`
+	Log            []string `json:"log"`
+	ImageName      string   `json:"imageName"`
+	Status         string   `json:"status"`
+	ExtractSeconds float64  `json:"extractSeconds,omitempty"`
+	// BuildSeconds covers the solve, including the push
+	BuildSeconds float64 `json:"buildSeconds,omitempty"`
+	PushSeconds  float64 `json:"pushSeconds,omitempty"`
+	// ImageSize is the size in bytes of the layers pushed by the build
+	ImageSize int64 `json:"imageSize,omitempty"`
+	// PushMBps is the upload throughput to the registry
+	PushMBps float64 `json:"pushMBps,omitempty"`
+	// RegistryWaitSeconds is the part of the push spent waiting on the
+	// registry rather than uploading layers
+	RegistryWaitSeconds float64 `json:"registryWaitSeconds,omitempty"`
+	// LogURL is the complete log in log storage when it was too large
+	// to be returned in Log
+	LogURL string `json:"logURL,omitempty"`
+	// Scan is the SBOM and vulnerability summary of the image, when the
+	// of-builder has a scanner
+	Scan *ScanReport `json:"scan,omitempty"`
+}
+
+// Headers sent to the of-builder with a build context so that the
+// build can be recorded and re-run later through /rebuild
+const (
+	BuildOwnerHeader    = "X-Build-Owner"
+	BuildRepoHeader     = "X-Build-Repo"
+	BuildSHAHeader      = "X-Build-SHA"
+	BuildFunctionHeader = "X-Build-Function"
+	// BuildSignatureHeader is the HMAC of the headers above, see
+	// SignBuildMetadata
+	BuildSignatureHeader = "X-Build-Signature"
+)
+
+// BuildMetadataMessage is what the build signature covers, the values of
+// the build headers and the SHA-256 digest of the build context, one
+// per line, so that the headers can't be changed or moved to another
+// build context
+func BuildMetadataMessage(owner, repo, sha, function string, buildContext []byte) []byte {
+	digest := sha256.Sum256(buildContext)
+
+	return []byte(strings.Join([]string{owner, repo, sha, function, hex.EncodeToString(digest[:])}, "\n"))
+}
+
+// SignBuildMetadata gives the value of BuildSignatureHeader, signed with
+// the payload-secret
+func SignBuildMetadata(owner, repo, sha, function string, buildContext []byte, payloadSecret string) string {
+	digest := hmac.Sign(BuildMetadataMessage(owner, repo, sha, function, buildContext), []byte(payloadSecret))
+
+	return "sha1=" + hex.EncodeToString(digest)
+}
+
+// RebuildRequest asks the of-builder to repeat the builds recorded
+// for a commit, Function is optional and limits it to one function
+type RebuildRequest struct {
+	Owner    string `json:"owner"`
+	Repo     string `json:"repo"`
+	SHA      string `json:"sha"`
+	Function string `json:"function,omitempty"`
 }
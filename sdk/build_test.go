@@ -0,0 +1,27 @@
+package sdk
+
+import (
+	"testing"
+
+	"github.com/alexellis/hmac"
+)
+
+func Test_SignBuildMetadata(t *testing.T) {
+	buildContext := []byte("context")
+	signature := SignBuildMetadata("alexellis", "kubecon-tester", "6df8c47", "fn1", buildContext, "secret")
+
+	message := BuildMetadataMessage("alexellis", "kubecon-tester", "6df8c47", "fn1", buildContext)
+	if err := hmac.Validate(message, signature, "secret"); err != nil {
+		t.Errorf("want a valid signature, got: %s", err)
+	}
+
+	moved := BuildMetadataMessage("alexellis", "kubecon-tester", "6df8c47", "fn1", []byte("another context"))
+	if err := hmac.Validate(moved, signature, "secret"); err == nil {
+		t.Errorf("want the signature bound to the build context")
+	}
+
+	renamed := BuildMetadataMessage("attacker", "kubecon-tester", "6df8c47", "fn1", buildContext)
+	if err := hmac.Validate(renamed, signature, "secret"); err == nil {
+		t.Errorf("want the signature to cover the owner")
+	}
+}
@@ -0,0 +1,76 @@
+package sdk
+
+import "time"
+
+// RerunAction is the identifier of the button on a check run which
+// builds and deploys the commit again
+const RerunAction = "rerun"
+
+// CheckRunDetail is given by buildshiprun with the status of a function
+// so that its check run shows the build's output and timings
+type CheckRunDetail struct {
+	// LogExcerpt is the tail of the build log, with secrets redacted
+	LogExcerpt []string      `json:"logExcerpt,omitempty"`
+	Timings    []CheckTiming `json:"timings,omitempty"`
+	Started    time.Time     `json:"started"`
+}
+
+// CheckTiming is the time taken by one phase of the pipeline, i.e.
+// build, push or deploy
+type CheckTiming struct {
+	Phase   string  `json:"phase"`
+	Seconds float64 `json:"seconds"`
+}
+
+// CheckRunEvent is received from GitHub's check_run subscription when a
+// user re-runs a check run created by the app
+type CheckRunEvent struct {
+	Action          string                `json:"action"`
+	CheckRun        CheckRun              `json:"check_run"`
+	RequestedAction *RequestedAction      `json:"requested_action,omitempty"`
+	Repository      PushEventRepository   `json:"repository"`
+	Installation    PushEventInstallation `json:"installation"`
+	Sender          Sender                `json:"sender"`
+}
+
+// CheckRun is the check run of a CheckRunEvent
+type CheckRun struct {
+	Name       string     `json:"name"`
+	HeadSHA    string     `json:"head_sha"`
+	CheckSuite CheckSuite `json:"check_suite"`
+}
+
+// CheckSuite is the suite a CheckRun belongs to, HeadBranch is empty
+// for a tag
+type CheckSuite struct {
+	HeadBranch string `json:"head_branch"`
+}
+
+// RequestedAction is the button of the check run which was pressed
+type RequestedAction struct {
+	Identifier string `json:"identifier"`
+}
+
+// Rerun is true for the check run's own re-run link and for its
+// RerunAction button
+func (e CheckRunEvent) Rerun() bool {
+	switch e.Action {
+	case "rerequested":
+		return true
+	case "requested_action":
+		return e.RequestedAction != nil && e.RequestedAction.Identifier == RerunAction
+	}
+	return false
+}
+
+// PushEvent gives a push of the check run's commit to its branch, so
+// that it is built again like any other push
+func (e CheckRunEvent) PushEvent() PushEvent {
+	return PushEvent{
+		Ref:           branchRefPrefix + e.CheckRun.CheckSuite.HeadBranch,
+		AfterCommitID: e.CheckRun.HeadSHA,
+		Repository:    e.Repository,
+		Installation:  e.Installation,
+		Sender:        e.Sender,
+	}
+}
@@ -0,0 +1,56 @@
+package sdk
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testCheckRunEvent = `{
+  "action": "requested_action",
+  "check_run": {"name": "fn1", "head_sha": "af6db3c", "check_suite": {"head_branch": "master"}},
+  "requested_action": {"identifier": "rerun"},
+  "repository": {"name": "kubecon-tester", "full_name": "alexellis/kubecon-tester", "owner": {"login": "alexellis"}},
+  "installation": {"id": 10},
+  "sender": {"login": "alexellis"}
+}`
+
+func Test_CheckRunEvent_PushEvent(t *testing.T) {
+	event := CheckRunEvent{}
+	if err := json.Unmarshal([]byte(testCheckRunEvent), &event); err != nil {
+		t.Fatal(err)
+	}
+
+	if !event.Rerun() {
+		t.Errorf("want the rerun button to re-run the check")
+	}
+
+	push := event.PushEvent()
+	if push.Ref != "refs/heads/master" || push.AfterCommitID != "af6db3c" || push.Installation.ID != 10 {
+		t.Errorf("want a push of the check run's commit, got %+v", push)
+	}
+
+	info := BuildEventFromPushEvent(push)
+	if info.Branch != "master" || info.SHA != "af6db3c" || info.Owner != "alexellis" {
+		t.Errorf("want the branch and commit in the event, got %+v", info)
+	}
+}
+
+func Test_CheckRunEvent_Rerun(t *testing.T) {
+	cases := []struct {
+		event CheckRunEvent
+		want  bool
+	}{
+		{CheckRunEvent{Action: "rerequested"}, true},
+		{CheckRunEvent{Action: "requested_action", RequestedAction: &RequestedAction{Identifier: RerunAction}}, true},
+		{CheckRunEvent{Action: "requested_action", RequestedAction: &RequestedAction{Identifier: "other"}}, false},
+		{CheckRunEvent{Action: "requested_action"}, false},
+		{CheckRunEvent{Action: "created"}, false},
+		{CheckRunEvent{Action: "completed"}, false},
+	}
+
+	for _, c := range cases {
+		if got := c.event.Rerun(); got != c.want {
+			t.Errorf("%s: want %v, got %v", c.event.Action, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package sdk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Clock gives the time stamped onto labels, queued events and logs by
+// the pipeline's functions, tests replace it with sdktest.FixedClock
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock of the host, in UTC
+type SystemClock struct{}
+
+// Now gives the current time in UTC
+func (SystemClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// IDGenerator gives the IDs assigned by the pipeline's functions, such
+// as correlation IDs, tests replace it with sdktest.SequentialIDs
+type IDGenerator interface {
+	NewID() string
+}
+
+// RandomIDs gives 16 hex characters from crypto/rand
+type RandomIDs struct{}
+
+// NewID gives a random ID, falling back to the time when no randomness
+// is available
+func (RandomIDs) NewID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
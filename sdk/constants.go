@@ -5,4 +5,6 @@ const (
 	CloudSignatureHeader = "X-Cloud-Signature"
 	// FunctionLabelPrefix is a prefix for openfaas labels inside functions
 	FunctionLabelPrefix = "com.openfaas.cloud."
+	// MemoryLimitLabel records the memory limit in MB given to a function
+	MemoryLimitLabel = FunctionLabelPrefix + "memory-limit-mb"
 )
@@ -0,0 +1,422 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultCustomersURL is the CUSTOMERS file of the community cluster
+const defaultCustomersURL = "https://raw.githubusercontent.com/openfaas/openfaas-cloud/master/CUSTOMERS"
+
+// CustomerStore lists the customers of the installation along with
+// their plan, quotas and registry organisation
+type CustomerStore interface {
+	List() ([]CustomerInfo, error)
+
+	// String names the store for logging
+	String() string
+}
+
+// CustomerWriter is implemented by stores which can add, update and
+// remove customers, such as for GitHub Marketplace purchases
+type CustomerWriter interface {
+	// Put adds the customer or replaces their plan and quotas
+	Put(spec CustomerSpec) error
+
+	// Delete removes the customer, it is not an error when they are
+	// not found
+	Delete(login string) error
+}
+
+// NewCustomerStore picks the store from customers_store, either "url"
+// for the CUSTOMERS file at customers_path or customersURL, which is
+// the default, or "kubernetes" for Customer resources.
+func NewCustomerStore(customersPath, customersURL string) CustomerStore {
+	switch strings.ToLower(os.Getenv("customers_store")) {
+	case "kubernetes", "crd":
+		return NewKubeCustomerStore()
+	}
+
+	if len(customersPath) > 0 {
+		return &FileCustomerStore{Path: customersPath}
+	}
+
+	if len(customersURL) == 0 {
+		customersURL = os.Getenv("customers_url")
+	}
+	if len(customersURL) == 0 {
+		customersURL = defaultCustomersURL
+	}
+	return &URLCustomerStore{URL: customersURL}
+}
+
+// FileCustomerStore reads a CUSTOMERS file mounted into the function,
+// i.e. from a secret
+type FileCustomerStore struct {
+	Path string
+}
+
+// List gives no customers when the file can't be read
+func (s *FileCustomerStore) List() ([]CustomerInfo, error) {
+	out, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		log.Printf("unable to read customers from %s, error: %s", s.Path, err.Error())
+		return []CustomerInfo{}, nil
+	}
+
+	return parseCustomersFile(out)
+}
+
+func (s *FileCustomerStore) String() string {
+	return s.Path
+}
+
+// URLCustomerStore fetches a CUSTOMERS file over HTTP
+type URLCustomerStore struct {
+	URL string
+}
+
+// List fetches the file on each call, Customers caches the result
+func (s *URLCustomerStore) List() ([]CustomerInfo, error) {
+	log.Printf("Fetching customers from %s", s.URL)
+
+	out, err := fetchCustomers(s.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCustomersFile(out)
+}
+
+func (s *URLCustomerStore) String() string {
+	return s.URL
+}
+
+func parseCustomers(lines []string) []CustomerInfo {
+	customers := []CustomerInfo{}
+	for _, line := range lines {
+		if customer, ok := parseCustomer(line); ok {
+			customers = append(customers, customer)
+		}
+	}
+	return customers
+}
+
+// customersFile is the YAML format of the CUSTOMERS file, which starts
+// with its version, i.e.
+//
+//	version: 2
+//	customers:
+//	- login: alexellis
+//	  plan: pro
+//	  maxFunctions: 50
+//	  branches: [master, release/*]
+//	  registryOrg: customer-x
+type customersFile struct {
+	Version   int            `yaml:"version"`
+	Customers []CustomerSpec `yaml:"customers"`
+}
+
+// isCustomersYAML is true when the first line of the CUSTOMERS file,
+// other than comments, gives the version of the YAML format. Files
+// without it list a customer on each line.
+func isCustomersYAML(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		return strings.HasPrefix(line, "version:")
+	}
+	return false
+}
+
+// parseCustomersFile reads the CUSTOMERS file in either format
+func parseCustomersFile(content []byte) ([]CustomerInfo, error) {
+	if !isCustomersYAML(content) {
+		return parseCustomers(strings.Split(string(content), "\n")), nil
+	}
+
+	file := customersFile{}
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("unable to parse customers: %s", err.Error())
+	}
+
+	if file.Version != 2 {
+		return nil, fmt.Errorf("unsupported version of customers: %d", file.Version)
+	}
+
+	customers := []CustomerInfo{}
+	for _, spec := range file.Customers {
+		if info, ok := spec.Info(""); ok {
+			customers = append(customers, info)
+		}
+	}
+	return customers, nil
+}
+
+// customerResource is the API path of the Customer custom resources
+const customerResource = "/apis/ofc.openfaas.com/v1alpha1/namespaces/%s/customers"
+
+// serviceAccountPath holds the token and CA of the function's service
+// account when it runs on Kubernetes
+const serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// CustomerResource is a customer held as a custom resource, i.e.
+//
+//	apiVersion: ofc.openfaas.com/v1alpha1
+//	kind: Customer
+//	metadata:
+//	  name: alexellis
+//	spec:
+//	  plan: pro
+//	  registryOrg: customer-x
+//	  quotas:
+//	    functions: 50
+type CustomerResource struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec CustomerSpec `json:"spec"`
+}
+
+// CustomerSpec gives the customer's login, which defaults to the name
+// of the resource, and their plan, quotas, branches and registry
+// organisation. It is also an entry of the YAML CUSTOMERS file.
+type CustomerSpec struct {
+	Login        string            `json:"login,omitempty" yaml:"login,omitempty"`
+	Plan         string            `json:"plan,omitempty" yaml:"plan,omitempty"`
+	RegistryOrg  string            `json:"registryOrg,omitempty" yaml:"registryOrg,omitempty"`
+	Quotas       map[string]int    `json:"quotas,omitempty" yaml:"quotas,omitempty"`
+	Entitlements map[string]string `json:"entitlements,omitempty" yaml:"entitlements,omitempty"`
+	// MaxFunctions is the quota of functions, in place of quotas.functions
+	MaxFunctions int      `json:"maxFunctions,omitempty" yaml:"maxFunctions,omitempty"`
+	Branches     []string `json:"branches,omitempty" yaml:"branches,omitempty"`
+}
+
+// Info gives the CustomerInfo passed down the pipeline, where the plan
+// is the tier and the quotas are added to the entitlements
+func (c CustomerResource) Info() (CustomerInfo, bool) {
+	return c.Spec.Info(c.Metadata.Name)
+}
+
+// Info gives the CustomerInfo for the spec, name is the login when the
+// spec has none
+func (s CustomerSpec) Info(name string) (CustomerInfo, bool) {
+	login := s.Login
+	if len(login) == 0 {
+		login = name
+	}
+
+	login = formatUsername(login)
+	if len(login) == 0 {
+		return CustomerInfo{}, false
+	}
+
+	info := CustomerInfo{
+		Login:       login,
+		Tier:        DefaultTier,
+		RegistryOrg: s.RegistryOrg,
+		Branches:    s.Branches,
+	}
+
+	if len(s.Plan) > 0 {
+		info.Tier = strings.ToLower(s.Plan)
+	}
+
+	if len(s.Quotas)+len(s.Entitlements) > 0 || s.MaxFunctions > 0 {
+		info.Entitlements = map[string]string{}
+		for k, v := range s.Entitlements {
+			info.Entitlements[k] = v
+		}
+		for k, v := range s.Quotas {
+			info.Entitlements[k] = strconv.Itoa(v)
+		}
+		if s.MaxFunctions > 0 {
+			info.Entitlements["functions"] = strconv.Itoa(s.MaxFunctions)
+		}
+	}
+
+	return info, true
+}
+
+// KubeCustomerStore lists Customer resources from the Kubernetes API
+type KubeCustomerStore struct {
+	BaseURL   string
+	Token     string
+	Namespace string
+	Client    *http.Client
+}
+
+// NewKubeCustomerStore uses the function's service account and reads
+// the resources from customers_namespace, "openfaas" by default. The
+// service account needs to be able to list customers.ofc.openfaas.com.
+func NewKubeCustomerStore() *KubeCustomerStore {
+	store := &KubeCustomerStore{
+		BaseURL:   "https://" + os.Getenv("KUBERNETES_SERVICE_HOST") + ":" + os.Getenv("KUBERNETES_SERVICE_PORT"),
+		Namespace: "openfaas",
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if val := os.Getenv("customers_namespace"); len(val) > 0 {
+		store.Namespace = val
+	}
+
+	if token, err := ioutil.ReadFile(serviceAccountPath + "token"); err == nil {
+		store.Token = strings.TrimSpace(string(token))
+	}
+
+	if ca, err := ioutil.ReadFile(serviceAccountPath + "ca.crt"); err == nil {
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(ca) {
+			store.Client.Transport = &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			}
+		}
+	}
+
+	return store
+}
+
+// List reads every Customer in the namespace, sorted by login
+func (s *KubeCustomerStore) List() ([]CustomerInfo, error) {
+	req, _ := http.NewRequest(http.MethodGet, s.BaseURL+fmt.Sprintf(customerResource, s.Namespace), nil)
+	req.Header.Set("Accept", "application/json")
+	if len(s.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	list := struct {
+		Items []CustomerResource `json:"items"`
+	}{}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+
+	customers := []CustomerInfo{}
+	for _, item := range list.Items {
+		if info, ok := item.Info(); ok {
+			customers = append(customers, info)
+		}
+	}
+
+	sort.Slice(customers, func(i, j int) bool {
+		return customers[i].Login < customers[j].Login
+	})
+
+	return customers, nil
+}
+
+func (s *KubeCustomerStore) String() string {
+	return fmt.Sprintf("customers in %s", s.Namespace)
+}
+
+// Put creates a Customer named after the login, or merges spec into
+// the existing one. The service account needs to be able to create
+// and patch customers.ofc.openfaas.com.
+func (s *KubeCustomerStore) Put(spec CustomerSpec) error {
+	name := formatUsername(spec.Login)
+	if len(name) == 0 {
+		return fmt.Errorf("a login is required for a customer")
+	}
+
+	resource := CustomerResource{Spec: spec}
+	resource.Metadata.Name = name
+
+	body, _ := json.Marshal(struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		CustomerResource
+	}{"ofc.openfaas.com/v1alpha1", "Customer", resource})
+
+	statusCode, resBody, err := s.do(http.MethodPost, fmt.Sprintf(customerResource, s.Namespace), "application/json", body)
+	if err != nil {
+		return err
+	}
+
+	if statusCode == http.StatusConflict {
+		patch, _ := json.Marshal(map[string]CustomerSpec{"spec": spec})
+
+		statusCode, resBody, err = s.do(http.MethodPatch, fmt.Sprintf(customerResource, s.Namespace)+"/"+name, "application/merge-patch+json", patch)
+		if err != nil {
+			return err
+		}
+	}
+
+	if statusCode != http.StatusOK && statusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status code %d: %s", statusCode, strings.TrimSpace(string(resBody)))
+	}
+
+	return nil
+}
+
+// Delete removes the Customer named after the login. The service
+// account needs to be able to delete customers.ofc.openfaas.com.
+func (s *KubeCustomerStore) Delete(login string) error {
+	name := formatUsername(login)
+	if len(name) == 0 {
+		return fmt.Errorf("a login is required for a customer")
+	}
+
+	statusCode, resBody, err := s.do(http.MethodDelete, fmt.Sprintf(customerResource, s.Namespace)+"/"+name, "", nil)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK && statusCode != http.StatusAccepted && statusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected status code %d: %s", statusCode, strings.TrimSpace(string(resBody)))
+	}
+
+	return nil
+}
+
+// do calls the Kubernetes API with the service account's token
+func (s *KubeCustomerStore) do(method, path, contentType string, body []byte) (int, []byte, error) {
+	req, _ := http.NewRequest(method, s.BaseURL+path, bytes.NewReader(body))
+	req.Header.Set("Accept", "application/json")
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if len(s.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	resBody, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, resBody, nil
+}
@@ -0,0 +1,216 @@
+package sdk
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+func Test_NewCustomerStore(t *testing.T) {
+	defer os.Unsetenv("customers_store")
+
+	if store, ok := NewCustomerStore("/var/secrets/customers", "").(*FileCustomerStore); !ok || store.Path != "/var/secrets/customers" {
+		t.Errorf("want a file store, got %v", store)
+	}
+
+	if store, ok := NewCustomerStore("", "").(*URLCustomerStore); !ok || store.URL != defaultCustomersURL {
+		t.Errorf("want the default URL, got %v", store)
+	}
+
+	os.Setenv("customers_store", "kubernetes")
+	if _, ok := NewCustomerStore("/var/secrets/customers", "").(*KubeCustomerStore); !ok {
+		t.Errorf("want a Kubernetes store")
+	}
+}
+
+func Test_URLCustomerStore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("alexellis pro functions=50\nopenfaas\n"))
+	}))
+	defer server.Close()
+
+	c := NewCustomers("", server.URL)
+
+	customer, ok := c.Lookup("AlexEllis")
+	if !ok || customer.Tier != "pro" || customer.Entitlements["functions"] != "50" {
+		t.Errorf("want alexellis on pro, got %+v %t", customer, ok)
+	}
+	if found, _ := c.Get("openfaas"); !found {
+		t.Errorf("want openfaas found")
+	}
+}
+
+func Test_URLCustomerStore_YAML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`# customers of the installation
+version: 2
+customers:
+- login: AlexEllis
+  plan: Pro
+  maxFunctions: 50
+  branches: [master, release/*]
+  registryOrg: customer-x
+  entitlements:
+    rate: "100"
+- login: openfaas
+`))
+	}))
+	defer server.Close()
+
+	c := NewCustomers("", server.URL)
+
+	customer, ok := c.Lookup("alexellis")
+	if !ok || customer.Tier != "pro" || customer.RegistryOrg != "customer-x" {
+		t.Fatalf("want alexellis on pro with customer-x, got %+v %t", customer, ok)
+	}
+	if customer.Entitlements["functions"] != "50" || customer.Entitlements["rate"] != "100" {
+		t.Errorf("want the max functions and entitlements, got %v", customer.Entitlements)
+	}
+	if !customer.AllowsBranch("release/1.0") || customer.AllowsBranch("dev") {
+		t.Errorf("want only the customer's branches allowed, got %v", customer.Branches)
+	}
+
+	if customer, ok := c.Lookup("openfaas"); !ok || customer.Tier != DefaultTier || !customer.AllowsBranch("dev") {
+		t.Errorf("want openfaas on the default tier with any branch, got %+v", customer)
+	}
+}
+
+func Test_parseCustomersFile(t *testing.T) {
+	customers, err := parseCustomersFile([]byte("alexellis pro functions=50\nopenfaas\n"))
+	if err != nil || len(customers) != 2 || customers[0].Tier != "pro" {
+		t.Errorf("want the customers of each line, got %+v %s", customers, err)
+	}
+
+	if _, err := parseCustomersFile([]byte("version: 3\ncustomers: []\n")); err == nil {
+		t.Errorf("want an error for an unsupported version")
+	}
+
+	if _, err := parseCustomersFile([]byte("version: 2\ncustomers: {login: alexellis\n")); err == nil {
+		t.Errorf("want an error for invalid YAML")
+	}
+}
+
+func Test_FileCustomerStore_Missing(t *testing.T) {
+	store := &FileCustomerStore{Path: path.Join(os.TempDir(), "no-such-customers")}
+
+	customers, err := store.List()
+	if err != nil || len(customers) != 0 {
+		t.Errorf("want no customers and no error, got %v %s", customers, err)
+	}
+}
+
+func Test_KubeCustomerStore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/ofc.openfaas.com/v1alpha1/namespaces/openfaas/customers" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("want the service account token")
+		}
+
+		w.Write([]byte(`{"items": [
+	{"metadata": {"name": "openfaas"}, "spec": {}},
+	{"metadata": {"name": "alex"}, "spec": {"login": "AlexEllis", "plan": "Pro", "registryOrg": "customer-x", "quotas": {"functions": 50}, "entitlements": {"rate": "100"}}}
+]}`))
+	}))
+	defer server.Close()
+
+	store := &KubeCustomerStore{BaseURL: server.URL, Token: "token", Namespace: "openfaas", Client: server.Client()}
+
+	customers, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(customers) != 2 {
+		t.Fatalf("want 2 customers, got %d", len(customers))
+	}
+
+	alex := customers[0]
+	if alex.Login != "alexellis" || alex.Tier != "pro" || alex.RegistryOrg != "customer-x" {
+		t.Errorf("want alexellis on pro with customer-x, got %+v", alex)
+	}
+	if alex.Entitlements["functions"] != "50" || alex.Entitlements["rate"] != "100" {
+		t.Errorf("want the quotas and entitlements, got %v", alex.Entitlements)
+	}
+
+	if customers[1].Login != "openfaas" || customers[1].Tier != DefaultTier {
+		t.Errorf("want openfaas on the default tier, got %+v", customers[1])
+	}
+}
+
+func Test_KubeCustomerStore_Forbidden(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	c := &Customers{
+		Sync:  &sync.Mutex{},
+		Store: &KubeCustomerStore{BaseURL: server.URL, Namespace: "openfaas", Client: server.Client()},
+	}
+
+	if err := c.Fetch(); err == nil {
+		t.Errorf("want an error")
+	}
+	if found, _ := c.Get("alexellis"); found {
+		t.Errorf("want no customer when the store can't be read")
+	}
+}
+
+func Test_KubeCustomerStore_Put(t *testing.T) {
+	methods := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		body, _ := ioutil.ReadAll(r.Body)
+
+		switch r.Method {
+		case http.MethodPost:
+			resource := CustomerResource{}
+			json.Unmarshal(body, &resource)
+			if resource.Metadata.Name != "alexellis" || resource.Spec.Plan != "pro" {
+				t.Errorf("want a Customer for alexellis on pro, got %s", string(body))
+			}
+			w.WriteHeader(http.StatusConflict)
+		case http.MethodPatch:
+			if r.URL.Path != "/apis/ofc.openfaas.com/v1alpha1/namespaces/openfaas/customers/alexellis" {
+				t.Errorf("unexpected path %s", r.URL.Path)
+			}
+			if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+				t.Errorf("want a merge patch, got %s", r.Header.Get("Content-Type"))
+			}
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	store := &KubeCustomerStore{BaseURL: server.URL, Namespace: "openfaas", Client: server.Client()}
+
+	if err := store.Put(CustomerSpec{Login: "AlexEllis", Plan: "pro", MaxFunctions: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if len(methods) != 2 || methods[1] != http.MethodPatch {
+		t.Errorf("want the existing customer patched, got %v", methods)
+	}
+}
+
+func Test_KubeCustomerStore_Delete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/apis/ofc.openfaas.com/v1alpha1/namespaces/openfaas/customers/alexellis" {
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	store := &KubeCustomerStore{BaseURL: server.URL, Namespace: "openfaas", Client: server.Client()}
+
+	if err := store.Delete("alexellis"); err != nil {
+		t.Errorf("want no error for a customer who is not found, got %s", err)
+	}
+}
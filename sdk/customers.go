@@ -1,6 +1,8 @@
 package sdk
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -9,8 +11,71 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/alexellis/hmac"
+)
+
+// Headers used by github-event to forward the customer it resolved,
+// the signature is made with the payload-secret
+const (
+	CustomerHeader          = "X-Cloud-Customer"
+	CustomerSignatureHeader = "X-Cloud-Customer-Signature"
 )
 
+// DefaultTier is given to customers listed without a tier
+const DefaultTier = "free"
+
+// CustomerInfo is an entry in the CUSTOMERS file, given as the username
+// followed by an optional tier and entitlements, i.e.
+// "alexellis pro functions=50"
+type CustomerInfo struct {
+	Login        string            `json:"login"`
+	Tier         string            `json:"tier"`
+	Entitlements map[string]string `json:"entitlements,omitempty"`
+
+	// RegistryOrg and Branches are given by stores which hold them for
+	// the customer, such as the YAML format of the CUSTOMERS file
+	RegistryOrg string `json:"registryOrg,omitempty"`
+	// Branches limits the branches built for the customer, which may
+	// contain wildcards such as release/*
+	Branches []string `json:"branches,omitempty"`
+}
+
+// AllowsBranch is true when the customer's branches include branch, or
+// when the customer has no list of branches
+func (c *CustomerInfo) AllowsBranch(branch string) bool {
+	if c == nil || len(c.Branches) == 0 {
+		return true
+	}
+	return MatchBranch(branch, c.Branches)
+}
+
+// parseCustomer reads a line of the CUSTOMERS file
+func parseCustomer(line string) (CustomerInfo, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return CustomerInfo{}, false
+	}
+
+	customer := CustomerInfo{
+		Login: formatUsername(fields[0]),
+		Tier:  DefaultTier,
+	}
+
+	for _, field := range fields[1:] {
+		if parts := strings.SplitN(field, "=", 2); len(parts) == 2 {
+			if customer.Entitlements == nil {
+				customer.Entitlements = map[string]string{}
+			}
+			customer.Entitlements[parts[0]] = parts[1]
+		} else {
+			customer.Tier = strings.ToLower(field)
+		}
+	}
+
+	return customer, true
+}
+
 // ValidateCustomers checks environmental
 // variable validate_customers if customer
 // validation is explicitly disabled
@@ -46,18 +111,24 @@ type Customers struct {
 	Sync      *sync.Mutex
 	Expires   time.Time
 
+	customers map[string]CustomerInfo
+
 	CustomersURL  string
 	CustomersPath string
+
+	// Store lists the customers, see NewCustomerStore
+	Store CustomerStore
 }
 
 // NewCustomers creates a Customers struct to be used to query
-// valid users.
+// valid users from the store picked by customers_store.
 func NewCustomers(customersPath, customersURL string) *Customers {
 	return &Customers{
 		Sync:          &sync.Mutex{},
 		Expires:       time.Now().Add(time.Minute * -1),
 		CustomersPath: customersPath,
 		CustomersURL:  customersURL,
+		Store:         NewCustomerStore(customersPath, customersURL),
 	}
 }
 
@@ -73,6 +144,10 @@ func (c *Customers) Get(login string) (bool, error) {
 	c.Sync.Lock()
 	defer c.Sync.Unlock()
 
+	if c.Usernames == nil {
+		return false, nil
+	}
+
 	lookup := *c.Usernames
 
 	if _, ok := lookup[strings.ToLower(login)]; ok {
@@ -82,37 +157,40 @@ func (c *Customers) Get(login string) (bool, error) {
 	return found, nil
 }
 
+// Lookup returns the customer's tier and entitlements
+func (c *Customers) Lookup(login string) (*CustomerInfo, bool) {
+	if c.Expires.Before(time.Now()) {
+		c.Fetch()
+	}
+
+	c.Sync.Lock()
+	defer c.Sync.Unlock()
+
+	customer, ok := c.customers[strings.ToLower(login)]
+	if !ok {
+		return nil, false
+	}
+	return &customer, true
+}
+
 // Fetch refreshes cache of customers which is valid for
 // `customerCacheExpiry` duration.
 func (c *Customers) Fetch() error {
-	usernames := map[string]string{}
-
-	if len(c.CustomersPath) > 0 {
-		if out, err := ioutil.ReadFile(c.CustomersPath); err == nil {
-			values := string(out)
-
-			for _, customer := range strings.Split(values, "\n") {
-				if formatted := formatUsername(customer); len(formatted) > 0 {
-					usernames[formatted] = "true"
-				}
-			}
-		}
-	} else {
-		customersURL := os.Getenv("customers_url")
-		if len(customersURL) == 0 {
-			customersURL = "https://raw.githubusercontent.com/openfaas/openfaas-cloud/master/CUSTOMERS"
-		}
+	if c.Store == nil {
+		c.Store = NewCustomerStore(c.CustomersPath, c.CustomersURL)
+	}
 
-		log.Printf("Fetching customers from %s", customersURL)
-		customers, getErr := fetchCustomers(customersURL)
-		if getErr != nil {
-			log.Printf("unable to fetch customers from %s, error: %s", customersURL, getErr.Error())
-			return getErr
-		}
+	list, err := c.Store.List()
+	if err != nil {
+		log.Printf("unable to list customers from %s, error: %s", c.Store, err.Error())
+		return err
+	}
 
-		for _, customer := range customers {
-			usernames[customer] = "true"
-		}
+	usernames := map[string]string{}
+	customers := map[string]CustomerInfo{}
+	for _, customer := range list {
+		usernames[customer.Login] = "true"
+		customers[customer.Login] = customer
 	}
 
 	c.Sync.Lock()
@@ -121,42 +199,62 @@ func (c *Customers) Fetch() error {
 	log.Printf("%d customers found", len(usernames))
 
 	c.Usernames = &usernames
+	c.customers = customers
 	c.Expires = time.Now().Add(customerCacheExpiry)
 
 	return nil
 }
 
-// fetchCustomers reads a list of customers separated by new lines
-// who are valid users of OpenFaaS cloud
-func fetchCustomers(customerURL string) ([]string, error) {
-	customers := []string{}
-
+// fetchCustomers reads the CUSTOMERS file listing the valid users of
+// OpenFaaS cloud, in either format
+func fetchCustomers(customerURL string) ([]byte, error) {
 	if len(customerURL) == 0 {
 		return nil, fmt.Errorf("customerURL was nil")
 	}
 
 	httpReq, _ := http.NewRequest(http.MethodGet, customerURL, nil)
-	res, reqErr := http.DefaultClient.Do(httpReq)
+	res, reqErr := HTTPClient().Do(httpReq)
 
 	if reqErr != nil {
-		return customers, reqErr
+		return nil, reqErr
 	}
 
-	if res.Body != nil {
-		defer res.Body.Close()
-
-		pageBody, _ := ioutil.ReadAll(res.Body)
-
-		for _, c := range strings.Split(string(pageBody), "\n") {
-			if formatted := formatUsername(c); len(formatted) > 0 {
-				customers = append(customers, formatted)
-			}
-		}
+	if res.Body == nil {
+		return []byte{}, nil
 	}
 
-	return customers, nil
+	defer res.Body.Close()
+
+	return ioutil.ReadAll(res.Body)
 }
 
 func formatUsername(input string) string {
 	return strings.TrimSpace(strings.ToLower(input))
 }
+
+// SignCustomer encodes the customer for the CustomerHeader and signs
+// it for the CustomerSignatureHeader
+func SignCustomer(customer CustomerInfo, payloadSecret string) (string, string) {
+	value, _ := json.Marshal(customer)
+	digest := hmac.Sign(value, []byte(payloadSecret))
+	return string(value), "sha1=" + hex.EncodeToString(digest)
+}
+
+// CustomerFromHeader validates the signature of a CustomerHeader and
+// checks that the customer is the owner of the event
+func CustomerFromHeader(value, signature, payloadSecret, owner string) (*CustomerInfo, error) {
+	if err := hmac.Validate([]byte(value), signature, payloadSecret); err != nil {
+		return nil, fmt.Errorf("invalid customer signature")
+	}
+
+	customer := CustomerInfo{}
+	if err := json.Unmarshal([]byte(value), &customer); err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(customer.Login, owner) {
+		return nil, fmt.Errorf("customer %s is not the owner %s", customer.Login, owner)
+	}
+
+	return &customer, nil
+}
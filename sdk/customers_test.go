@@ -183,3 +183,79 @@ func TestformatUsername_TrimsReturn(t *testing.T) {
 		t.Errorf(`want %q, got %q`, want, got)
 	}
 }
+
+func Test_parseCustomer(t *testing.T) {
+	customer, ok := parseCustomer("AlexEllis pro functions=50 rate=100\r")
+	if !ok {
+		t.Fatalf("want a customer")
+	}
+
+	if customer.Login != "alexellis" || customer.Tier != "pro" {
+		t.Errorf("want alexellis on pro, got %+v", customer)
+	}
+	if customer.Entitlements["functions"] != "50" || customer.Entitlements["rate"] != "100" {
+		t.Errorf("want entitlements, got %v", customer.Entitlements)
+	}
+
+	if customer, _ := parseCustomer("openfaas"); customer.Tier != DefaultTier || customer.Entitlements != nil {
+		t.Errorf("want the default tier, got %+v", customer)
+	}
+
+	if _, ok := parseCustomer("  "); ok {
+		t.Errorf("want blank lines skipped")
+	}
+}
+
+func TestLookup_FromFile(t *testing.T) {
+	tmpPath := path.Join(os.TempDir(), "customers-tiers")
+	if err := ioutil.WriteFile(tmpPath, []byte("openfaas\ninlets pro functions=50\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpPath)
+
+	c := NewCustomers(tmpPath, "")
+
+	customer, ok := c.Lookup("Inlets")
+	if !ok || customer.Tier != "pro" || customer.Entitlements["functions"] != "50" {
+		t.Errorf("want inlets on pro, got %+v %t", customer, ok)
+	}
+
+	if found, _ := c.Get("inlets"); !found {
+		t.Errorf("want inlets found by Get")
+	}
+
+	if _, ok := c.Lookup("not-a-customer"); ok {
+		t.Errorf("want no customer")
+	}
+}
+
+func Test_CustomerFromHeader(t *testing.T) {
+	value, signature := SignCustomer(CustomerInfo{Login: "alexellis", Tier: "pro"}, "secret")
+
+	customer, err := CustomerFromHeader(value, signature, "secret", "AlexEllis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customer.Tier != "pro" {
+		t.Errorf("want pro, got %s", customer.Tier)
+	}
+
+	if _, err := CustomerFromHeader(value, signature, "other-secret", "alexellis"); err == nil {
+		t.Errorf("want an error for a bad signature")
+	}
+
+	if _, err := CustomerFromHeader(value, signature, "secret", "openfaas"); err == nil {
+		t.Errorf("want an error when the customer is not the owner")
+	}
+}
+
+func Test_BuildEventFromPushEvent_Customer(t *testing.T) {
+	pushEvent := PushEvent{
+		Customer: &CustomerInfo{Login: "alexellis", Tier: "pro", Entitlements: map[string]string{"functions": "50"}},
+	}
+
+	event := BuildEventFromPushEvent(pushEvent)
+	if event.Tier != "pro" || event.Entitlements["functions"] != "50" {
+		t.Errorf("want tier and entitlements on the event, got %+v", event)
+	}
+}
@@ -0,0 +1,86 @@
+package sdk
+
+import "time"
+
+// DeadLetterSource is the PipelineLog source used to store events
+// which could not be forwarded after all retries were exhausted
+const DeadLetterSource = "dead-letter"
+
+// HeldPushSource is the PipelineLog source used to store a force-push
+// which is waiting for confirmation before it is deployed
+const HeldPushSource = "held-push"
+
+// SecretScanSource is the PipelineLog source used by garbage-collect to
+// record when each unreferenced secret was first found
+const SecretScanSource = "secret-scan"
+
+// DeliverySource is the PipelineLog source used by github-event to
+// record the webhook deliveries it has accepted, so that duplicates
+// and replays are dropped
+const DeliverySource = "delivery"
+
+// ReceivedEventSource is the PipelineLog source used by github-event to
+// keep each owner's recent events, so that they can replay one
+const ReceivedEventSource = "received-event"
+
+// RateLimitSource is the PipelineLog source used by github-event to
+// keep the token bucket of each installation
+const RateLimitSource = "rate-limit"
+
+// FailureBudgetSource is the PipelineLog source used by buildshiprun to
+// count the consecutive failed builds of a repository
+const FailureBudgetSource = "failure-budget"
+
+// GarbageReportSource is the PipelineLog source used by garbage-collect
+// to keep the report of each request, i.e. what an uninstall removed
+const GarbageReportSource = "gc-report"
+
+// MetricsOwnerSource is the PipelineLog source used by buildshiprun to
+// keep the owners given their own metrics label
+const MetricsOwnerSource = "metrics-owner"
+
+// DeployHistorySource is the PipelineLog source used by buildshiprun to
+// keep the deployments of each function which passed verification, so
+// that a failed deployment is rolled back to one of them
+const DeployHistorySource = "deploy-history"
+
+// CanarySource is the PipelineLog source used by buildshiprun to keep
+// the canaries receiving traffic until they are promoted or aborted
+const CanarySource = "canary"
+
+// DeadLetter holds an event and the headers it was forwarded with so
+// that it can be replayed once the downstream function has recovered
+type DeadLetter struct {
+	Function string            `json:"function"`
+	Headers  map[string]string `json:"headers"`
+	Payload  []byte            `json:"payload"`
+	Attempts int               `json:"attempts"`
+	Error    string            `json:"error"`
+	Failed   time.Time         `json:"failed"`
+}
+
+// ReplayRequest selects a dead letter to forward again
+type ReplayRequest struct {
+	RepoPath  string `json:"repoPath"`
+	CommitSHA string `json:"commitSHA"`
+	Function  string `json:"function"`
+}
+
+// ReceivedEvent is an event github-event received and forwarded, as
+// listed to its owner
+type ReceivedEvent struct {
+	ID       string    `json:"id"`
+	Event    string    `json:"event"`
+	Repo     string    `json:"repo"`
+	Ref      string    `json:"ref,omitempty"`
+	SHA      string    `json:"sha,omitempty"`
+	Sender   string    `json:"sender,omitempty"`
+	Received time.Time `json:"received"`
+}
+
+// ReceivedEventRequest lists the owner's recent events, or when ID is
+// set, replays that event
+type ReceivedEventRequest struct {
+	Owner string `json:"owner"`
+	ID    string `json:"id,omitempty"`
+}
@@ -0,0 +1,66 @@
+package sdk
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alexellis/hmac"
+)
+
+// Headers used by github-event to forward the webhook delivery an event
+// came from, the signature is made with the payload-secret over the
+// value and the event's payload
+const (
+	DeliveryHeader          = "X-Cloud-Delivery"
+	DeliverySignatureHeader = "X-Cloud-Delivery-Signature"
+)
+
+// Delivery identifies the webhook delivery which started a pipeline,
+// ID is GitHub's X-GitHub-Delivery GUID which is shown in the app's
+// "Recent Deliveries"
+type Delivery struct {
+	ID       string    `json:"id"`
+	Event    string    `json:"event"`
+	Received time.Time `json:"received"`
+	// CorrelationID is generated by github-event for each pipeline it
+	// starts, a redelivery of the same GUID gets a new one
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+// NewCorrelationID gives a random ID for a pipeline
+func NewCorrelationID() string {
+	return RandomIDs{}.NewID()
+}
+
+// SignDelivery encodes the delivery for the DeliveryHeader and signs it
+// for the DeliverySignatureHeader. The payload is signed with it so that
+// the header can't be moved onto another event.
+func SignDelivery(delivery Delivery, payload []byte, payloadSecret string) (string, string) {
+	value, _ := json.Marshal(delivery)
+	digest := hmac.Sign(deliveryMessage(value, payload), []byte(payloadSecret))
+	return string(value), "sha1=" + hex.EncodeToString(digest)
+}
+
+// DeliveryFromHeader validates the signature of a DeliveryHeader for
+// the payload it was forwarded with
+func DeliveryFromHeader(value, signature string, payload []byte, payloadSecret string) (*Delivery, error) {
+	if err := hmac.Validate(deliveryMessage([]byte(value), payload), signature, payloadSecret); err != nil {
+		return nil, fmt.Errorf("invalid delivery signature")
+	}
+
+	delivery := Delivery{}
+	if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func deliveryMessage(value []byte, payload []byte) []byte {
+	message := make([]byte, 0, len(value)+1+len(payload))
+	message = append(message, value...)
+	message = append(message, '\n')
+	return append(message, payload...)
+}
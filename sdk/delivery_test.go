@@ -0,0 +1,58 @@
+package sdk
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_DeliveryFromHeader(t *testing.T) {
+	payload := []byte(`{"ref":"refs/heads/master"}`)
+	received := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	value, signature := SignDelivery(Delivery{ID: "72d3162e-cc78-11e3-81ab-4c9367dc0958", Event: "push", Received: received, CorrelationID: "9f2c81d04ab3e6f7"}, payload, "secret")
+
+	delivery, err := DeliveryFromHeader(value, signature, payload, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.ID != "72d3162e-cc78-11e3-81ab-4c9367dc0958" || delivery.Event != "push" || !delivery.Received.Equal(received) || delivery.CorrelationID != "9f2c81d04ab3e6f7" {
+		t.Errorf("want the delivery, got %+v", delivery)
+	}
+
+	if _, err := DeliveryFromHeader(value, signature, payload, "other-secret"); err == nil {
+		t.Errorf("want an error for a bad signature")
+	}
+
+	if _, err := DeliveryFromHeader(value, signature, []byte(`{"ref":"refs/heads/dev"}`), "secret"); err == nil {
+		t.Errorf("want an error when the delivery is forwarded with another payload")
+	}
+}
+
+func Test_BuildEventFromPushEvent_Delivery(t *testing.T) {
+	pushEvent := PushEvent{
+		Delivery: &Delivery{ID: "72d3162e-cc78-11e3-81ab-4c9367dc0958", Event: "push"},
+	}
+
+	event := BuildEventFromPushEvent(pushEvent)
+	if event.Delivery == nil || event.Delivery.ID != "72d3162e-cc78-11e3-81ab-4c9367dc0958" {
+		t.Errorf("want the delivery, got %+v", event.Delivery)
+	}
+}
+
+func Test_NewCorrelationID(t *testing.T) {
+	first, second := NewCorrelationID(), NewCorrelationID()
+	if len(first) != 16 || first == second {
+		t.Errorf("want a random ID for each pipeline, got %q and %q", first, second)
+	}
+}
+
+func Test_AuditEvent_Trace(t *testing.T) {
+	audit := AuditEvent{Owner: "alexellis", Source: "git-tar"}.Trace("af6db9c", &Delivery{ID: "72d3162e", CorrelationID: "9f2c81d04ab3e6f7"})
+	if audit.SHA != "af6db9c" || audit.DeliveryID != "72d3162e" || audit.CorrelationID != "9f2c81d04ab3e6f7" || audit.Owner != "alexellis" {
+		t.Errorf("want the commit and delivery on the event, got %+v", audit)
+	}
+
+	if audit := (AuditEvent{}).Trace("af6db9c", nil); audit.SHA != "af6db9c" || len(audit.DeliveryID) > 0 {
+		t.Errorf("want only the commit without a delivery, got %+v", audit)
+	}
+}
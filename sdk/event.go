@@ -22,6 +22,32 @@ type Event struct {
 	RepoURL        string            `json:"repourl"`
 	Labels         map[string]string `json:"labels"`
 	Annotations    map[string]string `json:"annotations"`
+	Constraints    []string          `json:"constraints,omitempty"`
+	Branch         string            `json:"branch,omitempty"`
+	Language       string            `json:"language,omitempty"`
+	Limits         *Resources        `json:"limits,omitempty"`
+	Requests       *Resources        `json:"requests,omitempty"`
+	Tier           string            `json:"tier,omitempty"`
+	Entitlements   map[string]string `json:"entitlements,omitempty"`
+	// SkipBuild deploys Image as-is rather than building it
+	SkipBuild bool `json:"skipBuild,omitempty"`
+	// PullRequest deploys the functions as a preview of the pull request
+	PullRequest int `json:"pullRequest,omitempty"`
+	// Tag is set when a git tag was pushed or released, rather than a
+	// branch
+	Tag string `json:"tag,omitempty"`
+	// License and Languages describe the repository, see RepoMetadata
+	License   string `json:"license,omitempty"`
+	Languages string `json:"languages,omitempty"`
+	// Delivery is the webhook delivery which started the pipeline
+	Delivery *Delivery `json:"delivery,omitempty"`
+}
+
+// Resources are the limits or requests of a function from stack.yml,
+// such as "256Mi" of memory or "500m" of CPU
+type Resources struct {
+	Memory string `json:"memory,omitempty"`
+	CPU    string `json:"cpu,omitempty"`
 }
 
 // BuildEventFromPushEvent function to build Event from PushEvent
@@ -41,8 +67,26 @@ func BuildEventFromPushEvent(pushEvent PushEvent) *Event {
 	info.URL = pushEvent.Repository.CloneURL
 	info.Private = pushEvent.Repository.Private
 
+	if strings.HasPrefix(pushEvent.Ref, "refs/heads/") {
+		info.Branch = strings.TrimPrefix(pushEvent.Ref, "refs/heads/")
+	}
+	info.Tag = TagFromRef(pushEvent.Ref)
+
 	info.SHA = pushEvent.AfterCommitID
 	info.InstallationID = pushEvent.Installation.ID
+	info.PullRequest = pushEvent.PullRequest
+
+	if pushEvent.Metadata != nil {
+		info.License = pushEvent.Metadata.License
+		info.Languages = pushEvent.Metadata.FormatLanguages()
+	}
+
+	if pushEvent.Customer != nil {
+		info.Tier = pushEvent.Customer.Tier
+		info.Entitlements = pushEvent.Customer.Entitlements
+	}
+
+	info.Delivery = pushEvent.Delivery
 
 	return &info
 }
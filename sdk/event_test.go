@@ -20,3 +20,36 @@ func Test_BuildEventFromPushEvent_ForEventKey(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_BuildEventFromPushEvent_ForBranch(t *testing.T) {
+	p := PushEvent{
+		Ref: "refs/heads/staging",
+		Repository: PushEventRepository{
+			Name:  "svc",
+			Owner: Owner{},
+		},
+	}
+
+	event := BuildEventFromPushEvent(p)
+
+	want := "staging"
+	if event.Branch != want {
+		t.Errorf("want %s, got %s", want, event.Branch)
+	}
+}
+
+func Test_BuildEventFromPushEvent_TagHasNoBranch(t *testing.T) {
+	p := PushEvent{
+		Ref: "refs/tags/simple-tag",
+		Repository: PushEventRepository{
+			Name:  "svc",
+			Owner: Owner{},
+		},
+	}
+
+	event := BuildEventFromPushEvent(p)
+
+	if event.Branch != "" {
+		t.Errorf("want no branch for a tag, got %s", event.Branch)
+	}
+}
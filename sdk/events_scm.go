@@ -19,6 +19,32 @@ type PushEvent struct {
 	AfterCommitID string `json:"after"`
 	Installation  PushEventInstallation
 	SCM           string // SCM field is for internal use and not provided by GitHub
+	// BeforeCommitID was the head of the branch before the push, it is
+	// all zeros for a new branch
+	BeforeCommitID string `json:"before,omitempty"`
+	// Forced is set by GitHub when the push rewrote the branch's history
+	Forced     bool             `json:"forced"`
+	HeadCommit *PushEventCommit `json:"head_commit,omitempty"`
+	// Customer is resolved by github-event and is not provided by GitHub
+	Customer *CustomerInfo `json:"customer,omitempty"`
+	// Delivery is the webhook delivery forwarded by github-event
+	Delivery *Delivery `json:"delivery,omitempty"`
+	// PullRequest is set by github-push when the push is the head of a
+	// pull request to be deployed as a preview
+	PullRequest int `json:"pullRequest,omitempty"`
+	// Metadata is read from the GitHub API by github-push
+	Metadata *RepoMetadata `json:"metadata,omitempty"`
+	// Sender is the user whose action triggered the event
+	Sender Sender `json:"sender"`
+	// Archive is the tar of stack.yml and the functions' source which was
+	// uploaded with the CLI, it is unpacked in place of a clone
+	Archive []byte `json:"archive,omitempty"`
+}
+
+// PushEventCommit is the commit at the head of the branch after a push
+type PushEventCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
 }
 
 // Owner is the owner of a GitHub repo
@@ -34,12 +60,14 @@ type PushEventInstallation struct {
 
 // GitLabPushEvent as received from GitLab's system hook event
 type GitLabPushEvent struct {
-	Ref              string           `json:"ref"`
-	UserUsername     string           `json:"user_username"`
-	UserEmail        string           `json:"user_email"`
-	GitLabProject    GitLabProject    `json:"project"`
-	GitLabRepository GitLabRepository `json:"repository"`
-	AfterCommitID    string           `json:"after"`
+	Ref              string            `json:"ref"`
+	UserUsername     string            `json:"user_username"`
+	UserEmail        string            `json:"user_email"`
+	GitLabProject    GitLabProject     `json:"project"`
+	GitLabRepository GitLabRepository  `json:"repository"`
+	AfterCommitID    string            `json:"after"`
+	BeforeCommitID   string            `json:"before"`
+	Commits          []PushEventCommit `json:"commits"`
 }
 
 type GitLabProject struct {
@@ -0,0 +1,101 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GitDeployKeysSecret holds the GitDeployKey of each owner who builds
+// from a plain git server, as a JSON object keyed by the owner
+const GitDeployKeysSecret = "git-deploy-keys"
+
+// GitPushEvent is sent to git-event for a branch which was pushed to a
+// plain git server, i.e. from a post-receive hook or a CI job on Azure
+// DevOps or SourceHut
+type GitPushEvent struct {
+	Owner    string `json:"owner"`
+	Repo     string `json:"repo"`
+	CloneURL string `json:"clone_url"`
+	Ref      string `json:"ref"`
+	After    string `json:"after"`
+	Before   string `json:"before,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Sender   string `json:"sender,omitempty"`
+	Forced   bool   `json:"forced,omitempty"`
+}
+
+// GitDeployKey is an owner's read-only credential for their git server,
+// with the secret their webhooks are signed with
+type GitDeployKey struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// WebhookSecret signs the owner's GitPushEvents with HMAC-SHA256
+	WebhookSecret string `json:"webhook_secret"`
+}
+
+// ReadGitDeployKey reads the owner's key from the git-deploy-keys secret
+func ReadGitDeployKey(owner string) (*GitDeployKey, error) {
+	value, err := ReadSecret(GitDeployKeysSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]GitDeployKey{}
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", GitDeployKeysSecret, err.Error())
+	}
+
+	for name, key := range keys {
+		if strings.EqualFold(name, owner) {
+			RegisterSecret(key.Password)
+			RegisterSecret(key.WebhookSecret)
+			return &key, nil
+		}
+	}
+	return nil, fmt.Errorf("no deploy key for %s in %s", owner, GitDeployKeysSecret)
+}
+
+// GitSCM is any git server which serves repositories over HTTPS
+type GitSCM struct {
+}
+
+// Name is "git"
+func (g *GitSCM) Name() string {
+	return SCMGit
+}
+
+// ValidateWebhook checks the X-Hub-Signature-256 header, which is signed
+// with the owner's webhook secret
+func (g *GitSCM) ValidateWebhook(payload []byte, header http.Header, secret string) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("no webhook secret")
+	}
+	if len(header.Get(GitHubSignature256Header)) == 0 {
+		return fmt.Errorf("missing %s header", GitHubSignature256Header)
+	}
+	return ValidGitHubSignature(payload, header.Get(GitHubSignature256Header), "", secret)
+}
+
+// CloneCredentials gives the owner's deploy key for a private repository
+func (g *GitSCM) CloneCredentials(event PushEvent) (*url.Userinfo, error) {
+	if !event.Repository.Private {
+		return nil, nil
+	}
+
+	key, err := ReadGitDeployKey(event.Repository.Owner.Login)
+	if err != nil {
+		return nil, err
+	}
+
+	return url.UserPassword(key.Username, key.Password), nil
+}
+
+// ReportStatus only clears the statuses, since a plain git server has
+// no commit statuses to report to
+func (g *GitSCM) ReportStatus(status *Status, gatewayURL string, payloadSecret string) error {
+	status.CommitStatuses = make(map[string]CommitStatus)
+	return nil
+}
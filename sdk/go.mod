@@ -5,4 +5,5 @@ go 1.13
 require (
 	github.com/alexellis/hmac v0.0.0-20180624210714-d5d71edd7bc7
 	github.com/openfaas/faas-provider v0.0.0-20180910095832-845bf7aa58cb
+	gopkg.in/yaml.v2 v2.2.8
 )
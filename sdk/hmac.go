@@ -1,12 +1,23 @@
 package sdk
 
 import (
+	cryptohmac "crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/alexellis/hmac"
 )
 
+const (
+	// GitHubSignatureHeader is the sha1 HMAC of a GitHub webhook
+	GitHubSignatureHeader = "X-Hub-Signature"
+	// GitHubSignature256Header is the sha256 HMAC of a GitHub webhook
+	GitHubSignature256Header = "X-Hub-Signature-256"
+)
+
 // HmacEnabled uses validate_hmac env-var to verify if the
 // feature is disabled
 func HmacEnabled() bool {
@@ -36,6 +47,37 @@ func validHMACWithSecretKey(payload *[]byte, secretText string, digest string) e
 	return nil
 }
 
+// ValidGitHubSignature validates the body of a GitHub webhook against
+// the sha256 signature when one was sent, otherwise the sha1 signature.
+func ValidGitHubSignature(payload []byte, signature256 string, signature string, secret string) error {
+	if len(signature256) > 0 {
+		if !strings.HasPrefix(signature256, "sha256=") {
+			return fmt.Errorf("unexpected hashing method in %s", GitHubSignature256Header)
+		}
+
+		messageMAC, err := hex.DecodeString(strings.TrimPrefix(signature256, "sha256="))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", GitHubSignature256Header, err.Error())
+		}
+
+		mac := cryptohmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		if !cryptohmac.Equal(messageMAC, mac.Sum(nil)) {
+			return fmt.Errorf("invalid message digest or secret in %s", GitHubSignature256Header)
+		}
+		return nil
+	}
+
+	if len(signature) == 0 {
+		return fmt.Errorf("missing %s or %s header", GitHubSignature256Header, GitHubSignatureHeader)
+	}
+
+	if err := hmac.Validate(payload, signature, secret); err != nil {
+		return fmt.Errorf("%s in %s", err.Error(), GitHubSignatureHeader)
+	}
+	return nil
+}
+
 func readBool(key string) bool {
 	if val, exists := os.LookupEnv(key); exists {
 		return val != "false" && val != "0"
@@ -1,6 +1,8 @@
 package sdk
 
 import (
+	cryptohmac "crypto/hmac"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"os"
@@ -75,3 +77,40 @@ func Test_HmacEnabled(t *testing.T) {
 		})
 	}
 }
+
+func Test_ValidGitHubSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/master"}`)
+	secret := "webhook-secret"
+
+	mac := cryptohmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	signature256 := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	signature := "sha1=" + hex.EncodeToString(hmac.Sign(body, []byte(secret)))
+
+	cases := []struct {
+		title        string
+		signature256 string
+		signature    string
+		wantErr      bool
+	}{
+		{"sha256", signature256, "", false},
+		{"sha256 preferred over an invalid sha1", signature256, "sha1=00", false},
+		{"sha1 only", "", signature, false},
+		{"invalid sha256", "sha256=00", signature, true},
+		{"sha1 in the sha256 header", signature, "", true},
+		{"invalid sha1", "", "sha1=00", true},
+		{"missing", "", "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			err := ValidGitHubSignature(body, c.signature256, c.signature, secret)
+			if c.wantErr && err == nil {
+				t.Errorf("want an error")
+			}
+			if !c.wantErr && err != nil {
+				t.Errorf("want no error, got %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package sdk
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultHTTPClientTimeout bounds each request made with HTTPClient,
+// unless http_client_timeout is set
+const DefaultHTTPClientTimeout = 60 * time.Second
+
+var (
+	httpClient     *http.Client
+	httpClientOnce sync.Once
+)
+
+// HTTPClientConfig holds the platform-wide settings used to build
+// HTTP clients for the pipeline functions
+type HTTPClientConfig struct {
+	// Timeout for the whole request, 0 means no timeout. Calls which
+	// last for minutes, such as git-tar to buildshiprun, use a client
+	// from NewHTTPClientWithTimeout.
+	Timeout time.Duration
+	// CABundlePath is a PEM file appended to the system roots
+	CABundlePath string
+	// MaxIdleConnsPerHost for connection pooling
+	MaxIdleConnsPerHost int
+}
+
+// NewHTTPClientConfig reads http_client_timeout (default 60s, 0 for no
+// timeout) and ca_bundle_path, the proxy is read from http_proxy,
+// https_proxy and no_proxy.
+func NewHTTPClientConfig() HTTPClientConfig {
+	config := HTTPClientConfig{
+		Timeout:             DefaultHTTPClientTimeout,
+		CABundlePath:        os.Getenv("ca_bundle_path"),
+		MaxIdleConnsPerHost: 32,
+	}
+
+	if val, ok := os.LookupEnv("http_client_timeout"); ok && len(val) > 0 {
+		timeout, err := time.ParseDuration(val)
+		if err != nil {
+			log.Printf("unable to parse http_client_timeout: %s", err.Error())
+		} else {
+			config.Timeout = timeout
+		}
+	}
+
+	return config
+}
+
+// NewHTTPClient builds a client which honours the proxy env-vars,
+// trusts the CA bundle if one is given and pools connections
+func NewHTTPClient(config HTTPClientConfig) (*http.Client, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if len(config.CABundlePath) > 0 {
+		pem, err := ioutil.ReadFile(config.CABundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %s, error: %s", config.CABundlePath, err)
+		}
+
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle: %s", config.CABundlePath)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	return &http.Client{
+		Timeout:   config.Timeout,
+		Transport: transport,
+	}, nil
+}
+
+// HTTPClient returns a shared client built from NewHTTPClientConfig,
+// falling back to a client without the CA bundle if it can't be read.
+func HTTPClient() *http.Client {
+	httpClientOnce.Do(func() {
+		config := NewHTTPClientConfig()
+
+		client, err := NewHTTPClient(config)
+		if err != nil {
+			log.Printf("HTTPClient: %s", err.Error())
+
+			config.CABundlePath = ""
+			client, _ = NewHTTPClient(config)
+		}
+
+		httpClient = client
+	})
+
+	return httpClient
+}
+
+// NewHTTPClientWithTimeout builds a client in the same way as HTTPClient
+// for calls which need longer than http_client_timeout, i.e. a build.
+func NewHTTPClientWithTimeout(timeout time.Duration) *http.Client {
+	config := NewHTTPClientConfig()
+	config.Timeout = timeout
+
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		log.Printf("NewHTTPClientWithTimeout: %s", err.Error())
+
+		config.CABundlePath = ""
+		client, _ = NewHTTPClient(config)
+	}
+
+	return client
+}
+
+// Do sends the request with the shared client, bound to ctx so that
+// it is cancelled with the caller
+func Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return HTTPClient().Do(req.WithContext(ctx))
+}
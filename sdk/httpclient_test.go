@@ -0,0 +1,88 @@
+package sdk
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_NewHTTPClientConfig_Defaults(t *testing.T) {
+	os.Unsetenv("http_client_timeout")
+	os.Unsetenv("ca_bundle_path")
+
+	config := NewHTTPClientConfig()
+	if config.Timeout != DefaultHTTPClientTimeout {
+		t.Errorf("want timeout %s, got %s", DefaultHTTPClientTimeout, config.Timeout)
+	}
+	if len(config.CABundlePath) > 0 {
+		t.Errorf("want no CA bundle, got %s", config.CABundlePath)
+	}
+}
+
+func Test_NewHTTPClientConfig_Overrides(t *testing.T) {
+	os.Setenv("http_client_timeout", "5s")
+	os.Setenv("ca_bundle_path", "/etc/ssl/custom.pem")
+	defer os.Unsetenv("http_client_timeout")
+	defer os.Unsetenv("ca_bundle_path")
+
+	config := NewHTTPClientConfig()
+	if config.Timeout != 5*time.Second {
+		t.Errorf("want timeout 5s, got %s", config.Timeout)
+	}
+	if config.CABundlePath != "/etc/ssl/custom.pem" {
+		t.Errorf("want /etc/ssl/custom.pem, got %s", config.CABundlePath)
+	}
+}
+
+func Test_NewHTTPClient_MissingCABundle(t *testing.T) {
+	_, err := NewHTTPClient(HTTPClientConfig{CABundlePath: "/does/not/exist.pem"})
+	if err == nil {
+		t.Errorf("want error for missing CA bundle")
+	}
+}
+
+func Test_NewHTTPClient_InvalidCABundle(t *testing.T) {
+	f, _ := ioutil.TempFile("", "ca")
+	f.WriteString("not a certificate")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	_, err := NewHTTPClient(HTTPClientConfig{CABundlePath: f.Name()})
+	if err == nil {
+		t.Errorf("want error for CA bundle without certificates")
+	}
+}
+
+func Test_Do_CancelledContext(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+	if _, err := Do(ctx, req); err == nil {
+		t.Errorf("want error for cancelled context")
+	}
+}
+
+func Test_NewHTTPClientConfig_NoTimeout(t *testing.T) {
+	os.Setenv("http_client_timeout", "0")
+	defer os.Unsetenv("http_client_timeout")
+
+	if config := NewHTTPClientConfig(); config.Timeout != 0 {
+		t.Errorf("want no timeout, got %s", config.Timeout)
+	}
+}
+
+func Test_NewHTTPClientWithTimeout(t *testing.T) {
+	if client := NewHTTPClientWithTimeout(15 * time.Minute); client.Timeout != 15*time.Minute {
+		t.Errorf("want timeout 15m, got %s", client.Timeout)
+	}
+}
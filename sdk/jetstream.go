@@ -0,0 +1,426 @@
+package sdk
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EventStream is the JetStream stream which github-event queues
+	// events to when event_queue is jetstream
+	EventStream = "OFC_EVENTS"
+	// EventSubjectPrefix is followed by the function an event is queued
+	// for, i.e. openfaas-cloud.events.github-push
+	EventSubjectPrefix = "openfaas-cloud.events."
+)
+
+// QueuedEvent is published to the EventStream in place of forwarding
+// the event to Function, which event-consumer does in the order the
+// events were received
+type QueuedEvent struct {
+	Function string            `json:"function"`
+	Headers  map[string]string `json:"headers"`
+	Payload  []byte            `json:"payload"`
+	Queued   time.Time         `json:"queued"`
+}
+
+// PubAck is JetStream's acknowledgement of a published message
+type PubAck struct {
+	Stream    string `json:"stream"`
+	Sequence  uint64 `json:"seq"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+// JetStreamMsg is a message fetched from a pull consumer, which must be
+// acknowledged with Ack, Nak or Term
+type JetStreamMsg struct {
+	Subject string
+	Data    []byte
+	reply   string
+}
+
+// Deliveries is the number of times the message has been delivered,
+// read from its ack subject, i.e. $JS.ACK.<stream>.<consumer>.<delivered>...
+func (m JetStreamMsg) Deliveries() int {
+	tokens := strings.Split(m.reply, ".")
+	index := 4
+	if len(tokens) >= 11 {
+		// $JS.ACK.<domain>.<account hash>.<stream>.<consumer>.<delivered>...
+		index = 6
+	}
+	if len(tokens) <= index {
+		return 1
+	}
+
+	delivered, err := strconv.Atoi(tokens[index])
+	if err != nil {
+		return 1
+	}
+	return delivered
+}
+
+// jsError is returned by the JetStream API in place of a response
+type jsError struct {
+	Code        int    `json:"code"`
+	ErrCode     int    `json:"err_code"`
+	Description string `json:"description"`
+}
+
+type jsResponse struct {
+	Error *jsError `json:"error,omitempty"`
+}
+
+// natsMsg is a MSG or HMSG received from the server, status is set from
+// the header of a JetStream status message such as 404 No Messages
+type natsMsg struct {
+	subject string
+	reply   string
+	status  int
+	data    []byte
+}
+
+// JetStream is a connection to a NATS server with JetStream enabled,
+// using the text protocol as PublishNATS does
+type JetStream struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	inbox   string
+	next    int
+	timeout time.Duration
+}
+
+// ConnectJetStream connects to the server at natsURL, i.e.
+// nats://nats.openfaas:4222, replies are received on a unique inbox
+func ConnectJetStream(natsURL string) (*JetStream, error) {
+	conn, reader, err := dialNATS(natsURL, true)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+
+	js := &JetStream{
+		conn:    conn,
+		reader:  reader,
+		inbox:   "_INBOX." + hex.EncodeToString(suffix),
+		timeout: natsTimeout,
+	}
+
+	if _, err := fmt.Fprintf(conn, "SUB %s.* 1\r\n", js.inbox); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return js, nil
+}
+
+// Close closes the connection, unacknowledged messages are delivered
+// again once the consumer's ack_wait has passed
+func (js *JetStream) Close() error {
+	return js.conn.Close()
+}
+
+// EnsureStream creates a work-queue stream for the subjects, messages
+// are removed once acknowledged or after maxAge. A stream which exists
+// is left as it is.
+func (js *JetStream) EnsureStream(name string, subjects []string, maxAge time.Duration, duplicateWindow time.Duration) error {
+	config := map[string]interface{}{
+		"name":             name,
+		"subjects":         subjects,
+		"retention":        "workqueue",
+		"storage":          "file",
+		"max_age":          maxAge.Nanoseconds(),
+		"duplicate_window": duplicateWindow.Nanoseconds(),
+	}
+
+	return js.api("$JS.API.STREAM.CREATE."+name, config)
+}
+
+// EnsureConsumer creates a durable pull consumer which delivers one
+// message at a time, so that events are handled in order, and delivers
+// each message up to maxDeliver times. A consumer which exists is left
+// as it is.
+func (js *JetStream) EnsureConsumer(stream string, durable string, ackWait time.Duration, maxDeliver int) error {
+	config := map[string]interface{}{
+		"stream_name": stream,
+		"config": map[string]interface{}{
+			"durable_name":    durable,
+			"ack_policy":      "explicit",
+			"deliver_policy":  "all",
+			"ack_wait":        ackWait.Nanoseconds(),
+			"max_deliver":     maxDeliver,
+			"max_ack_pending": 1,
+		},
+	}
+
+	return js.api(fmt.Sprintf("$JS.API.CONSUMER.DURABLE.CREATE.%s.%s", stream, durable), config)
+}
+
+func (js *JetStream) api(subject string, request interface{}) error {
+	body, _ := json.Marshal(request)
+
+	msg, err := js.request(subject, nil, body)
+	if err != nil {
+		return err
+	}
+
+	res := jsResponse{}
+	if err := json.Unmarshal(msg.data, &res); err != nil {
+		return fmt.Errorf("unable to parse response from %s: %s", subject, err.Error())
+	}
+
+	if res.Error != nil && !strings.Contains(res.Error.Description, "already") {
+		return fmt.Errorf("%s: %d %s", subject, res.Error.Code, res.Error.Description)
+	}
+	return nil
+}
+
+// Publish publishes to a subject of a stream and waits for JetStream to
+// store it. A msgID, such as the delivery's GUID, is stored once within
+// the stream's duplicate window.
+func (js *JetStream) Publish(subject string, msgID string, payload []byte) (PubAck, error) {
+	headers := map[string]string{}
+	if len(msgID) > 0 {
+		headers["Nats-Msg-Id"] = msgID
+	}
+
+	ack := PubAck{}
+	msg, err := js.request(subject, headers, payload)
+	if err != nil {
+		return ack, err
+	}
+
+	if msg.status == 503 {
+		return ack, fmt.Errorf("no stream for subject %s", subject)
+	}
+
+	res := struct {
+		PubAck
+		Error *jsError `json:"error,omitempty"`
+	}{}
+	if err := json.Unmarshal(msg.data, &res); err != nil {
+		return ack, fmt.Errorf("unable to parse ack: %s", err.Error())
+	}
+	if res.Error != nil {
+		return ack, fmt.Errorf("publish to %s: %d %s", subject, res.Error.Code, res.Error.Description)
+	}
+
+	return res.PubAck, nil
+}
+
+// Fetch gives up to batch messages which are waiting for the durable
+// consumer without waiting for more
+func (js *JetStream) Fetch(stream string, durable string, batch int) ([]JetStreamMsg, error) {
+	reply := js.nextInbox()
+	body, _ := json.Marshal(map[string]interface{}{
+		"batch":   batch,
+		"no_wait": true,
+	})
+
+	js.conn.SetDeadline(time.Now().Add(js.timeout))
+	if err := js.write(fmt.Sprintf("$JS.API.CONSUMER.MSG.NEXT.%s.%s", stream, durable), reply, nil, body); err != nil {
+		return nil, err
+	}
+
+	msgs := []JetStreamMsg{}
+	for len(msgs) < batch {
+		msg, err := js.readReply("")
+		if err != nil {
+			return msgs, err
+		}
+
+		switch msg.status {
+		case 0:
+			msgs = append(msgs, JetStreamMsg{Subject: msg.subject, Data: msg.data, reply: msg.reply})
+		case 100:
+			// Idle heartbeat
+		case 404, 408, 409:
+			// No messages, the request expired or the consumer's
+			// limit of pending messages was reached
+			return msgs, nil
+		default:
+			return msgs, fmt.Errorf("unexpected status from %s: %d", durable, msg.status)
+		}
+	}
+
+	return msgs, nil
+}
+
+// Ack removes a handled message from the stream
+func (js *JetStream) Ack(msg JetStreamMsg) error {
+	return js.acknowledge(msg, "+ACK")
+}
+
+// Nak asks for the message to be delivered again after delay
+func (js *JetStream) Nak(msg JetStreamMsg, delay time.Duration) error {
+	if delay <= 0 {
+		return js.acknowledge(msg, "-NAK")
+	}
+	return js.acknowledge(msg, fmt.Sprintf(`-NAK {"delay": %d}`, delay.Nanoseconds()))
+}
+
+// Term stops the message from being delivered again
+func (js *JetStream) Term(msg JetStreamMsg) error {
+	return js.acknowledge(msg, "+TERM")
+}
+
+// acknowledge waits for the server's PONG so that the acknowledgement
+// has been received before the connection is closed
+func (js *JetStream) acknowledge(msg JetStreamMsg, body string) error {
+	if len(msg.reply) == 0 {
+		return fmt.Errorf("message can't be acknowledged")
+	}
+
+	js.conn.SetDeadline(time.Now().Add(js.timeout))
+	if err := js.write(msg.reply, "", nil, []byte(body)); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(js.conn, "PING\r\n"); err != nil {
+		return err
+	}
+
+	for {
+		line, err := js.reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("waiting for PONG: %s", err.Error())
+		}
+
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (js *JetStream) request(subject string, headers map[string]string, payload []byte) (natsMsg, error) {
+	reply := js.nextInbox()
+
+	js.conn.SetDeadline(time.Now().Add(js.timeout))
+	if err := js.write(subject, reply, headers, payload); err != nil {
+		return natsMsg{}, err
+	}
+
+	return js.readReply(reply)
+}
+
+func (js *JetStream) nextInbox() string {
+	js.next++
+	return fmt.Sprintf("%s.%d", js.inbox, js.next)
+}
+
+// write sends PUB, or HPUB when there are headers
+func (js *JetStream) write(subject string, reply string, headers map[string]string, payload []byte) error {
+	target := subject
+	if len(reply) > 0 {
+		target = subject + " " + reply
+	}
+
+	if len(headers) == 0 {
+		_, err := fmt.Fprintf(js.conn, "PUB %s %d\r\n%s\r\n", target, len(payload), payload)
+		return err
+	}
+
+	header := "NATS/1.0\r\n"
+	for k, v := range headers {
+		header += k + ": " + v + "\r\n"
+	}
+	header += "\r\n"
+
+	_, err := fmt.Fprintf(js.conn, "HPUB %s %d %d\r\n%s%s\r\n", target, len(header), len(header)+len(payload), header, payload)
+	return err
+}
+
+// readReply reads until a message is received on the reply subject, or
+// any message when reply is empty, answering the server's PINGs. One
+// request is made at a time on the inbox.
+func (js *JetStream) readReply(reply string) (natsMsg, error) {
+	for {
+		line, err := js.reader.ReadString('\n')
+		if err != nil {
+			return natsMsg{}, err
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PING":
+			io.WriteString(js.conn, "PONG\r\n")
+		case strings.HasPrefix(line, "-ERR"):
+			return natsMsg{}, fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case strings.HasPrefix(line, "MSG "), strings.HasPrefix(line, "HMSG "):
+			msg, err := readNATSMsg(js.reader, line)
+			if err != nil {
+				return msg, err
+			}
+			// Messages fetched from a consumer keep their own subject
+			if len(reply) == 0 || msg.subject == reply {
+				return msg, nil
+			}
+		}
+	}
+}
+
+// readNATSMsg reads the payload of "MSG <subject> <sid> [reply] <bytes>"
+// or "HMSG <subject> <sid> [reply] <header bytes> <total bytes>"
+func readNATSMsg(reader *bufio.Reader, line string) (natsMsg, error) {
+	fields := strings.Fields(line)
+	headers := fields[0] == "HMSG"
+
+	sizes := 1
+	if headers {
+		sizes = 2
+	}
+	if len(fields) < 3+sizes {
+		return natsMsg{}, fmt.Errorf("invalid message: %s", line)
+	}
+
+	msg := natsMsg{subject: fields[1]}
+	if len(fields) == 4+sizes {
+		msg.reply = fields[3]
+	}
+
+	total, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return msg, fmt.Errorf("invalid message: %s", line)
+	}
+	headerSize := 0
+	if headers {
+		if headerSize, err = strconv.Atoi(fields[len(fields)-2]); err != nil || headerSize > total {
+			return msg, fmt.Errorf("invalid message: %s", line)
+		}
+	}
+
+	body := make([]byte, total+2)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return msg, err
+	}
+
+	msg.data = body[headerSize:total]
+	if headers {
+		msg.status = headerStatus(string(body[:headerSize]))
+	}
+	return msg, nil
+}
+
+// headerStatus reads the status from the first line of a header block,
+// i.e. "NATS/1.0 404 No Messages"
+func headerStatus(header string) int {
+	firstLine := strings.SplitN(header, "\r\n", 2)[0]
+	fields := strings.Fields(firstLine)
+	if len(fields) < 2 {
+		return 0
+	}
+
+	status, _ := strconv.Atoi(fields[1])
+	return status
+}
@@ -0,0 +1,170 @@
+package sdk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeJetStream serves one connection, answering requests to subjects
+// in responses with their value. A fetch is answered with the messages
+// in pending, then a 404 status. Acknowledgements are sent to acks.
+func fakeJetStream(t *testing.T, responses map[string]string, pending []string) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acks := make(chan string, 10)
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"headers\":true}\r\n")
+
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			switch fields[0] {
+			case "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			case "PUB", "HPUB":
+				size, _ := strconv.Atoi(fields[len(fields)-1])
+				body := make([]byte, size+2)
+				io.ReadFull(reader, body)
+
+				subject, reply := fields[1], ""
+				if (fields[0] == "PUB" && len(fields) == 4) || (fields[0] == "HPUB" && len(fields) == 5) {
+					reply = fields[2]
+				}
+
+				switch {
+				case strings.HasPrefix(subject, "$JS.ACK."):
+					acks <- string(body[:size])
+				case strings.HasPrefix(subject, "$JS.API.CONSUMER.MSG.NEXT."):
+					for i, msg := range pending {
+						ackSubject := fmt.Sprintf("$JS.ACK.OFC_EVENTS.event-consumer.%d.%d.%d.0.0", i+1, i+1, i+1)
+						fmt.Fprintf(conn, "MSG openfaas-cloud.events.github-push 1 %s %d\r\n%s\r\n", ackSubject, len(msg), msg)
+					}
+					status := "NATS/1.0 404 No Messages\r\n\r\n"
+					fmt.Fprintf(conn, "HMSG %s 1 %d %d\r\n%s\r\n", reply, len(status), len(status), status)
+				default:
+					if res, ok := responses[subject]; ok {
+						fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", reply, len(res), res)
+					}
+				}
+			}
+		}
+	}()
+
+	return "nats://" + listener.Addr().String(), acks
+}
+
+func Test_JetStream_Publish(t *testing.T) {
+	natsURL, _ := fakeJetStream(t, map[string]string{
+		"$JS.API.STREAM.CREATE.OFC_EVENTS":  `{"error":{"code":400,"err_code":10058,"description":"stream name already in use"}}`,
+		"openfaas-cloud.events.github-push": `{"stream":"OFC_EVENTS","seq":7}`,
+	}, nil)
+
+	js, err := ConnectJetStream(natsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+
+	if err := js.EnsureStream(EventStream, []string{EventSubjectPrefix + ">"}, time.Hour, time.Minute); err != nil {
+		t.Fatalf("want an existing stream to be used, got %s", err)
+	}
+
+	ack, err := js.Publish(EventSubjectPrefix+"github-push", "72d3162e", []byte(`{"function":"github-push"}`))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if ack.Stream != EventStream || ack.Sequence != 7 {
+		t.Errorf("want sequence 7 of OFC_EVENTS, got %+v", ack)
+	}
+}
+
+func Test_JetStream_PublishError(t *testing.T) {
+	natsURL, _ := fakeJetStream(t, map[string]string{
+		"openfaas-cloud.events.github-push": `{"error":{"code":503,"description":"stream is full"}}`,
+	}, nil)
+
+	js, err := ConnectJetStream(natsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+
+	if _, err := js.Publish(EventSubjectPrefix+"github-push", "", []byte("{}")); err == nil || !strings.Contains(err.Error(), "stream is full") {
+		t.Errorf("want the error from JetStream, got %v", err)
+	}
+}
+
+func Test_JetStream_FetchAndAck(t *testing.T) {
+	natsURL, acks := fakeJetStream(t, nil, []string{`{"function":"github-push"}`})
+
+	js, err := ConnectJetStream(natsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+
+	msgs, err := js.Fetch(EventStream, "event-consumer", 10)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if len(msgs) != 1 || string(msgs[0].Data) != `{"function":"github-push"}` {
+		t.Fatalf("want the pending message, got %v", msgs)
+	}
+	if msgs[0].Subject != "openfaas-cloud.events.github-push" || msgs[0].Deliveries() != 1 {
+		t.Errorf("want the first delivery of the message, got %s after %d", msgs[0].Subject, msgs[0].Deliveries())
+	}
+
+	if err := js.Nak(msgs[0], time.Second); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if got := <-acks; got != `-NAK {"delay": 1000000000}` {
+		t.Errorf("want a delayed NAK, got %q", got)
+	}
+
+	if err := js.Ack(msgs[0]); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if got := <-acks; got != "+ACK" {
+		t.Errorf("want +ACK, got %q", got)
+	}
+}
+
+func Test_JetStreamMsg_Deliveries(t *testing.T) {
+	cases := map[string]int{
+		"$JS.ACK.OFC_EVENTS.event-consumer.3.10.9.1600000000.0":             3,
+		"$JS.ACK.hub.ACCHASH.OFC_EVENTS.event-consumer.2.10.9.1600000000.0": 2,
+		"": 1,
+	}
+
+	for reply, want := range cases {
+		if got := (JetStreamMsg{reply: reply}).Deliveries(); got != want {
+			t.Errorf("%q: want %d, got %d", reply, want, got)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package sdk
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alexellis/hmac"
+)
+
+// ManifestSource is the PipelineLog source used to store
+// signed deployment manifests alongside the build log
+const ManifestSource = "deploy-manifest"
+
+// DeploymentManifest is a record of the spec applied to the gateway
+// for a given SHA, signed with the payload-secret so that it can be
+// verified later
+type DeploymentManifest struct {
+	Owner     string          `json:"owner"`
+	Repo      string          `json:"repo"`
+	SHA       string          `json:"sha"`
+	Function  string          `json:"function"`
+	Image     string          `json:"image"`
+	Spec      json.RawMessage `json:"spec"`
+	Deployed  time.Time       `json:"deployed"`
+	Signature string          `json:"signature,omitempty"`
+}
+
+// Sign computes the signature over the manifest with an empty
+// Signature field and stores it in the format sha1=<digest>
+func (m *DeploymentManifest) Sign(payloadSecret string) error {
+	m.Signature = ""
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	digest := hmac.Sign(body, []byte(payloadSecret))
+	m.Signature = "sha1=" + hex.EncodeToString(digest)
+	return nil
+}
+
+// Verify returns an error if the manifest has been changed since
+// it was signed
+func (m DeploymentManifest) Verify(payloadSecret string) error {
+	signature := m.Signature
+	if len(signature) == 0 {
+		return fmt.Errorf("manifest for %s is not signed", m.Function)
+	}
+
+	m.Signature = ""
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	if err := validHMACWithSecretKey(&body, payloadSecret, signature); err != nil {
+		return fmt.Errorf("manifest for %s has been tampered with", m.Function)
+	}
+
+	return nil
+}
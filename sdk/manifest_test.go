@@ -0,0 +1,49 @@
+package sdk
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func Test_DeploymentManifest_SignAndVerify(t *testing.T) {
+	m := DeploymentManifest{
+		Owner:    "alexellis",
+		Repo:     "super-pancake",
+		SHA:      "af6db",
+		Function: "alexellis-fn1",
+		Image:    "registry:5000/alexellis/fn1:af6db",
+		Spec:     json.RawMessage(`{"FunctionName":"alexellis-fn1"}`),
+		Deployed: time.Unix(1546300800, 0).UTC(),
+	}
+
+	if err := m.Sign("secret"); err != nil {
+		t.Fatalf("unexpected error signing: %s", err)
+	}
+
+	if err := m.Verify("secret"); err != nil {
+		t.Errorf("want valid signature, got: %s", err)
+	}
+}
+
+func Test_DeploymentManifest_VerifyDetectsTampering(t *testing.T) {
+	m := DeploymentManifest{
+		Function: "alexellis-fn1",
+		Image:    "registry:5000/alexellis/fn1:af6db",
+	}
+	m.Sign("secret")
+
+	m.Image = "registry:5000/alexellis/fn1:evil"
+
+	if err := m.Verify("secret"); err == nil {
+		t.Errorf("want error for tampered manifest")
+	}
+}
+
+func Test_DeploymentManifest_VerifyUnsigned(t *testing.T) {
+	m := DeploymentManifest{Function: "alexellis-fn1"}
+
+	if err := m.Verify("secret"); err == nil {
+		t.Errorf("want error for unsigned manifest")
+	}
+}
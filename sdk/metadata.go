@@ -0,0 +1,54 @@
+package sdk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LicenseAnnotation records the SPDX ID of the repository's license,
+// i.e. MIT
+const LicenseAnnotation = FunctionLabelPrefix + "git-license"
+
+// LanguagesAnnotation records the languages of the repository by their
+// share of the code, i.e. Go=82.5,Shell=17.5
+const LanguagesAnnotation = FunctionLabelPrefix + "git-languages"
+
+// RepoMetadata describes the repository a function was built from, as
+// given by the GitHub API
+type RepoMetadata struct {
+	// License is the SPDX ID, or NOASSERTION when GitHub could not
+	// identify the license
+	License string `json:"license,omitempty"`
+	// Languages are the bytes of code by language
+	Languages map[string]int `json:"languages,omitempty"`
+}
+
+// FormatLanguages gives each language with its percentage of the code,
+// largest first
+func (m *RepoMetadata) FormatLanguages() string {
+	total := 0
+	names := []string{}
+	for name, bytes := range m.Languages {
+		total += bytes
+		names = append(names, name)
+	}
+
+	if total == 0 {
+		return ""
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if m.Languages[names[i]] == m.Languages[names[j]] {
+			return names[i] < names[j]
+		}
+		return m.Languages[names[i]] > m.Languages[names[j]]
+	})
+
+	values := []string{}
+	for _, name := range names {
+		share := float64(m.Languages[name]) * 100 / float64(total)
+		values = append(values, fmt.Sprintf("%s=%.1f", name, share))
+	}
+	return strings.Join(values, ",")
+}
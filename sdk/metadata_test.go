@@ -0,0 +1,22 @@
+package sdk
+
+import "testing"
+
+func Test_RepoMetadata_FormatLanguages(t *testing.T) {
+	metadata := RepoMetadata{
+		Languages: map[string]int{"Shell": 175, "Go": 800, "Dockerfile": 25},
+	}
+
+	want := "Go=80.0,Shell=17.5,Dockerfile=2.5"
+	if got := metadata.FormatLanguages(); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func Test_RepoMetadata_FormatLanguages_Empty(t *testing.T) {
+	metadata := RepoMetadata{}
+
+	if got := metadata.FormatLanguages(); got != "" {
+		t.Errorf("want no languages, got %s", got)
+	}
+}
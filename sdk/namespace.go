@@ -0,0 +1,42 @@
+package sdk
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultNamespacePrefix prefixes the namespace of each owner's
+// functions with owner_namespaces=true
+const defaultNamespacePrefix = "openfaas-fn-"
+
+// OwnerNamespaces is enabled with owner_namespaces=true to deploy
+// each owner's functions into their own namespace using the gateway's
+// multi-namespace support, instead of prefixing the function name.
+func OwnerNamespaces() bool {
+	return os.Getenv("owner_namespaces") == "true"
+}
+
+// OwnerNamespace returns the namespace for the owner's functions, such
+// as openfaas-fn-alexellis, or an empty string for the gateway's
+// default namespace.
+func OwnerNamespace(owner string) string {
+	if !OwnerNamespaces() {
+		return ""
+	}
+
+	prefix := os.Getenv("namespace_prefix")
+	if len(prefix) == 0 {
+		prefix = defaultNamespacePrefix
+	}
+	return prefix + strings.ToLower(owner)
+}
+
+// FunctionName gives the name of the function on the gateway, which
+// is only prefixed with the owner when sharing a namespace.
+func FunctionName(owner, service string) string {
+	if OwnerNamespaces() {
+		return strings.ToLower(service)
+	}
+
+	return FormatServiceName(owner, service)
+}
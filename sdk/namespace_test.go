@@ -0,0 +1,36 @@
+package sdk
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_OwnerNamespace_DefaultsToGatewayNamespace(t *testing.T) {
+	os.Unsetenv("owner_namespaces")
+
+	if got := OwnerNamespace("alexellis"); got != "" {
+		t.Errorf("want empty namespace, got %s", got)
+	}
+	if got := FunctionName("alexellis", "fn1"); got != "alexellis-fn1" {
+		t.Errorf("want alexellis-fn1, got %s", got)
+	}
+}
+
+func Test_OwnerNamespace_PerOwner(t *testing.T) {
+	os.Setenv("owner_namespaces", "true")
+	defer os.Unsetenv("owner_namespaces")
+
+	if got := OwnerNamespace("AlexEllis"); got != "openfaas-fn-alexellis" {
+		t.Errorf("want openfaas-fn-alexellis, got %s", got)
+	}
+	if got := FunctionName("alexellis", "fn1"); got != "fn1" {
+		t.Errorf("want fn1, got %s", got)
+	}
+
+	os.Setenv("namespace_prefix", "customer-")
+	defer os.Unsetenv("namespace_prefix")
+
+	if got := OwnerNamespace("alexellis"); got != "customer-alexellis" {
+		t.Errorf("want customer-alexellis, got %s", got)
+	}
+}
@@ -0,0 +1,297 @@
+package sdk
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// FunctionDeployedEvent is published when a function is deployed
+	FunctionDeployedEvent = "function-deployed"
+	// DeployFailedEvent is published when a function's pipeline fails
+	DeployFailedEvent = "deploy-failed"
+	// PipelineStageEvent is published for each commit status reported
+	// by the pipeline, i.e. a function's build going from pending to
+	// success
+	PipelineStageEvent = "pipeline-stage"
+
+	defaultDeploymentSubject = "openfaas-cloud.deployments"
+	defaultPipelineSubject   = "openfaas-cloud.pipeline"
+	natsTimeout              = 5 * time.Second
+)
+
+// DeploymentEvent is published to NATS after each deployment so that
+// other systems can subscribe instead of polling the gateway. Stage and
+// Status are the context and state of a pipeline-stage event.
+type DeploymentEvent struct {
+	Type      string    `json:"type"`
+	Owner     string    `json:"owner"`
+	Repo      string    `json:"repo"`
+	Function  string    `json:"function"`
+	SHA       string    `json:"sha"`
+	Branch    string    `json:"branch,omitempty"`
+	Image     string    `json:"image,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Stage     string    `json:"stage,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PublishDeploymentEvent publishes the event to the nats_subject on the
+// server at nats_url, i.e. nats://nats.openfaas:4222. Publishing is
+// disabled when nats_url is empty and errors are only logged.
+func PublishDeploymentEvent(event DeploymentEvent) {
+	natsURL := os.Getenv("nats_url")
+	if len(natsURL) == 0 {
+		return
+	}
+
+	subject := DeploymentSubject()
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	bytesOut, _ := json.Marshal(&event)
+	if err := PublishNATS(natsURL, subject, bytesOut); err != nil {
+		log.Printf("PublishDeploymentEvent %s: %s", subject, err.Error())
+	}
+}
+
+// DeploymentSubject is the subject deployment events are published to,
+// set with nats_subject
+func DeploymentSubject() string {
+	if subject := os.Getenv("nats_subject"); len(subject) > 0 {
+		return subject
+	}
+	return defaultDeploymentSubject
+}
+
+// PipelineSubject is the subject pipeline-stage events are published
+// to, set with nats_pipeline_subject
+func PipelineSubject() string {
+	if subject := os.Getenv("nats_pipeline_subject"); len(subject) > 0 {
+		return subject
+	}
+	return defaultPipelineSubject
+}
+
+// PipelineEvents gives a pipeline-stage event for each commit status,
+// the function is empty for the stack's status
+func PipelineEvents(status *Status) []DeploymentEvent {
+	events := []DeploymentEvent{}
+	now := time.Now().UTC()
+
+	for _, commitStatus := range status.CommitStatuses {
+		function := status.EventInfo.Service
+		if commitStatus.Context == StackContext {
+			function = ""
+		}
+
+		events = append(events, DeploymentEvent{
+			Type:      PipelineStageEvent,
+			Owner:     status.EventInfo.Owner,
+			Repo:      status.EventInfo.Repository,
+			Function:  function,
+			SHA:       status.EventInfo.SHA,
+			Branch:    status.EventInfo.Branch,
+			Message:   commitStatus.Description,
+			Stage:     ContextName(commitStatus.Context),
+			Status:    commitStatus.Status,
+			Timestamp: now,
+		})
+	}
+
+	return events
+}
+
+// PublishPipelineEvents publishes the status as pipeline-stage events
+// to the PipelineSubject, so that the dashboard can follow a build as
+// it happens. As with PublishDeploymentEvent it is disabled when
+// nats_url is empty and errors are only logged.
+func PublishPipelineEvents(status *Status) {
+	natsURL := os.Getenv("nats_url")
+	if len(natsURL) == 0 {
+		return
+	}
+
+	subject := PipelineSubject()
+	for _, event := range PipelineEvents(status) {
+		bytesOut, _ := json.Marshal(&event)
+		if err := PublishNATS(natsURL, subject, bytesOut); err != nil {
+			log.Printf("PublishPipelineEvents %s: %s", subject, err.Error())
+			return
+		}
+	}
+}
+
+// PublishNATS publishes a single message using the NATS text protocol,
+// waiting for the server's PONG so that the message has been accepted
+// before returning. A user and password can be given in the URL.
+func PublishNATS(natsURL string, subject string, payload []byte) error {
+	conn, reader, err := dialNATS(natsURL, false)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload)
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return err
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("waiting for PONG: %s", err.Error())
+		}
+
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// SubscribeNATS subscribes to the subjects on the server at natsURL and
+// calls fn with each message until ctx is done, when it returns nil, or
+// the connection fails
+func SubscribeNATS(ctx context.Context, natsURL string, subjects []string, fn func(subject string, data []byte)) error {
+	conn, reader, err := dialNATS(natsURL, false)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The connection is held open, so only the dial has a deadline
+	conn.SetDeadline(time.Time{})
+
+	subs := ""
+	for i, subject := range subjects {
+		subs += fmt.Sprintf("SUB %s %d\r\n", subject, i+1)
+	}
+	if _, err := conn.Write([]byte(subs + "PING\r\n")); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			conn.Write([]byte("PONG\r\n"))
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case strings.HasPrefix(line, "MSG "):
+			msg, err := readNATSMsg(reader, line)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			fn(msg.subject, msg.data)
+		}
+	}
+}
+
+// dialNATS connects to the server at natsURL and sends CONNECT, headers
+// are enabled for JetStream's status messages. Only plain connections
+// with user and password or no auth are supported, a server which needs
+// TLS is refused rather than sent credentials in the clear. This client
+// is to be replaced by github.com/nats-io/nats.go once it is vendored.
+func dialNATS(natsURL string, headers bool) (net.Conn, *bufio.Reader, error) {
+	u, err := url.Parse(natsURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid nats_url: %s", err.Error())
+	}
+	if u.Scheme == "tls" {
+		return nil, nil, fmt.Errorf("invalid nats_url: TLS is not supported")
+	}
+
+	host := u.Host
+	if len(host) == 0 {
+		host = natsURL
+	}
+	if !strings.Contains(host, ":") {
+		host = host + ":4222"
+	}
+
+	conn, err := net.DialTimeout("tcp", host, natsTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+	reader := bufio.NewReader(conn)
+
+	info, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("reading INFO: %s", err.Error())
+	}
+	if !strings.HasPrefix(info, "INFO") {
+		conn.Close()
+		return nil, nil, fmt.Errorf("unexpected greeting: %s", strings.TrimSpace(info))
+	}
+
+	serverInfo := struct {
+		TLSRequired bool `json:"tls_required"`
+	}{}
+	json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(info, "INFO"))), &serverInfo)
+	if serverInfo.TLSRequired {
+		conn.Close()
+		return nil, nil, fmt.Errorf("server at %s requires TLS, which is not supported", host)
+	}
+
+	connect := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "openfaas-cloud",
+	}
+	if headers {
+		connect["headers"] = true
+		connect["no_responders"] = true
+	}
+	if u.User != nil {
+		connect["user"] = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			connect["pass"] = password
+		}
+	}
+	connectOut, _ := json.Marshal(connect)
+
+	if _, err := conn.Write([]byte(fmt.Sprintf("CONNECT %s\r\n", connectOut))); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, reader, nil
+}
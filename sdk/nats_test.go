@@ -0,0 +1,212 @@
+package sdk
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS accepts one connection and records the CONNECT options and
+// the published message, replying with reply to the PING
+func fakeNATS(t *testing.T, reply string) (string, chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []string, 1)
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\n")
+
+		lines := []string{}
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimSpace(line)
+			lines = append(lines, line)
+			if line == "PING" {
+				fmt.Fprint(conn, reply+"\r\n")
+				break
+			}
+		}
+
+		received <- lines
+	}()
+
+	return "nats://" + listener.Addr().String(), received
+}
+
+func Test_PublishNATS(t *testing.T) {
+	natsURL, received := fakeNATS(t, "PONG")
+
+	err := PublishNATS(natsURL, "openfaas-cloud.deployments", []byte(`{"type":"function-deployed"}`))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	lines := <-received
+	if len(lines) != 4 {
+		t.Fatalf("want CONNECT, PUB, payload and PING, got %v", lines)
+	}
+	if lines[1] != "PUB openfaas-cloud.deployments 28" {
+		t.Errorf("want PUB with the payload length, got %q", lines[1])
+	}
+	if lines[2] != `{"type":"function-deployed"}` {
+		t.Errorf("want payload, got %q", lines[2])
+	}
+}
+
+func Test_PublishNATS_Credentials(t *testing.T) {
+	natsURL, received := fakeNATS(t, "PONG")
+
+	err := PublishNATS(strings.Replace(natsURL, "nats://", "nats://ofc:secret@", 1), "subject", []byte("{}"))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	lines := <-received
+	connect := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[0], "CONNECT ")), &connect); err != nil {
+		t.Fatalf("unable to parse %q: %s", lines[0], err)
+	}
+	if connect["user"] != "ofc" || connect["pass"] != "secret" {
+		t.Errorf("want user and pass in CONNECT, got %v", connect)
+	}
+}
+
+func Test_PublishNATS_ServerError(t *testing.T) {
+	natsURL, _ := fakeNATS(t, "-ERR 'Authorization Violation'")
+
+	err := PublishNATS(natsURL, "subject", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("want server error, got %v", err)
+	}
+}
+
+func Test_PublishNATS_TLSRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"tls_required\":true}\r\n")
+		bufio.NewReader(conn).ReadString('\n')
+	}()
+
+	err = PublishNATS("nats://ofc:secret@"+listener.Addr().String(), "subject", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "requires TLS") {
+		t.Errorf("want TLS to be refused, got %v", err)
+	}
+
+	err = PublishNATS("tls://"+listener.Addr().String(), "subject", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "TLS is not supported") {
+		t.Errorf("want tls:// to be refused, got %v", err)
+	}
+}
+
+func Test_PipelineEvents(t *testing.T) {
+	status := BuildStatus(&Event{Owner: "alexellis", Repository: "fn1", Service: "alexellis-fn1", SHA: "a1b2c3d"}, EmptyAuthToken)
+	status.AddStatus(StatusPending, "building", BuildFunctionContext("fn1"))
+
+	events := PipelineEvents(status)
+	if len(events) != 1 {
+		t.Fatalf("want one event, got %d", len(events))
+	}
+
+	event := events[0]
+	if event.Type != PipelineStageEvent || event.Owner != "alexellis" || event.Function != "alexellis-fn1" ||
+		event.Stage != "fn1" || event.Status != StatusPending || event.Message != "building" {
+		t.Errorf("want the pending build of fn1, got %+v", event)
+	}
+
+	status = BuildStatus(&Event{Owner: "alexellis", Repository: "fn1", Service: "alexellis-fn1"}, EmptyAuthToken)
+	status.AddStatus(StatusSuccess, "deployed", StackContext)
+	if events := PipelineEvents(status); len(events) != 1 || len(events[0].Function) > 0 {
+		t.Errorf("want the stack's event without a function, got %+v", events)
+	}
+}
+
+func Test_SubscribeNATS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	subs := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\n")
+
+		lines := []string{}
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			if line == "PING" {
+				break
+			}
+			lines = append(lines, line)
+		}
+		subs <- lines
+
+		fmt.Fprint(conn, "PONG\r\nPING\r\n")
+		fmt.Fprint(conn, "MSG openfaas-cloud.pipeline 2 7\r\n{\"a\":1}\r\n")
+
+		// Held open until the subscriber closes the connection
+		reader.ReadString('\n')
+		reader.ReadString('\n')
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	received := []string{}
+	err = SubscribeNATS(ctx, "nats://"+listener.Addr().String(), []string{"openfaas-cloud.deployments", "openfaas-cloud.pipeline"}, func(subject string, data []byte) {
+		received = append(received, subject+" "+string(data))
+		cancel()
+	})
+	if err != nil {
+		t.Fatalf("want no error once cancelled, got %s", err)
+	}
+
+	lines := <-subs
+	if len(lines) != 3 || lines[1] != "SUB openfaas-cloud.deployments 1" || lines[2] != "SUB openfaas-cloud.pipeline 2" {
+		t.Errorf("want CONNECT and a SUB for each subject, got %v", lines)
+	}
+	if len(received) != 1 || received[0] != `openfaas-cloud.pipeline {"a":1}` {
+		t.Errorf("want the message, got %v", received)
+	}
+}
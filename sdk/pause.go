@@ -0,0 +1,19 @@
+package sdk
+
+// PausedAnnotation is set on each function of an owner whose GitHub App
+// installation is suspended, the router answers for it with a 403
+const PausedAnnotation = FunctionLabelPrefix + "paused"
+
+// PauseRequest selects the owner whose functions are paused or resumed,
+// the reason is recorded in the audit event
+type PauseRequest struct {
+	Owner  string `json:"owner"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ResumePipelineRequest resumes the pipeline of a repository which was
+// paused after too many failed builds, User is who asked for it
+type ResumePipelineRequest struct {
+	RepoPath string `json:"repoPath"`
+	User     string `json:"user,omitempty"`
+}
@@ -1,5 +1,25 @@
 package sdk
 
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/alexellis/hmac"
+)
+
+// Stages of a pipeline run recorded with the pipeline-watchdog
+const (
+	StageBuilding  = "building"
+	StageDeploying = "deploying"
+	StageCompleted = "completed"
+)
+
 // PipelineLog stores a log output from a given stage of
 // a pipeline such as the container builder
 type PipelineLog struct {
@@ -9,3 +29,97 @@ type PipelineLog struct {
 	Source    string
 	Data      string
 }
+
+// PipelineRun records the stage a pipeline run for a function
+// has reached, so that runs which never complete can be detected
+type PipelineRun struct {
+	Event   Event     `json:"event"`
+	Stage   string    `json:"stage"`
+	Started time.Time `json:"started"`
+}
+
+// WritePipelineLog stores the record in pipeline-log, signed with the
+// payload-secret. gatewayURL ends with a slash.
+func WritePipelineLog(gatewayURL string, payloadSecret string, p PipelineLog) error {
+	return sendPipelineLog(http.MethodPost, gatewayURL, payloadSecret, p)
+}
+
+// DeletePipelineLog removes the record kept under the RepoPath,
+// CommitSHA, Function and Source of p, signed as WritePipelineLog is
+func DeletePipelineLog(gatewayURL string, payloadSecret string, p PipelineLog) error {
+	return sendPipelineLog(http.MethodDelete, gatewayURL, payloadSecret, p)
+}
+
+func sendPipelineLog(method string, gatewayURL string, payloadSecret string, p PipelineLog) error {
+	pipelineBytes, _ := json.Marshal(p)
+	req, _ := http.NewRequest(method, gatewayURL+"function/pipeline-log", bytes.NewReader(pipelineBytes))
+
+	digest := hmac.Sign(pipelineBytes, []byte(payloadSecret))
+	req.Header.Add(CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+	res, err := HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("unexpected status code from pipeline-log: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// ReadPipelineLog reads the record kept under the RepoPath, CommitSHA,
+// Function and Source of p, the body is empty when there is none
+func ReadPipelineLog(gatewayURL string, p PipelineLog) ([]byte, error) {
+	query := url.Values{}
+	query.Set("repoPath", p.RepoPath)
+	query.Set("commitSHA", p.CommitSHA)
+	query.Set("function", p.Function)
+	query.Set("source", p.Source)
+
+	return getPipelineLog(gatewayURL, query)
+}
+
+// ListPipelineLog gives the keys, in place of the CommitSHA, of the
+// records of the Function and Source of p under its RepoPath, in order
+func ListPipelineLog(gatewayURL string, p PipelineLog) ([]string, error) {
+	query := url.Values{}
+	query.Set("repoPath", p.RepoPath)
+	query.Set("function", p.Function)
+	query.Set("source", p.Source)
+	query.Set("list", "true")
+
+	body, err := getPipelineLog(gatewayURL, query)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return nil, fmt.Errorf("unable to parse keys from pipeline-log: %s", err.Error())
+	}
+	return keys, nil
+}
+
+func getPipelineLog(gatewayURL string, query url.Values) ([]byte, error) {
+	res, err := HTTPClient().Get(gatewayURL + "function/pipeline-log?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from pipeline-log: %d", res.StatusCode)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,93 @@
+package sdk
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/alexellis/hmac"
+)
+
+func Test_WritePipelineLog_Signed(t *testing.T) {
+	var got PipelineLog
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		want := "sha1=" + hex.EncodeToString(hmac.Sign(body, []byte("secret")))
+		if r.URL.Path != "/function/pipeline-log" || r.Header.Get(CloudSignatureHeader) != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &got)
+	}))
+	defer server.Close()
+
+	p := PipelineLog{RepoPath: "alexellis/fn", CommitSHA: "sha", Function: "fn", Source: ManifestSource, Data: "{}"}
+	if err := WritePipelineLog(server.URL+"/", "secret", p); err != nil {
+		t.Fatal(err)
+	}
+	if got != p {
+		t.Errorf("want: %v, got: %v", p, got)
+	}
+
+	if err := WritePipelineLog(server.URL+"/", "wrong", p); err == nil {
+		t.Errorf("want an error when pipeline-log rejects the record")
+	}
+}
+
+func Test_DeletePipelineLog_Signed(t *testing.T) {
+	var got PipelineLog
+	method := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		want := "sha1=" + hex.EncodeToString(hmac.Sign(body, []byte("secret")))
+		if r.Header.Get(CloudSignatureHeader) != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		method = r.Method
+		json.Unmarshal(body, &got)
+	}))
+	defer server.Close()
+
+	p := PipelineLog{RepoPath: "system/received/alexellis", CommitSHA: "key", Function: "github-event", Source: ReceivedEventSource}
+	if err := DeletePipelineLog(server.URL+"/", "secret", p); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodDelete || got != p {
+		t.Errorf("want %v deleted, got: %s %v", p, method, got)
+	}
+}
+
+func Test_ReadPipelineLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("list") == "true" {
+			w.Write([]byte(`["a","b"]`))
+			return
+		}
+		if query.Get("repoPath") != "alexellis/fn" || query.Get("commitSHA") != "sha" || query.Get("source") != ManifestSource {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("record"))
+	}))
+	defer server.Close()
+
+	body, err := ReadPipelineLog(server.URL+"/", PipelineLog{RepoPath: "alexellis/fn", CommitSHA: "sha", Function: "fn", Source: ManifestSource})
+	if err != nil || string(body) != "record" {
+		t.Errorf("want record, got: %q, %v", body, err)
+	}
+
+	if _, err := ReadPipelineLog(server.URL+"/", PipelineLog{RepoPath: "alexellis/other"}); err == nil {
+		t.Errorf("want an error for a status other than 200")
+	}
+
+	keys, err := ListPipelineLog(server.URL+"/", PipelineLog{RepoPath: "alexellis/fn", Function: "fn", Source: ManifestSource})
+	if err != nil || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("want [a b], got: %v, %v", keys, err)
+	}
+}
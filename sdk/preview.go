@@ -0,0 +1,56 @@
+package sdk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PullRequestLabel records the pull request a preview function was
+// deployed for, so that it can be removed when the pull request closes
+const PullRequestLabel = FunctionLabelPrefix + "git-pull-request"
+
+// PreviewSuffix is appended to the name of a function deployed from a
+// pull request, i.e. alexellis-fn1-pr-12
+func PreviewSuffix(number int) string {
+	return fmt.Sprintf("-pr-%d", number)
+}
+
+// PullRequestEvent is received from GitHub's pull_request subscription
+type PullRequestEvent struct {
+	Action       string                `json:"action"`
+	Number       int                   `json:"number"`
+	PullRequest  PullRequest           `json:"pull_request"`
+	Repository   PushEventRepository   `json:"repository"`
+	Installation PushEventInstallation `json:"installation"`
+}
+
+// PullRequest is the pull request of a PullRequestEvent
+type PullRequest struct {
+	Merged bool           `json:"merged"`
+	Head   PullRequestRef `json:"head"`
+}
+
+// PullRequestRef is the branch and commit at the head of a pull request
+type PullRequestRef struct {
+	Ref  string              `json:"ref"`
+	SHA  string              `json:"sha"`
+	Repo PushEventRepository `json:"repo"`
+}
+
+// FromFork is true when the head of the pull request is in another
+// repository than the one it is opened against
+func (e PullRequestEvent) FromFork() bool {
+	return !strings.EqualFold(e.PullRequest.Head.Repo.FullName, e.Repository.FullName)
+}
+
+// PushEvent gives the push of the pull request's head to its branch so
+// that it can be built like any other push
+func (e PullRequestEvent) PushEvent() PushEvent {
+	return PushEvent{
+		Ref:           "refs/heads/" + e.PullRequest.Head.Ref,
+		Repository:    e.Repository,
+		AfterCommitID: e.PullRequest.Head.SHA,
+		Installation:  e.Installation,
+		PullRequest:   e.Number,
+	}
+}
@@ -0,0 +1,52 @@
+package sdk
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testPullRequestEvent = `{
+  "action": "synchronize",
+  "number": 12,
+  "pull_request": {
+    "merged": false,
+    "head": {"ref": "feature", "sha": "04b8e44988", "repo": {"full_name": "alexellis/kubecon-tester"}}
+  },
+  "repository": {"name": "kubecon-tester", "full_name": "alexellis/kubecon-tester", "owner": {"login": "alexellis"}},
+  "installation": {"id": 10}
+}`
+
+func Test_PullRequestEvent_PushEvent(t *testing.T) {
+	event := PullRequestEvent{}
+	if err := json.Unmarshal([]byte(testPullRequestEvent), &event); err != nil {
+		t.Fatal(err)
+	}
+
+	if event.FromFork() {
+		t.Errorf("want a pull request from the same repository")
+	}
+
+	push := event.PushEvent()
+	if push.Ref != "refs/heads/feature" || push.AfterCommitID != "04b8e44988" || push.PullRequest != 12 || push.Installation.ID != 10 {
+		t.Errorf("want the head of the pull request, got %+v", push)
+	}
+
+	info := BuildEventFromPushEvent(push)
+	if info.Branch != "feature" || info.PullRequest != 12 || info.Owner != "alexellis" {
+		t.Errorf("want the branch and pull request in the event, got %+v", info)
+	}
+
+	if suffix := PreviewSuffix(push.PullRequest); suffix != "-pr-12" {
+		t.Errorf("want -pr-12, got %s", suffix)
+	}
+}
+
+func Test_PullRequestEvent_FromFork(t *testing.T) {
+	event := PullRequestEvent{}
+	event.Repository.FullName = "alexellis/kubecon-tester"
+	event.PullRequest.Head.Repo.FullName = "someone/kubecon-tester"
+
+	if !event.FromFork() {
+		t.Errorf("want a pull request from a fork")
+	}
+}
@@ -0,0 +1,13 @@
+package sdk
+
+// StagingSuffix is appended to the name of a function deployed from
+// the staging branch, i.e. alexellis-fn1-staging
+const StagingSuffix = "-staging"
+
+// PromoteRequest selects a function deployed from the staging branch
+// whose image is to be redeployed to production
+type PromoteRequest struct {
+	Owner    string `json:"owner"`
+	Repo     string `json:"repo"`
+	Function string `json:"function"`
+}
@@ -0,0 +1,67 @@
+package sdk
+
+import (
+	"os"
+	"strings"
+)
+
+// RegistryOrg gives the registry organisation an owner's images are
+// pushed to. It is read from registry_orgs, a list of owner=org pairs
+// i.e. "alexellis=customer-x,openfaas=ofc", then from
+// registry_org_template where {owner} is replaced with the owner i.e.
+// "customer-{owner}". An empty string is returned when neither is set.
+func RegistryOrg(owner string) string {
+	owner = strings.ToLower(owner)
+
+	for _, pair := range strings.Split(os.Getenv("registry_orgs"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) == 2 && strings.ToLower(strings.TrimSpace(parts[0])) == owner {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+
+	if template := strings.TrimSpace(os.Getenv("registry_org_template")); len(template) > 0 {
+		return strings.Replace(template, "{owner}", owner, -1)
+	}
+
+	return ""
+}
+
+// OwnerRegistryURL gives the registry an owner brings for their own
+// images, read from owner_registries, a list of owner=registry pairs
+// i.e. "alexellis=ghcr.io/alexellis". An empty string is returned when
+// the owner uses the platform's registry.
+func OwnerRegistryURL(owner string) string {
+	owner = strings.ToLower(owner)
+
+	for _, pair := range strings.Split(os.Getenv("owner_registries"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) == 2 && strings.ToLower(strings.TrimSpace(parts[0])) == owner {
+			return strings.TrimSuffix(strings.TrimSpace(parts[1]), "/")
+		}
+	}
+
+	return ""
+}
+
+// OwnerRegistryAuthSecret is the owner's secret with a Docker
+// config.json for the registry given in owner_registries
+func OwnerRegistryAuthSecret(owner string) string {
+	return strings.ToLower(owner) + "-registry-auth"
+}
+
+// OwnerRegistry gives the registry prefix for an owner's images. An
+// owner's own registry is used for both push and pull, otherwise the
+// owner's organisation is appended to the registry when one is set.
+func OwnerRegistry(registry string, owner string) string {
+	if own := OwnerRegistryURL(owner); len(own) > 0 {
+		return own
+	}
+
+	org := RegistryOrg(owner)
+	if len(org) == 0 {
+		return registry
+	}
+
+	return strings.TrimSuffix(registry, "/") + "/" + org
+}
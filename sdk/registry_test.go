@@ -0,0 +1,68 @@
+package sdk
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_RegistryOrg(t *testing.T) {
+	defer os.Unsetenv("registry_orgs")
+	defer os.Unsetenv("registry_org_template")
+
+	tests := []struct {
+		title    string
+		orgs     string
+		template string
+		owner    string
+		want     string
+	}{
+		{"no config", "", "", "alexellis", ""},
+		{"mapped owner", "alexellis=customer-x, openfaas=ofc", "", "AlexEllis", "customer-x"},
+		{"unmapped owner", "openfaas=ofc", "", "alexellis", ""},
+		{"template", "", "customer-{owner}", "alexellis", "customer-alexellis"},
+		{"mapping before template", "alexellis=customer-x", "customer-{owner}", "alexellis", "customer-x"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			os.Setenv("registry_orgs", test.orgs)
+			os.Setenv("registry_org_template", test.template)
+
+			if got := RegistryOrg(test.owner); got != test.want {
+				t.Errorf("want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func Test_OwnerRegistry(t *testing.T) {
+	os.Setenv("registry_orgs", "alexellis=customer-x")
+	defer os.Unsetenv("registry_orgs")
+
+	if got := OwnerRegistry("docker.io/ofcommunity/", "alexellis"); got != "docker.io/ofcommunity/customer-x" {
+		t.Errorf("want docker.io/ofcommunity/customer-x, got %s", got)
+	}
+
+	if got := OwnerRegistry("registry:5000", "openfaas"); got != "registry:5000" {
+		t.Errorf("want registry:5000, got %s", got)
+	}
+}
+
+func Test_OwnerRegistry_OwnRegistry(t *testing.T) {
+	os.Setenv("registry_orgs", "alexellis=customer-x")
+	os.Setenv("owner_registries", "alexellis=ghcr.io/alexellis/, openfaas=quay.io/ofc")
+	defer os.Unsetenv("registry_orgs")
+	defer os.Unsetenv("owner_registries")
+
+	if got := OwnerRegistryURL("AlexEllis"); got != "ghcr.io/alexellis" {
+		t.Errorf("want ghcr.io/alexellis, got %s", got)
+	}
+
+	if got := OwnerRegistry("docker.io/ofcommunity/", "alexellis"); got != "ghcr.io/alexellis" {
+		t.Errorf("want the owner's registry before the org, got %s", got)
+	}
+
+	if got := OwnerRegistry("registry:5000", "stefanprodan"); got != "registry:5000" {
+		t.Errorf("want registry:5000, got %s", got)
+	}
+}
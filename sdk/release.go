@@ -0,0 +1,60 @@
+package sdk
+
+import (
+	"regexp"
+	"strings"
+)
+
+// TagLabel records the git tag a function was deployed from
+const TagLabel = FunctionLabelPrefix + "git-tag"
+
+// tagRefPrefix prefixes the ref of a tag in a push event
+const tagRefPrefix = "refs/tags/"
+
+// ReleaseEvent is received from GitHub's release subscription
+type ReleaseEvent struct {
+	Action       string                `json:"action"`
+	Release      Release               `json:"release"`
+	Repository   PushEventRepository   `json:"repository"`
+	Installation PushEventInstallation `json:"installation"`
+}
+
+// Release is the release of a ReleaseEvent
+type Release struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// PushEvent gives the push of the release's tag so that it can be built
+// like any other push. GitHub does not send the tag's commit, so it is
+// resolved once the tag is checked out.
+func (e ReleaseEvent) PushEvent() PushEvent {
+	return PushEvent{
+		Ref:          tagRefPrefix + e.Release.TagName,
+		Repository:   e.Repository,
+		Installation: e.Installation,
+	}
+}
+
+// TagFromRef gives the tag of a ref such as refs/tags/v1.0.0, or an
+// empty string for a branch
+func TagFromRef(ref string) string {
+	if strings.HasPrefix(ref, tagRefPrefix) {
+		return strings.TrimPrefix(ref, tagRefPrefix)
+	}
+	return ""
+}
+
+var invalidImageTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// FormatImageTag gives a valid image tag for a git tag, i.e. release/1.0
+// becomes release-1.0
+func FormatImageTag(tag string) string {
+	imageTag := invalidImageTagChars.ReplaceAllString(tag, "-")
+	imageTag = strings.TrimLeft(imageTag, ".-")
+	if len(imageTag) > 128 {
+		imageTag = imageTag[:128]
+	}
+	return imageTag
+}
@@ -0,0 +1,60 @@
+package sdk
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testReleaseEvent = `{
+  "action": "published",
+  "release": {"tag_name": "v1.2.0", "draft": false, "prerelease": false},
+  "repository": {"name": "kubecon-tester", "full_name": "alexellis/kubecon-tester", "owner": {"login": "alexellis"}},
+  "installation": {"id": 10}
+}`
+
+func Test_ReleaseEvent_PushEvent(t *testing.T) {
+	event := ReleaseEvent{}
+	if err := json.Unmarshal([]byte(testReleaseEvent), &event); err != nil {
+		t.Fatal(err)
+	}
+
+	push := event.PushEvent()
+	if push.Ref != "refs/tags/v1.2.0" || push.AfterCommitID != "" || push.Installation.ID != 10 {
+		t.Errorf("want the release's tag, got %+v", push)
+	}
+
+	info := BuildEventFromPushEvent(push)
+	if info.Tag != "v1.2.0" || info.Branch != "" || info.Owner != "alexellis" {
+		t.Errorf("want the tag in the event, got %+v", info)
+	}
+}
+
+func Test_TagFromRef(t *testing.T) {
+	cases := map[string]string{
+		"refs/tags/v1.0.0":      "v1.0.0",
+		"refs/tags/release/1.0": "release/1.0",
+		"refs/heads/master":     "",
+		"":                      "",
+	}
+
+	for ref, want := range cases {
+		if got := TagFromRef(ref); got != want {
+			t.Errorf("TagFromRef(%q): want %q, got %q", ref, want, got)
+		}
+	}
+}
+
+func Test_FormatImageTag(t *testing.T) {
+	cases := map[string]string{
+		"v1.0.0":         "v1.0.0",
+		"release/1.0":    "release-1.0",
+		".hidden":        "hidden",
+		"v1.0.0+build.1": "v1.0.0-build.1",
+	}
+
+	for tag, want := range cases {
+		if got := FormatImageTag(tag); got != want {
+			t.Errorf("FormatImageTag(%q): want %q, got %q", tag, want, got)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package sdk
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// Statuses of a Response
+const (
+	// ResponseOK is given when the request was handled, i.e. an event
+	// was forwarded or a function deployed
+	ResponseOK = "ok"
+	// ResponseAccepted is given when the request was queued to be
+	// handled later
+	ResponseAccepted = "accepted"
+	// ResponseSkipped is given for a valid request which there was
+	// nothing to do for, such as a push to a branch which isn't built
+	ResponseSkipped = "skipped"
+	// ResponseRejected is given for a request which is not allowed, such
+	// as one with an invalid signature or over a limit
+	ResponseRejected = "rejected"
+	// ResponseFailed is given when a valid request could not be handled
+	ResponseFailed = "failed"
+)
+
+// Response is returned as JSON by github-event, github-push and
+// buildshiprun. Code follows the meaning of HTTP status codes, the
+// functions themselves always return a 200 to the watchdog.
+type Response struct {
+	Status        string `json:"status"`
+	Code          int    `json:"code"`
+	Reason        string `json:"reason"`
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+// OK is a Response for a request which was handled
+func OK(reason string) Response {
+	return Response{Status: ResponseOK, Code: http.StatusOK, Reason: reason}
+}
+
+// Accepted is a Response for a request which was queued
+func Accepted(reason string) Response {
+	return Response{Status: ResponseAccepted, Code: http.StatusAccepted, Reason: reason}
+}
+
+// Skipped is a Response for a request which there was nothing to do for
+func Skipped(reason string) Response {
+	return Response{Status: ResponseSkipped, Code: http.StatusOK, Reason: reason}
+}
+
+// Rejected is a Response for a request which is not allowed, code is
+// i.e. http.StatusUnauthorized
+func Rejected(code int, reason string) Response {
+	return Response{Status: ResponseRejected, Code: code, Reason: reason}
+}
+
+// Failed is a Response for a request which could not be handled, code
+// is i.e. http.StatusBadGateway when a downstream function failed
+func Failed(code int, reason string) Response {
+	return Response{Status: ResponseFailed, Code: code, Reason: reason}
+}
+
+// String gives the reason, so that a Response can be logged as before
+func (r Response) String() string {
+	return r.Reason
+}
+
+// JSON encodes the Response with its reason redacted, the correlation ID
+// is set from the request when the Response has none
+func (r Response) JSON() string {
+	if len(r.CorrelationID) == 0 {
+		r.CorrelationID = CorrelationID()
+	}
+	r.Reason = Redact(r.Reason)
+
+	out, _ := json.Marshal(r)
+	return string(out)
+}
+
+// CorrelationID identifies the request a Response is for, the GitHub
+// delivery's GUID when there is one, otherwise the gateway's X-Call-Id
+func CorrelationID() string {
+	if deliveryID := os.Getenv("Http_X_Github_Delivery"); len(deliveryID) > 0 {
+		return deliveryID
+	}
+	return os.Getenv("Http_X_Call_Id")
+}
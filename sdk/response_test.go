@@ -0,0 +1,50 @@
+package sdk
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func Test_Response_JSON(t *testing.T) {
+	os.Setenv("Http_X_Call_Id", "c1a2b3")
+	defer os.Unsetenv("Http_X_Call_Id")
+
+	got := Response{}
+	if err := json.Unmarshal([]byte(Rejected(http.StatusUnauthorized, "invalid signature").JSON()), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Response{Status: ResponseRejected, Code: http.StatusUnauthorized, Reason: "invalid signature", CorrelationID: "c1a2b3"}
+	if got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func Test_Response_JSON_Redacted(t *testing.T) {
+	got := Response{}
+	if err := json.Unmarshal([]byte(Failed(http.StatusBadGateway, "Authorization: Bearer abc.def-ghi").JSON()), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "Authorization: Bearer [REDACTED]"; got.Reason != want {
+		t.Errorf("want %q, got %q", want, got.Reason)
+	}
+}
+
+func Test_CorrelationID(t *testing.T) {
+	os.Setenv("Http_X_Call_Id", "c1a2b3")
+	defer os.Unsetenv("Http_X_Call_Id")
+
+	if got := CorrelationID(); got != "c1a2b3" {
+		t.Errorf("want the gateway's call ID, got %q", got)
+	}
+
+	os.Setenv("Http_X_Github_Delivery", "72d3162e")
+	defer os.Unsetenv("Http_X_Github_Delivery")
+
+	if got := CorrelationID(); got != "72d3162e" {
+		t.Errorf("want the GitHub delivery's GUID, got %q", got)
+	}
+}
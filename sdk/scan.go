@@ -0,0 +1,80 @@
+package sdk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Annotations recorded by buildshiprun from the of-builder's scan of
+// the image
+const (
+	// SBOMPackagesAnnotation is the number of packages in the SBOM
+	SBOMPackagesAnnotation = FunctionLabelPrefix + "sbom-packages"
+	// VulnerabilitiesAnnotation counts vulnerabilities by severity, i.e.
+	// "critical=0,high=2,medium=5,low=1"
+	VulnerabilitiesAnnotation = FunctionLabelPrefix + "vulnerabilities"
+	// ScannerAnnotation names the scanner and its version
+	ScannerAnnotation = FunctionLabelPrefix + "scanner"
+)
+
+// ScanSource is the PipelineLog source used to store the scan report
+// of each function next to its build log
+const ScanSource = "scan"
+
+// Severities of vulnerabilities, most severe first
+var Severities = []string{"critical", "high", "medium", "low", "unknown"}
+
+// ScanReport summarises the SBOM and vulnerability scan of an image,
+// it is returned by the of-builder when a scanner is configured
+type ScanReport struct {
+	Image   string `json:"image"`
+	Scanner string `json:"scanner,omitempty"`
+
+	// SBOMFormat such as spdx-json and the number of packages found
+	SBOMFormat   string `json:"sbomFormat,omitempty"`
+	SBOMPackages int    `json:"sbomPackages"`
+	// SBOMURL is where the complete SBOM is kept, if anywhere
+	SBOMURL string `json:"sbomURL,omitempty"`
+
+	// Vulnerabilities counts the vulnerabilities found by severity
+	Vulnerabilities map[string]int `json:"vulnerabilities"`
+}
+
+// Count gives the number of vulnerabilities of a severity, scanners
+// differ in the case they give severities in
+func (r *ScanReport) Count(severity string) int {
+	count := 0
+	for name, n := range r.Vulnerabilities {
+		if strings.EqualFold(name, severity) {
+			count += n
+		}
+	}
+	return count
+}
+
+// FormatVulnerabilities gives the counts most severe first, the known
+// severities are always given and any others follow by name, i.e.
+// "critical=0,high=2,medium=5,low=1,unknown=0"
+func (r *ScanReport) FormatVulnerabilities() string {
+	known := map[string]bool{}
+	values := []string{}
+	for _, severity := range Severities {
+		known[severity] = true
+		values = append(values, fmt.Sprintf("%s=%d", severity, r.Count(severity)))
+	}
+
+	others := []string{}
+	for severity := range r.Vulnerabilities {
+		if !known[strings.ToLower(severity)] {
+			others = append(others, severity)
+		}
+	}
+	sort.Strings(others)
+
+	for _, severity := range others {
+		values = append(values, fmt.Sprintf("%s=%d", strings.ToLower(severity), r.Vulnerabilities[severity]))
+	}
+
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,22 @@
+package sdk
+
+import "testing"
+
+func Test_ScanReport_FormatVulnerabilities(t *testing.T) {
+	report := ScanReport{
+		Vulnerabilities: map[string]int{
+			"HIGH":       2,
+			"medium":     5,
+			"negligible": 3,
+		},
+	}
+
+	want := "critical=0,high=2,medium=5,low=0,unknown=0,negligible=3"
+	if got := report.FormatVulnerabilities(); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+
+	if report.Count("high") != 2 || report.Count("critical") != 0 {
+		t.Errorf("want counts regardless of case, got high=%d", report.Count("high"))
+	}
+}
@@ -0,0 +1,259 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/alexellis/hmac"
+)
+
+const (
+	// SCMGitHub is the SCM of a PushEvent from a GitHub App
+	SCMGitHub = "github"
+	// SCMGitLab is the SCM of a PushEvent from a GitLab system hook
+	SCMGitLab = "gitlab"
+	// SCMBitbucket is the SCM of a PushEvent from a Bitbucket Cloud webhook
+	SCMBitbucket = "bitbucket"
+	// SCMGit is the SCM of a PushEvent from a plain git server
+	SCMGit = "git"
+	// SCMCLI is the SCM of a PushEvent for an archive uploaded with the CLI
+	SCMCLI = "cli"
+)
+
+// GitHubTokenUser is the user an installation token clones as
+const GitHubTokenUser = "x-access-token"
+
+// SCM is a source control provider which the pipeline builds from. A
+// provider is registered under the name given in the SCM field of a
+// PushEvent, so that a new provider only has to implement SCM rather
+// than each function switching on its name.
+type SCM interface {
+	// Name is the SCM field of the provider's PushEvents, i.e. "github"
+	Name() string
+	// ValidateWebhook checks a webhook sent by the provider was signed
+	// with the webhook secret
+	ValidateWebhook(payload []byte, header http.Header, secret string) error
+	// CloneCredentials gives the credentials to clone the repository of
+	// a push, or nil when it can be cloned anonymously
+	CloneCredentials(event PushEvent) (*url.Userinfo, error)
+	// ReportStatus sends the commit statuses to the provider through its
+	// status function, i.e. github-status
+	ReportStatus(status *Status, gatewayURL string, payloadSecret string) error
+}
+
+var (
+	scmProviders = map[string]SCM{
+		SCMGitHub:    &GitHubSCM{},
+		SCMGitLab:    &GitLabSCM{},
+		SCMBitbucket: &BitbucketSCM{},
+		SCMGit:       &GitSCM{},
+		SCMCLI:       &CLISCM{},
+	}
+	scmLock sync.RWMutex
+)
+
+// RegisterSCM adds a provider, or replaces the one of the same name, i.e.
+// to give the GitHubSCM a way to create installation tokens
+func RegisterSCM(scm SCM) {
+	scmLock.Lock()
+	defer scmLock.Unlock()
+
+	scmProviders[scm.Name()] = scm
+}
+
+// GetSCM gives the provider registered for the SCM field of a PushEvent,
+// which GitHub does not set so an empty name is GitHub
+func GetSCM(name string) (SCM, error) {
+	if len(name) == 0 {
+		name = SCMGitHub
+	}
+
+	scmLock.RLock()
+	defer scmLock.RUnlock()
+
+	scm, ok := scmProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("non-supported SCM: %s", name)
+	}
+	return scm, nil
+}
+
+// CloneURL gives the URL to clone the repository of a push with, which
+// includes the provider's credentials for a private repository
+func CloneURL(scm SCM, event PushEvent) (string, error) {
+	credentials, err := scm.CloneCredentials(event)
+	if err != nil {
+		return "", err
+	}
+	if credentials == nil {
+		return event.Repository.CloneURL, nil
+	}
+
+	u, err := url.Parse(event.Repository.CloneURL)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing URL: %s", err.Error())
+	}
+	u.User = credentials
+
+	return u.String(), nil
+}
+
+// GitHubSCM is a GitHub App installed on the owner's repositories
+type GitHubSCM struct {
+	// InstallationToken creates an access token for an installation of
+	// the GitHub App, which is needed to clone private repositories
+	InstallationToken func(installationID int) (string, error)
+}
+
+// Name is "github"
+func (g *GitHubSCM) Name() string {
+	return SCMGitHub
+}
+
+// ValidateWebhook checks the X-Hub-Signature-256 or X-Hub-Signature header
+func (g *GitHubSCM) ValidateWebhook(payload []byte, header http.Header, secret string) error {
+	return ValidGitHubSignature(payload, header.Get(GitHubSignature256Header), header.Get(GitHubSignatureHeader), secret)
+}
+
+// CloneCredentials exchanges the push's installation ID for a token to
+// clone a private repository over HTTPS, as the x-access-token user
+func (g *GitHubSCM) CloneCredentials(event PushEvent) (*url.Userinfo, error) {
+	if !event.Repository.Private {
+		return nil, nil
+	}
+	if g.InstallationToken == nil {
+		return nil, fmt.Errorf("cannot clone private repository %s without an installation token", event.Repository.FullName)
+	}
+	if event.Installation.ID == 0 {
+		return nil, fmt.Errorf("cannot clone private repository %s, the push has no installation of the GitHub App", event.Repository.FullName)
+	}
+
+	token, err := g.InstallationToken(event.Installation.ID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get auth token: %s", err.Error())
+	}
+	// GitHub answers an unknown installation with a message and no token
+	if len(token) == 0 {
+		return nil, fmt.Errorf("no auth token was given for installation %d", event.Installation.ID)
+	}
+
+	return url.UserPassword(GitHubTokenUser, token), nil
+}
+
+// ReportStatus calls github-status, which gives back a token for the
+// next status of the same event
+func (g *GitHubSCM) ReportStatus(status *Status, gatewayURL string, payloadSecret string) error {
+	_, err := status.Report(gatewayURL, payloadSecret)
+	return err
+}
+
+// GitLabSCM is a self-hosted GitLab instance with a system hook
+type GitLabSCM struct{}
+
+// Name is "gitlab"
+func (g *GitLabSCM) Name() string {
+	return SCMGitLab
+}
+
+// ValidateWebhook checks the X-Gitlab-Token header is the secret
+func (g *GitLabSCM) ValidateWebhook(payload []byte, header http.Header, secret string) error {
+	if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+		return fmt.Errorf("value in X-Gitlab-Token does not match the webhook secret")
+	}
+	return nil
+}
+
+// CloneCredentials gives the owner and the gitlab-api-token secret for a
+// private repository
+func (g *GitLabSCM) CloneCredentials(event PushEvent) (*url.Userinfo, error) {
+	if !event.Repository.Private {
+		return nil, nil
+	}
+
+	token, err := ReadSecret("gitlab-api-token")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read api token from GitLab in secret `gitlab-api-token`: %s", err.Error())
+	}
+
+	return url.UserPassword(event.Repository.Owner.Login, token), nil
+}
+
+// ReportStatus calls gitlab-status
+func (g *GitLabSCM) ReportStatus(status *Status, gatewayURL string, payloadSecret string) error {
+	return reportStatusToFunction(status, gatewayURL, payloadSecret, "gitlab-status")
+}
+
+// BitbucketSCM is a Bitbucket Cloud workspace with the platform's OAuth
+// consumer
+type BitbucketSCM struct{}
+
+// Name is "bitbucket"
+func (b *BitbucketSCM) Name() string {
+	return SCMBitbucket
+}
+
+// ValidateWebhook checks the X-Hub-Signature header, which Bitbucket
+// signs with HMAC-SHA256
+func (b *BitbucketSCM) ValidateWebhook(payload []byte, header http.Header, secret string) error {
+	return ValidGitHubSignature(payload, header.Get(GitHubSignatureHeader), "", secret)
+}
+
+// CloneCredentials gives an access token for the OAuth consumer for a
+// private repository
+func (b *BitbucketSCM) CloneCredentials(event PushEvent) (*url.Userinfo, error) {
+	if !event.Repository.Private {
+		return nil, nil
+	}
+
+	token, err := BitbucketAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get an access token from Bitbucket: %s", err.Error())
+	}
+
+	return url.UserPassword("x-token-auth", token), nil
+}
+
+// ReportStatus calls bitbucket-status
+func (b *BitbucketSCM) ReportStatus(status *Status, gatewayURL string, payloadSecret string) error {
+	return reportStatusToFunction(status, gatewayURL, payloadSecret, "bitbucket-status")
+}
+
+// reportStatusToFunction posts the status signed with the payload-secret
+// to a provider's status function
+func reportStatusToFunction(status *Status, gatewayURL string, payloadSecret string, function string) error {
+	statusBytes, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("error while marshalling request: %s", err.Error())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, gatewayURL+"function/"+function, bytes.NewReader(statusBytes))
+	if err != nil {
+		return fmt.Errorf("error while making request to %s: `%s`", function, err.Error())
+	}
+
+	digest := hmac.Sign(statusBytes, []byte(payloadSecret))
+	req.Header.Add(CloudSignatureHeader, "sha1="+hex.EncodeToString(digest))
+
+	res, err := HTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("unexpected error while retrieving response: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	ioutil.ReadAll(res.Body)
+
+	// reset old status
+	status.CommitStatuses = make(map[string]CommitStatus)
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("unexpected status code from %s: %d", function, res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package sdktest
+
+import (
+	"sync"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// FakeAudit implements sdk.Audit and records the events posted to it
+type FakeAudit struct {
+	events []sdk.AuditEvent
+	mutex  sync.Mutex
+}
+
+// Post records the event
+func (a *FakeAudit) Post(event sdk.AuditEvent) error {
+	a.mutex.Lock()
+	a.events = append(a.events, event)
+	a.mutex.Unlock()
+	return nil
+}
+
+// Events returns the events posted in order
+func (a *FakeAudit) Events() []sdk.AuditEvent {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return append([]sdk.AuditEvent{}, a.events...)
+}
+
+// Messages returns the messages of the events posted in order
+func (a *FakeAudit) Messages() []string {
+	messages := []string{}
+	for _, event := range a.Events() {
+		messages = append(messages, event.Message)
+	}
+	return messages
+}
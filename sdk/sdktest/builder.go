@@ -0,0 +1,89 @@
+package sdktest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// Build is a build context received by the FakeBuilder
+type Build struct {
+	Header http.Header
+	// Context is the tar sent by buildshiprun
+	Context []byte
+}
+
+// FakeBuilder is an of-builder which returns a canned BuildResult for
+// every build and records the build contexts it was sent
+type FakeBuilder struct {
+	// URL of the builder with a trailing slash, as set in builder_url
+	URL string
+
+	server     *httptest.Server
+	statusCode int
+	result     sdk.BuildResult
+	builds     []Build
+	mutex      sync.Mutex
+}
+
+// NewFakeBuilder starts a FakeBuilder whose builds succeed with the
+// given image, call Close once the test is done
+func NewFakeBuilder(imageName string) *FakeBuilder {
+	b := &FakeBuilder{
+		statusCode: http.StatusOK,
+		result: sdk.BuildResult{
+			ImageName: imageName,
+			Status:    "success",
+			Log:       []string{"v: 2019-10-01T12:00:00Z exporting to image 1.00s"},
+		},
+	}
+
+	router := http.NewServeMux()
+	router.HandleFunc("/build", b.handleBuild)
+
+	b.server = httptest.NewServer(router)
+	b.URL = b.server.URL + "/"
+
+	return b
+}
+
+// Close shuts down the builder
+func (b *FakeBuilder) Close() {
+	b.server.Close()
+}
+
+// SetResult sets the status code and result returned for each build,
+// i.e. http.StatusInternalServerError and a failure status
+func (b *FakeBuilder) SetResult(statusCode int, result sdk.BuildResult) {
+	b.mutex.Lock()
+	b.statusCode = statusCode
+	b.result = result
+	b.mutex.Unlock()
+}
+
+// Builds returns the builds received in order
+func (b *FakeBuilder) Builds() []Build {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return append([]Build{}, b.builds...)
+}
+
+func (b *FakeBuilder) handleBuild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	context, _ := ioutil.ReadAll(r.Body)
+
+	b.mutex.Lock()
+	b.builds = append(b.builds, Build{Header: r.Header.Clone(), Context: context})
+	statusCode, result := b.statusCode, b.result
+	b.mutex.Unlock()
+
+	writeJSON(w, statusCode, result)
+}
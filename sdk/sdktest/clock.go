@@ -0,0 +1,50 @@
+package sdktest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FixedClock implements sdk.Clock with a time which only moves when a
+// test calls Add
+type FixedClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewFixedClock gives a FixedClock stopped at now
+func NewFixedClock(now time.Time) *FixedClock {
+	return &FixedClock{now: now}
+}
+
+// Now gives the clock's time
+func (c *FixedClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Add moves the clock on by d
+func (c *FixedClock) Add(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// SequentialIDs implements sdk.IDGenerator with Prefix followed by a
+// counter, starting from 1
+type SequentialIDs struct {
+	Prefix string
+
+	lock sync.Mutex
+	next int
+}
+
+// NewID gives the next ID in the sequence
+func (s *SequentialIDs) NewID() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.next++
+	return fmt.Sprintf("%s%d", s.Prefix, s.next)
+}
@@ -0,0 +1,12 @@
+// Package sdktest provides fakes of the gateway, the of-builder and the
+// GitHub API along with webhook payload builders, so that the handlers
+// of the pipeline functions can be tested without an OpenFaaS Cloud
+// installation.
+//
+// The fakes are httptest servers, point a handler at them through its
+// usual env-vars, i.e. gateway_url, builder_url or github_api_url:
+//
+//	gateway := sdktest.NewFakeGateway()
+//	defer gateway.Close()
+//	os.Setenv("gateway_url", gateway.URL)
+package sdktest
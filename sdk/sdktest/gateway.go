@@ -0,0 +1,317 @@
+package sdktest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Function is a function deployed to the FakeGateway, it is returned in
+// the same form as the gateway's system/functions endpoint
+type Function struct {
+	Name              string            `json:"name"`
+	Image             string            `json:"image"`
+	Namespace         string            `json:"namespace,omitempty"`
+	Labels            map[string]string `json:"labels"`
+	Annotations       map[string]string `json:"annotations"`
+	Secrets           []string          `json:"secrets"`
+	Replicas          uint64            `json:"replicas"`
+	AvailableReplicas uint64            `json:"availableReplicas"`
+}
+
+// Invocation is a request made through the gateway to a function, such
+// as a call to pipeline-log or github-status
+type Invocation struct {
+	Function string
+	// Path is the remainder of the path after the function's name
+	Path   string
+	Query  string
+	Method string
+	Header http.Header
+	Body   []byte
+	// Async is set for calls to async-function/
+	Async bool
+}
+
+// Response is returned for the invocations of a function
+type Response struct {
+	StatusCode int
+	Body       string
+}
+
+// deploySpec is the body of a deployment, only the fields used by the
+// fake are read
+type deploySpec struct {
+	Service     string            `json:"service"`
+	Image       string            `json:"image"`
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Secrets     []string          `json:"secrets"`
+}
+
+// FakeGateway is an OpenFaaS gateway which stores functions and secrets
+// in memory and records the invocations of functions
+type FakeGateway struct {
+	// URL of the gateway with a trailing slash, as set in gateway_url
+	URL string
+
+	server      *httptest.Server
+	functions   map[string]Function
+	secrets     map[string]string
+	responses   map[string]Response
+	invocations []Invocation
+	mutex       sync.Mutex
+}
+
+// NewFakeGateway starts a FakeGateway, call Close once the test is done
+func NewFakeGateway() *FakeGateway {
+	g := &FakeGateway{
+		functions: map[string]Function{},
+		secrets:   map[string]string{},
+		responses: map[string]Response{},
+	}
+
+	router := http.NewServeMux()
+	router.HandleFunc("/system/functions", g.handleFunctions)
+	router.HandleFunc("/system/function/", g.handleFunction)
+	router.HandleFunc("/system/secrets", g.handleSecrets)
+	router.HandleFunc("/function/", g.handleInvoke)
+	router.HandleFunc("/async-function/", g.handleInvoke)
+
+	g.server = httptest.NewServer(router)
+	g.URL = g.server.URL + "/"
+
+	return g
+}
+
+// Close shuts down the gateway
+func (g *FakeGateway) Close() {
+	g.server.Close()
+}
+
+// AddFunction deploys a function, it is reported as ready unless
+// AvailableReplicas is set
+func (g *FakeGateway) AddFunction(fn Function) {
+	if fn.Replicas == 0 {
+		fn.Replicas = 1
+	}
+	if fn.AvailableReplicas == 0 {
+		fn.AvailableReplicas = fn.Replicas
+	}
+
+	g.mutex.Lock()
+	g.functions[fn.Name] = fn
+	g.mutex.Unlock()
+}
+
+// Function returns a deployed function by name
+func (g *FakeGateway) Function(name string) (Function, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	fn, ok := g.functions[name]
+	return fn, ok
+}
+
+// Functions returns the deployed functions sorted by name
+func (g *FakeGateway) Functions() []Function {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	functions := []Function{}
+	for _, fn := range g.functions {
+		functions = append(functions, fn)
+	}
+
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].Name < functions[j].Name
+	})
+	return functions
+}
+
+// AddSecret creates a secret
+func (g *FakeGateway) AddSecret(name string, value string) {
+	g.mutex.Lock()
+	g.secrets[name] = value
+	g.mutex.Unlock()
+}
+
+// Secrets returns the names of the secrets sorted by name
+func (g *FakeGateway) Secrets() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	names := []string{}
+	for name := range g.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetResponse sets what is returned when a function is invoked, by
+// default the gateway returns 200, or 202 for an async invocation
+func (g *FakeGateway) SetResponse(function string, statusCode int, body string) {
+	g.mutex.Lock()
+	g.responses[function] = Response{StatusCode: statusCode, Body: body}
+	g.mutex.Unlock()
+}
+
+// Invocations returns the calls to a function in the order received
+func (g *FakeGateway) Invocations(function string) []Invocation {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	invocations := []Invocation{}
+	for _, invocation := range g.invocations {
+		if invocation.Function == function {
+			invocations = append(invocations, invocation)
+		}
+	}
+	return invocations
+}
+
+func (g *FakeGateway) handleFunctions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, g.Functions())
+
+	case http.MethodPost, http.MethodPut:
+		spec := deploySpec{}
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil || len(spec.Service) == 0 {
+			http.Error(w, "invalid deployment", http.StatusBadRequest)
+			return
+		}
+
+		if _, exists := g.Function(spec.Service); exists != (r.Method == http.MethodPut) {
+			http.Error(w, "function "+spec.Service, http.StatusNotFound)
+			return
+		}
+
+		g.AddFunction(Function{
+			Name:        spec.Service,
+			Image:       spec.Image,
+			Namespace:   spec.Namespace,
+			Labels:      spec.Labels,
+			Annotations: spec.Annotations,
+			Secrets:     spec.Secrets,
+		})
+		w.WriteHeader(http.StatusAccepted)
+
+	case http.MethodDelete:
+		req := struct {
+			FunctionName string `json:"functionName"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		g.mutex.Lock()
+		_, ok := g.functions[req.FunctionName]
+		delete(g.functions, req.FunctionName)
+		g.mutex.Unlock()
+
+		if !ok {
+			http.Error(w, "function not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (g *FakeGateway) handleFunction(w http.ResponseWriter, r *http.Request) {
+	fn, ok := g.Function(strings.TrimPrefix(r.URL.Path, "/system/function/"))
+	if !ok {
+		http.Error(w, "function not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, fn)
+}
+
+func (g *FakeGateway) handleSecrets(w http.ResponseWriter, r *http.Request) {
+	secret := struct {
+		Name  string `json:"name"`
+		Value string `json:"value,omitempty"`
+	}{}
+
+	switch r.Method {
+	case http.MethodGet:
+		secrets := []interface{}{}
+		for _, name := range g.Secrets() {
+			secrets = append(secrets, map[string]string{"name": name})
+		}
+		writeJSON(w, http.StatusOK, secrets)
+
+	case http.MethodPost, http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&secret); err != nil || len(secret.Name) == 0 {
+			http.Error(w, "invalid secret", http.StatusBadRequest)
+			return
+		}
+		g.AddSecret(secret.Name, secret.Value)
+		w.WriteHeader(http.StatusAccepted)
+
+	case http.MethodDelete:
+		json.NewDecoder(r.Body).Decode(&secret)
+
+		g.mutex.Lock()
+		_, ok := g.secrets[secret.Name]
+		delete(g.secrets, secret.Name)
+		g.mutex.Unlock()
+
+		if !ok {
+			http.Error(w, "secret not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (g *FakeGateway) handleInvoke(w http.ResponseWriter, r *http.Request) {
+	async := strings.HasPrefix(r.URL.Path, "/async-function/")
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/async-function/"), "/function/")
+
+	function, rest := path, ""
+	if index := strings.Index(path, "/"); index > -1 {
+		function, rest = path[:index], path[index:]
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+
+	g.mutex.Lock()
+	g.invocations = append(g.invocations, Invocation{
+		Function: function,
+		Path:     rest,
+		Query:    r.URL.RawQuery,
+		Method:   r.Method,
+		Header:   r.Header.Clone(),
+		Body:     body,
+		Async:    async,
+	})
+	res, ok := g.responses[function]
+	g.mutex.Unlock()
+
+	if !ok {
+		res = Response{StatusCode: http.StatusOK}
+		if async {
+			res.StatusCode = http.StatusAccepted
+		}
+	}
+
+	w.WriteHeader(res.StatusCode)
+	w.Write([]byte(res.Body))
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(value)
+}
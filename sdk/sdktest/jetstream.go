@@ -0,0 +1,218 @@
+package sdktest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StreamMsg is a message stored by the FakeJetStream
+type StreamMsg struct {
+	Subject string
+	MsgID   string
+	Data    []byte
+	// Deliveries is the number of times the message was fetched
+	Deliveries int
+	// Result is the last acknowledgement, i.e. +ACK, -NAK or +TERM
+	Result string
+
+	seq     int
+	pending bool
+}
+
+// FakeJetStream is a NATS server with a single JetStream stream and
+// durable pull consumer, which delivers one message at a time, as
+// created by sdk.JetStream. It is enough for the text protocol used by
+// the sdk and does not check subjects against the stream.
+type FakeJetStream struct {
+	// URL of the server, as set in nats_url
+	URL string
+
+	listener net.Listener
+	messages []*StreamMsg
+	seq      int
+	mutex    sync.Mutex
+}
+
+// NewFakeJetStream starts a FakeJetStream, call Close once the test is
+// done
+func NewFakeJetStream() *FakeJetStream {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	s := &FakeJetStream{
+		URL:      "nats://" + listener.Addr().String(),
+		listener: listener,
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+// Close stops the server
+func (s *FakeJetStream) Close() {
+	s.listener.Close()
+}
+
+// Messages gives the messages stored, including those acknowledged
+func (s *FakeJetStream) Messages() []StreamMsg {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := []StreamMsg{}
+	for _, msg := range s.messages {
+		messages = append(messages, *msg)
+	}
+	return messages
+}
+
+// Add stores a message as if it was published
+func (s *FakeJetStream) Add(subject string, data []byte) {
+	s.publish(subject, "", data)
+}
+
+func (s *FakeJetStream) publish(subject string, msgID string, data []byte) (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(msgID) > 0 {
+		for _, msg := range s.messages {
+			if msg.MsgID == msgID {
+				return msg.seq, true
+			}
+		}
+	}
+
+	s.seq++
+	s.messages = append(s.messages, &StreamMsg{Subject: subject, MsgID: msgID, Data: data, seq: s.seq})
+	return s.seq, false
+}
+
+// next delivers the first message which has not been acknowledged, or
+// nothing while a message is pending
+func (s *FakeJetStream) next() *StreamMsg {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, msg := range s.messages {
+		if msg.pending {
+			return nil
+		}
+		if msg.Result == "" || strings.HasPrefix(msg.Result, "-NAK") {
+			msg.pending = true
+			msg.Deliveries++
+			copied := *msg
+			return &copied
+		}
+	}
+	return nil
+}
+
+func (s *FakeJetStream) acknowledge(seq int, result string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, msg := range s.messages {
+		if msg.seq == seq {
+			msg.pending = false
+			msg.Result = result
+		}
+	}
+}
+
+func (s *FakeJetStream) serve(conn net.Conn) {
+	defer conn.Close()
+
+	fmt.Fprint(conn, "INFO {\"server_id\":\"sdktest\",\"headers\":true,\"jetstream\":true}\r\n")
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PUB", "HPUB":
+			s.handlePublish(conn, reader, fields)
+		}
+	}
+}
+
+func (s *FakeJetStream) handlePublish(conn net.Conn, reader *bufio.Reader, fields []string) {
+	headerSize := 0
+	size, _ := strconv.Atoi(fields[len(fields)-1])
+	if fields[0] == "HPUB" {
+		headerSize, _ = strconv.Atoi(fields[len(fields)-2])
+	}
+
+	body := make([]byte, size+2)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return
+	}
+	header, data := string(body[:headerSize]), body[headerSize:size]
+
+	subject, reply := fields[1], ""
+	if (fields[0] == "PUB" && len(fields) == 4) || (fields[0] == "HPUB" && len(fields) == 5) {
+		reply = fields[2]
+	}
+
+	switch {
+	case strings.HasPrefix(subject, "$JS.ACK."):
+		tokens := strings.Split(subject, ".")
+		if len(tokens) > 5 {
+			seq, _ := strconv.Atoi(tokens[5])
+			s.acknowledge(seq, string(data))
+		}
+	case strings.HasPrefix(subject, "$JS.API.CONSUMER.MSG.NEXT."):
+		if msg := s.next(); msg != nil {
+			ackSubject := fmt.Sprintf("$JS.ACK.stream.consumer.%d.%d.%d.0.0", msg.Deliveries, msg.seq, msg.seq)
+			fmt.Fprintf(conn, "MSG %s 1 %s %d\r\n%s\r\n", msg.Subject, ackSubject, len(msg.Data), msg.Data)
+		}
+		status := "NATS/1.0 404 No Messages\r\n\r\n"
+		fmt.Fprintf(conn, "HMSG %s 1 %d %d\r\n%s\r\n", reply, len(status), len(status), status)
+	case strings.HasPrefix(subject, "$JS.API."):
+		reply200(conn, reply, `{"type":"io.nats.jetstream.api.v1.response"}`)
+	default:
+		seq, duplicate := s.publish(subject, headerValue(header, "Nats-Msg-Id"), data)
+		ack, _ := json.Marshal(map[string]interface{}{"stream": "stream", "seq": seq, "duplicate": duplicate})
+		reply200(conn, reply, string(ack))
+	}
+}
+
+func reply200(conn net.Conn, reply string, body string) {
+	if len(reply) > 0 {
+		fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", reply, len(body), body)
+	}
+}
+
+func headerValue(header string, key string) string {
+	for _, line := range strings.Split(header, "\r\n") {
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 && strings.EqualFold(parts[0], key) {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
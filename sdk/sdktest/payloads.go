@@ -0,0 +1,124 @@
+package sdktest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/alexellis/hmac"
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// GitHubPush builds the push event sent by GitHub for a commit to the
+// branch of a repository
+func GitHubPush(owner, repo, branch, sha string) sdk.PushEvent {
+	return sdk.PushEvent{
+		Ref: "refs/heads/" + branch,
+		Repository: sdk.PushEventRepository{
+			Name:          repo,
+			FullName:      owner + "/" + repo,
+			CloneURL:      fmt.Sprintf("https://github.com/%s/%s.git", owner, repo),
+			RepositoryURL: fmt.Sprintf("https://github.com/%s/%s", owner, repo),
+			Owner: sdk.Owner{
+				Login: owner,
+			},
+		},
+		AfterCommitID: sha,
+		Installation: sdk.PushEventInstallation{
+			ID: 1,
+		},
+		HeadCommit: &sdk.PushEventCommit{
+			ID:      sha,
+			Message: "Update stack.yml",
+		},
+	}
+}
+
+// GitLabPush builds the push event sent by a GitLab system hook for a
+// commit to the branch of a project
+func GitLabPush(owner, repo, branch, sha string) sdk.GitLabPushEvent {
+	return sdk.GitLabPushEvent{
+		Ref:          "refs/heads/" + branch,
+		UserUsername: owner,
+		GitLabProject: sdk.GitLabProject{
+			ID:                1,
+			Namespace:         owner,
+			Name:              repo,
+			PathWithNamespace: owner + "/" + repo,
+			WebURL:            fmt.Sprintf("https://gitlab.com/%s/%s", owner, repo),
+		},
+		GitLabRepository: sdk.GitLabRepository{
+			CloneURL: fmt.Sprintf("https://gitlab.com/%s/%s.git", owner, repo),
+		},
+		AfterCommitID: sha,
+	}
+}
+
+// BitbucketPush builds the repo:push webhook sent by Bitbucket Cloud for
+// a commit to the branch of a repository in the owner's workspace
+func BitbucketPush(owner, repo, branch, sha string) sdk.BitbucketPushEvent {
+	event := sdk.BitbucketPushEvent{}
+	event.Actor.Nickname = owner
+	event.Repository.Name = repo
+	event.Repository.FullName = owner + "/" + repo
+	event.Repository.Links.HTML.Href = fmt.Sprintf("https://bitbucket.org/%s/%s", owner, repo)
+	event.Repository.Workspace.Slug = owner
+
+	head := &sdk.BitbucketRef{Type: "branch", Name: branch}
+	head.Target.Hash = sha
+	head.Target.Message = "Update stack.yml"
+	event.Push.Changes = []sdk.BitbucketChange{{New: head}}
+
+	return event
+}
+
+// GitPush builds the event sent to git-event by a plain git server for a
+// commit to the branch of the owner's repository
+func GitPush(owner, repo, branch, sha string) sdk.GitPushEvent {
+	return sdk.GitPushEvent{
+		Owner:    owner,
+		Repo:     repo,
+		CloneURL: fmt.Sprintf("https://git.example.com/%s/%s.git", owner, repo),
+		Ref:      "refs/heads/" + branch,
+		After:    sha,
+		Message:  "Update stack.yml",
+		Sender:   owner,
+	}
+}
+
+// Payload marshals an event into a webhook body
+func Payload(event interface{}) []byte {
+	body, err := json.Marshal(event)
+	if err != nil {
+		panic(err)
+	}
+	return body
+}
+
+// Sign returns the signature of a body for the X-Cloud-Signature and
+// X-Hub-Signature headers, in the form sha1=<digest>
+func Sign(body []byte, secret string) string {
+	digest := hmac.Sign(body, []byte(secret))
+	return "sha1=" + hex.EncodeToString(digest)
+}
+
+// WriteSecrets writes the secrets to a temporary directory and returns
+// it to be set as secret_mount_path, remove it once the test is done
+func WriteSecrets(secrets map[string]string) (string, error) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		return "", err
+	}
+
+	for name, value := range secrets {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0600); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,218 @@
+package sdktest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+// CommitStatus is a status or check run written to the FakeSCM
+type CommitStatus struct {
+	// Repo is owner/repo on GitHub and Bitbucket or the project ID on
+	// GitLab
+	Repo        string
+	SHA         string
+	State       string
+	Context     string
+	Description string
+	TargetURL   string
+}
+
+// FakeSCM records the commit statuses and check runs written through
+// the GitHub REST API, the commit statuses written through the GitLab
+// API at /api/v4/ and the build statuses written through the Bitbucket
+// API at /2.0/
+type FakeSCM struct {
+	// URL of the API with a trailing slash
+	URL string
+
+	server   *httptest.Server
+	statuses []CommitStatus
+	metadata map[string]sdk.RepoMetadata
+	mutex    sync.Mutex
+}
+
+// NewFakeSCM starts a FakeSCM, call Close once the test is done
+func NewFakeSCM() *FakeSCM {
+	s := &FakeSCM{metadata: map[string]sdk.RepoMetadata{}}
+
+	router := http.NewServeMux()
+	router.HandleFunc("/repos/", s.handleGitHub)
+	router.HandleFunc("/api/v4/projects/", s.handleGitLab)
+	router.HandleFunc("/2.0/repositories/", s.handleBitbucket)
+
+	s.server = httptest.NewServer(router)
+	s.URL = s.server.URL + "/"
+
+	return s
+}
+
+// Close shuts down the SCM
+func (s *FakeSCM) Close() {
+	s.server.Close()
+}
+
+// Statuses returns the statuses written in order
+func (s *FakeSCM) Statuses() []CommitStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]CommitStatus{}, s.statuses...)
+}
+
+// SetRepoMetadata sets the languages and license served for owner/repo,
+// a repo without a license gives a 404 like GitHub
+func (s *FakeSCM) SetRepoMetadata(repo string, metadata sdk.RepoMetadata) {
+	s.mutex.Lock()
+	s.metadata[repo] = metadata
+	s.mutex.Unlock()
+}
+
+func (s *FakeSCM) repoMetadata(repo string) sdk.RepoMetadata {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.metadata[repo]
+}
+
+func (s *FakeSCM) add(status CommitStatus) {
+	s.mutex.Lock()
+	s.statuses = append(s.statuses, status)
+	s.mutex.Unlock()
+}
+
+// handleGitHub serves POST /repos/:owner/:repo/statuses/:sha, the
+// check-runs of a commit, creating and updating a check run, and the
+// languages and license of a repo
+func (s *FakeSCM) handleGitHub(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/repos/"), "/")
+	if len(parts) < 3 {
+		http.NotFound(w, r)
+		return
+	}
+	repo := parts[0] + "/" + parts[1]
+
+	switch {
+	case parts[2] == "statuses" && len(parts) == 4 && r.Method == http.MethodPost:
+		status := struct {
+			State       string `json:"state"`
+			Context     string `json:"context"`
+			Description string `json:"description"`
+			TargetURL   string `json:"target_url"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&status)
+
+		s.add(CommitStatus{
+			Repo:        repo,
+			SHA:         parts[3],
+			State:       status.State,
+			Context:     status.Context,
+			Description: status.Description,
+			TargetURL:   status.TargetURL,
+		})
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": 1})
+
+	case parts[2] == "commits" && len(parts) == 5 && parts[4] == "check-runs":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"total_count": 0, "check_runs": []interface{}{}})
+
+	case parts[2] == "check-runs" && (r.Method == http.MethodPost || r.Method == http.MethodPatch):
+		check := struct {
+			Name       string `json:"name"`
+			HeadSHA    string `json:"head_sha"`
+			Status     string `json:"status"`
+			Conclusion string `json:"conclusion"`
+			DetailsURL string `json:"details_url"`
+			Output     struct {
+				Title string `json:"title"`
+			} `json:"output"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&check)
+
+		state := check.Conclusion
+		if len(state) == 0 {
+			state = check.Status
+		}
+
+		s.add(CommitStatus{
+			Repo:        repo,
+			SHA:         check.HeadSHA,
+			State:       state,
+			Context:     check.Name,
+			Description: check.Output.Title,
+			TargetURL:   check.DetailsURL,
+		})
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": 1, "name": check.Name})
+
+	case parts[2] == "languages" && len(parts) == 3 && r.Method == http.MethodGet:
+		languages := s.repoMetadata(repo).Languages
+		if languages == nil {
+			languages = map[string]int{}
+		}
+		writeJSON(w, http.StatusOK, languages)
+
+	case parts[2] == "license" && len(parts) == 3 && r.Method == http.MethodGet:
+		license := s.repoMetadata(repo).License
+		if len(license) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"message": "Not Found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"license": map[string]interface{}{"spdx_id": license}})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleGitLab serves POST /api/v4/projects/:id/statuses/:sha, where
+// the status is given in the query-string
+func (s *FakeSCM) handleGitLab(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v4/projects/"), "/")
+	if len(parts) != 3 || parts[1] != "statuses" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	s.add(CommitStatus{
+		Repo:        parts[0],
+		SHA:         parts[2],
+		State:       query.Get("state"),
+		Context:     query.Get("context"),
+		Description: query.Get("description"),
+		TargetURL:   query.Get("target_url"),
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": 1})
+}
+
+// handleBitbucket serves POST
+// /2.0/repositories/:workspace/:repo/commit/:sha/statuses/build, the
+// key of the build status is recorded as its context
+func (s *FakeSCM) handleBitbucket(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/2.0/repositories/"), "/")
+	if len(parts) != 6 || parts[2] != "commit" || parts[4] != "statuses" || parts[5] != "build" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	status := struct {
+		State       string `json:"state"`
+		Key         string `json:"key"`
+		Description string `json:"description"`
+		URL         string `json:"url"`
+	}{}
+	json.NewDecoder(r.Body).Decode(&status)
+
+	s.add(CommitStatus{
+		Repo:        parts[0] + "/" + parts[1],
+		SHA:         parts[3],
+		State:       status.State,
+		Context:     status.Key,
+		Description: status.Description,
+		TargetURL:   status.URL,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"key": status.Key})
+}
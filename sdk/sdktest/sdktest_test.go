@@ -0,0 +1,197 @@
+package sdktest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-cloud/sdk"
+)
+
+func Test_FakeGateway_Functions(t *testing.T) {
+	gateway := NewFakeGateway()
+	defer gateway.Close()
+
+	body := []byte(`{"service":"alexellis-fn1","image":"fn1:latest","labels":{"com.openfaas.cloud.git-owner":"alexellis"},"secrets":["alexellis-token"]}`)
+	res, err := http.Post(gateway.URL+"system/functions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("want %d, got %d", http.StatusAccepted, res.StatusCode)
+	}
+
+	res, err = http.Get(gateway.URL + "system/functions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	functions := []Function{}
+	json.NewDecoder(res.Body).Decode(&functions)
+	res.Body.Close()
+
+	if len(functions) != 1 || functions[0].Name != "alexellis-fn1" || functions[0].AvailableReplicas != 1 {
+		t.Fatalf("want alexellis-fn1 deployed and ready, got %v", functions)
+	}
+	if len(functions[0].Secrets) != 1 || functions[0].Secrets[0] != "alexellis-token" {
+		t.Errorf("want the secrets of the function, got %v", functions[0].Secrets)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, gateway.URL+"system/functions", bytes.NewReader([]byte(`{"functionName":"alexellis-fn1"}`)))
+	if res, err = http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusAccepted {
+		t.Fatalf("want the function deleted, got %v %v", res, err)
+	}
+
+	if _, ok := gateway.Function("alexellis-fn1"); ok {
+		t.Errorf("want alexellis-fn1 removed")
+	}
+}
+
+func Test_FakeGateway_Invocations(t *testing.T) {
+	gateway := NewFakeGateway()
+	defer gateway.Close()
+
+	gateway.SetResponse("list-functions", http.StatusOK, `[]`)
+
+	res, err := http.Get(gateway.URL + "function/list-functions?user=alexellis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if string(body) != "[]" {
+		t.Errorf("want the canned response, got %q", string(body))
+	}
+
+	res, err = http.Post(gateway.URL+"async-function/git-tar", "application/json", bytes.NewReader([]byte("{}")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("want %d for an async invocation, got %d", http.StatusAccepted, res.StatusCode)
+	}
+
+	invocations := gateway.Invocations("list-functions")
+	if len(invocations) != 1 || invocations[0].Query != "user=alexellis" {
+		t.Errorf("want one call to list-functions, got %v", invocations)
+	}
+	if invocations := gateway.Invocations("git-tar"); len(invocations) != 1 || !invocations[0].Async {
+		t.Errorf("want one async call to git-tar, got %v", invocations)
+	}
+}
+
+func Test_FakeBuilder(t *testing.T) {
+	builder := NewFakeBuilder("registry/alexellis-fn1:latest-abc")
+	defer builder.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, builder.URL+"build", bytes.NewReader([]byte("tar")))
+	req.Header.Set(sdk.BuildOwnerHeader, "alexellis")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := sdk.BuildResult{}
+	json.NewDecoder(res.Body).Decode(&result)
+	res.Body.Close()
+
+	if result.ImageName != "registry/alexellis-fn1:latest-abc" || result.Status != "success" {
+		t.Errorf("want a successful build, got %v", result)
+	}
+
+	builds := builder.Builds()
+	if len(builds) != 1 || string(builds[0].Context) != "tar" || builds[0].Header.Get(sdk.BuildOwnerHeader) != "alexellis" {
+		t.Errorf("want the build context recorded, got %v", builds)
+	}
+}
+
+func Test_FakeSCM_Statuses(t *testing.T) {
+	scm := NewFakeSCM()
+	defer scm.Close()
+
+	http.Post(scm.URL+"repos/alexellis/fn1/statuses/abc", "application/json", bytes.NewReader([]byte(`{"state":"success","context":"fn1"}`)))
+	http.Post(scm.URL+"api/v4/projects/1/statuses/abc?state=failed&context=stack-deploy", "", nil)
+	http.Post(scm.URL+"2.0/repositories/alexellis/fn1/commit/abc/statuses/build", "application/json", bytes.NewReader([]byte(`{"state":"INPROGRESS","key":"fn1"}`)))
+
+	statuses := scm.Statuses()
+	if len(statuses) != 3 {
+		t.Fatalf("want 3 statuses, got %v", statuses)
+	}
+	if statuses[0].Repo != "alexellis/fn1" || statuses[0].State != "success" || statuses[0].Context != "fn1" {
+		t.Errorf("want the GitHub status, got %v", statuses[0])
+	}
+	if statuses[1].Repo != "1" || statuses[1].State != "failed" || statuses[1].Context != "stack-deploy" {
+		t.Errorf("want the GitLab status, got %v", statuses[1])
+	}
+	if statuses[2].Repo != "alexellis/fn1" || statuses[2].State != "INPROGRESS" || statuses[2].Context != "fn1" {
+		t.Errorf("want the Bitbucket status, got %v", statuses[2])
+	}
+}
+
+func Test_FakeSCM_RepoMetadata(t *testing.T) {
+	scm := NewFakeSCM()
+	defer scm.Close()
+
+	scm.SetRepoMetadata("alexellis/fn1", sdk.RepoMetadata{License: "MIT", Languages: map[string]int{"Go": 100}})
+
+	res, err := http.Get(scm.URL + "repos/alexellis/fn1/languages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	languages := map[string]int{}
+	json.NewDecoder(res.Body).Decode(&languages)
+	res.Body.Close()
+
+	if languages["Go"] != 100 {
+		t.Errorf("want the languages of the repo, got %v", languages)
+	}
+
+	res, err = http.Get(scm.URL + "repos/alexellis/fn2/license")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("want a 404 for a repo without a license, got %d", res.StatusCode)
+	}
+}
+
+func Test_Sign(t *testing.T) {
+	body := Payload(GitHubPush("alexellis", "fn1", "master", "abc"))
+
+	secrets, err := WriteSecrets(map[string]string{"payload-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(secrets)
+
+	os.Setenv("secret_mount_path", secrets)
+	defer os.Unsetenv("secret_mount_path")
+
+	if err := sdk.ValidHMAC(&body, "payload-secret", Sign(body, "secret")); err != nil {
+		t.Errorf("want a valid signature, got %s", err.Error())
+	}
+}
+
+func Test_FixedClock(t *testing.T) {
+	var clock sdk.Clock = NewFixedClock(time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	clock.(*FixedClock).Add(time.Minute)
+
+	if want := time.Date(2020, 5, 1, 12, 1, 0, 0, time.UTC); !clock.Now().Equal(want) {
+		t.Errorf("want %s, got %s", want, clock.Now())
+	}
+}
+
+func Test_SequentialIDs(t *testing.T) {
+	var ids sdk.IDGenerator = &SequentialIDs{Prefix: "corr-"}
+
+	if first, second := ids.NewID(), ids.NewID(); first != "corr-1" || second != "corr-2" {
+		t.Errorf("want corr-1 then corr-2, got %s then %s", first, second)
+	}
+}
@@ -22,5 +22,6 @@ func ReadSecret(key string) (string, error) {
 		return "", fmt.Errorf("unable to read secret: %s, error: %s", readPath, readErr)
 	}
 	val := strings.TrimSpace(string(secretBytes))
+	RegisterSecret(val)
 	return val, nil
 }
@@ -0,0 +1,34 @@
+package sdk
+
+import "strings"
+
+// skipCIMarkers in a commit message skip its pipeline, as with other CI
+// systems
+var skipCIMarkers = []string{"[skip ci]", "[ci skip]"}
+
+// SkipCI is true when the message of the commit at the head of a push
+// contains [skip ci] or [ci skip], in any case
+func SkipCI(commit *PushEventCommit) bool {
+	if commit == nil {
+		return false
+	}
+
+	message := strings.ToLower(commit.Message)
+	for _, marker := range skipCIMarkers {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// HeadCommit gives the commit GitLab's push moved the branch to, from the
+// commits of the push
+func (e GitLabPushEvent) HeadCommit() *PushEventCommit {
+	for i := range e.Commits {
+		if e.Commits[i].ID == e.AfterCommitID {
+			return &e.Commits[i]
+		}
+	}
+	return nil
+}
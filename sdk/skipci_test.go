@@ -0,0 +1,42 @@
+package sdk
+
+import "testing"
+
+func Test_SkipCI(t *testing.T) {
+	cases := map[string]bool{
+		"Update README [skip ci]":                  true,
+		"[CI SKIP] Fix typo":                       true,
+		"Fix typo\n\nDocs only, [Skip CI] please.": true,
+		"Add fn2": false,
+		"skip ci": false,
+	}
+
+	for message, want := range cases {
+		if got := SkipCI(&PushEventCommit{Message: message}); got != want {
+			t.Errorf("%q: want %v, got %v", message, want, got)
+		}
+	}
+
+	if SkipCI(nil) {
+		t.Errorf("want a push without a head commit to be built")
+	}
+}
+
+func Test_GitLabPushEvent_HeadCommit(t *testing.T) {
+	event := GitLabPushEvent{
+		AfterCommitID: "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		Commits: []PushEventCommit{
+			{ID: "b6568db1bc1dcd7f8b4d5a946b0b91f9dacd7327", Message: "Add fn1"},
+			{ID: "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", Message: "Update README [skip ci]"},
+		},
+	}
+
+	head := event.HeadCommit()
+	if head == nil || head.Message != "Update README [skip ci]" {
+		t.Errorf("want the commit of after, got %v", head)
+	}
+
+	if (GitLabPushEvent{AfterCommitID: "da15608"}).HeadCommit() != nil {
+		t.Errorf("want no head commit when it is not in the push")
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"regexp"
+	"strings"
 
 	hmac "github.com/alexellis/hmac"
 )
@@ -24,8 +26,10 @@ const (
 const (
 	FunctionContext = "%s"
 	StackContext    = "stack-deploy"
-	EmptyAuthToken  = ""
-	tokenKey        = "token"
+	// VerifyContext is the post-deploy verification of a function
+	VerifyContext  = "verify: %s"
+	EmptyAuthToken = ""
+	tokenKey       = "token"
 )
 
 const authTokenPattern = "^[A-Za-z0-9-_.]*"
@@ -44,6 +48,20 @@ type Status struct {
 	CommitStatuses map[string]CommitStatus `json:"commit-statuses"`
 	EventInfo      Event                   `json:"event"`
 	AuthToken      string                  `json:"auth-token"`
+	// Deploy is set with the success status of a function
+	Deploy *DeployInfo `json:"deploy,omitempty"`
+	// Check is set by buildshiprun with the final status of a function
+	Check *CheckRunDetail `json:"check,omitempty"`
+}
+
+// DeployInfo describes the deployment of a function for pull request
+// comments
+type DeployInfo struct {
+	Image        string  `json:"image"`
+	BuildSeconds float64 `json:"buildSeconds"`
+	// Drift is set for a preview with how its configuration differs
+	// from the production function's
+	Drift *DeployDiff `json:"drift,omitempty"`
 }
 
 // BuildStatus constructs a status object from event
@@ -126,7 +144,7 @@ make sure combine_output is disabled for github-status`, token)
 func (status *Status) Report(gateway string, payloadSecret string) (string, error) {
 	body, _ := status.Marshal()
 
-	c := http.Client{}
+	c := HTTPClient()
 	bodyReader := bytes.NewBuffer(body)
 	httpReq, _ := http.NewRequest(http.MethodPost, gateway+"function/github-status", bodyReader)
 
@@ -170,3 +188,50 @@ func (status *Status) Report(gateway string, payloadSecret string) (string, erro
 func BuildFunctionContext(function string) string {
 	return fmt.Sprintf(FunctionContext, function)
 }
+
+// BuildVerifyContext build a context for the post-deploy verification
+// of a function
+func BuildVerifyContext(function string) string {
+	return fmt.Sprintf(VerifyContext, function)
+}
+
+// IsVerifyContext returns the function of a verification context
+func IsVerifyContext(context string) (string, bool) {
+	prefix := strings.TrimSuffix(VerifyContext, "%s")
+	if !strings.HasPrefix(context, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(context, prefix), true
+}
+
+// ContextName is the name a status context is reported to GitHub or
+// GitLab with, so that it can match the branch protection rules of an
+// organisation. The names are set with stack_context_name and with
+// function_context_name and verify_context_name, where %s is replaced
+// with the function's name.
+func ContextName(context string) string {
+	if context == StackContext {
+		if val := os.Getenv("stack_context_name"); len(val) > 0 {
+			return val
+		}
+		return StackContext
+	}
+
+	if function, ok := IsVerifyContext(context); ok {
+		return formatContextName(os.Getenv("verify_context_name"), "%s/verify", function)
+	}
+
+	return formatContextName(os.Getenv("function_context_name"), FunctionContext, context)
+}
+
+// formatContextName requires %s in the format, so that each function
+// has its own context
+func formatContextName(format string, fallback string, function string) string {
+	if !strings.Contains(format, "%s") {
+		if len(format) > 0 {
+			log.Printf("context name %q does not contain %%s, using %q", format, fallback)
+		}
+		format = fallback
+	}
+	return strings.Replace(format, "%s", function, -1)
+}
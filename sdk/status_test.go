@@ -0,0 +1,67 @@
+package sdk
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_IsVerifyContext(t *testing.T) {
+	function, ok := IsVerifyContext(BuildVerifyContext("fn1"))
+	if !ok || function != "fn1" {
+		t.Errorf("want fn1 from the verify context, got %q %t", function, ok)
+	}
+
+	if _, ok := IsVerifyContext(BuildFunctionContext("fn1")); ok {
+		t.Errorf("want a function context not to be a verify context")
+	}
+}
+
+func Test_ContextName_Defaults(t *testing.T) {
+	values := []struct {
+		context string
+		want    string
+	}{
+		{StackContext, "stack-deploy"},
+		{BuildFunctionContext("fn1"), "fn1"},
+		{BuildVerifyContext("fn1"), "fn1/verify"},
+	}
+
+	for _, v := range values {
+		if got := ContextName(v.context); got != v.want {
+			t.Errorf("ContextName(%q): want %q, got %q", v.context, v.want, got)
+		}
+	}
+}
+
+func Test_ContextName_Configured(t *testing.T) {
+	os.Setenv("stack_context_name", "ofc/deploy")
+	os.Setenv("function_context_name", "ofc/build (%s)")
+	os.Setenv("verify_context_name", "ofc/verify (%s)")
+	defer os.Unsetenv("stack_context_name")
+	defer os.Unsetenv("function_context_name")
+	defer os.Unsetenv("verify_context_name")
+
+	values := []struct {
+		context string
+		want    string
+	}{
+		{StackContext, "ofc/deploy"},
+		{BuildFunctionContext("fn1"), "ofc/build (fn1)"},
+		{BuildVerifyContext("fn1"), "ofc/verify (fn1)"},
+	}
+
+	for _, v := range values {
+		if got := ContextName(v.context); got != v.want {
+			t.Errorf("ContextName(%q): want %q, got %q", v.context, v.want, got)
+		}
+	}
+}
+
+func Test_ContextName_WithoutFunction(t *testing.T) {
+	os.Setenv("function_context_name", "ofc/build")
+	defer os.Unsetenv("function_context_name")
+
+	if got := ContextName(BuildFunctionContext("fn1")); got != "fn1" {
+		t.Errorf("want the default name when %%s is missing, got %q", got)
+	}
+}
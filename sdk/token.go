@@ -0,0 +1,107 @@
+package sdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// gatewayAuthBasic uses the basic-auth-user and basic-auth-password
+	// secrets, when basic_auth is enabled
+	gatewayAuthBasic = "basic"
+	// gatewayAuthToken sends a bearer token read from a mounted file
+	gatewayAuthToken = "token"
+
+	defaultGatewayTokenName = "gateway-token"
+)
+
+// AddGatewayAuth adds the credentials for the gateway selected with
+// gateway_auth to a request. The default is basic auth, see AddBasicAuth,
+// or "token" for a bearer token such as a service account token where
+// basic auth is disabled on the gateway.
+func AddGatewayAuth(req *http.Request) error {
+	switch mode := os.Getenv("gateway_auth"); mode {
+	case "", gatewayAuthBasic:
+		return AddBasicAuth(req)
+	case gatewayAuthToken:
+		return AddTokenAuth(req)
+	default:
+		return fmt.Errorf("unknown gateway_auth: %s", mode)
+	}
+}
+
+// AddTokenAuth adds a bearer token to a request. The token is read from
+// gateway_token_file, or the gateway-token secret, and read again when
+// the file changes so that a rotated token is used without a restart.
+func AddTokenAuth(req *http.Request) error {
+	token, err := gatewayToken.Read(gatewayTokenPath())
+	if err != nil {
+		return fmt.Errorf("error with AddTokenAuth %s", err.Error())
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func gatewayTokenPath() string {
+	if val := os.Getenv("gateway_token_file"); len(val) > 0 {
+		return val
+	}
+
+	secretMountPath := os.Getenv("secret_mount_path")
+	if secretMountPath == "" {
+		secretMountPath = defaultSecretMountPath
+	}
+
+	return filepath.Join(secretMountPath, defaultGatewayTokenName)
+}
+
+var gatewayToken = &tokenFile{}
+
+// tokenFile caches a token until the file's modification time changes,
+// Kubernetes replaces the file when a projected token or secret rotates
+type tokenFile struct {
+	path    string
+	modTime time.Time
+	token   string
+
+	mutex sync.Mutex
+}
+
+// Read returns the token at path, only reading the file when it has
+// changed since it was last read
+func (t *tokenFile) Read(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.path == path && t.modTime.Equal(info.ModTime()) && len(t.token) > 0 {
+		return t.token, nil
+	}
+
+	tokenBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(tokenBytes))
+	if len(token) == 0 {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+
+	t.path = path
+	t.modTime = info.ModTime()
+	t.token = token
+
+	return token, nil
+}
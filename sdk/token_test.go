@@ -0,0 +1,74 @@
+package sdk
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_AddTokenAuth(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "token")
+	defer os.RemoveAll(dir)
+
+	tokenPath := filepath.Join(dir, "token")
+	ioutil.WriteFile(tokenPath, []byte("first\n"), 0600)
+
+	os.Setenv("gateway_auth", "token")
+	os.Setenv("gateway_token_file", tokenPath)
+	defer os.Unsetenv("gateway_auth")
+	defer os.Unsetenv("gateway_token_file")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://gateway:8080/system/functions", nil)
+	if err := AddGatewayAuth(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer first" {
+		t.Errorf("want Bearer first, got %q", got)
+	}
+
+	// Rotate the token, the modification time must change for it to be read
+	ioutil.WriteFile(tokenPath, []byte("second"), 0600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(tokenPath, later, later)
+
+	req, _ = http.NewRequest(http.MethodGet, "http://gateway:8080/system/functions", nil)
+	if err := AddGatewayAuth(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer second" {
+		t.Errorf("want the rotated token, got %q", got)
+	}
+}
+
+func Test_AddTokenAuth_MissingFile(t *testing.T) {
+	os.Setenv("gateway_token_file", "/does/not/exist")
+	defer os.Unsetenv("gateway_token_file")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://gateway:8080/system/functions", nil)
+	if err := AddTokenAuth(req); err == nil {
+		t.Errorf("want an error when the token file is missing")
+	}
+}
+
+func Test_AddGatewayAuth_Unknown(t *testing.T) {
+	os.Setenv("gateway_auth", "oauth")
+	defer os.Unsetenv("gateway_auth")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://gateway:8080/system/functions", nil)
+	if err := AddGatewayAuth(req); err == nil {
+		t.Errorf("want an error for an unknown gateway_auth")
+	}
+}
+
+func Test_gatewayTokenPath(t *testing.T) {
+	os.Unsetenv("gateway_token_file")
+	os.Setenv("secret_mount_path", "/etc/secrets")
+	defer os.Unsetenv("secret_mount_path")
+
+	if got := gatewayTokenPath(); got != "/etc/secrets/gateway-token" {
+		t.Errorf("want /etc/secrets/gateway-token, got %s", got)
+	}
+}